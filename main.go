@@ -1,29 +1,69 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/go-git/go-git/v5"
+	"github.com/rancher/charts-build-scripts/pkg/attestation"
+	"github.com/rancher/charts-build-scripts/pkg/backport"
+	"github.com/rancher/charts-build-scripts/pkg/bump"
+	"github.com/rancher/charts-build-scripts/pkg/cache"
 	"github.com/rancher/charts-build-scripts/pkg/charts"
+	"github.com/rancher/charts-build-scripts/pkg/credentials"
+	"github.com/rancher/charts-build-scripts/pkg/dryrun"
+	"github.com/rancher/charts-build-scripts/pkg/events"
 	"github.com/rancher/charts-build-scripts/pkg/filesystem"
 	"github.com/rancher/charts-build-scripts/pkg/helm"
+	"github.com/rancher/charts-build-scripts/pkg/hotfix"
+	"github.com/rancher/charts-build-scripts/pkg/journal"
+	"github.com/rancher/charts-build-scripts/pkg/lifecycle"
+	"github.com/rancher/charts-build-scripts/pkg/network"
 	"github.com/rancher/charts-build-scripts/pkg/options"
 	"github.com/rancher/charts-build-scripts/pkg/path"
+	"github.com/rancher/charts-build-scripts/pkg/preview"
+	"github.com/rancher/charts-build-scripts/pkg/puller"
+	"github.com/rancher/charts-build-scripts/pkg/ratelimit"
+	"github.com/rancher/charts-build-scripts/pkg/report"
 	"github.com/rancher/charts-build-scripts/pkg/repository"
+	"github.com/rancher/charts-build-scripts/pkg/reproducible"
+	"github.com/rancher/charts-build-scripts/pkg/retry"
 	"github.com/rancher/charts-build-scripts/pkg/sync"
+	"github.com/rancher/charts-build-scripts/pkg/transient"
 	"github.com/rancher/charts-build-scripts/pkg/update"
+	"github.com/rancher/charts-build-scripts/pkg/upstream"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 	"gopkg.in/yaml.v2"
 )
 
 const (
+	// DefaultSupportBundleFile is the default path to write a support bundle to
+	DefaultSupportBundleFile = "support-bundle.tar.gz"
+	// DefaultImageListFile is the default path to write the list of images referenced by the charts to
+	DefaultImageListFile = "images.txt"
+	// DefaultValuesDefaultsDir is the default directory to write the default values of each chart version to
+	DefaultValuesDefaultsDir = "values-defaults"
+	// DefaultSupportBundleRuns is the default number of recent runs included in a support bundle
+	DefaultSupportBundleRuns = 5
 	// DefaultChartsScriptOptionsFile is the default path to look a file containing options for the charts scripts to use for this branch
 	DefaultChartsScriptOptionsFile = "configuration.yaml"
 	// DefaultPackageEnvironmentVariable is the default environment variable for picking a specific package
 	DefaultPackageEnvironmentVariable = "PACKAGE"
+	// DefaultPackageSelectorEnvironmentVariable is the default environment variable for selecting packages by their labels
+	DefaultPackageSelectorEnvironmentVariable = "PACKAGE_SELECTOR"
+	// DefaultPreviewPort is the default port to serve the catalog preview on
+	DefaultPreviewPort = 8080
+	// DefaultStaleAfterDays is the default number of days without activity after which an upstream is considered stale
+	DefaultStaleAfterDays = 365
+	// DefaultExportBranch is the default branch to create on the upstream repository when exporting patches
+	DefaultExportBranch = "charts-build-scripts-patches"
 )
 
 var (
@@ -34,10 +74,88 @@ var (
 
 	// ChartsScriptOptionsFile represents a name of a file that contains options for the charts script to use for this branch
 	ChartsScriptOptionsFile string
-	// GithubToken represents the Github Auth token; currently not used
+	// GithubToken represents the Github Auth token
 	GithubToken string
+	// CredentialsProvider represents the type of provider used to get credentials for pulling upstreams
+	CredentialsProvider string
+	// CredentialsSource represents the source that the credentials provider gets credentials from
+	CredentialsSource string
 	// CurrentPackage represents the specific chart within packages/ in the source branch which is being used
 	CurrentPackage string
+	// PackageSelector represents a label selector that the packages within packages/ must match to be used
+	PackageSelector string
+	// StaleAfterDays represents the number of days without activity after which an upstream is considered stale
+	StaleAfterDays int
+	// PreviewPort represents the port to serve the catalog preview on
+	PreviewPort int
+	// ProposedCommit represents a new upstream commit being proposed for a package
+	ProposedCommit string
+	// BackportCommit represents a commit on the current branch whose changes to packages are back-ported to older release branches
+	BackportCommit string
+	// WriteUpdates indicates that the package.yaml of each package should be rewritten to pin the newer versions of its upstreams
+	WriteUpdates bool
+	// ExportBranch represents the branch to create on the upstream repository when exporting patches
+	ExportBranch string
+	// CABundle represents a path to a PEM-encoded bundle of additional CA certificates to trust when pulling upstreams over HTTPS
+	CABundle string
+	// EnableCache indicates that upstreams should be cached across runs
+	EnableCache bool
+	// CacheDir represents the directory where upstreams are cached across runs
+	CacheDir string
+	// CacheMaxSize represents the maximum total size of the upstream cache, e.g. 10GiB
+	CacheMaxSize string
+	// CacheMaxAge represents the maximum duration an upstream is kept in the cache after it was last used
+	CacheMaxAge time.Duration
+	// DisableJournal indicates that runs should not be recorded in the journal
+	DisableJournal bool
+	// JournalDir represents the directory where the inputs, outcomes, and logs of each run are recorded
+	JournalDir string
+	// SupportBundleFile represents a path to write a support bundle to
+	SupportBundleFile string
+	// SupportBundleRuns represents the number of recent runs included in a support bundle
+	SupportBundleRuns int
+	// ArchiveReason represents the reason a package is being archived
+	ArchiveReason string
+	// ImageListFile represents a path to write the list of images referenced by the charts to
+	ImageListFile string
+	// RebuildRef represents the git reference that a package is rebuilt as of
+	RebuildRef string
+	// RebuildOutputDir represents a directory to write the rebuilt assets and charts of a package to
+	RebuildOutputDir string
+	// BumpBaseRef represents the git reference of the branch that the current branch is based on, whose committed chart versions are released
+	BumpBaseRef string
+	// ValuesDefaultsDir represents a directory to write the default values of each chart version to
+	ValuesDefaultsDir string
+	// Release represents the name of the release whose assets are being attested
+	Release string
+	// SigningKeyFile represents a path to the PEM-encoded ed25519 private key used to sign attestations
+	SigningKeyFile string
+	// ThreeWayMerge indicates that local changes should be merged onto the current upstream before generating a patch
+	ThreeWayMerge bool
+	// EventsWebhook represents a URL that structured events are posted to during a run
+	EventsWebhook string
+	// ReportFile represents a path to write an HTML report on the chart versions produced by a run
+	ReportFile string
+	// MaxAssetGrowth represents the maximum percentage by which the asset of a chart version may grow relative to the previous version of the chart
+	MaxAssetGrowth float64
+	// DryRun indicates that the command should run against a copy of the repository and only report the files it would change
+	DryRun bool
+	// Workers represents the number of packages that are processed concurrently
+	Workers int
+	// Incremental indicates that packages whose inputs and generated charts have not changed since their charts were last generated should be skipped
+	Incremental bool
+	// HotfixChart represents the chart whose released version is being hotfixed
+	HotfixChart string
+	// HotfixVersion represents the released chart version being hotfixed
+	HotfixVersion string
+	// HotfixPatchFile represents a path to a patch applied to the released chart version being hotfixed
+	HotfixPatchFile string
+	// HotfixValuesFile represents a path to a YAML file merged into the values.yaml of the released chart version being hotfixed
+	HotfixValuesFile string
+	// CleanTransient indicates that only the transient artifacts left behind by previous runs should be cleaned up
+	CleanTransient bool
+	// AuditReportFile represents a path to write the audit of released chart versions to, or an empty string to write it to stdout
+	AuditReportFile string
 )
 
 func main() {
@@ -53,63 +171,620 @@ func main() {
 			Destination: &ChartsScriptOptionsFile,
 			Value:       DefaultChartsScriptOptionsFile,
 		},
+		cli.StringFlag{
+			Name:        "credentials-provider",
+			Usage:       "A provider to get credentials for pulling upstreams from: env, file, exec, or http",
+			EnvVar:      "CHARTS_CREDENTIALS_PROVIDER",
+			Destination: &CredentialsProvider,
+		},
+		cli.StringFlag{
+			Name:        "credentials-source",
+			Usage:       "The source for the credentials provider: an environment variable prefix (env), a path to a YAML file (file), a helper command (exec), or a URL (http)",
+			EnvVar:      "CHARTS_CREDENTIALS_SOURCE",
+			Destination: &CredentialsSource,
+		},
+		cli.BoolFlag{
+			Name:        "strict-config",
+			Usage:       "Fail if a package.yaml or dependency.yaml contains unknown fields or duplicate keys instead of silently ignoring them",
+			EnvVar:      "CHARTS_STRICT_CONFIG",
+			Destination: &options.StrictDecoding,
+		},
+		cli.StringFlag{
+			Name:        "events-webhook",
+			Usage:       "A URL to post structured events to as JSON when packages are started, succeed, or fail, assets are produced, and validations find problems",
+			EnvVar:      "CHARTS_EVENTS_WEBHOOK",
+			Destination: &EventsWebhook,
+		},
+		cli.BoolFlag{
+			Name:        "ssh-fallback",
+			Usage:       "Retry cloning Github repositories over SSH if cloning over HTTPS fails with an authentication error",
+			EnvVar:      "CHARTS_SSH_FALLBACK",
+			Destination: &puller.EnableSSHFallback,
+		},
+		cli.IntFlag{
+			Name:        "retries",
+			Usage:       "The number of times to retry cloning a Git repository or downloading an archive after a transient failure",
+			EnvVar:      "CHARTS_RETRIES",
+			Value:       retry.Retries,
+			Destination: &retry.Retries,
+		},
+		cli.DurationFlag{
+			Name:        "retry-backoff",
+			Usage:       "How long to wait before the first retry of a network operation, which doubles on every subsequent retry up to 30s",
+			EnvVar:      "CHARTS_RETRY_BACKOFF",
+			Value:       retry.Backoff,
+			Destination: &retry.Backoff,
+		},
+		cli.IntFlag{
+			Name:        "max-fetches-per-host",
+			Usage:       "The maximum number of Git clones and downloads from a single host that may be in progress at once, or 0 for no limit",
+			EnvVar:      "CHARTS_MAX_FETCHES_PER_HOST",
+			Value:       ratelimit.MaxConcurrentPerHost,
+			Destination: &ratelimit.MaxConcurrentPerHost,
+		},
+		cli.DurationFlag{
+			Name:        "min-fetch-interval-per-host",
+			Usage:       "The minimum duration between starting two Git clones or downloads from a single host (e.g. 500ms)",
+			EnvVar:      "CHARTS_MIN_FETCH_INTERVAL_PER_HOST",
+			Destination: &ratelimit.MinIntervalPerHost,
+		},
+		cli.StringFlag{
+			Name:        "ca-bundle",
+			Usage:       "A PEM-encoded bundle of CA certificates to trust in addition to the system CAs when downloading archives and cloning over HTTPS, e.g. for a proxy that intercepts TLS",
+			EnvVar:      "CHARTS_CA_BUNDLE",
+			TakesFile:   true,
+			Destination: &CABundle,
+		},
+		cli.BoolFlag{
+			Name:        "cache",
+			Usage:       "Reuse Git repositories pinned to a commit and archives pinned to a checksum across runs instead of always pulling upstreams",
+			EnvVar:      "CHARTS_CACHE",
+			Destination: &EnableCache,
+		},
+		cli.StringFlag{
+			Name:        "cache-dir",
+			Usage:       "The directory where upstreams are cached across runs, which also enables the cache (default: charts-build-scripts within the user cache directory)",
+			EnvVar:      "CHARTS_CACHE_DIR",
+			Destination: &CacheDir,
+		},
+		cli.StringFlag{
+			Name:        "cache-max-size",
+			Usage:       "The maximum total size of the upstream cache (e.g. 10GiB); the least recently used upstreams are evicted beyond it",
+			EnvVar:      "CHARTS_CACHE_MAX_SIZE",
+			Destination: &CacheMaxSize,
+		},
+		cli.DurationFlag{
+			Name:        "cache-max-age",
+			Usage:       "The maximum duration an upstream is kept in the cache after it was last used (e.g. 168h)",
+			EnvVar:      "CHARTS_CACHE_MAX_AGE",
+			Destination: &CacheMaxAge,
+		},
+		cli.BoolFlag{
+			Name:        "disable-journal",
+			Usage:       "Do not record the inputs, outcomes, and logs of this run in the journal",
+			EnvVar:      "CHARTS_DISABLE_JOURNAL",
+			Destination: &DisableJournal,
+		},
+		cli.StringFlag{
+			Name:        "journal-dir",
+			Usage:       "The directory where the inputs, outcomes, and logs of each run are recorded (default: charts-build-scripts-journal within the user cache directory)",
+			EnvVar:      "CHARTS_JOURNAL_DIR",
+			Destination: &JournalDir,
+		},
+		cli.BoolFlag{
+			Name:        "dry-run",
+			Usage:       "Run the command against a temporary copy of the repository and print the files it would create, modify, and delete instead of writing to the repository",
+			EnvVar:      "CHARTS_DRY_RUN",
+			Destination: &DryRun,
+		},
+	}
+	app.Before = func(c *cli.Context) error {
+		configureJournal(c)
+		if err := configureDryRun(); err != nil {
+			return err
+		}
+		if err := configureTransientArtifacts(c); err != nil {
+			return err
+		}
+		if err := network.ConfigureDefaultTransport(CABundle); err != nil {
+			return err
+		}
+		events.Configure(EventsWebhook, c.Args().First())
+		// Runs that end with a fatal error exit without returning from the command
+		logrus.RegisterExitHandler(events.Flush)
+		if err := configureCredentials(c); err != nil {
+			return err
+		}
+		if err := configureScriptOptions(c); err != nil {
+			return err
+		}
+		return configureCache(c)
+	}
+	app.After = func(c *cli.Context) error {
+		err := pruneCache(c)
+		if DryRun {
+			if dryRunErr := dryrun.Finish(os.Stdout); err == nil {
+				err = dryRunErr
+			}
+		}
+		journal.Finish(err)
+		events.Flush()
+		return err
 	}
 	packageFlag := cli.StringFlag{
 		Name:        "package,p",
-		Usage:       "A package you would like to run prepare on",
+		Usage:       "A package you would like to run the command on, a glob matching the names of packages, e.g. 'rancher-monitoring*', or a regular expression prefixed by 'regex:'",
 		Required:    false,
 		Destination: &CurrentPackage,
 		EnvVar:      DefaultPackageEnvironmentVariable,
 	}
-	// Github Auth Token is currently not used
-	_ = cli.StringFlag{
+	selectorFlag := cli.StringFlag{
+		Name:        "selector,l",
+		Usage:       "A comma-separated list of requirements on the labels in the package.yaml of each package you would like to run the command on, e.g. 'team=monitoring,tier!=experimental'",
+		Required:    false,
+		Destination: &PackageSelector,
+		EnvVar:      DefaultPackageSelectorEnvironmentVariable,
+	}
+	reportFlag := cli.StringFlag{
+		Name:        "report",
+		Usage:       "A path to write an HTML report summarizing the diffs and image changes of each newly produced chart version",
+		Required:    false,
+		Destination: &ReportFile,
+	}
+	requirementsFlag := cli.BoolFlag{
+		Name:        "emit-requirements-yaml",
+		Usage:       "Add a requirements.yaml mirroring the dependencies in the Chart.yaml to exported charts for legacy tooling that still parses it",
+		EnvVar:      "CHARTS_EMIT_REQUIREMENTS_YAML",
+		Destination: &helm.EmitRequirementsYaml,
+	}
+	workersFlag := cli.IntFlag{
+		Name:        "workers",
+		Usage:       "The number of packages to process concurrently. Packages that are sourced from another package or that another package is sourced from are always processed one at a time",
+		EnvVar:      "CHARTS_WORKERS",
+		Value:       1,
+		Destination: &Workers,
+	}
+	imagePlatformsFlag := cli.BoolFlag{
+		Name:        "image-platforms",
+		Usage:       "Derive the catalog.cattle.io/os and catalog.cattle.io/arch annotations of charts from the platforms their images are published for, and check the annotations charts declare against them on validate",
+		EnvVar:      "CHARTS_IMAGE_PLATFORMS",
+		Destination: &helm.ImagePlatforms,
+	}
+	vendoredDependenciesFlag := cli.BoolFlag{
+		Name:        "require-vendored-dependencies",
+		Usage:       "Fail to export any chart that declares a dependency in its Chart.yaml that is not vendored within its charts/ directory",
+		EnvVar:      "CHARTS_REQUIRE_VENDORED_DEPENDENCIES",
+		Destination: &helm.RequireVendoredDependencies,
+	}
+	allowAPIVersionV1Flag := cli.BoolFlag{
+		Name:        "allow-api-version-v1",
+		Usage:       "Prepare and export charts that use apiVersion v1 as is instead of converting them to apiVersion v2",
+		EnvVar:      "CHARTS_ALLOW_API_VERSION_V1",
+		Destination: &helm.AllowAPIVersionV1,
+	}
+	valuesSchemaFlag := cli.BoolFlag{
+		Name:        "generate-values-schema",
+		Usage:       "Add a values.schema.json derived from the default values to exported charts that do not have one, merging in the schema found at schemas/<working directory>.json within the package, if any",
+		EnvVar:      "CHARTS_GENERATE_VALUES_SCHEMA",
+		Destination: &helm.GenerateValuesSchema,
+	}
+	blockAnnotationChangesFlag := cli.BoolFlag{
+		Name:        "block-annotation-changes",
+		Usage:       "Fail to export a package if any of its charts changes a critical annotation (" + strings.Join(helm.CriticalAnnotations, ", ") + ") from the version that precedes it, unless the change is listed in acknowledgedAnnotationChanges in its package.yaml. Otherwise, such changes are only logged",
+		EnvVar:      "CHARTS_BLOCK_ANNOTATION_CHANGES",
+		Destination: &helm.BlockAnnotationChanges,
+	}
+	signKeyFlag := cli.StringFlag{
+		Name:        "sign-key",
+		Usage:       "The name of the GPG key to sign exported chart archives with, which places a .prov provenance file alongside each archive in assets/. If not provided, chart archives are not signed",
+		EnvVar:      "CHARTS_SIGN_KEY",
+		Destination: &helm.ChartSigningKey,
+	}
+	signKeyringFlag := cli.StringFlag{
+		Name:        "sign-keyring",
+		Usage:       "A path to the GPG keyring that contains the key to sign exported chart archives with (default: pubring.gpg within $GNUPGHOME or ~/.gnupg)",
+		EnvVar:      "CHARTS_SIGN_KEYRING",
+		Destination: &helm.ChartSigningKeyring,
+	}
+	signPassphraseFileFlag := cli.StringFlag{
+		Name:        "sign-passphrase-file",
+		Usage:       "A path to a file that contains the passphrase of the key to sign exported chart archives with, or - to read it from stdin. If not provided and the key is encrypted, the passphrase is prompted for",
+		EnvVar:      "CHARTS_SIGN_PASSPHRASE_FILE",
+		Destination: &helm.ChartSigningPassphraseFile,
+	}
+	maxAssetGrowthFlag := cli.Float64Flag{
+		Name:        "max-asset-growth",
+		Usage:       "Fail if the asset of a chart version grew by more than this percentage relative to the previous version of the chart, unless the chart has the " + report.AssetGrowthOverrideAnnotation + " annotation. If 0, assets may grow by any amount",
+		EnvVar:      "CHARTS_MAX_ASSET_GROWTH",
+		Destination: &MaxAssetGrowth,
+	}
+	incrementalFlag := cli.BoolFlag{
+		Name:        "incremental",
+		Usage:       "Skip packages whose upstreams, package options, changes, and export settings, as well as the assets and charts generated from them, have not changed since their charts were last generated, as recorded in the " + path.PackageBuildStateFile + " of each package",
+		EnvVar:      "CHARTS_INCREMENTAL",
+		Destination: &Incremental,
+	}
+	atomicIndexFlag := cli.BoolFlag{
+		Name:        "atomic-index",
+		Usage:       "Write the index.yaml to a temporary file and rename it into place so that it is never served partially written",
+		EnvVar:      "CHARTS_ATOMIC_INDEX",
+		Destination: &helm.AtomicIndexWrite,
+	}
+	versionedIndexFlag := cli.BoolFlag{
+		Name:        "versioned-index",
+		Usage:       "Also write the index.yaml to index-<timestamp>.yaml and record its name in index-latest",
+		EnvVar:      "CHARTS_VERSIONED_INDEX",
+		Destination: &helm.VersionedIndex,
+	}
+	threeWayFlag := cli.BoolFlag{
+		Name:        "three-way",
+		Usage:       "Merge local changes onto the current upstream using the upstream pulled by the last prepare as the base, marking conflicts instead of generating a patch against a stale upstream",
+		Destination: &ThreeWayMerge,
+	}
+	githubTokenFlag := cli.StringFlag{
 		Name:        "github-auth-token,g",
 		Usage:       "Github Access Token that can be used to make requests to the Github API on your behalf",
-		Required:    true,
+		Required:    false,
 		EnvVar:      "GITHUB_AUTH_TOKEN",
 		Destination: &GithubToken,
 	}
+	portFlag := cli.IntFlag{
+		Name:        "port",
+		Usage:       "The port to serve the catalog preview on",
+		Value:       DefaultPreviewPort,
+		Destination: &PreviewPort,
+	}
+	commitFlag := cli.StringFlag{
+		Name:        "commit",
+		Usage:       "A new upstream commit being proposed for the package",
+		Required:    false,
+		Destination: &ProposedCommit,
+	}
+	backportCommitFlag := cli.StringFlag{
+		Name:        "commit",
+		Usage:       "The commit on the current branch whose changes to packages should be back-ported to the branches that the configuration.yaml lists under backport",
+		Required:    true,
+		Destination: &BackportCommit,
+	}
+	branchFlag := cli.StringFlag{
+		Name:        "branch",
+		Usage:       "The branch to create on the upstream repository to apply the patches on",
+		Value:       DefaultExportBranch,
+		Destination: &ExportBranch,
+	}
+	writeUpdatesFlag := cli.BoolFlag{
+		Name:        "write",
+		Usage:       "Rewrite the package.yaml of each package to pin the newer versions of its upstreams",
+		Destination: &WriteUpdates,
+	}
+	staleAfterDaysFlag := cli.IntFlag{
+		Name:        "stale-after-days",
+		Usage:       "The number of days without any commits or releases after which an upstream is considered stale",
+		Value:       DefaultStaleAfterDays,
+		Destination: &StaleAfterDays,
+	}
+	releaseFlag := cli.StringFlag{
+		Name:        "release",
+		Usage:       "The name of the release whose assets are being attested, e.g. v2.5.1",
+		Required:    true,
+		Destination: &Release,
+	}
+	signingKeyFlag := cli.StringFlag{
+		Name:        "signing-key",
+		Usage:       "A path to the PEM-encoded PKCS #8 ed25519 private key used to sign the attestation",
+		Required:    true,
+		EnvVar:      "CHARTS_SIGNING_KEY",
+		Destination: &SigningKeyFile,
+	}
+	supportBundleFileFlag := cli.StringFlag{
+		Name:        "output,o",
+		Usage:       "A path to write the support bundle to",
+		Value:       DefaultSupportBundleFile,
+		Destination: &SupportBundleFile,
+	}
+	supportBundleRunsFlag := cli.IntFlag{
+		Name:        "runs",
+		Usage:       "The number of recent runs to include in the support bundle",
+		Value:       DefaultSupportBundleRuns,
+		Destination: &SupportBundleRuns,
+	}
+	archiveReasonFlag := cli.StringFlag{
+		Name:        "reason",
+		Usage:       "The reason the package is being archived, which is recorded within the archived package",
+		Required:    true,
+		Destination: &ArchiveReason,
+	}
+	imageListFileFlag := cli.StringFlag{
+		Name:        "output,o",
+		Usage:       "A path to write the list of images to. If it ends with .json, the images referenced by each chart are written as JSON as well",
+		Value:       DefaultImageListFile,
+		Destination: &ImageListFile,
+	}
+	valuesDefaultsDirFlag := cli.StringFlag{
+		Name:        "output,o",
+		Usage:       "A directory to write the default values of each chart version to, at <package>/<chart>/<version>.json",
+		Value:       DefaultValuesDefaultsDir,
+		Destination: &ValuesDefaultsDir,
+	}
+	rebuildRefFlag := cli.StringFlag{
+		Name:        "as-of",
+		Usage:       "The git reference (e.g. a commit, tag, or branch) whose packages the charts are rebuilt from",
+		Required:    true,
+		Destination: &RebuildRef,
+	}
+	rebuildOutputDirFlag := cli.StringFlag{
+		Name:        "output,o",
+		Usage:       "A directory to write the rebuilt assets and charts of the package to, under assets/<package> and charts/<package>",
+		Destination: &RebuildOutputDir,
+	}
+	bumpBaseRefFlag := cli.StringFlag{
+		Name:        "base",
+		Usage:       "The git reference of the branch that the current branch is based on (e.g. origin/dev-v2.9), whose committed chart versions are released. Defaults to the remote branch tracked by the current branch",
+		EnvVar:      "CHARTS_BUMP_BASE",
+		Destination: &BumpBaseRef,
+	}
+	hotfixChartFlag := cli.StringFlag{
+		Name:        "chart",
+		Usage:       "The chart whose released version is hotfixed. Defaults to the only chart released by the package",
+		Destination: &HotfixChart,
+	}
+	hotfixVersionFlag := cli.StringFlag{
+		Name:        "version",
+		Usage:       "The released chart version to hotfix, e.g. 100.0.1+up1.2.3",
+		Required:    true,
+		Destination: &HotfixVersion,
+	}
+	hotfixPatchFlag := cli.StringFlag{
+		Name:        "patch",
+		Usage:       "A path to a unified diff to apply to the released chart, with paths rooted at the root of the chart after stripping their first component",
+		Destination: &HotfixPatchFile,
+	}
+	hotfixValuesFlag := cli.StringFlag{
+		Name:        "values",
+		Usage:       "A path to a YAML file to merge into the values.yaml of the released chart, in which a null value removes the key",
+		Destination: &HotfixValuesFile,
+	}
+	cleanTransientFlag := cli.BoolFlag{
+		Name:        "transient",
+		Usage:       "Only remove the transient artifacts, e.g. -original directories and chart.tgz files, that previous runs left behind because they crashed or were interrupted",
+		Destination: &CleanTransient,
+	}
+	auditReportFileFlag := cli.StringFlag{
+		Name:        "output,o",
+		Usage:       "A path to write the audit to instead of stdout. If it ends with .json, the audit is written as JSON",
+		Destination: &AuditReportFile,
+	}
 	app.Commands = []cli.Command{
 		{
 			Name:   "prepare",
 			Usage:  "Pull in the chart specified from upstream to the charts directory and apply any patch files",
 			Action: prepareCharts,
-			Flags:  []cli.Flag{packageFlag},
+			Flags:  []cli.Flag{packageFlag, selectorFlag, workersFlag, allowAPIVersionV1Flag},
 		},
 		{
 			Name:   "patch",
 			Usage:  "Apply a patch between the upstream chart and the current state of the chart in the charts directory",
 			Action: generatePatch,
-			Flags:  []cli.Flag{packageFlag},
+			Flags:  []cli.Flag{packageFlag, selectorFlag, workersFlag, threeWayFlag},
+		},
+		{
+			Name:   "apply-conventions",
+			Usage:  "Apply the Rancher feature chart conventions to the upstream chart of a package and record them as generated changes",
+			Action: applyConventions,
+			Flags:  []cli.Flag{packageFlag, selectorFlag},
+		},
+		{
+			Name:   "migrate-api-version",
+			Usage:  "Convert the charts of a package that use apiVersion v1 to apiVersion v2 and record the conversion as generated changes",
+			Action: migrateAPIVersion,
+			Flags:  []cli.Flag{packageFlag, selectorFlag},
 		},
 		{
 			Name:   "charts",
 			Usage:  "Create a local chart archive of your finalized chart for testing",
 			Action: generateCharts,
-			Flags:  []cli.Flag{packageFlag},
+			Flags:  []cli.Flag{packageFlag, selectorFlag, workersFlag, reportFlag, requirementsFlag, imagePlatformsFlag, vendoredDependenciesFlag, allowAPIVersionV1Flag, valuesSchemaFlag, blockAnnotationChangesFlag, signKeyFlag, signKeyringFlag, signPassphraseFileFlag, maxAssetGrowthFlag, incrementalFlag, atomicIndexFlag, versionedIndexFlag},
 		},
 		{
 			Name:   "clean",
 			Usage:  "Clean up your current repository to get it ready for a PR",
 			Action: cleanRepository,
-			Flags:  []cli.Flag{packageFlag},
+			Flags:  []cli.Flag{packageFlag, selectorFlag, cleanTransientFlag},
 		},
 		{
 			Name:   "rebase",
 			Usage:  "Provide a rebase.yaml to generate drift against your main chart",
 			Action: rebaseChart,
-			Flags:  []cli.Flag{packageFlag},
+			Flags:  []cli.Flag{packageFlag, selectorFlag},
 		},
 		{
 			Name:   "validate",
 			Usage:  "Ensure a sync will not overwrite generated assets in branches that the configuration.yaml wants you to validate against",
 			Action: validateRepo,
-			Flags:  []cli.Flag{packageFlag},
+			Flags:  []cli.Flag{packageFlag, selectorFlag, imagePlatformsFlag, vendoredDependenciesFlag, allowAPIVersionV1Flag},
 		},
 		{
 			Name:   "sync",
 			Usage:  "Pull in new generated assets from branches that the configuration.yaml has set your current branch to sync with",
 			Action: synchronizeRepo,
+			Flags:  []cli.Flag{atomicIndexFlag, versionedIndexFlag},
+		},
+		{
+			Name:   "attest",
+			Usage:  "Write a signed manifest of the chart name, version, and digest of every asset added since the last attested release",
+			Action: attestRelease,
+			Flags:  []cli.Flag{releaseFlag, signingKeyFlag},
+		},
+		{
+			Name:   "preview",
+			Usage:  "Serve the generated Helm index and assets with a web UI listing charts, versions, annotations, and validation status",
+			Action: previewCatalog,
+			Flags:  []cli.Flag{portFlag},
+		},
+		{
+			Name:   "lint-values",
+			Usage:  "Lint the values.yaml of each prepared chart against the rules configured in the configuration file",
+			Action: lintValues,
+			Flags:  []cli.Flag{packageFlag, selectorFlag},
+		},
+		{
+			Name:   "lint-templates",
+			Usage:  "Check that the templates of each prepared chart only call the template functions permitted in the configuration file",
+			Action: lintTemplates,
+			Flags:  []cli.Flag{packageFlag, selectorFlag},
+		},
+		{
+			Name:   "lint-charts",
+			Usage:  "Run helm lint against each prepared chart and every exported version of each chart, ignoring the messages suppressed in the package.yaml",
+			Action: lintCharts,
+			Flags:  []cli.Flag{packageFlag, selectorFlag},
+		},
+		{
+			Name:   "scan-secrets",
+			Usage:  "Check the manifests rendered from the default values of each chart for hardcoded credentials, failing or warning according to the policy in the configuration file",
+			Action: scanSecrets,
+			Flags:  []cli.Flag{packageFlag, selectorFlag},
+		},
+		{
+			Name:   "unit-test",
+			Usage:  "Run the unit tests in the tests directory of each package against its prepared charts",
+			Action: runUnitTests,
+			Flags:  []cli.Flag{packageFlag, selectorFlag},
+		},
+		{
+			Name:   "blast-radius",
+			Usage:  "Report which charts, branches, and dependent packages will be affected by changing a package",
+			Action: reportBlastRadius,
+			Flags:  []cli.Flag{packageFlag, commitFlag},
+		},
+		{
+			Name:   "explain",
+			Usage:  "Print the effective configuration of a package along with the configuration file, environment variables, and export settings that apply to it and the paths the scripts read from and write to for it",
+			Action: explainPackage,
+			Flags:  []cli.Flag{packageFlag, requirementsFlag, imagePlatformsFlag, vendoredDependenciesFlag, allowAPIVersionV1Flag, valuesSchemaFlag, signKeyFlag, signKeyringFlag},
+		},
+		{
+			Name:   "export-patches",
+			Usage:  "Apply the patches of a package onto a new branch of a fresh clone of its upstream repository to propose them upstream",
+			Action: exportPatches,
+			Flags:  []cli.Flag{packageFlag, branchFlag},
+		},
+		{
+			Name:   "patch-stats",
+			Usage:  "Report the number of patches, patched lines, patch age, upstream bumps, and conflicts per package",
+			Action: reportPatchStats,
+			Flags:  []cli.Flag{packageFlag, selectorFlag},
+		},
+		{
+			Name:   "values-drift",
+			Usage:  "Report the default values each package changed from its upstream, added by upstream since the previous upstream without review, or still sets after upstream removed them",
+			Action: reportValuesDrift,
+			Flags:  []cli.Flag{packageFlag, selectorFlag},
+		},
+		{
+			Name:   "asset-sizes",
+			Usage:  "Report the size of the asset of the latest version of each chart and its growth relative to the previous version of the chart",
+			Action: reportAssetSizes,
+			Flags:  []cli.Flag{maxAssetGrowthFlag},
+		},
+		{
+			Name:   "images",
+			Usage:  "List the images referenced by every version of each chart, rendered with its default values and any extra values configured under imageList in the configuration.yaml",
+			Action: listImages,
+			Flags:  []cli.Flag{imageListFileFlag},
+		},
+		{
+			Name:   "values-defaults",
+			Usage:  "Write the default values of every chart version, along with their types, descriptions, and the values.yaml that declares them, as JSON for documentation tooling",
+			Action: writeValuesDefaults,
+			Flags:  []cli.Flag{valuesDefaultsDirFlag},
+		},
+		{
+			Name:   "handoff",
+			Usage:  "Report charts whose latest versions differ across the branches that the configuration.yaml lists for handoff, grouped by owner",
+			Action: reportHandoff,
+		},
+		{
+			Name:   "backport",
+			Usage:  "Replay the changes a commit made to packages onto new branches created from each release branch that the configuration.yaml lists under backport, incrementing the packageVersion and regenerating the charts",
+			Action: backportCommit,
+			Flags:  []cli.Flag{packageFlag, backportCommitFlag},
+		},
+		{
+			Name:   "check-upstreams",
+			Usage:  "Warn about upstreams that have been archived or have had no activity within a configurable window",
+			Action: checkUpstreams,
+			Flags:  []cli.Flag{packageFlag, selectorFlag, githubTokenFlag, staleAfterDaysFlag},
+		},
+		{
+			Name:   "auto-update",
+			Usage:  "Report upstreams that have newer tags, Github releases, or Helm repository versions than the ones pinned in the package.yaml, flagging those that contain security fixes, and optionally pin them",
+			Action: autoUpdate,
+			Flags:  []cli.Flag{packageFlag, selectorFlag, githubTokenFlag, writeUpdatesFlag},
+		},
+		{
+			Name:   "bump-package-version",
+			Usage:  "Increment the packageVersion of packages whose chart versions were already released, as found in the charts committed to the current branch and in the branches that the configuration.yaml lists under validate",
+			Action: bumpPackageVersion,
+			Flags:  []cli.Flag{packageFlag, selectorFlag, bumpBaseRefFlag},
+		},
+		{
+			Name:   "verify-reproducible",
+			Usage:  "Build the charts of a package twice from scratch with an empty cache and compare the outputs byte-for-byte, reporting any sources of nondeterminism",
+			Action: verifyReproducible,
+			Flags:  []cli.Flag{packageFlag},
+		},
+		{
+			Name:   "rebuild",
+			Usage:  "Rebuild the charts of a package from the packages as of a past git reference in a temporary workspace, without touching the current checkout, and compare them byte-for-byte against the assets and charts committed at that reference",
+			Action: rebuildPackage,
+			Flags:  []cli.Flag{packageFlag, rebuildRefFlag, rebuildOutputDirFlag},
+		},
+		{
+			Name:   "migrate-generated-changes",
+			Usage:  "Move the generated changes of packages from the default directory layout into the generatedChangesLayout configured in the configuration.yaml",
+			Action: migrateGeneratedChanges,
+			Flags:  []cli.Flag{packageFlag},
+		},
+		{
+			Name:   "archive-package",
+			Usage:  "Stop building a package by moving it to archived-packages/, mark every version of its charts as deprecated in the Helm index, and record why it was archived",
+			Action: archivePackage,
+			Flags:  []cli.Flag{packageFlag, archiveReasonFlag, atomicIndexFlag, versionedIndexFlag},
+		},
+		{
+			Name:   "hotfix",
+			Usage:  "Release the next patch-level version of a released chart version with a patch or values change applied, without pulling its upstream or building its other versions, and bump the packageVersion of the package past it",
+			Action: hotfixChart,
+			Flags:  []cli.Flag{packageFlag, hotfixChartFlag, hotfixVersionFlag, hotfixPatchFlag, hotfixValuesFlag, signKeyFlag, signKeyringFlag, signPassphraseFileFlag, atomicIndexFlag, versionedIndexFlag},
+		},
+		{
+			Name:   "audit",
+			Usage:  "Run the validation and policy checks in the configuration.yaml against every asset on the current branch without rebuilding it and report the released chart versions that do not comply, grouped by owner",
+			Action: auditAssets,
+			Flags:  []cli.Flag{auditReportFileFlag},
+		},
+		{
+			Name:  "cache",
+			Usage: "Manage the cache of upstreams",
+			Subcommands: []cli.Command{
+				{
+					Name:   "stats",
+					Usage:  "Show the number of entries and size of the upstream cache",
+					Action: showCacheStats,
+				},
+				{
+					Name:   "prune",
+					Usage:  "Evict upstreams from the cache according to the configured max size and max age",
+					Action: pruneCacheEntries,
+				},
+			},
+		},
+		{
+			Name:   "support-bundle",
+			Usage:  "Create a tarball of the journal and logs of recent runs and sanitized copies of the configuration of this repository to attach to bug reports",
+			Action: createSupportBundle,
+			Flags:  []cli.Flag{supportBundleFileFlag, supportBundleRunsFlag},
 		},
 		{
 			Usage:  "Pulls in the latest docs to this repository",
@@ -123,22 +798,200 @@ func main() {
 	}
 }
 
+func configureJournal(c *cli.Context) {
+	if DisableJournal {
+		return
+	}
+	journalDir, err := getJournalDir()
+	if err != nil {
+		logrus.Warnf("Journal is disabled: %s", err)
+		return
+	}
+	repoRoot, err := os.Getwd()
+	if err != nil {
+		logrus.Warnf("Journal is disabled: unable to get current working directory: %s", err)
+		return
+	}
+	run := journal.Run{
+		Command:     c.Args().First(),
+		Args:        journal.SanitizeArgs(os.Args[1:]),
+		Version:     fmt.Sprintf("%s (%s)", Version, GitCommit),
+		Repository:  repoRoot,
+		Environment: journal.GetEnvironment(),
+	}
+	if repo, err := repository.GetRepo(repoRoot); err == nil {
+		if head, err := repository.GetHead(repo); err == nil {
+			run.Commit = head.String()
+		}
+	}
+	if err := journal.Start(journalDir, run); err != nil {
+		logrus.Warnf("Journal is disabled: %s", err)
+		return
+	}
+	// Runs that end with a fatal error exit without returning from the command
+	logrus.RegisterExitHandler(func() {
+		journal.Finish(nil)
+	})
+}
+
+func configureDryRun() error {
+	if !DryRun {
+		return nil
+	}
+	if err := dryrun.Start(); err != nil {
+		return err
+	}
+	// Runs that end with a fatal error exit without returning from the command
+	logrus.RegisterExitHandler(dryrun.Cleanup)
+	return nil
+}
+
+func configureTransientArtifacts(c *cli.Context) error {
+	if err := transient.Start(); err != nil {
+		return err
+	}
+	if c.Args().First() == "clean" {
+		// Leftover transient artifacts are reported by clean instead
+		return nil
+	}
+	removed, err := transient.Clean()
+	if err != nil {
+		return err
+	}
+	for _, artifact := range removed {
+		logrus.Warnf("Removed transient artifact %s left behind by a previous run", artifact)
+	}
+	return nil
+}
+
+func getJournalDir() (string, error) {
+	if len(JournalDir) > 0 {
+		return JournalDir, nil
+	}
+	return journal.DefaultDir()
+}
+
+func configureCredentials(c *cli.Context) error {
+	if len(CredentialsProvider) == 0 {
+		return nil
+	}
+	provider, err := credentials.NewProvider(CredentialsProvider, CredentialsSource)
+	if err != nil {
+		return err
+	}
+	credentials.SetDefaultProvider(provider)
+	return nil
+}
+
+func configureScriptOptions(c *cli.Context) error {
+	if _, err := os.Stat(ChartsScriptOptionsFile); os.IsNotExist(err) {
+		// Charts are exported without a content policy or chart aliases and with the default layouts, and the repository is not redirected from previous URLs, if there is no configuration file
+		return nil
+	}
+	return applyScriptOptions(parseScriptOptions(), ChartsScriptOptionsFile)
+}
+
+// applyScriptOptions configures how charts are built and exported from the chartsScriptOptions parsed from source
+func applyScriptOptions(chartsScriptOptions *options.ChartsScriptOptions, source string) error {
+	helm.ContentPolicy = chartsScriptOptions.ContentPolicyOptions
+	helm.ChartAliases = chartsScriptOptions.ChartAliases
+	if err := helm.ValidateLayout(chartsScriptOptions.LayoutOptions); err != nil {
+		return fmt.Errorf("Invalid layout in %s: %s", source, err)
+	}
+	helm.Layout = chartsScriptOptions.LayoutOptions
+	if err := helm.ValidatePreviousRepositoryURLs(chartsScriptOptions.HelmRepoConfiguration); err != nil {
+		return fmt.Errorf("Invalid helmRepo in %s: %s", source, err)
+	}
+	helm.RepositoryURL = helm.GetRepositoryURL(chartsScriptOptions.HelmRepoConfiguration)
+	helm.PreviousRepositoryURLs = chartsScriptOptions.HelmRepoConfiguration.PreviousURLs
+	if err := charts.ValidateGeneratedChangesLayout(chartsScriptOptions.GeneratedChangesLayoutOptions); err != nil {
+		return fmt.Errorf("Invalid generatedChangesLayout in %s: %s", source, err)
+	}
+	charts.SetGeneratedChangesLayout(chartsScriptOptions.GeneratedChangesLayoutOptions)
+	return nil
+}
+
+func configureCache(c *cli.Context) error {
+	if !EnableCache && len(CacheDir) == 0 {
+		return nil
+	}
+	cacheDir := CacheDir
+	if len(cacheDir) == 0 {
+		var err error
+		cacheDir, err = cache.DefaultDir()
+		if err != nil {
+			logrus.Warnf("Upstream cache is disabled: %s", err)
+			return nil
+		}
+	}
+	var maxSize int64
+	if len(CacheMaxSize) > 0 {
+		var err error
+		maxSize, err = cache.ParseSize(CacheMaxSize)
+		if err != nil {
+			return fmt.Errorf("Invalid cache max size %s: %s", CacheMaxSize, err)
+		}
+	}
+	cache.SetDefault(&cache.Cache{
+		Dir:     cacheDir,
+		MaxSize: maxSize,
+		MaxAge:  CacheMaxAge,
+	})
+	return nil
+}
+
+func pruneCache(c *cli.Context) error {
+	upstreamCache := cache.GetDefault()
+	if upstreamCache == nil || (upstreamCache.MaxSize == 0 && upstreamCache.MaxAge == 0) {
+		return nil
+	}
+	_, err := upstreamCache.Prune()
+	return err
+}
+
+func showCacheStats(c *cli.Context) {
+	upstreamCache := cache.GetDefault()
+	if upstreamCache == nil {
+		logrus.Fatalf("Upstream cache is disabled; enable it with --cache or --cache-dir")
+	}
+	stats, err := upstreamCache.Stats()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	fmt.Print(stats)
+}
+
+func pruneCacheEntries(c *cli.Context) {
+	upstreamCache := cache.GetDefault()
+	if upstreamCache == nil {
+		logrus.Fatalf("Upstream cache is disabled; enable it with --cache or --cache-dir")
+	}
+	if upstreamCache.MaxSize == 0 && upstreamCache.MaxAge == 0 {
+		logrus.Fatalf("Must provide a cache max size or max age to prune the cache")
+	}
+	evicted, err := upstreamCache.Prune()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	logrus.Infof("Evicted %d entries from the upstream cache", len(evicted))
+}
+
 func prepareCharts(c *cli.Context) {
 	repoRoot, err := os.Getwd()
 	if err != nil {
 		logrus.Fatalf("Unable to get current working directory: %s", err)
 	}
-	packages, err := charts.GetPackages(repoRoot, CurrentPackage)
+	packages, err := getPackages(repoRoot)
 	if err != nil {
 		logrus.Fatal(err)
 	}
 	if len(packages) == 0 {
 		logrus.Fatalf("Could not find any packages in packages/")
 	}
-	for _, p := range packages {
-		if err = p.Prepare(); err != nil {
-			logrus.Fatal(err)
-		}
+	if err := runPackages(packages, func(p *charts.Package) error {
+		return p.Prepare()
+	}); err != nil {
+		logrus.Fatal(err)
 	}
 }
 
@@ -147,26 +1000,29 @@ func generatePatch(c *cli.Context) {
 	if err != nil {
 		logrus.Fatalf("Unable to get current working directory: %s", err)
 	}
-	packages, err := charts.GetPackages(repoRoot, CurrentPackage)
+	packages, err := getPackages(repoRoot)
 	if err != nil {
 		logrus.Fatal(err)
 	}
 	if len(packages) == 0 {
 		logrus.Fatalf("Could not find any packages in packages/")
 	}
-	for _, p := range packages {
-		if err = p.GeneratePatch(); err != nil {
-			logrus.Fatal(err)
+	if err := runPackages(packages, func(p *charts.Package) error {
+		if ThreeWayMerge {
+			return p.MergePatch()
 		}
+		return p.GeneratePatch()
+	}); err != nil {
+		logrus.Fatal(err)
 	}
 }
 
-func generateCharts(c *cli.Context) {
+func applyConventions(c *cli.Context) {
 	repoRoot, err := os.Getwd()
 	if err != nil {
 		logrus.Fatalf("Unable to get current working directory: %s", err)
 	}
-	packages, err := charts.GetPackages(repoRoot, CurrentPackage)
+	packages, err := getPackages(repoRoot)
 	if err != nil {
 		logrus.Fatal(err)
 	}
@@ -174,18 +1030,18 @@ func generateCharts(c *cli.Context) {
 		logrus.Fatalf("Could not find any packages in packages/")
 	}
 	for _, p := range packages {
-		if err = p.GenerateCharts(); err != nil {
+		if err = runPackage(p, p.ApplyConventions); err != nil {
 			logrus.Fatal(err)
 		}
 	}
 }
 
-func cleanRepository(c *cli.Context) {
+func migrateAPIVersion(c *cli.Context) {
 	repoRoot, err := os.Getwd()
 	if err != nil {
 		logrus.Fatalf("Unable to get current working directory: %s", err)
 	}
-	packages, err := charts.GetPackages(repoRoot, CurrentPackage)
+	packages, err := getPackages(repoRoot)
 	if err != nil {
 		logrus.Fatal(err)
 	}
@@ -193,35 +1049,123 @@ func cleanRepository(c *cli.Context) {
 		logrus.Fatalf("Could not find any packages in packages/")
 	}
 	for _, p := range packages {
-		if err = p.Clean(); err != nil {
+		if err = runPackage(p, p.MigrateAPIVersion); err != nil {
 			logrus.Fatal(err)
 		}
 	}
 }
 
-func rebaseChart(c *cli.Context) {
+func generateCharts(c *cli.Context) {
 	repoRoot, err := os.Getwd()
 	if err != nil {
 		logrus.Fatalf("Unable to get current working directory: %s", err)
 	}
-	packages, err := charts.GetPackages(repoRoot, CurrentPackage)
+	packages, err := getPackages(repoRoot)
 	if err != nil {
 		logrus.Fatal(err)
 	}
 	if len(packages) == 0 {
 		logrus.Fatalf("Could not find any packages in packages/")
 	}
-	if len(packages) > 1 {
-		logrus.Fatalf("Can only run rebase on exactly one package")
+	rootFs := filesystem.GetFilesystem(repoRoot)
+	var previousChartVersions report.ChartVersions
+	if len(ReportFile) > 0 || MaxAssetGrowth > 0 {
+		previousChartVersions, err = report.GetChartVersions(rootFs)
+		if err != nil {
+			logrus.Fatalf("Unable to get existing chart versions for report: %s", err)
+		}
 	}
-	p := packages[0]
-	if err = p.GenerateRebasePatch(); err != nil {
+	if err := runPackages(packages, func(p *charts.Package) error {
+		if Incremental {
+			_, err := p.GenerateChartsIfChanged()
+			return err
+		}
+		return p.GenerateCharts()
+	}); err != nil {
 		logrus.Fatal(err)
 	}
+	if MaxAssetGrowth > 0 {
+		assetSizes, err := report.GetAssetSizes(rootFs, previousChartVersions)
+		if err != nil {
+			logrus.Fatalf("Unable to get asset sizes: %s", err)
+		}
+		checkAssetGrowth(assetSizes)
+	}
+	if len(ReportFile) > 0 {
+		reports, err := report.GenerateChartVersionReports(rootFs, previousChartVersions)
+		if err != nil {
+			logrus.Fatalf("Unable to generate report: %s", err)
+		}
+		var cacheStats *cache.Stats
+		if upstreamCache := cache.GetDefault(); upstreamCache != nil {
+			if _, err := upstreamCache.Prune(); err != nil {
+				logrus.Fatalf("Unable to prune upstream cache: %s", err)
+			}
+			stats, err := upstreamCache.Stats()
+			if err != nil {
+				logrus.Fatalf("Unable to get upstream cache statistics for report: %s", err)
+			}
+			cacheStats = &stats
+		}
+		if err := report.WriteHTMLReport(reports, cacheStats, ReportFile); err != nil {
+			logrus.Fatalf("Unable to write report to %s: %s", ReportFile, err)
+		}
+	}
 }
 
-func validateRepo(c *cli.Context) {
-	repoRoot, err := os.Getwd()
+func cleanRepository(c *cli.Context) {
+	removed, err := transient.Clean()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	for _, artifact := range removed {
+		logrus.Infof("Removed transient artifact %s", artifact)
+	}
+	if CleanTransient {
+		logrus.Infof("Removed %d transient artifacts", len(removed))
+		return
+	}
+	repoRoot, err := os.Getwd()
+	if err != nil {
+		logrus.Fatalf("Unable to get current working directory: %s", err)
+	}
+	packages, err := getPackages(repoRoot)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if len(packages) == 0 {
+		logrus.Fatalf("Could not find any packages in packages/")
+	}
+	for _, p := range packages {
+		if err = runPackage(p, p.Clean); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+}
+
+func rebaseChart(c *cli.Context) {
+	repoRoot, err := os.Getwd()
+	if err != nil {
+		logrus.Fatalf("Unable to get current working directory: %s", err)
+	}
+	packages, err := getPackages(repoRoot)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if len(packages) == 0 {
+		logrus.Fatalf("Could not find any packages in packages/")
+	}
+	if len(packages) > 1 {
+		logrus.Fatalf("Can only run rebase on exactly one package")
+	}
+	p := packages[0]
+	if err = p.GenerateRebasePatch(); err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+func validateRepo(c *cli.Context) {
+	repoRoot, err := os.Getwd()
 	if err != nil {
 		logrus.Fatalf("Unable to get current working directory: %s", err)
 	}
@@ -242,14 +1186,110 @@ func validateRepo(c *cli.Context) {
 		logrus.Fatalf("Current repository is not clean:\n%s", status)
 	}
 	chartsScriptOptions := parseScriptOptions()
+	packages, err := getPackages(repoRoot)
+	if err != nil {
+		logrus.Fatal(err)
+	}
 	// Validate
 	for _, compareGeneratedAssetsOptions := range chartsScriptOptions.ValidateOptions {
 		logrus.Infof("Validating against released charts in %s", compareGeneratedAssetsOptions.Branch)
-		if err := sync.ValidateRepository(wt.Filesystem, compareGeneratedAssetsOptions, CurrentPackage); err != nil {
+		if err := sync.ValidateRepository(wt.Filesystem, compareGeneratedAssetsOptions, packages); err != nil {
+			events.Emit(events.Event{Type: events.ValidationFinding, Message: fmt.Sprintf("Failed to validate against %s: %s", compareGeneratedAssetsOptions.Branch, err)})
 			logrus.Fatalf("Failed to validate against %s: %s", compareGeneratedAssetsOptions.Branch, err)
 		}
 		logrus.Infof("Successfully validated against %s!", compareGeneratedAssetsOptions.Branch)
 	}
+	monotonicityOptions := chartsScriptOptions.VersionMonotonicityOptions
+	if len(monotonicityOptions.OlderBranches) > 0 || len(monotonicityOptions.NewerBranches) > 0 {
+		regressions, err := report.GetVersionRegressions(wt.Filesystem, monotonicityOptions)
+		if err != nil {
+			logrus.Fatalf("Failed to validate chart version monotonicity: %s", err)
+		}
+		for _, regression := range regressions {
+			logrus.Error(regression)
+			events.Emit(events.Event{Type: events.ValidationFinding, Message: regression.String()})
+		}
+		if len(regressions) > 0 {
+			logrus.Fatalf("Found %d charts whose versions on a newer release line are not ahead of their versions on an older release line", len(regressions))
+		}
+		logrus.Infof("Successfully validated chart version monotonicity!")
+	}
+	if err := attestation.ValidateAttestations(wt.Filesystem, chartsScriptOptions.AttestationOptions.PublicKeys); err != nil {
+		logrus.Fatalf("Failed to validate attestations: %s", err)
+	}
+	aliasInconsistencies, err := helm.ValidateChartAliases(wt.Filesystem, chartsScriptOptions.ChartAliases)
+	if err != nil {
+		logrus.Fatalf("Failed to validate chart aliases: %s", err)
+	}
+	for _, inconsistency := range aliasInconsistencies {
+		logrus.Error(inconsistency)
+		events.Emit(events.Event{Type: events.ValidationFinding, Message: inconsistency})
+	}
+	if len(aliasInconsistencies) > 0 {
+		logrus.Fatalf("Found %d inconsistencies between the chart aliases and %s", len(aliasInconsistencies), path.RepositoryHelmIndexFile)
+	}
+	for _, p := range packages {
+		if err := p.CheckSupportTier(); err != nil {
+			events.Emit(events.Event{Type: events.ValidationFinding, Package: p.Name, Message: err.Error()})
+			logrus.Fatalf("Failed to validate support tier of package %s: %s", p.Name, err)
+		}
+	}
+	if helm.ImagePlatforms {
+		for _, p := range packages {
+			if err := p.CheckImagePlatforms(); err != nil {
+				events.Emit(events.Event{Type: events.ValidationFinding, Package: p.Name, Message: err.Error()})
+				logrus.Fatalf("Failed to validate image platforms of package %s: %s", p.Name, err)
+			}
+		}
+	}
+	if chartsScriptOptions.HelmLintOptions.Enabled {
+		for _, p := range packages {
+			if err := p.LintCharts(chartsScriptOptions.HelmLintOptions); err != nil {
+				events.Emit(events.Event{Type: events.ValidationFinding, Package: p.Name, Message: err.Error()})
+				logrus.Fatalf("Failed to lint charts of package %s: %s", p.Name, err)
+			}
+		}
+	}
+	if policy := chartsScriptOptions.SecretScanOptions.Policy; len(policy) > 0 {
+		for _, p := range packages {
+			if err := p.CheckSecrets(policy); err != nil {
+				events.Emit(events.Event{Type: events.ValidationFinding, Package: p.Name, Message: err.Error()})
+				logrus.Fatalf("Failed to validate rendered manifests of package %s for hardcoded credentials: %s", p.Name, err)
+			}
+		}
+	}
+	if len(chartsScriptOptions.ManifestValidationOptions.KubeVersions) > 0 {
+		for _, p := range packages {
+			if err := p.ValidateManifests(chartsScriptOptions.ManifestValidationOptions); err != nil {
+				events.Emit(events.Event{Type: events.ValidationFinding, Package: p.Name, Message: err.Error()})
+				logrus.Fatalf("Failed to validate rendered manifests of package %s against Kubernetes schemas: %s", p.Name, err)
+			}
+		}
+	}
+	if len(chartsScriptOptions.RenderProfiles) == 0 {
+		return
+	}
+	for _, p := range packages {
+		if err := p.RenderCharts(chartsScriptOptions.RenderProfiles); err != nil {
+			events.Emit(events.Event{Type: events.ValidationFinding, Package: p.Name, Message: err.Error()})
+			logrus.Fatalf("Failed to render package %s: %s", p.Name, err)
+		}
+	}
+	logrus.Infof("Successfully rendered all packages against %d render profiles!", len(chartsScriptOptions.RenderProfiles))
+}
+
+func attestRelease(c *cli.Context) {
+	repoRoot, err := os.Getwd()
+	if err != nil {
+		logrus.Fatalf("Unable to get current working directory: %s", err)
+	}
+	key, err := attestation.LoadSigningKey(SigningKeyFile)
+	if err != nil {
+		logrus.Fatalf("Unable to load signing key: %s", err)
+	}
+	if _, err := attestation.CreateAttestation(filesystem.GetFilesystem(repoRoot), Release, key); err != nil {
+		logrus.Fatalf("Unable to attest release %s: %s", Release, err)
+	}
 }
 
 func synchronizeRepo(c *cli.Context) {
@@ -290,7 +1330,10 @@ func synchronizeRepo(c *cli.Context) {
 	}
 	chartsIntroduced := false
 	for p, fileStatus := range status {
-		if p == path.RepositoryHelmIndexFile {
+		if p == path.RepositoryHelmIndexFile || p == path.RepositoryLatestHelmIndexPointerFile {
+			continue
+		}
+		if isVersionedIndex, _ := filepath.Match(fmt.Sprintf(path.RepositoryVersionedHelmIndexFileFormat, "*"), p); isVersionedIndex {
 			continue
 		}
 		if fileStatus.Worktree == git.Untracked && fileStatus.Staging == git.Untracked {
@@ -309,17 +1352,798 @@ func synchronizeRepo(c *cli.Context) {
 	}
 }
 
-func getDocs(c *cli.Context) {
+func previewCatalog(c *cli.Context) {
+	repoRoot, err := os.Getwd()
+	if err != nil {
+		logrus.Fatalf("Unable to get current working directory: %s", err)
+	}
+	if err := preview.Serve(filesystem.GetFilesystem(repoRoot), fmt.Sprintf(":%d", PreviewPort)); err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+func lintValues(c *cli.Context) {
 	repoRoot, err := os.Getwd()
 	if err != nil {
 		logrus.Fatalf("Unable to get current working directory: %s", err)
 	}
-	repoFs := filesystem.GetFilesystem(repoRoot)
 	chartsScriptOptions := parseScriptOptions()
-	if err := update.GetDocumentation(repoFs, *chartsScriptOptions); err != nil {
-		logrus.Fatalf("Failed to update docs: %s", err)
+	if chartsScriptOptions.ValuesLintOptions == (options.ValuesLintOptions{}) {
+		logrus.Infof("No values lint rules are enabled in %s", ChartsScriptOptionsFile)
+		return
 	}
-	logrus.Infof("Successfully pulled new updated docs into working directory.")
+	packages, err := getPackages(repoRoot)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if len(packages) == 0 {
+		logrus.Infof("No packages found.")
+		return
+	}
+	var failed bool
+	for _, p := range packages {
+		if err := p.LintValues(chartsScriptOptions.ValuesLintOptions); err != nil {
+			logrus.Error(err)
+			failed = true
+		}
+	}
+	if failed {
+		logrus.Fatal("Values lint failed")
+	}
+	logrus.Infof("Successfully linted values of all packages!")
+}
+
+func lintCharts(c *cli.Context) {
+	repoRoot, err := os.Getwd()
+	if err != nil {
+		logrus.Fatalf("Unable to get current working directory: %s", err)
+	}
+	chartsScriptOptions := parseScriptOptions()
+	packages, err := getPackages(repoRoot)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if len(packages) == 0 {
+		logrus.Infof("No packages found.")
+		return
+	}
+	var failed bool
+	for _, p := range packages {
+		if err := p.LintCharts(chartsScriptOptions.HelmLintOptions); err != nil {
+			logrus.Error(err)
+			failed = true
+		}
+	}
+	if failed {
+		logrus.Fatal("Helm lint failed")
+	}
+	logrus.Infof("Successfully linted charts of all packages!")
+}
+
+func lintTemplates(c *cli.Context) {
+	repoRoot, err := os.Getwd()
+	if err != nil {
+		logrus.Fatalf("Unable to get current working directory: %s", err)
+	}
+	chartsScriptOptions := parseScriptOptions()
+	templateFunctionOptions := chartsScriptOptions.TemplateFunctionOptions
+	if len(templateFunctionOptions.Allowed) == 0 && len(templateFunctionOptions.Forbidden) == 0 {
+		logrus.Infof("No template function policy is configured in %s", ChartsScriptOptionsFile)
+		return
+	}
+	packages, err := getPackages(repoRoot)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if len(packages) == 0 {
+		logrus.Infof("No packages found.")
+		return
+	}
+	var failed bool
+	for _, p := range packages {
+		if err := p.CheckTemplateFunctions(templateFunctionOptions); err != nil {
+			logrus.Error(err)
+			failed = true
+		}
+	}
+	if failed {
+		logrus.Fatal("Template function check failed")
+	}
+	logrus.Infof("Successfully checked template functions of all packages!")
+}
+
+func scanSecrets(c *cli.Context) {
+	repoRoot, err := os.Getwd()
+	if err != nil {
+		logrus.Fatalf("Unable to get current working directory: %s", err)
+	}
+	chartsScriptOptions := parseScriptOptions()
+	policy := chartsScriptOptions.SecretScanOptions.Policy
+	if len(policy) == 0 {
+		logrus.Infof("No secret scan policy is configured in %s", ChartsScriptOptionsFile)
+		return
+	}
+	packages, err := getPackages(repoRoot)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if len(packages) == 0 {
+		logrus.Infof("No packages found.")
+		return
+	}
+	var failed bool
+	for _, p := range packages {
+		if err := p.CheckSecrets(policy); err != nil {
+			logrus.Error(err)
+			failed = true
+		}
+	}
+	if failed {
+		logrus.Fatal("Secret scan failed")
+	}
+	logrus.Infof("Successfully scanned all packages for hardcoded credentials!")
+}
+
+func runUnitTests(c *cli.Context) {
+	repoRoot, err := os.Getwd()
+	if err != nil {
+		logrus.Fatalf("Unable to get current working directory: %s", err)
+	}
+	packages, err := getPackages(repoRoot)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if len(packages) == 0 {
+		logrus.Fatalf("Could not find any packages in packages/")
+	}
+	var numTests int
+	var failed bool
+	for _, p := range packages {
+		n, err := p.RunUnitTests()
+		numTests += n
+		if err != nil {
+			logrus.Error(err)
+			failed = true
+		}
+	}
+	if failed {
+		logrus.Fatal("Unit tests failed")
+	}
+	logrus.Infof("Successfully ran %d unit tests!", numTests)
+}
+
+func reportBlastRadius(c *cli.Context) {
+	repoRoot, err := os.Getwd()
+	if err != nil {
+		logrus.Fatalf("Unable to get current working directory: %s", err)
+	}
+	if len(CurrentPackage) == 0 {
+		logrus.Fatalf("Must provide a package to compute the blast radius of")
+	}
+	packages, err := getPackages(repoRoot)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if len(packages) == 0 {
+		logrus.Fatalf("Could not find package %s in packages/", CurrentPackage)
+	}
+	if len(packages) > 1 {
+		logrus.Fatalf("Package selection %s matches %d packages, but exactly one package must be selected", CurrentPackage, len(packages))
+	}
+	allPackages, err := charts.GetPackages(repoRoot, "")
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	var chartsScriptOptions *options.ChartsScriptOptions
+	if _, err := os.Stat(ChartsScriptOptionsFile); err == nil {
+		chartsScriptOptions = parseScriptOptions()
+	}
+	blastRadius, err := report.GetBlastRadius(filesystem.GetFilesystem(repoRoot), packages[0], allPackages, chartsScriptOptions, ProposedCommit)
+	if err != nil {
+		logrus.Fatalf("Unable to compute blast radius: %s", err)
+	}
+	fmt.Print(blastRadius)
+}
+
+func explainPackage(c *cli.Context) {
+	repoRoot, err := os.Getwd()
+	if err != nil {
+		logrus.Fatalf("Unable to get current working directory: %s", err)
+	}
+	if len(CurrentPackage) == 0 {
+		logrus.Fatalf("Must provide a package to explain")
+	}
+	packages, err := getPackages(repoRoot)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if len(packages) == 0 {
+		logrus.Fatalf("Could not find package %s in packages/", CurrentPackage)
+	}
+	if len(packages) > 1 {
+		logrus.Fatalf("Package selection %s matches %d packages, but exactly one package must be selected", CurrentPackage, len(packages))
+	}
+	explanation, err := packages[0].Explain()
+	if err != nil {
+		logrus.Fatalf("Unable to explain package %s: %s", packages[0].Name, err)
+	}
+	exportSettingsYaml, err := helm.GetExportSettings()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	var exportSettings yaml.MapSlice
+	if err := yaml.Unmarshal([]byte(exportSettingsYaml), &exportSettings); err != nil {
+		logrus.Fatal(err)
+	}
+	configuration := ChartsScriptOptionsFile
+	if _, err := os.Stat(ChartsScriptOptionsFile); os.IsNotExist(err) {
+		configuration = ""
+	}
+	explanationYaml, err := yaml.Marshal(struct {
+		Configuration      string            `yaml:"configuration"`
+		Environment        map[string]string `yaml:"environment"`
+		ExportSettings     yaml.MapSlice     `yaml:"exportSettings"`
+		charts.Explanation `yaml:",inline"`
+	}{
+		Configuration:  configuration,
+		Environment:    journal.GetEnvironment(),
+		ExportSettings: exportSettings,
+		Explanation:    *explanation,
+	})
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	// The configuration may embed credentials, e.g. within the URLs of upstreams
+	explanationYaml, err = journal.SanitizeYAML(explanationYaml)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	fmt.Print(string(explanationYaml))
+}
+
+func exportPatches(c *cli.Context) {
+	repoRoot, err := os.Getwd()
+	if err != nil {
+		logrus.Fatalf("Unable to get current working directory: %s", err)
+	}
+	if len(CurrentPackage) == 0 {
+		logrus.Fatalf("Must provide a package to export patches from")
+	}
+	packages, err := getPackages(repoRoot)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if len(packages) == 0 {
+		logrus.Fatalf("Could not find package %s in packages/", CurrentPackage)
+	}
+	if len(packages) > 1 {
+		logrus.Fatalf("Package selection %s matches %d packages, but exactly one package must be selected", CurrentPackage, len(packages))
+	}
+	if err := packages[0].ExportPatches(ExportBranch); err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+func reportPatchStats(c *cli.Context) {
+	repoRoot, err := os.Getwd()
+	if err != nil {
+		logrus.Fatalf("Unable to get current working directory: %s", err)
+	}
+	packages, err := getPackages(repoRoot)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if len(packages) == 0 {
+		logrus.Fatalf("Could not find any packages in packages/")
+	}
+	stats, err := report.GetPatchStats(repoRoot, packages)
+	if err != nil {
+		logrus.Fatalf("Unable to compute patch statistics: %s", err)
+	}
+	if err := report.WritePatchStats(os.Stdout, stats, time.Now()); err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+func reportValuesDrift(c *cli.Context) {
+	repoRoot, err := os.Getwd()
+	if err != nil {
+		logrus.Fatalf("Unable to get current working directory: %s", err)
+	}
+	packages, err := getPackages(repoRoot)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if len(packages) == 0 {
+		logrus.Fatalf("Could not find any packages in packages/")
+	}
+	drifts, err := report.GetValuesDrift(repoRoot, packages)
+	if err != nil {
+		logrus.Fatalf("Unable to compute values drift: %s", err)
+	}
+	if err := report.WriteValuesDrift(os.Stdout, drifts); err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+func reportAssetSizes(c *cli.Context) {
+	repoRoot, err := os.Getwd()
+	if err != nil {
+		logrus.Fatalf("Unable to get current working directory: %s", err)
+	}
+	assetSizes, err := report.GetAssetSizes(filesystem.GetFilesystem(repoRoot), nil)
+	if err != nil {
+		logrus.Fatalf("Unable to get asset sizes: %s", err)
+	}
+	if err := report.WriteAssetSizes(os.Stdout, assetSizes); err != nil {
+		logrus.Fatal(err)
+	}
+	if MaxAssetGrowth > 0 {
+		checkAssetGrowth(assetSizes)
+	}
+}
+
+// checkAssetGrowth exits if any of the assets grew by more than MaxAssetGrowth without an override
+func checkAssetGrowth(assetSizes []report.AssetSize) {
+	violations := report.CheckAssetGrowth(assetSizes, MaxAssetGrowth)
+	for _, violation := range violations {
+		logrus.Error(violation)
+		events.Emit(events.Event{Type: events.ValidationFinding, Message: violation})
+	}
+	if len(violations) > 0 {
+		logrus.Fatalf("Found %d chart assets that grew by more than %.1f%%", len(violations), MaxAssetGrowth)
+	}
+}
+
+func listImages(c *cli.Context) {
+	repoRoot, err := os.Getwd()
+	if err != nil {
+		logrus.Fatalf("Unable to get current working directory: %s", err)
+	}
+	chartsScriptOptions := parseScriptOptions()
+	imageList, err := report.GetImageList(filesystem.GetFilesystem(repoRoot), chartsScriptOptions.ImageListOptions)
+	if err != nil {
+		logrus.Fatalf("Unable to list images: %s", err)
+	}
+	f, err := os.Create(ImageListFile)
+	if err != nil {
+		logrus.Fatalf("Unable to create %s: %s", ImageListFile, err)
+	}
+	defer f.Close()
+	if err := report.WriteImageList(f, imageList, filepath.Ext(ImageListFile) == ".json"); err != nil {
+		logrus.Fatalf("Unable to write %s: %s", ImageListFile, err)
+	}
+	logrus.Infof("Wrote %d images referenced by %d charts to %s", len(imageList.Images), len(imageList.Charts), ImageListFile)
+}
+
+func writeValuesDefaults(c *cli.Context) {
+	repoRoot, err := os.Getwd()
+	if err != nil {
+		logrus.Fatalf("Unable to get current working directory: %s", err)
+	}
+	valuesDefaults, err := report.GetValuesDefaults(filesystem.GetFilesystem(repoRoot))
+	if err != nil {
+		logrus.Fatalf("Unable to get default values of charts: %s", err)
+	}
+	if err := report.WriteValuesDefaults(ValuesDefaultsDir, valuesDefaults); err != nil {
+		logrus.Fatalf("Unable to write default values of charts: %s", err)
+	}
+	logrus.Infof("Wrote default values of %d chart versions to %s", len(valuesDefaults), ValuesDefaultsDir)
+}
+
+func reportHandoff(c *cli.Context) {
+	repoRoot, err := os.Getwd()
+	if err != nil {
+		logrus.Fatalf("Unable to get current working directory: %s", err)
+	}
+	chartsScriptOptions := parseScriptOptions()
+	if len(chartsScriptOptions.HandoffOptions) == 0 {
+		logrus.Fatalf("No branches were provided under handoff in %s", ChartsScriptOptionsFile)
+	}
+	packages, err := charts.GetPackages(repoRoot, "")
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	handoff, err := report.GetHandoff(filesystem.GetFilesystem(repoRoot), chartsScriptOptions.HandoffOptions, packages)
+	if err != nil {
+		logrus.Fatalf("Unable to compute handoff report: %s", err)
+	}
+	if err := report.WriteHandoff(os.Stdout, handoff); err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+func backportCommit(c *cli.Context) {
+	repoRoot, err := os.Getwd()
+	if err != nil {
+		logrus.Fatalf("Unable to get current working directory: %s", err)
+	}
+	chartsScriptOptions := parseScriptOptions()
+	if len(chartsScriptOptions.BackportOptions.Branches) == 0 {
+		logrus.Fatalf("No branches were provided under backport in %s", ChartsScriptOptionsFile)
+	}
+	results, err := backport.Backport(repoRoot, BackportCommit, CurrentPackage, chartsScriptOptions.BackportOptions.Branches)
+	if err != nil {
+		logrus.Fatalf("Unable to back-port %s: %s", BackportCommit, err)
+	}
+	if err := backport.WriteResults(os.Stdout, results); err != nil {
+		logrus.Fatal(err)
+	}
+	var failed []string
+	for _, r := range results {
+		if r.Err != nil {
+			failed = append(failed, r.Branch)
+		}
+	}
+	if len(failed) > 0 {
+		logrus.Fatalf("Failed to back-port %s onto %s", BackportCommit, strings.Join(failed, ", "))
+	}
+}
+
+func checkUpstreams(c *cli.Context) {
+	repoRoot, err := os.Getwd()
+	if err != nil {
+		logrus.Fatalf("Unable to get current working directory: %s", err)
+	}
+	packages, err := getPackages(repoRoot)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if len(packages) == 0 {
+		logrus.Fatalf("Could not find any packages in packages/")
+	}
+	ctx := context.Background()
+	client := upstream.NewGithubClient(ctx, GithubToken)
+	window := time.Duration(StaleAfterDays) * 24 * time.Hour
+	statuses, err := upstream.CheckAbandonment(ctx, client, packages, window)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	abandoned := 0
+	for _, status := range statuses {
+		if status.IsAbandoned() {
+			logrus.Warn(status)
+			abandoned++
+			continue
+		}
+		logrus.Info(status)
+	}
+	if abandoned > 0 {
+		logrus.Warnf("Found %d upstreams that may no longer be maintained", abandoned)
+		return
+	}
+	logrus.Infof("All upstreams have been active within the last %d days", StaleAfterDays)
+}
+
+func autoUpdate(c *cli.Context) {
+	repoRoot, err := os.Getwd()
+	if err != nil {
+		logrus.Fatalf("Unable to get current working directory: %s", err)
+	}
+	packages, err := getPackages(repoRoot)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if len(packages) == 0 {
+		logrus.Fatalf("Could not find any packages in packages/")
+	}
+	ctx := context.Background()
+	client := upstream.NewGithubClient(ctx, GithubToken)
+	updates, err := upstream.FindUpdates(ctx, client, packages)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if len(updates) == 0 {
+		logrus.Infof("All upstreams are pinned to their latest versions")
+		return
+	}
+	rootFs := filesystem.GetFilesystem(repoRoot)
+	for _, update := range updates {
+		if len(update.SecurityFixes) > 0 {
+			logrus.Warn(update)
+			for _, securityFix := range update.SecurityFixes {
+				logrus.Warnf("  %s", securityFix)
+			}
+		} else {
+			logrus.Info(update)
+		}
+		if !WriteUpdates {
+			continue
+		}
+		if err := upstream.ApplyUpdate(rootFs, update); err != nil {
+			logrus.Fatalf("Unable to update package %s: %s", update.Package, err)
+		}
+	}
+	if WriteUpdates {
+		logrus.Infof("Updated %d upstreams. Run prepare on each updated package to check that its patches still apply", len(updates))
+		return
+	}
+	logrus.Infof("Found %d upstreams with newer versions. Run with --write to pin them", len(updates))
+}
+
+func verifyReproducible(c *cli.Context) {
+	if len(CurrentPackage) == 0 {
+		logrus.Fatalf("Must provide a package to verify")
+	}
+	repoRoot, err := os.Getwd()
+	if err != nil {
+		logrus.Fatalf("Unable to get current working directory: %s", err)
+	}
+	r, err := reproducible.VerifyPackage(repoRoot, CurrentPackage)
+	if err != nil {
+		logrus.Fatalf("Unable to verify that package %s is reproducible: %s", CurrentPackage, err)
+	}
+	if err := reproducible.WriteReport(os.Stdout, r); err != nil {
+		logrus.Fatal(err)
+	}
+	if len(r.Differences) > 0 {
+		logrus.Fatalf("Package %s is not reproducible: found %d differences between builds", CurrentPackage, len(r.Differences))
+	}
+}
+
+func rebuildPackage(c *cli.Context) {
+	if len(CurrentPackage) == 0 {
+		logrus.Fatalf("Must provide a package to rebuild")
+	}
+	repoRoot, err := os.Getwd()
+	if err != nil {
+		logrus.Fatalf("Unable to get current working directory: %s", err)
+	}
+	// The layout and policies that the outputs were exported with are those configured as of the ref
+	configYaml, err := reproducible.GetFileAsOf(repoRoot, RebuildRef, ChartsScriptOptionsFile)
+	if err != nil {
+		logrus.Fatalf("Unable to get %s as of %s: %s", ChartsScriptOptionsFile, RebuildRef, err)
+	}
+	chartsScriptOptions := options.ChartsScriptOptions{}
+	if configYaml != nil {
+		if err := options.UnmarshalStrict(ChartsScriptOptionsFile, configYaml, &chartsScriptOptions); err != nil {
+			logrus.Fatalf("Unable to unmarshall %s as of %s: %s", ChartsScriptOptionsFile, RebuildRef, err)
+		}
+	}
+	if err := applyScriptOptions(&chartsScriptOptions, fmt.Sprintf("%s as of %s", ChartsScriptOptionsFile, RebuildRef)); err != nil {
+		logrus.Fatal(err)
+	}
+	r, err := reproducible.RebuildPackageAsOf(repoRoot, RebuildRef, CurrentPackage, RebuildOutputDir)
+	if err != nil {
+		logrus.Fatalf("Unable to rebuild package %s as of %s: %s", CurrentPackage, RebuildRef, err)
+	}
+	if err := reproducible.WriteReport(os.Stdout, r); err != nil {
+		logrus.Fatal(err)
+	}
+	if len(r.Differences) > 0 {
+		logrus.Fatalf("Rebuilt package %s does not match the outputs committed as of %s: found %d differences", CurrentPackage, RebuildRef, len(r.Differences))
+	}
+}
+
+func migrateGeneratedChanges(c *cli.Context) {
+	repoRoot, err := os.Getwd()
+	if err != nil {
+		logrus.Fatalf("Unable to get current working directory: %s", err)
+	}
+	packages, err := getPackages(repoRoot)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if len(packages) == 0 {
+		logrus.Fatalf("Could not find any packages in packages/")
+	}
+	for _, p := range packages {
+		if err := p.MigrateGeneratedChanges(options.GeneratedChangesLayoutOptions{}); err != nil {
+			logrus.Fatalf("Unable to migrate generated changes of package %s: %s", p.Name, err)
+		}
+	}
+}
+
+func archivePackage(c *cli.Context) {
+	if len(CurrentPackage) == 0 {
+		logrus.Fatalf("Must provide a package to archive")
+	}
+	repoRoot, err := os.Getwd()
+	if err != nil {
+		logrus.Fatalf("Unable to get current working directory: %s", err)
+	}
+	record, err := lifecycle.ArchivePackage(repoRoot, CurrentPackage, ArchiveReason)
+	if err != nil {
+		logrus.Fatalf("Unable to archive package: %s", err)
+	}
+	for chart, versions := range record.DeprecatedVersions {
+		logrus.Infof("Deprecated %d versions of chart %s in the Helm index", len(versions), chart)
+	}
+}
+
+func hotfixChart(c *cli.Context) {
+	if len(CurrentPackage) == 0 {
+		logrus.Fatalf("Must provide a package to hotfix")
+	}
+	repoRoot, err := os.Getwd()
+	if err != nil {
+		logrus.Fatalf("Unable to get current working directory: %s", err)
+	}
+	version, err := hotfix.Hotfix(repoRoot, hotfix.Request{
+		Package:    CurrentPackage,
+		Chart:      HotfixChart,
+		Version:    HotfixVersion,
+		PatchFile:  HotfixPatchFile,
+		ValuesFile: HotfixValuesFile,
+	})
+	if err != nil {
+		logrus.Fatalf("Unable to hotfix chart version %s: %s", HotfixVersion, err)
+	}
+	logrus.Infof("Released hotfix of chart version %s as %s", HotfixVersion, version)
+}
+
+func auditAssets(c *cli.Context) {
+	repoRoot, err := os.Getwd()
+	if err != nil {
+		logrus.Fatalf("Unable to get current working directory: %s", err)
+	}
+	chartsScriptOptions := parseScriptOptions()
+	packages, err := charts.GetPackages(repoRoot, "")
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	audit, err := report.GetAudit(repoRoot, chartsScriptOptions, packages)
+	if err != nil {
+		logrus.Fatalf("Unable to audit assets: %s", err)
+	}
+	w := os.Stdout
+	if len(AuditReportFile) > 0 {
+		f, err := os.Create(AuditReportFile)
+		if err != nil {
+			logrus.Fatalf("Unable to create %s: %s", AuditReportFile, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := report.WriteAudit(w, audit, filepath.Ext(AuditReportFile) == ".json"); err != nil {
+		logrus.Fatal(err)
+	}
+	logrus.Infof("Found %d findings in %d of %d released chart versions", len(audit.Findings), audit.NonCompliantVersions(), audit.Assets)
+}
+
+func bumpPackageVersion(c *cli.Context) {
+	repoRoot, err := os.Getwd()
+	if err != nil {
+		logrus.Fatalf("Unable to get current working directory: %s", err)
+	}
+	packages, err := getPackages(repoRoot)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if len(packages) == 0 {
+		logrus.Fatalf("Could not find any packages in packages/")
+	}
+	chartsScriptOptions := parseScriptOptions()
+	released, err := bump.GetReleasedChartVersions(repoRoot, BumpBaseRef, chartsScriptOptions.ValidateOptions)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	rootFs := filesystem.GetFilesystem(repoRoot)
+	var numBumps int
+	for _, p := range packages {
+		b, err := bump.GetBump(p, released)
+		if err != nil {
+			logrus.Fatalf("Unable to determine the next packageVersion of %s: %s", p.Name, err)
+		}
+		if b == nil {
+			continue
+		}
+		logrus.Info(b)
+		if err := bump.ApplyBump(rootFs, *b); err != nil {
+			logrus.Fatalf("Unable to update package %s: %s", b.Package, err)
+		}
+		numBumps++
+	}
+	if numBumps == 0 {
+		logrus.Infof("No packages have chart versions that were already released")
+		return
+	}
+	logrus.Infof("Bumped the packageVersion of %d packages", numBumps)
+}
+
+func createSupportBundle(c *cli.Context) {
+	repoRoot, err := os.Getwd()
+	if err != nil {
+		logrus.Fatalf("Unable to get current working directory: %s", err)
+	}
+	journalDir, err := getJournalDir()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if err := journal.CreateSupportBundle(repoRoot, journalDir, ChartsScriptOptionsFile, SupportBundleFile, SupportBundleRuns); err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+func getDocs(c *cli.Context) {
+	repoRoot, err := os.Getwd()
+	if err != nil {
+		logrus.Fatalf("Unable to get current working directory: %s", err)
+	}
+	repoFs := filesystem.GetFilesystem(repoRoot)
+	chartsScriptOptions := parseScriptOptions()
+	if err := update.GetDocumentation(repoFs, *chartsScriptOptions); err != nil {
+		logrus.Fatalf("Failed to update docs: %s", err)
+	}
+	logrus.Infof("Successfully pulled new updated docs into working directory.")
+}
+
+// runPackages runs f on each package with runPackage, processing up to Workers packages concurrently
+// Packages that cannot be processed concurrently are processed one at a time once all others have been processed successfully
+func runPackages(packages []*charts.Package, f func(p *charts.Package) error) error {
+	if Workers <= 1 || len(packages) <= 1 {
+		for _, p := range packages {
+			if err := runPackage(p, func() error { return f(p) }); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	independent, dependent, err := charts.SplitIndependentPackages(packages)
+	if err != nil {
+		return err
+	}
+	workers := make(chan struct{}, Workers)
+	failures := make(chan string, len(independent))
+	for _, p := range independent {
+		workers <- struct{}{}
+		go func(p *charts.Package) {
+			defer func() { <-workers }()
+			if err := runPackage(p, func() error { return f(p) }); err != nil {
+				logrus.Errorf("Encountered error while processing package %s: %s", p.Name, err)
+				failures <- p.Name
+			}
+		}(p)
+	}
+	// Wait for every worker to finish
+	for i := 0; i < cap(workers); i++ {
+		workers <- struct{}{}
+	}
+	close(failures)
+	var failed []string
+	for name := range failures {
+		failed = append(failed, name)
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("Failed to process %d packages: %s", len(failed), strings.Join(failed, ", "))
+	}
+	for _, p := range dependent {
+		if err := runPackage(p, func() error { return f(p) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runPackage runs f on the package, emitting events when it starts and when it succeeds or fails and recording its outcome in the journal
+func runPackage(p *charts.Package, f func() error) error {
+	journal.AddPackage(p.Name)
+	events.Emit(events.Event{Type: events.PackageStarted, Package: p.Name})
+	started := time.Now()
+	err := f()
+	journal.RecordStep(p.Name, started, err)
+	if err != nil {
+		events.Emit(events.Event{Type: events.PackageFailed, Package: p.Name, Message: err.Error()})
+		return err
+	}
+	events.Emit(events.Event{Type: events.PackageSucceeded, Package: p.Name})
+	return nil
+}
+
+func getPackages(repoRoot string) ([]*charts.Package, error) {
+	packages, err := charts.GetPackages(repoRoot, CurrentPackage)
+	if err != nil {
+		return nil, err
+	}
+	if len(PackageSelector) == 0 {
+		return packages, nil
+	}
+	selector, err := charts.ParseLabelSelector(PackageSelector)
+	if err != nil {
+		return nil, err
+	}
+	return charts.FilterPackages(packages, selector), nil
 }
 
 func parseScriptOptions() *options.ChartsScriptOptions {
@@ -328,7 +2152,7 @@ func parseScriptOptions() *options.ChartsScriptOptions {
 		logrus.Fatalf("Unable to find configuration file: %s", err)
 	}
 	chartsScriptOptions := options.ChartsScriptOptions{}
-	if err := yaml.UnmarshalStrict(configYaml, &chartsScriptOptions); err != nil {
+	if err := options.UnmarshalStrict(ChartsScriptOptionsFile, configYaml, &chartsScriptOptions); err != nil {
 		logrus.Fatalf("Unable to unmarshall configuration file: %s", err)
 	}
 	return &chartsScriptOptions
@@ -0,0 +1,275 @@
+package attestation
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/events"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/helm"
+	"github.com/rancher/charts-build-scripts/pkg/path"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// Asset represents a chart archive shipped by a release
+type Asset struct {
+	// Name is the name of the chart
+	Name string `yaml:"name"`
+	// Version is the version of the chart
+	Version string `yaml:"version"`
+	// Digest is the sha256 digest of the chart archive, as recorded in the Helm index
+	Digest string `yaml:"digest"`
+}
+
+func (a Asset) String() string {
+	return fmt.Sprintf("%s-%s", a.Name, a.Version)
+}
+
+// Attestation represents a signed manifest of the assets added in a release
+type Attestation struct {
+	// Release is the name of the release
+	Release string `yaml:"release"`
+	// Assets are the assets added in the release
+	Assets []Asset `yaml:"assets"`
+	// PublicKey is the base64-encoded ed25519 public key that verifies the signature
+	PublicKey string `yaml:"publicKey"`
+	// Signature is the base64-encoded ed25519 signature of the release and its assets
+	Signature string `yaml:"signature"`
+}
+
+// payload returns the bytes that are signed, which cover the release and its assets
+func (a Attestation) payload() ([]byte, error) {
+	return yaml.Marshal(Attestation{Release: a.Release, Assets: a.Assets})
+}
+
+// Verify returns an error if the signature of the attestation is invalid
+func (a Attestation) Verify() error {
+	publicKey, err := base64.StdEncoding.DecodeString(a.PublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("Public key of the attestation of release %s is invalid", a.Release)
+	}
+	signature, err := base64.StdEncoding.DecodeString(a.Signature)
+	if err != nil {
+		return fmt.Errorf("Signature of the attestation of release %s is invalid: %s", a.Release, err)
+	}
+	payload, err := a.payload()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, payload, signature) {
+		return fmt.Errorf("Signature of the attestation of release %s does not match its contents", a.Release)
+	}
+	return nil
+}
+
+// LoadSigningKey returns the ed25519 private key within the PEM-encoded PKCS #8 file at keyPath
+func LoadSigningKey(keyPath string) (ed25519.PrivateKey, error) {
+	keyBytes, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(keyBytes)
+	if block == nil {
+		return nil, fmt.Errorf("Could not find a PEM-encoded key in %s", keyPath)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse key in %s: %s", keyPath, err)
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("Key in %s is not an ed25519 key", keyPath)
+	}
+	return privateKey, nil
+}
+
+// CreateAttestation signs and writes an attestation of every asset that is not listed in the attestation of an earlier release
+func CreateAttestation(rootFs billy.Filesystem, release string, key ed25519.PrivateKey) (*Attestation, error) {
+	if len(release) == 0 || strings.ContainsAny(release, `/\`) {
+		return nil, fmt.Errorf("Release name %s is invalid", release)
+	}
+	attestationPath := filepath.Join(path.RepositoryAttestationsDir, release+".yaml")
+	exists, err := filesystem.PathExists(rootFs, attestationPath)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, fmt.Errorf("Release %s has already been attested in %s", release, attestationPath)
+	}
+	attestations, err := LoadAttestations(rootFs)
+	if err != nil {
+		return nil, err
+	}
+	attested := make(map[string]bool)
+	for _, a := range attestations {
+		for _, asset := range a.Assets {
+			attested[asset.String()] = true
+		}
+	}
+	assets, err := getAssets(rootFs)
+	if err != nil {
+		return nil, err
+	}
+	a := Attestation{Release: release}
+	for _, asset := range assets {
+		if !attested[asset.String()] {
+			a.Assets = append(a.Assets, asset)
+		}
+	}
+	if len(a.Assets) == 0 {
+		return nil, fmt.Errorf("No assets have been added since the last attested release")
+	}
+	payload, err := a.payload()
+	if err != nil {
+		return nil, err
+	}
+	a.PublicKey = base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+	a.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload))
+	attestationBytes, err := yaml.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	if err := rootFs.MkdirAll(path.RepositoryAttestationsDir, 0755); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filesystem.GetAbsPath(rootFs, attestationPath), attestationBytes, 0644); err != nil {
+		return nil, err
+	}
+	logrus.Infof("Attested %d assets added in release %s in %s", len(a.Assets), release, attestationPath)
+	return &a, nil
+}
+
+// LoadAttestations returns the attestations of every release in the repository
+func LoadAttestations(rootFs billy.Filesystem) ([]Attestation, error) {
+	exists, err := filesystem.PathExists(rootFs, path.RepositoryAttestationsDir)
+	if err != nil || !exists {
+		return nil, err
+	}
+	fileInfos, err := rootFs.ReadDir(path.RepositoryAttestationsDir)
+	if err != nil {
+		return nil, err
+	}
+	var attestations []Attestation
+	for _, fileInfo := range fileInfos {
+		if fileInfo.IsDir() || filepath.Ext(fileInfo.Name()) != ".yaml" {
+			continue
+		}
+		attestationPath := filepath.Join(path.RepositoryAttestationsDir, fileInfo.Name())
+		attestationBytes, err := ioutil.ReadFile(filesystem.GetAbsPath(rootFs, attestationPath))
+		if err != nil {
+			return nil, err
+		}
+		var a Attestation
+		if err := yaml.Unmarshal(attestationBytes, &a); err != nil {
+			return nil, fmt.Errorf("Encountered error while parsing attestation %s: %s", attestationPath, err)
+		}
+		attestations = append(attestations, a)
+	}
+	return attestations, nil
+}
+
+// ValidateAttestations returns an error if any attestation is not signed by one of the trustedKeys or any attested asset has changed or been removed
+// Attestations can only be validated against trustedKeys, so an error is returned if there are attestations but no trustedKeys
+func ValidateAttestations(rootFs billy.Filesystem, trustedKeys []string) error {
+	attestations, err := LoadAttestations(rootFs)
+	if err != nil {
+		return err
+	}
+	if len(attestations) == 0 {
+		return nil
+	}
+	if len(trustedKeys) == 0 {
+		return fmt.Errorf("Found attestations for %d releases but no public keys are trusted to sign them: attestation.publicKeys must be provided in the configuration.yaml", len(attestations))
+	}
+	assets, err := getAssets(rootFs)
+	if err != nil {
+		return err
+	}
+	digests := make(map[string]string)
+	for _, asset := range assets {
+		digests[asset.String()] = asset.Digest
+	}
+	var violations int
+	for _, a := range attestations {
+		if err := a.Verify(); err != nil {
+			reportViolation(err.Error())
+			violations++
+			continue
+		}
+		if !contains(trustedKeys, a.PublicKey) {
+			reportViolation(fmt.Sprintf("Attestation of release %s is not signed by a trusted key", a.Release))
+			violations++
+			continue
+		}
+		for _, asset := range a.Assets {
+			digest, ok := digests[asset.String()]
+			if !ok {
+				reportViolation(fmt.Sprintf("Asset %s attested in release %s no longer exists", asset, a.Release))
+				violations++
+				continue
+			}
+			if digest != asset.Digest {
+				reportViolation(fmt.Sprintf("Asset %s attested in release %s has changed: expected digest %s, found %s", asset, a.Release, asset.Digest, digest))
+				violations++
+			}
+		}
+	}
+	if violations > 0 {
+		return fmt.Errorf("Found %d violations while validating the attestations of %d releases", violations, len(attestations))
+	}
+	return nil
+}
+
+// reportViolation logs the violation and emits it as a validation finding
+func reportViolation(message string) {
+	logrus.Error(message)
+	events.Emit(events.Event{Type: events.ValidationFinding, Message: message})
+}
+
+// getAssets returns every asset in the repository, sorted by name and version
+func getAssets(rootFs billy.Filesystem) ([]Asset, error) {
+	exists, err := filesystem.PathExists(rootFs, path.RepositoryAssetsDir)
+	if err != nil || !exists {
+		return nil, err
+	}
+	helmIndexFile, err := helm.IndexRepositoryAssets(rootFs)
+	if err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to index assets: %s", err)
+	}
+	var assets []Asset
+	for name, chartVersions := range helmIndexFile.Entries {
+		for _, chartVersion := range chartVersions {
+			assets = append(assets, Asset{
+				Name:    name,
+				Version: chartVersion.Version,
+				Digest:  chartVersion.Digest,
+			})
+		}
+	}
+	sort.Slice(assets, func(i, j int) bool {
+		if assets[i].Name != assets[j].Name {
+			return assets[i].Name < assets[j].Name
+		}
+		return assets[i].Version < assets[j].Version
+	})
+	return assets, nil
+}
+
+// contains returns whether s is one of the values
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,340 @@
+package backport
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rancher/charts-build-scripts/pkg/charts"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	"github.com/rancher/charts-build-scripts/pkg/path"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// branchPrefix is the prefix of the branch created for each back-port
+	branchPrefix = "backport"
+	// shortCommitLength is the number of characters of a commit hash used to identify it within branch names and commit messages
+	shortCommitLength = 8
+)
+
+// Result represents the outcome of back-porting a commit onto a release branch
+type Result struct {
+	// Branch is the release branch that the commit was replayed onto
+	Branch string
+	// BackportBranch is the branch created from Branch that contains the back-port, if it succeeded
+	BackportBranch string
+	// Packages are the packages that were back-ported
+	Packages []string
+	// Files are the files changed by the back-port, prefixed by their git status, e.g. M packages/rancher-monitoring/package.yaml
+	Files []string
+	// Skipped are the packages that do not exist on the release branch
+	Skipped []string
+	// Err is the error encountered while back-porting onto the release branch, if any
+	Err error
+}
+
+// Backport replays the changes that a commit made to packages onto each of the release branches provided
+// The packageVersion of each package is incremented and its charts are regenerated on each release branch
+// Each back-port is committed onto a new branch named backport-<commit>-<branch> that is ready to be proposed in a PR
+// If packageName is provided, only the changes to the packages that match it are back-ported
+func Backport(repoRoot, commit, packageName string, branches []string) ([]Result, error) {
+	pathToGitCmd, err := exec.LookPath("git")
+	if err != nil {
+		return nil, fmt.Errorf("Cannot back-port commits if git is not available")
+	}
+	hash, err := gitOutput(pathToGitCmd, repoRoot, "rev-parse", "--verify", commit+"^{commit}")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to resolve commit %s: %s", commit, err)
+	}
+	subject, err := gitOutput(pathToGitCmd, repoRoot, "log", "-1", "--format=%s", hash)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get message of commit %s: %s", hash, err)
+	}
+	packageNames, err := getChangedPackages(pathToGitCmd, repoRoot, hash, packageName)
+	if err != nil {
+		return nil, err
+	}
+	if len(packageNames) == 0 {
+		if len(packageName) > 0 {
+			return nil, fmt.Errorf("Commit %s does not change package %s", hash, packageName)
+		}
+		return nil, fmt.Errorf("Commit %s does not change any packages", hash)
+	}
+	for _, name := range packageNames {
+		changed, err := hasPackageOptionsChanges(pathToGitCmd, repoRoot, hash, name)
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			logrus.Warnf("Commit %s changes the package.yaml of %s, which usually differs across release branches, so those changes must be back-ported manually", shortHash(hash), name)
+		}
+	}
+	results := make([]Result, len(branches))
+	for i, branch := range branches {
+		results[i] = backportBranch(pathToGitCmd, repoRoot, hash, subject, packageNames, branch)
+		if results[i].Err != nil {
+			logrus.Errorf("Unable to back-port %s onto %s: %s", shortHash(hash), branch, results[i].Err)
+		}
+	}
+	return results, nil
+}
+
+// backportBranch replays the changes that the commit made to the packages onto a new branch created from branch
+// The new branch is only kept if the changes could be replayed and the charts could be regenerated
+func backportBranch(pathToGitCmd, repoRoot, hash, subject string, packageNames []string, branch string) Result {
+	result := Result{
+		Branch:         branch,
+		BackportBranch: fmt.Sprintf("%s-%s-%s", branchPrefix, shortHash(hash), strings.ReplaceAll(branch, "/", "-")),
+	}
+	absWorktreeDir, err := ioutil.TempDir("", "charts-build-scripts-backport-")
+	if err != nil {
+		result.Err = fmt.Errorf("Unable to create directory for worktree: %s", err)
+		return result
+	}
+	// git worktree add requires that the directory does not exist yet
+	os.RemoveAll(absWorktreeDir)
+	if _, err := gitOutput(pathToGitCmd, repoRoot, "worktree", "add", "-b", result.BackportBranch, absWorktreeDir, branch); err != nil {
+		result.Err = fmt.Errorf("Unable to create branch %s from %s: %s", result.BackportBranch, branch, err)
+		return result
+	}
+	result.Err = replay(pathToGitCmd, absWorktreeDir, hash, subject, packageNames, &result)
+	if _, err := gitOutput(pathToGitCmd, repoRoot, "worktree", "remove", "--force", absWorktreeDir); err != nil {
+		logrus.Warnf("Unable to remove worktree at %s: %s", absWorktreeDir, err)
+	}
+	if result.Err != nil {
+		if _, err := gitOutput(pathToGitCmd, repoRoot, "branch", "-D", result.BackportBranch); err != nil {
+			logrus.Warnf("Unable to delete branch %s: %s", result.BackportBranch, err)
+		}
+		result.BackportBranch = ""
+	}
+	return result
+}
+
+// replay applies the changes that the commit made to each package that exists in the worktree, increments its packageVersion, regenerates its charts, and commits the result
+func replay(pathToGitCmd, absWorktreeDir, hash, subject string, packageNames []string, result *Result) error {
+	worktreeFs := filesystem.GetFilesystem(absWorktreeDir)
+	for _, name := range packageNames {
+		packageDir := filepath.Join(path.RepositoryPackagesDir, name)
+		packageOptionsPath, aggregated, err := charts.GetPackageOptionsPath(worktreeFs, name)
+		if err != nil {
+			return err
+		}
+		exists, err := filesystem.PathExists(worktreeFs, packageOptionsPath)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			result.Skipped = append(result.Skipped, name)
+			continue
+		}
+		if aggregated {
+			logrus.Warnf("Package %s is defined in %s, so any changes to its options must be back-ported manually", name, packageOptionsPath)
+		}
+		patch, err := gitOutput(pathToGitCmd, absWorktreeDir, "diff", "--binary", hash+"^", hash, "--", packageDir, ":(exclude)"+filepath.Join(packageDir, path.PackageOptionsFile))
+		if err != nil {
+			return fmt.Errorf("Unable to get changes to %s in %s: %s", name, shortHash(hash), err)
+		}
+		if len(patch) > 0 {
+			if err := applyPatch(pathToGitCmd, absWorktreeDir, patch+"\n"); err != nil {
+				return fmt.Errorf("Unable to apply changes to %s: %s", name, err)
+			}
+		}
+		incrementPackageVersion := func(packageVersion int) int {
+			return packageVersion + 1
+		}
+		if err := charts.UpdatePackageVersion(worktreeFs, name, incrementPackageVersion); err != nil {
+			return fmt.Errorf("Unable to increment packageVersion of %s: %s", name, err)
+		}
+		packages, err := charts.GetPackages(absWorktreeDir, name)
+		if err != nil {
+			return fmt.Errorf("Unable to get package %s: %s", name, err)
+		}
+		for _, p := range packages {
+			if err := p.GenerateCharts(); err != nil {
+				return fmt.Errorf("Unable to regenerate charts of %s: %s", name, err)
+			}
+		}
+		result.Packages = append(result.Packages, name)
+	}
+	if len(result.Packages) == 0 {
+		return fmt.Errorf("None of the packages changed by %s exist on this branch", shortHash(hash))
+	}
+	if _, err := gitOutput(pathToGitCmd, absWorktreeDir, "add", "-A"); err != nil {
+		return fmt.Errorf("Unable to stage back-port: %s", err)
+	}
+	status, err := gitOutput(pathToGitCmd, absWorktreeDir, "diff", "--cached", "--name-status")
+	if err != nil {
+		return fmt.Errorf("Unable to get files changed by back-port: %s", err)
+	}
+	for _, line := range strings.Split(status, "\n") {
+		if len(line) > 0 {
+			result.Files = append(result.Files, strings.Join(strings.Fields(line), " "))
+		}
+	}
+	message := fmt.Sprintf("[%s] %s\n\nBack-port of %s to %s", result.Branch, subject, hash, result.Branch)
+	if _, err := gitOutput(pathToGitCmd, absWorktreeDir, "commit", "-q", "-m", message); err != nil {
+		return fmt.Errorf("Unable to commit back-port: %s", err)
+	}
+	return nil
+}
+
+// applyPatch applies the patch onto the worktree, falling back to a three-way merge if it does not apply cleanly
+// If the merge has conflicts, the conflicting files are returned within the error
+func applyPatch(pathToGitCmd, absWorktreeDir, patch string) error {
+	var buf bytes.Buffer
+	cmd := exec.Command(pathToGitCmd, "apply", "--3way", "--whitespace=nowarn", "-")
+	cmd.Dir = absWorktreeDir
+	cmd.Stdin = strings.NewReader(patch)
+	cmd.Stdout = &buf
+	cmd.Stderr = &buf
+	if err := cmd.Run(); err == nil {
+		return nil
+	}
+	conflicts, err := gitOutput(pathToGitCmd, absWorktreeDir, "diff", "--name-only", "--diff-filter=U")
+	if err != nil || len(conflicts) == 0 {
+		return fmt.Errorf("%s", strings.TrimSpace(buf.String()))
+	}
+	return fmt.Errorf("conflicts in %s", strings.Join(strings.Split(conflicts, "\n"), ", "))
+}
+
+// getChangedPackages returns the names of the packages changed by the commit, limited to those matching packageName if it is provided
+func getChangedPackages(pathToGitCmd, repoRoot, hash, packageName string) ([]string, error) {
+	matchesName, err := charts.GetPackageNameMatcher(packageName)
+	if err != nil {
+		return nil, err
+	}
+	changedFiles, err := gitOutput(pathToGitCmd, repoRoot, "diff-tree", "--no-commit-id", "--name-only", "-r", hash+"^", hash, "--", path.RepositoryPackagesDir)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get files changed by %s: %s", hash, err)
+	}
+	packageSet := make(map[string]bool)
+	for _, changedFile := range strings.Split(changedFiles, "\n") {
+		parts := strings.SplitN(changedFile, "/", 3)
+		if len(parts) < 3 {
+			continue
+		}
+		if !matchesName(parts[1]) {
+			continue
+		}
+		packageSet[parts[1]] = true
+	}
+	packageNames := make([]string, 0, len(packageSet))
+	for name := range packageSet {
+		packageNames = append(packageNames, name)
+	}
+	sort.Strings(packageNames)
+	return packageNames, nil
+}
+
+// hasPackageOptionsChanges returns whether the commit changed the options of the package in any way other than its packageVersion
+func hasPackageOptionsChanges(pathToGitCmd, repoRoot, hash, name string) (bool, error) {
+	var packageOptions [2]options.PackageOptions
+	for i, rev := range []string{hash + "^", hash} {
+		revPackageOptions, err := getPackageOptionsAt(pathToGitCmd, repoRoot, rev, name)
+		if err != nil {
+			return false, err
+		}
+		if revPackageOptions == nil {
+			// The package was added or removed by the commit
+			return true, nil
+		}
+		packageOptions[i] = *revPackageOptions
+		packageOptions[i].PackageVersion = 0
+	}
+	return !reflect.DeepEqual(packageOptions[0], packageOptions[1]), nil
+}
+
+// getPackageOptionsAt returns the options of the package from its package.yaml or its entry in the aggregated package options as of rev, or nil if the package does not exist at rev
+func getPackageOptionsAt(pathToGitCmd, repoRoot, rev, name string) (*options.PackageOptions, error) {
+	packageOptionsPath := filepath.Join(path.RepositoryPackagesDir, name, path.PackageOptionsFile)
+	if packageOptionsString, err := gitOutput(pathToGitCmd, repoRoot, "show", fmt.Sprintf("%s:%s", rev, packageOptionsPath)); err == nil {
+		var packageOptions options.PackageOptions
+		if err := yaml.Unmarshal([]byte(packageOptionsString), &packageOptions); err != nil {
+			return nil, fmt.Errorf("Unable to parse %s at %s: %s", packageOptionsPath, rev, err)
+		}
+		return &packageOptions, nil
+	}
+	aggregatedPackageOptionsPath := filepath.Join(path.RepositoryPackagesDir, path.AggregatedPackageOptionsFile)
+	aggregatedPackageOptionsString, err := gitOutput(pathToGitCmd, repoRoot, "show", fmt.Sprintf("%s:%s", rev, aggregatedPackageOptionsPath))
+	if err != nil {
+		return nil, nil
+	}
+	var aggregatedPackageOptions map[string]options.PackageOptions
+	if err := yaml.Unmarshal([]byte(aggregatedPackageOptionsString), &aggregatedPackageOptions); err != nil {
+		return nil, fmt.Errorf("Unable to parse %s at %s: %s", aggregatedPackageOptionsPath, rev, err)
+	}
+	packageOptions, ok := aggregatedPackageOptions[name]
+	if !ok {
+		return nil, nil
+	}
+	return &packageOptions, nil
+}
+
+// gitOutput runs the git command with the provided args within dir and returns its output without any trailing newline
+func gitOutput(pathToGitCmd, dir string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(pathToGitCmd, args...)
+	cmd.Dir = dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if stderr.Len() > 0 {
+			return "", fmt.Errorf("%s", strings.TrimSpace(stderr.String()))
+		}
+		return "", err
+	}
+	return strings.TrimRight(stdout.String(), "\n"), nil
+}
+
+// shortHash returns the abbreviated form of a commit hash
+func shortHash(hash string) string {
+	if len(hash) <= shortCommitLength {
+		return hash
+	}
+	return hash[:shortCommitLength]
+}
+
+// WriteResults writes the outcome of back-porting onto each release branch as a table followed by the files changed on each branch
+func WriteResults(w io.Writer, results []Result) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "BRANCH\tBACK-PORT BRANCH\tPACKAGES\tSKIPPED\tSTATUS")
+	for _, r := range results {
+		status := "ready for PR"
+		if r.Err != nil {
+			status = fmt.Sprintf("failed: %s", r.Err)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.Branch, orDash(r.BackportBranch), orDash(strings.Join(r.Packages, ", ")), orDash(strings.Join(r.Skipped, ", ")), status)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, r := range results {
+		if r.Err != nil || len(r.Files) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\nChanges on %s:\n", r.BackportBranch)
+		for _, f := range r.Files {
+			fmt.Fprintf(w, "  %s\n", f)
+		}
+	}
+	return nil
+}
+
+// orDash returns s or a dash if s is empty
+func orDash(s string) string {
+	if len(s) == 0 {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,277 @@
+package bump
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/rancher/charts-build-scripts/pkg/charts"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/helm"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	"github.com/rancher/charts-build-scripts/pkg/path"
+	"github.com/rancher/charts-build-scripts/pkg/puller"
+	"github.com/rancher/charts-build-scripts/pkg/report"
+	"github.com/rancher/charts-build-scripts/pkg/transient"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// packageVersionSuffixRegex matches what is appended to the version of a chart to form the version of an exported chart, e.g. 02-rc01 or 02 once the release candidate version is dropped
+	packageVersionSuffixRegex = regexp.MustCompile(`^(\d{2,})(?:-rc\d+)?$`)
+)
+
+// Bump represents a package whose packageVersion must be incremented since a chart version built from its current packageVersion was already released
+type Bump struct {
+	// Package is the name of the package
+	Package string
+	// Current is the packageVersion currently in the package.yaml
+	Current int
+	// Next is the packageVersion that comes after every released version of the charts of the package
+	Next int
+	// Released are the released chart versions, i.e. {package}/{chart}/{version}, that share the upstream version of the charts of the package
+	Released []string
+}
+
+func (b Bump) String() string {
+	return fmt.Sprintf("%s: packageVersion %d must be bumped to %d since the following chart versions were already released: %s", b.Package, b.Current, b.Next, strings.Join(b.Released, ", "))
+}
+
+// GetReleasedChartVersions returns every chart version committed to the charts directory of the branch that the current branch of the repository at repoRoot is based on
+// and found in the charts directory of each branch in validateOptions, after generating the charts of any packages within that branch
+// The branch that the current branch is based on is baseRef or, if it is not provided, the remote branch tracked by the current branch
+func GetReleasedChartVersions(repoRoot, baseRef string, validateOptions options.ValidateOptions) (report.ChartVersions, error) {
+	released, err := getCommittedChartVersions(repoRoot, baseRef)
+	if err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to get chart versions committed to %s: %s", repoRoot, err)
+	}
+	rootFs := filesystem.GetFilesystem(repoRoot)
+	if err := transient.Track(rootFs, path.ChartsRepositoryCurrentBranchDir); err != nil {
+		return nil, err
+	}
+	defer transient.Remove(rootFs, path.ChartsRepositoryCurrentBranchDir)
+	for _, compareGeneratedAssetsOptions := range validateOptions {
+		branch := compareGeneratedAssetsOptions.Branch
+		branchChartVersions, err := getBranchChartVersions(rootFs, compareGeneratedAssetsOptions)
+		if err != nil {
+			return nil, fmt.Errorf("Encountered error while trying to get chart versions in %s: %s", branch, err)
+		}
+		for chart, versions := range branchChartVersions {
+			released[chart] = append(released[chart], versions...)
+		}
+	}
+	return released, nil
+}
+
+// getCommittedChartVersions returns every chart version in the charts directory of the merge base of HEAD and baseRef
+// Chart versions that were only committed on the current branch or generated but not committed yet are not considered released,
+// so that bumping the packageVersion of a package more than once on the same branch does not skip a packageVersion
+func getCommittedChartVersions(repoRoot, baseRef string) (report.ChartVersions, error) {
+	chartVersions := make(report.ChartVersions)
+	repo, err := git.PlainOpen(repoRoot)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := getBaseCommit(repo, baseRef)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	chartsTree, err := tree.Tree(path.RepositoryChartsDir)
+	if err == object.ErrDirectoryNotFound {
+		return chartVersions, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, packageEntry := range getDirEntries(chartsTree) {
+		packageTree, err := chartsTree.Tree(packageEntry)
+		if err != nil {
+			return nil, err
+		}
+		// Chart versions are laid out within the charts directory of each package by the chart template
+		walker := object.NewTreeWalker(packageTree, true, nil)
+		for {
+			name, entry, err := walker.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				walker.Close()
+				return nil, err
+			}
+			if entry.Mode != filemode.Dir {
+				continue
+			}
+			chartName, version, ok := helm.ParseChartPath(name)
+			if !ok {
+				continue
+			}
+			chart := filepath.Join(packageEntry, chartName)
+			chartVersions[chart] = append(chartVersions[chart], version)
+		}
+		walker.Close()
+	}
+	return chartVersions, nil
+}
+
+// getBaseCommit returns the merge base of HEAD and baseRef or, if baseRef is not provided, of HEAD and the remote branch tracked by the current branch
+// If the current branch does not track a remote branch, HEAD is returned
+func getBaseCommit(repo *git.Repository, baseRef string) (*object.Commit, error) {
+	head, err := repo.Head()
+	if err != nil {
+		return nil, err
+	}
+	headCommit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+	if len(baseRef) == 0 {
+		baseRef, err = getTrackedBranch(repo, head)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(baseRef) == 0 {
+		logrus.Warnf("Treating the chart versions committed to HEAD as released since the current branch does not track a remote branch; provide the branch it is based on to bump packageVersions that were only bumped on this branch")
+		return headCommit, nil
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(baseRef))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to resolve %s: %s", baseRef, err)
+	}
+	baseCommit, err := repo.CommitObject(*hash)
+	if err != nil {
+		return nil, err
+	}
+	mergeBases, err := headCommit.MergeBase(baseCommit)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to find the merge base of HEAD and %s: %s", baseRef, err)
+	}
+	if len(mergeBases) == 0 {
+		return nil, fmt.Errorf("HEAD does not share any history with %s", baseRef)
+	}
+	return mergeBases[0], nil
+}
+
+// getTrackedBranch returns the remote branch tracked by the branch that head points to, or an empty string if it does not track one
+func getTrackedBranch(repo *git.Repository, head *plumbing.Reference) (string, error) {
+	if !head.Name().IsBranch() {
+		return "", nil
+	}
+	cfg, err := repo.Config()
+	if err != nil {
+		return "", err
+	}
+	branch, ok := cfg.Branches[head.Name().Short()]
+	if !ok || len(branch.Remote) == 0 || len(branch.Merge) == 0 {
+		return "", nil
+	}
+	if branch.Remote == "." {
+		return branch.Merge.String(), nil
+	}
+	return plumbing.NewRemoteReferenceName(branch.Remote, branch.Merge.Short()).String(), nil
+}
+
+// getDirEntries returns the names of the directories within the tree
+func getDirEntries(tree *object.Tree) []string {
+	var dirs []string
+	for _, entry := range tree.Entries {
+		if entry.Mode == filemode.Dir {
+			dirs = append(dirs, entry.Name)
+		}
+	}
+	return dirs
+}
+
+// getBranchChartVersions pulls the branch and returns every chart version in its charts directory after generating the charts of any packages within it
+func getBranchChartVersions(rootFs billy.Filesystem, compareGeneratedAssetsOptions options.CompareGeneratedAssetsOptions) (report.ChartVersions, error) {
+	branchUpstream, err := puller.GetGithubRepository(compareGeneratedAssetsOptions.UpstreamOptions, &compareGeneratedAssetsOptions.Branch)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get Github repository pointing to %s: %s", compareGeneratedAssetsOptions.Branch, err)
+	}
+	if err := filesystem.RemoveAll(rootFs, path.ChartsRepositoryCurrentBranchDir); err != nil {
+		return nil, err
+	}
+	if err := branchUpstream.Pull(rootFs, rootFs, path.ChartsRepositoryCurrentBranchDir); err != nil {
+		return nil, fmt.Errorf("Failed to pull %s: %s", compareGeneratedAssetsOptions.Branch, err)
+	}
+	branchPackages, err := charts.GetPackages(filesystem.GetAbsPath(rootFs, path.ChartsRepositoryCurrentBranchDir), "")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get packages in %s: %s", compareGeneratedAssetsOptions.Branch, err)
+	}
+	for _, p := range branchPackages {
+		if err := p.GenerateCharts(); err != nil {
+			return nil, err
+		}
+	}
+	branchFs, err := rootFs.Chroot(path.ChartsRepositoryCurrentBranchDir)
+	if err != nil {
+		return nil, err
+	}
+	return report.GetChartVersions(branchFs)
+}
+
+// GetBump returns a Bump if a chart version built from the current packageVersion of the package was already released or nil otherwise
+// The next packageVersion comes after the packageVersion of every released chart version that shares the version of one of the charts of the package
+func GetBump(p *charts.Package, released report.ChartVersions) (*Bump, error) {
+	chartVersions, err := p.GetChartVersions()
+	if err != nil {
+		return nil, err
+	}
+	latestPackageVersion := -1
+	var releasedVersions []string
+	for chartName, chartVersion := range chartVersions {
+		chart := filepath.Join(p.Name, chartName)
+		for _, version := range released[chart] {
+			if !strings.HasPrefix(version, chartVersion) {
+				continue
+			}
+			match := packageVersionSuffixRegex.FindStringSubmatch(strings.TrimPrefix(version, chartVersion))
+			if match == nil {
+				continue
+			}
+			packageVersion, err := strconv.Atoi(match[1])
+			if err != nil {
+				return nil, err
+			}
+			if packageVersion < p.PackageVersion {
+				continue
+			}
+			releasedVersions = append(releasedVersions, filepath.Join(chart, version))
+			if packageVersion > latestPackageVersion {
+				latestPackageVersion = packageVersion
+			}
+		}
+	}
+	if len(releasedVersions) == 0 {
+		logrus.Debugf("No chart versions of %s were released from packageVersion %d or later", p.Name, p.PackageVersion)
+		return nil, nil
+	}
+	sort.Strings(releasedVersions)
+	return &Bump{
+		Package:  p.Name,
+		Current:  p.PackageVersion,
+		Next:     latestPackageVersion + 1,
+		Released: releasedVersions,
+	}, nil
+}
+
+// ApplyBump sets the packageVersion of the package in the file it is defined in without reformatting the rest of the file
+func ApplyBump(rootFs billy.Filesystem, b Bump) error {
+	return charts.UpdatePackageVersion(rootFs, b.Package, func(int) int {
+		return b.Next
+	})
+}
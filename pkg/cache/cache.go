@@ -0,0 +1,342 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	units "github.com/docker/go-units"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// DefaultDirName is the name of the directory within the user's cache directory that is used by default
+	DefaultDirName = "charts-build-scripts"
+
+	// entryMetadataFile is a file within each entry that tracks the key and the last time the entry was used
+	entryMetadataFile = "entry.yaml"
+	// entryContentPath is the path within each entry that holds the contents of the upstream
+	entryContentPath = "content"
+	// tempEntryPrefix is the prefix of directories within the cache that hold entries that are still being stored
+	tempEntryPrefix = ".tmp-"
+)
+
+var (
+	defaultCache     *Cache
+	defaultCacheLock sync.RWMutex
+)
+
+// Cache represents a directory on the local filesystem where upstreams are stored across runs
+type Cache struct {
+	// Dir is the directory that holds all entries of the cache
+	Dir string
+	// MaxSize is the maximum total size in bytes of all entries. If zero, the size is unbounded
+	MaxSize int64
+	// MaxAge is the maximum duration an entry is kept after it was last used. If zero, entries never expire
+	MaxAge time.Duration
+
+	// evicted is the number of entries evicted during this run
+	evicted int
+	// evictedSize is the total size in bytes of entries evicted during this run
+	evictedSize int64
+	// hits is the number of lookups during this run that found an entry
+	hits int
+	// misses is the number of lookups during this run that had to store a new entry
+	misses int
+	// keyLocks serialize storing entries under each key so that concurrent pulls of the same upstream only populate it once
+	keyLocks map[string]*sync.Mutex
+	lock     sync.Mutex
+}
+
+// Entry represents a single upstream stored within the cache
+type Entry struct {
+	// Key uniquely identifies the upstream stored in this entry
+	Key string `yaml:"key"`
+	// LastUsed is the last time that this entry was used
+	LastUsed time.Time `yaml:"lastUsed"`
+
+	// Dir is the directory of the entry within the cache
+	Dir string `yaml:"-"`
+	// Size is the total size in bytes of the entry
+	Size int64 `yaml:"-"`
+}
+
+// Stats summarize the contents of the cache and the evictions made during this run
+type Stats struct {
+	// Dir is the directory that holds all entries of the cache
+	Dir string
+	// Entries is the number of entries in the cache
+	Entries int
+	// Size is the total size in bytes of all entries
+	Size int64
+	// MaxSize is the maximum total size in bytes of all entries, or zero if unbounded
+	MaxSize int64
+	// MaxAge is the maximum duration an entry is kept after it was last used, or zero if entries never expire
+	MaxAge time.Duration
+	// Evicted is the number of entries evicted during this run
+	Evicted int
+	// EvictedSize is the total size in bytes of entries evicted during this run
+	EvictedSize int64
+	// Hits is the number of upstreams that were found in the cache during this run
+	Hits int
+	// Misses is the number of upstreams that had to be pulled and stored in the cache during this run
+	Misses int
+}
+
+// DefaultDir returns the directory used for the cache if none is provided
+func DefaultDir() (string, error) {
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("Unable to find user cache directory: %s", err)
+	}
+	return filepath.Join(userCacheDir, DefaultDirName), nil
+}
+
+// SetDefault sets the Cache used to store upstreams
+func SetDefault(c *Cache) {
+	defaultCacheLock.Lock()
+	defer defaultCacheLock.Unlock()
+	defaultCache = c
+}
+
+// GetDefault returns the Cache used to store upstreams or nil if caching is disabled
+func GetDefault() *Cache {
+	defaultCacheLock.RLock()
+	defer defaultCacheLock.RUnlock()
+	return defaultCache
+}
+
+// Entries returns all entries in the cache ordered from least to most recently used
+func (c *Cache) Entries() ([]Entry, error) {
+	fileInfos, err := ioutil.ReadDir(c.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read cache directory %s: %s", c.Dir, err)
+	}
+	var entries []Entry
+	for _, fileInfo := range fileInfos {
+		if !fileInfo.IsDir() || strings.HasPrefix(fileInfo.Name(), tempEntryPrefix) {
+			continue
+		}
+		entryDir := filepath.Join(c.Dir, fileInfo.Name())
+		entry, err := readEntry(entryDir)
+		if err != nil {
+			logrus.Warnf("Ignoring invalid cache entry %s: %s", entryDir, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastUsed.Before(entries[j].LastUsed)
+	})
+	return entries, nil
+}
+
+// Prune evicts all entries that have not been used within MaxAge and then evicts the least recently used entries until the cache fits within MaxSize
+// It returns the entries that were evicted
+func (c *Cache) Prune() ([]Entry, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entries, err := c.Entries()
+	if err != nil {
+		return nil, err
+	}
+	var size int64
+	for _, entry := range entries {
+		size += entry.Size
+	}
+	var evicted []Entry
+	now := time.Now()
+	for _, entry := range entries {
+		expired := c.MaxAge > 0 && now.Sub(entry.LastUsed) > c.MaxAge
+		oversized := c.MaxSize > 0 && size > c.MaxSize
+		if !expired && !oversized {
+			continue
+		}
+		logrus.Infof("Evicting %s (%s) from cache", entry.Key, FormatSize(entry.Size))
+		if err := os.RemoveAll(entry.Dir); err != nil {
+			return evicted, fmt.Errorf("Unable to evict cache entry %s: %s", entry.Dir, err)
+		}
+		size -= entry.Size
+		c.evicted++
+		c.evictedSize += entry.Size
+		evicted = append(evicted, entry)
+	}
+	return evicted, nil
+}
+
+// Stats returns statistics on the contents of the cache and the evictions made during this run
+func (c *Cache) Stats() (Stats, error) {
+	entries, err := c.Entries()
+	if err != nil {
+		return Stats{}, err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	stats := Stats{
+		Dir:         c.Dir,
+		Entries:     len(entries),
+		MaxSize:     c.MaxSize,
+		MaxAge:      c.MaxAge,
+		Evicted:     c.evicted,
+		EvictedSize: c.evictedSize,
+		Hits:        c.hits,
+		Misses:      c.misses,
+	}
+	for _, entry := range entries {
+		stats.Size += entry.Size
+	}
+	return stats, nil
+}
+
+func (s Stats) String() string {
+	maxSize := "unbounded"
+	if s.MaxSize > 0 {
+		maxSize = FormatSize(s.MaxSize)
+	}
+	maxAge := "never"
+	if s.MaxAge > 0 {
+		maxAge = s.MaxAge.String()
+	}
+	return fmt.Sprintf("Cache directory: %s\nEntries: %d\nSize: %s (max: %s)\nEntries expire after: %s\nHits this run: %d\nMisses this run: %d\nEvicted this run: %d (%s)\n",
+		s.Dir, s.Entries, FormatSize(s.Size), maxSize, maxAge, s.Hits, s.Misses, s.Evicted, FormatSize(s.EvictedSize))
+}
+
+// Key returns the key of an upstream given its URL and a reference that immutably identifies its contents, such as a commit or a digest
+func Key(url, ref string) string {
+	return fmt.Sprintf("%s@%s", url, ref)
+}
+
+// Get returns the path to the contents stored under the key and whether an entry was found
+// Finding an entry marks it as the most recently used
+func (c *Cache) Get(key string) (string, bool, error) {
+	entryDir := c.entryDir(key)
+	entry, err := readEntry(entryDir)
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("Unable to read cache entry %s: %s", entryDir, err)
+	}
+	if entry.Key != key {
+		return "", false, nil
+	}
+	entry.LastUsed = time.Now()
+	if err := writeEntry(entryDir, entry); err != nil {
+		return "", false, fmt.Errorf("Unable to update cache entry %s: %s", entryDir, err)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.hits++
+	return filepath.Join(entryDir, entryContentPath), true, nil
+}
+
+// Put stores the contents that populate writes into the path it is given under the key and returns the path to the stored contents
+// The entry only becomes visible once populate succeeds, so a failed or interrupted pull never leaves a partial entry behind
+func (c *Cache) Put(key string, populate func(contentPath string) error) (string, error) {
+	keyLock := c.getKeyLock(key)
+	keyLock.Lock()
+	defer keyLock.Unlock()
+	// Another pull of the same upstream may have stored the entry while this one was waiting
+	if contentPath, ok, err := c.Get(key); err != nil {
+		return "", err
+	} else if ok {
+		return contentPath, nil
+	}
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return "", fmt.Errorf("Unable to create cache directory %s: %s", c.Dir, err)
+	}
+	tempDir, err := ioutil.TempDir(c.Dir, tempEntryPrefix)
+	if err != nil {
+		return "", fmt.Errorf("Unable to create temporary cache entry in %s: %s", c.Dir, err)
+	}
+	defer os.RemoveAll(tempDir)
+	if err := populate(filepath.Join(tempDir, entryContentPath)); err != nil {
+		return "", err
+	}
+	if err := writeEntry(tempDir, Entry{Key: key, LastUsed: time.Now()}); err != nil {
+		return "", fmt.Errorf("Unable to write cache entry for %s: %s", key, err)
+	}
+	entryDir := c.entryDir(key)
+	if err := os.RemoveAll(entryDir); err != nil {
+		return "", fmt.Errorf("Unable to replace cache entry %s: %s", entryDir, err)
+	}
+	if err := os.Rename(tempDir, entryDir); err != nil {
+		return "", fmt.Errorf("Unable to store cache entry %s: %s", entryDir, err)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.misses++
+	return filepath.Join(entryDir, entryContentPath), nil
+}
+
+// getKeyLock returns the lock that serializes storing entries under the key
+func (c *Cache) getKeyLock(key string) *sync.Mutex {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.keyLocks == nil {
+		c.keyLocks = make(map[string]*sync.Mutex)
+	}
+	if _, ok := c.keyLocks[key]; !ok {
+		c.keyLocks[key] = &sync.Mutex{}
+	}
+	return c.keyLocks[key]
+}
+
+// entryDir returns the directory of the entry for the key within the cache
+func (c *Cache) entryDir(key string) string {
+	digest := sha256.Sum256([]byte(key))
+	return filepath.Join(c.Dir, hex.EncodeToString(digest[:]))
+}
+
+// readEntry reads the metadata and computes the size of the entry at entryDir
+func readEntry(entryDir string) (Entry, error) {
+	var entry Entry
+	metadataBytes, err := ioutil.ReadFile(filepath.Join(entryDir, entryMetadataFile))
+	if err != nil {
+		return entry, err
+	}
+	if err := yaml.Unmarshal(metadataBytes, &entry); err != nil {
+		return entry, err
+	}
+	entry.Dir = entryDir
+	err = filepath.Walk(entryDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			entry.Size += info.Size()
+		}
+		return nil
+	})
+	return entry, err
+}
+
+// writeEntry writes the metadata of the entry into entryDir
+func writeEntry(entryDir string, entry Entry) error {
+	metadataBytes, err := yaml.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(entryDir, entryMetadataFile), metadataBytes, 0644)
+}
+
+// FormatSize returns a human-readable representation of a size in bytes
+func FormatSize(size int64) string {
+	return units.BytesSize(float64(size))
+}
+
+// ParseSize parses a human-readable size such as 10GiB or 512m into bytes
+func ParseSize(size string) (int64, error) {
+	return units.RAMInBytes(size)
+}
@@ -15,9 +15,9 @@ import (
 // ApplyChanges applies the changes from the gcOverlayDirpath, gcExcludeDirpath, and gcPatchDirpath within gcDir to toDir within the package filesystem
 func ApplyChanges(fs billy.Filesystem, toDir, gcRootDir string) error {
 	logrus.Infof("Applying changes from %s", path.GeneratedChangesDir)
-	// gcRootDir should always end with path.GeneratedChangesDir
-	if !strings.HasSuffix(gcRootDir, path.GeneratedChangesDir) {
-		return fmt.Errorf("Root directory for generated changes should end with %s, received: %s", path.GeneratedChangesDir, gcRootDir)
+	// gcRootDir should always end with path.GeneratedChangesDir or be within it, e.g. for additional charts whose generated changes are flattened
+	if !isGeneratedChangesRootDir(gcRootDir) {
+		return fmt.Errorf("Root directory for generated changes should end with %s or be within it, received: %s", path.GeneratedChangesDir, gcRootDir)
 	}
 	chartsOverlayDirpath := filepath.Join(gcRootDir, path.GeneratedChangesOverlayDir)
 	chartsExcludeDirpath := filepath.Join(gcRootDir, path.GeneratedChangesExcludeDir)
@@ -27,7 +27,7 @@ func ApplyChanges(fs billy.Filesystem, toDir, gcRootDir string) error {
 			return nil
 		}
 		logrus.Infof("Applying: %s", patchPath)
-		return diff.ApplyPatch(fs, patchPath, toDir)
+		return ApplyPatchFile(fs, patchPath, chartsPatchDirpath, toDir)
 	}
 
 	applyOverlayFile := func(fs billy.Filesystem, overlayPath string, isDir bool) error {
@@ -85,3 +85,22 @@ func ApplyChanges(fs billy.Filesystem, toDir, gcRootDir string) error {
 	}
 	return nil
 }
+
+// ApplyPatchFile applies the patch at patchPath, which is rooted at patchDir, to the corresponding file in toDir
+// Structured patches are merged into the YAML file they were generated from, while any other patch is applied as a unified diff
+func ApplyPatchFile(fs billy.Filesystem, patchPath, patchDir, toDir string) error {
+	if !strings.HasSuffix(patchPath, StructuredPatchExt) {
+		return diff.ApplyPatch(fs, patchPath, toDir)
+	}
+	dstPath, err := filesystem.MovePath(strings.TrimSuffix(patchPath, StructuredPatchExt), patchDir, toDir)
+	if err != nil {
+		return err
+	}
+	return ApplyStructuredPatch(fs, patchPath, dstPath)
+}
+
+// isGeneratedChangesRootDir returns whether gcRootDir is a directory that generated changes can be rooted at
+func isGeneratedChangesRootDir(gcRootDir string) bool {
+	gcRootDir = filepath.ToSlash(gcRootDir)
+	return strings.HasSuffix(gcRootDir, path.GeneratedChangesDir) || strings.HasPrefix(gcRootDir, path.GeneratedChangesDir+"/")
+}
@@ -0,0 +1,19 @@
+package change
+
+import (
+	"bytes"
+)
+
+const (
+	// binarySniffLen is the number of bytes at the start of a file that are checked to determine whether it is binary
+	binarySniffLen = 8000
+)
+
+// isBinary returns whether the contents of a file are binary, i.e. whether a null byte is found within its first bytes
+// This is the same heuristic that GNU diff and Git use to avoid generating line-based diffs for a file
+func isBinary(data []byte) bool {
+	if len(data) > binarySniffLen {
+		data = data[:binarySniffLen]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
@@ -1,9 +1,10 @@
 package change
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
 	"path/filepath"
-	"strings"
 
 	"github.com/go-git/go-billy/v5"
 	"github.com/rancher/charts-build-scripts/pkg/diff"
@@ -14,48 +15,76 @@ import (
 
 const (
 	patchFmt = "%s.patch"
+	// StructuredPatchExt is the extension of patches that are stored as YAML merge patches instead of unified diffs
+	StructuredPatchExt = ".yamlpatch"
 )
 
 // GenerateChanges generates the change between fromDir and toDir and places it in the appropriate directories within gcDir
-func GenerateChanges(fs billy.Filesystem, fromDir, toDir, gcRootDir string) error {
+// If structuredPatches is set, modifications to YAML files are stored as merge patches that are applied semantically wherever the change can be expressed as one
+func GenerateChanges(fs billy.Filesystem, fromDir, toDir, gcRootDir string, structuredPatches bool) error {
 	logrus.Infof("Generating changes to %s", path.GeneratedChangesDir)
-	// gcRootDir should always end with path.GeneratedChangesDir
-	if !strings.HasSuffix(gcRootDir, path.GeneratedChangesDir) {
-		return fmt.Errorf("Root directory for generated changes should end with %s, received: %s", path.GeneratedChangesDir, gcRootDir)
+	// gcRootDir should always end with path.GeneratedChangesDir or be within it, e.g. for additional charts whose generated changes are flattened
+	if !isGeneratedChangesRootDir(gcRootDir) {
+		return fmt.Errorf("Root directory for generated changes should end with %s or be within it, received: %s", path.GeneratedChangesDir, gcRootDir)
 	}
 	if err := removeAllGeneratedChanges(fs, gcRootDir); err != nil {
 		return fmt.Errorf("Encountered error while trying to remove all existing generated changes before generating new changes: %s", err)
 	}
-	generatePatchFile := func(fs billy.Filesystem, fromPath, toPath string, isDir bool) error {
+	generateOverlayFile := func(fs billy.Filesystem, toPath string, isDir bool) error {
 		if isDir {
 			return nil
 		}
-		patchPath, err := filesystem.MovePath(fromPath, fromDir, filepath.Join(gcRootDir, path.GeneratedChangesPatchDir))
+		overlayPath, err := filesystem.MovePath(toPath, toDir, filepath.Join(gcRootDir, path.GeneratedChangesOverlayDir))
 		if err != nil {
 			return err
 		}
-		patchPathWithExt := fmt.Sprintf(patchFmt, patchPath)
-		generatedPatch, err := diff.GeneratePatch(fs, patchPathWithExt, fromPath, toPath)
-		if err != nil {
+		if err := filesystem.CopyFile(fs, toPath, overlayPath); err != nil {
 			return err
 		}
-		if generatedPatch {
-			logrus.Infof("Patch: %s", patchPath)
-		}
+		logrus.Infof("Overlay: %s", toPath)
 		return nil
 	}
-	generateOverlayFile := func(fs billy.Filesystem, toPath string, isDir bool) error {
+	generatePatchFile := func(fs billy.Filesystem, fromPath, toPath string, isDir bool) error {
 		if isDir {
 			return nil
 		}
-		overlayPath, err := filesystem.MovePath(toPath, toDir, filepath.Join(gcRootDir, path.GeneratedChangesOverlayDir))
+		fromData, err := ioutil.ReadFile(filesystem.GetAbsPath(fs, fromPath))
 		if err != nil {
 			return err
 		}
-		if err := filesystem.CopyFile(fs, toPath, overlayPath); err != nil {
+		toData, err := ioutil.ReadFile(filesystem.GetAbsPath(fs, toPath))
+		if err != nil {
 			return err
 		}
-		logrus.Infof("Overlay: %s", toPath)
+		if isBinary(fromData) || isBinary(toData) {
+			// Binary files cannot be patched, so the modified file replaces the original one as an overlay
+			if bytes.Equal(fromData, toData) {
+				return nil
+			}
+			return generateOverlayFile(fs, toPath, isDir)
+		}
+		patchPath, err := filesystem.MovePath(fromPath, fromDir, filepath.Join(gcRootDir, path.GeneratedChangesPatchDir))
+		if err != nil {
+			return err
+		}
+		if structuredPatches && isYAMLFile(fromPath) {
+			generatedPatch, err := generateStructuredPatch(fs, patchPath+StructuredPatchExt, fromPath, toPath)
+			if err != nil {
+				return fmt.Errorf("Encountered error while generating structured patch for %s: %s", toPath, err)
+			}
+			if generatedPatch {
+				logrus.Infof("Structured patch: %s", patchPath)
+				return nil
+			}
+		}
+		patchPathWithExt := fmt.Sprintf(patchFmt, patchPath)
+		generatedPatch, err := diff.GeneratePatch(fs, patchPathWithExt, fromPath, toPath)
+		if err != nil {
+			return err
+		}
+		if generatedPatch {
+			logrus.Infof("Patch: %s", patchPath)
+		}
 		return nil
 	}
 	generateExcludeFile := func(fs billy.Filesystem, fromPath string, isDir bool) error {
@@ -76,9 +105,9 @@ func GenerateChanges(fs billy.Filesystem, fromDir, toDir, gcRootDir string) erro
 }
 
 func removeAllGeneratedChanges(fs billy.Filesystem, gcRootDir string) error {
-	// gcRootDir should always end with path.GeneratedChangesDir
-	if !strings.HasSuffix(gcRootDir, path.GeneratedChangesDir) {
-		return fmt.Errorf("Root directory for generated changes should end with %s, received: %s", path.GeneratedChangesDir, gcRootDir)
+	// gcRootDir should always end with path.GeneratedChangesDir or be within it, e.g. for additional charts whose generated changes are flattened
+	if !isGeneratedChangesRootDir(gcRootDir) {
+		return fmt.Errorf("Root directory for generated changes should end with %s or be within it, received: %s", path.GeneratedChangesDir, gcRootDir)
 	}
 	// Remove all overlays
 	if err := filesystem.RemoveAll(fs, filepath.Join(gcRootDir, path.GeneratedChangesOverlayDir)); err != nil {
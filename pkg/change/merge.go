@@ -0,0 +1,137 @@
+package change
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/diff"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/sirupsen/logrus"
+)
+
+// MergeChanges merges the changes from baseDir to theirsDir into oursDir, which holds changes made on top of baseDir
+// Files that were only changed on one side take that change, while files changed on both sides are merged line by line
+// It returns the conflicts that could not be merged, which are marked in oursDir with conflict markers or described in the returned messages
+func MergeChanges(fs billy.Filesystem, baseDir, oursDir, theirsDir string) ([]string, error) {
+	relPaths := make(map[string]bool)
+	for _, dir := range []string{baseDir, oursDir, theirsDir} {
+		err := filesystem.WalkDir(fs, dir, func(fs billy.Filesystem, path string, isDir bool) error {
+			if isDir {
+				return nil
+			}
+			relPath, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			relPaths[relPath] = true
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sortedRelPaths := make([]string, 0, len(relPaths))
+	for relPath := range relPaths {
+		sortedRelPaths = append(sortedRelPaths, relPath)
+	}
+	sort.Strings(sortedRelPaths)
+	var conflicts []string
+	for _, relPath := range sortedRelPaths {
+		basePath, oursPath, theirsPath := filepath.Join(baseDir, relPath), filepath.Join(oursDir, relPath), filepath.Join(theirsDir, relPath)
+		base, err := readFileIfExists(fs, basePath)
+		if err != nil {
+			return nil, err
+		}
+		ours, err := readFileIfExists(fs, oursPath)
+		if err != nil {
+			return nil, err
+		}
+		theirs, err := readFileIfExists(fs, theirsPath)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case equalFiles(ours, theirs), equalFiles(theirs, base):
+			// Upstream did not change the file or made the same change, so the working directory is already up to date
+			continue
+		case equalFiles(ours, base):
+			// Only upstream changed the file
+			if theirs == nil {
+				logrus.Infof("Removing %s since it was removed upstream", oursPath)
+				if err := fs.Remove(oursPath); err != nil {
+					return nil, err
+				}
+				if err := filesystem.PruneEmptyDirsInPath(fs, oursPath); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			logrus.Infof("Updating %s to match upstream", oursPath)
+			if err := writeFile(fs, oursPath, theirs); err != nil {
+				return nil, err
+			}
+		case ours == nil:
+			conflicts = append(conflicts, fmt.Sprintf("%s was removed but was modified upstream; the upstream version was restored", oursPath))
+			if err := writeFile(fs, oursPath, theirs); err != nil {
+				return nil, err
+			}
+		case theirs == nil:
+			conflicts = append(conflicts, fmt.Sprintf("%s was modified but was removed upstream; remove it if the modifications are no longer needed", oursPath))
+		case isBinary(ours) || isBinary(theirs):
+			conflicts = append(conflicts, fmt.Sprintf("%s is a binary file that was modified both locally and upstream; the local version was kept", oursPath))
+		default:
+			if base == nil {
+				basePath = ""
+			}
+			merged, conflicted, err := diff.MergeFiles(fs, oursPath, basePath, theirsPath)
+			if err != nil {
+				return nil, err
+			}
+			if conflicted {
+				conflicts = append(conflicts, fmt.Sprintf("%s has conflicting changes that are surrounded by conflict markers", oursPath))
+			} else {
+				logrus.Infof("Merged upstream changes into %s", oursPath)
+			}
+			if err := writeFile(fs, oursPath, merged); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return conflicts, nil
+}
+
+// readFileIfExists returns the contents of the file at path or nil if it does not exist
+func readFileIfExists(fs billy.Filesystem, path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filesystem.GetAbsPath(fs, path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		data = []byte{}
+	}
+	return data, nil
+}
+
+// equalFiles returns whether both files have the same contents or both do not exist
+func equalFiles(a, b []byte) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return bytes.Equal(a, b)
+}
+
+// writeFile writes the contents to the file at path, creating any parent directories
+func writeFile(fs billy.Filesystem, path string, data []byte) error {
+	if err := fs.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filesystem.GetAbsPath(fs, path), data, 0644)
+}
@@ -0,0 +1,195 @@
+package change
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"gopkg.in/yaml.v2"
+)
+
+// isYAMLFile returns whether the file at path is a YAML file based on its extension
+func isYAMLFile(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// generateStructuredPatch writes a merge patch between the YAML files at fromPath and toPath to patchPath
+// It returns false without writing a patch if the change cannot be expressed as a merge patch, e.g. if either file is not a single YAML document
+// containing a map, if the change only affects comments or formatting, or if a value is set to null
+func generateStructuredPatch(fs billy.Filesystem, patchPath, fromPath, toPath string) (bool, error) {
+	from, ok, err := readYAMLMap(fs, fromPath)
+	if err != nil || !ok {
+		return false, err
+	}
+	to, ok, err := readYAMLMap(fs, toPath)
+	if err != nil || !ok {
+		return false, err
+	}
+	patch := createMergePatch(from, to)
+	if len(patch) == 0 {
+		return false, nil
+	}
+	if !reflect.DeepEqual(normalizeYAML(applyMergePatch(from, patch)), normalizeYAML(to)) {
+		return false, nil
+	}
+	patchBytes, err := yaml.Marshal(patch)
+	if err != nil {
+		return false, err
+	}
+	file, err := filesystem.CreateFileAndDirs(fs, patchPath)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	if _, err := file.Write(patchBytes); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ApplyStructuredPatch applies the merge patch at patchPath, a YAML map in which a null value removes the key, to the YAML file at dstPath
+// The YAML file is rewritten, so any comments within it are not preserved
+func ApplyStructuredPatch(fs billy.Filesystem, patchPath, dstPath string) error {
+	patch, ok, err := readYAMLMap(fs, patchPath)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("Structured patch %s must be a YAML document containing a map", patchPath)
+	}
+	dst, ok, err := readYAMLMap(fs, dstPath)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("Cannot apply structured patch %s since %s is not a YAML document containing a map", patchPath, dstPath)
+	}
+	dstBytes, err := yaml.Marshal(applyMergePatch(dst, patch))
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filesystem.GetAbsPath(fs, dstPath), dstBytes, 0644)
+}
+
+// readYAMLMap returns the contents of the YAML file at path and whether it is a single, untemplated YAML document containing a map
+func readYAMLMap(fs billy.Filesystem, path string) (yaml.MapSlice, bool, error) {
+	data, err := ioutil.ReadFile(filesystem.GetAbsPath(fs, path))
+	if err != nil {
+		return nil, false, err
+	}
+	if bytes.Contains(data, []byte("{{")) {
+		return nil, false, nil
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	var m yaml.MapSlice
+	if err := decoder.Decode(&m); err != nil && err != io.EOF {
+		return nil, false, nil
+	}
+	var next interface{}
+	if err := decoder.Decode(&next); err != io.EOF {
+		return nil, false, nil
+	}
+	return m, true, nil
+}
+
+// createMergePatch returns a merge patch that turns from into to
+// Keys removed in to are set to null, maps found in both are patched recursively, and any other values that differ are replaced
+func createMergePatch(from, to yaml.MapSlice) yaml.MapSlice {
+	var patch yaml.MapSlice
+	for _, item := range to {
+		fromValue, exists := getValue(from, item.Key)
+		if !exists {
+			patch = append(patch, item)
+			continue
+		}
+		fromMap, fromIsMap := fromValue.(yaml.MapSlice)
+		toMap, toIsMap := item.Value.(yaml.MapSlice)
+		if fromIsMap && toIsMap {
+			if subpatch := createMergePatch(fromMap, toMap); len(subpatch) > 0 {
+				patch = append(patch, yaml.MapItem{Key: item.Key, Value: subpatch})
+			}
+			continue
+		}
+		if !reflect.DeepEqual(normalizeYAML(fromValue), normalizeYAML(item.Value)) {
+			patch = append(patch, item)
+		}
+	}
+	for _, item := range from {
+		if _, exists := getValue(to, item.Key); !exists {
+			patch = append(patch, yaml.MapItem{Key: item.Key, Value: nil})
+		}
+	}
+	return patch
+}
+
+// applyMergePatch returns the result of applying the merge patch to m
+// Keys set to null in the patch are removed, maps are patched recursively, and any other values are replaced or appended
+func applyMergePatch(m, patch yaml.MapSlice) yaml.MapSlice {
+	result := make(yaml.MapSlice, 0, len(m))
+	for _, item := range m {
+		patchValue, patched := getValue(patch, item.Key)
+		if !patched {
+			result = append(result, item)
+			continue
+		}
+		if patchValue == nil {
+			continue
+		}
+		mapValue, isMap := item.Value.(yaml.MapSlice)
+		patchMap, patchIsMap := patchValue.(yaml.MapSlice)
+		if isMap && patchIsMap {
+			result = append(result, yaml.MapItem{Key: item.Key, Value: applyMergePatch(mapValue, patchMap)})
+			continue
+		}
+		if patchIsMap {
+			patchValue = applyMergePatch(nil, patchMap)
+		}
+		result = append(result, yaml.MapItem{Key: item.Key, Value: patchValue})
+	}
+	for _, item := range patch {
+		if _, exists := getValue(m, item.Key); exists || item.Value == nil {
+			continue
+		}
+		if patchMap, ok := item.Value.(yaml.MapSlice); ok {
+			item = yaml.MapItem{Key: item.Key, Value: applyMergePatch(nil, patchMap)}
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+// getValue returns the value tied to key in the MapSlice and whether it exists
+func getValue(m yaml.MapSlice, key interface{}) (interface{}, bool) {
+	for _, item := range m {
+		if reflect.DeepEqual(item.Key, key) {
+			return item.Value, true
+		}
+	}
+	return nil, false
+}
+
+// normalizeYAML converts any MapSlices within the value into maps so that values can be compared regardless of the order of their keys
+func normalizeYAML(value interface{}) interface{} {
+	switch v := value.(type) {
+	case yaml.MapSlice:
+		m := make(map[string]interface{}, len(v))
+		for _, item := range v {
+			m[fmt.Sprintf("%T:%v", item.Key, item.Key)] = normalizeYAML(item.Value)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, item := range v {
+			s[i] = normalizeYAML(item)
+		}
+		return s
+	default:
+		return v
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/rancher/charts-build-scripts/pkg/options"
 	"github.com/rancher/charts-build-scripts/pkg/path"
 	"github.com/rancher/charts-build-scripts/pkg/puller"
+	"github.com/rancher/charts-build-scripts/pkg/transient"
 	"github.com/sirupsen/logrus"
 )
 
@@ -22,6 +23,10 @@ type AdditionalChart struct {
 	Upstream *puller.Puller `yaml:"upstream"`
 	// CRDChartOptions represents any options that are configurable for CRD charts
 	CRDChartOptions *options.CRDChartOptions `yaml:"crdChart"`
+	// MainChartDependencyOptions represents how this chart is wired into the main chart as a dependency, if at all
+	MainChartDependencyOptions *options.MainChartDependencyOptions `yaml:"mainChartDependency"`
+	// StructuredPatches indicates that modifications to YAML files should be stored as merge patches instead of unified diffs
+	StructuredPatches bool `yaml:"structuredPatches"`
 }
 
 // ApplyMainChanges applies any changes on the main chart introduced by the AdditionalChart
@@ -31,22 +36,41 @@ func (c *AdditionalChart) ApplyMainChanges(pkgFs billy.Filesystem) error {
 	} else if !exists {
 		return fmt.Errorf("Working directory %s has not been prepared yet", c.WorkingDir)
 	}
-	if c.CRDChartOptions == nil {
+	if c.CRDChartOptions == nil && c.MainChartDependencyOptions == nil {
 		return nil
 	}
 	mainChartWorkingDir, err := c.getMainChartWorkingDir(pkgFs)
 	if err != nil {
 		return fmt.Errorf("Encountered error while trying to get the main chart's working directory: %s", err)
 	}
-	if err := helm.CopyCRDsFromChart(pkgFs, mainChartWorkingDir, path.ChartCRDDir, c.WorkingDir, c.CRDChartOptions.CRDDirectory); err != nil {
-		return fmt.Errorf("Encountered error while trying to copy CRDs from %s to %s: %s", mainChartWorkingDir, c.WorkingDir, err)
+	if c.CRDChartOptions != nil && c.CRDChartOptions.Selector != nil {
+		if err := c.moveSelectedCRDsFromMainChart(pkgFs, mainChartWorkingDir); err != nil {
+			return err
+		}
+	} else if c.CRDChartOptions != nil {
+		if err := helm.CopyCRDsFromChart(pkgFs, mainChartWorkingDir, path.ChartCRDDir, c.WorkingDir, c.CRDChartOptions.CRDDirectory); err != nil {
+			return fmt.Errorf("Encountered error while trying to copy CRDs from %s to %s: %s", mainChartWorkingDir, c.WorkingDir, err)
+		}
+		if !c.CRDChartOptions.KeepCRDsInMainChart {
+			if err := helm.DeleteCRDsFromChart(pkgFs, mainChartWorkingDir); err != nil {
+				return fmt.Errorf("Encountered error while trying to delete CRDs from main chart: %s", err)
+			}
+		}
 	}
-	if err := helm.DeleteCRDsFromChart(pkgFs, mainChartWorkingDir); err != nil {
-		return fmt.Errorf("Encountered error while trying to delete CRDs from main chart: %s", err)
+	if c.CRDChartOptions != nil {
+		if err := ValidateNoCRDOwnershipConflicts(pkgFs, mainChartWorkingDir, c.WorkingDir, c.CRDChartOptions.CRDDirectory); err != nil {
+			return err
+		}
+		if c.CRDChartOptions.AddCRDValidationToMainChart {
+			if err := AddCRDValidationToChart(pkgFs, mainChartWorkingDir, c.WorkingDir, c.CRDChartOptions.CRDDirectory); err != nil {
+				return fmt.Errorf("Encountered error while trying to add CRD validation to %s based on CRDs in %s: %s", mainChartWorkingDir, c.WorkingDir, err)
+			}
+		}
 	}
-	if c.CRDChartOptions.AddCRDValidationToMainChart {
-		if err := AddCRDValidationToChart(pkgFs, mainChartWorkingDir, c.WorkingDir, c.CRDChartOptions.CRDDirectory); err != nil {
-			return fmt.Errorf("Encountered error while trying to add CRD validation to %s based on CRDs in %s: %s", mainChartWorkingDir, c.WorkingDir, err)
+	if c.MainChartDependencyOptions != nil {
+		// The dependency is wired in last so that a vendored copy picks up the CRDs moved out of the main chart
+		if err := AddDependencyWiringToChart(pkgFs, mainChartWorkingDir, c.WorkingDir, *c.MainChartDependencyOptions); err != nil {
+			return fmt.Errorf("Encountered error while trying to add %s as a dependency of %s: %s", c.WorkingDir, mainChartWorkingDir, err)
 		}
 	}
 	return nil
@@ -59,15 +83,31 @@ func (c *AdditionalChart) RevertMainChanges(pkgFs billy.Filesystem) error {
 	} else if !exists {
 		return fmt.Errorf("Working directory %s has not been prepared yet", c.WorkingDir)
 	}
-	if c.CRDChartOptions == nil {
+	if c.CRDChartOptions == nil && c.MainChartDependencyOptions == nil {
 		return nil
 	}
 	mainChartWorkingDir, err := c.getMainChartWorkingDir(pkgFs)
 	if err != nil {
 		return fmt.Errorf("Encountered error while trying to get the main chart's working directory: %s", err)
 	}
-	if err := helm.CopyCRDsFromChart(pkgFs, c.WorkingDir, c.CRDChartOptions.CRDDirectory, mainChartWorkingDir, path.ChartCRDDir); err != nil {
-		return fmt.Errorf("Encountered error while trying to copy CRDs from %s to %s: %s", c.WorkingDir, mainChartWorkingDir, err)
+	if c.MainChartDependencyOptions != nil {
+		if err := RemoveDependencyWiringFromChart(pkgFs, mainChartWorkingDir, c.WorkingDir, *c.MainChartDependencyOptions); err != nil {
+			return fmt.Errorf("Encountered error while trying to remove %s as a dependency of %s: %s", c.WorkingDir, mainChartWorkingDir, err)
+		}
+	}
+	if c.CRDChartOptions == nil {
+		return nil
+	}
+	if !c.CRDChartOptions.KeepCRDsInMainChart {
+		// CRDs that were kept in the main chart never need to be restored
+		// Other CRD charts may have already restored their CRDs, so only the CRDs of this chart are copied back
+		crdFiles, err := GetSelectedCRDFiles(pkgFs, c.WorkingDir, c.CRDChartOptions.CRDDirectory, nil)
+		if err != nil {
+			return err
+		}
+		if err := helm.CopyCRDFilesFromChart(pkgFs, c.WorkingDir, c.CRDChartOptions.CRDDirectory, mainChartWorkingDir, path.ChartCRDDir, crdFiles); err != nil {
+			return fmt.Errorf("Encountered error while trying to copy CRDs from %s to %s: %s", c.WorkingDir, mainChartWorkingDir, err)
+		}
 	}
 	if c.CRDChartOptions.AddCRDValidationToMainChart {
 		if err := RemoveCRDValidationFromChart(pkgFs, mainChartWorkingDir); err != nil {
@@ -77,8 +117,33 @@ func (c *AdditionalChart) RevertMainChanges(pkgFs billy.Filesystem) error {
 	return nil
 }
 
+// moveSelectedCRDsFromMainChart copies the CRDs of the main chart that are selected by the CRD chart options into the CRD chart and removes them from the main chart unless they are kept there
+func (c *AdditionalChart) moveSelectedCRDsFromMainChart(pkgFs billy.Filesystem, mainChartWorkingDir string) error {
+	crdFiles, err := GetSelectedCRDFiles(pkgFs, mainChartWorkingDir, path.ChartCRDDir, c.CRDChartOptions.Selector)
+	if err != nil {
+		return err
+	}
+	if len(crdFiles) == 0 {
+		return fmt.Errorf("No CRDs left in %s match the selector of CRD chart %s", filepath.Join(mainChartWorkingDir, path.ChartCRDDir), c.WorkingDir)
+	}
+	crdsDirpath := filepath.Join(c.WorkingDir, c.CRDChartOptions.CRDDirectory)
+	if err := filesystem.RemoveAll(pkgFs, crdsDirpath); err != nil {
+		return err
+	}
+	if err := helm.CopyCRDFilesFromChart(pkgFs, mainChartWorkingDir, path.ChartCRDDir, c.WorkingDir, c.CRDChartOptions.CRDDirectory, crdFiles); err != nil {
+		return fmt.Errorf("Encountered error while trying to copy CRDs from %s to %s: %s", mainChartWorkingDir, c.WorkingDir, err)
+	}
+	if c.CRDChartOptions.KeepCRDsInMainChart {
+		return nil
+	}
+	if err := helm.DeleteCRDFilesFromChart(pkgFs, mainChartWorkingDir, path.ChartCRDDir, crdFiles); err != nil {
+		return fmt.Errorf("Encountered error while trying to delete CRDs from main chart: %s", err)
+	}
+	return nil
+}
+
 // Prepare pulls in a package based on the spec to the local git repository
-func (c *AdditionalChart) Prepare(rootFs, pkgFs billy.Filesystem) error {
+func (c *AdditionalChart) Prepare(rootFs, pkgFs billy.Filesystem, crdChartTemplateData *CRDChartTemplateData) error {
 	if c.CRDChartOptions == nil && c.Upstream == nil {
 		return fmt.Errorf("No options provided to prepare additional chart")
 	}
@@ -102,9 +167,20 @@ func (c *AdditionalChart) Prepare(rootFs, pkgFs billy.Filesystem) error {
 		if !exists {
 			return fmt.Errorf("Unable to prepare a CRD chart since there are no CRDs at %s", filepath.Join(mainChartWorkingDir, path.ChartCRDDir))
 		}
-		if err := GenerateCRDChartFromTemplate(pkgFs, c.WorkingDir, filepath.Join(path.PackageTemplatesDir, c.CRDChartOptions.TemplateDirectory), c.CRDChartOptions.CRDDirectory); err != nil {
+		if !c.CRDChartOptions.RenderTemplate {
+			crdChartTemplateData = nil
+		}
+		if err := GenerateCRDChartFromTemplate(pkgFs, c.WorkingDir, filepath.Join(path.PackageTemplatesDir, c.CRDChartOptions.TemplateDirectory), c.CRDChartOptions.CRDDirectory, crdChartTemplateData); err != nil {
 			return fmt.Errorf("Encountered error while trying to generate CRD chart from template at %s: %s", c.CRDChartOptions.TemplateDirectory, err)
 		}
+		if err := SetCRDChartMetadata(pkgFs, c.WorkingDir, mainChartWorkingDir, *c.CRDChartOptions); err != nil {
+			return fmt.Errorf("Encountered error while trying to set the metadata of CRD chart %s: %s", c.WorkingDir, err)
+		}
+		if c.CRDChartOptions.UpgradeJob != nil {
+			if err := AddCRDUpgradeJobToChart(pkgFs, c.WorkingDir, c.CRDChartOptions.CRDDirectory, *c.CRDChartOptions.UpgradeJob); err != nil {
+				return fmt.Errorf("Encountered error while trying to add CRD upgrade job to %s: %s", c.WorkingDir, err)
+			}
+		}
 	} else {
 		u := *c.Upstream
 		if err := u.Pull(rootFs, pkgFs, c.WorkingDir); err != nil {
@@ -126,7 +202,7 @@ func (c *AdditionalChart) Prepare(rootFs, pkgFs billy.Filesystem) error {
 
 // getMainChartWorkingDir gets the working directory of the main chart
 func (c *AdditionalChart) getMainChartWorkingDir(pkgFs billy.Filesystem) (string, error) {
-	packageOpts, err := options.LoadPackageOptionsFromFile(pkgFs, path.PackageOptionsFile)
+	packageOpts, err := loadPackageOptions(pkgFs)
 	if err != nil {
 		return "", fmt.Errorf("Unable to read package.yaml: %s", err)
 	}
@@ -159,22 +235,37 @@ func (c *AdditionalChart) GeneratePatch(rootFs, pkgFs billy.Filesystem) error {
 	}
 
 	u := *c.Upstream
+	if err := transient.Track(pkgFs, c.OriginalDir()); err != nil {
+		return err
+	}
+	defer transient.Remove(pkgFs, c.OriginalDir())
 	if err := u.Pull(rootFs, pkgFs, c.OriginalDir()); err != nil {
 		return fmt.Errorf("Encountered error while trying to pull upstream into %s: %s", c.OriginalDir(), err)
 	}
 	if err := PrepareDependencies(rootFs, pkgFs, c.OriginalDir(), c.GeneratedChangesRootDir()); err != nil {
 		return fmt.Errorf("Encountered error while trying to prepare dependencies in %s: %s", c.OriginalDir(), err)
 	}
-	defer filesystem.RemoveAll(pkgFs, c.OriginalDir())
-	if err := change.GenerateChanges(pkgFs, c.OriginalDir(), c.WorkingDir, c.GeneratedChangesRootDir()); err != nil {
+	if err := change.GenerateChanges(pkgFs, c.OriginalDir(), c.WorkingDir, c.GeneratedChangesRootDir(), c.StructuredPatches); err != nil {
 		return fmt.Errorf("Encountered error while generating changes from %s to %s and placing it in %s: %s", c.OriginalDir(), c.WorkingDir, c.GeneratedChangesRootDir(), err)
 	}
 	return nil
 }
 
 // GenerateChart generates the chart and stores it in the assets and charts directory
-func (c *AdditionalChart) GenerateChart(rootFs, pkgFs billy.Filesystem, packageVersion, packageAssetsDirpath, packageChartsDirpath string) error {
-	if err := helm.ExportHelmChart(rootFs, pkgFs, c.WorkingDir, packageVersion, packageAssetsDirpath, packageChartsDirpath); err != nil {
+func (c *AdditionalChart) GenerateChart(rootFs, pkgFs billy.Filesystem, packageVersion, supportTier string, annotations map[string]string, imageMirror *options.ImageMirrorOptions, packageAssetsDirpath, packageChartsDirpath string) error {
+	if c.CRDChartOptions != nil && len(c.CRDChartOptions.CRDTransformTemplate) > 0 {
+		templatePath := filepath.Join(path.PackageTemplatesDir, c.CRDChartOptions.CRDTransformTemplate)
+		if err := helm.TransformCRDs(pkgFs, c.WorkingDir, c.CRDChartOptions.CRDDirectory, templatePath); err != nil {
+			return fmt.Errorf("Encountered error while trying to transform CRDs in %s: %s", c.WorkingDir, err)
+		}
+	}
+	// Managed-by metadata is added last since it may template the CRDs, which are skipped by any later update to them
+	if c.CRDChartOptions != nil && c.CRDChartOptions.AddManagedByMetadataToCRDs {
+		if err := helm.AddManagedByMetadataToCRDs(pkgFs, c.WorkingDir, c.CRDChartOptions.CRDDirectory); err != nil {
+			return fmt.Errorf("Encountered error while trying to add managed-by metadata to CRDs in %s: %s", c.WorkingDir, err)
+		}
+	}
+	if err := helm.ExportHelmChart(rootFs, pkgFs, c.WorkingDir, packageVersion, supportTier, annotations, imageMirror, packageAssetsDirpath, packageChartsDirpath); err != nil {
 		return fmt.Errorf("Encountered error while trying to export Helm chart for %s: %s", c.WorkingDir, err)
 	}
 	return nil
@@ -187,5 +278,5 @@ func (c *AdditionalChart) OriginalDir() string {
 
 // GeneratedChangesRootDir stored the directory rooted at the package level where generated changes for this chart can be found
 func (c *AdditionalChart) GeneratedChangesRootDir() string {
-	return filepath.Join(path.GeneratedChangesDir, path.GeneratedChangesAdditionalChartDir, c.WorkingDir, path.GeneratedChangesDir)
+	return getAdditionalChartGeneratedChangesRootDir(getGeneratedChangesLayout(), c.WorkingDir)
 }
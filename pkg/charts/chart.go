@@ -2,13 +2,20 @@ package charts
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 
 	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5"
 	"github.com/rancher/charts-build-scripts/pkg/change"
 	"github.com/rancher/charts-build-scripts/pkg/filesystem"
 	"github.com/rancher/charts-build-scripts/pkg/helm"
+	"github.com/rancher/charts-build-scripts/pkg/options"
 	"github.com/rancher/charts-build-scripts/pkg/path"
 	"github.com/rancher/charts-build-scripts/pkg/puller"
+	"github.com/rancher/charts-build-scripts/pkg/repository"
+	"github.com/rancher/charts-build-scripts/pkg/transient"
 	"github.com/sirupsen/logrus"
 )
 
@@ -18,6 +25,8 @@ type Chart struct {
 	Upstream puller.Puller `yaml:"upstream"`
 	// WorkingDir represents the working directory of this chart
 	WorkingDir string `yaml:"workingDir" default:"charts"`
+	// StructuredPatches indicates that modifications to YAML files should be stored as merge patches instead of unified diffs
+	StructuredPatches bool `yaml:"structuredPatches"`
 }
 
 // Prepare pulls in a package based on the spec to the local git repository
@@ -29,12 +38,47 @@ func (c *Chart) Prepare(rootFs, pkgFs billy.Filesystem) error {
 		}
 		return nil
 	}
-	if err := filesystem.RemoveAll(pkgFs, c.WorkingDir); err != nil {
-		return fmt.Errorf("Encountered error while trying to clean up %s before preparing: %s", c.WorkingDir, err)
+	for _, dir := range []string{c.WorkingDir, c.PristineDir()} {
+		if err := filesystem.RemoveAll(pkgFs, dir); err != nil {
+			return fmt.Errorf("Encountered error while trying to clean up %s before preparing: %s", dir, err)
+		}
 	}
 	if err := c.Upstream.Pull(rootFs, pkgFs, c.WorkingDir); err != nil {
 		return fmt.Errorf("Encountered error while trying to pull upstream into %s: %s", c.WorkingDir, err)
 	}
+	if isPinned(c.Upstream) {
+		// Keep a copy of the upstream so that changes can be re-applied without pulling it again
+		if err := filesystem.CopyDir(pkgFs, c.WorkingDir, c.PristineDir()); err != nil {
+			return fmt.Errorf("Encountered error while trying to copy %s to %s: %s", c.WorkingDir, c.PristineDir(), err)
+		}
+	}
+	return c.applyChanges(rootFs, pkgFs)
+}
+
+// ReapplyChanges restores the working directory to the upstream pulled by the last prepare and applies the changes to it
+// If no copy of the upstream was kept, the chart is prepared from scratch
+func (c *Chart) ReapplyChanges(rootFs, pkgFs billy.Filesystem) error {
+	exists, err := filesystem.PathExists(pkgFs, c.PristineDir())
+	if err != nil {
+		return fmt.Errorf("Encountered error while trying to check if %s exists: %s", c.PristineDir(), err)
+	}
+	if c.Upstream.IsWithinPackage() || !exists {
+		return c.Prepare(rootFs, pkgFs)
+	}
+	if err := filesystem.RemoveAll(pkgFs, c.WorkingDir); err != nil {
+		return fmt.Errorf("Encountered error while trying to clean up %s before preparing: %s", c.WorkingDir, err)
+	}
+	if err := filesystem.CopyDir(pkgFs, c.PristineDir(), c.WorkingDir); err != nil {
+		return fmt.Errorf("Encountered error while trying to copy %s to %s: %s", c.PristineDir(), c.WorkingDir, err)
+	}
+	return c.applyChanges(rootFs, pkgFs)
+}
+
+// applyChanges copies the package overlay, prepares the dependencies of the chart, and applies the generated changes to its working directory
+func (c *Chart) applyChanges(rootFs, pkgFs billy.Filesystem) error {
+	if err := applyPackageOverlay(pkgFs, c.WorkingDir); err != nil {
+		return err
+	}
 	if err := PrepareDependencies(rootFs, pkgFs, c.WorkingDir, c.GeneratedChangesRootDir()); err != nil {
 		return fmt.Errorf("Encountered error while trying to prepare dependencies in %s: %s", c.WorkingDir, err)
 	}
@@ -55,32 +99,244 @@ func (c *Chart) GeneratePatch(rootFs, pkgFs billy.Filesystem) error {
 	} else if !exists {
 		return fmt.Errorf("Working directory %s has not been prepared yet", c.WorkingDir)
 	}
+	if err := transient.Track(pkgFs, c.OriginalDir()); err != nil {
+		return err
+	}
+	defer transient.Remove(pkgFs, c.OriginalDir())
 	if err := c.Upstream.Pull(rootFs, pkgFs, c.OriginalDir()); err != nil {
 		return fmt.Errorf("Encountered error while trying to pull upstream into %s: %s", c.OriginalDir(), err)
 	}
+	if err := applyPackageOverlay(pkgFs, c.OriginalDir()); err != nil {
+		return err
+	}
 	if err := PrepareDependencies(rootFs, pkgFs, c.OriginalDir(), c.GeneratedChangesRootDir()); err != nil {
 		return fmt.Errorf("Encountered error while trying to prepare dependencies in %s: %s", c.OriginalDir(), err)
 	}
-	defer filesystem.RemoveAll(pkgFs, c.OriginalDir())
-	if err := change.GenerateChanges(pkgFs, c.OriginalDir(), c.WorkingDir, c.GeneratedChangesRootDir()); err != nil {
+	if err := change.GenerateChanges(pkgFs, c.OriginalDir(), c.WorkingDir, c.GeneratedChangesRootDir(), c.StructuredPatches); err != nil {
 		return fmt.Errorf("Encountered error while generating changes from %s to %s and placing it in %s: %s", c.OriginalDir(), c.WorkingDir, c.GeneratedChangesRootDir(), err)
 	}
 	return nil
 }
 
+// MergePatch rebases the local changes onto the current upstream with a three-way merge and regenerates the patch from the result
+// The upstream pulled by the last prepare is used as the common ancestor, so the chart must be pinned to an upstream and prepared
+// It returns the conflicts that must be resolved in the working directory before the patch can be generated
+func (c *Chart) MergePatch(rootFs, pkgFs billy.Filesystem) ([]string, error) {
+	if c.Upstream.IsWithinPackage() {
+		logrus.Infof("Local chart does not need to be patched")
+		return nil, nil
+	}
+	if exists, err := filesystem.PathExists(pkgFs, c.WorkingDir); err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to check if %s exists: %s", c.WorkingDir, err)
+	} else if !exists {
+		return nil, fmt.Errorf("Working directory %s has not been prepared yet", c.WorkingDir)
+	}
+	if exists, err := filesystem.PathExists(pkgFs, c.PristineDir()); err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to check if %s exists: %s", c.PristineDir(), err)
+	} else if !exists {
+		return nil, fmt.Errorf("Cannot find the upstream pulled by the last prepare in %s: the upstream must be pinned and the chart must be prepared to merge changes", c.PristineDir())
+	}
+	// The previous upstream with the package overlay and dependencies is the state the local changes were made on top of
+	if err := filesystem.RemoveAll(pkgFs, c.BaseDir()); err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to clean up %s before merging: %s", c.BaseDir(), err)
+	}
+	defer filesystem.RemoveAll(pkgFs, c.BaseDir())
+	if err := filesystem.CopyDir(pkgFs, c.PristineDir(), c.BaseDir()); err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to copy %s to %s: %s", c.PristineDir(), c.BaseDir(), err)
+	}
+	if err := applyPackageOverlay(pkgFs, c.BaseDir()); err != nil {
+		return nil, err
+	}
+	if err := PrepareDependencies(rootFs, pkgFs, c.BaseDir(), c.GeneratedChangesRootDir()); err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to prepare dependencies in %s: %s", c.BaseDir(), err)
+	}
+	if err := filesystem.RemoveAll(pkgFs, c.OriginalDir()); err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to clean up %s before merging: %s", c.OriginalDir(), err)
+	}
+	if err := transient.Track(pkgFs, c.OriginalDir()); err != nil {
+		return nil, err
+	}
+	defer transient.Remove(pkgFs, c.OriginalDir())
+	if err := c.Upstream.Pull(rootFs, pkgFs, c.OriginalDir()); err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to pull upstream into %s: %s", c.OriginalDir(), err)
+	}
+	// The current upstream becomes the one that changes are re-applied to on the next prepare once the merge succeeds
+	if err := filesystem.RemoveAll(pkgFs, c.NextPristineDir()); err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to clean up %s before merging: %s", c.NextPristineDir(), err)
+	}
+	if err := transient.Track(pkgFs, c.NextPristineDir()); err != nil {
+		return nil, err
+	}
+	defer transient.Remove(pkgFs, c.NextPristineDir())
+	if err := filesystem.CopyDir(pkgFs, c.OriginalDir(), c.NextPristineDir()); err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to copy %s to %s: %s", c.OriginalDir(), c.NextPristineDir(), err)
+	}
+	if err := applyPackageOverlay(pkgFs, c.OriginalDir()); err != nil {
+		return nil, err
+	}
+	if err := PrepareDependencies(rootFs, pkgFs, c.OriginalDir(), c.GeneratedChangesRootDir()); err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to prepare dependencies in %s: %s", c.OriginalDir(), err)
+	}
+	conflicts, err := change.MergeChanges(pkgFs, c.BaseDir(), c.WorkingDir, c.OriginalDir())
+	if err != nil {
+		return nil, fmt.Errorf("Encountered error while merging changes from %s to %s into %s: %s", c.BaseDir(), c.OriginalDir(), c.WorkingDir, err)
+	}
+	// The working directory is now based on the current upstream, even if conflicts are left to be resolved
+	if err := filesystem.RemoveAll(pkgFs, c.PristineDir()); err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to remove %s: %s", c.PristineDir(), err)
+	}
+	if err := pkgFs.Rename(c.NextPristineDir(), c.PristineDir()); err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to move %s to %s: %s", c.NextPristineDir(), c.PristineDir(), err)
+	}
+	if len(conflicts) > 0 {
+		return conflicts, nil
+	}
+	if err := change.GenerateChanges(pkgFs, c.OriginalDir(), c.WorkingDir, c.GeneratedChangesRootDir(), c.StructuredPatches); err != nil {
+		return nil, fmt.Errorf("Encountered error while generating changes from %s to %s and placing it in %s: %s", c.OriginalDir(), c.WorkingDir, c.GeneratedChangesRootDir(), err)
+	}
+	return nil, nil
+}
+
+// ExportPatches applies the patches of this chart onto a clone of its upstream repository on a new branch
+// The upstream repository is cloned into a new temporary directory outside of the repository and the absolute path of the clone is returned
+// The temporary directory is only removed if the patches could not be exported
+func (c *Chart) ExportPatches(pkgFs billy.Filesystem, branch string) (absUpstreamDir string, err error) {
+	var clone func(fs billy.Filesystem, path string) (*git.Repository, error)
+	var subdirectory *string
+	switch upstream := puller.Unwrap(c.Upstream).(type) {
+	case puller.GithubRepository:
+		clone, subdirectory = upstream.Clone, upstream.Subdirectory
+	case puller.GitRepository:
+		clone, subdirectory = upstream.Clone, upstream.Subdirectory
+	default:
+		return "", fmt.Errorf("Patches can only be exported to an upstream that is a Git repository, found %s", c.Upstream)
+	}
+	absTempDir, err := ioutil.TempDir("", "charts-build-scripts-export-patches-")
+	if err != nil {
+		return "", fmt.Errorf("Encountered error while trying to create temporary directory: %s", err)
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(absTempDir)
+		}
+	}()
+	tempFs := filesystem.GetFilesystem(absTempDir)
+	repo, err := clone(tempFs, c.UpstreamDir())
+	if err != nil {
+		return "", fmt.Errorf("Encountered error while trying to clone upstream into %s: %s", absTempDir, err)
+	}
+	absUpstreamDir = filesystem.GetAbsPath(tempFs, c.UpstreamDir())
+	head, err := repository.GetHead(repo)
+	if err != nil {
+		return "", fmt.Errorf("Encountered error while trying to get the head of %s: %s", absUpstreamDir, err)
+	}
+	if err := repository.CreateBranch(repo, branch, head); err != nil {
+		return "", fmt.Errorf("Encountered error while trying to create branch %s in %s: %s", branch, absUpstreamDir, err)
+	}
+	if err := repository.CheckoutBranch(repo, branch); err != nil {
+		return "", fmt.Errorf("Encountered error while trying to checkout branch %s in %s: %s", branch, absUpstreamDir, err)
+	}
+	// Patches are generated relative to the working directory, which is rooted at the subdirectory of the upstream
+	chartDir := c.UpstreamDir()
+	if subdirectory != nil {
+		chartDir = filepath.Join(chartDir, *subdirectory)
+	}
+	// The overlay and patches of the package are copied next to the clone since they can only be applied within a single filesystem
+	patchDir := filepath.Join(c.GeneratedChangesRootDir(), path.GeneratedChangesPatchDir)
+	for _, dir := range []string{path.PackageOverlayDir, patchDir} {
+		exists, err := filesystem.PathExists(pkgFs, dir)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			continue
+		}
+		if err := filesystem.CopyFromLocalPath(filesystem.GetAbsPath(pkgFs, dir), tempFs, dir); err != nil {
+			return "", fmt.Errorf("Encountered error while trying to copy %s into %s: %s", dir, absTempDir, err)
+		}
+		defer func(dir string) {
+			filesystem.RemoveAll(tempFs, dir)
+			filesystem.PruneEmptyDirsInPath(tempFs, filepath.Dir(dir))
+		}(dir)
+	}
+	if err := applyPackageOverlay(tempFs, chartDir); err != nil {
+		return "", err
+	}
+	exists, err := filesystem.PathExists(tempFs, patchDir)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		logrus.Infof("No patches found in %s", patchDir)
+		return absUpstreamDir, nil
+	}
+	err = filesystem.WalkDir(tempFs, patchDir, func(fs billy.Filesystem, patchPath string, isDir bool) error {
+		if isDir {
+			return nil
+		}
+		logrus.Infof("Applying: %s", patchPath)
+		return change.ApplyPatchFile(fs, patchPath, patchDir, chartDir)
+	})
+	if err != nil {
+		return "", fmt.Errorf("Encountered error while applying patches to %s: %s", chartDir, err)
+	}
+	return absUpstreamDir, nil
+}
+
 // GenerateChart generates the chart and stores it in the assets and charts directory
-func (c *Chart) GenerateChart(rootFs billy.Filesystem, pkgFs billy.Filesystem, chartVersion string, packageAssetsDirpath, packageChartsDirpath string) error {
-	if err := helm.ExportHelmChart(rootFs, pkgFs, c.WorkingDir, chartVersion, packageAssetsDirpath, packageChartsDirpath); err != nil {
+func (c *Chart) GenerateChart(rootFs billy.Filesystem, pkgFs billy.Filesystem, chartVersion, supportTier string, annotations map[string]string, imageMirror *options.ImageMirrorOptions, packageAssetsDirpath, packageChartsDirpath string) error {
+	if err := helm.ExportHelmChart(rootFs, pkgFs, c.WorkingDir, chartVersion, supportTier, annotations, imageMirror, packageAssetsDirpath, packageChartsDirpath); err != nil {
 		return fmt.Errorf("Encountered error while trying to export Helm chart for %s: %s", c.WorkingDir, err)
 	}
 	return nil
 }
 
+// applyPackageOverlay copies the files within the overlay directory of the package over the chart at helmChartPath, if the package has one
+func applyPackageOverlay(pkgFs billy.Filesystem, helmChartPath string) error {
+	exists, err := filesystem.PathExists(pkgFs, path.PackageOverlayDir)
+	if err != nil {
+		return fmt.Errorf("Encountered error while trying to check if %s exists: %s", path.PackageOverlayDir, err)
+	}
+	if !exists {
+		return nil
+	}
+	logrus.Infof("Copying %s over %s", path.PackageOverlayDir, helmChartPath)
+	if err := filesystem.CopyDir(pkgFs, path.PackageOverlayDir, helmChartPath); err != nil {
+		return fmt.Errorf("Encountered error while trying to copy %s over %s: %s", path.PackageOverlayDir, helmChartPath, err)
+	}
+	return nil
+}
+
 // OriginalDir returns a working directory where we can place the original chart from upstream
 func (c *Chart) OriginalDir() string {
 	return fmt.Sprintf("%s-original", c.WorkingDir)
 }
 
+// UpstreamDir returns the directory within a temporary directory where we can clone the upstream repository to export patches to
+func (c *Chart) UpstreamDir() string {
+	return fmt.Sprintf("%s-upstream", c.WorkingDir)
+}
+
+// BaseDir returns a working directory where we can place the chart that local changes were made on top of when merging changes
+func (c *Chart) BaseDir() string {
+	return fmt.Sprintf("%s-base", c.WorkingDir)
+}
+
+// PreviousDir returns a working directory where we can place the chart from a previous upstream to compare against
+func (c *Chart) PreviousDir() string {
+	return fmt.Sprintf("%s-previous", c.WorkingDir)
+}
+
+// PristineDir returns a working directory where we can keep a copy of the upstream chart pulled by the last prepare
+func (c *Chart) PristineDir() string {
+	return fmt.Sprintf("%s-pristine", c.WorkingDir)
+}
+
+// NextPristineDir returns a working directory where we can stage the copy of the upstream chart that replaces the one in PristineDir
+func (c *Chart) NextPristineDir() string {
+	return fmt.Sprintf("%s-pristine-next", c.WorkingDir)
+}
+
 // GeneratedChangesRootDir stored the directory rooted at the package level where generated changes for this chart can be found
 func (c *Chart) GeneratedChangesRootDir() string {
 	return path.GeneratedChangesDir
@@ -7,13 +7,25 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"text/template"
 
 	"github.com/go-git/go-billy/v5"
 	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/helm"
+	"github.com/rancher/charts-build-scripts/pkg/options"
 	"github.com/rancher/charts-build-scripts/pkg/path"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
+	helmChartutil "helm.sh/helm/v3/pkg/chartutil"
+)
+
+const (
+	// CRDChartVersionSchemeTemplate keeps the version of the CRD chart found in its template
+	CRDChartVersionSchemeTemplate = "template"
+	// CRDChartVersionSchemeMainChart versions the CRD chart along with the main chart
+	CRDChartVersionSchemeMainChart = "mainChart"
 )
 
 // ValidateInstallCRDContentsFmt is the format for the contents of ChartValidateInstallCRDFile
@@ -35,8 +47,174 @@ const ValidateInstallCRDContentsFmt = `#{{- if gt (len (lookup "rbac.authorizati
 // ValidateInstallCRDPerGVKFmt is the format that the GroupVersionKind of each CRD placed in ValidateInstallCRDContentsFmt needs to have
 const ValidateInstallCRDPerGVKFmt = `# {{- set $found "%s" false -}}`
 
+// CRDUpgradeJobContentsFmt is the format for the contents of ChartCRDUpgradeJobFile, given the CRD directory of the chart and the repository and tag of the default image
+// Every file within the CRD directory is placed in its own ConfigMap so that large sets of CRDs do not exceed the size limit of a ConfigMap
+const CRDUpgradeJobContentsFmt = `{{- $job := .Values.crdUpgradeJob | default dict -}}
+{{- if or (not (hasKey $job "enabled")) $job.enabled }}
+{{- $name := printf "%%s-crd-upgrade" .Chart.Name | trunc 63 | trimSuffix "-" }}
+{{- $image := $job.image | default dict }}
+{{- $global := .Values.global | default dict }}
+{{- $cattle := $global.cattle | default dict }}
+{{- $registry := $cattle.systemDefaultRegistry | default "" }}
+{{- $files := .Files.Glob "%[1]s/**" }}
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: {{ $name }}
+  namespace: {{ .Release.Namespace }}
+  annotations:
+    "helm.sh/hook": pre-install,pre-upgrade
+    "helm.sh/hook-weight": "-5"
+    "helm.sh/hook-delete-policy": before-hook-creation,hook-succeeded
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: {{ $name }}
+  annotations:
+    "helm.sh/hook": pre-install,pre-upgrade
+    "helm.sh/hook-weight": "-5"
+    "helm.sh/hook-delete-policy": before-hook-creation,hook-succeeded
+rules:
+- apiGroups: ["apiextensions.k8s.io"]
+  resources: ["customresourcedefinitions"]
+  verbs: ["create", "get", "list", "patch", "update"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: {{ $name }}
+  annotations:
+    "helm.sh/hook": pre-install,pre-upgrade
+    "helm.sh/hook-weight": "-5"
+    "helm.sh/hook-delete-policy": before-hook-creation,hook-succeeded
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: {{ $name }}
+subjects:
+- kind: ServiceAccount
+  name: {{ $name }}
+  namespace: {{ .Release.Namespace }}
+{{- range $path, $_ := $files }}
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ printf "%%s-%%s" $name (sha256sum $path | trunc 8) | trunc 63 | trimSuffix "-" }}
+  namespace: {{ $.Release.Namespace }}
+  annotations:
+    "helm.sh/hook": pre-install,pre-upgrade
+    "helm.sh/hook-weight": "-5"
+    "helm.sh/hook-delete-policy": before-hook-creation,hook-succeeded
+data:
+  {{ printf "%%s-%%s" (sha256sum $path | trunc 8) (base $path) }}: |-
+{{ $.Files.Get $path | indent 4 }}
+{{- end }}
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: {{ $name }}
+  namespace: {{ .Release.Namespace }}
+  annotations:
+    "helm.sh/hook": pre-install,pre-upgrade
+    "helm.sh/hook-weight": "0"
+    "helm.sh/hook-delete-policy": before-hook-creation,hook-succeeded
+spec:
+  backoffLimit: 3
+  template:
+    spec:
+      serviceAccountName: {{ $name }}
+      restartPolicy: OnFailure
+      securityContext: {{- $job.securityContext | default (dict "runAsNonRoot" true "runAsUser" 1000 "seccompProfile" (dict "type" "RuntimeDefault")) | toYaml | nindent 8 }}
+      nodeSelector: {{- $job.nodeSelector | default (dict "kubernetes.io/os" "linux") | toYaml | nindent 8 }}
+      tolerations: {{- $job.tolerations | default (list) | toYaml | nindent 8 }}
+      containers:
+      - name: apply-crds
+        image: {{ if $registry }}{{ trimSuffix "/" $registry }}/{{ end }}{{ $image.repository | default "%[2]s" }}:{{ $image.tag | default "%[3]s" }}
+        imagePullPolicy: {{ $image.pullPolicy | default "IfNotPresent" }}
+        command: ["kubectl", "apply", "--server-side", "--force-conflicts", "--field-manager={{ .Chart.Name }}", "--filename=/etc/crds"]
+        env:
+        - name: HOME
+          value: /tmp
+        securityContext: {{- $job.containerSecurityContext | default (dict "allowPrivilegeEscalation" false "readOnlyRootFilesystem" true "capabilities" (dict "drop" (list "ALL"))) | toYaml | nindent 10 }}
+        resources: {{- $job.resources | default (dict) | toYaml | nindent 10 }}
+        volumeMounts:
+        - name: crds
+          mountPath: /etc/crds
+          readOnly: true
+        - name: tmp
+          mountPath: /tmp
+      volumes:
+      - name: crds
+        projected:
+          sources:
+          {{- range $path, $_ := $files }}
+          - configMap:
+              name: {{ printf "%%s-%%s" $name (sha256sum $path | trunc 8) | trunc 63 | trimSuffix "-" }}
+          {{- end }}
+      - name: tmp
+        emptyDir: {}
+{{- end }}
+`
+
+const (
+	// CRDChartTemplateLeftDelim is the left delimiter of the actions rendered in a CRD chart template, which differs from that of Helm so that the Helm templates within it are kept as is
+	CRDChartTemplateLeftDelim = "[["
+	// CRDChartTemplateRightDelim is the right delimiter of the actions rendered in a CRD chart template
+	CRDChartTemplateRightDelim = "]]"
+)
+
+// CRDChartTemplateData represents the metadata of a package that the files of a CRD chart template are rendered with
+type CRDChartTemplateData struct {
+	// PackageName is the name of the package
+	PackageName string
+	// PackageVersion is the version of the package
+	PackageVersion int
+	// ChartName is the name of the main chart
+	ChartName string
+	// UpstreamVersion is the version of the main chart before the package version is appended to it on export
+	UpstreamVersion string
+	// Version is the version that the main chart is exported with
+	Version string
+	// AppVersion is the appVersion of the main chart
+	AppVersion string
+	// Annotations are the annotations of the package that are added to the main chart on export
+	Annotations map[string]string
+}
+
+// getCRDChartTemplateData returns the metadata that the templates of CRD charts in the package are rendered with, or nil if no CRD chart renders its template
+// It must be called once the main chart is prepared
+func (p *Package) getCRDChartTemplateData() (*CRDChartTemplateData, error) {
+	renderTemplate := false
+	for _, additionalChart := range p.AdditionalCharts {
+		if additionalChart.CRDChartOptions != nil && additionalChart.CRDChartOptions.RenderTemplate {
+			renderTemplate = true
+		}
+	}
+	if !renderTemplate {
+		return nil, nil
+	}
+	mainChartYamlPath := filepath.Join(p.Chart.WorkingDir, helmChartutil.ChartfileName)
+	mainMetadata, err := helmChartutil.LoadChartfile(filesystem.GetAbsPath(p.fs, mainChartYamlPath))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load %s: %s", mainChartYamlPath, err)
+	}
+	return &CRDChartTemplateData{
+		PackageName:     p.Name,
+		PackageVersion:  p.PackageVersion,
+		ChartName:       mainMetadata.Name,
+		UpstreamVersion: mainMetadata.Version,
+		Version:         mainMetadata.Version + fmt.Sprintf("%02d-rc%02d", p.PackageVersion, p.ReleaseCandidateVersion),
+		AppVersion:      mainMetadata.AppVersion,
+		Annotations:     p.Annotations,
+	}, nil
+}
+
 // GenerateCRDChartFromTemplate copies templateDir over to dstPath
-func GenerateCRDChartFromTemplate(fs billy.Filesystem, dstHelmChartPath, templateDir, crdsDir string) error {
+// If templateData is provided, every file copied from templateDir is rendered as a Go template with Sprig functions against it
+func GenerateCRDChartFromTemplate(fs billy.Filesystem, dstHelmChartPath, templateDir, crdsDir string, templateData *CRDChartTemplateData) error {
 	exists, err := filesystem.PathExists(fs, templateDir)
 	if err != nil {
 		return err
@@ -50,9 +228,246 @@ func GenerateCRDChartFromTemplate(fs billy.Filesystem, dstHelmChartPath, templat
 	if err := filesystem.CopyDir(fs, templateDir, dstHelmChartPath); err != nil {
 		return err
 	}
+	if templateData == nil {
+		return nil
+	}
+	logrus.Infof("Rendering CRD chart template %s", templateDir)
+	return filesystem.WalkDir(fs, dstHelmChartPath, func(fs billy.Filesystem, filePath string, isDir bool) error {
+		if isDir {
+			return nil
+		}
+		return renderCRDChartTemplateFile(fs, filePath, templateData)
+	})
+}
+
+// renderCRDChartTemplateFile renders the file at filePath as a Go template with Sprig functions against templateData and writes the result back to it
+func renderCRDChartTemplateFile(fs billy.Filesystem, filePath string, templateData *CRDChartTemplateData) error {
+	absPath := filesystem.GetAbsPath(fs, filePath)
+	fileInfo, err := os.Stat(absPath)
+	if err != nil {
+		return err
+	}
+	fileBytes, err := ioutil.ReadFile(absPath)
+	if err != nil {
+		return fmt.Errorf("Unable to read file %s: %s", filePath, err)
+	}
+	t, err := template.New(filepath.Base(filePath)).
+		Delims(CRDChartTemplateLeftDelim, CRDChartTemplateRightDelim).
+		Funcs(helm.SprigFuncMap()).
+		Option("missingkey=error").
+		Parse(string(fileBytes))
+	if err != nil {
+		return fmt.Errorf("Unable to parse CRD chart template file %s: %s", filePath, err)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, templateData); err != nil {
+		return fmt.Errorf("Unable to render CRD chart template file %s: %s", filePath, err)
+	}
+	return ioutil.WriteFile(absPath, buf.Bytes(), fileInfo.Mode())
+}
+
+// ValidateCRDChartVersionScheme returns an error if the version scheme is not template, mainChart, or empty
+func ValidateCRDChartVersionScheme(versionScheme string) error {
+	switch versionScheme {
+	case "", CRDChartVersionSchemeTemplate, CRDChartVersionSchemeMainChart:
+		return nil
+	}
+	return fmt.Errorf("CRD chart version scheme %s is invalid: must be %s or %s", versionScheme, CRDChartVersionSchemeTemplate, CRDChartVersionSchemeMainChart)
+}
+
+// ValidateCRDSelector returns an error if the selector selects nothing or has an invalid file pattern
+func ValidateCRDSelector(selector *options.CRDSelectorOptions) error {
+	if selector == nil {
+		return nil
+	}
+	if len(selector.Groups) == 0 && len(selector.Files) == 0 {
+		return fmt.Errorf("CRD selector must provide at least one group or file pattern")
+	}
+	for _, pattern := range selector.Files {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("CRD selector file pattern %s is invalid: %s", pattern, err)
+		}
+	}
+	return nil
+}
+
+// ValidateCRDUpgradeJob returns an error if the CRD upgrade job has no image with a tag or the CRDs it applies would also be deployed as templates by Helm
+func ValidateCRDUpgradeJob(upgradeJob *options.CRDUpgradeJobOptions, crdsDir string) error {
+	if upgradeJob == nil {
+		return nil
+	}
+	if _, _, err := splitImageTag(upgradeJob.Image); err != nil {
+		return fmt.Errorf("CRD upgrade job image is invalid: %s", err)
+	}
+	crdsDir = filepath.Clean(crdsDir)
+	if crdsDir == path.ChartTemplatesDir || strings.HasPrefix(crdsDir, path.ChartTemplatesDir+"/") {
+		return fmt.Errorf("CRD upgrade job cannot apply CRDs placed in %s, since Helm already manages them as templates", crdsDir)
+	}
+	return nil
+}
+
+// AddCRDUpgradeJobToChart adds the crd-upgrade-job.yaml to the chart at helmChartPath, which applies the CRDs located in crdsDir on install and upgrade
+func AddCRDUpgradeJobToChart(fs billy.Filesystem, helmChartPath, crdsDir string, upgradeJob options.CRDUpgradeJobOptions) error {
+	logrus.Infof("Adding %s to CRD chart", path.ChartCRDUpgradeJobFile)
+	repository, tag, err := splitImageTag(upgradeJob.Image)
+	if err != nil {
+		return err
+	}
+	upgradeJobContents := fmt.Sprintf(CRDUpgradeJobContentsFmt, filepath.ToSlash(filepath.Clean(crdsDir)), repository, tag)
+	upgradeJobDestpath := filepath.Join(helmChartPath, path.ChartCRDUpgradeJobFile)
+	if err := fs.MkdirAll(filepath.Dir(upgradeJobDestpath), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filesystem.GetAbsPath(fs, upgradeJobDestpath), []byte(upgradeJobContents), 0644); err != nil {
+		return fmt.Errorf("Encountered error while writing into %s: %s", upgradeJobDestpath, err)
+	}
 	return nil
 }
 
+// splitImageTag returns the repository and tag of an image of the form repository:tag
+func splitImageTag(image string) (string, string, error) {
+	i := strings.LastIndex(image, ":")
+	if i <= 0 || i == len(image)-1 || strings.Contains(image[i+1:], "/") {
+		return "", "", fmt.Errorf("image %s must be of the form repository:tag", image)
+	}
+	return image[:i], image[i+1:], nil
+}
+
+// validateCRDCharts returns an error if the CRD charts of a package cannot split the CRDs of the main chart between them
+// CRD charts take their CRDs from the main chart in the order they are listed, so a CRD chart without a selector takes every CRD left and must be listed last
+// Only one CRD chart can add CRD validation to the main chart, since each would overwrite the validation added by the others
+func validateCRDCharts(additionalCharts []AdditionalChart) error {
+	var crdCharts []AdditionalChart
+	for _, additionalChart := range additionalCharts {
+		if additionalChart.CRDChartOptions != nil {
+			crdCharts = append(crdCharts, additionalChart)
+		}
+	}
+	validatingCRDChart := ""
+	for i, crdChart := range crdCharts {
+		if crdChart.CRDChartOptions.Selector == nil && i != len(crdCharts)-1 {
+			return fmt.Errorf("CRD chart %s must provide a selector since it is not the last CRD chart of the package", crdChart.WorkingDir)
+		}
+		if !crdChart.CRDChartOptions.AddCRDValidationToMainChart {
+			continue
+		}
+		if len(validatingCRDChart) > 0 {
+			return fmt.Errorf("CRD charts %s and %s cannot both add CRD validation to the main chart", validatingCRDChart, crdChart.WorkingDir)
+		}
+		validatingCRDChart = crdChart.WorkingDir
+	}
+	return nil
+}
+
+// GetSelectedCRDFiles returns the files within crdsDir of helmChartPath that are selected by the selector, relative to crdsDir
+// If the selector is nil, every file is selected
+func GetSelectedCRDFiles(fs billy.Filesystem, helmChartPath, crdsDir string, selector *options.CRDSelectorOptions) ([]string, error) {
+	crdsDirpath := filepath.Join(helmChartPath, crdsDir)
+	var crdFiles []string
+	err := filesystem.WalkDir(fs, crdsDirpath, func(fs billy.Filesystem, path string, isDir bool) error {
+		if isDir {
+			return nil
+		}
+		crdFile, err := filepath.Rel(crdsDirpath, path)
+		if err != nil {
+			return err
+		}
+		selected, err := isSelectedCRDFile(fs, path, crdFile, selector)
+		if err != nil {
+			return err
+		}
+		if selected {
+			crdFiles = append(crdFiles, crdFile)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to select CRDs from %s: %s", crdsDirpath, err)
+	}
+	return crdFiles, nil
+}
+
+// isSelectedCRDFile returns whether the file at path, which is found at crdFile within the crds directory, matches any of the file patterns or defines a CRD in any of the groups of the selector
+func isSelectedCRDFile(fs billy.Filesystem, path, crdFile string, selector *options.CRDSelectorOptions) (bool, error) {
+	if selector == nil {
+		return true, nil
+	}
+	for _, pattern := range selector.Files {
+		if matched, _ := filepath.Match(pattern, crdFile); matched {
+			return true, nil
+		}
+	}
+	if len(selector.Groups) == 0 {
+		return false, nil
+	}
+	type k8sCRDResource struct {
+		Kind string `yaml:"kind"`
+		Spec struct {
+			Group string `yaml:"group"`
+		} `yaml:"spec"`
+	}
+	yamlFile, err := ioutil.ReadFile(filesystem.GetAbsPath(fs, path))
+	if err != nil {
+		return false, fmt.Errorf("Unable to read file %s: %s", path, err)
+	}
+	yamlDecoder := yaml.NewDecoder(bytes.NewReader(helm.TemplateActionRegex.ReplaceAll(yamlFile, nil)))
+	for {
+		var resource k8sCRDResource
+		err := yamlDecoder.Decode(&resource)
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("Unable to parse CRDs in %s: %s", path, err)
+		}
+		if resource.Kind != "CustomResourceDefinition" {
+			continue
+		}
+		for _, group := range selector.Groups {
+			if resource.Spec.Group == group {
+				return true, nil
+			}
+		}
+	}
+}
+
+// SetCRDChartMetadata overrides the name, description, annotations, and version of the CRD chart generated from a template with those configured in the CRD chart options
+// The name and version are derived from the main chart at mainHelmChartPath
+func SetCRDChartMetadata(fs billy.Filesystem, crdHelmChartPath, mainHelmChartPath string, crdChartOpts options.CRDChartOptions) error {
+	if len(crdChartOpts.NameSuffix) == 0 && len(crdChartOpts.Description) == 0 && len(crdChartOpts.Annotations) == 0 && crdChartOpts.VersionScheme != CRDChartVersionSchemeMainChart {
+		// Keep the Chart.yaml of the template as is
+		return nil
+	}
+	mainChartYamlPath := filepath.Join(mainHelmChartPath, helmChartutil.ChartfileName)
+	mainMetadata, err := helmChartutil.LoadChartfile(filesystem.GetAbsPath(fs, mainChartYamlPath))
+	if err != nil {
+		return fmt.Errorf("Unable to load %s: %s", mainChartYamlPath, err)
+	}
+	chartYamlPath := filepath.Join(crdHelmChartPath, helmChartutil.ChartfileName)
+	absChartYamlPath := filesystem.GetAbsPath(fs, chartYamlPath)
+	metadata, err := helmChartutil.LoadChartfile(absChartYamlPath)
+	if err != nil {
+		return fmt.Errorf("Unable to load %s: %s", chartYamlPath, err)
+	}
+	if len(crdChartOpts.NameSuffix) > 0 {
+		metadata.Name = mainMetadata.Name + crdChartOpts.NameSuffix
+	}
+	if len(crdChartOpts.Description) > 0 {
+		metadata.Description = crdChartOpts.Description
+	}
+	if len(crdChartOpts.Annotations) > 0 && metadata.Annotations == nil {
+		metadata.Annotations = make(map[string]string, len(crdChartOpts.Annotations))
+	}
+	for annotation, value := range crdChartOpts.Annotations {
+		metadata.Annotations[annotation] = value
+	}
+	if crdChartOpts.VersionScheme == CRDChartVersionSchemeMainChart {
+		metadata.Version = mainMetadata.Version
+		metadata.AppVersion = mainMetadata.AppVersion
+	}
+	return helmChartutil.SaveChartfile(absChartYamlPath, metadata)
+}
+
 // AddCRDValidationToChart adds the validate-install-crd.yaml to helmChartPathWithoutCRDs based on CRDs located in crdsDir within helmChartPathWithCRDs
 func AddCRDValidationToChart(fs billy.Filesystem, helmChartPathWithoutCRDs, helmChartPathWithCRDs, crdsDir string) error {
 	// Get the CRDs
@@ -147,3 +562,70 @@ func RemoveCRDValidationFromChart(fs billy.Filesystem, helmChartPath string) err
 	}
 	return nil
 }
+
+// ValidateNoCRDOwnershipConflicts returns an error if any CRD located in crdsDir within helmChartPathWithCRDs is also deployed by the templates of the chart at helmChartPathWithoutCRDs
+// Two charts deploying the same CRD will fight over its ownership on a helm upgrade
+// CRDs in the crds directory of helmChartPathWithoutCRDs are not checked, since they have either been moved into the CRD chart or are kept on purpose,
+// in which case Helm only installs them if they are missing and never upgrades or deletes them
+func ValidateNoCRDOwnershipConflicts(fs billy.Filesystem, helmChartPathWithoutCRDs, helmChartPathWithCRDs, crdsDir string) error {
+	crdChartCRDs, err := getCRDNames(fs, filepath.Join(helmChartPathWithCRDs, crdsDir))
+	if err != nil {
+		return fmt.Errorf("Encountered error while trying to read CRDs from %s: %s", helmChartPathWithCRDs, err)
+	}
+	mainChartCRDs, err := getCRDNames(fs, filepath.Join(helmChartPathWithoutCRDs, path.ChartTemplatesDir))
+	if err != nil {
+		return fmt.Errorf("Encountered error while trying to read CRDs from %s: %s", helmChartPathWithoutCRDs, err)
+	}
+	var conflicts []string
+	for name, file := range mainChartCRDs {
+		if _, ok := crdChartCRDs[name]; ok {
+			conflicts = append(conflicts, fmt.Sprintf("%s (%s)", name, file))
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return fmt.Errorf("Main chart %s deploys CRDs that are owned by the CRD chart %s: %s", helmChartPathWithoutCRDs, helmChartPathWithCRDs, strings.Join(conflicts, ", "))
+	}
+	return nil
+}
+
+// getCRDNames returns a map of the names of all CRDs found in dirpath to the file that contains them
+// Any Go template actions are stripped before parsing, so templated files are parsed on a best-effort basis
+func getCRDNames(fs billy.Filesystem, dirpath string) (map[string]string, error) {
+	crdNames := make(map[string]string)
+	type k8sResource struct {
+		Kind     string `yaml:"kind"`
+		Metadata struct {
+			Name string `yaml:"name"`
+		} `yaml:"metadata"`
+	}
+	err := filesystem.WalkDir(fs, dirpath, func(fs billy.Filesystem, path string, isDir bool) error {
+		if isDir {
+			return nil
+		}
+		absPath := filesystem.GetAbsPath(fs, path)
+		yamlFile, err := ioutil.ReadFile(absPath)
+		if err != nil {
+			return fmt.Errorf("Unable to read file %s: %s", absPath, err)
+		}
+		yamlDecoder := yaml.NewDecoder(bytes.NewReader(helm.TemplateActionRegex.ReplaceAll(yamlFile, nil)))
+		for {
+			var resource k8sResource
+			err := yamlDecoder.Decode(&resource)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				// Template could not be parsed as YAML without rendering it
+				logrus.Debugf("Skipping CRD ownership check for %s: %s", path, err)
+				break
+			}
+			if resource.Kind != "CustomResourceDefinition" || len(resource.Metadata.Name) == 0 {
+				continue
+			}
+			crdNames[resource.Metadata.Name] = path
+		}
+		return nil
+	})
+	return crdNames, err
+}
@@ -2,16 +2,19 @@ package charts
 
 import (
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 
 	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/credentials"
 	"github.com/rancher/charts-build-scripts/pkg/filesystem"
 	"github.com/rancher/charts-build-scripts/pkg/helm"
 	"github.com/rancher/charts-build-scripts/pkg/options"
 	"github.com/rancher/charts-build-scripts/pkg/path"
+	"github.com/rancher/charts-build-scripts/pkg/puller"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 	helmChart "helm.sh/helm/v3/pkg/chart"
@@ -24,7 +27,7 @@ import (
 // PrepareDependencies prepares all of the dependencies of a given chart and regenerates the requirements.yaml or Chart.yaml
 func PrepareDependencies(rootFs, pkgFs billy.Filesystem, mainHelmChartPath string, gcRootDir string) error {
 	logrus.Infof("Loading dependencies for chart")
-	if err := LoadDependencies(pkgFs, mainHelmChartPath, gcRootDir); err != nil {
+	if err := LoadDependencies(rootFs, pkgFs, mainHelmChartPath, gcRootDir); err != nil {
 		return err
 	}
 	dependencyMap, err := GetDependencyMap(pkgFs, gcRootDir)
@@ -88,7 +91,7 @@ func PrepareDependencies(rootFs, pkgFs billy.Filesystem, mainHelmChartPath strin
 }
 
 func getMainChartUpstreamOptions(pkgFs billy.Filesystem, gcRootDir string) (*options.UpstreamOptions, error) {
-	packageOpts, err := options.LoadPackageOptionsFromFile(pkgFs, path.PackageOptionsFile)
+	packageOpts, err := loadPackageOptions(pkgFs)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to read %s for PackageOptions: %s", path.PackageOptionsFile, err)
 	}
@@ -99,8 +102,12 @@ func getMainChartUpstreamOptions(pkgFs billy.Filesystem, gcRootDir string) (*opt
 	if !strings.HasPrefix(gcRootDir, additionalChartPrefix) {
 		return nil, fmt.Errorf("Unable to figure out main chart options given generated changes root directory at %s", gcRootDir)
 	}
-	// Get additional chart working dir by parsing chart name out of generated-changes/additional-charts/{chart-name}/generated-changes
-	additionalChartWorkingDir, err := filesystem.MovePath(filepath.Dir(gcRootDir), additionalChartPrefix, "")
+	// Get additional chart working dir by parsing chart name out of generated-changes/additional-charts/{chart-name}/generated-changes, or generated-changes/additional-charts/{chart-name} if flattened
+	additionalChartDir := filepath.Dir(gcRootDir)
+	if path.FlatAdditionalChartGeneratedChanges {
+		additionalChartDir = gcRootDir
+	}
+	additionalChartWorkingDir, err := filesystem.MovePath(additionalChartDir, additionalChartPrefix, "")
 	if err != nil {
 		return nil, err
 	}
@@ -113,23 +120,44 @@ func getMainChartUpstreamOptions(pkgFs billy.Filesystem, gcRootDir string) (*opt
 }
 
 // LoadDependencies takes all existing subcharts in the package and loads them into the gcRootDir as dependencies
-func LoadDependencies(pkgFs billy.Filesystem, mainHelmChartPath string, gcRootDir string) error {
+func LoadDependencies(rootFs, pkgFs billy.Filesystem, mainHelmChartPath string, gcRootDir string) error {
 	// Get main chart options
 	mainChartUpstreamOpts, err := getMainChartUpstreamOptions(pkgFs, gcRootDir)
 	if err != nil {
 		return err
 	}
+	if mainChartUpstreamOpts == nil {
+		// Charts generated from a template, e.g. CRD charts, do not override or build any dependencies
+		mainChartUpstreamOpts = &options.UpstreamOptions{}
+	}
 	// Load the main chart
 	mainChart, err := helmLoader.Load(filesystem.GetAbsPath(pkgFs, mainHelmChartPath))
 	if err != nil {
 		return err
 	}
+	overrides, err := getDependencyOverrides(mainChart, mainChartUpstreamOpts.DependencyOverrides)
+	if err != nil {
+		return err
+	}
+	localDependencies, err := loadLocalDependencies(rootFs, pkgFs, mainHelmChartPath, gcRootDir, mainChartUpstreamOpts.LocalDependencies)
+	if err != nil {
+		return err
+	}
+	for name := range overrides {
+		if localDependencies[name] {
+			return fmt.Errorf("Dependency %s cannot be both overridden and built from a chart within the repository", name)
+		}
+	}
 	// Handle local chart archives first since version numbers don't make a difference
 	for _, dependency := range mainChart.Metadata.Dependencies {
 		if !strings.HasPrefix(dependency.Repository, "file://") {
 			continue
 		}
 		dependencyName := dependency.Name
+		if _, ok := overrides[dependencyName]; ok || localDependencies[dependencyName] {
+			// Overridden dependencies are pulled from the repository of the override and local dependencies are built from the repository
+			continue
+		}
 		dependencyOptionsPath := filepath.Join(gcRootDir, path.GeneratedChangesDependenciesDir, dependencyName, path.DependencyOptionsFile)
 		dependencyExists, err := filesystem.PathExists(pkgFs, dependencyOptionsPath)
 		if err != nil {
@@ -148,6 +176,8 @@ func LoadDependencies(pkgFs billy.Filesystem, mainHelmChartPath string, gcRootDi
 				URL:          mainChartUpstreamOpts.URL,
 				Subdirectory: &subdirectory,
 				Commit:       mainChartUpstreamOpts.Commit,
+				Tag:          mainChartUpstreamOpts.Tag,
+				Protocol:     mainChartUpstreamOpts.Protocol,
 			},
 		}
 		if err := dependencyPackageOptions.WriteToFile(pkgFs, dependencyOptionsPath); err != nil {
@@ -155,33 +185,50 @@ func LoadDependencies(pkgFs billy.Filesystem, mainHelmChartPath string, gcRootDi
 		}
 	}
 	// Handle remote chart archives that don't have fixed version numbers
-	if mainChart.Lock == nil || mainChart.Lock.Dependencies == nil {
-		// No dependencies to parse
-		return nil
+	var remoteDependencies []*helmChart.Dependency
+	if mainChart.Lock != nil {
+		remoteDependencies = append(remoteDependencies, mainChart.Lock.Dependencies...)
 	}
-	for _, dependency := range mainChart.Lock.Dependencies {
-		dependencyName := dependency.Name
-		dependencyOptionsPath := filepath.Join(gcRootDir, path.GeneratedChangesDependenciesDir, dependencyName, path.DependencyOptionsFile)
-		// Check if dependency already exists
-		dependencyExists, err := filesystem.PathExists(pkgFs, dependencyOptionsPath)
-		if err != nil {
-			return err
+	for _, dependency := range mainChart.Metadata.Dependencies {
+		if _, ok := overrides[dependency.Name]; ok && !containsDependency(remoteDependencies, dependency.Name) {
+			// Overridden dependencies are resolved even if the upstream chart does not lock them
+			remoteDependencies = append(remoteDependencies, dependency)
 		}
-		if dependencyExists {
-			logrus.Infof("Found chart options for %s in %s", dependencyName, dependencyOptionsPath)
+	}
+	for _, dependency := range remoteDependencies {
+		dependencyName := dependency.Name
+		if localDependencies[dependencyName] {
 			continue
 		}
-		logrus.Infof("Looking for %s within repository %s", dependencyName, dependency.Repository)
-		dependencyURL, err := helmRepo.FindChartInRepoURL(
-			dependency.Repository,
-			dependencyName,
-			dependency.Version,
-			"", "", "",
-			helmGetter.All(&helmCli.EnvSettings{}),
-		)
+		dependencyOptionsPath := filepath.Join(gcRootDir, path.GeneratedChangesDependenciesDir, dependencyName, path.DependencyOptionsFile)
+		repository, version := dependency.Repository, dependency.Version
+		override, overridden := overrides[dependencyName]
+		if overridden {
+			if len(override.Repository) > 0 {
+				repository = override.Repository
+			}
+			if len(override.Version) > 0 {
+				version = override.Version
+			}
+		} else {
+			// Check if dependency already exists
+			dependencyExists, err := filesystem.PathExists(pkgFs, dependencyOptionsPath)
+			if err != nil {
+				return err
+			}
+			if dependencyExists {
+				logrus.Infof("Found chart options for %s in %s", dependencyName, dependencyOptionsPath)
+				continue
+			}
+		}
+		logrus.Infof("Looking for %s within repository %s", dependencyName, repository)
+		dependencyURL, err := findDependencyURL(repository, dependencyName, version)
 		if err != nil {
 			return fmt.Errorf("Encountered error while trying to find the repository for dependency %s: %s", dependency.Name, err)
 		}
+		if overridden {
+			logrus.Infof("Overriding dependency %s with version %s from %s", dependencyName, version, repository)
+		}
 		dependencyPackageOptions := options.ChartOptions{
 			UpstreamOptions: options.UpstreamOptions{
 				URL: dependencyURL,
@@ -194,6 +241,125 @@ func LoadDependencies(pkgFs billy.Filesystem, mainHelmChartPath string, gcRootDi
 	return nil
 }
 
+// findDependencyURL returns the URL of the chart version within the Helm repository or OCI registry, authenticating with the credentials used by the pullers
+func findDependencyURL(repository, chartName, version string) (string, error) {
+	if strings.HasPrefix(repository, puller.OCIChartURLPrefix) {
+		return puller.GetOCIChartURL(repository, chartName, version)
+	}
+	repositoryURL, err := url.Parse(repository)
+	if err != nil {
+		return "", fmt.Errorf("Unable to parse repository URL %s: %s", repository, err)
+	}
+	var username, password string
+	creds, err := credentials.GetCredentials(repositoryURL.Hostname())
+	if err != nil {
+		return "", err
+	}
+	if creds != nil && len(creds.Password) > 0 {
+		username, password = creds.Username, creds.Password
+		if len(username) == 0 {
+			// Helm only authenticates when both a username and a password are provided
+			username = puller.DefaultTokenUsername
+		}
+	}
+	return helmRepo.FindChartInAuthRepoURL(
+		repository,
+		username, password,
+		chartName,
+		version,
+		"", "", "",
+		helmGetter.All(&helmCli.EnvSettings{}),
+	)
+}
+
+// getDependencyOverrides returns the overrides keyed by the name of the dependency of the chart that they apply to
+// An override must change the version or repository of a dependency declared by the chart, and must provide a repository if the dependency is vendored via file://
+func getDependencyOverrides(chart *helmChart.Chart, dependencyOverrides []options.DependencyOverride) (map[string]options.DependencyOverride, error) {
+	overrides := make(map[string]options.DependencyOverride, len(dependencyOverrides))
+	for _, override := range dependencyOverrides {
+		if _, ok := overrides[override.Name]; ok {
+			return nil, fmt.Errorf("Dependency %s is overridden more than once", override.Name)
+		}
+		if len(override.Version) == 0 && len(override.Repository) == 0 {
+			return nil, fmt.Errorf("Override of dependency %s must provide a version or a repository", override.Name)
+		}
+		var dependency *helmChart.Dependency
+		for _, d := range chart.Metadata.Dependencies {
+			if d.Name == override.Name {
+				dependency = d
+			}
+		}
+		if dependency == nil {
+			return nil, fmt.Errorf("Override of dependency %s does not match any dependency declared by chart %s", override.Name, chart.Metadata.Name)
+		}
+		if strings.HasPrefix(dependency.Repository, "file://") && len(override.Repository) == 0 {
+			return nil, fmt.Errorf("Dependency %s is vendored within the upstream via %s, so its override must provide a repository", override.Name, dependency.Repository)
+		}
+		overrides[override.Name] = override
+	}
+	return overrides, nil
+}
+
+// loadLocalDependencies writes the options of each local dependency of the chart at mainHelmChartPath into gcRootDir, so that it is built along with any other dependency
+// It returns the names of the local dependencies
+func loadLocalDependencies(rootFs, pkgFs billy.Filesystem, mainHelmChartPath, gcRootDir string, localDependencies []options.LocalDependency) (map[string]bool, error) {
+	names := make(map[string]bool, len(localDependencies))
+	packageName := filepath.Base(filesystem.GetAbsPath(pkgFs, ""))
+	for _, dependency := range localDependencies {
+		if len(dependency.Name) == 0 {
+			return nil, fmt.Errorf("Local dependencies must have a name")
+		}
+		if names[dependency.Name] {
+			return nil, fmt.Errorf("Local dependency %s is declared more than once", dependency.Name)
+		}
+		names[dependency.Name] = true
+		if (len(dependency.Path) == 0) == (len(dependency.Package) == 0) {
+			return nil, fmt.Errorf("Local dependency %s must provide exactly one of a path or a package", dependency.Name)
+		}
+		var dependencyURL string
+		if len(dependency.Package) > 0 {
+			if dependency.Package == packageName {
+				return nil, fmt.Errorf("Local dependency %s cannot be the main chart of package %s itself; provide a path instead", dependency.Name, packageName)
+			}
+			dependencyURL = filepath.Join(path.RepositoryPackagesDir, dependency.Package)
+		} else {
+			dependencyPath := filepath.Clean(dependency.Path)
+			if filepath.IsAbs(dependencyPath) || dependencyPath == "." || dependencyPath == ".." || strings.HasPrefix(dependencyPath, "../") {
+				return nil, fmt.Errorf("Local dependency %s is invalid: path %s must be a directory relative to the root of the package", dependency.Name, dependency.Path)
+			}
+			if dependencyPath == filepath.Clean(mainHelmChartPath) {
+				return nil, fmt.Errorf("Local dependency %s is invalid: chart %s cannot depend on itself", dependency.Name, mainHelmChartPath)
+			}
+			repositoryPath, err := filesystem.GetRelativePath(rootFs, filesystem.GetAbsPath(pkgFs, dependencyPath))
+			if err != nil {
+				return nil, err
+			}
+			dependencyURL = puller.LocalPathURLPrefix + repositoryPath
+		}
+		dependencyOptionsPath := filepath.Join(gcRootDir, path.GeneratedChangesDependenciesDir, dependency.Name, path.DependencyOptionsFile)
+		logrus.Infof("Building dependency %s from %s", dependency.Name, dependencyURL)
+		dependencyPackageOptions := options.ChartOptions{
+			UpstreamOptions: options.UpstreamOptions{
+				URL: dependencyURL,
+			},
+		}
+		if err := dependencyPackageOptions.WriteToFile(pkgFs, dependencyOptionsPath); err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+// containsDependency returns whether a dependency with the name is in dependencies
+func containsDependency(dependencies []*helmChart.Dependency, name string) bool {
+	for _, d := range dependencies {
+		if d.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
 // GetDependencyMap gets a map between a dependency's name and a Chart representing that dependency for all rooted at gcRootDir
 func GetDependencyMap(pkgFs billy.Filesystem, gcRootDir string) (map[string]*Chart, error) {
 	dependencyMap := make(map[string]*Chart)
@@ -0,0 +1,111 @@
+package charts
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/rancher/charts-build-scripts/pkg/puller"
+)
+
+// GetDependentPackages returns the names of all packages that directly or transitively use the package with the given name as an upstream or a dependency
+func GetDependentPackages(packages []*Package, name string) ([]string, error) {
+	dependents := make(map[string]bool)
+	queue := []string{name}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, p := range packages {
+			if p.Name == name || dependents[p.Name] {
+				continue
+			}
+			dependsOn, err := p.DependsOnPackage(current)
+			if err != nil {
+				return nil, err
+			}
+			if dependsOn {
+				dependents[p.Name] = true
+				queue = append(queue, p.Name)
+			}
+		}
+	}
+	dependentNames := make([]string, 0, len(dependents))
+	for dependent := range dependents {
+		dependentNames = append(dependentNames, dependent)
+	}
+	sort.Strings(dependentNames)
+	return dependentNames, nil
+}
+
+// DependsOnPackage returns whether any chart in this package or any of their dependencies are sourced from the package with the given name
+func (p *Package) DependsOnPackage(name string) (bool, error) {
+	packageDependencies, err := p.GetPackageDependencies()
+	if err != nil {
+		return false, err
+	}
+	for _, dependency := range packageDependencies {
+		if dependency == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GetPackageDependencies returns the names of the packages that any chart in this package or any of their dependencies are sourced from
+func (p *Package) GetPackageDependencies() ([]string, error) {
+	var names []string
+	var addLocalPackage func(upstream puller.Puller)
+	addLocalPackage = func(upstream puller.Puller) {
+		if localPackage, ok := puller.Unwrap(upstream).(LocalPackage); ok {
+			names = append(names, localPackage.Name)
+		}
+		// Overlays may also be sourced from other packages
+		for _, overlay := range puller.GetOverlays(upstream) {
+			addLocalPackage(overlay.Puller)
+		}
+	}
+	addLocalPackage(p.Chart.Upstream)
+	gcRootDirs := []string{p.Chart.GeneratedChangesRootDir()}
+	for _, additionalChart := range p.AdditionalCharts {
+		if additionalChart.Upstream != nil {
+			addLocalPackage(*additionalChart.Upstream)
+		}
+		gcRootDirs = append(gcRootDirs, additionalChart.GeneratedChangesRootDir())
+	}
+	for _, gcRootDir := range gcRootDirs {
+		dependencyMap, err := GetDependencyMap(p.fs, gcRootDir)
+		if err != nil {
+			return nil, fmt.Errorf("Encountered error while trying to get dependencies of package %s: %s", p.Name, err)
+		}
+		for _, dependency := range dependencyMap {
+			addLocalPackage(dependency.Upstream)
+		}
+	}
+	return names, nil
+}
+
+// SplitIndependentPackages splits packages into those that can be processed concurrently and those that must be processed one at a time
+// Packages that are sourced from another package or that another of the packages is sourced from must be processed one at a time,
+// since preparing them may also prepare the packages they are sourced from. Both lists keep the order of packages
+func SplitIndependentPackages(packages []*Package) ([]*Package, []*Package, error) {
+	dependedOn := make(map[string]bool)
+	dependsOnAny := make(map[string]bool)
+	for _, p := range packages {
+		packageDependencies, err := p.GetPackageDependencies()
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, dependency := range packageDependencies {
+			dependedOn[dependency] = true
+			dependsOnAny[p.Name] = true
+		}
+	}
+	var independent, dependent []*Package
+	for _, p := range packages {
+		if dependedOn[p.Name] || dependsOnAny[p.Name] {
+			dependent = append(dependent, p)
+		} else {
+			independent = append(independent, p)
+		}
+	}
+	return independent, dependent, nil
+}
@@ -0,0 +1,84 @@
+package charts
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/change"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/helm"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	"github.com/rancher/charts-build-scripts/pkg/path"
+	"github.com/sirupsen/logrus"
+	helmLoader "helm.sh/helm/v3/pkg/chart/loader"
+)
+
+var (
+	// editionNameRegex matches valid names of editions, which are appended to the name of the chart
+	editionNameRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+)
+
+// validateEditions returns an error if any edition does not have a valid name or is defined more than once
+func validateEditions(editions []options.EditionOptions) error {
+	names := make(map[string]bool, len(editions))
+	for _, edition := range editions {
+		if !editionNameRegex.MatchString(edition.Name) {
+			return fmt.Errorf("Edition name %q is invalid: must consist of lower case alphanumeric characters or '-', and must start and end with an alphanumeric character", edition.Name)
+		}
+		if names[edition.Name] {
+			return fmt.Errorf("Edition %s is defined more than once", edition.Name)
+		}
+		names[edition.Name] = true
+	}
+	return nil
+}
+
+// GenerateEdition generates the edition of the prepared chart, named {chart}-{edition}, and stores it in the assets and charts directory
+// The edition is staged in a copy of the working directory, which has the values of the edition merged into its values.yaml
+func (c *Chart) GenerateEdition(rootFs billy.Filesystem, pkgFs billy.Filesystem, edition options.EditionOptions, chartVersion, supportTier string, annotations map[string]string, imageMirror *options.ImageMirrorOptions, packageAssetsDirpath, packageChartsDirpath string) error {
+	editionDir := c.EditionDir(edition.Name)
+	if err := filesystem.RemoveAll(pkgFs, editionDir); err != nil {
+		return fmt.Errorf("Encountered error while trying to clean up %s before staging edition: %s", editionDir, err)
+	}
+	if err := filesystem.CopyDir(pkgFs, c.WorkingDir, editionDir); err != nil {
+		return fmt.Errorf("Encountered error while trying to copy %s to %s: %s", c.WorkingDir, editionDir, err)
+	}
+	defer filesystem.RemoveAll(pkgFs, editionDir)
+	editionValuesPath := filepath.Join(path.PackageEditionsDir, edition.Name+".yaml")
+	exists, err := filesystem.PathExists(pkgFs, editionValuesPath)
+	if err != nil {
+		return fmt.Errorf("Encountered error while trying to check if %s exists: %s", editionValuesPath, err)
+	}
+	if exists {
+		logrus.Infof("Merging %s into the values of edition %s", editionValuesPath, edition.Name)
+		if err := change.ApplyStructuredPatch(pkgFs, editionValuesPath, filepath.Join(editionDir, path.ChartValuesFile)); err != nil {
+			return fmt.Errorf("Encountered error while trying to merge %s: %s", editionValuesPath, err)
+		}
+	}
+	chart, err := helmLoader.Load(filesystem.GetAbsPath(pkgFs, editionDir))
+	if err != nil {
+		return fmt.Errorf("Could not load Helm chart: %s", err)
+	}
+	if err := helm.SetChartName(pkgFs, editionDir, fmt.Sprintf("%s-%s", chart.Metadata.Name, edition.Name)); err != nil {
+		return err
+	}
+	editionAnnotations := make(map[string]string, len(annotations)+len(edition.Annotations)+1)
+	for annotation, val := range annotations {
+		editionAnnotations[annotation] = val
+	}
+	for annotation, val := range edition.Annotations {
+		editionAnnotations[annotation] = val
+	}
+	editionAnnotations[helm.EditionAnnotation] = edition.Name
+	if err := helm.ExportHelmChart(rootFs, pkgFs, editionDir, chartVersion, supportTier, editionAnnotations, imageMirror, packageAssetsDirpath, packageChartsDirpath); err != nil {
+		return fmt.Errorf("Encountered error while trying to export Helm chart for %s: %s", editionDir, err)
+	}
+	return nil
+}
+
+// EditionDir returns a working directory where we can stage an edition of the chart
+func (c *Chart) EditionDir(edition string) string {
+	return fmt.Sprintf("%s-edition-%s", c.WorkingDir, edition)
+}
@@ -0,0 +1,128 @@
+package charts
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/helm"
+	"github.com/rancher/charts-build-scripts/pkg/path"
+	helmChartutil "helm.sh/helm/v3/pkg/chartutil"
+)
+
+// Explanation represents the effective configuration of a package and the paths that the scripts read from and write to for it
+type Explanation struct {
+	// Source is the path within the repository of the file that defines the options of the package
+	Source string `yaml:"source"`
+	// Package is the configuration of the package after defaults are applied
+	Package *Package `yaml:"package"`
+	// ChartVersionSuffix is appended to the version of each chart when it is exported
+	ChartVersionSuffix string `yaml:"chartVersionSuffix"`
+	// Charts are the paths that the scripts use for each chart of the package
+	Charts []ChartExplanation `yaml:"charts"`
+}
+
+// ChartExplanation represents the paths within the repository that the scripts read from and write to for a chart
+type ChartExplanation struct {
+	// WorkingDir is where the chart is prepared
+	WorkingDir string `yaml:"workingDir"`
+	// Upstream is where the chart is pulled from
+	Upstream string `yaml:"upstream"`
+	// Prepared indicates whether the working directory currently exists
+	Prepared bool `yaml:"prepared"`
+	// OriginalDir is where the upstream chart is placed while generating changes
+	OriginalDir string `yaml:"originalDir"`
+	// GeneratedChangesRootDir is where the changes to the upstream chart are stored
+	GeneratedChangesRootDir string `yaml:"generatedChangesRootDir"`
+	// Exports are the paths that each chart generated from the working directory is exported to
+	Exports []ExportExplanation `yaml:"exports"`
+}
+
+// ExportExplanation represents where a chart is exported to
+// The name and version of the chart are only known once the chart is prepared, so they are left as placeholders otherwise
+type ExportExplanation struct {
+	// Name is the name of the exported chart
+	Name string `yaml:"name"`
+	// Version is the version of the exported chart
+	Version string `yaml:"version"`
+	// Asset is the path of the chart archive
+	Asset string `yaml:"asset"`
+	// Chart is the path of the unarchived chart
+	Chart string `yaml:"chart"`
+}
+
+// Explain returns the effective configuration of the package and the paths that the scripts read from and write to for it without modifying the repository
+func (p *Package) Explain() (*Explanation, error) {
+	source := filepath.Join(path.RepositoryPackagesDir, p.Name, path.PackageOptionsFile)
+	if p.aggregated {
+		source = filepath.Join(path.RepositoryPackagesDir, path.AggregatedPackageOptionsFile)
+	}
+	explanation := Explanation{
+		Source:             source,
+		Package:            p,
+		ChartVersionSuffix: fmt.Sprintf("%02d-rc%02d", p.PackageVersion, p.ReleaseCandidateVersion),
+	}
+	var editionNames []string
+	for _, edition := range p.Editions {
+		editionNames = append(editionNames, edition.Name)
+	}
+	mainChart, err := p.explainChart(p.Chart.WorkingDir, fmt.Sprint(p.Chart.Upstream), p.Chart.OriginalDir(), p.Chart.GeneratedChangesRootDir(), explanation.ChartVersionSuffix, editionNames)
+	if err != nil {
+		return nil, err
+	}
+	explanation.Charts = append(explanation.Charts, mainChart)
+	for _, additionalChart := range p.AdditionalCharts {
+		upstream := "CRD chart generated from the main chart"
+		if additionalChart.Upstream != nil {
+			upstream = fmt.Sprint(*additionalChart.Upstream)
+		}
+		chart, err := p.explainChart(additionalChart.WorkingDir, upstream, additionalChart.OriginalDir(), additionalChart.GeneratedChangesRootDir(), explanation.ChartVersionSuffix, nil)
+		if err != nil {
+			return nil, err
+		}
+		explanation.Charts = append(explanation.Charts, chart)
+	}
+	return &explanation, nil
+}
+
+// explainChart returns the paths that the scripts use for the chart in the working directory and each edition generated from it
+func (p *Package) explainChart(workingDir, upstream, originalDir, generatedChangesRootDir, chartVersionSuffix string, editionNames []string) (ChartExplanation, error) {
+	packageDir := filepath.Join(path.RepositoryPackagesDir, p.Name)
+	chart := ChartExplanation{
+		WorkingDir:              filepath.Join(packageDir, workingDir),
+		Upstream:                upstream,
+		OriginalDir:             filepath.Join(packageDir, originalDir),
+		GeneratedChangesRootDir: filepath.Join(packageDir, generatedChangesRootDir),
+	}
+	name, version := "{name}", "{version}"
+	chartYamlPath := filepath.Join(workingDir, helmChartutil.ChartfileName)
+	exists, err := filesystem.PathExists(p.fs, chartYamlPath)
+	if err != nil {
+		return chart, err
+	}
+	if exists {
+		metadata, err := helmChartutil.LoadChartfile(filesystem.GetAbsPath(p.fs, chartYamlPath))
+		if err != nil {
+			return chart, fmt.Errorf("Unable to load %s: %s", chartYamlPath, err)
+		}
+		chart.Prepared = true
+		name, version = metadata.Name, metadata.Version
+	}
+	version += chartVersionSuffix
+	packageAssetsDirpath := filepath.Join(path.RepositoryAssetsDir, p.Name)
+	packageChartsDirpath := filepath.Join(path.RepositoryChartsDir, p.Name)
+	for _, exportName := range append([]string{""}, editionNames...) {
+		if len(exportName) == 0 {
+			exportName = name
+		} else {
+			exportName = fmt.Sprintf("%s-%s", name, exportName)
+		}
+		chart.Exports = append(chart.Exports, ExportExplanation{
+			Name:    exportName,
+			Version: version,
+			Asset:   helm.GetAssetPath(packageAssetsDirpath, exportName, version),
+			Chart:   helm.GetChartPath(packageChartsDirpath, exportName, version),
+		})
+	}
+	return chart, nil
+}
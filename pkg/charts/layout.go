@@ -0,0 +1,199 @@
+package charts
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	"github.com/rancher/charts-build-scripts/pkg/path"
+	"github.com/sirupsen/logrus"
+)
+
+// ValidateGeneratedChangesLayout returns an error if a directory name of the layout is not a single path element or would collide with another directory within generated changes
+func ValidateGeneratedChangesLayout(layout options.GeneratedChangesLayoutOptions) error {
+	layout = withGeneratedChangesLayoutDefaults(layout)
+	reserved := map[string]string{
+		path.GeneratedChangesOverlayDir: "overlays",
+		path.GeneratedChangesExcludeDir: "excludes",
+		path.GeneratedChangesPatchDir:   "patches",
+	}
+	for _, dir := range []struct {
+		field string
+		name  string
+	}{
+		{"rootDir", layout.RootDir},
+		{"additionalChartsDir", layout.AdditionalChartsDir},
+		{"dependenciesDir", layout.DependenciesDir},
+	} {
+		if dir.name == "." || dir.name == ".." || strings.ContainsAny(dir.name, `/\`) {
+			return fmt.Errorf("%s %s is invalid: must be the name of a directory", dir.field, dir.name)
+		}
+		if contents, ok := reserved[dir.name]; ok {
+			return fmt.Errorf("%s %s is invalid: the directory already contains %s", dir.field, dir.name, contents)
+		}
+	}
+	if layout.AdditionalChartsDir == layout.DependenciesDir {
+		return fmt.Errorf("additionalChartsDir and dependenciesDir cannot both be %s", layout.AdditionalChartsDir)
+	}
+	return nil
+}
+
+// SetGeneratedChangesLayout configures the directories that contain the changes made to upstream charts within each package
+func SetGeneratedChangesLayout(layout options.GeneratedChangesLayoutOptions) {
+	layout = withGeneratedChangesLayoutDefaults(layout)
+	path.GeneratedChangesDir = layout.RootDir
+	path.GeneratedChangesAdditionalChartDir = layout.AdditionalChartsDir
+	path.GeneratedChangesDependenciesDir = layout.DependenciesDir
+	path.FlatAdditionalChartGeneratedChanges = layout.FlatAdditionalCharts
+}
+
+// getGeneratedChangesLayout returns the layout that is currently configured
+func getGeneratedChangesLayout() options.GeneratedChangesLayoutOptions {
+	return options.GeneratedChangesLayoutOptions{
+		RootDir:              path.GeneratedChangesDir,
+		AdditionalChartsDir:  path.GeneratedChangesAdditionalChartDir,
+		DependenciesDir:      path.GeneratedChangesDependenciesDir,
+		FlatAdditionalCharts: path.FlatAdditionalChartGeneratedChanges,
+	}
+}
+
+// withGeneratedChangesLayoutDefaults returns the layout with the default name of each directory that is not provided
+func withGeneratedChangesLayoutDefaults(layout options.GeneratedChangesLayoutOptions) options.GeneratedChangesLayoutOptions {
+	if len(layout.RootDir) == 0 {
+		layout.RootDir = path.DefaultGeneratedChangesDir
+	}
+	if len(layout.AdditionalChartsDir) == 0 {
+		layout.AdditionalChartsDir = path.DefaultGeneratedChangesAdditionalChartDir
+	}
+	if len(layout.DependenciesDir) == 0 {
+		layout.DependenciesDir = path.DefaultGeneratedChangesDependenciesDir
+	}
+	return layout
+}
+
+// getAdditionalChartGeneratedChangesRootDir returns the directory rooted at the package level where the generated changes of the additional chart at workingDir are found in the layout
+func getAdditionalChartGeneratedChangesRootDir(layout options.GeneratedChangesLayoutOptions, workingDir string) string {
+	additionalChartDir := filepath.Join(layout.RootDir, layout.AdditionalChartsDir, workingDir)
+	if layout.FlatAdditionalCharts {
+		return additionalChartDir
+	}
+	return filepath.Join(additionalChartDir, layout.RootDir)
+}
+
+// MigrateGeneratedChanges moves the generated changes of the package from the directories given by the from layout into those of the layout that is currently configured
+// Packages whose generated changes are not found in the from layout are left as is
+func (p *Package) MigrateGeneratedChanges(from options.GeneratedChangesLayoutOptions) error {
+	from = withGeneratedChangesLayoutDefaults(from)
+	to := getGeneratedChangesLayout()
+	if from == to {
+		return nil
+	}
+	if err := moveGeneratedChangesDir(p.fs, from.RootDir, to.RootDir); err != nil {
+		return err
+	}
+	if err := migrateDependencies(p.fs, to.RootDir, from, to); err != nil {
+		return err
+	}
+	fromAdditionalChartsDir := filepath.Join(to.RootDir, from.AdditionalChartsDir)
+	toAdditionalChartsDir := filepath.Join(to.RootDir, to.AdditionalChartsDir)
+	if err := moveGeneratedChangesDir(p.fs, fromAdditionalChartsDir, toAdditionalChartsDir); err != nil {
+		return err
+	}
+	for _, additionalChart := range p.AdditionalCharts {
+		// The additional charts directory has already been moved, so only the nesting within it is left to migrate
+		fromRootDir := filepath.Join(toAdditionalChartsDir, additionalChart.WorkingDir)
+		if !from.FlatAdditionalCharts {
+			fromRootDir = filepath.Join(fromRootDir, from.RootDir)
+		}
+		toRootDir := getAdditionalChartGeneratedChangesRootDir(to, additionalChart.WorkingDir)
+		if err := moveGeneratedChangesDir(p.fs, fromRootDir, toRootDir); err != nil {
+			return err
+		}
+		if err := migrateDependencies(p.fs, toRootDir, from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateDependencies moves the directory containing the dependencies within gcRootDir, along with the generated changes of each dependency, from the from layout into the to layout
+func migrateDependencies(fs billy.Filesystem, gcRootDir string, from, to options.GeneratedChangesLayoutOptions) error {
+	toDependenciesDir := filepath.Join(gcRootDir, to.DependenciesDir)
+	if err := moveGeneratedChangesDir(fs, filepath.Join(gcRootDir, from.DependenciesDir), toDependenciesDir); err != nil {
+		return err
+	}
+	exists, err := filesystem.PathExists(fs, toDependenciesDir)
+	if err != nil || !exists {
+		return err
+	}
+	fileInfos, err := fs.ReadDir(toDependenciesDir)
+	if err != nil {
+		return err
+	}
+	for _, fileInfo := range fileInfos {
+		if !fileInfo.IsDir() {
+			continue
+		}
+		dependencyDir := filepath.Join(toDependenciesDir, fileInfo.Name())
+		// Dependencies keep the generated changes of their own dependencies within a RootDir of their own
+		dependencyRootDir := filepath.Join(dependencyDir, to.RootDir)
+		if err := moveGeneratedChangesDir(fs, filepath.Join(dependencyDir, from.RootDir), dependencyRootDir); err != nil {
+			return err
+		}
+		if err := migrateDependencies(fs, dependencyRootDir, from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveGeneratedChangesDir moves fromDir to toDir, doing nothing if fromDir does not exist or is the same as toDir
+// Either directory may be nested within the other, e.g. when the generated changes of an additional chart are flattened
+func moveGeneratedChangesDir(fs billy.Filesystem, fromDir, toDir string) error {
+	if fromDir == toDir {
+		return nil
+	}
+	exists, err := filesystem.PathExists(fs, fromDir)
+	if err != nil || !exists {
+		return err
+	}
+	fromDirWithinToDir := strings.HasPrefix(fromDir, toDir+"/")
+	exists, err = filesystem.PathExists(fs, toDir)
+	if err != nil {
+		return err
+	}
+	if exists && !fromDirWithinToDir {
+		return fmt.Errorf("Unable to move %s to %s: destination already exists", fromDir, toDir)
+	}
+	// Move through a temporary directory outside of both so that either can be within the other
+	tempDir := fmt.Sprintf(".%s.migrating", filepath.Base(fromDir))
+	if err := fs.Rename(fromDir, tempDir); err != nil {
+		return err
+	}
+	if fromDirWithinToDir {
+		if err := filesystem.PruneEmptyDirsInPath(fs, fromDir); err != nil {
+			return err
+		}
+		exists, err := filesystem.PathExists(fs, toDir)
+		if err != nil {
+			return err
+		}
+		if exists {
+			if err := fs.Rename(tempDir, fromDir); err != nil {
+				return err
+			}
+			return fmt.Errorf("Unable to move %s to %s: destination contains other files", fromDir, toDir)
+		}
+	}
+	if err := fs.MkdirAll(filepath.Dir(toDir), 0755); err != nil {
+		return err
+	}
+	if err := fs.Rename(tempDir, toDir); err != nil {
+		return err
+	}
+	logrus.Infof("Moved %s to %s", fromDir, toDir)
+	return nil
+}
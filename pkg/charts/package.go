@@ -3,6 +3,7 @@ package charts
 import (
 	"fmt"
 	"path/filepath"
+	"strings"
 
 	"github.com/go-git/go-billy/v5"
 	"github.com/rancher/charts-build-scripts/pkg/change"
@@ -10,7 +11,9 @@ import (
 	"github.com/rancher/charts-build-scripts/pkg/helm"
 	"github.com/rancher/charts-build-scripts/pkg/options"
 	"github.com/rancher/charts-build-scripts/pkg/path"
+	"github.com/rancher/charts-build-scripts/pkg/puller"
 	"github.com/sirupsen/logrus"
+	helmLoader "helm.sh/helm/v3/pkg/chart/loader"
 )
 
 // Package represents the configuration of a particular forked Helm chart
@@ -25,16 +28,90 @@ type Package struct {
 	ReleaseCandidateVersion int `yaml:"releaseCandidateVersion"`
 	// AdditionalCharts are other charts that should be packaged together with this
 	AdditionalCharts []AdditionalChart `yaml:"additionalCharts,omitempty"`
+	// ValuesLintSuppressions are violations of the values lint rules that should be ignored for this package
+	ValuesLintSuppressions []options.ValuesLintSuppression `yaml:"valuesLintSuppressions,omitempty"`
+	// HelmLintSuppressions are messages reported by helm lint against the charts of this package that should be ignored
+	HelmLintSuppressions []options.HelmLintSuppression `yaml:"helmLintSuppressions,omitempty"`
+	// SecretScanSuppressions are hardcoded credentials found in the rendered manifests of this package that should be ignored
+	SecretScanSuppressions []options.SecretScanSuppression `yaml:"secretScanSuppressions,omitempty"`
+	// Owner is the team or person responsible for maintaining this package
+	Owner string `yaml:"owner,omitempty"`
+	// SupportTier is the level of support offered for the charts in this package: supported, community, or experimental
+	SupportTier string `yaml:"supportTier,omitempty"`
+	// Labels are arbitrary key-value pairs that packages can be selected by, e.g. team: monitoring
+	Labels map[string]string `yaml:"labels,omitempty"`
+	// Annotations are added to the Chart.yaml of the main chart and every edition of it exported from this package
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+	// AcknowledgedAnnotationChanges are intended changes to critical annotations of the charts in this package, keyed by the name of the annotation with its new value
+	AcknowledgedAnnotationChanges map[string]string `yaml:"acknowledgedAnnotationChanges,omitempty"`
+	// ImageMirror is how the images referenced by the charts in this package are rewritten to point at a mirror registry on export
+	ImageMirror *options.ImageMirrorOptions `yaml:"imageMirror,omitempty"`
+	// Editions are variants of the main chart that are exported as charts of their own
+	Editions []options.EditionOptions `yaml:"editions,omitempty"`
 
 	// fs is a filesystem rooted at the package
 	fs billy.Filesystem
 	// rootFs is a filesystem rooted at the repository containing the package
 	rootFs billy.Filesystem
+	// aggregated indicates that the package is defined in the aggregated package options rather than a package.yaml of its own
+	aggregated bool
 }
 
 // Prepare pulls in a package based on the spec to the local git repository
+// If the package was already prepared from the same pinned upstreams and its working directories have not been modified since,
+// the prepare is skipped if the changes are also the same or only the changes are re-applied otherwise
 func (p *Package) Prepare() error {
-	if err := p.Chart.Prepare(p.rootFs, p.fs); err != nil {
+	state, err := p.getPrepareState()
+	if err != nil {
+		return fmt.Errorf("Encountered error while trying to get the state of package %s: %s", p.Name, err)
+	}
+	var reapplyChanges bool
+	if state != nil {
+		preparedState, err := p.loadPrepareState()
+		if err != nil {
+			return fmt.Errorf("Encountered error while trying to load %s: %s", path.PackagePrepareStateFile, err)
+		}
+		if preparedState != nil && preparedState.Upstreams == state.Upstreams {
+			workingDirsDigest, err := p.getWorkingDirsDigest()
+			if err != nil {
+				return fmt.Errorf("Encountered error while trying to get the state of the working directories of package %s: %s", p.Name, err)
+			}
+			if workingDirsDigest == preparedState.WorkingDirs {
+				if preparedState.Changes == state.Changes {
+					logrus.Infof("Package %s is already prepared", p.Name)
+					return nil
+				}
+				logrus.Infof("Only the changes of package %s were modified since it was last prepared, re-applying changes", p.Name)
+				reapplyChanges = true
+			}
+		}
+	}
+	if err := filesystem.RemoveAll(p.fs, path.PackagePrepareStateFile); err != nil {
+		return fmt.Errorf("Encountered error while trying to remove %s: %s", path.PackagePrepareStateFile, err)
+	}
+	if err := p.prepare(reapplyChanges); err != nil {
+		return err
+	}
+	if state == nil {
+		return nil
+	}
+	state.WorkingDirs, err = p.getWorkingDirsDigest()
+	if err != nil {
+		return fmt.Errorf("Encountered error while trying to get the state of the working directories of package %s: %s", p.Name, err)
+	}
+	if err := p.savePrepareState(*state); err != nil {
+		return fmt.Errorf("Encountered error while trying to save %s: %s", path.PackagePrepareStateFile, err)
+	}
+	return nil
+}
+
+// prepare pulls in a package, only re-applying the changes onto the upstream pulled by the last prepare of the main chart if reapplyChanges is set
+func (p *Package) prepare(reapplyChanges bool) error {
+	prepareMainChart := p.Chart.Prepare
+	if reapplyChanges {
+		prepareMainChart = p.Chart.ReapplyChanges
+	}
+	if err := prepareMainChart(p.rootFs, p.fs); err != nil {
 		return fmt.Errorf("Encountered error while preparing main chart: %s", err)
 	}
 	if p.Chart.Upstream.IsWithinPackage() {
@@ -52,14 +129,40 @@ func (p *Package) Prepare() error {
 			}
 		}
 	}
+	crdChartTemplateData, err := p.getCRDChartTemplateData()
+	if err != nil {
+		return fmt.Errorf("Encountered error while trying to get the metadata to render CRD chart templates with: %s", err)
+	}
 	for _, additionalChart := range p.AdditionalCharts {
-		if err := additionalChart.Prepare(p.rootFs, p.fs); err != nil {
+		if err := additionalChart.Prepare(p.rootFs, p.fs, crdChartTemplateData); err != nil {
 			return fmt.Errorf("Encountered error while preparing additional chart %s: %s", additionalChart.WorkingDir, err)
 		}
 		if err := additionalChart.ApplyMainChanges(p.fs); err != nil {
 			return fmt.Errorf("Encountered error while applying main changes from %s to main chart: %s", additionalChart.WorkingDir, err)
 		}
 	}
+	return p.migrateAPIVersionV1Charts()
+}
+
+// migrateAPIVersionV1Charts converts each prepared chart in the package that still uses apiVersion v1 to apiVersion v2, since it cannot be exported otherwise
+// The conversion is only recorded in the generated changes of the package once a patch is generated
+func (p *Package) migrateAPIVersionV1Charts() error {
+	if helm.AllowAPIVersionV1 {
+		return nil
+	}
+	workingDirs, err := p.getPreparedWorkingDirs()
+	if err != nil {
+		return err
+	}
+	for _, workingDir := range workingDirs {
+		migrated, err := helm.MigrateChartToAPIVersionV2(p.fs, workingDir)
+		if err != nil {
+			return fmt.Errorf("Encountered error while migrating %s to apiVersion v2: %s", workingDir, err)
+		}
+		if migrated {
+			logrus.Warnf("%s/%s was converted from apiVersion v1 to apiVersion v2; run patch or migrate-api-version to record the conversion in the generated changes", p.Name, workingDir)
+		}
+	}
 	return nil
 }
 
@@ -84,6 +187,501 @@ func (p *Package) GeneratePatch() error {
 	return nil
 }
 
+// MergePatch rebases the local changes of the main chart onto its current upstream and generates patches on the package
+// It returns an error listing any conflicts, which must be resolved in the working directory before generating the patch again
+func (p *Package) MergePatch() error {
+	for _, additionalChart := range p.AdditionalCharts {
+		if err := additionalChart.RevertMainChanges(p.fs); err != nil {
+			return fmt.Errorf("Encountered error while reverting changes from %s to main chart: %s", additionalChart.WorkingDir, err)
+		}
+	}
+	conflicts, err := p.Chart.MergePatch(p.rootFs, p.fs)
+	if err != nil {
+		return fmt.Errorf("Encountered error while merging patch on main chart: %s", err)
+	}
+	for _, additionalChart := range p.AdditionalCharts {
+		if err := additionalChart.ApplyMainChanges(p.fs); err != nil {
+			return fmt.Errorf("Encountered error while applying main changes from %s to main chart: %s", additionalChart.WorkingDir, err)
+		}
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("Found %d conflicts while merging changes onto the upstream of package %s; resolve them and run patch again:\n  %s", len(conflicts), p.Name, strings.Join(conflicts, "\n  "))
+	}
+	for _, additionalChart := range p.AdditionalCharts {
+		if err := additionalChart.GeneratePatch(p.rootFs, p.fs); err != nil {
+			return fmt.Errorf("Encountered error while generating patch on additional chart %s: %s", additionalChart.WorkingDir, err)
+		}
+	}
+	return nil
+}
+
+// ExportPatches applies the patches of the main chart onto a clone of its upstream repository on a new branch
+func (p *Package) ExportPatches(branch string) error {
+	if p.Chart.Upstream.IsWithinPackage() {
+		return fmt.Errorf("Package %s does not have an upstream to export patches to", p.Name)
+	}
+	absUpstreamDir, err := p.Chart.ExportPatches(p.fs, branch)
+	if err != nil {
+		return fmt.Errorf("Encountered error while exporting patches of main chart: %s", err)
+	}
+	if len(p.AdditionalCharts) > 0 {
+		logrus.Warnf("Patches of additional charts in package %s are not exported", p.Name)
+	}
+	logrus.Infof("Applied patches onto branch %s in %s; review and commit the changes to propose them upstream", branch, absUpstreamDir)
+	return nil
+}
+
+// ApplyConventions prepares the package, applies the conventions of Rancher feature charts to the main chart, and records them in the generated changes
+func (p *Package) ApplyConventions() error {
+	if p.Chart.Upstream.IsWithinPackage() {
+		return fmt.Errorf("Package %s does not have an upstream chart to apply conventions to", p.Name)
+	}
+	if err := p.Prepare(); err != nil {
+		return err
+	}
+	if err := helm.ApplyRancherConventions(p.fs, p.Chart.WorkingDir); err != nil {
+		return fmt.Errorf("Encountered error while applying conventions to main chart: %s", err)
+	}
+	return p.GeneratePatch()
+}
+
+// MigrateAPIVersion prepares the package, which converts each of its charts that uses apiVersion v1 to apiVersion v2, and records the conversion in the generated changes
+func (p *Package) MigrateAPIVersion() error {
+	if err := p.Prepare(); err != nil {
+		return err
+	}
+	return p.GeneratePatch()
+}
+
+// CheckSupportTier prepares the package and checks each of its charts against the rules of its support tier before cleaning it up
+func (p *Package) CheckSupportTier() error {
+	if len(p.SupportTier) == 0 {
+		return nil
+	}
+	if err := p.Prepare(); err != nil {
+		return fmt.Errorf("Encountered error while trying to prepare package: %s", err)
+	}
+	checkErr := p.checkSupportTier()
+	if err := p.Clean(); err != nil {
+		return fmt.Errorf("Encountered error while trying to clean package: %s", err)
+	}
+	return checkErr
+}
+
+// checkSupportTier checks each prepared chart in the package against the rules of its support tier
+func (p *Package) checkSupportTier() error {
+	workingDirs, err := p.getPreparedWorkingDirs()
+	if err != nil {
+		return err
+	}
+	var numViolations int
+	for _, workingDir := range workingDirs {
+		var annotations map[string]string
+		if workingDir == p.Chart.WorkingDir {
+			// Annotations of the package only describe its main chart
+			annotations = p.Annotations
+		}
+		violations, err := helm.CheckSupportTier(p.fs, workingDir, p.SupportTier, p.Owner, annotations)
+		if err != nil {
+			return fmt.Errorf("Encountered error while checking support tier of %s: %s", workingDir, err)
+		}
+		for _, violation := range violations {
+			logrus.Errorf("%s/%s: %s", p.Name, workingDir, violation)
+		}
+		numViolations += len(violations)
+	}
+	if numViolations > 0 {
+		return fmt.Errorf("Found %d violations of the rules of the %s support tier in package %s", numViolations, p.SupportTier, p.Name)
+	}
+	return nil
+}
+
+// CheckImagePlatforms prepares the package and checks that the OS and architecture annotations declared by each of its charts
+// are supported by the images of the chart before cleaning it up
+func (p *Package) CheckImagePlatforms() error {
+	if err := p.Prepare(); err != nil {
+		return fmt.Errorf("Encountered error while trying to prepare package: %s", err)
+	}
+	checkErr := p.checkImagePlatforms()
+	if err := p.Clean(); err != nil {
+		return fmt.Errorf("Encountered error while trying to clean package: %s", err)
+	}
+	return checkErr
+}
+
+// checkImagePlatforms checks the OS and architecture annotations of each prepared chart in the package against the platforms of its images
+func (p *Package) checkImagePlatforms() error {
+	workingDirs, err := p.getPreparedWorkingDirs()
+	if err != nil {
+		return err
+	}
+	var numMismatches int
+	for _, workingDir := range workingDirs {
+		mismatches, err := helm.CheckImagePlatforms(p.fs, workingDir)
+		if err != nil {
+			return fmt.Errorf("Encountered error while checking image platforms of %s: %s", workingDir, err)
+		}
+		for _, mismatch := range mismatches {
+			logrus.Errorf("%s/%s: %s", p.Name, workingDir, mismatch)
+		}
+		numMismatches += len(mismatches)
+	}
+	if numMismatches > 0 {
+		return fmt.Errorf("Found %d platforms declared by the charts of package %s that are not supported by their images", numMismatches, p.Name)
+	}
+	return nil
+}
+
+// LintValues lints the values.yaml of each prepared chart in the package against the rules in lintOptions
+func (p *Package) LintValues(lintOptions options.ValuesLintOptions) error {
+	workingDirs, err := p.getPreparedWorkingDirs()
+	if err != nil {
+		return err
+	}
+	var numViolations int
+	for _, workingDir := range workingDirs {
+		violations, err := helm.LintValuesFile(p.fs, workingDir, lintOptions, p.ValuesLintSuppressions)
+		if err != nil {
+			return fmt.Errorf("Encountered error while linting values of %s: %s", workingDir, err)
+		}
+		for _, violation := range violations {
+			logrus.Errorf("%s/%s: %s", p.Name, workingDir, violation)
+		}
+		numViolations += len(violations)
+	}
+	if numViolations > 0 {
+		return fmt.Errorf("Found %d values lint violations in package %s", numViolations, p.Name)
+	}
+	return nil
+}
+
+// CheckTemplateFunctions checks that the templates of each prepared chart in the package only call the template functions permitted by templateFunctionOptions
+func (p *Package) CheckTemplateFunctions(templateFunctionOptions options.TemplateFunctionOptions) error {
+	workingDirs, err := p.getPreparedWorkingDirs()
+	if err != nil {
+		return err
+	}
+	var numViolations int
+	for _, workingDir := range workingDirs {
+		violations, err := helm.CheckTemplateFunctions(p.fs, workingDir, templateFunctionOptions)
+		if err != nil {
+			return fmt.Errorf("Encountered error while checking template functions of %s: %s", workingDir, err)
+		}
+		for _, violation := range violations {
+			logrus.Errorf("%s/%s", p.Name, violation)
+		}
+		numViolations += len(violations)
+	}
+	if numViolations > 0 {
+		return fmt.Errorf("Found %d calls to template functions that are not allowed in package %s", numViolations, p.Name)
+	}
+	return nil
+}
+
+// LintCharts prepares the package and runs helm lint against each of its charts and every exported version of each of its charts before cleaning it up
+func (p *Package) LintCharts(helmLintOptions options.HelmLintOptions) error {
+	if err := p.Prepare(); err != nil {
+		return fmt.Errorf("Encountered error while trying to prepare package: %s", err)
+	}
+	lintErr := p.lintCharts(helmLintOptions)
+	if err := p.Clean(); err != nil {
+		return fmt.Errorf("Encountered error while trying to clean package: %s", err)
+	}
+	return lintErr
+}
+
+// lintCharts runs helm lint against each prepared chart in the package and every exported version of each chart of the package, if any
+func (p *Package) lintCharts(helmLintOptions options.HelmLintOptions) error {
+	workingDirs, err := p.getPreparedWorkingDirs()
+	if err != nil {
+		return err
+	}
+	var messages []helm.ChartLintMessage
+	for _, workingDir := range workingDirs {
+		messages = append(messages, helm.LintChart(p.fs, workingDir, helmLintOptions.Strict, p.HelmLintSuppressions)...)
+	}
+	exportedCharts, err := helm.GetExportedCharts(p.rootFs, filepath.Join(path.RepositoryChartsDir, p.Name))
+	if err != nil {
+		return fmt.Errorf("Encountered error while trying to find the exported charts of package %s: %s", p.Name, err)
+	}
+	for _, exportedChart := range exportedCharts {
+		messages = append(messages, helm.LintChart(p.rootFs, exportedChart.Path, helmLintOptions.Strict, p.HelmLintSuppressions)...)
+	}
+	var numFailures int
+	for _, message := range messages {
+		if !message.Failure {
+			logrus.Warnf("%s/%s", p.Name, message)
+			continue
+		}
+		logrus.Errorf("%s/%s", p.Name, message)
+		numFailures++
+	}
+	if numFailures > 0 {
+		return fmt.Errorf("Found %d helm lint failures in package %s", numFailures, p.Name)
+	}
+	return nil
+}
+
+// CheckSecrets prepares the package and checks the manifests rendered from the default values of each of its charts for hardcoded credentials
+// before cleaning it up. Hardcoded credentials are logged as warnings or fail the check, depending on the policy
+func (p *Package) CheckSecrets(policy string) error {
+	if err := helm.ValidateSecretScanPolicy(policy); err != nil {
+		return err
+	}
+	if err := p.Prepare(); err != nil {
+		return fmt.Errorf("Encountered error while trying to prepare package: %s", err)
+	}
+	checkErr := p.checkSecrets(policy)
+	if err := p.Clean(); err != nil {
+		return fmt.Errorf("Encountered error while trying to clean package: %s", err)
+	}
+	return checkErr
+}
+
+// checkSecrets checks the manifests rendered from the default values of each prepared chart in the package for hardcoded credentials
+func (p *Package) checkSecrets(policy string) error {
+	workingDirs, err := p.getPreparedWorkingDirs()
+	if err != nil {
+		return err
+	}
+	var numSecrets int
+	for _, workingDir := range workingDirs {
+		secrets, err := helm.FindHardcodedSecrets(p.fs, workingDir, p.SecretScanSuppressions)
+		if err != nil {
+			return fmt.Errorf("Encountered error while scanning %s for hardcoded credentials: %s", workingDir, err)
+		}
+		for _, secret := range secrets {
+			if policy == helm.SecretScanPolicyWarn {
+				logrus.Warnf("%s/%s/%s", p.Name, workingDir, secret)
+				continue
+			}
+			logrus.Errorf("%s/%s/%s", p.Name, workingDir, secret)
+		}
+		numSecrets += len(secrets)
+	}
+	if numSecrets > 0 && policy == helm.SecretScanPolicyFail {
+		return fmt.Errorf("Found %d hardcoded credentials in the rendered manifests of package %s", numSecrets, p.Name)
+	}
+	return nil
+}
+
+// ValidateManifests prepares the package and validates the manifests rendered from the default values of each of its charts against the schemas
+// of their kinds in each configured Kubernetes version before cleaning it up
+func (p *Package) ValidateManifests(manifestValidationOptions options.ManifestValidationOptions) error {
+	if err := p.Prepare(); err != nil {
+		return fmt.Errorf("Encountered error while trying to prepare package: %s", err)
+	}
+	validateErr := p.validateManifests(manifestValidationOptions)
+	if err := p.Clean(); err != nil {
+		return fmt.Errorf("Encountered error while trying to clean package: %s", err)
+	}
+	return validateErr
+}
+
+// validateManifests validates the manifests rendered from the default values of each prepared chart in the package against the schemas of their kinds
+func (p *Package) validateManifests(manifestValidationOptions options.ManifestValidationOptions) error {
+	workingDirs, err := p.getPreparedWorkingDirs()
+	if err != nil {
+		return err
+	}
+	var numViolations int
+	for _, workingDir := range workingDirs {
+		logrus.Infof("Validating manifests of %s/%s against Kubernetes %s", p.Name, workingDir, strings.Join(manifestValidationOptions.KubeVersions, ", "))
+		violations, err := helm.ValidateManifests(p.fs, workingDir, manifestValidationOptions)
+		if err != nil {
+			return fmt.Errorf("Encountered error while validating manifests of %s: %s", workingDir, err)
+		}
+		for _, violation := range violations {
+			logrus.Errorf("%s/%s", p.Name, violation)
+		}
+		numViolations += len(violations)
+	}
+	if numViolations > 0 {
+		return fmt.Errorf("Found %d manifests that do not match the Kubernetes schemas in package %s", numViolations, p.Name)
+	}
+	return nil
+}
+
+// GetValuesDrift prepares the package and compares the default values of its main chart against those of its upstream before cleaning it up
+// If previousUpstream is not nil, the values of the upstream are also compared against those of previousUpstream to find the keys it added and removed
+// It returns nil if the main chart is local, since it has no upstream to drift from
+func (p *Package) GetValuesDrift(previousUpstream puller.Puller) (*helm.ValuesDrift, error) {
+	if p.Chart.Upstream.IsWithinPackage() {
+		return nil, nil
+	}
+	if err := p.Prepare(); err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to prepare package: %s", err)
+	}
+	drift, driftErr := p.getValuesDrift(previousUpstream)
+	if err := p.Clean(); err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to clean package: %s", err)
+	}
+	return drift, driftErr
+}
+
+// getValuesDrift compares the default values of the prepared main chart against those of its upstream and previousUpstream
+func (p *Package) getValuesDrift(previousUpstream puller.Puller) (*helm.ValuesDrift, error) {
+	ours, err := helm.ReadValues(p.fs, p.Chart.WorkingDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Chart.Upstream.Pull(p.rootFs, p.fs, p.Chart.OriginalDir()); err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to pull upstream into %s: %s", p.Chart.OriginalDir(), err)
+	}
+	upstream, err := helm.ReadValues(p.fs, p.Chart.OriginalDir())
+	if err != nil {
+		return nil, err
+	}
+	var previous map[string]interface{}
+	if previousUpstream != nil {
+		if err := previousUpstream.Pull(p.rootFs, p.fs, p.Chart.PreviousDir()); err != nil {
+			return nil, fmt.Errorf("Encountered error while trying to pull previous upstream into %s: %s", p.Chart.PreviousDir(), err)
+		}
+		previous, err = helm.ReadValues(p.fs, p.Chart.PreviousDir())
+		if err != nil {
+			return nil, err
+		}
+	}
+	drift := helm.GetValuesDrift(ours, upstream, previous)
+	return &drift, nil
+}
+
+// GetChartVersions prepares the package and returns the version of each of its charts, keyed by the name of the chart, before cleaning it up
+// The version of each exported chart is formed by appending the packageVersion and releaseCandidateVersion to these versions
+func (p *Package) GetChartVersions() (map[string]string, error) {
+	if err := p.Prepare(); err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to prepare package: %s", err)
+	}
+	chartVersions, versionsErr := p.getChartVersions()
+	if err := p.Clean(); err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to clean package: %s", err)
+	}
+	return chartVersions, versionsErr
+}
+
+// getChartVersions returns the version of each prepared chart in the package, keyed by the name of the chart
+func (p *Package) getChartVersions() (map[string]string, error) {
+	workingDirs, err := p.getPreparedWorkingDirs()
+	if err != nil {
+		return nil, err
+	}
+	chartVersions := make(map[string]string, len(workingDirs))
+	for _, workingDir := range workingDirs {
+		chart, err := helmLoader.Load(filesystem.GetAbsPath(p.fs, workingDir))
+		if err != nil {
+			return nil, fmt.Errorf("Encountered error while trying to load chart in %s: %s", workingDir, err)
+		}
+		chartVersions[chart.Metadata.Name] = chart.Metadata.Version
+	}
+	return chartVersions, nil
+}
+
+// RenderCharts prepares the package and renders each of its charts against every render profile before cleaning it up
+func (p *Package) RenderCharts(profiles []options.RenderProfile) error {
+	if err := p.Prepare(); err != nil {
+		return fmt.Errorf("Encountered error while trying to prepare package: %s", err)
+	}
+	renderErr := p.renderCharts(profiles)
+	if err := p.Clean(); err != nil {
+		return fmt.Errorf("Encountered error while trying to clean package: %s", err)
+	}
+	return renderErr
+}
+
+// renderCharts renders each prepared chart in the package against every render profile
+func (p *Package) renderCharts(profiles []options.RenderProfile) error {
+	workingDirs, err := p.getPreparedWorkingDirs()
+	if err != nil {
+		return err
+	}
+	for _, workingDir := range workingDirs {
+		for _, profile := range profiles {
+			logrus.Infof("Rendering %s/%s against Kubernetes %s", p.Name, workingDir, profile.KubeVersion)
+			if err := helm.RenderChart(p.fs, workingDir, profile); err != nil {
+				return fmt.Errorf("Encountered error while rendering %s: %s", workingDir, err)
+			}
+		}
+	}
+	return nil
+}
+
+// RunUnitTests runs the unit tests found in the tests directory of the package against its prepared charts
+// It returns the number of tests that were run
+func (p *Package) RunUnitTests() (int, error) {
+	exists, err := filesystem.PathExists(p.fs, path.PackageTestsDir)
+	if err != nil {
+		return 0, fmt.Errorf("Encountered error while trying to check if %s exists: %s", path.PackageTestsDir, err)
+	}
+	if !exists {
+		return 0, nil
+	}
+	workingDirs, err := p.getPreparedWorkingDirs()
+	if err != nil {
+		return 0, err
+	}
+	var numTests, numFailures int
+	err = filesystem.WalkDir(p.fs, path.PackageTestsDir, func(fs billy.Filesystem, suitePath string, isDir bool) error {
+		if isDir || (filepath.Ext(suitePath) != ".yaml" && filepath.Ext(suitePath) != ".yml") {
+			return nil
+		}
+		suite, err := helm.LoadTestSuite(fs, suitePath)
+		if err != nil {
+			return err
+		}
+		workingDir := suite.WorkingDir
+		if len(workingDir) == 0 {
+			workingDir = p.Chart.WorkingDir
+		}
+		if !containsWorkingDir(workingDirs, workingDir) {
+			return fmt.Errorf("Test suite %s targets working directory %s, which is not a chart in package %s", suitePath, workingDir, p.Name)
+		}
+		logrus.Infof("Running test suite %s against %s/%s", suite.Suite, p.Name, workingDir)
+		failures, err := helm.RunTestSuite(fs, workingDir, suite)
+		if err != nil {
+			return err
+		}
+		for _, failure := range failures {
+			logrus.Errorf("%s: %s", p.Name, failure)
+		}
+		numTests += len(suite.Tests)
+		numFailures += len(failures)
+		return nil
+	})
+	if err != nil {
+		return numTests, fmt.Errorf("Encountered error while running unit tests: %s", err)
+	}
+	if numFailures > 0 {
+		return numTests, fmt.Errorf("Found %d failed assertions in package %s", numFailures, p.Name)
+	}
+	return numTests, nil
+}
+
+// containsWorkingDir returns whether workingDir is one of the workingDirs
+func containsWorkingDir(workingDirs []string, workingDir string) bool {
+	for _, w := range workingDirs {
+		if w == workingDir {
+			return true
+		}
+	}
+	return false
+}
+
+// getPreparedWorkingDirs returns the working directories of all charts in the package or an error if any of them have not been prepared
+func (p *Package) getPreparedWorkingDirs() ([]string, error) {
+	workingDirs := []string{p.Chart.WorkingDir}
+	for _, additionalChart := range p.AdditionalCharts {
+		workingDirs = append(workingDirs, additionalChart.WorkingDir)
+	}
+	for _, workingDir := range workingDirs {
+		if exists, err := filesystem.PathExists(p.fs, workingDir); err != nil {
+			return nil, fmt.Errorf("Encountered error while trying to check if %s exists: %s", workingDir, err)
+		} else if !exists {
+			return nil, fmt.Errorf("Working directory %s has not been prepared yet", workingDir)
+		}
+	}
+	return workingDirs, nil
+}
+
 // GenerateCharts creates Helm chart archives for each chart after preparing it
 func (p *Package) GenerateCharts() error {
 	if err := p.Prepare(); err != nil {
@@ -94,12 +692,22 @@ func (p *Package) GenerateCharts() error {
 	packageChartsDirpath := filepath.Join(path.RepositoryChartsDir, p.Name)
 	// Add the ReleaseCandidateVersion to the PackageVersion and format
 	chartVersion := fmt.Sprintf("%02d-rc%02d", p.PackageVersion, p.ReleaseCandidateVersion)
-	err := p.Chart.GenerateChart(p.rootFs, p.fs, chartVersion, packageAssetsDirpath, packageChartsDirpath)
+	if err := p.checkAnnotationChanges(chartVersion, packageChartsDirpath); err != nil {
+		return err
+	}
+	err := p.Chart.GenerateChart(p.rootFs, p.fs, chartVersion, p.SupportTier, p.Annotations, p.ImageMirror, packageAssetsDirpath, packageChartsDirpath)
 	if err != nil {
 		return fmt.Errorf("Encountered error while exporting main chart: %s", err)
 	}
+	for _, edition := range p.Editions {
+		err = p.Chart.GenerateEdition(p.rootFs, p.fs, edition, chartVersion, p.SupportTier, p.Annotations, p.ImageMirror, packageAssetsDirpath, packageChartsDirpath)
+		if err != nil {
+			return fmt.Errorf("Encountered error while exporting edition %s of main chart: %s", edition.Name, err)
+		}
+	}
 	for _, additionalChart := range p.AdditionalCharts {
-		err = additionalChart.GenerateChart(p.rootFs, p.fs, chartVersion, packageAssetsDirpath, packageChartsDirpath)
+		// Annotations of the package only describe its main chart
+		err = additionalChart.GenerateChart(p.rootFs, p.fs, chartVersion, p.SupportTier, nil, p.ImageMirror, packageAssetsDirpath, packageChartsDirpath)
 		if err != nil {
 			return fmt.Errorf("Encountered error while exporting %s: %s", additionalChart.WorkingDir, err)
 		}
@@ -110,6 +718,85 @@ func (p *Package) GenerateCharts() error {
 	return p.Clean()
 }
 
+// GenerateChartsIfChanged generates the charts of the package unless its inputs and the charts generated from them are the same as those recorded by its last build
+// Packages with an upstream that is not pinned are always generated. It returns whether the charts were generated
+func (p *Package) GenerateChartsIfChanged() (bool, error) {
+	inputsDigest, err := p.getBuildInputsDigest()
+	if err != nil {
+		return false, fmt.Errorf("Encountered error while trying to get the inputs of package %s: %s", p.Name, err)
+	}
+	if len(inputsDigest) > 0 {
+		builtState, err := p.loadBuildState()
+		if err != nil {
+			return false, fmt.Errorf("Encountered error while trying to load %s: %s", path.PackageBuildStateFile, err)
+		}
+		if builtState != nil && builtState.Inputs == inputsDigest {
+			outputsDigest, err := p.getBuildOutputsDigest()
+			if err != nil {
+				return false, fmt.Errorf("Encountered error while trying to get the outputs of package %s: %s", p.Name, err)
+			}
+			if outputsDigest == builtState.Outputs {
+				logrus.Infof("Package %s has not changed since its charts were last generated", p.Name)
+				return false, nil
+			}
+			logrus.Infof("Charts generated for package %s were modified since they were last generated, regenerating charts", p.Name)
+		}
+	}
+	if err := filesystem.RemoveAll(p.fs, path.PackageBuildStateFile); err != nil {
+		return false, fmt.Errorf("Encountered error while trying to remove %s: %s", path.PackageBuildStateFile, err)
+	}
+	if err := p.GenerateCharts(); err != nil {
+		return false, err
+	}
+	if len(inputsDigest) == 0 {
+		return true, nil
+	}
+	outputsDigest, err := p.getBuildOutputsDigest()
+	if err != nil {
+		return false, fmt.Errorf("Encountered error while trying to get the outputs of package %s: %s", p.Name, err)
+	}
+	if err := p.saveBuildState(BuildState{Inputs: inputsDigest, Outputs: outputsDigest}); err != nil {
+		return false, fmt.Errorf("Encountered error while trying to save %s: %s", path.PackageBuildStateFile, err)
+	}
+	return true, nil
+}
+
+// checkAnnotationChanges checks whether any prepared chart in the package changes a critical annotation from the version of the chart that precedes it
+// Changes that are not acknowledged by the package are logged, or fail the check if helm.BlockAnnotationChanges is set
+func (p *Package) checkAnnotationChanges(chartVersion, packageChartsDirpath string) error {
+	workingDirs, err := p.getPreparedWorkingDirs()
+	if err != nil {
+		return err
+	}
+	var numUnacknowledged int
+	for _, workingDir := range workingDirs {
+		var annotations map[string]string
+		if workingDir == p.Chart.WorkingDir {
+			annotations = p.Annotations
+		}
+		changes, err := helm.GetAnnotationChanges(p.rootFs, p.fs, workingDir, chartVersion, annotations, packageChartsDirpath)
+		if err != nil {
+			return fmt.Errorf("Encountered error while checking for changes to critical annotations in %s: %s", workingDir, err)
+		}
+		for _, change := range changes {
+			if val, ok := p.AcknowledgedAnnotationChanges[change.Annotation]; ok && val == change.Current {
+				logrus.Infof("%s/%s (acknowledged)", p.Name, change)
+				continue
+			}
+			numUnacknowledged++
+			if !helm.BlockAnnotationChanges {
+				logrus.Warnf("%s/%s", p.Name, change)
+				continue
+			}
+			logrus.Errorf("%s/%s", p.Name, change)
+		}
+	}
+	if numUnacknowledged > 0 && helm.BlockAnnotationChanges {
+		return fmt.Errorf("Found %d changes to critical annotations in package %s that are not acknowledged by acknowledgedAnnotationChanges in its package.yaml", numUnacknowledged, p.Name)
+	}
+	return nil
+}
+
 // GenerateRebasePatch creates a patch on the upstream provided in the RebasePackageOptionsFile
 func (p *Package) GenerateRebasePatch() error {
 	exists, err := filesystem.PathExists(p.fs, path.RebasePackageOptionsFile)
@@ -150,7 +837,7 @@ func (p *Package) GenerateRebasePatch() error {
 	}
 	// Generate the patch
 	gcRootDir := filepath.Join(path.GeneratedChangesDir, "rebase", path.GeneratedChangesDir)
-	if err := change.GenerateChanges(p.fs, p.Chart.WorkingDir, r.WorkingDir, gcRootDir); err != nil {
+	if err := change.GenerateChanges(p.fs, p.Chart.WorkingDir, r.WorkingDir, gcRootDir, p.Chart.StructuredPatches); err != nil {
 		return fmt.Errorf("Encountered error while generating changes from %s to %s and placing it in %s: %s", p.Chart.WorkingDir, r.WorkingDir, gcRootDir, err)
 	}
 	return nil
@@ -158,7 +845,10 @@ func (p *Package) GenerateRebasePatch() error {
 
 // Clean removes all other files except for the package.yaml, patch, and overlay/ files from a package
 func (p *Package) Clean() error {
-	chartPathsToClean := []string{p.Chart.OriginalDir()}
+	chartPathsToClean := []string{p.Chart.OriginalDir(), p.Chart.BaseDir(), p.Chart.PreviousDir(), p.Chart.PristineDir(), p.Chart.NextPristineDir(), path.PackagePrepareStateFile}
+	for _, edition := range p.Editions {
+		chartPathsToClean = append(chartPathsToClean, p.Chart.EditionDir(edition.Name))
+	}
 	if !p.Chart.Upstream.IsWithinPackage() {
 		chartPathsToClean = append(chartPathsToClean, p.Chart.WorkingDir)
 	} else {
@@ -200,5 +890,12 @@ func (p *Package) Clean() error {
 			return fmt.Errorf("Encountered error while trying to prune directory in path %s: %s", rebasePathToClean, err)
 		}
 	}
+	if p.aggregated {
+		// Remove the directory that was created for the package on prepare if nothing else is stored in it
+		packageRoot := filepath.Join(path.RepositoryPackagesDir, p.Name)
+		if err := filesystem.PruneEmptyDirsInPath(p.rootFs, packageRoot); err != nil {
+			return fmt.Errorf("Encountered error while trying to prune directory in path %s: %s", packageRoot, err)
+		}
+	}
 	return nil
 }
@@ -0,0 +1,123 @@
+package charts
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	"github.com/rancher/charts-build-scripts/pkg/path"
+)
+
+const (
+	// packageVersionRegexFmt is the format of a regex that matches the packageVersion of a package indented by the provided indentation
+	packageVersionRegexFmt = `(?m)^%spackageVersion:[ \t]*(\d+)[ \t]*$`
+)
+
+var (
+	// indentRegex matches the indentation of the first line that is neither empty nor a comment
+	indentRegex = regexp.MustCompile(`(?m)^([ \t]*)[^ \t\r\n#]`)
+	// topLevelKeyRegex matches any line that starts a new top-level key of a YAML file
+	topLevelKeyRegex = regexp.MustCompile(`(?m)^[^ \t\r\n#]`)
+)
+
+// LoadPackageOptions returns the options of the package from its package.yaml or, if it does not have one, from its entry in the aggregated package options of the repository at rootFs
+func LoadPackageOptions(rootFs billy.Filesystem, name string) (options.PackageOptions, error) {
+	aggregatedPackageOptions, err := loadAggregatedPackageOptions(rootFs)
+	if err != nil {
+		return options.PackageOptions{}, err
+	}
+	pkgFs, err := rootFs.Chroot(filepath.Join(path.RepositoryPackagesDir, name))
+	if err != nil {
+		return options.PackageOptions{}, err
+	}
+	return getPackageOptions(pkgFs, name, aggregatedPackageOptions)
+}
+
+// GetPackageOptionsPath returns the path within the repository at rootFs of the file that the package is defined in and whether it is the aggregated package options
+func GetPackageOptionsPath(rootFs billy.Filesystem, name string) (string, bool, error) {
+	aggregatedPackageOptions, err := loadAggregatedPackageOptions(rootFs)
+	if err != nil {
+		return "", false, err
+	}
+	if _, aggregated := aggregatedPackageOptions[name]; aggregated {
+		return filepath.Join(path.RepositoryPackagesDir, path.AggregatedPackageOptionsFile), true, nil
+	}
+	return filepath.Join(path.RepositoryPackagesDir, name, path.PackageOptionsFile), false, nil
+}
+
+// EditPackageOptions replaces the options of the package in the file it is defined in with the result of edit without reformatting the rest of the file
+// For packages defined in the aggregated package options, edit is only given the entry of the package, whose keys are indented by indent
+func EditPackageOptions(rootFs billy.Filesystem, name string, edit func(contents []byte, indent string) ([]byte, error)) error {
+	packageOptionsPath, aggregated, err := GetPackageOptionsPath(rootFs, name)
+	if err != nil {
+		return err
+	}
+	absPackageOptionsPath := filesystem.GetAbsPath(rootFs, packageOptionsPath)
+	packageOptionsBytes, err := ioutil.ReadFile(absPackageOptionsPath)
+	if err != nil {
+		return err
+	}
+	start, end := 0, len(packageOptionsBytes)
+	var indent string
+	if aggregated {
+		start, end, err = getAggregatedPackageOptionsRange(packageOptionsBytes, name)
+		if err != nil {
+			return fmt.Errorf("Unable to find package %s in %s: %s", name, packageOptionsPath, err)
+		}
+		indent = "  "
+		if match := indentRegex.FindSubmatch(packageOptionsBytes[start:end]); match != nil && len(match[1]) > 0 {
+			indent = string(match[1])
+		}
+	}
+	edited, err := edit(packageOptionsBytes[start:end], indent)
+	if err != nil {
+		return fmt.Errorf("Unable to edit package %s in %s: %s", name, packageOptionsPath, err)
+	}
+	var buf bytes.Buffer
+	buf.Write(packageOptionsBytes[:start])
+	buf.Write(edited)
+	buf.Write(packageOptionsBytes[end:])
+	return ioutil.WriteFile(absPackageOptionsPath, buf.Bytes(), 0644)
+}
+
+// getAggregatedPackageOptionsRange returns the range of the contents of the aggregated package options that holds the options of the package, excluding the line of its name
+func getAggregatedPackageOptionsRange(aggregatedPackageOptionsBytes []byte, name string) (int, int, error) {
+	nameRegex := regexp.MustCompile(fmt.Sprintf(`(?m)^["']?%s["']?:[ \t]*(?:#.*)?\r?\n`, regexp.QuoteMeta(name)))
+	match := nameRegex.FindIndex(aggregatedPackageOptionsBytes)
+	if match == nil {
+		return 0, 0, fmt.Errorf("the options of the package must be a block under its name")
+	}
+	start := match[1]
+	end := len(aggregatedPackageOptionsBytes)
+	if next := topLevelKeyRegex.FindIndex(aggregatedPackageOptionsBytes[start:]); next != nil {
+		end = start + next[0]
+	}
+	return start, end, nil
+}
+
+// UpdatePackageVersion sets the packageVersion of the package to the result of update on its current packageVersion without reformatting the rest of the file it is defined in
+// The packageVersion defaults to 0 if it is not provided
+func UpdatePackageVersion(rootFs billy.Filesystem, name string, update func(packageVersion int) int) error {
+	return EditPackageOptions(rootFs, name, func(contents []byte, indent string) ([]byte, error) {
+		packageVersionRegex := regexp.MustCompile(fmt.Sprintf(packageVersionRegexFmt, indent))
+		match := packageVersionRegex.FindSubmatchIndex(contents)
+		if match == nil {
+			return append([]byte(fmt.Sprintf("%spackageVersion: %d\n", indent, update(0))), contents...), nil
+		}
+		packageVersion, err := strconv.Atoi(string(contents[match[2]:match[3]]))
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		buf.Write(contents[:match[2]])
+		buf.WriteString(strconv.Itoa(update(packageVersion)))
+		buf.Write(contents[match[3]:])
+		return buf.Bytes(), nil
+	})
+}
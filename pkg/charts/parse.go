@@ -4,10 +4,13 @@ import (
 	"errors"
 	"fmt"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/go-git/go-billy/v5"
 	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/helm"
 	"github.com/rancher/charts-build-scripts/pkg/options"
 	"github.com/rancher/charts-build-scripts/pkg/path"
 	"github.com/rancher/charts-build-scripts/pkg/puller"
@@ -18,12 +21,22 @@ var (
 	ErrRemoteDoesNotExist = errors.New("Repository does not have any matching remotes")
 )
 
+const (
+	// PackageRegexPrefix is the prefix of a package selection that is matched as a regular expression against the name of each package
+	PackageRegexPrefix = "regex:"
+)
+
 // GetPackages returns all packages found within the repository. If there is a specific package provided, it will return just that Package in the list
+// The specific package may also be a glob, e.g. rancher-monitoring*, or a regular expression prefixed by PackageRegexPrefix, in which case every matching package is returned
 func GetPackages(repoRoot string, specificPackage string) ([]*Package, error) {
 	var packages []*Package
 	rootFs := filesystem.GetFilesystem(repoRoot)
-	if len(specificPackage) != 0 {
-		pkg, err := GetPackage(rootFs, specificPackage)
+	aggregatedPackageOptions, err := loadAggregatedPackageOptions(rootFs)
+	if err != nil {
+		return nil, err
+	}
+	if len(specificPackage) != 0 && !isPackagePattern(specificPackage) {
+		pkg, err := getPackage(rootFs, specificPackage, aggregatedPackageOptions)
 		if err != nil {
 			return nil, err
 		}
@@ -32,6 +45,10 @@ func GetPackages(repoRoot string, specificPackage string) ([]*Package, error) {
 		}
 		return packages, nil
 	}
+	matchesName, err := GetPackageNameMatcher(specificPackage)
+	if err != nil {
+		return nil, err
+	}
 	exists, err := filesystem.PathExists(rootFs, path.RepositoryPackagesDir)
 	if err != nil {
 		return nil, err
@@ -43,12 +60,25 @@ func GetPackages(repoRoot string, specificPackage string) ([]*Package, error) {
 	if err != nil {
 		return nil, err
 	}
+	names := make(map[string]bool, len(fileInfos)+len(aggregatedPackageOptions))
 	for _, fileInfo := range fileInfos {
-		if !fileInfo.IsDir() {
+		if fileInfo.IsDir() {
+			names[fileInfo.Name()] = true
+		}
+	}
+	for name := range aggregatedPackageOptions {
+		names[name] = true
+	}
+	sortedNames := make([]string, 0, len(names))
+	for name := range names {
+		sortedNames = append(sortedNames, name)
+	}
+	sort.Strings(sortedNames)
+	for _, name := range sortedNames {
+		if !matchesName(name) {
 			continue
 		}
-		name := fileInfo.Name()
-		pkg, err := GetPackage(rootFs, name)
+		pkg, err := getPackage(rootFs, name, aggregatedPackageOptions)
 		if err != nil {
 			return nil, err
 		}
@@ -59,39 +89,93 @@ func GetPackages(repoRoot string, specificPackage string) ([]*Package, error) {
 	return packages, nil
 }
 
+// isPackagePattern returns whether the package selection is a glob or a regular expression rather than the name of a package
+func isPackagePattern(specificPackage string) bool {
+	return strings.HasPrefix(specificPackage, PackageRegexPrefix) || strings.ContainsAny(specificPackage, "*?[")
+}
+
+// GetPackageNameMatcher returns a function that returns whether the name of a package matches the package selection
+// An empty package selection matches every package
+func GetPackageNameMatcher(specificPackage string) (func(name string) bool, error) {
+	if len(specificPackage) == 0 {
+		return func(name string) bool { return true }, nil
+	}
+	if strings.HasPrefix(specificPackage, PackageRegexPrefix) {
+		packageRegex, err := regexp.Compile(strings.TrimPrefix(specificPackage, PackageRegexPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid regular expression in package selection %s: %s", specificPackage, err)
+		}
+		return packageRegex.MatchString, nil
+	}
+	if _, err := filepath.Match(specificPackage, ""); err != nil {
+		return nil, fmt.Errorf("Invalid glob in package selection %s: %s", specificPackage, err)
+	}
+	return func(name string) bool {
+		matched, _ := filepath.Match(specificPackage, name)
+		return matched
+	}, nil
+}
+
 // GetPackage returns a Package based on the options provided
 func GetPackage(rootFs billy.Filesystem, name string) (*Package, error) {
+	aggregatedPackageOptions, err := loadAggregatedPackageOptions(rootFs)
+	if err != nil {
+		return nil, err
+	}
+	return getPackage(rootFs, name, aggregatedPackageOptions)
+}
+
+// getPackage returns a Package based on the options provided in its package.yaml or its entry in the aggregated package options
+func getPackage(rootFs billy.Filesystem, name string, aggregatedPackageOptions map[string]options.PackageOptions) (*Package, error) {
 	// Get pkgFs
 	packageRoot := filepath.Join(path.RepositoryPackagesDir, name)
 	exists, err := filesystem.PathExists(rootFs, packageRoot)
 	if err != nil {
 		return nil, err
 	}
-	if !exists {
+	_, aggregated := aggregatedPackageOptions[name]
+	if !exists && !aggregated {
 		return nil, nil
 	}
+	// Packages defined in the aggregated package options do not need a directory of their own until they are prepared
 	pkgFs, err := rootFs.Chroot(packageRoot)
 	if err != nil {
 		return nil, err
 	}
-	// Get package options from package.yaml
-	packageOpt, err := options.LoadPackageOptionsFromFile(pkgFs, path.PackageOptionsFile)
+	packageOpt, err := getPackageOptions(pkgFs, name, aggregatedPackageOptions)
 	if err != nil {
 		return nil, err
 	}
+	if err := helm.ValidateSupportTier(packageOpt.SupportTier); err != nil {
+		return nil, fmt.Errorf("Encountered error while parsing package %s: %s", name, err)
+	}
+	if err := helm.ValidateImageMirror(packageOpt.ImageMirror); err != nil {
+		return nil, fmt.Errorf("Encountered error while parsing package %s: %s", name, err)
+	}
+	if err := helm.ValidateHelmLintSuppressions(packageOpt.HelmLintSuppressions); err != nil {
+		return nil, fmt.Errorf("Encountered error while parsing package %s: %s", name, err)
+	}
+	if err := validateEditions(packageOpt.Editions); err != nil {
+		return nil, fmt.Errorf("Encountered error while parsing package %s: %s", name, err)
+	}
 	// Get charts
 	chart, err := GetChartFromOptions(packageOpt.MainChartOptions)
 	if err != nil {
 		return nil, err
 	}
+	chart.StructuredPatches = packageOpt.StructuredPatches
 	var additionalCharts []AdditionalChart
 	for _, additionalChartOptions := range packageOpt.AdditionalChartOptions {
 		additionalChart, err := GetAdditionalChartFromOptions(additionalChartOptions)
 		if err != nil {
 			return nil, err
 		}
+		additionalChart.StructuredPatches = packageOpt.StructuredPatches
 		additionalCharts = append(additionalCharts, additionalChart)
 	}
+	if err := validateCRDCharts(additionalCharts); err != nil {
+		return nil, err
+	}
 	p := Package{
 		Chart: chart,
 
@@ -99,13 +183,62 @@ func GetPackage(rootFs billy.Filesystem, name string) (*Package, error) {
 		PackageVersion:          packageOpt.PackageVersion,
 		AdditionalCharts:        additionalCharts,
 		ReleaseCandidateVersion: packageOpt.ReleaseCandidateVersion,
+		ValuesLintSuppressions:  packageOpt.ValuesLintSuppressions,
+		HelmLintSuppressions:    packageOpt.HelmLintSuppressions,
+		SecretScanSuppressions:  packageOpt.SecretScanSuppressions,
+		Owner:                   packageOpt.Owner,
+		SupportTier:             packageOpt.SupportTier,
+		Labels:                  packageOpt.Labels,
+		Annotations:             packageOpt.Annotations,
 
-		fs:     pkgFs,
-		rootFs: rootFs,
+		AcknowledgedAnnotationChanges: packageOpt.AcknowledgedAnnotationChanges,
+		ImageMirror:                   packageOpt.ImageMirror,
+		Editions:                      packageOpt.Editions,
+
+		fs:         pkgFs,
+		rootFs:     rootFs,
+		aggregated: aggregated,
 	}
 	return &p, nil
 }
 
+// loadAggregatedPackageOptions returns the options of every package defined in the aggregated package options file of the repository, keyed by the name of each package
+func loadAggregatedPackageOptions(rootFs billy.Filesystem) (map[string]options.PackageOptions, error) {
+	aggregatedPackageOptionsPath := filepath.Join(path.RepositoryPackagesDir, path.AggregatedPackageOptionsFile)
+	aggregatedPackageOptions, err := options.LoadAggregatedPackageOptionsFromFile(rootFs, aggregatedPackageOptionsPath)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load package options from %s: %s", aggregatedPackageOptionsPath, err)
+	}
+	return aggregatedPackageOptions, nil
+}
+
+// loadPackageOptions returns the options of the package rooted at pkgFs
+func loadPackageOptions(pkgFs billy.Filesystem) (options.PackageOptions, error) {
+	absPackageRoot := filesystem.GetAbsPath(pkgFs, "")
+	aggregatedPackageOptions, err := loadAggregatedPackageOptions(filesystem.GetFilesystem(filepath.Dir(filepath.Dir(absPackageRoot))))
+	if err != nil {
+		return options.PackageOptions{}, err
+	}
+	return getPackageOptions(pkgFs, filepath.Base(absPackageRoot), aggregatedPackageOptions)
+}
+
+// getPackageOptions returns the options of the package rooted at pkgFs from its package.yaml or, if it does not have one, from its entry in the aggregated package options
+func getPackageOptions(pkgFs billy.Filesystem, name string, aggregatedPackageOptions map[string]options.PackageOptions) (options.PackageOptions, error) {
+	exists, err := filesystem.PathExists(pkgFs, path.PackageOptionsFile)
+	if err != nil {
+		return options.PackageOptions{}, err
+	}
+	packageOpt, aggregated := aggregatedPackageOptions[name]
+	if !aggregated {
+		return options.LoadPackageOptionsFromFile(pkgFs, path.PackageOptionsFile)
+	}
+	if exists {
+		return options.PackageOptions{}, fmt.Errorf("Package %s cannot be defined in both %s and %s", name,
+			filepath.Join(path.RepositoryPackagesDir, name, path.PackageOptionsFile), filepath.Join(path.RepositoryPackagesDir, path.AggregatedPackageOptionsFile))
+	}
+	return packageOpt, nil
+}
+
 // GetChartFromOptions returns a Chart based on the options provided
 func GetChartFromOptions(opt options.ChartOptions) (Chart, error) {
 	upstream, err := GetUpstream(opt.UpstreamOptions)
@@ -156,17 +289,66 @@ func GetAdditionalChartFromOptions(opt options.AdditionalChartOptions) (Addition
 		if len(templateDirectory) == 0 {
 			return a, fmt.Errorf("CRD options must provide a template directory")
 		}
+		if err := ValidateCRDChartVersionScheme(opt.CRDChartOptions.VersionScheme); err != nil {
+			return a, err
+		}
+		if err := ValidateCRDSelector(opt.CRDChartOptions.Selector); err != nil {
+			return a, err
+		}
+		if err := ValidateCRDUpgradeJob(opt.CRDChartOptions.UpgradeJob, crdDirectory); err != nil {
+			return a, err
+		}
 		a.CRDChartOptions = &options.CRDChartOptions{
 			TemplateDirectory:           templateDirectory,
 			CRDDirectory:                crdDirectory,
+			RenderTemplate:              opt.CRDChartOptions.RenderTemplate,
 			AddCRDValidationToMainChart: opt.CRDChartOptions.AddCRDValidationToMainChart,
+			KeepCRDsInMainChart:         opt.CRDChartOptions.KeepCRDsInMainChart,
+			AddManagedByMetadataToCRDs:  opt.CRDChartOptions.AddManagedByMetadataToCRDs,
+			CRDTransformTemplate:        opt.CRDChartOptions.CRDTransformTemplate,
+			NameSuffix:                  opt.CRDChartOptions.NameSuffix,
+			Description:                 opt.CRDChartOptions.Description,
+			Annotations:                 opt.CRDChartOptions.Annotations,
+			VersionScheme:               opt.CRDChartOptions.VersionScheme,
+			Selector:                    opt.CRDChartOptions.Selector,
+			UpgradeJob:                  opt.CRDChartOptions.UpgradeJob,
 		}
 	}
+	if opt.MainChartDependencyOptions != nil && len(opt.MainChartDependencyOptions.Tags) > 0 && !opt.MainChartDependencyOptions.Vendor {
+		return a, fmt.Errorf("Tags of mainChartDependency of %s can only be provided if the chart is vendored into the main chart", opt.WorkingDir)
+	}
+	a.MainChartDependencyOptions = opt.MainChartDependencyOptions
 	return a, nil
 }
 
 // GetUpstream returns the appropriate Upstream given the options provided
 func GetUpstream(opt options.UpstreamOptions) (puller.Puller, error) {
+	upstream, err := getUpstream(opt)
+	if err != nil {
+		return nil, err
+	}
+	if len(opt.Include) > 0 || len(opt.Exclude) > 0 {
+		upstream, err = puller.GetPartial(upstream, opt.Include, opt.Exclude)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(opt.Overlays) == 0 {
+		return upstream, nil
+	}
+	var overlays []puller.Overlay
+	for _, overlayOptions := range opt.Overlays {
+		overlay, err := GetUpstream(overlayOptions.UpstreamOptions)
+		if err != nil {
+			return nil, fmt.Errorf("Encountered error while parsing overlay %s: %s", overlayOptions.URL, err)
+		}
+		overlays = append(overlays, puller.Overlay{Puller: overlay, Path: overlayOptions.Path})
+	}
+	return puller.GetLayered(upstream, overlays)
+}
+
+// getUpstream returns the Upstream that pulls the entire chart given the options provided
+func getUpstream(opt options.UpstreamOptions) (puller.Puller, error) {
 	if opt.URL == "" {
 		return nil, fmt.Errorf("URL is not defined")
 	}
@@ -183,21 +365,69 @@ func GetUpstream(opt options.UpstreamOptions) (puller.Puller, error) {
 		}
 		return upstream, nil
 	}
+	if opt.ChartName != nil {
+		upstream := puller.HelmRepository{
+			URL:          opt.URL,
+			ChartName:    *opt.ChartName,
+			Subdirectory: opt.Subdirectory,
+		}
+		if opt.Version != nil {
+			upstream.Version = *opt.Version
+		}
+		return upstream, nil
+	}
+	if strings.HasPrefix(opt.URL, puller.LocalPathURLPrefix) {
+		upstream, err := puller.GetLocalPath(opt.URL, opt.Subdirectory)
+		if err != nil {
+			return nil, err
+		}
+		return upstream, nil
+	}
+	if strings.HasPrefix(opt.URL, puller.OCIChartURLPrefix) {
+		upstream := puller.OCIChart{
+			URL:          opt.URL,
+			Subdirectory: opt.Subdirectory,
+		}
+		return upstream, nil
+	}
+	if strings.HasPrefix(opt.URL, puller.ContainerImageURLPrefix) {
+		upstream := puller.ContainerImage{
+			URL:          opt.URL,
+			Subdirectory: opt.Subdirectory,
+		}
+		return upstream, nil
+	}
+	if strings.HasSuffix(opt.URL, ".bundle") {
+		upstream := puller.GitBundle{
+			URL:          opt.URL,
+			Subdirectory: opt.Subdirectory,
+			Commit:       opt.Commit,
+		}
+		return upstream, nil
+	}
 	if strings.HasSuffix(opt.URL, ".git") {
+		if !puller.IsGithubURL(opt.URL) {
+			upstream, err := puller.GetGitRepository(opt, nil)
+			if err != nil {
+				return nil, err
+			}
+			return upstream, nil
+		}
 		upstream, err := puller.GetGithubRepository(opt, nil)
 		if err != nil {
 			return nil, err
 		}
 		return upstream, nil
 	}
-	if strings.HasSuffix(opt.URL, ".tgz") || strings.Contains(opt.URL, ".tar.gz") {
+	if strings.HasSuffix(opt.URL, ".tgz") || strings.Contains(opt.URL, ".tar.gz") || puller.IsZipURL(opt.URL) {
 		upstream := puller.Archive{
-			URL: opt.URL,
+			URL:      opt.URL,
+			Checksum: opt.Checksum,
 		}
 		if opt.Subdirectory != nil {
 			upstream.Subdirectory = opt.Subdirectory
 		}
 		return upstream, nil
 	}
-	return nil, fmt.Errorf("URL is invalid (must contain .git or .tgz)")
+	return nil, fmt.Errorf("URL is invalid (must start with %s, %s, or %s, contain .git, .bundle, .tgz, or .zip, or point to a Helm repository along with a chartName)", puller.LocalPathURLPrefix, puller.OCIChartURLPrefix, puller.ContainerImageURLPrefix)
 }
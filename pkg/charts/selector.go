@@ -0,0 +1,78 @@
+package charts
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LabelSelector represents requirements on the labels of a package, all of which must be met for the package to be selected
+type LabelSelector []LabelRequirement
+
+// LabelRequirement represents a requirement on a single label of a package
+type LabelRequirement struct {
+	// Key is the key of the label
+	Key string
+	// Value is the value that the label must have, or must not have if Negated is set. If empty, only the presence of the label is checked
+	Value string
+	// Negated indicates that the label must not have Value, or must not exist if Value is empty
+	Negated bool
+}
+
+// ParseLabelSelector parses a comma-separated list of requirements, each of which is one of key=value, key!=value, key, or !key
+func ParseLabelSelector(selector string) (LabelSelector, error) {
+	var labelSelector LabelSelector
+	for _, requirement := range strings.Split(selector, ",") {
+		requirement = strings.TrimSpace(requirement)
+		if len(requirement) == 0 {
+			continue
+		}
+		var r LabelRequirement
+		switch {
+		case strings.Contains(requirement, "!="):
+			parts := strings.SplitN(requirement, "!=", 2)
+			r = LabelRequirement{Key: parts[0], Value: parts[1], Negated: true}
+		case strings.Contains(requirement, "="):
+			parts := strings.SplitN(strings.Replace(requirement, "==", "=", 1), "=", 2)
+			r = LabelRequirement{Key: parts[0], Value: parts[1]}
+		case strings.HasPrefix(requirement, "!"):
+			r = LabelRequirement{Key: strings.TrimPrefix(requirement, "!"), Negated: true}
+		default:
+			r = LabelRequirement{Key: requirement}
+		}
+		r.Key = strings.TrimSpace(r.Key)
+		r.Value = strings.TrimSpace(r.Value)
+		if len(r.Key) == 0 || strings.ContainsAny(r.Key, "=!") {
+			return nil, fmt.Errorf("Invalid requirement %s in label selector %s", requirement, selector)
+		}
+		labelSelector = append(labelSelector, r)
+	}
+	return labelSelector, nil
+}
+
+// Matches returns whether the labels meet every requirement of the selector
+func (s LabelSelector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		value, ok := labels[r.Key]
+		var matched bool
+		if len(r.Value) == 0 {
+			matched = ok
+		} else {
+			matched = ok && value == r.Value
+		}
+		if matched == r.Negated {
+			return false
+		}
+	}
+	return true
+}
+
+// FilterPackages returns the packages whose labels match the selector
+func FilterPackages(packages []*Package, selector LabelSelector) []*Package {
+	var filtered []*Package
+	for _, p := range packages {
+		if selector.Matches(p.Labels) {
+			filtered = append(filtered, p)
+		}
+	}
+	return filtered
+}
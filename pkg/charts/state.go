@@ -0,0 +1,258 @@
+package charts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/helm"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	"github.com/rancher/charts-build-scripts/pkg/path"
+	"github.com/rancher/charts-build-scripts/pkg/puller"
+	"gopkg.in/yaml.v2"
+)
+
+// PrepareState represents the inputs and the result of the last prepare of a package
+type PrepareState struct {
+	// Upstreams is a digest of the upstreams of every chart in the package
+	Upstreams string `yaml:"upstreams"`
+	// Changes is a digest of the package options and overlay of the package and the generated changes, CRD chart templates, and local dependencies of every chart in the package
+	Changes string `yaml:"changes"`
+	// WorkingDirs is a digest of the working directories of every chart in the package after it was prepared
+	WorkingDirs string `yaml:"workingDirs"`
+}
+
+// BuildState represents the inputs and the outputs of the last build of the charts of a package
+type BuildState struct {
+	// Inputs is a digest of the upstreams, package options, changes, local charts, and export settings of the package
+	Inputs string `yaml:"inputs"`
+	// Outputs is a digest of the assets and charts generated for the package
+	Outputs string `yaml:"outputs"`
+}
+
+// getPrepareState returns the inputs of a prepare of the package
+// It returns nil if the main chart is local or any upstream is not pinned, since the working directories may then be outdated even if no input has changed
+func (p *Package) getPrepareState() (*PrepareState, error) {
+	if p.Chart.Upstream.IsWithinPackage() {
+		return nil, nil
+	}
+	upstreamsDigest, pinned, err := p.getUpstreamsDigest()
+	if err != nil || !pinned {
+		return nil, err
+	}
+	changesDigest, err := p.getChangesDigest()
+	if err != nil {
+		return nil, err
+	}
+	return &PrepareState{
+		Upstreams: upstreamsDigest,
+		Changes:   changesDigest,
+	}, nil
+}
+
+// getUpstreamsDigest returns a digest of the upstreams of every chart in the package
+// It also returns whether every upstream is pinned, since the digest does not identify the contents of an upstream that is not
+func (p *Package) getUpstreamsDigest() (string, bool, error) {
+	upstreams := []puller.Puller{p.Chart.Upstream}
+	for _, additionalChart := range p.AdditionalCharts {
+		if additionalChart.Upstream != nil {
+			upstreams = append(upstreams, *additionalChart.Upstream)
+		}
+	}
+	upstreamsHash := sha256.New()
+	for _, upstream := range upstreams {
+		if !isPinned(upstream) {
+			return "", false, nil
+		}
+		upstreamOptionsBytes, err := yaml.Marshal(upstream.GetOptions())
+		if err != nil {
+			return "", false, err
+		}
+		fmt.Fprintf(upstreamsHash, "%s\n%s\n", upstream, upstreamOptionsBytes)
+	}
+	return hex.EncodeToString(upstreamsHash.Sum(nil)), true, nil
+}
+
+// getChangesDigest returns a digest of everything other than the upstreams that the charts of the package are prepared from, along with the additional paths within the package provided
+// It covers the options and overlay of the package, the generated changes and CRD chart template of every chart, and the local dependencies of every chart
+func (p *Package) getChangesDigest(additionalPackagePaths ...string) (string, error) {
+	packagePaths := []string{path.PackageOptionsFile, path.PackageOverlayDir, p.Chart.GeneratedChangesRootDir()}
+	for _, additionalChart := range p.AdditionalCharts {
+		packagePaths = append(packagePaths, additionalChart.GeneratedChangesRootDir())
+		if additionalChart.CRDChartOptions != nil {
+			packagePaths = append(packagePaths, filepath.Join(path.PackageTemplatesDir, additionalChart.CRDChartOptions.TemplateDirectory))
+		}
+	}
+	// Local dependencies are built from charts within the repository, so those charts are inputs as well
+	localDependencyPaths, localDependencyPackages, err := p.getLocalDependencies()
+	if err != nil {
+		return "", err
+	}
+	packagePaths = append(packagePaths, localDependencyPaths...)
+	packagePaths = append(packagePaths, additionalPackagePaths...)
+	packageDigest, err := filesystem.GetDigest(p.fs, packagePaths...)
+	if err != nil {
+		return "", err
+	}
+	repositoryPaths := localDependencyPackages
+	if p.aggregated {
+		repositoryPaths = append(repositoryPaths, filepath.Join(path.RepositoryPackagesDir, path.AggregatedPackageOptionsFile))
+	}
+	repositoryDigest, err := filesystem.GetDigest(p.rootFs, repositoryPaths...)
+	if err != nil {
+		return "", err
+	}
+	changesHash := sha256.New()
+	fmt.Fprintf(changesHash, "%s\n%s\n", packageDigest, repositoryDigest)
+	return hex.EncodeToString(changesHash.Sum(nil)), nil
+}
+
+// getBuildInputsDigest returns a digest of everything that the charts built for the package are generated from
+// It returns an empty string if any upstream is not pinned, since the charts may then change even if no input has changed
+func (p *Package) getBuildInputsDigest() (string, error) {
+	upstreamsDigest, pinned, err := p.getUpstreamsDigest()
+	if err != nil || !pinned {
+		return "", err
+	}
+	// Local charts are stored within the package, so their working directories are inputs as well
+	packagePaths := []string{path.PackageValuesSchemaOverlayDir, path.PackageEditionsDir}
+	if p.Chart.Upstream.IsWithinPackage() {
+		packagePaths = append(packagePaths, p.Chart.WorkingDir)
+	}
+	for _, additionalChart := range p.AdditionalCharts {
+		if additionalChart.Upstream != nil && (*additionalChart.Upstream).IsWithinPackage() {
+			packagePaths = append(packagePaths, additionalChart.WorkingDir)
+		}
+	}
+	changesDigest, err := p.getChangesDigest(packagePaths...)
+	if err != nil {
+		return "", err
+	}
+	exportSettings, err := helm.GetExportSettings()
+	if err != nil {
+		return "", err
+	}
+	inputsHash := sha256.New()
+	fmt.Fprintf(inputsHash, "%s\n%s\n%s\n", upstreamsDigest, changesDigest, exportSettings)
+	return hex.EncodeToString(inputsHash.Sum(nil)), nil
+}
+
+// getLocalDependencies returns the directories within the package and the directories of the packages that the local dependencies of the charts in the package are built from
+func (p *Package) getLocalDependencies() ([]string, []string, error) {
+	packageOpts, err := loadPackageOptions(p.fs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to read %s for PackageOptions: %s", path.PackageOptionsFile, err)
+	}
+	upstreamOpts := []options.UpstreamOptions{packageOpts.MainChartOptions.UpstreamOptions}
+	for _, additionalChartOptions := range packageOpts.AdditionalChartOptions {
+		if additionalChartOptions.UpstreamOptions != nil {
+			upstreamOpts = append(upstreamOpts, *additionalChartOptions.UpstreamOptions)
+		}
+	}
+	var dependencyPaths, dependencyPackages []string
+	for _, u := range upstreamOpts {
+		for _, dependency := range u.LocalDependencies {
+			if len(dependency.Package) > 0 {
+				dependencyPackages = append(dependencyPackages, filepath.Join(path.RepositoryPackagesDir, dependency.Package))
+				continue
+			}
+			dependencyPaths = append(dependencyPaths, dependency.Path)
+		}
+	}
+	return dependencyPaths, dependencyPackages, nil
+}
+
+// getBuildOutputsDigest returns a digest of the assets and charts generated for the package
+func (p *Package) getBuildOutputsDigest() (string, error) {
+	return filesystem.GetDigest(p.rootFs, filepath.Join(path.RepositoryAssetsDir, p.Name), filepath.Join(path.RepositoryChartsDir, p.Name))
+}
+
+// getWorkingDirsDigest returns a digest of the working directories of every chart in the package
+func (p *Package) getWorkingDirsDigest() (string, error) {
+	workingDirs := []string{p.Chart.WorkingDir}
+	for _, additionalChart := range p.AdditionalCharts {
+		workingDirs = append(workingDirs, additionalChart.WorkingDir)
+	}
+	return filesystem.GetDigest(p.fs, workingDirs...)
+}
+
+// loadPrepareState returns the state recorded by the last prepare of the package, if any
+func (p *Package) loadPrepareState() (*PrepareState, error) {
+	exists, err := filesystem.PathExists(p.fs, path.PackagePrepareStateFile)
+	if err != nil || !exists {
+		return nil, err
+	}
+	stateBytes, err := ioutil.ReadFile(filesystem.GetAbsPath(p.fs, path.PackagePrepareStateFile))
+	if err != nil {
+		return nil, err
+	}
+	var state PrepareState
+	if err := yaml.Unmarshal(stateBytes, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// savePrepareState records the state of the prepare of the package
+func (p *Package) savePrepareState(state PrepareState) error {
+	stateBytes, err := yaml.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filesystem.GetAbsPath(p.fs, path.PackagePrepareStateFile), stateBytes, 0644)
+}
+
+// loadBuildState returns the state recorded by the last build of the charts of the package, if any
+func (p *Package) loadBuildState() (*BuildState, error) {
+	exists, err := filesystem.PathExists(p.fs, path.PackageBuildStateFile)
+	if err != nil || !exists {
+		return nil, err
+	}
+	stateBytes, err := ioutil.ReadFile(filesystem.GetAbsPath(p.fs, path.PackageBuildStateFile))
+	if err != nil {
+		return nil, err
+	}
+	var state BuildState
+	if err := yaml.Unmarshal(stateBytes, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// saveBuildState records the state of the build of the charts of the package
+func (p *Package) saveBuildState(state BuildState) error {
+	stateBytes, err := yaml.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filesystem.GetAbsPath(p.fs, path.PackageBuildStateFile), stateBytes, 0644)
+}
+
+// isPinned returns whether the upstream always pulls the same contents
+func isPinned(upstream puller.Puller) bool {
+	if upstream.IsWithinPackage() {
+		return true
+	}
+	for _, overlay := range puller.GetOverlays(upstream) {
+		if !isPinned(overlay.Puller) {
+			return false
+		}
+	}
+	switch u := puller.Unwrap(upstream).(type) {
+	case puller.GithubRepository:
+		return u.Commit != nil
+	case puller.GitRepository:
+		return u.Commit != nil
+	case puller.Archive:
+		return u.Checksum != nil
+	case puller.OCIChart:
+		// Tags can be moved to another artifact, unlike digests
+		return strings.Contains(u.URL, "@sha256:")
+	default:
+		return false
+	}
+}
@@ -0,0 +1,304 @@
+package charts
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	"github.com/rancher/charts-build-scripts/pkg/path"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	helmChart "helm.sh/helm/v3/pkg/chart"
+	helmLoader "helm.sh/helm/v3/pkg/chart/loader"
+	helmChartutil "helm.sh/helm/v3/pkg/chartutil"
+)
+
+// dependencyWiringCommentFmt is the comment that marks the entries added to a chart to wire in a dependency, so that they can be found and removed again
+const dependencyWiringCommentFmt = "# Toggles the %s chart generated from %s"
+
+// dependenciesKeyRegex matches the dependencies key of a Chart.yaml
+var dependenciesKeyRegex = regexp.MustCompile(`(?m)^dependencies:[ \t]*(#.*)?$`)
+
+// AddDependencyWiringToChart adds the condition of wiringOpts that toggles the chart at dependencyHelmChartPath to the values.yaml of the chart at helmChartPath with its default value, unless the values already set it
+// If wiringOpts.Vendor is set, the chart at dependencyHelmChartPath is also vendored into the chart at helmChartPath as a dependency toggled by the condition and tags of wiringOpts
+func AddDependencyWiringToChart(fs billy.Filesystem, helmChartPath, dependencyHelmChartPath string, wiringOpts options.MainChartDependencyOptions) error {
+	name, err := getDependencyWiringName(fs, dependencyHelmChartPath)
+	if err != nil {
+		return err
+	}
+	condition := getDependencyWiringCondition(name, wiringOpts)
+	for _, key := range strings.Split(condition, ".") {
+		if len(key) == 0 {
+			return fmt.Errorf("Condition %s of dependency %s is invalid: must be a path of keys separated by dots", condition, name)
+		}
+	}
+	comment := fmt.Sprintf(dependencyWiringCommentFmt, name, dependencyHelmChartPath)
+	valuesPath := filepath.Join(helmChartPath, path.ChartValuesFile)
+	values, err := readChartFile(fs, valuesPath)
+	if err != nil {
+		return err
+	}
+	values, err = addConditionToValues(values, valuesPath, comment, condition, wiringOpts.Enabled)
+	if err != nil {
+		return err
+	}
+	if !wiringOpts.Vendor {
+		logrus.Infof("Adding %s to the values of %s to toggle %s", condition, helmChartPath, dependencyHelmChartPath)
+		return writeChartFile(fs, valuesPath, values)
+	}
+	chart, err := helmLoader.Load(filesystem.GetAbsPath(fs, helmChartPath))
+	if err != nil {
+		return fmt.Errorf("Could not load Helm chart: %s", err)
+	}
+	if chart.Metadata.APIVersion == helmChart.APIVersionV1 {
+		return fmt.Errorf("Helm chart %s uses apiVersion %s and must be migrated to apiVersion %s before dependencies can be wired into it", helmChartPath, helmChart.APIVersionV1, helmChart.APIVersionV2)
+	}
+	for _, dependency := range chart.Metadata.Dependencies {
+		if dependency.Name == name {
+			return fmt.Errorf("Helm chart %s already declares a dependency named %s", helmChartPath, name)
+		}
+	}
+	// Compute every change up front so that the chart is left untouched if any of them cannot be made
+	chartYamlPath := filepath.Join(helmChartPath, helmChartutil.ChartfileName)
+	chartYaml, err := readChartFile(fs, chartYamlPath)
+	if err != nil {
+		return err
+	}
+	if loc := dependenciesKeyRegex.FindStringIndex(chartYaml); loc != nil {
+		// Match the indentation of the existing dependencies
+		indent := ""
+		if match := regexp.MustCompile(`(?m)^([ \t]*)- `).FindStringSubmatch(chartYaml[loc[1]:]); match != nil {
+			indent = match[1]
+		}
+		chartYaml = chartYaml[:loc[1]] + "\n" + strings.TrimSuffix(getDependencyWiringBlock(comment, name, condition, wiringOpts.Tags, indent, false), "\n") + chartYaml[loc[1]:]
+	} else if len(chart.Metadata.Dependencies) > 0 {
+		return fmt.Errorf("Unable to find the dependencies key in %s", chartYamlPath)
+	} else {
+		if len(chartYaml) > 0 && !strings.HasSuffix(chartYaml, "\n") {
+			chartYaml += "\n"
+		}
+		chartYaml += getDependencyWiringBlock(comment, name, condition, wiringOpts.Tags, "", true)
+	}
+	dependencyDestPath := filepath.Join(helmChartPath, "charts", name)
+	exists, err := filesystem.PathExists(fs, dependencyDestPath)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("Unable to vendor %s into %s since it already exists", dependencyHelmChartPath, dependencyDestPath)
+	}
+	logrus.Infof("Adding %s as a dependency of %s toggled by %s", dependencyHelmChartPath, helmChartPath, condition)
+	if err := filesystem.CopyDir(fs, dependencyHelmChartPath, dependencyDestPath); err != nil {
+		return fmt.Errorf("Encountered error while copying %s into %s: %s", dependencyHelmChartPath, dependencyDestPath, err)
+	}
+	if err := writeChartFile(fs, chartYamlPath, chartYaml); err != nil {
+		return err
+	}
+	return writeChartFile(fs, valuesPath, values)
+}
+
+// addConditionToValues returns the values with the condition set to enabled, unless the values already set it
+func addConditionToValues(values, valuesPath, comment, condition string, enabled bool) (string, error) {
+	var parsedValues map[interface{}]interface{}
+	if err := yaml.Unmarshal([]byte(values), &parsedValues); err != nil {
+		return "", fmt.Errorf("Unable to parse %s: %s", valuesPath, err)
+	}
+	keys := strings.Split(condition, ".")
+	if hasValue(parsedValues, keys) {
+		logrus.Infof("%s already sets %s, leaving it as is", valuesPath, condition)
+		return values, nil
+	}
+	topLevelValue, hasTopLevelKey := parsedValues[keys[0]]
+	if !hasTopLevelKey {
+		if len(values) > 0 && !strings.HasSuffix(values, "\n") {
+			values += "\n"
+		}
+		return values + "\n" + getConditionValuesBlock(comment, keys, enabled), nil
+	}
+	// The condition can only be added beneath a top-level key that does not set any part of it yet
+	if topLevelValues, ok := topLevelValue.(map[interface{}]interface{}); !ok || len(keys) == 1 || topLevelValues[keys[1]] != nil {
+		return "", fmt.Errorf("Unable to add condition %s to %s since it already sets part of it to another value", condition, valuesPath)
+	}
+	loc := regexp.MustCompile(fmt.Sprintf(`(?m)^%s:[ \t]*(#.*)?$`, regexp.QuoteMeta(keys[0]))).FindStringIndex(values)
+	if loc == nil {
+		return "", fmt.Errorf("Unable to find the %s key in %s", keys[0], valuesPath)
+	}
+	return values[:loc[1]] + "\n" + strings.TrimSuffix(indent(getConditionValuesBlock(comment, keys[1:], enabled), "  "), "\n") + values[loc[1]:], nil
+}
+
+// RemoveDependencyWiringFromChart removes the condition and, if it was vendored, the dependency on the chart at dependencyHelmChartPath added by AddDependencyWiringToChart from the chart at helmChartPath
+// It does nothing if the dependency was not added
+func RemoveDependencyWiringFromChart(fs billy.Filesystem, helmChartPath, dependencyHelmChartPath string, wiringOpts options.MainChartDependencyOptions) error {
+	name, err := getDependencyWiringName(fs, dependencyHelmChartPath)
+	if err != nil {
+		return err
+	}
+	condition := getDependencyWiringCondition(name, wiringOpts)
+	comment := fmt.Sprintf(dependencyWiringCommentFmt, name, dependencyHelmChartPath)
+	if wiringOpts.Vendor {
+		if err := removeVendoredDependency(fs, helmChartPath, name, comment, condition, wiringOpts.Tags); err != nil {
+			return err
+		}
+	}
+	valuesPath := filepath.Join(helmChartPath, path.ChartValuesFile)
+	values, err := readChartFile(fs, valuesPath)
+	if err != nil {
+		return err
+	}
+	keys := strings.Split(condition, ".")
+	candidates := []string{"\n" + getConditionValuesBlock(comment, keys, wiringOpts.Enabled)}
+	if len(keys) > 1 {
+		candidates = append(candidates, "\n"+strings.TrimSuffix(indent(getConditionValuesBlock(comment, keys[1:], wiringOpts.Enabled), "  "), "\n"))
+	}
+	// The values are left as is if they already set the condition before the dependency was wired in
+	values, removed := removeFirst(values, candidates)
+	if !removed {
+		return nil
+	}
+	return writeChartFile(fs, valuesPath, values)
+}
+
+// removeVendoredDependency removes the dependency named name that was vendored into the chart at helmChartPath, doing nothing if it was not vendored
+func removeVendoredDependency(fs billy.Filesystem, helmChartPath, name, comment, condition string, tags []string) error {
+	chartYamlPath := filepath.Join(helmChartPath, helmChartutil.ChartfileName)
+	chartYaml, err := readChartFile(fs, chartYamlPath)
+	if err != nil {
+		return err
+	}
+	match := regexp.MustCompile(fmt.Sprintf(`(?m)^([ \t]*)%s$`, regexp.QuoteMeta(comment))).FindStringSubmatch(chartYaml)
+	if match == nil {
+		// The dependency was never vendored into this chart
+		return nil
+	}
+	candidates := []string{
+		getDependencyWiringBlock(comment, name, condition, tags, "", true),
+		"\n" + strings.TrimSuffix(getDependencyWiringBlock(comment, name, condition, tags, match[1], false), "\n"),
+	}
+	chartYaml, removed := removeFirst(chartYaml, candidates)
+	if !removed {
+		return fmt.Errorf("Unable to remove dependency %s from %s since it was modified; remove it manually", name, chartYamlPath)
+	}
+	if err := writeChartFile(fs, chartYamlPath, chartYaml); err != nil {
+		return err
+	}
+	dependenciesPath := filepath.Join(helmChartPath, "charts")
+	if err := filesystem.RemoveAll(fs, filepath.Join(dependenciesPath, name)); err != nil {
+		return err
+	}
+	return filesystem.PruneEmptyDirsInPath(fs, dependenciesPath)
+}
+
+// getDependencyWiringName returns the name of the chart at dependencyHelmChartPath
+func getDependencyWiringName(fs billy.Filesystem, dependencyHelmChartPath string) (string, error) {
+	chartYamlPath := filepath.Join(dependencyHelmChartPath, helmChartutil.ChartfileName)
+	metadata, err := helmChartutil.LoadChartfile(filesystem.GetAbsPath(fs, chartYamlPath))
+	if err != nil {
+		return "", fmt.Errorf("Unable to load %s: %s", chartYamlPath, err)
+	}
+	return metadata.Name, nil
+}
+
+// getDependencyWiringCondition returns the condition that toggles the dependency, which defaults to {name}.enabled
+func getDependencyWiringCondition(name string, wiringOpts options.MainChartDependencyOptions) string {
+	if len(wiringOpts.Condition) > 0 {
+		return wiringOpts.Condition
+	}
+	return fmt.Sprintf("%s.enabled", name)
+}
+
+// getDependencyWiringBlock returns the entry of the dependencies of a Chart.yaml that declares the vendored dependency, preceded by the dependencies key if withKey is set
+func getDependencyWiringBlock(comment, name, condition string, tags []string, indent string, withKey bool) string {
+	lines := []string{comment}
+	if withKey {
+		lines = append(lines, "dependencies:")
+	}
+	lines = append(lines,
+		fmt.Sprintf("- name: %s", name),
+		fmt.Sprintf("  repository: file://./charts/%s", name),
+		fmt.Sprintf("  condition: %s", condition),
+	)
+	if len(tags) > 0 {
+		lines = append(lines, "  tags:")
+		for _, tag := range tags {
+			lines = append(lines, fmt.Sprintf("  - %s", tag))
+		}
+	}
+	for i := range lines {
+		lines[i] = indent + lines[i]
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// getConditionValuesBlock returns the values that set the nested keys to enabled, preceded by the comment
+func getConditionValuesBlock(comment string, keys []string, enabled bool) string {
+	lines := []string{comment}
+	for i, key := range keys {
+		line := strings.Repeat("  ", i) + key + ":"
+		if i == len(keys)-1 {
+			line = fmt.Sprintf("%s %t", line, enabled)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// hasValue returns whether the nested keys are set within the values
+func hasValue(values map[interface{}]interface{}, keys []string) bool {
+	for i, key := range keys {
+		value, ok := values[key]
+		if !ok {
+			return false
+		}
+		if i == len(keys)-1 {
+			return true
+		}
+		if values, ok = value.(map[interface{}]interface{}); !ok {
+			return false
+		}
+	}
+	return false
+}
+
+// removeFirst removes the first of the candidates that is found within s, returning whether any was removed
+func removeFirst(s string, candidates []string) (string, bool) {
+	for _, candidate := range candidates {
+		if strings.Contains(s, candidate) {
+			return strings.Replace(s, candidate, "", 1), true
+		}
+	}
+	return s, false
+}
+
+// indent prefixes every line of s with prefix
+func indent(s, prefix string) string {
+	lines := strings.SplitAfter(s, "\n")
+	for i, line := range lines {
+		if len(line) > 0 {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// readChartFile returns the contents of the file within the chart, or an empty string if it does not exist
+func readChartFile(fs billy.Filesystem, filePath string) (string, error) {
+	exists, err := filesystem.PathExists(fs, filePath)
+	if err != nil || !exists {
+		return "", err
+	}
+	data, err := ioutil.ReadFile(filesystem.GetAbsPath(fs, filePath))
+	if err != nil {
+		return "", fmt.Errorf("Unable to read %s: %s", filePath, err)
+	}
+	return string(data), nil
+}
+
+// writeChartFile writes the contents of the file within the chart
+func writeChartFile(fs billy.Filesystem, filePath, data string) error {
+	return ioutil.WriteFile(filesystem.GetAbsPath(fs, filePath), []byte(data), 0644)
+}
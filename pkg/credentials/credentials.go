@@ -0,0 +1,90 @@
+package credentials
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	// EnvProviderType is the type of a Provider that reads credentials from environment variables
+	EnvProviderType = "env"
+	// FileProviderType is the type of a Provider that reads credentials from a YAML file
+	FileProviderType = "file"
+	// ExecProviderType is the type of a Provider that gets credentials by executing a helper command
+	ExecProviderType = "exec"
+	// HTTPProviderType is the type of a Provider that gets credentials from an HTTP endpoint
+	HTTPProviderType = "http"
+)
+
+var (
+	defaultProvider     Provider
+	defaultProviderLock sync.RWMutex
+)
+
+// Credentials represent the credentials used to authenticate against a host
+type Credentials struct {
+	// Username is the username to authenticate with
+	Username string `yaml:"username" json:"username"`
+	// Password is the password or token to authenticate with
+	Password string `yaml:"password" json:"password"`
+	// SSHKeyPath is the path to a private key used to authenticate over SSH
+	SSHKeyPath string `yaml:"sshKeyPath" json:"sshKeyPath"`
+	// SSHKeyPassphrase is the passphrase of the private key, if it is encrypted
+	SSHKeyPassphrase string `yaml:"sshKeyPassphrase" json:"sshKeyPassphrase"`
+}
+
+// Provider represents an interface that is able to provide credentials for a given host
+type Provider interface {
+	// GetCredentials returns the credentials for the host or nil if it has none
+	GetCredentials(host string) (*Credentials, error)
+}
+
+// NewProvider returns a Provider of the given type that gets credentials from the source provided
+// The meaning of source depends on the type: a prefix for environment variables, a path to a file, a command, or a URL
+func NewProvider(providerType, source string) (Provider, error) {
+	switch providerType {
+	case EnvProviderType:
+		if len(source) == 0 {
+			source = DefaultEnvPrefix
+		}
+		return EnvProvider{Prefix: source}, nil
+	case FileProviderType:
+		if len(source) == 0 {
+			return nil, fmt.Errorf("A path to a credentials file must be provided for the %s credentials provider", providerType)
+		}
+		return FileProvider{Path: source}, nil
+	case ExecProviderType:
+		if len(strings.Fields(source)) == 0 {
+			return nil, fmt.Errorf("A command must be provided for the %s credentials provider", providerType)
+		}
+		return ExecProvider{Command: source}, nil
+	case HTTPProviderType:
+		if len(source) == 0 {
+			return nil, fmt.Errorf("A URL must be provided for the %s credentials provider", providerType)
+		}
+		return HTTPProvider{URL: source}, nil
+	}
+	return nil, fmt.Errorf("Unknown credentials provider %s: must be one of %s, %s, %s, or %s", providerType, EnvProviderType, FileProviderType, ExecProviderType, HTTPProviderType)
+}
+
+// SetDefaultProvider sets the Provider used to get credentials for pulling upstreams
+func SetDefaultProvider(p Provider) {
+	defaultProviderLock.Lock()
+	defer defaultProviderLock.Unlock()
+	defaultProvider = p
+}
+
+// GetCredentials returns the credentials for the host from the default Provider or nil if no credentials are available
+func GetCredentials(host string) (*Credentials, error) {
+	defaultProviderLock.RLock()
+	defer defaultProviderLock.RUnlock()
+	if defaultProvider == nil {
+		return nil, nil
+	}
+	creds, err := defaultProvider.GetCredentials(host)
+	if err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to get credentials for %s: %s", host, err)
+	}
+	return creds, nil
+}
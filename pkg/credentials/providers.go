@@ -0,0 +1,154 @@
+package credentials
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// DefaultEnvPrefix is the default prefix of environment variables read by the EnvProvider
+	DefaultEnvPrefix = "CHARTS_CREDENTIALS"
+	// HTTPProviderTokenEnvVar is an environment variable containing a bearer token used to authenticate against the HTTPProvider's endpoint
+	HTTPProviderTokenEnvVar = "CHARTS_CREDENTIALS_HTTP_TOKEN"
+)
+
+// EnvProvider gets credentials from environment variables
+// It looks up {Prefix}_{HOST}_USERNAME and {Prefix}_{HOST}_PASSWORD first, falling back to {Prefix}_USERNAME and {Prefix}_PASSWORD
+// An SSH key can be provided in the same way with {Prefix}_{HOST}_SSH_KEY and {Prefix}_{HOST}_SSH_KEY_PASSPHRASE
+// HOST is the host in uppercase with all non-alphanumeric characters replaced by underscores, e.g. GITHUB_COM
+type EnvProvider struct {
+	// Prefix is the prefix of all environment variables read by this provider
+	Prefix string
+}
+
+// GetCredentials returns the credentials for the host from environment variables
+func (p EnvProvider) GetCredentials(host string) (*Credentials, error) {
+	hostPrefix := fmt.Sprintf("%s_%s", p.Prefix, envVarHost(host))
+	for _, prefix := range []string{hostPrefix, p.Prefix} {
+		creds := Credentials{
+			Username:         os.Getenv(prefix + "_USERNAME"),
+			Password:         os.Getenv(prefix + "_PASSWORD"),
+			SSHKeyPath:       os.Getenv(prefix + "_SSH_KEY"),
+			SSHKeyPassphrase: os.Getenv(prefix + "_SSH_KEY_PASSPHRASE"),
+		}
+		if len(creds.Username) > 0 || len(creds.Password) > 0 || len(creds.SSHKeyPath) > 0 {
+			return &creds, nil
+		}
+	}
+	return nil, nil
+}
+
+// envVarHost converts a host into a string that can be used in an environment variable name
+func envVarHost(host string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, strings.ToUpper(host))
+}
+
+// FileProvider gets credentials from a YAML file that maps each host to its credentials
+type FileProvider struct {
+	// Path is the path to the YAML file
+	Path string
+}
+
+// GetCredentials returns the credentials for the host from the file
+func (p FileProvider) GetCredentials(host string) (*Credentials, error) {
+	credentialsBytes, err := ioutil.ReadFile(p.Path)
+	if err != nil {
+		return nil, err
+	}
+	var hostCredentials map[string]*Credentials
+	if err := yaml.UnmarshalStrict(credentialsBytes, &hostCredentials); err != nil {
+		return nil, fmt.Errorf("Unable to parse credentials file %s: %s", p.Path, err)
+	}
+	return hostCredentials[host], nil
+}
+
+// ExecProvider gets credentials by executing a helper command with the host as its last argument
+// The command is expected to output JSON with a username and password; an empty output indicates there are no credentials
+type ExecProvider struct {
+	// Command is the helper command along with any arguments
+	Command string
+}
+
+// GetCredentials returns the credentials for the host from the output of the helper command
+func (p ExecProvider) GetCredentials(host string) (*Credentials, error) {
+	args := strings.Fields(p.Command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("No command was provided for the credentials helper")
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(args[0], append(args[1:], host)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("Credentials helper %s failed: %s: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return parseJSONCredentials(stdout.Bytes())
+}
+
+// HTTPProvider gets credentials from an HTTP endpoint by making a GET request with the host as a query parameter
+// If HTTPProviderTokenEnvVar is set, it is provided to the endpoint as a bearer token
+// The endpoint is expected to respond with JSON containing a username and password or a 404 if there are no credentials
+type HTTPProvider struct {
+	// URL is the URL of the endpoint
+	URL string
+}
+
+// GetCredentials returns the credentials for the host from the HTTP endpoint
+func (p HTTPProvider) GetCredentials(host string) (*Credentials, error) {
+	endpoint, err := url.Parse(p.URL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid URL for credentials endpoint %s: %s", p.URL, err)
+	}
+	query := endpoint.Query()
+	query.Set("host", host)
+	endpoint.RawQuery = query.Encode()
+	req, err := http.NewRequest(http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv(HTTPProviderTokenEnvVar); len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Credentials endpoint returned unexpected status %s", resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return parseJSONCredentials(body)
+}
+
+// parseJSONCredentials parses credentials from JSON or returns nil if the output is empty
+func parseJSONCredentials(data []byte) (*Credentials, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+	var creds Credentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("Unable to parse credentials: %s", err)
+	}
+	return &creds, nil
+}
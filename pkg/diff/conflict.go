@@ -0,0 +1,222 @@
+package diff
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+)
+
+var (
+	// patchingFileRegex matches the line GNU patch outputs before applying the hunks of a file
+	patchingFileRegex = regexp.MustCompile("^patching file [`']?(.+?)'?$")
+	// failedHunkRegex matches the line GNU patch outputs for each hunk that it could not apply
+	failedHunkRegex = regexp.MustCompile(`^Hunk #(\d+) FAILED at (\d+)`)
+	// missingFileRegex matches the line GNU patch outputs if the file that a patch applies to does not exist
+	missingFileRegex = regexp.MustCompile(`^can't find file to patch at input line (\d+)`)
+	// hunkHeaderRegex matches the header of a hunk and captures the line it starts at in the original file
+	hunkHeaderRegex = regexp.MustCompile(`^@@ -(\d+)(?:,\d+)? \+\d+(?:,\d+)? @@`)
+)
+
+// HunkConflict represents a hunk of a patch that could not be applied to the file it modifies
+type HunkConflict struct {
+	// File is the path to the file that the hunk modifies
+	File string
+	// Hunk is the position of the hunk within the changes to the file, starting at 1
+	Hunk int
+	// Header is the header of the hunk, which contains the lines it modifies in the original file
+	Header string
+	// Expected are the lines that the hunk expects to find in the file, i.e. its context and the lines it removes
+	Expected []string
+	// NearestLine is the line in the file where the lines that most closely match the expected lines start, or 0 if none match
+	NearestLine int
+	// Nearest are the lines in the file that most closely match the expected lines
+	Nearest []string
+	// Suggestion describes how the conflict can be resolved
+	Suggestion string
+}
+
+func (c HunkConflict) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: hunk #%d %s could not be applied\n", c.File, c.Hunk, c.Header)
+	b.WriteString("  expected:\n")
+	for _, line := range c.Expected {
+		fmt.Fprintf(&b, "    | %s\n", line)
+	}
+	if c.NearestLine > 0 {
+		fmt.Fprintf(&b, "  nearest match at line %d:\n", c.NearestLine)
+		for _, line := range c.Nearest {
+			fmt.Fprintf(&b, "    | %s\n", line)
+		}
+	}
+	fmt.Fprintf(&b, "  suggestion: %s", c.Suggestion)
+	return b.String()
+}
+
+// PatchConflictError is returned when a patch contains hunks that could not be applied
+type PatchConflictError struct {
+	// PatchPath is the path to the patch that could not be applied
+	PatchPath string
+	// Conflicts are the hunks that could not be applied
+	Conflicts []HunkConflict
+}
+
+func (e *PatchConflictError) Error() string {
+	return fmt.Sprintf("Unable to apply %d of the hunks in %s", len(e.Conflicts), e.PatchPath)
+}
+
+// patchHunk represents a hunk within a patch
+type patchHunk struct {
+	header    string
+	startLine int
+	expected  []string
+}
+
+// getHunkConflicts returns the hunks of the patch at patchPath that GNU patch reported as failed in its output when applying it to destDir
+func getHunkConflicts(fs billy.Filesystem, patchPath, destDir, output string) ([]HunkConflict, error) {
+	patchBytes, err := ioutil.ReadFile(filesystem.GetAbsPath(fs, patchPath))
+	if err != nil {
+		return nil, err
+	}
+	hunksByFile, filesByLine := parsePatch(string(patchBytes))
+	var conflicts []HunkConflict
+	var currentFile string
+	for _, line := range strings.Split(output, "\n") {
+		if match := patchingFileRegex.FindStringSubmatch(line); match != nil {
+			currentFile = match[1]
+			continue
+		}
+		if match := missingFileRegex.FindStringSubmatch(line); match != nil {
+			lineNum, _ := strconv.Atoi(match[1])
+			file := filesByLine[lineNum]
+			for i, hunk := range hunksByFile[file] {
+				conflicts = append(conflicts, HunkConflict{
+					File:       file,
+					Hunk:       i + 1,
+					Header:     hunk.header,
+					Expected:   hunk.expected,
+					Suggestion: "the file no longer exists upstream; if it was moved, update the patch to modify its new location, otherwise remove the patch or add the file as an overlay",
+				})
+			}
+			continue
+		}
+		match := failedHunkRegex.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		hunkNum, _ := strconv.Atoi(match[1])
+		hunks := hunksByFile[currentFile]
+		if hunkNum < 1 || hunkNum > len(hunks) {
+			continue
+		}
+		hunk := hunks[hunkNum-1]
+		conflict := HunkConflict{
+			File:     currentFile,
+			Hunk:     hunkNum,
+			Header:   hunk.header,
+			Expected: hunk.expected,
+		}
+		fileBytes, err := ioutil.ReadFile(filepath.Join(filesystem.GetAbsPath(fs, destDir), currentFile))
+		if err != nil {
+			return nil, err
+		}
+		lines := strings.Split(string(fileBytes), "\n")
+		var matched int
+		conflict.NearestLine, matched = findNearestLines(lines, hunk.expected, hunk.startLine)
+		if conflict.NearestLine > 0 {
+			end := conflict.NearestLine - 1 + len(hunk.expected)
+			if end > len(lines) {
+				end = len(lines)
+			}
+			conflict.Nearest = lines[conflict.NearestLine-1 : end]
+		}
+		switch {
+		case matched == 0:
+			conflict.Suggestion = "none of the expected lines exist upstream anymore, so the code that the hunk modifies was likely removed or rewritten; re-apply the change by hand if it is still needed, then regenerate the patch"
+		case matched == len(hunk.expected):
+			conflict.Suggestion = fmt.Sprintf("the expected lines were found unchanged at line %d, so the patch was likely edited by hand; regenerate the patch", conflict.NearestLine)
+		default:
+			conflict.Suggestion = fmt.Sprintf("%d of the %d expected lines changed upstream near line %d; apply the change by hand there (see %s.rej), then regenerate the patch", len(hunk.expected)-matched, len(hunk.expected), conflict.NearestLine, currentFile)
+		}
+		conflicts = append(conflicts, conflict)
+	}
+	return conflicts, nil
+}
+
+// parsePatch returns the hunks of each file modified by the patch, keyed by both the original and the new path of the file with its leading directory
+// stripped since GNU patch applies them to whichever exists, along with the file whose header ends just before each line of the patch
+func parsePatch(patch string) (map[string][]patchHunk, map[int]string) {
+	hunksByFile := make(map[string][]patchHunk)
+	filesByLine := make(map[int]string)
+	var files []string
+	var currentHunk *patchHunk
+	lines := strings.Split(patch, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			files = []string{stripPatchPath(strings.TrimPrefix(line, "--- "))}
+			if newFile := stripPatchPath(strings.TrimPrefix(lines[i+1], "+++ ")); newFile != files[0] {
+				files = append(files, newFile)
+			}
+			currentHunk = nil
+			i++
+			filesByLine[i+2] = files[len(files)-1]
+		case strings.HasPrefix(line, "@@ ") && len(files) > 0:
+			hunk := patchHunk{header: line}
+			if match := hunkHeaderRegex.FindStringSubmatch(line); match != nil {
+				hunk.startLine, _ = strconv.Atoi(match[1])
+			}
+			hunks := append(hunksByFile[files[0]], hunk)
+			for _, file := range files {
+				hunksByFile[file] = hunks
+			}
+			currentHunk = &hunks[len(hunks)-1]
+		case currentHunk != nil && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "-")):
+			currentHunk.expected = append(currentHunk.expected, line[1:])
+		}
+	}
+	return hunksByFile, filesByLine
+}
+
+// stripPatchPath returns the path of a file in the header of a patch without its leading directory, as GNU patch does with -p1
+func stripPatchPath(path string) string {
+	if i := strings.Index(path, "/"); i >= 0 {
+		return path[i+1:]
+	}
+	return path
+}
+
+// findNearestLines returns the line where the window of lines that shares the most lines with expected starts, along with the number of lines shared
+// Ties are broken by the distance from startLine, where the hunk expected to find the lines. It returns 0 if no line matches
+func findNearestLines(lines, expected []string, startLine int) (int, int) {
+	bestLine, bestMatched := 0, 0
+	for start := 0; start < len(lines); start++ {
+		matched := 0
+		for i, e := range expected {
+			if start+i < len(lines) && strings.TrimSpace(lines[start+i]) == strings.TrimSpace(e) {
+				matched++
+			}
+		}
+		if matched == 0 {
+			continue
+		}
+		if matched > bestMatched || (matched == bestMatched && absInt(start+1-startLine) < absInt(bestLine-startLine)) {
+			bestLine, bestMatched = start+1, matched
+		}
+	}
+	return bestLine, bestMatched
+}
+
+// absInt returns the absolute value of n
+func absInt(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
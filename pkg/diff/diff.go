@@ -1,10 +1,12 @@
 package diff
 
 import (
-	"bufio"
 	"bytes"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"os/exec"
+	"strings"
 
 	"github.com/go-git/go-billy/v5"
 	"github.com/rancher/charts-build-scripts/pkg/filesystem"
@@ -14,16 +16,48 @@ import (
 // GeneratePatch generates the patch between the files at srcPath and dstPath and outputs it to patchPath
 // It returns whether the patch was generated or any errors that were encountered
 func GeneratePatch(fs billy.Filesystem, patchPath, srcPath, dstPath string) (bool, error) {
+	buf, err := runDiff(fs, "-uN", srcPath, dstPath)
+	if err != nil {
+		return false, err
+	}
+	if buf.Len() == 0 {
+		return false, nil
+	}
+	// Patch exists
+	patchFile, err := filesystem.CreateFileAndDirs(fs, patchPath)
+	if err != nil {
+		return false, err
+	}
+	defer patchFile.Close()
+	if _, err = removeTimestamps(buf).WriteTo(patchFile); err != nil {
+		return false, fmt.Errorf("Unable to write diff to file: %s", err)
+	}
+	return true, nil
+}
+
+// GenerateDiff returns the unified diff between the files or directories at srcPath and dstPath without any timestamps
+func GenerateDiff(fs billy.Filesystem, srcPath, dstPath string) (string, error) {
+	buf, err := runDiff(fs, "-ruN", srcPath, dstPath)
+	if err != nil {
+		return "", err
+	}
+	return removeTimestamps(buf).String(), nil
+}
+
+// runDiff runs GNU diff with the provided flags between srcPath and dstPath and returns the output
+func runDiff(fs billy.Filesystem, flags, srcPath, dstPath string) (*bytes.Buffer, error) {
 	// TODO(aiyengar2): find a better library to actually generate and apply patches
 	// There doesn't seem to be any existing library at the moment that can work with unified patches
 	pathToDiffCmd, err := exec.LookPath("diff")
 	if err != nil {
-		return false, fmt.Errorf("Cannot generate patch file if GNU diff is not available")
+		return nil, fmt.Errorf("Cannot generate patch file if GNU diff is not available")
 	}
 
 	var buf bytes.Buffer
-	cmd := exec.Command(pathToDiffCmd, "-uN", "-x *.tgz", "-x *.lock", srcPath, dstPath)
+	cmd := exec.Command(pathToDiffCmd, flags, "-x *.tgz", "-x *.lock", srcPath, dstPath)
 	cmd.Dir = fs.Root()
+	// Ensure that files are compared in byte order and that messages are not localized so that the output is the same on every machine
+	cmd.Env = append(os.Environ(), "LC_ALL=C", "TZ=UTC")
 	cmd.Stdout = &buf
 
 	if err = cmd.Run(); err != nil {
@@ -31,23 +65,10 @@ func GeneratePatch(fs billy.Filesystem, patchPath, srcPath, dstPath string) (boo
 		// Exit code of 1 indicates that a difference was observed, so it is expected
 		if !ok || exitErr.ExitCode() != 1 {
 			logrus.Errorf("\n%s", &buf)
-			return false, fmt.Errorf("Unable to generate patch with error: %s", err)
+			return nil, fmt.Errorf("Unable to generate patch with error: %s", err)
 		}
 	}
-
-	if buf.Len() == 0 {
-		return false, nil
-	}
-	// Patch exists
-	patchFile, err := filesystem.CreateFileAndDirs(fs, patchPath)
-	if err != nil {
-		return false, err
-	}
-	defer patchFile.Close()
-	if _, err = removeTimestamps(&buf).WriteTo(patchFile); err != nil {
-		return false, fmt.Errorf("Unable to write diff to file: %s", err)
-	}
-	return true, nil
+	return &buf, nil
 }
 
 // ApplyPatch applies a patch file located at patchPath to the destDir on the filesystem
@@ -60,38 +81,139 @@ func ApplyPatch(fs billy.Filesystem, patchPath, destDir string) error {
 	}
 
 	var buf bytes.Buffer
-	patchFile, err := fs.Open(patchPath)
+	patch, err := ioutil.ReadFile(filesystem.GetAbsPath(fs, patchPath))
 	if err != nil {
 		return err
 	}
-	defer patchFile.Close()
+	if err := validatePatchPaths(string(patch)); err != nil {
+		return fmt.Errorf("Refusing to apply patch %s: %s", patchPath, err)
+	}
 
 	cmd := exec.Command(pathToPatchCmd, "-E", "-p1")
 	cmd.Dir = filesystem.GetAbsPath(fs, destDir)
-	cmd.Stdin = patchFile
+	cmd.Stdin = bytes.NewReader(patch)
 	cmd.Stdout = &buf
+	// Ensure that the output of GNU patch is not localized so that failed hunks can be identified
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
 
 	if err = cmd.Run(); err != nil {
 		logrus.Errorf("\n%s", &buf)
-		err = fmt.Errorf("Unable to generate patch with error: %s", err)
+		conflicts, conflictsErr := getHunkConflicts(fs, patchPath, destDir, buf.String())
+		if conflictsErr != nil || len(conflicts) == 0 {
+			return fmt.Errorf("Unable to generate patch with error: %s", err)
+		}
+		for _, conflict := range conflicts {
+			logrus.Errorf("Patch conflict in %s\n%s", patchPath, conflict)
+		}
+		return &PatchConflictError{
+			PatchPath: patchPath,
+			Conflicts: conflicts,
+		}
+	}
+	return nil
+}
+
+// gitExtendedHeaderPrefixes are the prefixes of the extended headers of a git patch that name a file without the a/ or b/ prefix of its other headers
+var gitExtendedHeaderPrefixes = []string{"rename from ", "rename to ", "copy from ", "copy to "}
+
+// validatePatchPaths returns an error if any file in the headers of the patch is absolute or would resolve outside of the directory that the patch is applied to
+func validatePatchPaths(patch string) error {
+	lines := strings.Split(patch, "\n")
+	for i, line := range lines {
+		line = strings.TrimSuffix(line, "\r")
+		for _, prefix := range gitExtendedHeaderPrefixes {
+			if strings.HasPrefix(line, prefix) {
+				if err := validatePatchPath(line[len(prefix):], false); err != nil {
+					return err
+				}
+			}
+		}
+		// A file header is a pair of --- and +++ lines followed by the header of its first hunk
+		if i+2 >= len(lines) || !strings.HasPrefix(lines[i], "--- ") || !strings.HasPrefix(lines[i+1], "+++ ") || !strings.HasPrefix(lines[i+2], "@@ ") {
+			continue
+		}
+		for _, headerLine := range lines[i : i+2] {
+			patchPath := strings.TrimSuffix(headerLine[len("--- "):], "\r")
+			// GNU diff separates the path in a file header from its timestamp with a tab
+			if j := strings.Index(patchPath, "\t"); j >= 0 {
+				patchPath = patchPath[:j]
+			}
+			if err := validatePatchPath(patchPath, true); err != nil {
+				return err
+			}
+		}
 	}
+	return nil
+}
+
+// validatePatchPath returns an error if the path named by a header of a patch is absolute or would resolve outside of the directory that the patch is applied to
+// If stripped is set, the first component of the path is stripped when the patch is applied, e.g. the a/ or b/ of a git patch
+func validatePatchPath(patchPath string, stripped bool) error {
+	patchPath = strings.Trim(patchPath, `"`)
+	if patchPath == os.DevNull {
+		return nil
+	}
+	if strings.HasPrefix(patchPath, "/") {
+		return fmt.Errorf("Path %s is absolute", patchPath)
+	}
+	if stripped {
+		patchPath = stripPatchPath(patchPath)
+	}
+	_, err := filesystem.SanitizeRelativePath(patchPath)
 	return err
 }
 
-// removeTimestamps removes timestamps from a given patch file
+// MergeFiles performs a three-way merge of the changes from the file at basePath to the files at oursPath and theirsPath
+// It returns the merged contents and whether any changes conflicted, in which case the conflicting hunks are surrounded by conflict markers
+// If basePath is empty, the files are merged as if both were added
+func MergeFiles(fs billy.Filesystem, oursPath, basePath, theirsPath string) ([]byte, bool, error) {
+	pathToGitCmd, err := exec.LookPath("git")
+	if err != nil {
+		return nil, false, fmt.Errorf("Cannot merge files if git is not available")
+	}
+	absBasePath := os.DevNull
+	if len(basePath) > 0 {
+		absBasePath = filesystem.GetAbsPath(fs, basePath)
+	}
+	var buf, errBuf bytes.Buffer
+	cmd := exec.Command(pathToGitCmd, "merge-file", "-p", "-L", "working directory", "-L", "previous upstream", "-L", "upstream",
+		filesystem.GetAbsPath(fs, oursPath), absBasePath, filesystem.GetAbsPath(fs, theirsPath))
+	cmd.Stdout = &buf
+	cmd.Stderr = &errBuf
+	if err = cmd.Run(); err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		// A positive exit code smaller than 128 is the number of conflicts found
+		if !ok || exitErr.ExitCode() <= 0 || exitErr.ExitCode() >= 128 {
+			return nil, false, fmt.Errorf("Unable to merge %s: %s", oursPath, strings.TrimSpace(errBuf.String()))
+		}
+		return buf.Bytes(), true, nil
+	}
+	return buf.Bytes(), false, nil
+}
+
+// removeTimestamps removes timestamps from the file headers of a given patch file so that its output is stable across runs
+// Every other line is kept byte-for-byte, including any carriage returns, and the patch always ends with a single newline
 func removeTimestamps(in *bytes.Buffer) *bytes.Buffer {
-	var out []byte
-	var timestampPath string
-	s := bufio.NewScanner(in)
-	for s.Scan() {
-		line := s.Text()
-		if n, err := fmt.Sscanf(line, "--- %s", &timestampPath); n == 1 && err == nil {
-			line = fmt.Sprintf("--- %s", timestampPath)
+	var out bytes.Buffer
+	inHeader := true
+	for _, line := range strings.SplitAfter(in.String(), "\n") {
+		if len(line) == 0 {
+			continue
 		}
-		if n, err := fmt.Sscanf(line, "+++ %s", &timestampPath); n == 1 && err == nil {
-			line = fmt.Sprintf("+++ %s", timestampPath)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "diff "):
+			// Each file compared in a recursive diff starts with a new header
+			inHeader = true
+		case strings.HasPrefix(line, "@@ "):
+			inHeader = false
+		case inHeader && (strings.HasPrefix(line, "--- ") || strings.HasPrefix(line, "+++ ")):
+			// GNU diff separates the path in a file header from its timestamp with a tab
+			if i := strings.Index(line, "\t"); i >= 0 {
+				line = line[:i]
+			}
 		}
-		out = append(out, (line + "\n")...)
+		out.WriteString(line + "\n")
 	}
-	return bytes.NewBuffer(out)
+	return &out
 }
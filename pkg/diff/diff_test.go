@@ -0,0 +1,118 @@
+package diff
+
+import "testing"
+
+func TestValidatePatchPaths(t *testing.T) {
+	testCases := []struct {
+		name    string
+		patch   string
+		wantErr bool
+	}{
+		{
+			name: "GNU diff",
+			patch: `--- charts-original/values.yaml	2021-01-01 00:00:00.000000000 +0000
++++ charts/values.yaml	2021-01-01 00:00:00.000000000 +0000
+@@ -1 +1 @@
+-a: 1
++a: 2
+`,
+		},
+		{
+			name: "new file",
+			patch: `--- /dev/null
++++ charts/templates/cm.yaml
+@@ -0,0 +1 @@
++kind: ConfigMap
+`,
+		},
+		{
+			name: "git rename",
+			patch: `diff --git a/templates/old.yaml b/templates/new.yaml
+similarity index 100%
+rename from templates/old.yaml
+rename to templates/new.yaml
+`,
+		},
+		{
+			name: "git copy",
+			patch: `diff --git a/templates/old.yaml b/templates/new.yaml
+similarity index 100%
+copy from templates/old.yaml
+copy to templates/new.yaml
+`,
+		},
+		{
+			name: "absolute",
+			patch: `--- /etc/passwd
++++ /etc/passwd
+@@ -1 +1 @@
+-root
++pwned
+`,
+			wantErr: true,
+		},
+		{
+			name: "traversal outside directory",
+			patch: `--- a/../../etc/passwd
++++ b/../../etc/passwd
+@@ -1 +1 @@
+-root
++pwned
+`,
+			wantErr: true,
+		},
+		{
+			name: "quoted traversal outside directory",
+			patch: `--- "a/../../etc/passwd"
++++ "b/../../etc/passwd"
+@@ -1 +1 @@
+-root
++pwned
+`,
+			wantErr: true,
+		},
+		{
+			name: "git rename to outside directory",
+			patch: `diff --git a/values.yaml b/../../values.yaml
+similarity index 100%
+rename from values.yaml
+rename to ../../values.yaml
+`,
+			wantErr: true,
+		},
+		{
+			name: "git rename from absolute",
+			patch: `diff --git a/etc/passwd b/values.yaml
+similarity index 100%
+rename from /etc/passwd
+rename to values.yaml
+`,
+			wantErr: true,
+		},
+		{
+			name: "git copy from outside directory",
+			patch: `diff --git a/values.yaml b/values.yaml
+similarity index 100%
+copy from ../../etc/passwd
+copy to values.yaml
+`,
+			wantErr: true,
+		},
+		{
+			name:    "git copy to outside directory",
+			patch:   "diff --git a/values.yaml b/values.yaml\r\nsimilarity index 100%\r\ncopy from values.yaml\r\ncopy to ../values.yaml\r\n",
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validatePatchPaths(tc.patch)
+			if tc.wantErr && err == nil {
+				t.Fatalf("expected an error")
+			}
+			if !tc.wantErr && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		})
+	}
+}
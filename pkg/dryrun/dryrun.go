@@ -0,0 +1,220 @@
+package dryrun
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// gitDir is the directory holding the Git repository, whose changes are never reported
+const gitDir = ".git"
+
+var (
+	// repoRoot is the repository that the dry run was started from
+	repoRoot string
+	// tempDir is the copy of the repository that the dry run writes to
+	tempDir string
+)
+
+// Change represents a file that would be created, modified, or deleted within the repository
+type Change struct {
+	// Action is one of create, modify, or delete
+	Action string
+	// Path is the path to the file relative to the root of the repository
+	Path string
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s %s", c.Action, c.Path)
+}
+
+// Start copies the repository in the current working directory to a temporary directory and changes the working directory to it,
+// so that every subsequent change is written to the copy instead of the repository
+func Start() error {
+	var err error
+	repoRoot, err = os.Getwd()
+	if err != nil {
+		return fmt.Errorf("Unable to get current working directory: %s", err)
+	}
+	tempDir, err = ioutil.TempDir("", "charts-build-scripts-dry-run-")
+	if err != nil {
+		return fmt.Errorf("Unable to create temporary directory for dry run: %s", err)
+	}
+	if err := copyTree(repoRoot, tempDir); err != nil {
+		Cleanup()
+		return fmt.Errorf("Encountered error while copying %s to %s for dry run: %s", repoRoot, tempDir, err)
+	}
+	if err := os.Chdir(tempDir); err != nil {
+		Cleanup()
+		return fmt.Errorf("Unable to change working directory to %s for dry run: %s", tempDir, err)
+	}
+	return nil
+}
+
+// Finish writes the changes that would have been made to the repository to w and removes the copy of the repository
+func Finish(w io.Writer) error {
+	if len(tempDir) == 0 {
+		return nil
+	}
+	defer Cleanup()
+	changes, err := getChanges(repoRoot, tempDir)
+	if err != nil {
+		return fmt.Errorf("Encountered error while comparing %s to %s after dry run: %s", repoRoot, tempDir, err)
+	}
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "Dry run: no files would be changed")
+		return nil
+	}
+	fmt.Fprintf(w, "Dry run: %d files would be changed\n", len(changes))
+	for _, change := range changes {
+		fmt.Fprintf(w, "  %s\n", change)
+	}
+	return nil
+}
+
+// Cleanup restores the working directory to the repository and removes the copy of the repository without reporting any changes
+func Cleanup() {
+	if len(tempDir) == 0 {
+		return
+	}
+	os.Chdir(repoRoot)
+	os.RemoveAll(tempDir)
+	tempDir = ""
+}
+
+// copyTree copies every file, directory, and symbolic link within srcDir to dstDir, preserving their modes
+func copyTree(srcDir, dstDir string) error {
+	return filepath.Walk(srcDir, func(srcPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(srcDir, srcPath)
+		if err != nil {
+			return err
+		}
+		dstPath := filepath.Join(dstDir, relPath)
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(srcPath)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(target, dstPath)
+		case info.IsDir():
+			return os.MkdirAll(dstPath, info.Mode().Perm())
+		default:
+			return copyFile(srcPath, dstPath, info.Mode().Perm())
+		}
+	})
+}
+
+// copyFile copies the file at srcPath to dstPath with the given mode
+func copyFile(srcPath, dstPath string, mode os.FileMode) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(dstPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// getChanges returns the files that were created, modified, or deleted in newDir compared to oldDir, ordered by path
+func getChanges(oldDir, newDir string) ([]Change, error) {
+	oldFiles, err := listFiles(oldDir)
+	if err != nil {
+		return nil, err
+	}
+	newFiles, err := listFiles(newDir)
+	if err != nil {
+		return nil, err
+	}
+	var changes []Change
+	for relPath, newInfo := range newFiles {
+		oldInfo, ok := oldFiles[relPath]
+		if !ok {
+			changes = append(changes, Change{Action: "create", Path: relPath})
+			continue
+		}
+		equal, err := equalFiles(filepath.Join(oldDir, relPath), oldInfo, filepath.Join(newDir, relPath), newInfo)
+		if err != nil {
+			return nil, err
+		}
+		if !equal {
+			changes = append(changes, Change{Action: "modify", Path: relPath})
+		}
+	}
+	for relPath := range oldFiles {
+		if _, ok := newFiles[relPath]; !ok {
+			changes = append(changes, Change{Action: "delete", Path: relPath})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes, nil
+}
+
+// listFiles returns the files and symbolic links within dir outside of the Git directory, keyed by their slash-separated path relative to dir
+func listFiles(dir string) (map[string]os.FileInfo, error) {
+	files := make(map[string]os.FileInfo)
+	err := filepath.Walk(dir, func(absPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, absPath)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if relPath == gitDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		files[filepath.ToSlash(relPath)] = info
+		return nil
+	})
+	return files, err
+}
+
+// equalFiles returns whether the files at oldPath and newPath have the same type, mode, and contents
+func equalFiles(oldPath string, oldInfo os.FileInfo, newPath string, newInfo os.FileInfo) (bool, error) {
+	if oldInfo.Mode() != newInfo.Mode() {
+		return false, nil
+	}
+	if oldInfo.Mode()&os.ModeSymlink != 0 {
+		oldTarget, err := os.Readlink(oldPath)
+		if err != nil {
+			return false, err
+		}
+		newTarget, err := os.Readlink(newPath)
+		if err != nil {
+			return false, err
+		}
+		return oldTarget == newTarget, nil
+	}
+	if oldInfo.Size() != newInfo.Size() {
+		return false, nil
+	}
+	oldBytes, err := ioutil.ReadFile(oldPath)
+	if err != nil {
+		return false, err
+	}
+	newBytes, err := ioutil.ReadFile(newPath)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(oldBytes, newBytes), nil
+}
@@ -0,0 +1,141 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Type represents the kind of an event emitted during a run
+type Type string
+
+const (
+	// PackageStarted is emitted when a command starts running on a package
+	PackageStarted Type = "package.started"
+	// PackageSucceeded is emitted when a command finishes running on a package
+	PackageSucceeded Type = "package.succeeded"
+	// PackageFailed is emitted when a command fails to run on a package
+	PackageFailed Type = "package.failed"
+	// AssetProduced is emitted when a chart archive is generated
+	AssetProduced Type = "asset.produced"
+	// ValidationFinding is emitted when a chart or the repository fails a check
+	ValidationFinding Type = "validation.finding"
+
+	// webhookTimeout is the maximum duration to wait for the webhook to accept an event
+	webhookTimeout = 10 * time.Second
+	// queueSize is the number of events that can wait to be sent to the webhook before further events are dropped
+	queueSize = 1024
+)
+
+// Event represents a structured event emitted during a run
+type Event struct {
+	// Type is the kind of the event
+	Type Type `json:"type"`
+	// Time is when the event was emitted
+	Time time.Time `json:"time"`
+	// Command is the command of the run that emitted the event
+	Command string `json:"command,omitempty"`
+	// Package is the package that the event relates to, if any
+	Package string `json:"package,omitempty"`
+	// Asset is the path to the chart archive that was produced, if any
+	Asset string `json:"asset,omitempty"`
+	// Message describes the failure or finding, if any
+	Message string `json:"message,omitempty"`
+}
+
+var (
+	// queue holds the events that are waiting to be sent to the webhook. If nil, events are not emitted
+	queue chan Event
+	// sent is closed once every event in the queue was sent
+	sent chan struct{}
+	// command is the command of the current run
+	command string
+	// dropped indicates that an event was dropped since the queue was full
+	dropped bool
+	// lock guards the queue while events are emitted
+	lock sync.Mutex
+)
+
+// Configure sets the endpoint that events emitted by the command are posted to as JSON
+// Events are posted in the order they are emitted by a single goroutine, so emitting an event never waits for the webhook
+func Configure(url, cmd string) {
+	lock.Lock()
+	defer lock.Unlock()
+	if len(url) == 0 {
+		return
+	}
+	queue, sent, command = make(chan Event, queueSize), make(chan struct{}), cmd
+	go send(url, queue, sent)
+}
+
+// Emit queues the event to be posted to the configured webhook, if any
+// Failing to deliver an event never fails the run; instead, a warning is logged and the event is dropped
+func Emit(event Event) {
+	lock.Lock()
+	defer lock.Unlock()
+	if queue == nil {
+		return
+	}
+	event.Time = time.Now().UTC()
+	event.Command = command
+	select {
+	case queue <- event:
+	default:
+		if !dropped {
+			logrus.Warnf("Unable to queue %s event since the webhook is not keeping up, further events may be dropped", event.Type)
+			dropped = true
+		}
+	}
+}
+
+// Flush waits until every emitted event was posted to the configured webhook and stops emitting events
+func Flush() {
+	lock.Lock()
+	if queue == nil {
+		lock.Unlock()
+		return
+	}
+	close(queue)
+	queue = nil
+	lock.Unlock()
+	<-sent
+}
+
+// send posts each event in the queue to the webhook until the queue is closed
+// Once an event fails to be delivered, no further events are posted
+func send(url string, queue <-chan Event, sent chan<- struct{}) {
+	defer close(sent)
+	failed := false
+	for event := range queue {
+		if failed {
+			continue
+		}
+		if err := post(url, event); err != nil {
+			logrus.Warnf("Unable to send %s event to webhook, no further events will be sent: %s", event.Type, err)
+			failed = true
+		}
+	}
+}
+
+// post sends the event to the webhook
+func post(url string, event Event) error {
+	eventBytes, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: webhookTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(eventBytes))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %s", resp.Status)
+	}
+	return nil
+}
@@ -2,17 +2,24 @@ package filesystem
 
 import (
 	"archive/tar"
+	"archive/zip"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/rancher/charts-build-scripts/pkg/credentials"
+	"github.com/rancher/charts-build-scripts/pkg/ratelimit"
+	"github.com/rancher/charts-build-scripts/pkg/retry"
 )
 
 // GetFilesystem returns a filesystem rooted at the provided path
@@ -137,7 +144,7 @@ func CopyFile(fs billy.Filesystem, srcPath string, dstPath string) error {
 	if !dstExists {
 		dstFile, err = CreateFileAndDirs(fs, dstPath)
 	} else {
-		dstFile, err = fs.OpenFile(dstPath, os.O_WRONLY, os.ModePerm)
+		dstFile, err = fs.OpenFile(dstPath, os.O_WRONLY|os.O_TRUNC, 0644)
 	}
 	if err != nil {
 		return err
@@ -151,19 +158,51 @@ func CopyFile(fs billy.Filesystem, srcPath string, dstPath string) error {
 }
 
 // GetChartArchive gets a chart tgz file from a url and drops it into the path specified on the filesystem
+// Downloads that fail with a network error or a server error are retried
 func GetChartArchive(fs billy.Filesystem, url string, path string) error {
+	return retry.Do(fmt.Sprintf("download %s", url), func() error {
+		return getChartArchive(fs, url, path)
+	})
+}
+
+// getChartArchive makes a single attempt at downloading the chart tgz file from the url
+// Errors that will not be resolved by retrying the download are marked as permanent
+func getChartArchive(fs billy.Filesystem, url string, path string) error {
 	// Create file
 	tgz, err := CreateFileAndDirs(fs, path)
 	if err != nil {
-		return fmt.Errorf("Unable to create tgz file: %s", err)
+		return retry.Permanent(fmt.Errorf("Unable to create tgz file: %s", err))
 	}
 	defer tgz.Close()
 	// Get tgz
-	resp, err := http.Get(url)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return retry.Permanent(fmt.Errorf("Unable to create request for chart archive: %s", err))
+	}
+	creds, err := credentials.GetCredentials(req.URL.Hostname())
+	if err != nil {
+		return retry.Permanent(err)
+	}
+	setRequestAuth(req, creds)
+	defer ratelimit.Acquire(req.URL.Hostname())()
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("Unable to get chart archive: %s", err)
 	}
 	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		if creds == nil {
+			return retry.Permanent(fmt.Errorf("Unable to get chart archive from %s: %s; no credentials were found for %s", url, resp.Status, req.URL.Hostname()))
+		}
+		return retry.Permanent(fmt.Errorf("Unable to get chart archive from %s: %s; the credentials provided for %s were rejected", url, resp.Status, req.URL.Hostname()))
+	case resp.StatusCode != http.StatusOK:
+		err := fmt.Errorf("Unable to get chart archive from %s: %s", url, resp.Status)
+		if isRetryableStatus(resp.StatusCode) {
+			return err
+		}
+		return retry.Permanent(err)
+	}
 	// Copy into the tgz
 	if _, err = io.Copy(tgz, resp.Body); err != nil {
 		return fmt.Errorf("Unable to create chart archive: %s", err)
@@ -171,6 +210,24 @@ func GetChartArchive(fs billy.Filesystem, url string, path string) error {
 	return nil
 }
 
+// isRetryableStatus returns whether a request that failed with the HTTP status code may succeed on a retry
+func isRetryableStatus(statusCode int) bool {
+	return statusCode >= http.StatusInternalServerError || statusCode == http.StatusTooManyRequests || statusCode == http.StatusRequestTimeout
+}
+
+// setRequestAuth authenticates the request with the credentials, if any
+// Credentials that only provide a password are treated as a bearer token
+func setRequestAuth(req *http.Request, creds *credentials.Credentials) {
+	if creds == nil || len(creds.Password) == 0 {
+		return
+	}
+	if len(creds.Username) == 0 {
+		req.Header.Set("Authorization", "Bearer "+creds.Password)
+		return
+	}
+	req.SetBasicAuth(creds.Username, creds.Password)
+}
+
 // UnarchiveTgz attempts to unarchive the tgz file found at tgzPath in the filesystem
 func UnarchiveTgz(fs billy.Filesystem, tgzPath, tgzSubdirectory, destPath string, overwrite bool) error {
 	// Check whether the destPath already exists to avoid overwriting it
@@ -205,16 +262,23 @@ func UnarchiveTgz(fs billy.Filesystem, tgzPath, tgzSubdirectory, destPath string
 		if err != nil {
 			return err
 		}
-		rootPath, err := GetRootPath(h.Name)
+		if h.Name == "pax_global_header" {
+			continue
+		}
+		name, err := SanitizeRelativePath(h.Name)
+		if err != nil {
+			return fmt.Errorf("Unable to unarchive %s: %s", tgzPath, err)
+		}
+		rootPath, err := GetRootPath(name)
 		if err != nil {
 			return err
 		}
 		rootPathWithSubdir := filepath.Join(rootPath, tgzSubdirectory)
-		if len(tgzSubdirectory) > 0 && !strings.HasPrefix(h.Name, rootPathWithSubdir) {
+		if len(tgzSubdirectory) > 0 && !strings.HasPrefix(name, rootPathWithSubdir) {
 			continue
 		}
 		subdirectoryFound = true
-		path, err := MovePath(h.Name, rootPathWithSubdir, destPath)
+		path, err := MovePath(name, rootPathWithSubdir, destPath)
 		if err != nil {
 			return err
 		}
@@ -238,9 +302,6 @@ func UnarchiveTgz(fs billy.Filesystem, tgzPath, tgzSubdirectory, destPath string
 			}
 			continue
 		}
-		if h.Name == "pax_global_header" {
-			continue
-		}
 		return fmt.Errorf("Encountered unknown type of file (name=%s) when unarchiving %s", h.Name, tgzPath)
 	}
 	if len(tgzSubdirectory) > 0 && !subdirectoryFound {
@@ -249,6 +310,138 @@ func UnarchiveTgz(fs billy.Filesystem, tgzPath, tgzSubdirectory, destPath string
 	return nil
 }
 
+// UnarchiveZip attempts to unarchive the zip file found at zipPath in the filesystem
+// If every file in the zip is within a single top-level directory, that directory is treated as the root of the zip
+func UnarchiveZip(fs billy.Filesystem, zipPath, zipSubdirectory, destPath string, overwrite bool) error {
+	// Check whether the destPath already exists to avoid overwriting it
+	if !overwrite {
+		exists, err := PathExists(fs, destPath)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("Cannot unarchive %s into %s/ since the path already exists", zipPath, destPath)
+		}
+	}
+	zipReader, err := zip.OpenReader(GetAbsPath(fs, zipPath))
+	if err != nil {
+		return fmt.Errorf("Unable to read zip formatted file: %s", err)
+	}
+	defer zipReader.Close()
+	// Sanitize the names of all files before extracting any of them
+	names := make([]string, len(zipReader.File))
+	for i, f := range zipReader.File {
+		name, err := SanitizeRelativePath(f.Name)
+		if err != nil {
+			return fmt.Errorf("Unable to unarchive %s: %s", zipPath, err)
+		}
+		names[i] = name
+	}
+	rootPath := getCommonRootPath(names)
+	rootPathWithSubdir := filepath.Join(rootPath, zipSubdirectory)
+	// Iterate through the contents of the zip to unarchive it
+	subdirectoryFound := false
+	for i, f := range zipReader.File {
+		name := names[i]
+		if rootPathWithSubdir != "." && name != rootPathWithSubdir && !strings.HasPrefix(name, rootPathWithSubdir+"/") {
+			continue
+		}
+		subdirectoryFound = true
+		path, err := MovePath(name, strings.TrimPrefix(rootPathWithSubdir, "."), destPath)
+		if err != nil {
+			return err
+		}
+		mode := f.Mode()
+		if mode.IsDir() {
+			if err := fs.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if !mode.IsRegular() {
+			return fmt.Errorf("Encountered unknown type of file (name=%s) when unarchiving %s", f.Name, zipPath)
+		}
+		if err := unarchiveZipFile(fs, f, path); err != nil {
+			return err
+		}
+	}
+	if len(zipSubdirectory) > 0 && !subdirectoryFound {
+		return fmt.Errorf("Subdirectory %s was not found within the folder outputted by the zip file", zipSubdirectory)
+	}
+	return nil
+}
+
+// unarchiveZipFile writes the contents of a file within a zip to the path
+func unarchiveZipFile(fs billy.Filesystem, f *zip.File, path string) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := CreateFileAndDirs(fs, path)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+	return UpdatePermissions(fs, path, int64(f.Mode().Perm()))
+}
+
+// SanitizeRelativePath cleans a path that is meant to be relative to a directory, e.g. the name of a file within an archive or a patch,
+// and returns an error if the path is absolute or would resolve outside of that directory
+func SanitizeRelativePath(name string) (string, error) {
+	if len(name) == 0 {
+		return "", nil
+	}
+	cleanName := filepath.ToSlash(filepath.Clean(strings.ReplaceAll(name, "\\", "/")))
+	if filepath.IsAbs(cleanName) || strings.HasPrefix(cleanName, "/") || cleanName == ".." || strings.HasPrefix(cleanName, "../") {
+		return "", fmt.Errorf("Path %s is absolute or traverses outside of its directory", name)
+	}
+	return cleanName, nil
+}
+
+// SecureJoin joins the relativePath onto baseDir and returns an error if relativePath is absolute or would resolve outside of baseDir, e.g. ../../etc/passwd
+func SecureJoin(baseDir, relativePath string) (string, error) {
+	cleanPath, err := SanitizeRelativePath(relativePath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(baseDir, cleanPath), nil
+}
+
+// getCommonRootPath returns the top-level directory that contains all of the paths, or . if there is no such directory
+func getCommonRootPath(paths []string) string {
+	var commonRootPath string
+	for _, p := range paths {
+		rootPathList := strings.SplitN(p, "/", 2)
+		if len(rootPathList) < 2 && !isDirName(p, paths) {
+			// A file is located at the top level
+			return "."
+		}
+		if len(commonRootPath) == 0 {
+			commonRootPath = rootPathList[0]
+		} else if rootPathList[0] != commonRootPath {
+			return "."
+		}
+	}
+	if len(commonRootPath) == 0 {
+		return "."
+	}
+	return commonRootPath
+}
+
+// isDirName returns whether the name is the parent directory of any of the paths
+func isDirName(name string, paths []string) bool {
+	for _, p := range paths {
+		if strings.HasPrefix(p, name+"/") {
+			return true
+		}
+	}
+	return false
+}
+
 // RelativePathFunc is a function that is applied on a relative path within the given filesystem
 type RelativePathFunc func(fs billy.Filesystem, path string, isDir bool) error
 
@@ -293,6 +486,59 @@ func CopyDir(fs billy.Filesystem, srcDir string, dstDir string) error {
 	})
 }
 
+// CopyFromLocalPath copies the file or directory at the absolute path srcPath on the local filesystem to dstPath within the filesystem
+func CopyFromLocalPath(srcPath string, fs billy.Filesystem, dstPath string) error {
+	return filepath.Walk(srcPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(srcPath, path)
+		if err != nil {
+			return err
+		}
+		absDstPath := GetAbsPath(fs, filepath.Join(dstPath, relPath))
+		if info.IsDir() {
+			return os.MkdirAll(absDstPath, 0755)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(absDstPath), 0755); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(absDstPath, data, 0644)
+	})
+}
+
+// GetDigest returns a SHA256 digest of the paths and contents of all files located at the paths, which may be files or directories
+// Paths that do not exist contribute nothing to the digest
+func GetDigest(fs billy.Filesystem, paths ...string) (string, error) {
+	hash := sha256.New()
+	for _, p := range paths {
+		var filePaths []string
+		err := WalkDir(fs, p, func(fs billy.Filesystem, filePath string, isDir bool) error {
+			if !isDir {
+				filePaths = append(filePaths, filePath)
+			}
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+		sort.Strings(filePaths)
+		for _, filePath := range filePaths {
+			data, err := ioutil.ReadFile(GetAbsPath(fs, filePath))
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(hash, "%s\x00%d\x00", filepath.ToSlash(filePath), len(data))
+			hash.Write(data)
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
 // MakeSubdirectoryRoot makes a particular subdirectory of a path its main directory
 func MakeSubdirectoryRoot(fs billy.Filesystem, path, subdirectory string) error {
 	exists, err := PathExists(fs, filepath.Join(path, subdirectory))
@@ -371,11 +617,12 @@ func GetRootPath(path string) (string, error) {
 }
 
 // MovePath takes a path that is contained within fromDir and returns the same path contained within toDir
+// It returns an error if the path would resolve outside of toDir once moved
 func MovePath(path string, fromDir string, toDir string) (string, error) {
 	if !strings.HasPrefix(path, fromDir) {
 		return "", fmt.Errorf("Path %s does not contain directory %s", path, fromDir)
 	}
 	relativePath := strings.TrimPrefix(path, fromDir)
 	relativePath = strings.TrimPrefix(relativePath, "/")
-	return filepath.Join(toDir, relativePath), nil
+	return SecureJoin(toDir, relativePath)
 }
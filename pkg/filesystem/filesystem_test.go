@@ -0,0 +1,206 @@
+package filesystem
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestSanitizeRelativePath(t *testing.T) {
+	testCases := []struct {
+		name     string
+		path     string
+		expected string
+		wantErr  bool
+	}{
+		{name: "empty", path: "", expected: ""},
+		{name: "file", path: "Chart.yaml", expected: "Chart.yaml"},
+		{name: "nested file", path: "chart/templates/deployment.yaml", expected: "chart/templates/deployment.yaml"},
+		{name: "current directory", path: "./chart/values.yaml", expected: "chart/values.yaml"},
+		{name: "traversal within directory", path: "chart/templates/../values.yaml", expected: "chart/values.yaml"},
+		{name: "backslashes", path: `chart\templates\deployment.yaml`, expected: "chart/templates/deployment.yaml"},
+		{name: "parent directory", path: "..", wantErr: true},
+		{name: "traversal outside directory", path: "../../etc/passwd", wantErr: true},
+		{name: "nested traversal outside directory", path: "chart/../../etc/passwd", wantErr: true},
+		{name: "backslash traversal outside directory", path: `..\..\etc\passwd`, wantErr: true},
+		{name: "absolute", path: "/etc/passwd", wantErr: true},
+		{name: "absolute with backslashes", path: `\etc\passwd`, wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := SanitizeRelativePath(tc.path)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error for %q, got %q", tc.path, actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error for %q: %s", tc.path, err)
+			}
+			if actual != tc.expected {
+				t.Fatalf("expected %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSecureJoin(t *testing.T) {
+	testCases := []struct {
+		name         string
+		baseDir      string
+		relativePath string
+		expected     string
+		wantErr      bool
+	}{
+		{name: "file", baseDir: "charts", relativePath: "Chart.yaml", expected: "charts/Chart.yaml"},
+		{name: "empty", baseDir: "charts", relativePath: "", expected: "charts"},
+		{name: "traversal within directory", baseDir: "charts", relativePath: "templates/../values.yaml", expected: "charts/values.yaml"},
+		{name: "absolute base directory", baseDir: "/tmp/charts", relativePath: "values.yaml", expected: "/tmp/charts/values.yaml"},
+		{name: "traversal outside directory", baseDir: "charts", relativePath: "../../etc/passwd", wantErr: true},
+		{name: "absolute", baseDir: "charts", relativePath: "/etc/passwd", wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := SecureJoin(tc.baseDir, tc.relativePath)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error for %q, got %q", tc.relativePath, actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error for %q: %s", tc.relativePath, err)
+			}
+			if actual != tc.expected {
+				t.Fatalf("expected %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+// tarEntry represents a single entry of a tgz built for a test
+type tarEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	contents string
+}
+
+// writeTgz writes a tgz that contains the entries to tgzPath within dir
+func writeTgz(t *testing.T, dir, tgzPath string, entries []tarEntry) {
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for _, e := range entries {
+		h := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Linkname: e.linkname,
+			Mode:     0644,
+			Size:     int64(len(e.contents)),
+		}
+		if e.typeflag == tar.TypeDir {
+			h.Mode = 0755
+		}
+		if err := tarWriter.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tarWriter.Write([]byte(e.contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, tgzPath), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnarchiveTgz(t *testing.T) {
+	testCases := []struct {
+		name     string
+		entries  []tarEntry
+		expected map[string]string
+		wantErr  bool
+	}{
+		{
+			name: "chart",
+			entries: []tarEntry{
+				{name: "chart/", typeflag: tar.TypeDir},
+				{name: "chart/Chart.yaml", typeflag: tar.TypeReg, contents: "name: chart\n"},
+				{name: "chart/templates/cm.yaml", typeflag: tar.TypeReg, contents: "kind: ConfigMap\n"},
+			},
+			expected: map[string]string{
+				"Chart.yaml":        "name: chart\n",
+				"templates/cm.yaml": "kind: ConfigMap\n",
+			},
+		},
+		{
+			name: "traversal outside directory",
+			entries: []tarEntry{
+				{name: "chart/Chart.yaml", typeflag: tar.TypeReg, contents: "name: chart\n"},
+				{name: "chart/../../escaped.yaml", typeflag: tar.TypeReg, contents: "escaped\n"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "absolute",
+			entries: []tarEntry{
+				{name: "/tmp/escaped.yaml", typeflag: tar.TypeReg, contents: "escaped\n"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "symlink",
+			entries: []tarEntry{
+				{name: "chart/Chart.yaml", typeflag: tar.TypeReg, contents: "name: chart\n"},
+				{name: "chart/values.yaml", typeflag: tar.TypeSymlink, linkname: "/etc/passwd"},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "charts-build-unarchive-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer RemoveAll(GetFilesystem(dir), "")
+			fs := GetFilesystem(filepath.Join(dir, "root"))
+			if err := fs.MkdirAll(".", 0755); err != nil {
+				t.Fatal(err)
+			}
+			writeTgz(t, GetAbsPath(fs, ""), "chart.tgz", tc.entries)
+			err = UnarchiveTgz(fs, "chart.tgz", "", "out", false)
+			if exists, existsErr := PathExists(GetFilesystem(dir), "escaped.yaml"); existsErr != nil || exists {
+				t.Fatalf("unarchiving wrote a file outside of its destination")
+			}
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			for path, contents := range tc.expected {
+				actual, err := ioutil.ReadFile(GetAbsPath(fs, filepath.Join("out", path)))
+				if err != nil {
+					t.Fatalf("unable to read %s: %s", path, err)
+				}
+				if string(actual) != contents {
+					t.Fatalf("expected %s to contain %q, got %q", path, contents, actual)
+				}
+			}
+		})
+	}
+}
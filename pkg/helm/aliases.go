@@ -0,0 +1,177 @@
+package helm
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	"github.com/rancher/charts-build-scripts/pkg/path"
+	"github.com/sirupsen/logrus"
+	helmRepo "helm.sh/helm/v3/pkg/repo"
+)
+
+const (
+	// AliasPolicyDuplicate adds every version of a renamed chart to the Helm index under its previous name as well
+	AliasPolicyDuplicate = "duplicate"
+	// AliasPolicyRedirect annotates the versions released under the previous name of a chart with its current name
+	AliasPolicyRedirect = "redirect"
+
+	// AliasOfAnnotation is the annotation added to entries duplicated under the previous name of a chart to indicate its current name
+	AliasOfAnnotation = "catalog.cattle.io/alias-of"
+	// RenamedToAnnotation is the annotation added to entries released under the previous name of a chart to indicate its current name
+	RenamedToAnnotation = "catalog.cattle.io/renamed-to"
+)
+
+var (
+	// ChartAliases represents the previous names of renamed charts that should still resolve in the Helm index
+	ChartAliases []options.ChartAlias
+)
+
+// validateChartAliases returns an error if any alias is incomplete, uses an unknown policy, or would make a name resolve to more than one chart
+func validateChartAliases(aliases []options.ChartAlias) error {
+	names := make(map[string]bool)
+	for _, alias := range aliases {
+		if len(alias.Name) == 0 || len(alias.Chart) == 0 {
+			return fmt.Errorf("Chart alias %s -> %s must provide both the previous and the current name of the chart", alias.Name, alias.Chart)
+		}
+		if alias.Name == alias.Chart {
+			return fmt.Errorf("Chart alias %s cannot point to itself", alias.Name)
+		}
+		if names[alias.Name] {
+			return fmt.Errorf("Chart alias %s is defined more than once", alias.Name)
+		}
+		names[alias.Name] = true
+		if len(alias.Policy) > 0 && alias.Policy != AliasPolicyDuplicate && alias.Policy != AliasPolicyRedirect {
+			return fmt.Errorf("Policy %s of chart alias %s is invalid: must be %s or %s", alias.Policy, alias.Name, AliasPolicyDuplicate, AliasPolicyRedirect)
+		}
+	}
+	for _, alias := range aliases {
+		if names[alias.Chart] {
+			return fmt.Errorf("Chart alias %s points to %s, which is itself an alias", alias.Name, alias.Chart)
+		}
+	}
+	return nil
+}
+
+// applyChartAliases adds the entries and annotations required by each alias to the Helm index
+// Versions that were released under both names are never overwritten, but duplicates that no longer match the chart version they point to are replaced
+func applyChartAliases(helmIndexFile *helmRepo.IndexFile, aliases []options.ChartAlias) error {
+	if err := validateChartAliases(aliases); err != nil {
+		return err
+	}
+	for _, alias := range aliases {
+		if alias.Policy == AliasPolicyRedirect {
+			for _, chartVersion := range helmIndexFile.Entries[alias.Name] {
+				setAnnotation(chartVersion, RenamedToAnnotation, alias.Chart)
+			}
+			continue
+		}
+		for _, chartVersion := range helmIndexFile.Entries[alias.Chart] {
+			if existing, err := helmIndexFile.Get(alias.Name, chartVersion.Version); err == nil {
+				if existing.Annotations[AliasOfAnnotation] != alias.Chart {
+					logrus.Warnf("Version %s of chart %s was released under its previous name %s, so it is not duplicated under that name", chartVersion.Version, alias.Chart, alias.Name)
+					continue
+				}
+				if existing.Digest != chartVersion.Digest || !reflect.DeepEqual(existing.URLs, chartVersion.URLs) {
+					// The chart version was rebuilt or moved since it was duplicated
+					*existing = *duplicateChartVersion(chartVersion, alias)
+				}
+				continue
+			}
+			helmIndexFile.Entries[alias.Name] = append(helmIndexFile.Entries[alias.Name], duplicateChartVersion(chartVersion, alias))
+		}
+	}
+	helmIndexFile.SortEntries()
+	return nil
+}
+
+// duplicateChartVersion returns a copy of the entry of a chart version that is listed under the previous name of the chart
+func duplicateChartVersion(chartVersion *helmRepo.ChartVersion, alias options.ChartAlias) *helmRepo.ChartVersion {
+	metadata := *chartVersion.Metadata
+	metadata.Name = alias.Name
+	metadata.Annotations = make(map[string]string, len(chartVersion.Annotations)+1)
+	for k, v := range chartVersion.Annotations {
+		metadata.Annotations[k] = v
+	}
+	metadata.Annotations[AliasOfAnnotation] = alias.Chart
+	duplicate := *chartVersion
+	duplicate.Metadata = &metadata
+	duplicate.URLs = append([]string{}, chartVersion.URLs...)
+	return &duplicate
+}
+
+// setAnnotation sets the annotation on the entry of a chart version
+func setAnnotation(chartVersion *helmRepo.ChartVersion, key, value string) {
+	if chartVersion.Annotations == nil {
+		chartVersion.Annotations = make(map[string]string)
+	}
+	chartVersion.Annotations[key] = value
+}
+
+// ValidateChartAliases returns the inconsistencies between the aliases and the Helm index of the repository
+// Every version of a duplicated chart must be listed under its previous name with the same digest, every version released under the previous name
+// of a redirected chart must be annotated with its current name, and no entry may be annotated as an alias that is not defined
+func ValidateChartAliases(rootFs billy.Filesystem, aliases []options.ChartAlias) ([]string, error) {
+	if err := validateChartAliases(aliases); err != nil {
+		return nil, err
+	}
+	exists, err := filesystem.PathExists(rootFs, path.RepositoryHelmIndexFile)
+	if err != nil || !exists {
+		return nil, err
+	}
+	helmIndexFile, err := helmRepo.LoadIndexFile(filesystem.GetAbsPath(rootFs, path.RepositoryHelmIndexFile))
+	if err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to load %s: %s", path.RepositoryHelmIndexFile, err)
+	}
+	aliasesByName := make(map[string]options.ChartAlias)
+	for _, alias := range aliases {
+		aliasesByName[alias.Name] = alias
+	}
+	var inconsistencies []string
+	for _, alias := range aliases {
+		if len(helmIndexFile.Entries[alias.Chart]) == 0 {
+			inconsistencies = append(inconsistencies, fmt.Sprintf("Chart %s that alias %s points to does not exist in %s", alias.Chart, alias.Name, path.RepositoryHelmIndexFile))
+			continue
+		}
+		if alias.Policy == AliasPolicyRedirect {
+			for _, chartVersion := range helmIndexFile.Entries[alias.Name] {
+				if chartVersion.Annotations[RenamedToAnnotation] != alias.Chart {
+					inconsistencies = append(inconsistencies, fmt.Sprintf("Version %s of chart %s must set the annotation %s: %s", chartVersion.Version, alias.Name, RenamedToAnnotation, alias.Chart))
+				}
+			}
+			continue
+		}
+		for _, chartVersion := range helmIndexFile.Entries[alias.Chart] {
+			duplicate, err := helmIndexFile.Get(alias.Name, chartVersion.Version)
+			if err != nil {
+				inconsistencies = append(inconsistencies, fmt.Sprintf("Version %s of chart %s is not listed under its previous name %s", chartVersion.Version, alias.Chart, alias.Name))
+				continue
+			}
+			if duplicate.Annotations[AliasOfAnnotation] == alias.Chart && duplicate.Digest != chartVersion.Digest {
+				inconsistencies = append(inconsistencies, fmt.Sprintf("Version %s of chart %s does not have the same digest as its duplicate under its previous name %s", chartVersion.Version, alias.Chart, alias.Name))
+			}
+		}
+	}
+	names := make([]string, 0, len(helmIndexFile.Entries))
+	for name := range helmIndexFile.Entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, chartVersion := range helmIndexFile.Entries[name] {
+			for _, annotation := range []string{AliasOfAnnotation, RenamedToAnnotation} {
+				chart, ok := chartVersion.Annotations[annotation]
+				if !ok {
+					continue
+				}
+				if alias, defined := aliasesByName[name]; !defined || alias.Chart != chart {
+					inconsistencies = append(inconsistencies, fmt.Sprintf("Version %s of chart %s sets the annotation %s: %s but no such chart alias is defined", chartVersion.Version, name, annotation, chart))
+				}
+			}
+		}
+	}
+	return inconsistencies, nil
+}
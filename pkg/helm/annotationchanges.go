@@ -0,0 +1,125 @@
+package helm
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	helmLoader "helm.sh/helm/v3/pkg/chart/loader"
+	helmChartutil "helm.sh/helm/v3/pkg/chartutil"
+)
+
+var (
+	// CriticalAnnotations are annotations that determine whether and where a chart is visible in the catalog of Rancher
+	CriticalAnnotations = []string{
+		"catalog.cattle.io/kube-version",
+		"catalog.cattle.io/rancher-version",
+		"catalog.cattle.io/permits-os",
+		"catalog.cattle.io/auto-install",
+	}
+	// BlockAnnotationChanges indicates that a package should not be exported if any of its charts change a critical annotation from the version that precedes it
+	// without the change being acknowledged in its package.yaml. Otherwise, such changes are only logged
+	BlockAnnotationChanges = false
+)
+
+// AnnotationChange represents a change to a critical annotation between a chart version and the version that precedes it
+type AnnotationChange struct {
+	// Chart is the name of the chart
+	Chart string
+	// Annotation is the name of the critical annotation
+	Annotation string
+	// PreviousVersion is the version of the chart that precedes the version being exported
+	PreviousVersion string
+	// Previous is the value of the annotation on PreviousVersion, or empty if it was not set
+	Previous string
+	// Current is the value of the annotation on the version being exported, or empty if it is not set
+	Current string
+}
+
+func (c AnnotationChange) String() string {
+	return fmt.Sprintf("%s: %s changed from %s in %s to %s", c.Chart, c.Annotation, annotationValueOrUnset(c.Previous), c.PreviousVersion, annotationValueOrUnset(c.Current))
+}
+
+// annotationValueOrUnset quotes the value of an annotation or describes it as unset
+func annotationValueOrUnset(val string) string {
+	if len(val) == 0 {
+		return "unset"
+	}
+	return fmt.Sprintf("%q", val)
+}
+
+// GetAnnotationChanges returns the changes to critical annotations between the chart at helmChartPath, once it is exported with chartVersion and annotations,
+// and the latest version of the chart that precedes it within packageChartsDirpath
+func GetAnnotationChanges(rootFs, fs billy.Filesystem, helmChartPath, chartVersion string, annotations map[string]string, packageChartsDirpath string) ([]AnnotationChange, error) {
+	chart, err := helmLoader.Load(filesystem.GetAbsPath(fs, helmChartPath))
+	if err != nil {
+		return nil, fmt.Errorf("Could not load Helm chart: %s", err)
+	}
+	version, err := semver.NewVersion(chart.Metadata.Version + chartVersion)
+	if err != nil {
+		return nil, fmt.Errorf("Chart version %s%s is not a valid semantic version: %s", chart.Metadata.Version, chartVersion, err)
+	}
+	exportedCharts, err := GetExportedCharts(rootFs, packageChartsDirpath)
+	if err != nil {
+		return nil, err
+	}
+	var previousVersion *semver.Version
+	var previousChart ExportedChart
+	for _, exportedChart := range exportedCharts {
+		if exportedChart.Name != chart.Metadata.Name {
+			continue
+		}
+		v, err := semver.NewVersion(exportedChart.Version)
+		if err != nil || !v.LessThan(version) {
+			continue
+		}
+		if previousVersion == nil || v.GreaterThan(previousVersion) {
+			previousVersion = v
+			previousChart = exportedChart
+		}
+	}
+	if previousVersion == nil {
+		return nil, nil
+	}
+	previousChartYamlPath := filepath.Join(previousChart.Path, "Chart.yaml")
+	previousMetadata, err := helmChartutil.LoadChartfile(filesystem.GetAbsPath(rootFs, previousChartYamlPath))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load %s: %s", previousChartYamlPath, err)
+	}
+	currentAnnotations := make(map[string]string, len(chart.Metadata.Annotations)+len(annotations))
+	for annotation, val := range chart.Metadata.Annotations {
+		currentAnnotations[annotation] = val
+	}
+	for annotation, val := range annotations {
+		currentAnnotations[annotation] = val
+	}
+	var changes []AnnotationChange
+	for _, annotation := range CriticalAnnotations {
+		previous := normalizeAnnotationValue(annotation, previousMetadata.Annotations[annotation])
+		current := normalizeAnnotationValue(annotation, currentAnnotations[annotation])
+		if previous == current {
+			continue
+		}
+		changes = append(changes, AnnotationChange{
+			Chart:           chart.Metadata.Name,
+			Annotation:      annotation,
+			PreviousVersion: previousChart.Version,
+			Previous:        previous,
+			Current:         current,
+		})
+	}
+	return changes, nil
+}
+
+// normalizeAnnotationValue drops any release candidate version from the value of an annotation that is modified on export,
+// so that releasing a chart version is not mistaken for a change to the annotation
+func normalizeAnnotationValue(annotation, val string) string {
+	exportAnnotation, ok := exportAnnotations[annotation]
+	if !ok {
+		return val
+	}
+	newValWithoutRC, _ := exportAnnotation.dropRCVersion(val)
+	return newValWithoutRC
+}
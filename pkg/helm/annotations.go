@@ -1,7 +1,13 @@
 package helm
 
 import (
+	"fmt"
+	"path/filepath"
 	"strings"
+
+	"github.com/sirupsen/logrus"
+	helmLoader "helm.sh/helm/v3/pkg/chart/loader"
+	helmChartutil "helm.sh/helm/v3/pkg/chartutil"
 )
 
 // ExportAnnotation is an annotation that is modified on an export
@@ -25,3 +31,22 @@ var (
 		"catalog.cattle.io/auto-install": &AutoInstallAnnotation{},
 	}
 )
+
+// addAnnotations adds the annotations to the Chart.yaml of the chart archive at absTgzPath, overriding any existing annotations of the same name
+func addAnnotations(absTgzPath string, annotations map[string]string) error {
+	chart, err := helmLoader.Load(absTgzPath)
+	if err != nil {
+		return fmt.Errorf("Could not load Helm chart archive %s: %s", absTgzPath, err)
+	}
+	if chart.Metadata.Annotations == nil {
+		chart.Metadata.Annotations = make(map[string]string)
+	}
+	for annotation, val := range annotations {
+		chart.Metadata.Annotations[annotation] = val
+	}
+	if _, err := helmChartutil.Save(chart, filepath.Dir(absTgzPath)); err != nil {
+		return fmt.Errorf("Could not save Helm chart archive %s: %s", absTgzPath, err)
+	}
+	logrus.Infof("Added %d annotations to %s", len(annotations), filepath.Base(absTgzPath))
+	return nil
+}
@@ -0,0 +1,119 @@
+package helm
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// servedAPIVersion represents an API version built into Kubernetes along with the minor versions of Kubernetes 1.x that serve it by default
+type servedAPIVersion struct {
+	// apiVersion is the group and version of the API, e.g. apps/v1
+	apiVersion string
+	// introduced is the first minor version that serves the API version
+	introduced uint64
+	// removed is the first minor version that no longer serves the API version, or 0 if it is still served
+	removed uint64
+}
+
+var (
+	// builtInGroups are the API groups built into Kubernetes, as opposed to those defined by CRDs or aggregated API servers
+	builtInGroups = map[string]bool{
+		"":                             true,
+		"admissionregistration.k8s.io": true,
+		"apiextensions.k8s.io":         true,
+		"apiregistration.k8s.io":       true,
+		"apps":                         true,
+		"authentication.k8s.io":        true,
+		"authorization.k8s.io":         true,
+		"autoscaling":                  true,
+		"batch":                        true,
+		"certificates.k8s.io":          true,
+		"coordination.k8s.io":          true,
+		"discovery.k8s.io":             true,
+		"events.k8s.io":                true,
+		"extensions":                   true,
+		"flowcontrol.apiserver.k8s.io": true,
+		"internal.apiserver.k8s.io":    true,
+		"networking.k8s.io":            true,
+		"node.k8s.io":                  true,
+		"policy":                       true,
+		"rbac.authorization.k8s.io":    true,
+		"resource.k8s.io":              true,
+		"scheduling.k8s.io":            true,
+		"settings.k8s.io":              true,
+		"storage.k8s.io":               true,
+		"storagemigration.k8s.io":      true,
+	}
+
+	// servedAPIVersions are the API versions built into Kubernetes that are served by default, excluding alpha versions
+	servedAPIVersions = []servedAPIVersion{
+		{"v1", 0, 0},
+		{"admissionregistration.k8s.io/v1", 16, 0},
+		{"admissionregistration.k8s.io/v1beta1", 9, 22},
+		{"apiextensions.k8s.io/v1", 16, 0},
+		{"apiextensions.k8s.io/v1beta1", 7, 22},
+		{"apiregistration.k8s.io/v1", 10, 0},
+		{"apiregistration.k8s.io/v1beta1", 7, 22},
+		{"apps/v1", 9, 0},
+		{"apps/v1beta1", 6, 16},
+		{"apps/v1beta2", 8, 16},
+		{"authentication.k8s.io/v1", 6, 0},
+		{"authentication.k8s.io/v1beta1", 3, 22},
+		{"authorization.k8s.io/v1", 6, 0},
+		{"authorization.k8s.io/v1beta1", 3, 22},
+		{"autoscaling/v1", 2, 0},
+		{"autoscaling/v2", 23, 0},
+		{"autoscaling/v2beta1", 8, 25},
+		{"autoscaling/v2beta2", 12, 26},
+		{"batch/v1", 2, 0},
+		{"batch/v1beta1", 8, 25},
+		{"certificates.k8s.io/v1", 19, 0},
+		{"certificates.k8s.io/v1beta1", 6, 22},
+		{"coordination.k8s.io/v1", 14, 0},
+		{"coordination.k8s.io/v1beta1", 12, 22},
+		{"discovery.k8s.io/v1", 21, 0},
+		{"discovery.k8s.io/v1beta1", 17, 25},
+		{"events.k8s.io/v1", 19, 0},
+		{"events.k8s.io/v1beta1", 8, 25},
+		{"extensions/v1beta1", 2, 22},
+		{"flowcontrol.apiserver.k8s.io/v1", 29, 0},
+		{"flowcontrol.apiserver.k8s.io/v1beta1", 20, 26},
+		{"flowcontrol.apiserver.k8s.io/v1beta2", 23, 29},
+		{"flowcontrol.apiserver.k8s.io/v1beta3", 26, 32},
+		{"networking.k8s.io/v1", 8, 0},
+		{"networking.k8s.io/v1beta1", 14, 22},
+		{"node.k8s.io/v1", 20, 0},
+		{"node.k8s.io/v1beta1", 14, 25},
+		{"policy/v1", 21, 0},
+		{"policy/v1beta1", 5, 25},
+		{"rbac.authorization.k8s.io/v1", 8, 0},
+		{"rbac.authorization.k8s.io/v1beta1", 6, 22},
+		{"resource.k8s.io/v1", 34, 0},
+		{"scheduling.k8s.io/v1", 14, 0},
+		{"scheduling.k8s.io/v1beta1", 11, 22},
+		{"storage.k8s.io/v1", 6, 0},
+		{"storage.k8s.io/v1beta1", 5, 27},
+	}
+)
+
+// getServedAPIVersions returns the API versions built into Kubernetes that are served by default by the Kubernetes version, e.g. v1.22.0
+func getServedAPIVersions(kubeVersion string) ([]string, error) {
+	version, err := semver.NewVersion(kubeVersion)
+	if err != nil {
+		return nil, fmt.Errorf("Kubernetes version %s is invalid: %s", kubeVersion, err)
+	}
+	if version.Major() != 1 {
+		return nil, fmt.Errorf("Kubernetes version %s is invalid: only Kubernetes 1.x is supported", kubeVersion)
+	}
+	var apiVersions []string
+	for _, served := range servedAPIVersions {
+		if version.Minor() < served.introduced || (served.removed > 0 && version.Minor() >= served.removed) {
+			continue
+		}
+		apiVersions = append(apiVersions, served.apiVersion)
+	}
+	sort.Strings(apiVersions)
+	return apiVersions, nil
+}
@@ -0,0 +1,74 @@
+package helm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	helmLint "helm.sh/helm/v3/pkg/lint"
+	helmLintSupport "helm.sh/helm/v3/pkg/lint/support"
+)
+
+// ChartLintMessage represents a message reported by helm lint about a chart
+type ChartLintMessage struct {
+	// Path is the path to the chart that was linted
+	Path string
+	// File is the file within the chart that the message is about, e.g. templates/
+	File string
+	// Message describes the problem
+	Message string
+	// Failure indicates that the message fails the lint, which is the case for errors and, if linting strictly, warnings
+	Failure bool
+}
+
+func (m ChartLintMessage) String() string {
+	return fmt.Sprintf("%s/%s: %s", m.Path, strings.TrimSuffix(m.File, "/"), m.Message)
+}
+
+// ValidateHelmLintSuppressions returns an error if any of the suppressions does not provide a message, since it would suppress every message
+func ValidateHelmLintSuppressions(suppressions []options.HelmLintSuppression) error {
+	for _, suppression := range suppressions {
+		if len(strings.TrimSpace(suppression.Message)) == 0 {
+			return fmt.Errorf("Helm lint suppressions must provide a message, since they would suppress every message otherwise")
+		}
+	}
+	return nil
+}
+
+// LintChart runs helm lint against the chart at helmChartPath with its default values and returns the errors and warnings reported
+// that do not match any of the suppressions. If strict is set, warnings fail the lint as well as errors
+func LintChart(fs billy.Filesystem, helmChartPath string, strict bool, suppressions []options.HelmLintSuppression) []ChartLintMessage {
+	linter := helmLint.All(filesystem.GetAbsPath(fs, helmChartPath), nil, renderReleaseNamespace, strict)
+	var messages []ChartLintMessage
+	for _, m := range linter.Messages {
+		if m.Severity < helmLintSupport.WarningSev {
+			continue
+		}
+		message := ChartLintMessage{
+			Path:    helmChartPath,
+			File:    m.Path,
+			Message: m.Err.Error(),
+			Failure: m.Severity == helmLintSupport.ErrorSev || strict,
+		}
+		if isChartLintMessageSuppressed(message, suppressions) {
+			continue
+		}
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+// isChartLintMessageSuppressed returns whether the message matches any of the suppressions
+func isChartLintMessageSuppressed(message ChartLintMessage, suppressions []options.HelmLintSuppression) bool {
+	for _, suppression := range suppressions {
+		if len(suppression.File) > 0 && strings.TrimSuffix(suppression.File, "/") != strings.TrimSuffix(message.File, "/") {
+			continue
+		}
+		if strings.Contains(message.Message, suppression.Message) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,275 @@
+package helm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	helmLoader "helm.sh/helm/v3/pkg/chart/loader"
+)
+
+const (
+	// systemDefaultRegistryHelper is the name of the template that prefixes images with the system default registry configured in Rancher
+	systemDefaultRegistryHelper = "system_default_registry"
+	// pspEnabledValue is the value that toggles whether PodSecurityPolicies are deployed
+	pspEnabledValue = ".Values.global.cattle.psp.enabled"
+)
+
+var (
+	// conventionAnnotations returns the annotations that Rancher feature charts are expected to have for a chart with the given name
+	conventionAnnotations = func(name string) map[string]string {
+		return map[string]string{
+			"catalog.cattle.io/certified":    "rancher",
+			"catalog.cattle.io/display-name": name,
+			"catalog.cattle.io/release-name": name,
+		}
+	}
+
+	// globalValuesBlock is the global values block that Rancher sets on installing a feature chart
+	globalValuesBlock = []string{
+		"cattle:",
+		"  systemDefaultRegistry: \"\"",
+		"  psp:",
+		"    enabled: false",
+	}
+
+	// systemDefaultRegistryHelperTemplate defines the system_default_registry template
+	systemDefaultRegistryHelperTemplate = strings.Join([]string{
+		"{{- define \"system_default_registry\" -}}",
+		"{{- if .Values.global.cattle.systemDefaultRegistry -}}",
+		"{{- printf \"%s/\" .Values.global.cattle.systemDefaultRegistry -}}",
+		"{{- end -}}",
+		"{{- end -}}",
+	}, "\n")
+
+	globalKeyRegex          = regexp.MustCompile(`(?m)^global:[ \t]*(#.*)?$`)
+	annotationsKeyRegex     = regexp.MustCompile(`(?m)^annotations:[ \t]*(#.*)?$`)
+	documentSeparatorRegex  = regexp.MustCompile(`(?m)^---.*$\n?`)
+	childIndentRegex        = regexp.MustCompile(`^\r?\n(?:[ \t]*(?:#.*)?\r?\n)*([ \t]*)[^ \t\r\n#]`)
+	podSecurityPolicyRegex  = regexp.MustCompile(`(?m)^kind:[ \t]*PodSecurityPolicy[ \t]*$`)
+	helpersTemplateFilepath = filepath.Join("templates", "_helpers.tpl")
+)
+
+// ApplyRancherConventions applies the conventions of Rancher feature charts to the chart at helmChartPath
+// This adds the catalog.cattle.io annotations, the global.cattle values block, the system_default_registry helper,
+// and wraps any PodSecurityPolicy templates in a toggle on global.cattle.psp.enabled. Existing conventions are left untouched
+func ApplyRancherConventions(fs billy.Filesystem, helmChartPath string) error {
+	if err := addConventionAnnotations(fs, helmChartPath); err != nil {
+		return fmt.Errorf("Encountered error while trying to add annotations to %s: %s", helmChartPath, err)
+	}
+	if err := addGlobalValues(fs, helmChartPath); err != nil {
+		return fmt.Errorf("Encountered error while trying to add global values to %s: %s", helmChartPath, err)
+	}
+	if err := addSystemDefaultRegistryHelper(fs, helmChartPath); err != nil {
+		return fmt.Errorf("Encountered error while trying to add %s helper to %s: %s", systemDefaultRegistryHelper, helmChartPath, err)
+	}
+	if err := addPodSecurityPolicyToggle(fs, helmChartPath); err != nil {
+		return fmt.Errorf("Encountered error while trying to toggle PodSecurityPolicies in %s: %s", helmChartPath, err)
+	}
+	return nil
+}
+
+// addConventionAnnotations adds any conventional annotations that are missing from the Chart.yaml, retaining the rest of the file as is
+func addConventionAnnotations(fs billy.Filesystem, helmChartPath string) error {
+	chart, err := helmLoader.Load(filesystem.GetAbsPath(fs, helmChartPath))
+	if err != nil {
+		return err
+	}
+	conventions := conventionAnnotations(chart.Metadata.Name)
+	var missing []string
+	for annotation := range conventions {
+		if _, ok := chart.Metadata.Annotations[annotation]; !ok {
+			missing = append(missing, annotation)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	var annotationLines []string
+	for _, annotation := range missing {
+		annotationBytes, err := yaml.Marshal(map[string]string{annotation: conventions[annotation]})
+		if err != nil {
+			return err
+		}
+		annotationLines = append(annotationLines, strings.TrimSuffix(string(annotationBytes), "\n"))
+	}
+	chartYamlPath := filepath.Join(helmChartPath, "Chart.yaml")
+	chartYamlBytes, err := ioutil.ReadFile(filesystem.GetAbsPath(fs, chartYamlPath))
+	if err != nil {
+		return err
+	}
+	chartYaml := string(chartYamlBytes)
+	loc := annotationsKeyRegex.FindStringIndex(chartYaml)
+	if loc == nil {
+		if len(chart.Metadata.Annotations) > 0 || strings.Contains("\n"+chartYaml, "\nannotations:") {
+			logrus.Warnf("Unable to find annotations key in %s, skipping annotations", chartYamlPath)
+			return nil
+		}
+		if len(chartYaml) > 0 && !strings.HasSuffix(chartYaml, "\n") {
+			chartYaml += "\n"
+		}
+		chartYaml += "annotations:\n" + indent(annotationLines, "  ")
+		return writeChartFile(fs, chartYamlPath, []byte(chartYaml))
+	}
+	chartYaml = chartYaml[:loc[1]] + "\n" + strings.TrimSuffix(indent(annotationLines, getChildIndent(chartYaml[loc[1]:])), "\n") + chartYaml[loc[1]:]
+	return writeChartFile(fs, chartYamlPath, []byte(chartYaml))
+}
+
+// addGlobalValues adds the global.cattle values block to the values.yaml, retaining the rest of the file as is
+func addGlobalValues(fs billy.Filesystem, helmChartPath string) error {
+	valuesPath := filepath.Join(helmChartPath, "values.yaml")
+	exists, err := filesystem.PathExists(fs, valuesPath)
+	if err != nil {
+		return err
+	}
+	var values string
+	if exists {
+		valuesBytes, err := ioutil.ReadFile(filesystem.GetAbsPath(fs, valuesPath))
+		if err != nil {
+			return err
+		}
+		values = string(valuesBytes)
+	}
+	var parsedValues map[string]interface{}
+	if err := yaml.Unmarshal([]byte(values), &parsedValues); err != nil {
+		return err
+	}
+	global, hasGlobal := parsedValues["global"]
+	if !hasGlobal {
+		if len(values) > 0 && !strings.HasSuffix(values, "\n") {
+			values += "\n"
+		}
+		values += "\nglobal:\n" + indent(globalValuesBlock, "  ")
+		return writeChartFile(fs, valuesPath, []byte(values))
+	}
+	if globalValues, ok := global.(map[interface{}]interface{}); ok {
+		if _, hasCattle := globalValues["cattle"]; hasCattle {
+			logrus.Infof("%s already sets global.cattle, skipping global values", valuesPath)
+			return nil
+		}
+	}
+	loc := globalKeyRegex.FindStringIndex(values)
+	if loc == nil {
+		logrus.Warnf("Unable to find global key in %s, skipping global values", valuesPath)
+		return nil
+	}
+	// The block is indented like the existing children of global so that they remain its siblings
+	values = values[:loc[1]] + "\n" + strings.TrimSuffix(indent(indentBlock(globalValuesBlock, getChildIndent(values[loc[1]:])), ""), "\n") + values[loc[1]:]
+	return writeChartFile(fs, valuesPath, []byte(values))
+}
+
+// addSystemDefaultRegistryHelper adds the system_default_registry template to the templates/_helpers.tpl
+func addSystemDefaultRegistryHelper(fs billy.Filesystem, helmChartPath string) error {
+	helpersPath := filepath.Join(helmChartPath, helpersTemplateFilepath)
+	exists, err := filesystem.PathExists(fs, helpersPath)
+	if err != nil {
+		return err
+	}
+	var helpers string
+	if exists {
+		helpersBytes, err := ioutil.ReadFile(filesystem.GetAbsPath(fs, helpersPath))
+		if err != nil {
+			return err
+		}
+		helpers = string(helpersBytes)
+	}
+	if strings.Contains(helpers, fmt.Sprintf("define %q", systemDefaultRegistryHelper)) {
+		return nil
+	}
+	if len(helpers) > 0 {
+		if !strings.HasSuffix(helpers, "\n") {
+			helpers += "\n"
+		}
+		helpers += "\n"
+	}
+	helpers += systemDefaultRegistryHelperTemplate + "\n"
+	return writeChartFile(fs, helpersPath, []byte(helpers))
+}
+
+// addPodSecurityPolicyToggle wraps each YAML document of a template that defines a PodSecurityPolicy in a conditional on global.cattle.psp.enabled
+// Other documents within the same template are left untouched
+func addPodSecurityPolicyToggle(fs billy.Filesystem, helmChartPath string) error {
+	templatesPath := filepath.Join(helmChartPath, "templates")
+	exists, err := filesystem.PathExists(fs, templatesPath)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+	return filesystem.WalkDir(fs, templatesPath, func(fs billy.Filesystem, templatePath string, isDir bool) error {
+		if isDir || !strings.HasSuffix(templatePath, ".yaml") {
+			return nil
+		}
+		templateBytes, err := ioutil.ReadFile(filesystem.GetAbsPath(fs, templatePath))
+		if err != nil {
+			return err
+		}
+		template := string(templateBytes)
+		if !podSecurityPolicyRegex.MatchString(template) {
+			return nil
+		}
+		var b strings.Builder
+		var toggled bool
+		start := 0
+		for _, loc := range append(documentSeparatorRegex.FindAllStringIndex(template, -1), []int{len(template), len(template)}) {
+			document := template[start:loc[0]]
+			if podSecurityPolicyRegex.MatchString(document) && !strings.Contains(document, pspEnabledValue) {
+				if !strings.HasSuffix(document, "\n") {
+					document += "\n"
+				}
+				document = fmt.Sprintf("{{- if %s }}\n%s{{- end }}\n", pspEnabledValue, document)
+				toggled = true
+			}
+			b.WriteString(document)
+			b.WriteString(template[loc[0]:loc[1]])
+			start = loc[1]
+		}
+		if !toggled {
+			return nil
+		}
+		logrus.Infof("Toggling PodSecurityPolicy in %s", templatePath)
+		return writeChartFile(fs, templatePath, []byte(b.String()))
+	})
+}
+
+// indentBlock returns the lines of a block indented by two spaces per level with each level indented by childIndent instead
+func indentBlock(lines []string, childIndent string) []string {
+	indented := make([]string, len(lines))
+	for i, line := range lines {
+		trimmed := strings.TrimLeft(line, " ")
+		indented[i] = childIndent + strings.Repeat(childIndent, (len(line)-len(trimmed))/2) + trimmed
+	}
+	return indented
+}
+
+// getChildIndent returns the indentation of the first child of the key whose line ends right before contents, defaulting to two spaces if it has none
+func getChildIndent(contents string) string {
+	match := childIndentRegex.FindStringSubmatch(contents)
+	if match == nil || len(match[1]) == 0 {
+		return "  "
+	}
+	return match[1]
+}
+
+// indent prefixes each line with the prefix and joins them into a newline-terminated string
+func indent(lines []string, prefix string) string {
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(prefix + line + "\n")
+	}
+	return b.String()
+}
+
+// writeChartFile replaces the contents of a file within a chart, creating it if it does not exist
+func writeChartFile(fs billy.Filesystem, path string, data []byte) error {
+	return ioutil.WriteFile(filesystem.GetAbsPath(fs, path), data, 0644)
+}
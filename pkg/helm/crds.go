@@ -1,30 +1,99 @@
 package helm
 
 import (
+	"bytes"
 	"fmt"
-	"os"
+	"io"
+	"io/ioutil"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
 
 	"github.com/go-git/go-billy/v5"
 	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/path"
 	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
 	helmLoader "helm.sh/helm/v3/pkg/chart/loader"
+	k8sYaml "sigs.k8s.io/yaml"
+)
+
+const (
+	// ManagedByLabel is the label that identifies the tool that manages a resource
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	// ChartLabel is the label that identifies the chart and version that deployed a resource
+	ChartLabel = "helm.sh/chart"
+	// ReleaseNameAnnotation is the annotation Helm uses to identify the release that owns a resource
+	ReleaseNameAnnotation = "meta.helm.sh/release-name"
+	// ReleaseNamespaceAnnotation is the annotation Helm uses to identify the namespace of the release that owns a resource
+	ReleaseNamespaceAnnotation = "meta.helm.sh/release-namespace"
+)
+
+var (
+	// TemplateActionRegex matches any Go template actions within a file
+	TemplateActionRegex = regexp.MustCompile(`(?s){{.*?}}`)
 )
 
 // CopyCRDsFromChart copies the CRDs from a chart to another chart
 func CopyCRDsFromChart(fs billy.Filesystem, srcHelmChartPath, srcCRDsDir, dstHelmChartPath, destCRDsDir string) error {
-	if err := filesystem.RemoveAll(fs, filepath.Join(dstHelmChartPath, destCRDsDir)); err != nil {
+	srcCRDsDirpath, err := filesystem.SecureJoin(srcHelmChartPath, srcCRDsDir)
+	if err != nil {
+		return fmt.Errorf("Invalid CRD directory %s: %s", srcCRDsDir, err)
+	}
+	dstCRDsDirpath, err := filesystem.SecureJoin(dstHelmChartPath, destCRDsDir)
+	if err != nil {
+		return fmt.Errorf("Invalid CRD directory %s: %s", destCRDsDir, err)
+	}
+	if err := filesystem.RemoveAll(fs, dstCRDsDirpath); err != nil {
 		return err
 	}
-	if err := fs.MkdirAll(filepath.Join(dstHelmChartPath, destCRDsDir), os.ModePerm); err != nil {
+	if err := fs.MkdirAll(dstCRDsDirpath, 0755); err != nil {
 		return err
 	}
-	srcCRDsDirpath := filepath.Join(srcHelmChartPath, srcCRDsDir)
-	dstCRDsDirpath := filepath.Join(dstHelmChartPath, destCRDsDir)
 	logrus.Infof("Copying CRDs from %s to %s", srcCRDsDirpath, dstCRDsDirpath)
 	return filesystem.CopyDir(fs, srcCRDsDirpath, dstCRDsDirpath)
 }
 
+// CopyCRDFilesFromChart copies the CRD files at crdFiles, which are relative to srcCRDsDir, from a chart to another chart
+// Unlike CopyCRDsFromChart, any other files within destCRDsDir are kept
+func CopyCRDFilesFromChart(fs billy.Filesystem, srcHelmChartPath, srcCRDsDir, dstHelmChartPath, destCRDsDir string, crdFiles []string) error {
+	for _, crdFile := range crdFiles {
+		srcCRDFilepath, err := filesystem.SecureJoin(filepath.Join(srcHelmChartPath, srcCRDsDir), crdFile)
+		if err != nil {
+			return fmt.Errorf("Invalid CRD file %s: %s", crdFile, err)
+		}
+		dstCRDFilepath, err := filesystem.SecureJoin(filepath.Join(dstHelmChartPath, destCRDsDir), crdFile)
+		if err != nil {
+			return fmt.Errorf("Invalid CRD file %s: %s", crdFile, err)
+		}
+		logrus.Infof("Copying %s to %s", srcCRDFilepath, dstCRDFilepath)
+		if err := filesystem.CopyFile(fs, srcCRDFilepath, dstCRDFilepath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteCRDFilesFromChart deletes the CRD files at crdFiles, which are relative to crdsDir, from a chart
+func DeleteCRDFilesFromChart(fs billy.Filesystem, helmChartPath, crdsDir string, crdFiles []string) error {
+	for _, crdFile := range crdFiles {
+		crdFilepath, err := filesystem.SecureJoin(filepath.Join(helmChartPath, crdsDir), crdFile)
+		if err != nil {
+			return fmt.Errorf("Invalid CRD file %s: %s", crdFile, err)
+		}
+		logrus.Infof("Deleting %s", crdFilepath)
+		if err := filesystem.RemoveAll(fs, crdFilepath); err != nil {
+			return err
+		}
+		if err := filesystem.PruneEmptyDirsInPath(fs, crdFilepath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // DeleteCRDsFromChart deletes all the CRDs loaded by a chart
 func DeleteCRDsFromChart(fs billy.Filesystem, helmChartPath string) error {
 	chart, err := helmLoader.Load(filesystem.GetAbsPath(fs, helmChartPath))
@@ -32,7 +101,10 @@ func DeleteCRDsFromChart(fs billy.Filesystem, helmChartPath string) error {
 		return fmt.Errorf("Could not load Helm chart: %s", err)
 	}
 	for _, crd := range chart.CRDObjects() {
-		crdFilepath := filepath.Join(helmChartPath, crd.File.Name)
+		crdFilepath, err := filesystem.SecureJoin(helmChartPath, crd.File.Name)
+		if err != nil {
+			return fmt.Errorf("Invalid CRD file in chart: %s", err)
+		}
 		exists, err := filesystem.PathExists(fs, crdFilepath)
 		if err != nil {
 			return err
@@ -49,3 +121,179 @@ func DeleteCRDsFromChart(fs billy.Filesystem, helmChartPath string) error {
 	}
 	return nil
 }
+
+// AddManagedByMetadataToCRDs adds the standard Helm ownership label and annotations to every CRD found in crdsDir within helmChartPath
+// If the CRDs are placed within the chart's crds/ directory, they are not templated by Helm, so only the static managed-by label is added
+func AddManagedByMetadataToCRDs(fs billy.Filesystem, helmChartPath, crdsDir string) error {
+	labels := map[string]string{
+		ManagedByLabel: "Helm",
+	}
+	annotations := map[string]string{}
+	if isTemplatedCRDDir(crdsDir) {
+		labels[ManagedByLabel] = "{{ .Release.Service }}"
+		labels[ChartLabel] = `{{ .Chart.Name }}-{{ .Chart.Version | replace "+" "_" }}`
+		annotations[ReleaseNameAnnotation] = "{{ .Release.Name }}"
+		annotations[ReleaseNamespaceAnnotation] = "{{ .Release.Namespace }}"
+	}
+	crdsDirpath := filepath.Join(helmChartPath, crdsDir)
+	logrus.Infof("Adding managed-by metadata to CRDs in %s", crdsDirpath)
+	return updateCRDs(fs, crdsDirpath, func(path string, crd yaml.MapSlice) (yaml.MapSlice, error) {
+		metadata, _ := getMapSliceValue(crd, "metadata").(yaml.MapSlice)
+		metadata = setMapSliceStringValues(metadata, "labels", labels)
+		metadata = setMapSliceStringValues(metadata, "annotations", annotations)
+		return setMapSliceValue(crd, "metadata", metadata), nil
+	})
+}
+
+// TransformCRDs renders the Go template at templatePath within the package against every CRD found in crdsDir within helmChartPath and merges the result into the CRD
+// The template is given the CRD as its data and may call any Sprig function other than env and expandenv. Maps in the rendered YAML are merged into the CRD, while any other value replaces the existing one
+func TransformCRDs(fs billy.Filesystem, helmChartPath, crdsDir, templatePath string) error {
+	templateBytes, err := ioutil.ReadFile(filesystem.GetAbsPath(fs, templatePath))
+	if err != nil {
+		return fmt.Errorf("Unable to read CRD transform template %s: %s", templatePath, err)
+	}
+	transform, err := template.New(filepath.Base(templatePath)).Funcs(SprigFuncMap()).Parse(string(templateBytes))
+	if err != nil {
+		return fmt.Errorf("Unable to parse CRD transform template %s: %s", templatePath, err)
+	}
+	crdsDirpath := filepath.Join(helmChartPath, crdsDir)
+	logrus.Infof("Transforming CRDs in %s with %s", crdsDirpath, templatePath)
+	return updateCRDs(fs, crdsDirpath, func(path string, crd yaml.MapSlice) (yaml.MapSlice, error) {
+		crdBytes, err := yaml.Marshal(crd)
+		if err != nil {
+			return nil, err
+		}
+		var data map[string]interface{}
+		if err := k8sYaml.Unmarshal(crdBytes, &data); err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := transform.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("Unable to render CRD transform template %s: %s", templatePath, err)
+		}
+		var overlay yaml.MapSlice
+		if err := yaml.Unmarshal(buf.Bytes(), &overlay); err != nil {
+			return nil, fmt.Errorf("CRD transform template %s did not render valid YAML for %s: %s", templatePath, path, err)
+		}
+		return mergeMapSlices(crd, overlay), nil
+	})
+}
+
+// updateCRDs calls update on every CRD found in the YAML files within crdsDirpath and writes back any file that contains a CRD
+// Files that contain Go template actions are skipped, since writing them back as YAML would drop or break their templating
+func updateCRDs(fs billy.Filesystem, crdsDirpath string, update func(path string, crd yaml.MapSlice) (yaml.MapSlice, error)) error {
+	return filesystem.WalkDir(fs, crdsDirpath, func(fs billy.Filesystem, path string, isDir bool) error {
+		if isDir {
+			return nil
+		}
+		absPath := filesystem.GetAbsPath(fs, path)
+		yamlFile, err := ioutil.ReadFile(absPath)
+		if err != nil {
+			return fmt.Errorf("Unable to read file %s: %s", absPath, err)
+		}
+		if TemplateActionRegex.Match(yamlFile) {
+			logrus.Warnf("Skipping %s since it is templated", path)
+			return nil
+		}
+		var resources []yaml.MapSlice
+		modified := false
+		yamlDecoder := yaml.NewDecoder(bytes.NewReader(yamlFile))
+		for {
+			var resource yaml.MapSlice
+			err := yamlDecoder.Decode(&resource)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("Unable to parse YAML in %s: %s", path, err)
+			}
+			if len(resource) == 0 {
+				continue
+			}
+			if kind, _ := getMapSliceValue(resource, "kind").(string); kind == "CustomResourceDefinition" {
+				resource, err = update(path, resource)
+				if err != nil {
+					return err
+				}
+				modified = true
+			}
+			resources = append(resources, resource)
+		}
+		if !modified {
+			return nil
+		}
+		var buf bytes.Buffer
+		yamlEncoder := yaml.NewEncoder(&buf)
+		for _, resource := range resources {
+			if err := yamlEncoder.Encode(resource); err != nil {
+				return fmt.Errorf("Unable to encode YAML for %s: %s", path, err)
+			}
+		}
+		if err := yamlEncoder.Close(); err != nil {
+			return fmt.Errorf("Unable to encode YAML for %s: %s", path, err)
+		}
+		return ioutil.WriteFile(absPath, buf.Bytes(), 0644)
+	})
+}
+
+// isTemplatedCRDDir returns whether CRDs placed in crdsDir will be rendered as templates by Helm
+func isTemplatedCRDDir(crdsDir string) bool {
+	crdsDir = filepath.Clean(crdsDir)
+	return crdsDir != path.ChartCRDDir && !strings.HasPrefix(crdsDir, path.ChartCRDDir+"/")
+}
+
+// getMapSliceValue returns the value tied to key in the MapSlice or nil if it does not exist
+func getMapSliceValue(m yaml.MapSlice, key string) interface{} {
+	for _, item := range m {
+		if k, ok := item.Key.(string); ok && k == key {
+			return item.Value
+		}
+	}
+	return nil
+}
+
+// setMapSliceValue sets the value tied to key in the MapSlice, appending it if it does not exist
+func setMapSliceValue(m yaml.MapSlice, key string, value interface{}) yaml.MapSlice {
+	for i, item := range m {
+		if k, ok := item.Key.(string); ok && k == key {
+			m[i].Value = value
+			return m
+		}
+	}
+	return append(m, yaml.MapItem{Key: key, Value: value})
+}
+
+// mergeMapSlices merges src into dst, recursing into values that are MapSlices in both and otherwise replacing the value in dst
+func mergeMapSlices(dst, src yaml.MapSlice) yaml.MapSlice {
+	for _, item := range src {
+		key, ok := item.Key.(string)
+		if !ok {
+			continue
+		}
+		dstValue, dstIsMap := getMapSliceValue(dst, key).(yaml.MapSlice)
+		srcValue, srcIsMap := item.Value.(yaml.MapSlice)
+		if dstIsMap && srcIsMap {
+			dst = setMapSliceValue(dst, key, mergeMapSlices(dstValue, srcValue))
+			continue
+		}
+		dst = setMapSliceValue(dst, key, item.Value)
+	}
+	return dst
+}
+
+// setMapSliceStringValues sets each of the values on the MapSlice tied to key within m, creating it if it does not exist
+func setMapSliceStringValues(m yaml.MapSlice, key string, values map[string]string) yaml.MapSlice {
+	if len(values) == 0 {
+		return m
+	}
+	inner, _ := getMapSliceValue(m, key).(yaml.MapSlice)
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		inner = setMapSliceValue(inner, k, values[k])
+	}
+	return setMapSliceValue(m, key, inner)
+}
@@ -0,0 +1,218 @@
+package helm
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/path"
+	helmChart "helm.sh/helm/v3/pkg/chart"
+	helmLoader "helm.sh/helm/v3/pkg/chart/loader"
+)
+
+var (
+	// valuesKeyRegex matches a line of a values.yaml that declares a key of a block mapping, capturing its indentation, key, and value
+	valuesKeyRegex = regexp.MustCompile(`^( *)("[^"]*"|'[^']*'|[^\s#'"\-][^:#]*?|-[^\s:#][^:#]*?)\s*:(?:\s+(.*))?$`)
+	// blockScalarRegex matches the value of a key whose contents are a literal or folded block scalar on the lines that follow it
+	blockScalarRegex = regexp.MustCompile(`^[|>][-+0-9]*\s*(#.*)?$`)
+)
+
+// ValueDefault represents the default value of a key within the values of a chart
+type ValueDefault struct {
+	// Path is the dot-separated path to the key within the values of the chart
+	Path string `json:"path"`
+	// Type is the JSON Schema type of the default value, i.e. object, array, string, boolean, number, or null
+	Type string `json:"type"`
+	// Default is the default value. It is omitted for objects that have keys, since each of their keys is listed separately
+	Default interface{} `json:"default,omitempty"`
+	// Description is the comment directly above the key in the values.yaml that declares it, if any
+	Description string `json:"description,omitempty"`
+	// Source is where the default value is declared
+	Source ValueSource `json:"source"`
+}
+
+// ValueSource represents where the default value of a key is declared
+type ValueSource struct {
+	// Chart is the name of the chart or subchart whose values.yaml declares the key
+	Chart string `json:"chart"`
+	// File is the path to the values.yaml within that chart
+	File string `json:"file"`
+	// Line is the line of the values.yaml that declares the key, or 0 if it could not be determined
+	Line int `json:"line,omitempty"`
+}
+
+// valuesKeyInfo represents where a key is declared within a values.yaml
+type valuesKeyInfo struct {
+	line        int
+	description string
+}
+
+// GetValuesDefaults returns the default value of every key within the values of the chart at helmChartPath, including the values of its subcharts
+// Keys of the chart are listed first in alphabetical order, followed by the keys of each subchart under the name it is imported as
+// Arrays and objects without keys are not descended into
+func GetValuesDefaults(fs billy.Filesystem, helmChartPath string) ([]ValueDefault, error) {
+	chart, err := helmLoader.Load(filesystem.GetAbsPath(fs, helmChartPath))
+	if err != nil {
+		return nil, fmt.Errorf("Could not load Helm chart %s: %s", helmChartPath, err)
+	}
+	var defaults []ValueDefault
+	seen := make(map[string]bool)
+	addChartValuesDefaults(chart, "", seen, &defaults)
+	aliases := make(map[string]string)
+	for _, dependency := range chart.Metadata.Dependencies {
+		if len(dependency.Alias) > 0 {
+			aliases[dependency.Name] = dependency.Alias
+		}
+	}
+	subcharts := chart.Dependencies()
+	sort.SliceStable(subcharts, func(i, j int) bool {
+		return subcharts[i].Name() < subcharts[j].Name()
+	})
+	for _, subchart := range subcharts {
+		name := subchart.Name()
+		if alias, ok := aliases[name]; ok {
+			name = alias
+		}
+		addChartValuesDefaults(subchart, name, seen, &defaults)
+	}
+	return defaults, nil
+}
+
+// addChartValuesDefaults appends the default value of every key within the values of the chart under prefix, skipping keys that have already been seen
+// Global values of a subchart are not placed under prefix, since Helm merges them with the global values of the parent chart
+func addChartValuesDefaults(chart *helmChart.Chart, prefix string, seen map[string]bool, defaults *[]ValueDefault) {
+	var keyInfos map[string]valuesKeyInfo
+	for _, f := range chart.Raw {
+		if f.Name == path.ChartValuesFile {
+			keyInfos = getValuesKeyInfos(string(f.Data))
+			break
+		}
+	}
+	var walk func(values map[string]interface{}, keys []string)
+	walk = func(values map[string]interface{}, keys []string) {
+		for _, key := range sortedKeys(values) {
+			valueKeys := append(append([]string{}, keys...), key)
+			valuePath := strings.Join(valueKeys, ".")
+			if len(prefix) > 0 && valueKeys[0] != "global" {
+				valuePath = prefix + "." + valuePath
+			}
+			value := values[key]
+			nested, isObject := value.(map[string]interface{})
+			if !seen[valuePath] {
+				seen[valuePath] = true
+				keyInfo := keyInfos[strings.Join(valueKeys, ".")]
+				valueDefault := ValueDefault{
+					Path:        valuePath,
+					Type:        getValueType(value),
+					Description: keyInfo.description,
+					Source: ValueSource{
+						Chart: chart.Name(),
+						File:  path.ChartValuesFile,
+						Line:  keyInfo.line,
+					},
+				}
+				if !isObject || len(nested) == 0 {
+					valueDefault.Default = value
+				}
+				*defaults = append(*defaults, valueDefault)
+			}
+			if isObject {
+				walk(nested, valueKeys)
+			}
+		}
+	}
+	walk(chart.Values, nil)
+}
+
+// getValueType returns the JSON Schema type of a value parsed from a values.yaml
+func getValueType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case int, int64, float64:
+		// Numbers are not constrained to integers since Helm parses numbers provided on the command line as either
+		return "number"
+	default:
+		return ""
+	}
+}
+
+// getValuesKeyInfos returns the line and description of each key declared in block style within the contents of a values.yaml, keyed by its dot-separated path
+// The description of a key is the block of comments directly above it, with any leading '-- ' used by helm-docs removed
+// Keys within arrays and block scalars are ignored
+func getValuesKeyInfos(valuesYaml string) map[string]valuesKeyInfo {
+	type parent struct {
+		indent int
+		key    string
+	}
+	keyInfos := make(map[string]valuesKeyInfo)
+	var parents []parent
+	var comments []string
+	skipIndent := -1
+	for i, line := range strings.Split(valuesYaml, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if len(trimmed) == 0 || trimmed == "---" {
+			comments = nil
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		if skipIndent >= 0 {
+			if indent > skipIndent || (indent == skipIndent && (trimmed == "-" || strings.HasPrefix(trimmed, "- "))) {
+				continue
+			}
+			skipIndent = -1
+		}
+		if strings.HasPrefix(trimmed, "#") {
+			comment := strings.TrimSpace(strings.TrimPrefix(trimmed, "#"))
+			comments = append(comments, strings.TrimPrefix(comment, "-- "))
+			continue
+		}
+		if trimmed == "-" || strings.HasPrefix(trimmed, "- ") {
+			// The contents of an array are not descended into, and its items may be at the same indentation as its key
+			for len(parents) > 0 && parents[len(parents)-1].indent > indent {
+				parents = parents[:len(parents)-1]
+			}
+			skipIndent = indent
+			if len(parents) > 0 {
+				skipIndent = parents[len(parents)-1].indent
+			}
+			comments = nil
+			continue
+		}
+		for len(parents) > 0 && parents[len(parents)-1].indent >= indent {
+			parents = parents[:len(parents)-1]
+		}
+		match := valuesKeyRegex.FindStringSubmatch(line)
+		if match == nil {
+			comments = nil
+			continue
+		}
+		key := strings.Trim(match[2], `"'`)
+		keys := make([]string, 0, len(parents)+1)
+		for _, p := range parents {
+			keys = append(keys, p.key)
+		}
+		keys = append(keys, key)
+		keyInfos[strings.Join(keys, ".")] = valuesKeyInfo{
+			line:        i + 1,
+			description: strings.TrimSpace(strings.Join(comments, "\n")),
+		}
+		comments = nil
+		parents = append(parents, parent{indent: indent, key: key})
+		if blockScalarRegex.MatchString(match[3]) {
+			skipIndent = indent
+		}
+	}
+	return keyInfos
+}
@@ -0,0 +1,151 @@
+package helm
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/path"
+	helmChartutil "helm.sh/helm/v3/pkg/chartutil"
+)
+
+// ValuesDrift represents how the default values that we ship differ from the default values of the upstream chart
+type ValuesDrift struct {
+	// Changed are the keys that both we and the upstream set to different values
+	Changed []ValuesDriftKey
+	// Added are the keys that we set that the upstream does not set
+	Added []ValuesDriftKey
+	// Removed are the keys that the upstream sets that we do not set
+	Removed []ValuesDriftKey
+	// Unreviewed are the keys that the upstream added since the previous upstream that we ship unchanged
+	Unreviewed []ValuesDriftKey
+	// Stale are the keys that the upstream removed since the previous upstream that we still set
+	Stale []ValuesDriftKey
+}
+
+// ValuesDriftKey represents a key within the default values whose value differs between the values we ship and the upstream values
+type ValuesDriftKey struct {
+	// Path is the dot-separated path to the key
+	Path string
+	// Ours is the value that we ship, if any
+	Ours interface{}
+	// Upstream is the value set by the upstream, if any
+	Upstream interface{}
+}
+
+func (k ValuesDriftKey) String() string {
+	switch {
+	case k.Ours == nil:
+		return fmt.Sprintf("%s (upstream: %s)", k.Path, formatValue(k.Upstream))
+	case k.Upstream == nil:
+		return fmt.Sprintf("%s (ours: %s)", k.Path, formatValue(k.Ours))
+	default:
+		return fmt.Sprintf("%s (upstream: %s, ours: %s)", k.Path, formatValue(k.Upstream), formatValue(k.Ours))
+	}
+}
+
+// IsEmpty returns whether the values that we ship do not drift from the upstream values at all
+func (d ValuesDrift) IsEmpty() bool {
+	return len(d.Changed)+len(d.Added)+len(d.Removed)+len(d.Unreviewed)+len(d.Stale) == 0
+}
+
+// ReadValues returns the default values of the chart at helmChartPath, or empty values if it has no values.yaml
+func ReadValues(fs billy.Filesystem, helmChartPath string) (map[string]interface{}, error) {
+	valuesPath := filepath.Join(helmChartPath, path.ChartValuesFile)
+	exists, err := filesystem.PathExists(fs, valuesPath)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return map[string]interface{}{}, nil
+	}
+	values, err := helmChartutil.ReadValuesFile(filesystem.GetAbsPath(fs, valuesPath))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read values from %s: %s", valuesPath, err)
+	}
+	return values, nil
+}
+
+// GetValuesDrift compares the values that we ship against the upstream values
+// If previousUpstream is not nil, keys that the upstream added or removed since previousUpstream are also reported
+func GetValuesDrift(ours, upstream, previousUpstream map[string]interface{}) ValuesDrift {
+	var drift ValuesDrift
+	ourKeys, upstreamKeys := flattenValues(ours), flattenValues(upstream)
+	var previousKeys map[string]interface{}
+	if previousUpstream != nil {
+		previousKeys = flattenValues(previousUpstream)
+	}
+	for _, key := range sortedKeys(ourKeys) {
+		upstreamValue, inUpstream := upstreamKeys[key]
+		switch {
+		case inUpstream && !reflect.DeepEqual(ourKeys[key], upstreamValue):
+			drift.Changed = append(drift.Changed, ValuesDriftKey{Path: key, Ours: ourKeys[key], Upstream: upstreamValue})
+		case inUpstream:
+			if _, inPrevious := previousKeys[key]; previousKeys != nil && !inPrevious {
+				drift.Unreviewed = append(drift.Unreviewed, ValuesDriftKey{Path: key, Upstream: upstreamValue})
+			}
+		default:
+			if _, inPrevious := previousKeys[key]; inPrevious {
+				drift.Stale = append(drift.Stale, ValuesDriftKey{Path: key, Ours: ourKeys[key]})
+			} else {
+				drift.Added = append(drift.Added, ValuesDriftKey{Path: key, Ours: ourKeys[key]})
+			}
+		}
+	}
+	for _, key := range sortedKeys(upstreamKeys) {
+		if _, inOurs := ourKeys[key]; !inOurs {
+			drift.Removed = append(drift.Removed, ValuesDriftKey{Path: key, Upstream: upstreamKeys[key]})
+		}
+	}
+	return drift
+}
+
+// flattenValues returns the leaves of the values keyed by their dot-separated path
+// Lists and empty maps are treated as leaves, since their elements cannot be addressed by a path
+func flattenValues(values map[string]interface{}) map[string]interface{} {
+	leaves := make(map[string]interface{})
+	var flatten func(prefix string, values map[string]interface{})
+	flatten = func(prefix string, values map[string]interface{}) {
+		for key, value := range values {
+			keyPath := key
+			if len(prefix) > 0 {
+				keyPath = prefix + "." + key
+			}
+			if nested, ok := value.(map[string]interface{}); ok && len(nested) > 0 {
+				flatten(keyPath, nested)
+				continue
+			}
+			if value == nil {
+				// Distinguish keys explicitly set to null from keys that are not set
+				value = json.RawMessage("null")
+			}
+			leaves[keyPath] = value
+		}
+	}
+	flatten("", values)
+	return leaves
+}
+
+// sortedKeys returns the keys of m in lexical order
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatValue returns the value as compact JSON so that strings can be told apart from other scalars
+func formatValue(value interface{}) string {
+	valueBytes, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return strings.TrimSpace(string(valueBytes))
+}
@@ -0,0 +1,27 @@
+package helm
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	helmChartutil "helm.sh/helm/v3/pkg/chartutil"
+)
+
+const (
+	// EditionAnnotation is the annotation added to charts exported for an edition of the main chart of a package to indicate the name of the edition
+	EditionAnnotation = "catalog.cattle.io/edition"
+)
+
+// SetChartName sets the name in the Chart.yaml of the chart at helmChartPath
+func SetChartName(fs billy.Filesystem, helmChartPath, name string) error {
+	chartYamlPath := filepath.Join(helmChartPath, helmChartutil.ChartfileName)
+	absChartYamlPath := filesystem.GetAbsPath(fs, chartYamlPath)
+	metadata, err := helmChartutil.LoadChartfile(absChartYamlPath)
+	if err != nil {
+		return fmt.Errorf("Unable to load %s: %s", chartYamlPath, err)
+	}
+	metadata.Name = name
+	return helmChartutil.SaveChartfile(absChartYamlPath, metadata)
+}
@@ -2,21 +2,73 @@ package helm
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 
 	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/events"
 	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	"github.com/rancher/charts-build-scripts/pkg/transient"
 	"github.com/sirupsen/logrus"
 	helmAction "helm.sh/helm/v3/pkg/action"
+	helmChart "helm.sh/helm/v3/pkg/chart"
 	helmLoader "helm.sh/helm/v3/pkg/chart/loader"
+	k8sYaml "sigs.k8s.io/yaml"
 )
 
-// ExportHelmChart creates a Helm chart archive and an unarchived Helm chart at RepositoryAssetDirpath and RepositoryChartDirPath
+const (
+	// exportStagingDirPrefix is the prefix of the directory within the repository where each export is staged
+	exportStagingDirPrefix = ".export-"
+	// exportStagingChartDir is the directory within the staging directory where the exported chart is unarchived
+	exportStagingChartDir = "chart"
+)
+
+// exportSettings represents the settings that change the charts generated by ExportHelmChart
+type exportSettings struct {
+	EmitRequirementsYaml        bool                         `json:"emitRequirementsYaml"`
+	ImagePlatforms              bool                         `json:"imagePlatforms"`
+	RequireVendoredDependencies bool                         `json:"requireVendoredDependencies"`
+	AllowAPIVersionV1           bool                         `json:"allowAPIVersionV1"`
+	GenerateValuesSchema        bool                         `json:"generateValuesSchema"`
+	ChartSigningKey             string                       `json:"chartSigningKey"`
+	ChartSigningKeyring         string                       `json:"chartSigningKeyring"`
+	ContentPolicy               options.ContentPolicyOptions `json:"contentPolicy"`
+	SourceDateEpoch             string                       `json:"sourceDateEpoch"`
+	Layout                      options.LayoutOptions        `json:"layout"`
+}
+
+// GetExportSettings returns a description of the settings that change the charts generated by ExportHelmChart, which differs whenever any of them differ
+func GetExportSettings() (string, error) {
+	settingsBytes, err := k8sYaml.Marshal(exportSettings{
+		EmitRequirementsYaml:        EmitRequirementsYaml,
+		ImagePlatforms:              ImagePlatforms,
+		RequireVendoredDependencies: RequireVendoredDependencies,
+		AllowAPIVersionV1:           AllowAPIVersionV1,
+		GenerateValuesSchema:        GenerateValuesSchema,
+		ChartSigningKey:             ChartSigningKey,
+		ChartSigningKeyring:         ChartSigningKeyring,
+		ContentPolicy:               ContentPolicy,
+		SourceDateEpoch:             os.Getenv(sourceDateEpochEnvironmentVariable),
+		Layout:                      Layout,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(settingsBytes), nil
+}
+
+// ExportHelmChart creates a Helm chart archive and an unarchived Helm chart within packageAssetsDirpath and packageChartsDirpath at the paths given by the Layout
 // helmChartPath is a relative path (rooted at the package level) that contains the chart.
 // packageAssetsPath is a relative path (rooted at the repository level) where the generated chart archive will be placed
 // packageChartsPath is a relative path (rooted at the repository level) where the generated chart will be placed
-func ExportHelmChart(rootFs, fs billy.Filesystem, helmChartPath string, chartVersion string, packageAssetsDirpath, packageChartsDirpath string) error {
+// supportTier, if provided, is added to the generated chart as an annotation and a banner in its README.md
+// annotations, if provided, are added to the Chart.yaml of the generated chart
+// imageMirror, if provided, rewrites the images referenced by the generated chart to point at a mirror registry
+// The generated chart archive is reproducible: its files are recorded in a stable order with fixed modes and timestamps, pinned by SOURCE_DATE_EPOCH if set
+// If ChartSigningKey is set, the generated chart archive is signed and its provenance file is placed alongside it
+func ExportHelmChart(rootFs, fs billy.Filesystem, helmChartPath string, chartVersion, supportTier string, annotations map[string]string, imageMirror *options.ImageMirrorOptions, packageAssetsDirpath, packageChartsDirpath string) error {
 	// Try to load the chart to see if it can be exported
 	absHelmChartPath := filesystem.GetAbsPath(fs, helmChartPath)
 	chart, err := helmLoader.Load(absHelmChartPath)
@@ -26,39 +78,131 @@ func ExportHelmChart(rootFs, fs billy.Filesystem, helmChartPath string, chartVer
 	if err := chart.Validate(); err != nil {
 		return fmt.Errorf("Failed while trying to validate Helm chart: %s", err)
 	}
+	if chart.Metadata.APIVersion == helmChart.APIVersionV1 && !AllowAPIVersionV1 {
+		return fmt.Errorf("Helm chart %s uses apiVersion %s and must be migrated to apiVersion %s before it can be exported", helmChartPath, helmChart.APIVersionV1, helmChart.APIVersionV2)
+	}
+	violations, err := CheckContentPolicy(fs, helmChartPath, ContentPolicy)
+	if err != nil {
+		return fmt.Errorf("Encountered error while checking the content policy: %s", err)
+	}
+	for _, violation := range violations {
+		logrus.Errorf("%s/%s: %s", helmChartPath, violation.Path, violation.Message)
+		events.Emit(events.Event{Type: events.ValidationFinding, Message: fmt.Sprintf("%s/%s: %s", helmChartPath, violation.Path, violation.Message)})
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("Found %d content policy violations in %s", len(violations), helmChartPath)
+	}
+	if RequireVendoredDependencies {
+		unvendored := GetUnvendoredDependencies(chart)
+		for _, message := range unvendored {
+			logrus.Errorf("%s/%s", helmChartPath, message)
+			events.Emit(events.Event{Type: events.ValidationFinding, Message: fmt.Sprintf("%s/%s", helmChartPath, message)})
+		}
+		if len(unvendored) > 0 {
+			return fmt.Errorf("Found %d dependencies that are not vendored in %s", len(unvendored), helmChartPath)
+		}
+	}
 	chartVersion = chart.Metadata.Version + chartVersion
 
-	// All assets of each chart in a package are placed in a flat directory containing all versions
-	chartAssetsDirpath := packageAssetsDirpath
-	// All generated charts are indexed by version and the working directory
-	chartChartsDirpath := filepath.Join(packageChartsDirpath, chart.Metadata.Name, chartVersion)
-	// Create directories
-	if err := rootFs.MkdirAll(chartAssetsDirpath, os.ModePerm); err != nil {
-		return fmt.Errorf("Failed to create directory for assets at %s: %s", chartAssetsDirpath, err)
-	}
-	defer filesystem.PruneEmptyDirsInPath(rootFs, chartAssetsDirpath)
-	if err := rootFs.MkdirAll(chartChartsDirpath, os.ModePerm); err != nil {
-		return fmt.Errorf("Failed to create directory for charts at %s: %s", chartChartsDirpath, err)
-	}
-	defer filesystem.PruneEmptyDirsInPath(rootFs, chartChartsDirpath)
+	// Assets and generated charts are placed within the directories of the package as configured by the Layout
+	tgzPath := GetAssetPath(packageAssetsDirpath, chart.Metadata.Name, chartVersion)
+	chartChartsDirpath := GetChartPath(packageChartsDirpath, chart.Metadata.Name, chartVersion)
+	// Stage the export in a directory of its own so that concurrent exports never observe each other's intermediate state
+	// It is placed within the repository so that the results can be renamed into place
+	absStagingDir, err := ioutil.TempDir(filesystem.GetAbsPath(rootFs, ""), exportStagingDirPrefix)
+	if err != nil {
+		return fmt.Errorf("Failed to create staging directory for export: %s", err)
+	}
+	if err := transient.Track(rootFs, filepath.Base(absStagingDir)); err != nil {
+		return err
+	}
+	defer transient.Remove(rootFs, filepath.Base(absStagingDir))
+	stagingFs := filesystem.GetFilesystem(absStagingDir)
 	// Run helm package
 	pkg := helmAction.NewPackage()
 	pkg.Version = chartVersion
-	pkg.Destination = filesystem.GetAbsPath(rootFs, chartAssetsDirpath)
+	pkg.Destination = absStagingDir
 	pkg.DependencyUpdate = false
-	absTgzPath, err := pkg.Run(absHelmChartPath, nil)
+	absStagedTgzPath, err := pkg.Run(absHelmChartPath, nil)
 	if err != nil {
 		return err
 	}
-	tgzPath, err := filesystem.GetRelativePath(rootFs, absTgzPath)
-	if err != nil {
-		return err
+	if EmitRequirementsYaml {
+		if err := addRequirementsYaml(absStagedTgzPath); err != nil {
+			return err
+		}
+	}
+	if len(annotations) > 0 {
+		if err := addAnnotations(absStagedTgzPath, annotations); err != nil {
+			return err
+		}
+	}
+	if len(supportTier) > 0 {
+		if err := addSupportTier(absStagedTgzPath, supportTier); err != nil {
+			return err
+		}
+	}
+	if ImagePlatforms {
+		if err := addPlatformAnnotations(absStagedTgzPath); err != nil {
+			return err
+		}
+	}
+	if GenerateValuesSchema {
+		overlay, err := getValuesSchemaOverlay(fs, helmChartPath)
+		if err != nil {
+			return fmt.Errorf("Encountered error while getting the schema overlay of %s: %s", helmChartPath, err)
+		}
+		if err := addValuesSchema(absStagedTgzPath, overlay); err != nil {
+			return err
+		}
+	}
+	if imageMirror != nil {
+		if err := addImageMirror(absStagedTgzPath, imageMirror); err != nil {
+			return err
+		}
+	}
+	// Archives are rewritten by each step above, so they are only made reproducible once the chart is final
+	if err := normalizeChartArchive(absStagedTgzPath); err != nil {
+		return fmt.Errorf("Encountered error while normalizing chart archive: %s", err)
+	}
+	if len(ChartSigningKey) > 0 {
+		if err := signChartArchive(absStagedTgzPath); err != nil {
+			return err
+		}
 	}
-	logrus.Infof("Generated archive: %s", tgzPath)
 	// Unarchive the generated package
-	if err := filesystem.UnarchiveTgz(rootFs, tgzPath, "", chartChartsDirpath, true); err != nil {
+	if err := filesystem.UnarchiveTgz(stagingFs, filepath.Base(absStagedTgzPath), "", exportStagingChartDir, true); err != nil {
 		return err
 	}
+	// Move the archive and the chart into place
+	if err := rootFs.MkdirAll(filepath.Dir(tgzPath), 0755); err != nil {
+		return fmt.Errorf("Failed to create directory for assets at %s: %s", filepath.Dir(tgzPath), err)
+	}
+	if err := os.Rename(absStagedTgzPath, filesystem.GetAbsPath(rootFs, tgzPath)); err != nil {
+		return fmt.Errorf("Failed to move archive into %s: %s", tgzPath, err)
+	}
+	logrus.Infof("Generated archive: %s", tgzPath)
+	events.Emit(events.Event{Type: events.AssetProduced, Asset: tgzPath})
+	provPath := tgzPath + ProvenanceFileExtension
+	if len(ChartSigningKey) > 0 {
+		if err := os.Rename(absStagedTgzPath+ProvenanceFileExtension, filesystem.GetAbsPath(rootFs, provPath)); err != nil {
+			return fmt.Errorf("Failed to move provenance file into %s: %s", provPath, err)
+		}
+		logrus.Infof("Generated provenance file: %s", provPath)
+		events.Emit(events.Event{Type: events.AssetProduced, Asset: provPath})
+	} else if err := filesystem.RemoveAll(rootFs, provPath); err != nil {
+		// A provenance file left by an earlier signed export would no longer match the archive
+		return fmt.Errorf("Failed to remove stale provenance file %s: %s", provPath, err)
+	}
+	if err := rootFs.MkdirAll(filepath.Dir(chartChartsDirpath), 0755); err != nil {
+		return fmt.Errorf("Failed to create directory for charts at %s: %s", filepath.Dir(chartChartsDirpath), err)
+	}
+	if err := filesystem.RemoveAll(rootFs, chartChartsDirpath); err != nil {
+		return fmt.Errorf("Failed to remove existing chart at %s: %s", chartChartsDirpath, err)
+	}
+	if err := os.Rename(filesystem.GetAbsPath(stagingFs, exportStagingChartDir), filesystem.GetAbsPath(rootFs, chartChartsDirpath)); err != nil {
+		return fmt.Errorf("Failed to move chart into %s: %s", chartChartsDirpath, err)
+	}
 	logrus.Infof("Generated chart: %s", chartChartsDirpath)
 	return nil
 }
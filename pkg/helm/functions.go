@@ -0,0 +1,162 @@
+package helm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/Masterminds/sprig/v3"
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	"github.com/rancher/charts-build-scripts/pkg/path"
+)
+
+var (
+	// builtinTemplateFunctions are the functions that are predefined by text/template
+	builtinTemplateFunctions = []string{"and", "call", "eq", "ge", "gt", "html", "index", "js", "le", "len", "lt", "ne", "not", "or", "print", "printf", "println", "slice", "urlquery"}
+	// helmTemplateFunctions are the functions that Helm adds on top of the Sprig functions
+	helmTemplateFunctions = []string{"fromJson", "fromJsonArray", "fromYaml", "fromYamlArray", "include", "lookup", "required", "toJson", "toToml", "toYaml", "tpl"}
+	// environmentTemplateFunctions are the Sprig functions that read the environment, which Helm removes
+	environmentTemplateFunctions = []string{"env", "expandenv"}
+)
+
+// SprigFuncMap returns the Sprig functions without those that read the environment, as Helm does
+// This keeps the environment of the build, e.g. the secrets of CI, from ending up in the charts that are rendered with them
+func SprigFuncMap() template.FuncMap {
+	funcMap := sprig.TxtFuncMap()
+	for _, function := range environmentTemplateFunctions {
+		delete(funcMap, function)
+	}
+	return funcMap
+}
+
+// TemplateFunctionViolation represents a call to a template function that is not permitted
+type TemplateFunctionViolation struct {
+	// Location is the file, line, and column of the call
+	Location string
+	// Function is the name of the template function that was called
+	Function string
+}
+
+func (v TemplateFunctionViolation) String() string {
+	return fmt.Sprintf("%s: function %s is not allowed", v.Location, v.Function)
+}
+
+// CheckTemplateFunctions parses all templates within the chart at helmChartPath, including those of any subcharts,
+// and returns every call to a template function that is not permitted by the templateFunctionOptions
+func CheckTemplateFunctions(fs billy.Filesystem, helmChartPath string, templateFunctionOptions options.TemplateFunctionOptions) ([]TemplateFunctionViolation, error) {
+	allowed := make(map[string]bool, len(templateFunctionOptions.Allowed))
+	for _, function := range templateFunctionOptions.Allowed {
+		allowed[function] = true
+	}
+	forbidden := make(map[string]bool, len(templateFunctionOptions.Forbidden))
+	for _, function := range templateFunctionOptions.Forbidden {
+		forbidden[function] = true
+	}
+	isPermitted := func(function string) bool {
+		if forbidden[function] {
+			return false
+		}
+		return len(allowed) == 0 || allowed[function]
+	}
+	knownFunctions := getKnownTemplateFunctions()
+	var violations []TemplateFunctionViolation
+	err := filesystem.WalkDir(fs, helmChartPath, func(fs billy.Filesystem, templatePath string, isDir bool) error {
+		if isDir || !strings.Contains(templatePath, "/"+path.ChartTemplatesDir+"/") {
+			return nil
+		}
+		templateBytes, err := ioutil.ReadFile(filesystem.GetAbsPath(fs, templatePath))
+		if err != nil {
+			return err
+		}
+		trees, err := parse.Parse(templatePath, string(templateBytes), "", "", knownFunctions)
+		if err != nil {
+			return fmt.Errorf("Unable to parse template %s: %s", templatePath, err)
+		}
+		names := make([]string, 0, len(trees))
+		for name := range trees {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			tree := trees[name]
+			walkTemplateFunctions(tree.Root, func(node *parse.IdentifierNode) {
+				if isPermitted(node.Ident) {
+					return
+				}
+				location, _ := tree.ErrorContext(node)
+				violations = append(violations, TemplateFunctionViolation{
+					Location: location,
+					Function: node.Ident,
+				})
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return violations, nil
+}
+
+// getKnownTemplateFunctions returns all functions that can be called within a Helm template
+// Helm removes env and expandenv from the Sprig functions, but they are kept here so that any usage is reported as a violation
+func getKnownTemplateFunctions() map[string]interface{} {
+	knownFunctions := make(map[string]interface{})
+	for function := range sprig.TxtFuncMap() {
+		knownFunctions[function] = true
+	}
+	for _, function := range append(builtinTemplateFunctions, helmTemplateFunctions...) {
+		knownFunctions[function] = true
+	}
+	return knownFunctions
+}
+
+// walkTemplateFunctions calls doFunc on every function identifier found within the node
+func walkTemplateFunctions(node parse.Node, doFunc func(*parse.IdentifierNode)) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, inner := range n.Nodes {
+			walkTemplateFunctions(inner, doFunc)
+		}
+	case *parse.ActionNode:
+		walkTemplateFunctions(n.Pipe, doFunc)
+	case *parse.IfNode:
+		walkBranchTemplateFunctions(&n.BranchNode, doFunc)
+	case *parse.RangeNode:
+		walkBranchTemplateFunctions(&n.BranchNode, doFunc)
+	case *parse.WithNode:
+		walkBranchTemplateFunctions(&n.BranchNode, doFunc)
+	case *parse.TemplateNode:
+		walkTemplateFunctions(n.Pipe, doFunc)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			walkTemplateFunctions(cmd, doFunc)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			walkTemplateFunctions(arg, doFunc)
+		}
+	case *parse.ChainNode:
+		walkTemplateFunctions(n.Node, doFunc)
+	case *parse.IdentifierNode:
+		doFunc(n)
+	}
+}
+
+// walkBranchTemplateFunctions calls doFunc on every function identifier found within the branches of an if, range, or with
+func walkBranchTemplateFunctions(n *parse.BranchNode, doFunc func(*parse.IdentifierNode)) {
+	walkTemplateFunctions(n.Pipe, doFunc)
+	walkTemplateFunctions(n.List, doFunc)
+	walkTemplateFunctions(n.ElseList, doFunc)
+}
@@ -1,18 +1,47 @@
 package helm
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-git/go-billy/v5"
 	"github.com/rancher/charts-build-scripts/pkg/filesystem"
 	"github.com/rancher/charts-build-scripts/pkg/path"
+	"github.com/sirupsen/logrus"
 	helmRepo "helm.sh/helm/v3/pkg/repo"
+	k8sYaml "sigs.k8s.io/yaml"
+)
+
+const (
+	// versionedIndexTimeFormat is the format of the timestamp within the name of a versioned Helm index
+	versionedIndexTimeFormat = "20060102150405"
+	// indexFileMode is the mode of files written atomically
+	indexFileMode = 0644
+)
+
+var (
+	// AtomicIndexWrite indicates that the Helm index should be written to a temporary file and renamed into place
+	// so that consumers of the repository never observe a partially written index
+	AtomicIndexWrite bool
+	// VersionedIndex indicates that a copy of the Helm index should also be written to index-<timestamp>.yaml
+	// along with a pointer file that contains the name of the latest versioned index
+	VersionedIndex bool
+
+	// indexLock serializes updates to the Helm index so that packages exported concurrently do not overwrite each other's entries
+	indexLock sync.Mutex
 )
 
 // CreateOrUpdateHelmIndex either creates or updates the index.yaml for the repository this package is within
 func CreateOrUpdateHelmIndex(rootFs billy.Filesystem) error {
-	absRepositoryAssetsDir := filesystem.GetAbsPath(rootFs, path.RepositoryAssetsDir)
+	indexLock.Lock()
+	defer indexLock.Unlock()
+
 	absRepositoryHelmIndexFile := filesystem.GetAbsPath(rootFs, path.RepositoryHelmIndexFile)
 
 	var helmIndexFile *helmRepo.IndexFile
@@ -31,19 +60,177 @@ func CreateOrUpdateHelmIndex(rootFs billy.Filesystem) error {
 	}
 
 	// Generate the current index file from the assets/ directory
-	newHelmIndexFile, err := helmRepo.IndexDirectory(absRepositoryAssetsDir, path.RepositoryAssetsDir)
+	newHelmIndexFile, err := IndexRepositoryAssets(rootFs)
 	if err != nil {
 		return fmt.Errorf("Encountered error while trying to generate new Helm index: %s", err)
 	}
 
 	// Merge the indices and sort them
-	helmIndexFile.Merge(newHelmIndexFile)
+	indexTime, err := getIndexTime()
+	if err != nil {
+		return err
+	}
+	mergeHelmIndex(helmIndexFile, newHelmIndexFile, indexTime)
 	helmIndexFile.SortEntries()
 
+	// Ensure that renamed charts still resolve under their previous names
+	if err := applyChartAliases(helmIndexFile, ChartAliases); err != nil {
+		return fmt.Errorf("Encountered error while trying to apply chart aliases to Helm index: %s", err)
+	}
+
 	// Write new index to disk
-	err = helmIndexFile.WriteFile(absRepositoryHelmIndexFile, os.ModePerm)
+	return writeHelmIndex(rootFs, helmIndexFile)
+}
+
+// mergeHelmIndex merges the entries generated from the assets into the Helm index
+// Entries whose assets are unchanged are kept as they are so that rebuilding an unchanged package leaves the index untouched,
+// while entries whose assets changed are replaced. Entries that are added or replaced, and the index itself if any entry changed, are stamped with indexTime
+func mergeHelmIndex(helmIndexFile, newHelmIndexFile *helmRepo.IndexFile, indexTime time.Time) {
+	changed := false
+	for chartName, chartVersions := range newHelmIndexFile.Entries {
+		for _, chartVersion := range chartVersions {
+			chartVersion.Created = indexTime
+			existingChartVersions := helmIndexFile.Entries[chartName]
+			found := false
+			for i, existing := range existingChartVersions {
+				if existing.Version != chartVersion.Version {
+					continue
+				}
+				found = true
+				if existing.Digest != chartVersion.Digest {
+					existingChartVersions[i] = chartVersion
+					changed = true
+				}
+				break
+			}
+			if !found {
+				helmIndexFile.Entries[chartName] = append(existingChartVersions, chartVersion)
+				changed = true
+			}
+		}
+	}
+	if changed {
+		helmIndexFile.Generated = indexTime
+	}
+}
+
+// DeprecateHelmIndexEntries marks every chart version in the Helm index whose asset is found within packageAssetsDirpath as deprecated
+// It returns the versions that were marked, keyed by the name of the chart
+func DeprecateHelmIndexEntries(rootFs billy.Filesystem, packageAssetsDirpath string) (map[string][]string, error) {
+	indexLock.Lock()
+	defer indexLock.Unlock()
+
+	exists, err := filesystem.PathExists(rootFs, path.RepositoryHelmIndexFile)
 	if err != nil {
-		return fmt.Errorf("Encountered error while trying to write updated Helm index into index.yaml: %s", err)
+		return nil, fmt.Errorf("Encountered error while checking if Helm index file already exists in repository: %s", err)
+	}
+	if !exists {
+		return nil, nil
+	}
+	helmIndexFile, err := helmRepo.LoadIndexFile(filesystem.GetAbsPath(rootFs, path.RepositoryHelmIndexFile))
+	if err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to load existing index file: %s", err)
+	}
+	deprecated := make(map[string][]string)
+	assetPrefix := filepath.ToSlash(packageAssetsDirpath) + "/"
+	for chartName, chartVersions := range helmIndexFile.Entries {
+		for _, chartVersion := range chartVersions {
+			if len(chartVersion.URLs) == 0 || !strings.HasPrefix(chartVersion.URLs[0], assetPrefix) || chartVersion.Deprecated {
+				continue
+			}
+			chartVersion.Deprecated = true
+			deprecated[chartName] = append(deprecated[chartName], chartVersion.Version)
+		}
+	}
+	if len(deprecated) == 0 {
+		return nil, nil
+	}
+	return deprecated, writeHelmIndex(rootFs, helmIndexFile)
+}
+
+// writeHelmIndex writes the Helm index into the repository, along with a versioned copy and a pointer to it if VersionedIndex is set
+// The versioned copy is written first so that neither the index nor the pointer ever refer to a version that does not exist yet
+// A versioned copy is only written if the index differs from the latest versioned copy
+func writeHelmIndex(rootFs billy.Filesystem, helmIndexFile *helmRepo.IndexFile) error {
+	var versionedIndexFile string
+	if VersionedIndex {
+		changed, err := isChangedSinceLatestVersionedIndex(rootFs, helmIndexFile)
+		if err != nil {
+			return fmt.Errorf("Encountered error while comparing the Helm index against the latest versioned Helm index: %s", err)
+		}
+		if !changed {
+			logrus.Infof("Skipping writing a versioned Helm index since the Helm index has not changed since %s", path.RepositoryLatestHelmIndexPointerFile)
+		} else {
+			versionedIndexFile = fmt.Sprintf(path.RepositoryVersionedHelmIndexFileFormat, time.Now().UTC().Format(versionedIndexTimeFormat))
+			if err := writeFile(filesystem.GetAbsPath(rootFs, versionedIndexFile), helmIndexFile.WriteFile); err != nil {
+				return fmt.Errorf("Encountered error while trying to write versioned Helm index into %s: %s", versionedIndexFile, err)
+			}
+		}
+	}
+	if err := writeFile(filesystem.GetAbsPath(rootFs, path.RepositoryHelmIndexFile), helmIndexFile.WriteFile); err != nil {
+		return fmt.Errorf("Encountered error while trying to write updated Helm index into %s: %s", path.RepositoryHelmIndexFile, err)
+	}
+	if err := writeRepositoryRedirects(rootFs, helmIndexFile); err != nil {
+		return err
+	}
+	if len(versionedIndexFile) == 0 {
+		return nil
 	}
+	writePointer := func(absPath string, mode os.FileMode) error {
+		return ioutil.WriteFile(absPath, []byte(versionedIndexFile+"\n"), mode)
+	}
+	if err := writeFile(filesystem.GetAbsPath(rootFs, path.RepositoryLatestHelmIndexPointerFile), writePointer); err != nil {
+		return fmt.Errorf("Encountered error while trying to write pointer to the latest Helm index into %s: %s", path.RepositoryLatestHelmIndexPointerFile, err)
+	}
+	logrus.Infof("Wrote versioned Helm index %s", versionedIndexFile)
 	return nil
 }
+
+// isChangedSinceLatestVersionedIndex returns whether the Helm index differs from the versioned copy that the pointer to the latest versioned index refers to
+func isChangedSinceLatestVersionedIndex(rootFs billy.Filesystem, helmIndexFile *helmRepo.IndexFile) (bool, error) {
+	pointerBytes, err := ioutil.ReadFile(filesystem.GetAbsPath(rootFs, path.RepositoryLatestHelmIndexPointerFile))
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	latestBytes, err := ioutil.ReadFile(filesystem.GetAbsPath(rootFs, strings.TrimSpace(string(pointerBytes))))
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	// This is how Helm marshals the index when writing it
+	indexBytes, err := k8sYaml.Marshal(helmIndexFile)
+	if err != nil {
+		return false, err
+	}
+	return !bytes.Equal(indexBytes, latestBytes), nil
+}
+
+// writeFile writes a file to absPath using write
+// If AtomicIndexWrite is set, the file is written to a temporary file in the same directory and then renamed to absPath
+func writeFile(absPath string, write func(absPath string, mode os.FileMode) error) error {
+	if !AtomicIndexWrite {
+		return write(absPath, 0644)
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(absPath), "."+filepath.Base(absPath)+".tmp-")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+	if err := write(tmpPath, indexFileMode); err != nil {
+		return err
+	}
+	// Temporary files are only readable by the owner, but the index must be readable by whatever serves the repository
+	if err := os.Chmod(tmpPath, indexFileMode); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, absPath)
+}
@@ -0,0 +1,118 @@
+package helm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	helmChartutil "helm.sh/helm/v3/pkg/chartutil"
+	helmReleaseutil "helm.sh/helm/v3/pkg/releaseutil"
+	"sigs.k8s.io/yaml"
+)
+
+// GetImagesFromValues returns a sorted list of all images referenced within the values provided
+// An image is either a map containing a repository and a tag or a string tied to an image key
+func GetImagesFromValues(values map[string]interface{}) []string {
+	imageSet := make(map[string]bool)
+	collectImages(values, imageSet)
+	images := make([]string, 0, len(imageSet))
+	for image := range imageSet {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	return images
+}
+
+// collectImages recursively collects any images found in val into imageSet
+func collectImages(val interface{}, imageSet map[string]bool) {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		repository, hasRepository := v["repository"].(string)
+		if hasRepository && len(repository) > 0 {
+			if tag, ok := v["tag"]; ok && tag != nil && fmt.Sprintf("%v", tag) != "" {
+				imageSet[fmt.Sprintf("%s:%v", repository, tag)] = true
+			} else {
+				imageSet[repository] = true
+			}
+		}
+		for key, inner := range v {
+			if image, ok := inner.(string); ok && key == "image" && len(image) > 0 {
+				imageSet[image] = true
+				continue
+			}
+			collectImages(inner, imageSet)
+		}
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, inner := range v {
+			converted[fmt.Sprintf("%v", key)] = inner
+		}
+		collectImages(converted, imageSet)
+	case []interface{}:
+		for _, inner := range v {
+			collectImages(inner, imageSet)
+		}
+	}
+}
+
+// GetImagesFromRenderedChart returns a sorted list of all images referenced by the manifests that the chart at helmChartPath renders
+// The chart is rendered once with its default values and once more for each of the valuesFiles, which are merged over its default values
+// Images within values that no rendered manifest uses, e.g. those of disabled components, are not included
+func GetImagesFromRenderedChart(fs billy.Filesystem, helmChartPath string, valuesFiles []string) ([]string, error) {
+	imageSet := make(map[string]bool)
+	renderValues := []map[string]interface{}{{}}
+	for _, valuesFile := range valuesFiles {
+		vals, err := helmChartutil.ReadValuesFile(valuesFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read values from %s: %s", valuesFile, err)
+		}
+		renderValues = append(renderValues, vals.AsMap())
+	}
+	for i, vals := range renderValues {
+		rendered, err := renderChart(fs, helmChartPath, vals, renderReleaseName, renderReleaseNamespace, helmChartutil.DefaultCapabilities)
+		if err != nil {
+			if i == 0 {
+				return nil, fmt.Errorf("Could not render Helm chart with its default values: %s", err)
+			}
+			return nil, fmt.Errorf("Could not render Helm chart with values from %s: %s", valuesFiles[i-1], err)
+		}
+		for template, manifests := range rendered {
+			if strings.HasSuffix(template, "NOTES.txt") {
+				continue
+			}
+			for _, manifest := range helmReleaseutil.SplitManifests(manifests) {
+				var obj map[string]interface{}
+				if err := yaml.Unmarshal([]byte(manifest), &obj); err != nil {
+					// Invalid manifests are reported when rendering against render profiles
+					continue
+				}
+				collectRenderedImages(obj, imageSet)
+			}
+		}
+	}
+	images := make([]string, 0, len(imageSet))
+	for image := range imageSet {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	return images, nil
+}
+
+// collectRenderedImages recursively collects the value of every image field found in a rendered manifest into imageSet
+func collectRenderedImages(val interface{}, imageSet map[string]bool) {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		for key, inner := range v {
+			if image, ok := inner.(string); ok && key == "image" && len(strings.TrimSpace(image)) > 0 {
+				imageSet[strings.TrimSpace(image)] = true
+				continue
+			}
+			collectRenderedImages(inner, imageSet)
+		}
+	case []interface{}:
+		for _, inner := range v {
+			collectRenderedImages(inner, imageSet)
+		}
+	}
+}
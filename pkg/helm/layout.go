@@ -0,0 +1,205 @@
+package helm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	"github.com/rancher/charts-build-scripts/pkg/path"
+	helmLoader "helm.sh/helm/v3/pkg/chart/loader"
+	helmProvenance "helm.sh/helm/v3/pkg/provenance"
+	helmRepo "helm.sh/helm/v3/pkg/repo"
+)
+
+const (
+	// DefaultAssetTemplate is the path of each chart archive within the assets directory of its package unless configured otherwise
+	DefaultAssetTemplate = "{name}-{version}.tgz"
+	// DefaultChartTemplate is the path of each unarchived chart within the charts directory of its package unless configured otherwise
+	DefaultChartTemplate = "{name}/{version}"
+
+	// layoutNamePlaceholder is replaced by the name of the chart in a layout template
+	layoutNamePlaceholder = "{name}"
+	// layoutVersionPlaceholder is replaced by the version of the chart in a layout template
+	layoutVersionPlaceholder = "{version}"
+)
+
+var (
+	// Layout represents where exported charts are placed within the assets and charts directories of their package
+	Layout options.LayoutOptions
+
+	// layoutPlaceholderRegex matches any placeholder within a layout template
+	layoutPlaceholderRegex = regexp.MustCompile(`\{[^{}]*\}`)
+)
+
+// ExportedChart represents a chart version found within the charts directory of a package
+type ExportedChart struct {
+	// Name is the name of the chart
+	Name string
+	// Version is the version of the chart
+	Version string
+	// Path is the path to the unarchived chart within the repository
+	Path string
+}
+
+// ValidateLayout returns an error if a template of the layout is not a relative path that contains the name and version of the chart
+func ValidateLayout(layout options.LayoutOptions) error {
+	if err := validateLayoutTemplate("Asset", layout.AssetTemplate); err != nil {
+		return err
+	}
+	if len(layout.AssetTemplate) > 0 && !strings.HasSuffix(layout.AssetTemplate, ".tgz") {
+		return fmt.Errorf("Asset template %s is invalid: chart archives must end with .tgz", layout.AssetTemplate)
+	}
+	return validateLayoutTemplate("Chart", layout.ChartTemplate)
+}
+
+// validateLayoutTemplate returns an error if the template is set but is not a relative path that contains the name and version of the chart
+func validateLayoutTemplate(kind, template string) error {
+	if len(template) == 0 {
+		return nil
+	}
+	for _, placeholder := range layoutPlaceholderRegex.FindAllString(template, -1) {
+		if placeholder != layoutNamePlaceholder && placeholder != layoutVersionPlaceholder {
+			return fmt.Errorf("%s template %s is invalid: unknown placeholder %s, must be one of %s or %s", kind, template, placeholder, layoutNamePlaceholder, layoutVersionPlaceholder)
+		}
+	}
+	if !strings.Contains(template, layoutNamePlaceholder) || !strings.Contains(template, layoutVersionPlaceholder) {
+		return fmt.Errorf("%s template %s is invalid: must contain both %s and %s so that every chart version has its own path", kind, template, layoutNamePlaceholder, layoutVersionPlaceholder)
+	}
+	cleanTemplate := filepath.Clean(template)
+	if filepath.IsAbs(cleanTemplate) || cleanTemplate == ".." || strings.HasPrefix(cleanTemplate, "../") {
+		return fmt.Errorf("%s template %s is invalid: must be a path relative to the directory of the package", kind, template)
+	}
+	return nil
+}
+
+// GetAssetPath returns the path within the repository of the archive of the chart version, given the assets directory of its package
+func GetAssetPath(packageAssetsDirpath, chartName, chartVersion string) string {
+	template := Layout.AssetTemplate
+	if len(template) == 0 {
+		template = DefaultAssetTemplate
+	}
+	return filepath.Join(packageAssetsDirpath, renderLayoutTemplate(template, chartName, chartVersion))
+}
+
+// GetChartPath returns the path within the repository of the unarchived chart version, given the charts directory of its package
+func GetChartPath(packageChartsDirpath, chartName, chartVersion string) string {
+	template := Layout.ChartTemplate
+	if len(template) == 0 {
+		template = DefaultChartTemplate
+	}
+	return filepath.Join(packageChartsDirpath, renderLayoutTemplate(template, chartName, chartVersion))
+}
+
+// renderLayoutTemplate replaces the placeholders of the template with the name and version of the chart
+func renderLayoutTemplate(template, chartName, chartVersion string) string {
+	return strings.NewReplacer(layoutNamePlaceholder, chartName, layoutVersionPlaceholder, chartVersion).Replace(template)
+}
+
+// getChartTemplate returns the cleaned chart template of the layout
+func getChartTemplate() string {
+	template := Layout.ChartTemplate
+	if len(template) == 0 {
+		template = DefaultChartTemplate
+	}
+	return filepath.Clean(template)
+}
+
+// ParseChartPath returns the name and version of the chart version whose unarchived chart is at relPath within the charts directory of its package
+// The last return value is false if relPath is not laid out by the chart template
+func ParseChartPath(relPath string) (string, string, bool) {
+	template := getChartTemplate()
+	// Each placeholder matches a single path element, so chart names and versions never span directories
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	for i, part := range layoutPlaceholderRegex.Split(template, -1) {
+		if i > 0 {
+			pattern.WriteString(`([^/]+)`)
+		}
+		pattern.WriteString(regexp.QuoteMeta(part))
+	}
+	pattern.WriteString("$")
+	submatches := regexp.MustCompile(pattern.String()).FindStringSubmatch(filepath.ToSlash(relPath))
+	if submatches == nil {
+		return "", "", false
+	}
+	var name, version string
+	for i, placeholder := range layoutPlaceholderRegex.FindAllString(template, -1) {
+		value := submatches[i+1]
+		field := &version
+		if placeholder == layoutNamePlaceholder {
+			field = &name
+		}
+		// A placeholder that appears more than once must match the same value each time
+		if len(*field) > 0 && *field != value {
+			return "", "", false
+		}
+		*field = value
+	}
+	return name, version, true
+}
+
+// GetExportedCharts returns every chart version found within the charts directory of the package, as laid out by the chart template
+func GetExportedCharts(rootFs billy.Filesystem, packageChartsDirpath string) ([]ExportedChart, error) {
+	absPackageChartsDirpath := filesystem.GetAbsPath(rootFs, packageChartsDirpath)
+	matches, err := filepath.Glob(filepath.Join(absPackageChartsDirpath, layoutPlaceholderRegex.ReplaceAllString(getChartTemplate(), "*")))
+	if err != nil {
+		return nil, err
+	}
+	var exportedCharts []ExportedChart
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			continue
+		}
+		relPath, err := filepath.Rel(absPackageChartsDirpath, match)
+		if err != nil {
+			return nil, err
+		}
+		name, version, ok := ParseChartPath(relPath)
+		if !ok {
+			continue
+		}
+		exportedCharts = append(exportedCharts, ExportedChart{
+			Name:    name,
+			Version: version,
+			Path:    filepath.Join(packageChartsDirpath, relPath),
+		})
+	}
+	return exportedCharts, nil
+}
+
+// IndexRepositoryAssets returns a Helm index of every chart archive within the assets directory of the repository, at any depth
+// Unlike helmRepo.IndexDirectory, which only looks one directory deep, this indexes archives placed by any asset template
+func IndexRepositoryAssets(rootFs billy.Filesystem) (*helmRepo.IndexFile, error) {
+	indexFile := helmRepo.NewIndexFile()
+	exists, err := filesystem.PathExists(rootFs, path.RepositoryAssetsDir)
+	if err != nil || !exists {
+		return indexFile, err
+	}
+	err = filesystem.WalkDir(rootFs, path.RepositoryAssetsDir, func(fs billy.Filesystem, assetPath string, isDir bool) error {
+		if isDir || filepath.Ext(assetPath) != ".tgz" {
+			return nil
+		}
+		absAssetPath := filesystem.GetAbsPath(fs, assetPath)
+		chart, err := helmLoader.Load(absAssetPath)
+		if err != nil {
+			// Assume this is not a chart
+			return nil
+		}
+		digest, err := helmProvenance.DigestFile(absAssetPath)
+		if err != nil {
+			return err
+		}
+		indexFile.Add(chart.Metadata, filepath.Base(assetPath), filepath.ToSlash(filepath.Dir(assetPath)), digest)
+		return nil
+	})
+	return indexFile, err
+}
@@ -0,0 +1,148 @@
+package helm
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	"github.com/rancher/charts-build-scripts/pkg/path"
+	helmChartutil "helm.sh/helm/v3/pkg/chartutil"
+)
+
+const (
+	// CamelCaseKeysRule is the name of the rule that requires every key to be camelCase
+	CamelCaseKeysRule = "camelCaseKeys"
+	// SplitImagesRule is the name of the rule that requires every image to have a separate repository and tag
+	SplitImagesRule = "splitImages"
+	// DefaultResourcesRule is the name of the rule that requires every resources block to set default requests or limits
+	DefaultResourcesRule = "defaultResources"
+)
+
+var camelCaseRegex = regexp.MustCompile(`^[a-z][a-zA-Z0-9]*$`)
+
+// ValuesLintViolation represents a key within a values.yaml that does not follow a lint rule
+type ValuesLintViolation struct {
+	// Rule is the name of the rule that was violated
+	Rule string
+	// Path is the dot-separated path to the key that violates the rule
+	Path string
+	// Message describes the violation
+	Message string
+}
+
+func (v ValuesLintViolation) String() string {
+	return fmt.Sprintf("%s: %s (%s)", v.Path, v.Message, v.Rule)
+}
+
+// LintValuesFile lints the values.yaml of the chart at helmChartPath against the rules in lintOptions
+// Any violation that matches one of the suppressions is not returned
+func LintValuesFile(fs billy.Filesystem, helmChartPath string, lintOptions options.ValuesLintOptions, suppressions []options.ValuesLintSuppression) ([]ValuesLintViolation, error) {
+	valuesPath := filepath.Join(helmChartPath, path.ChartValuesFile)
+	exists, err := filesystem.PathExists(fs, valuesPath)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, nil
+	}
+	values, err := helmChartutil.ReadValuesFile(filesystem.GetAbsPath(fs, valuesPath))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read values from %s: %s", valuesPath, err)
+	}
+	var violations []ValuesLintViolation
+	for _, violation := range LintValues(values, lintOptions) {
+		if isSuppressed(violation, suppressions) {
+			continue
+		}
+		violations = append(violations, violation)
+	}
+	return violations, nil
+}
+
+// LintValues returns all violations of the rules in lintOptions found within the values provided
+func LintValues(values map[string]interface{}, lintOptions options.ValuesLintOptions) []ValuesLintViolation {
+	var violations []ValuesLintViolation
+	lintValue(values, "", "", lintOptions, &violations)
+	return violations
+}
+
+// lintValue recursively lints val, which is found at valPath under the key, and adds any violations it finds
+func lintValue(val interface{}, key, valPath string, lintOptions options.ValuesLintOptions, violations *[]ValuesLintViolation) {
+	addViolation := func(rule, message string) {
+		*violations = append(*violations, ValuesLintViolation{Rule: rule, Path: valPath, Message: message})
+	}
+	if lintOptions.SplitImages && key == "image" {
+		switch v := val.(type) {
+		case string:
+			addViolation(SplitImagesRule, "image must be a map with a separate repository and tag")
+		case map[string]interface{}:
+			if _, ok := v["repository"]; !ok {
+				addViolation(SplitImagesRule, "image must set a repository")
+			}
+			if _, ok := v["tag"]; !ok {
+				addViolation(SplitImagesRule, "image must set a tag")
+			}
+		}
+	}
+	if lintOptions.DefaultResources && key == "resources" {
+		v, _ := val.(map[string]interface{})
+		requests, _ := v["requests"].(map[string]interface{})
+		limits, _ := v["limits"].(map[string]interface{})
+		if len(requests) == 0 && len(limits) == 0 {
+			addViolation(DefaultResourcesRule, "resources must set default requests or limits")
+		}
+	}
+	switch v := val.(type) {
+	case map[string]interface{}:
+		if isFreeFormMap(key) {
+			// Keys and values of free-form maps are arbitrary strings defined by the user
+			return
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			innerPath := k
+			if len(valPath) > 0 {
+				innerPath = valPath + "." + k
+			}
+			if lintOptions.CamelCaseKeys && !camelCaseRegex.MatchString(k) {
+				*violations = append(*violations, ValuesLintViolation{Rule: CamelCaseKeysRule, Path: innerPath, Message: "key must be camelCase"})
+			}
+			lintValue(v[k], k, innerPath, lintOptions, violations)
+		}
+	case []interface{}:
+		for i, inner := range v {
+			lintValue(inner, key, fmt.Sprintf("%s[%d]", valPath, i), lintOptions, violations)
+		}
+	}
+}
+
+// isFreeFormMap returns whether the map under the key holds arbitrary user-defined keys, such as labels or annotations
+func isFreeFormMap(key string) bool {
+	lowerKey := strings.ToLower(key)
+	return strings.HasSuffix(lowerKey, "labels") || strings.HasSuffix(lowerKey, "annotations") || strings.HasSuffix(lowerKey, "selector")
+}
+
+// isSuppressed returns whether the violation matches any of the suppressions
+func isSuppressed(violation ValuesLintViolation, suppressions []options.ValuesLintSuppression) bool {
+	for _, suppression := range suppressions {
+		if suppression.Rule != violation.Rule {
+			continue
+		}
+		if len(suppression.Path) == 0 || violation.Path == suppression.Path {
+			return true
+		}
+		if strings.HasPrefix(violation.Path, suppression.Path+".") || strings.HasPrefix(violation.Path, suppression.Path+"[") {
+			return true
+		}
+	}
+	return false
+}
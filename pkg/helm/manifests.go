@@ -0,0 +1,278 @@
+package helm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	"github.com/rancher/charts-build-scripts/pkg/ratelimit"
+	"github.com/rancher/charts-build-scripts/pkg/retry"
+	"github.com/sirupsen/logrus"
+	helmLoader "helm.sh/helm/v3/pkg/chart/loader"
+	helmChartutil "helm.sh/helm/v3/pkg/chartutil"
+	helmReleaseutil "helm.sh/helm/v3/pkg/releaseutil"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// DefaultSchemaLocation is the location of the JSON schemas of Kubernetes kinds that manifests are validated against by default
+	DefaultSchemaLocation = "https://raw.githubusercontent.com/yannh/kubernetes-json-schema/master/{kubeVersion}-standalone-strict/{kind}{kindSuffix}.json"
+)
+
+var (
+	// schemas holds the JSON schemas fetched from each location, or nil if no schema exists at the location
+	schemas     = make(map[string][]byte)
+	schemasLock sync.Mutex
+)
+
+// ManifestViolation represents a manifest rendered by a chart that does not match the schema of its kind in a Kubernetes version
+type ManifestViolation struct {
+	// Path is the path to the chart that rendered the manifest
+	Path string
+	// KubeVersion is the Kubernetes version whose schema the manifest was validated against
+	KubeVersion string
+	// Template is the path to the template or CRD file that contains the manifest relative to the root of the chart
+	Template string
+	// Resource identifies the resource within the manifest, i.e. {apiVersion}/{kind}/{name}
+	Resource string
+	// Message describes why the manifest does not match the schema
+	Message string
+}
+
+func (v ManifestViolation) String() string {
+	return fmt.Sprintf("%s/%s: %s against Kubernetes %s: %s", v.Path, v.Template, v.Resource, v.KubeVersion, v.Message)
+}
+
+// ValidateManifests renders the templates of the chart at helmChartPath with its default values against each Kubernetes version in manifestValidationOptions
+// and validates the rendered manifests, along with the CRDs within the chart, against the schemas of their kinds in that Kubernetes version
+// A manifest whose kind has no schema is reported if it belongs to an API group built into Kubernetes, since the apiVersion is not served by that Kubernetes version
+func ValidateManifests(fs billy.Filesystem, helmChartPath string, manifestValidationOptions options.ManifestValidationOptions) ([]ManifestViolation, error) {
+	chart, err := helmLoader.Load(filesystem.GetAbsPath(fs, helmChartPath))
+	if err != nil {
+		return nil, fmt.Errorf("Could not load Helm chart: %s", err)
+	}
+	schemaLocation := manifestValidationOptions.SchemaLocation
+	if len(schemaLocation) == 0 {
+		schemaLocation = DefaultSchemaLocation
+	}
+	var violations []ManifestViolation
+	for _, kubeVersion := range manifestValidationOptions.KubeVersions {
+		caps, err := getCapabilities(options.RenderProfile{KubeVersion: kubeVersion})
+		if err != nil {
+			return nil, err
+		}
+		// Templates gated on .Capabilities.APIVersions must render as they would against a cluster of this Kubernetes version
+		apiVersions, err := getServedAPIVersions(caps.KubeVersion.Version)
+		if err != nil {
+			return nil, err
+		}
+		caps.APIVersions = helmChartutil.VersionSet(apiVersions)
+		rendered, err := renderChart(fs, helmChartPath, map[string]interface{}{}, renderReleaseName, renderReleaseNamespace, caps)
+		if err != nil {
+			return nil, fmt.Errorf("Could not render Helm chart against Kubernetes %s: %s", caps.KubeVersion.Version, err)
+		}
+		// Rendered templates are keyed by {chart}/templates/..., so the name of the chart is dropped
+		manifestsByFile := make(map[string]string, len(rendered))
+		for template, manifests := range rendered {
+			if strings.HasSuffix(template, "NOTES.txt") {
+				continue
+			}
+			if i := strings.Index(template, "/"); i >= 0 {
+				template = template[i+1:]
+			}
+			manifestsByFile[template] = manifests
+		}
+		for _, crd := range chart.CRDObjects() {
+			manifestsByFile[strings.TrimPrefix(crd.Filename, chart.Name()+"/")] = string(crd.File.Data)
+		}
+		files := make([]string, 0, len(manifestsByFile))
+		for file := range manifestsByFile {
+			files = append(files, file)
+		}
+		sort.Strings(files)
+		var manifests []renderedManifest
+		for _, file := range files {
+			split := helmReleaseutil.SplitManifests(manifestsByFile[file])
+			keys := make([]string, 0, len(split))
+			for key := range split {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				var obj map[string]interface{}
+				if err := yaml.Unmarshal([]byte(split[key]), &obj); err != nil {
+					return nil, fmt.Errorf("Template %s rendered an invalid manifest against Kubernetes %s: %s", file, caps.KubeVersion.Version, err)
+				}
+				if obj == nil {
+					continue
+				}
+				manifests = append(manifests, renderedManifest{file: file, obj: obj})
+			}
+		}
+		crdGroups := getCRDGroups(manifests)
+		for _, m := range manifests {
+			message, err := validateManifest(m.obj, schemaLocation, caps.KubeVersion.Version, crdGroups)
+			if err != nil {
+				return nil, err
+			}
+			if len(message) == 0 {
+				continue
+			}
+			violations = append(violations, ManifestViolation{
+				Path:        helmChartPath,
+				KubeVersion: caps.KubeVersion.Version,
+				Template:    m.file,
+				Resource:    getManifestResource(m.obj),
+				Message:     message,
+			})
+		}
+	}
+	return violations, nil
+}
+
+// renderedManifest represents a manifest rendered by a chart along with the template or CRD file that contains it
+type renderedManifest struct {
+	file string
+	obj  map[string]interface{}
+}
+
+// getCRDGroups returns the API groups of the CustomResourceDefinitions within the manifests
+func getCRDGroups(manifests []renderedManifest) map[string]bool {
+	crdGroups := make(map[string]bool)
+	for _, m := range manifests {
+		if kind, _ := m.obj["kind"].(string); kind != "CustomResourceDefinition" {
+			continue
+		}
+		if spec, ok := m.obj["spec"].(map[string]interface{}); ok {
+			if group, ok := spec["group"].(string); ok && len(group) > 0 {
+				crdGroups[group] = true
+			}
+		}
+	}
+	return crdGroups
+}
+
+// validateManifest returns a message describing why the manifest does not match the schema of its kind in the Kubernetes version, or an empty string if it does
+// Manifests of the crdGroups defined by the chart are only validated if the schema location provides a schema for them
+func validateManifest(obj map[string]interface{}, schemaLocation, kubeVersion string, crdGroups map[string]bool) (string, error) {
+	apiVersion, _ := obj["apiVersion"].(string)
+	kind, _ := obj["kind"].(string)
+	if len(apiVersion) == 0 || len(kind) == 0 {
+		return "manifest does not set an apiVersion and kind", nil
+	}
+	group, version := "", apiVersion
+	if i := strings.LastIndex(apiVersion, "/"); i >= 0 {
+		group, version = apiVersion[:i], apiVersion[i+1:]
+	}
+	schema, err := getSchema(getSchemaLocation(schemaLocation, kubeVersion, kind, group, version))
+	if err != nil {
+		return "", err
+	}
+	if schema == nil {
+		if builtInGroups[group] && !crdGroups[group] {
+			return fmt.Sprintf("%s %s is not served by Kubernetes %s", apiVersion, kind, kubeVersion), nil
+		}
+		// Custom resources are only validated if the schema location provides a schema for them
+		logrus.Debugf("Skipping validation of %s %s against Kubernetes %s: no schema found", apiVersion, kind, kubeVersion)
+		return "", nil
+	}
+	if err := helmChartutil.ValidateAgainstSingleSchema(obj, schema); err != nil {
+		// Each error is reported by Helm on a line of its own, e.g. "- spec.replicas: Invalid type"
+		var messages []string
+		for _, line := range strings.Split(err.Error(), "\n") {
+			if line = strings.TrimSpace(strings.TrimPrefix(line, "- ")); len(line) > 0 {
+				messages = append(messages, line)
+			}
+		}
+		return strings.Join(messages, "; "), nil
+	}
+	return "", nil
+}
+
+// getSchemaLocation returns the location of the schema of the kind in the Kubernetes version
+func getSchemaLocation(schemaLocation, kubeVersion, kind, group, version string) string {
+	// Schemas are named after the first component of the group, e.g. networking for networking.k8s.io
+	group = strings.SplitN(group, ".", 2)[0]
+	kindSuffix := fmt.Sprintf("-%s", version)
+	if len(group) > 0 {
+		kindSuffix = fmt.Sprintf("-%s-%s", group, version)
+	}
+	return strings.NewReplacer(
+		"{kubeVersion}", kubeVersion,
+		"{kind}", strings.ToLower(kind),
+		"{group}", group,
+		"{version}", version,
+		"{kindSuffix}", kindSuffix,
+	).Replace(schemaLocation)
+}
+
+// getManifestResource returns the apiVersion, kind, and name of the manifest, i.e. {apiVersion}/{kind}/{name}
+func getManifestResource(obj map[string]interface{}) string {
+	apiVersion, _ := obj["apiVersion"].(string)
+	kind, _ := obj["kind"].(string)
+	var name string
+	if metadata, ok := obj["metadata"].(map[string]interface{}); ok {
+		name, _ = metadata["name"].(string)
+	}
+	return fmt.Sprintf("%s/%s/%s", apiVersion, kind, name)
+}
+
+// getSchema returns the JSON schema at the location, which is either a URL or a local path, or nil if no schema exists at the location
+// Schemas are only fetched once per location
+func getSchema(location string) ([]byte, error) {
+	schemasLock.Lock()
+	defer schemasLock.Unlock()
+	if schema, ok := schemas[location]; ok {
+		return schema, nil
+	}
+	var schema []byte
+	var err error
+	if u, parseErr := url.Parse(location); parseErr == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		err = retry.Do(fmt.Sprintf("download %s", location), func() error {
+			schema, err = fetchSchema(location)
+			return err
+		})
+	} else {
+		schema, err = ioutil.ReadFile(location)
+		if os.IsNotExist(err) {
+			schema, err = nil, nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get schema from %s: %s", location, err)
+	}
+	schemas[location] = schema
+	return schema, nil
+}
+
+// fetchSchema makes a single attempt at downloading the JSON schema from the url and returns nil if it does not exist
+// Errors that will not be resolved by retrying the download are marked as permanent
+func fetchSchema(url string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, retry.Permanent(err)
+	}
+	defer ratelimit.Acquire(req.URL.Hostname())()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, nil
+	case resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout:
+		return nil, fmt.Errorf("%s", resp.Status)
+	case resp.StatusCode != http.StatusOK:
+		return nil, retry.Permanent(fmt.Errorf("%s", resp.Status))
+	}
+	return ioutil.ReadAll(resp.Body)
+}
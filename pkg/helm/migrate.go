@@ -0,0 +1,97 @@
+package helm
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/sirupsen/logrus"
+	helmChart "helm.sh/helm/v3/pkg/chart"
+	helmChartutil "helm.sh/helm/v3/pkg/chartutil"
+)
+
+const (
+	// requirementsLockFile is the file that Helm v2 charts used to lock their dependencies
+	requirementsLockFile = "requirements.lock"
+	// chartLockFile is the file that apiVersion v2 charts use to lock their dependencies
+	chartLockFile = "Chart.lock"
+)
+
+var (
+	// AllowAPIVersionV1 indicates that charts that use apiVersion v1 can be exported instead of having to be migrated to apiVersion v2 first
+	AllowAPIVersionV1 = false
+
+	// apiVersionRegex matches the apiVersion key of a Chart.yaml
+	apiVersionRegex = regexp.MustCompile(`(?m)^apiVersion:.*$`)
+	// dependenciesKeyRegex matches the dependencies key of a Chart.yaml
+	dependenciesKeyRegex = regexp.MustCompile(`(?m)^dependencies:`)
+	// leadingDocumentSeparatorRegex matches a document separator at the start of a YAML file
+	leadingDocumentSeparatorRegex = regexp.MustCompile(`^(?:[ \t]*(?:#.*)?\r?\n)*---[ \t]*\r?\n`)
+)
+
+// MigrateChartToAPIVersionV2 converts the chart at helmChartPath from apiVersion v1 to apiVersion v2
+// Only the apiVersion of the Chart.yaml is changed and the contents of the requirements.yaml are moved to the end of it as is, so the rest of the Chart.yaml keeps its formatting
+// The requirements.lock is renamed to Chart.lock. It returns whether the chart was converted, i.e. false if it already uses apiVersion v2
+func MigrateChartToAPIVersionV2(fs billy.Filesystem, helmChartPath string) (bool, error) {
+	isV1, err := IsAPIVersionV1Chart(fs, helmChartPath)
+	if err != nil {
+		return false, err
+	}
+	if !isV1 {
+		return false, nil
+	}
+	chartYamlPath := filepath.Join(helmChartPath, helmChartutil.ChartfileName)
+	chartYamlBytes, err := ioutil.ReadFile(filesystem.GetAbsPath(fs, chartYamlPath))
+	if err != nil {
+		return false, err
+	}
+	apiVersionLine := []byte(fmt.Sprintf("apiVersion: %s", helmChart.APIVersionV2))
+	if apiVersionRegex.Match(chartYamlBytes) {
+		chartYamlBytes = apiVersionRegex.ReplaceAllLiteral(chartYamlBytes, apiVersionLine)
+	} else {
+		chartYamlBytes = append(append(apiVersionLine, '\n'), chartYamlBytes...)
+	}
+	requirementsPath := filepath.Join(helmChartPath, requirementsFile)
+	exists, err := filesystem.PathExists(fs, requirementsPath)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		requirementsBytes, err := ioutil.ReadFile(filesystem.GetAbsPath(fs, requirementsPath))
+		if err != nil {
+			return false, err
+		}
+		if dependenciesKeyRegex.Match(chartYamlBytes) {
+			return false, fmt.Errorf("%s already declares dependencies, so those in %s cannot be moved into it", chartYamlPath, requirementsPath)
+		}
+		requirementsBytes = leadingDocumentSeparatorRegex.ReplaceAll(requirementsBytes, nil)
+		if len(bytes.TrimSpace(requirementsBytes)) > 0 {
+			if len(chartYamlBytes) > 0 && chartYamlBytes[len(chartYamlBytes)-1] != '\n' {
+				chartYamlBytes = append(chartYamlBytes, '\n')
+			}
+			chartYamlBytes = append(chartYamlBytes, requirementsBytes...)
+		}
+	}
+	if err := writeChartFile(fs, chartYamlPath, chartYamlBytes); err != nil {
+		return false, err
+	}
+	if err := filesystem.RemoveAll(fs, requirementsPath); err != nil {
+		return false, fmt.Errorf("Encountered error while trying to remove %s: %s", requirementsPath, err)
+	}
+	requirementsLockPath := filepath.Join(helmChartPath, requirementsLockFile)
+	exists, err = filesystem.PathExists(fs, requirementsLockPath)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		if err := fs.Rename(requirementsLockPath, filepath.Join(helmChartPath, chartLockFile)); err != nil {
+			return false, fmt.Errorf("Encountered error while trying to rename %s to %s: %s", requirementsLockPath, chartLockFile, err)
+		}
+	}
+	logrus.Infof("Migrated %s from apiVersion %s to apiVersion %s", helmChartPath, helmChart.APIVersionV1, helmChart.APIVersionV2)
+	return true, nil
+}
@@ -0,0 +1,226 @@
+package helm
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	"github.com/sirupsen/logrus"
+	helmChart "helm.sh/helm/v3/pkg/chart"
+	helmLoader "helm.sh/helm/v3/pkg/chart/loader"
+	helmChartutil "helm.sh/helm/v3/pkg/chartutil"
+)
+
+var (
+	// templateImageRegex matches image fields within templates that are set to a hardcoded image rather than one rendered from the values
+	templateImageRegex = regexp.MustCompile(`(?m)^([ \t]*(?:-[ \t]+)?image:[ \t]*["']?)([a-zA-Z0-9][^\s"'{}]*)(["']?[ \t]*(?:#.*)?)$`)
+	// valuesPathKeyRegex matches a key of a block mapping within a values.yaml after any indentation and sequence indicators, capturing the key
+	valuesPathKeyRegex = regexp.MustCompile(`^("[^"]*"|'[^']*'|[^\s#"'][^:#]*?):(?:[ \t]|$)`)
+)
+
+// valuesPathElement represents a key or sequence item that contains a line of a values.yaml
+type valuesPathElement struct {
+	// indent is the column of the key or of the sequence indicator of the item
+	indent int
+	// name is the key or, for a sequence item, its index in brackets
+	name string
+	// isItem indicates that the element is a sequence item
+	isItem bool
+}
+
+// imageRewrite represents a value of a field within the values.yaml of a chart that is rewritten to point at a mirror registry
+type imageRewrite struct {
+	// Path is the keys and, in brackets, sequence indices that lead to the field within the values.yaml, e.g. image.repository or images.[0].image
+	Path []string
+	// Field is the name of the field, i.e. registry, repository, or image
+	Field string
+	// Current is the current value of the field
+	Current string
+	// Mirrored is the value of the field once it points at the mirror registry
+	Mirrored string
+}
+
+// ValidateImageMirror returns an error if the image mirror options are invalid
+func ValidateImageMirror(imageMirror *options.ImageMirrorOptions) error {
+	if imageMirror == nil {
+		return nil
+	}
+	if len(strings.Trim(imageMirror.Registry, "/")) == 0 {
+		return fmt.Errorf("Image mirror must provide a registry")
+	}
+	return nil
+}
+
+// addImageMirror rewrites the images referenced by the values.yaml of the chart archive at absTgzPath and its subcharts to point at the mirror registry,
+// along with images hardcoded within their templates if imageMirror.Templates is set. The rest of each file is left as is
+func addImageMirror(absTgzPath string, imageMirror *options.ImageMirrorOptions) error {
+	chart, err := helmLoader.Load(absTgzPath)
+	if err != nil {
+		return fmt.Errorf("Could not load Helm chart archive %s: %s", absTgzPath, err)
+	}
+	mirror := strings.Trim(imageMirror.Registry, "/")
+	numRewritten := mirrorChartImages(chart, mirror, imageMirror.Templates)
+	if numRewritten == 0 {
+		return nil
+	}
+	if _, err := helmChartutil.Save(chart, filepath.Dir(absTgzPath)); err != nil {
+		return fmt.Errorf("Could not save Helm chart archive %s: %s", absTgzPath, err)
+	}
+	logrus.Infof("Rewrote %d image references in %s to point at %s", numRewritten, filepath.Base(absTgzPath), mirror)
+	return nil
+}
+
+// mirrorChartImages rewrites the images referenced by the chart and its subcharts to point at the mirror and returns the number of references rewritten
+func mirrorChartImages(chart *helmChart.Chart, mirror string, templates bool) int {
+	var numRewritten int
+	for _, f := range chart.Raw {
+		if f.Name != helmChartutil.ValuesfileName {
+			continue
+		}
+		var rewrites []imageRewrite
+		collectImageRewrites(chart.Values, mirror, nil, &rewrites)
+		for _, rewrite := range rewrites {
+			var rewritten bool
+			f.Data, rewritten = rewriteValuesField(f.Data, rewrite)
+			if rewritten {
+				numRewritten++
+			}
+		}
+	}
+	if templates {
+		for _, f := range chart.Templates {
+			f.Data = templateImageRegex.ReplaceAllFunc(f.Data, func(match []byte) []byte {
+				groups := templateImageRegex.FindSubmatch(match)
+				mirrored := mirrorImage(string(groups[2]), mirror)
+				if mirrored == string(groups[2]) {
+					return match
+				}
+				numRewritten++
+				return []byte(string(groups[1]) + mirrored + string(groups[3]))
+			})
+		}
+	}
+	for _, dependency := range chart.Dependencies() {
+		numRewritten += mirrorChartImages(dependency, mirror, templates)
+	}
+	return numRewritten
+}
+
+// collectImageRewrites collects the rewrites of every image found within val, which is found at valuesPath within the values, following the same conventions as GetImagesFromValues
+// Images that set a registry separately from their repository have their registry replaced by the mirror instead
+func collectImageRewrites(val interface{}, mirror string, valuesPath []string, rewrites *[]imageRewrite) {
+	fieldPath := func(field string) []string {
+		return append(append([]string{}, valuesPath...), field)
+	}
+	switch v := val.(type) {
+	case map[string]interface{}:
+		repository, hasRepository := v["repository"].(string)
+		registry, hasRegistry := v["registry"].(string)
+		if hasRepository && len(repository) > 0 {
+			if hasRegistry && len(registry) > 0 {
+				if registry != mirror && !strings.Contains(registry, "{{") {
+					*rewrites = append(*rewrites, imageRewrite{Path: fieldPath("registry"), Field: "registry", Current: registry, Mirrored: mirror})
+				}
+			} else if mirrored := mirrorImage(repository, mirror); mirrored != repository {
+				*rewrites = append(*rewrites, imageRewrite{Path: fieldPath("repository"), Field: "repository", Current: repository, Mirrored: mirrored})
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if image, ok := v[key].(string); ok && key == "image" && len(image) > 0 {
+				if mirrored := mirrorImage(image, mirror); mirrored != image {
+					*rewrites = append(*rewrites, imageRewrite{Path: fieldPath("image"), Field: "image", Current: image, Mirrored: mirrored})
+				}
+				continue
+			}
+			collectImageRewrites(v[key], mirror, fieldPath(key), rewrites)
+		}
+	case []interface{}:
+		for i, inner := range v {
+			collectImageRewrites(inner, mirror, fieldPath(fmt.Sprintf("[%d]", i)), rewrites)
+		}
+	}
+}
+
+// rewriteValuesField replaces the current value of the field of the rewrite with its mirrored value if the field is found at the path of the rewrite within the values.yaml
+// Only fields set on the same line as their key within block mappings and sequences are rewritten. The rest of the values.yaml is left as is
+func rewriteValuesField(values []byte, rewrite imageRewrite) ([]byte, bool) {
+	fieldRegex := regexp.MustCompile(fmt.Sprintf(`^(%s[ \t]*["']?)%s(["']?[ \t]*(?:#.*)?)$`, regexp.QuoteMeta(rewrite.Field+":"), regexp.QuoteMeta(rewrite.Current)))
+	target := strings.Join(rewrite.Path, "\n")
+	var stack []valuesPathElement
+	numItems := make(map[string]int)
+	getPath := func() string {
+		names := make([]string, len(stack))
+		for i, e := range stack {
+			names[i] = e.name
+		}
+		return strings.Join(names, "\n")
+	}
+	var offset int
+	for _, line := range strings.SplitAfter(string(values), "\n") {
+		lineStart := offset
+		offset += len(line)
+		content := strings.TrimRight(line, "\r\n")
+		trimmed := strings.TrimLeft(content, " ")
+		if len(trimmed) == 0 || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(content, "---") || strings.HasPrefix(content, "...") {
+			continue
+		}
+		indent := len(content) - len(trimmed)
+		if trimmed == "-" || strings.HasPrefix(trimmed, "- ") {
+			// Sequences may be indented at the same column as the key that contains them
+			for len(stack) > 0 && (stack[len(stack)-1].indent > indent || (stack[len(stack)-1].indent == indent && stack[len(stack)-1].isItem)) {
+				stack = stack[:len(stack)-1]
+			}
+		} else {
+			for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+				stack = stack[:len(stack)-1]
+			}
+		}
+		for trimmed == "-" || strings.HasPrefix(trimmed, "- ") {
+			parentPath := getPath()
+			stack = append(stack, valuesPathElement{indent: indent, name: fmt.Sprintf("[%d]", numItems[parentPath]), isItem: true})
+			numItems[parentPath]++
+			rest := strings.TrimLeft(trimmed[1:], " ")
+			indent += len(trimmed) - len(rest)
+			trimmed = rest
+		}
+		match := valuesPathKeyRegex.FindStringSubmatch(trimmed)
+		if match == nil {
+			continue
+		}
+		stack = append(stack, valuesPathElement{indent: indent, name: strings.Trim(match[1], `"'`)})
+		if getPath() != target || !fieldRegex.MatchString(trimmed) {
+			continue
+		}
+		rewritten := fieldRegex.ReplaceAllString(trimmed, "${1}"+rewrite.Mirrored+"${2}")
+		contentStart := lineStart + len(content) - len(trimmed)
+		var buf bytes.Buffer
+		buf.Write(values[:contentStart])
+		buf.WriteString(rewritten)
+		buf.Write(values[lineStart+len(content):])
+		return buf.Bytes(), true
+	}
+	return values, false
+}
+
+// mirrorImage replaces the registry of the image with the mirror, e.g. docker.io/rancher/shell:v0.1.0 becomes {mirror}/rancher/shell:v0.1.0
+// Images that already point at the mirror or are templated are returned as is
+func mirrorImage(image, mirror string) string {
+	if strings.HasPrefix(image, mirror+"/") || strings.Contains(image, "{{") {
+		return image
+	}
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		// The first component of an image is its registry if it looks like a host
+		image = parts[1]
+	}
+	return fmt.Sprintf("%s/%s", mirror, image)
+}
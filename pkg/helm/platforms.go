@@ -0,0 +1,212 @@
+package helm
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/puller"
+	"github.com/sirupsen/logrus"
+	helmLoader "helm.sh/helm/v3/pkg/chart/loader"
+	helmChartutil "helm.sh/helm/v3/pkg/chartutil"
+)
+
+const (
+	// OSAnnotation is the annotation that lists the operating systems that a chart can be installed on, e.g. linux,windows
+	OSAnnotation = "catalog.cattle.io/os"
+	// ArchAnnotation is the annotation that lists the architectures that a chart can be installed on, e.g. amd64,arm64
+	ArchAnnotation = "catalog.cattle.io/arch"
+)
+
+var (
+	// ImagePlatforms indicates that the OS and architecture annotations of exported charts should be derived from the platforms
+	// that their images are published for, and that the annotations declared by charts should be checked against them on validate
+	ImagePlatforms bool
+
+	// imagePlatforms caches the platforms of each image that has already been inspected, since charts often share images
+	imagePlatforms     = make(map[string][]string)
+	imagePlatformsLock sync.Mutex
+)
+
+// ChartPlatforms represents the operating systems and architectures that the images of a chart are published for
+type ChartPlatforms struct {
+	// OS are the operating systems that the images of the chart are published for
+	OS []string
+	// Arch are the architectures that the images of the chart are published for on any of OS
+	Arch []string
+	// Platforms are the os/arch pairs that every image published for the operating system of the pair is published for
+	Platforms []string
+}
+
+// GetChartPlatforms returns the platforms that the images referenced in the values are published for, or nil if no images are referenced
+// Images are only expected to be published for the operating systems they run on, e.g. a chart may reference separate images for its linux and windows workloads,
+// so an operating system is supported on the architectures that every image published for that operating system is published for
+func GetChartPlatforms(values map[string]interface{}) (*ChartPlatforms, error) {
+	images := GetImagesFromValues(values)
+	if len(images) == 0 {
+		return nil, nil
+	}
+	// supported tracks the architectures that every image published for each operating system is published for
+	supported := make(map[string]map[string]bool)
+	for _, image := range images {
+		platforms, err := getImagePlatforms(image)
+		if err != nil {
+			return nil, err
+		}
+		imageSupported := make(map[string]map[string]bool)
+		for _, platform := range platforms {
+			parts := strings.SplitN(platform, "/", 2)
+			if len(parts) < 2 {
+				continue
+			}
+			if imageSupported[parts[0]] == nil {
+				imageSupported[parts[0]] = make(map[string]bool)
+			}
+			imageSupported[parts[0]][parts[1]] = true
+		}
+		for osName, archSet := range imageSupported {
+			osSupported, ok := supported[osName]
+			if !ok {
+				supported[osName] = archSet
+				continue
+			}
+			for arch := range osSupported {
+				if !archSet[arch] {
+					delete(osSupported, arch)
+				}
+			}
+		}
+	}
+	osSet, archSet, platformSet := make(map[string]bool), make(map[string]bool), make(map[string]bool)
+	for osName, osArchSet := range supported {
+		for arch := range osArchSet {
+			osSet[osName] = true
+			archSet[arch] = true
+			platformSet[osName+"/"+arch] = true
+		}
+	}
+	return &ChartPlatforms{
+		OS:        sortedSet(osSet),
+		Arch:      sortedSet(archSet),
+		Platforms: sortedSet(platformSet),
+	}, nil
+}
+
+// getImagePlatforms returns the platforms that the image is published for, inspecting the registry only the first time it is called for the image
+func getImagePlatforms(image string) ([]string, error) {
+	imagePlatformsLock.Lock()
+	platforms, ok := imagePlatforms[image]
+	imagePlatformsLock.Unlock()
+	if ok {
+		return platforms, nil
+	}
+	platforms, err := puller.GetImagePlatforms(image)
+	if err != nil {
+		return nil, err
+	}
+	imagePlatformsLock.Lock()
+	imagePlatforms[image] = platforms
+	imagePlatformsLock.Unlock()
+	return platforms, nil
+}
+
+// CheckImagePlatforms returns the operating systems and architectures declared in the annotations of the chart at helmChartPath
+// that are not supported by every image referenced in its values.yaml
+func CheckImagePlatforms(fs billy.Filesystem, helmChartPath string) ([]string, error) {
+	chartMetadata, err := helmChartutil.LoadChartfile(filesystem.GetAbsPath(fs, filepath.Join(helmChartPath, helmChartutil.ChartfileName)))
+	if err != nil {
+		return nil, fmt.Errorf("Could not load %s in %s: %s", helmChartutil.ChartfileName, helmChartPath, err)
+	}
+	if len(chartMetadata.Annotations[OSAnnotation]) == 0 && len(chartMetadata.Annotations[ArchAnnotation]) == 0 {
+		return nil, nil
+	}
+	values, err := ReadValues(fs, helmChartPath)
+	if err != nil {
+		return nil, err
+	}
+	platforms, err := GetChartPlatforms(values)
+	if err != nil {
+		return nil, err
+	}
+	if platforms == nil {
+		return nil, nil
+	}
+	return getPlatformMismatches(chartMetadata.Annotations, platforms), nil
+}
+
+// getPlatformMismatches returns a description of each operating system and architecture declared in the annotations that is not in platforms
+func getPlatformMismatches(annotations map[string]string, platforms *ChartPlatforms) []string {
+	var mismatches []string
+	for _, declared := range []struct {
+		annotation string
+		supported  []string
+	}{{OSAnnotation, platforms.OS}, {ArchAnnotation, platforms.Arch}} {
+		for _, value := range getUnsupported(annotations[declared.annotation], declared.supported) {
+			mismatches = append(mismatches, fmt.Sprintf("%s declares %s but the images of the chart are only published for %s", declared.annotation, value, strings.Join(declared.supported, ",")))
+		}
+	}
+	return mismatches
+}
+
+// getUnsupported returns the values within the comma-separated list that are not supported
+func getUnsupported(list string, supported []string) []string {
+	supportedSet := make(map[string]bool, len(supported))
+	for _, s := range supported {
+		supportedSet[s] = true
+	}
+	var unsupported []string
+	for _, value := range strings.Split(list, ",") {
+		value = strings.TrimSpace(value)
+		if len(value) > 0 && !supportedSet[value] {
+			unsupported = append(unsupported, value)
+		}
+	}
+	return unsupported
+}
+
+// addPlatformAnnotations adds the OS and architecture annotations derived from the images of the chart archive at absTgzPath to it
+// Annotations that the chart already declares are kept, but a warning is logged if they are not supported by its images
+func addPlatformAnnotations(absTgzPath string) error {
+	chart, err := helmLoader.Load(absTgzPath)
+	if err != nil {
+		return fmt.Errorf("Could not load Helm chart archive %s: %s", absTgzPath, err)
+	}
+	platforms, err := GetChartPlatforms(chart.Values)
+	if err != nil {
+		return fmt.Errorf("Could not get the platforms of the images of %s: %s", filepath.Base(absTgzPath), err)
+	}
+	if platforms == nil {
+		return nil
+	}
+	if chart.Metadata.Annotations == nil {
+		chart.Metadata.Annotations = make(map[string]string)
+	}
+	for _, mismatch := range getPlatformMismatches(chart.Metadata.Annotations, platforms) {
+		logrus.Warnf("%s: %s", filepath.Base(absTgzPath), mismatch)
+	}
+	for annotation, supported := range map[string][]string{OSAnnotation: platforms.OS, ArchAnnotation: platforms.Arch} {
+		if _, ok := chart.Metadata.Annotations[annotation]; ok || len(supported) == 0 {
+			continue
+		}
+		chart.Metadata.Annotations[annotation] = strings.Join(supported, ",")
+	}
+	if _, err := helmChartutil.Save(chart, filepath.Dir(absTgzPath)); err != nil {
+		return fmt.Errorf("Could not save Helm chart archive %s: %s", absTgzPath, err)
+	}
+	logrus.Infof("Images of %s are published for %s", filepath.Base(absTgzPath), strings.Join(platforms.Platforms, ","))
+	return nil
+}
+
+// sortedSet returns the members of the set in lexical order
+func sortedSet(set map[string]bool) []string {
+	members := make([]string, 0, len(set))
+	for member := range set {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members
+}
@@ -0,0 +1,95 @@
+package helm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+)
+
+var (
+	// ContentPolicy represents the rules that the files of each chart must follow before it is exported
+	ContentPolicy options.ContentPolicyOptions
+)
+
+// ContentPolicyViolation represents a file within a chart that does not follow the content policy
+type ContentPolicyViolation struct {
+	// Path is the path to the file relative to the root of the chart
+	Path string
+	// Message describes the violation
+	Message string
+}
+
+func (v ContentPolicyViolation) String() string {
+	return fmt.Sprintf("%s: %s", v.Path, v.Message)
+}
+
+// CheckContentPolicy returns all violations of the content policy found within the files of the chart at helmChartPath
+// Symbolic links are not followed, since Helm would package the file that they point to
+func CheckContentPolicy(fs billy.Filesystem, helmChartPath string, policy options.ContentPolicyOptions) ([]ContentPolicyViolation, error) {
+	for _, pattern := range append(append([]string{}, policy.Allowed...), policy.Forbidden...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Content policy pattern %s is invalid: %s", pattern, err)
+		}
+	}
+	absHelmChartPath := filesystem.GetAbsPath(fs, helmChartPath)
+	var violations []ContentPolicyViolation
+	err := filepath.Walk(absHelmChartPath, func(absPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(absHelmChartPath, absPath)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		addViolation := func(message string) {
+			violations = append(violations, ContentPolicyViolation{Path: relPath, Message: message})
+		}
+		if pattern, ok := matchesAny(relPath, policy.Forbidden); ok {
+			addViolation(fmt.Sprintf("file matches forbidden pattern %s", pattern))
+		}
+		if _, ok := matchesAny(relPath, policy.Allowed); len(policy.Allowed) > 0 && !ok {
+			addViolation("file does not match any allowed pattern")
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if policy.ForbidSymlinks {
+				addViolation("symbolic links are forbidden")
+			}
+			return nil
+		}
+		if policy.MaxFileSizeKB > 0 && info.Size() > policy.MaxFileSizeKB*1024 {
+			addViolation(fmt.Sprintf("file is %d KB, which exceeds the maximum of %d KB", (info.Size()+1023)/1024, policy.MaxFileSizeKB))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, required := range policy.Required {
+		exists, err := filesystem.PathExists(fs, filepath.Join(helmChartPath, required))
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			violations = append(violations, ContentPolicyViolation{Path: required, Message: "required file is missing"})
+		}
+	}
+	return violations, nil
+}
+
+// matchesAny returns the first pattern that matches the path, if any
+func matchesAny(path string, patterns []string) (string, bool) {
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, path); matched {
+			return pattern, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,159 @@
+package helm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	"github.com/rancher/charts-build-scripts/pkg/path"
+	"github.com/sirupsen/logrus"
+	helmRepo "helm.sh/helm/v3/pkg/repo"
+)
+
+const (
+	// MovedToAnnotation is the annotation added to the Helm index served at a previous URL of the repository to indicate its current URL
+	MovedToAnnotation = "catalog.cattle.io/repository-moved-to"
+
+	// redirectPageFile is the name of the page that redirects browsers from a previous URL of the repository to its current URL
+	redirectPageFile = "index.html"
+	// redirectPageFmt is the format of the contents of redirectPageFile, given the current URL of the repository
+	redirectPageFmt = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="0; url=%[1]s">
+<link rel="canonical" href="%[1]s">
+<title>This Helm repository has moved</title>
+</head>
+<body>
+<p>This Helm repository has moved to <a href="%[1]s">%[1]s</a>. Please update your repository URL.</p>
+</body>
+</html>
+`
+)
+
+var (
+	// RepositoryURL is the current URL of the Helm repository, which the previous URLs redirect to
+	RepositoryURL string
+	// PreviousRepositoryURLs are the URLs that the Helm repository was previously served from
+	PreviousRepositoryURLs []options.HelmRepoPreviousURL
+)
+
+// GetRepositoryURL returns the current URL of the Helm repository given its configuration
+func GetRepositoryURL(helmRepoConfiguration options.HelmRepoConfiguration) string {
+	if len(helmRepoConfiguration.CNAME) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("https://%s/", strings.TrimSuffix(helmRepoConfiguration.CNAME, "/"))
+}
+
+// ValidatePreviousRepositoryURLs returns an error if a previous URL of the repository is not an absolute URL or is not redirected from a distinct directory within the repository
+func ValidatePreviousRepositoryURLs(helmRepoConfiguration options.HelmRepoConfiguration) error {
+	if len(helmRepoConfiguration.PreviousURLs) == 0 {
+		return nil
+	}
+	if len(helmRepoConfiguration.CNAME) == 0 {
+		return fmt.Errorf("A cname must be provided to redirect the previous URLs of the repository to")
+	}
+	directories := make(map[string]bool, len(helmRepoConfiguration.PreviousURLs))
+	for _, previousURL := range helmRepoConfiguration.PreviousURLs {
+		u, err := url.Parse(previousURL.URL)
+		if err != nil || !u.IsAbs() {
+			return fmt.Errorf("Previous URL %s of the repository is invalid: must be an absolute URL", previousURL.URL)
+		}
+		directory := filepath.Clean(previousURL.Directory)
+		if len(previousURL.Directory) == 0 || filepath.IsAbs(directory) || directory == "." || directory == ".." || strings.HasPrefix(directory, "../") {
+			return fmt.Errorf("Directory %s of previous URL %s is invalid: must be a path within the repository", previousURL.Directory, previousURL.URL)
+		}
+		for _, reserved := range []string{path.RepositoryAssetsDir, path.RepositoryChartsDir, path.RepositoryPackagesDir} {
+			if directory == reserved || strings.HasPrefix(directory, reserved+"/") {
+				return fmt.Errorf("Directory %s of previous URL %s is invalid: cannot be within %s", previousURL.Directory, previousURL.URL, reserved)
+			}
+		}
+		if directories[directory] {
+			return fmt.Errorf("Directory %s is used by more than one previous URL", previousURL.Directory)
+		}
+		directories[directory] = true
+	}
+	return nil
+}
+
+// writeRepositoryRedirects writes the files that redirect Helm clients and browsers from each previous URL of the repository to its current URL
+// Each directory serves a copy of the Helm index whose entries point at the chart archives under the current URL, so that clusters that still use a previous URL keep working
+func writeRepositoryRedirects(rootFs billy.Filesystem, helmIndexFile *helmRepo.IndexFile) error {
+	if len(PreviousRepositoryURLs) == 0 {
+		return nil
+	}
+	redirectIndexFile, err := getRedirectIndexFile(helmIndexFile, RepositoryURL)
+	if err != nil {
+		return err
+	}
+	for _, previousURL := range PreviousRepositoryURLs {
+		if err := rootFs.MkdirAll(previousURL.Directory, 0755); err != nil {
+			return err
+		}
+		redirectIndexPath := filepath.Join(previousURL.Directory, path.RepositoryHelmIndexFile)
+		if err := writeFile(filesystem.GetAbsPath(rootFs, redirectIndexPath), redirectIndexFile.WriteFile); err != nil {
+			return fmt.Errorf("Encountered error while trying to write Helm index redirecting %s into %s: %s", previousURL.URL, redirectIndexPath, err)
+		}
+		writePage := func(absPath string, mode os.FileMode) error {
+			return ioutil.WriteFile(absPath, []byte(fmt.Sprintf(redirectPageFmt, RepositoryURL)), mode)
+		}
+		redirectPagePath := filepath.Join(previousURL.Directory, redirectPageFile)
+		if err := writeFile(filesystem.GetAbsPath(rootFs, redirectPagePath), writePage); err != nil {
+			return fmt.Errorf("Encountered error while trying to write page redirecting %s into %s: %s", previousURL.URL, redirectPagePath, err)
+		}
+		logrus.Infof("Redirected %s to %s in %s", previousURL.URL, RepositoryURL, previousURL.Directory)
+	}
+	return nil
+}
+
+// GetRepositoryRedirectPaths returns the paths within the repository of the files that redirect from the previous URLs of the repository
+func GetRepositoryRedirectPaths() []string {
+	var redirectPaths []string
+	for _, previousURL := range PreviousRepositoryURLs {
+		redirectPaths = append(redirectPaths,
+			filepath.Join(previousURL.Directory, path.RepositoryHelmIndexFile),
+			filepath.Join(previousURL.Directory, redirectPageFile),
+		)
+	}
+	return redirectPaths
+}
+
+// getRedirectIndexFile returns a copy of the Helm index whose entries point at absolute URLs under repositoryURL and which is annotated with repositoryURL
+func getRedirectIndexFile(helmIndexFile *helmRepo.IndexFile, repositoryURL string) (*helmRepo.IndexFile, error) {
+	baseURL, err := url.Parse(repositoryURL)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse repository URL %s: %s", repositoryURL, err)
+	}
+	redirectIndexFile := helmRepo.NewIndexFile()
+	redirectIndexFile.Generated = helmIndexFile.Generated
+	redirectIndexFile.Annotations = make(map[string]string, len(helmIndexFile.Annotations)+1)
+	for annotation, value := range helmIndexFile.Annotations {
+		redirectIndexFile.Annotations[annotation] = value
+	}
+	redirectIndexFile.Annotations[MovedToAnnotation] = repositoryURL
+	for chartName, chartVersions := range helmIndexFile.Entries {
+		redirectChartVersions := make(helmRepo.ChartVersions, 0, len(chartVersions))
+		for _, chartVersion := range chartVersions {
+			redirectChartVersion := *chartVersion
+			redirectChartVersion.URLs = make([]string, 0, len(chartVersion.URLs))
+			for _, chartURL := range chartVersion.URLs {
+				u, err := url.Parse(chartURL)
+				if err != nil {
+					return nil, fmt.Errorf("Unable to parse URL %s of %s-%s: %s", chartURL, chartName, chartVersion.Version, err)
+				}
+				redirectChartVersion.URLs = append(redirectChartVersion.URLs, baseURL.ResolveReference(u).String())
+			}
+			redirectChartVersions = append(redirectChartVersions, &redirectChartVersion)
+		}
+		redirectIndexFile.Entries[chartName] = redirectChartVersions
+	}
+	return redirectIndexFile, nil
+}
@@ -0,0 +1,96 @@
+package helm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	helmLoader "helm.sh/helm/v3/pkg/chart/loader"
+	helmChartutil "helm.sh/helm/v3/pkg/chartutil"
+	helmEngine "helm.sh/helm/v3/pkg/engine"
+	helmReleaseutil "helm.sh/helm/v3/pkg/releaseutil"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	renderReleaseName      = "release-name"
+	renderReleaseNamespace = "default"
+)
+
+// RenderChart renders the templates of the chart at helmChartPath with its default values as an install against the cluster described by the profile
+// It returns an error if any template fails to render or produces a manifest that is not valid YAML
+func RenderChart(fs billy.Filesystem, helmChartPath string, profile options.RenderProfile) error {
+	caps, err := getCapabilities(profile)
+	if err != nil {
+		return err
+	}
+	rendered, err := renderChart(fs, helmChartPath, map[string]interface{}{}, renderReleaseName, renderReleaseNamespace, caps)
+	if err != nil {
+		return fmt.Errorf("Could not render Helm chart against Kubernetes %s: %s", caps.KubeVersion.Version, err)
+	}
+	templates := make([]string, 0, len(rendered))
+	for template := range rendered {
+		templates = append(templates, template)
+	}
+	sort.Strings(templates)
+	for _, template := range templates {
+		if strings.HasSuffix(template, "NOTES.txt") {
+			continue
+		}
+		for _, manifest := range helmReleaseutil.SplitManifests(rendered[template]) {
+			var obj map[string]interface{}
+			if err := yaml.Unmarshal([]byte(manifest), &obj); err != nil {
+				return fmt.Errorf("Template %s rendered an invalid manifest against Kubernetes %s: %s", template, caps.KubeVersion.Version, err)
+			}
+		}
+	}
+	return nil
+}
+
+// renderChart renders the templates of the chart at helmChartPath as an install of a release with the given name and namespace
+// The values provided are merged over the default values of the chart
+func renderChart(fs billy.Filesystem, helmChartPath string, vals map[string]interface{}, releaseName, releaseNamespace string, caps *helmChartutil.Capabilities) (map[string]string, error) {
+	chart, err := helmLoader.Load(filesystem.GetAbsPath(fs, helmChartPath))
+	if err != nil {
+		return nil, fmt.Errorf("Could not load Helm chart: %s", err)
+	}
+	if err := helmChartutil.ProcessDependencies(chart, vals); err != nil {
+		return nil, fmt.Errorf("Could not process dependencies of Helm chart: %s", err)
+	}
+	releaseOptions := helmChartutil.ReleaseOptions{
+		Name:      releaseName,
+		Namespace: releaseNamespace,
+		Revision:  1,
+		IsInstall: true,
+	}
+	values, err := helmChartutil.ToRenderValues(chart, vals, releaseOptions, caps)
+	if err != nil {
+		return nil, fmt.Errorf("Could not get values to render Helm chart: %s", err)
+	}
+	return helmEngine.Render(chart, values)
+}
+
+// getCapabilities returns the capabilities of the cluster described by the profile
+// If the profile does not list any API versions, the API versions known to Helm are used
+func getCapabilities(profile options.RenderProfile) (*helmChartutil.Capabilities, error) {
+	caps := *helmChartutil.DefaultCapabilities
+	if len(profile.KubeVersion) > 0 {
+		kubeVersion, err := semver.NewVersion(profile.KubeVersion)
+		if err != nil {
+			return nil, fmt.Errorf("Kubernetes version %s of render profile is invalid: %s", profile.KubeVersion, err)
+		}
+		caps.KubeVersion = helmChartutil.KubeVersion{
+			Version: fmt.Sprintf("v%s", kubeVersion),
+			Major:   fmt.Sprint(kubeVersion.Major()),
+			Minor:   fmt.Sprint(kubeVersion.Minor()),
+		}
+	}
+	if len(profile.APIVersions) > 0 {
+		caps.APIVersions = helmChartutil.VersionSet(profile.APIVersions)
+	}
+	return &caps, nil
+}
@@ -0,0 +1,135 @@
+package helm
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	helmChartutil "helm.sh/helm/v3/pkg/chartutil"
+)
+
+const (
+	// sourceDateEpochEnvironmentVariable is the environment variable that pins the timestamps recorded in the outputs of a build, as defined by https://reproducible-builds.org/specs/source-date-epoch/
+	sourceDateEpochEnvironmentVariable = "SOURCE_DATE_EPOCH"
+	// archiveFileMode is the mode recorded for every file within a chart archive
+	archiveFileMode = 0644
+)
+
+// getSourceDateEpoch returns the time pinned by SOURCE_DATE_EPOCH, if set
+func getSourceDateEpoch() (time.Time, bool, error) {
+	sourceDateEpoch, ok := os.LookupEnv(sourceDateEpochEnvironmentVariable)
+	if !ok || len(sourceDateEpoch) == 0 {
+		return time.Time{}, false, nil
+	}
+	seconds, err := strconv.ParseInt(sourceDateEpoch, 10, 64)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%s must be a number of seconds since the Unix epoch: %s", sourceDateEpochEnvironmentVariable, err)
+	}
+	return time.Unix(seconds, 0).UTC(), true, nil
+}
+
+// getArchiveTime returns the modification time recorded for files within a chart archive, which is pinned by SOURCE_DATE_EPOCH if set and is the Unix epoch otherwise
+func getArchiveTime() (time.Time, error) {
+	sourceDateEpoch, ok, err := getSourceDateEpoch()
+	if err != nil || ok {
+		return sourceDateEpoch, err
+	}
+	return time.Unix(0, 0).UTC(), nil
+}
+
+// getIndexTime returns the time recorded in the Helm index for entries it gains, which is pinned by SOURCE_DATE_EPOCH if set and is the current time otherwise
+func getIndexTime() (time.Time, error) {
+	sourceDateEpoch, ok, err := getSourceDateEpoch()
+	if err != nil || ok {
+		return sourceDateEpoch, err
+	}
+	return time.Now(), nil
+}
+
+// normalizeChartArchive rewrites the chart archive at absTgzPath so that it is byte-identical across builds of the same chart
+// Every file is recorded with the same mode, owner, and modification time, and files are ordered by name after the Chart.yaml of the chart
+func normalizeChartArchive(absTgzPath string) error {
+	modTime, err := getArchiveTime()
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(absTgzPath)
+	if err != nil {
+		return err
+	}
+	gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("Unable to read chart archive %s: %s", absTgzPath, err)
+	}
+	type archiveFile struct {
+		name string
+		data []byte
+	}
+	var files []archiveFile
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		h, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to read chart archive %s: %s", absTgzPath, err)
+		}
+		if h.Typeflag != tar.TypeReg && h.Typeflag != tar.TypeRegA {
+			continue
+		}
+		fileData, err := ioutil.ReadAll(tarReader)
+		if err != nil {
+			return fmt.Errorf("Unable to read %s from chart archive %s: %s", h.Name, absTgzPath, err)
+		}
+		files = append(files, archiveFile{name: h.Name, data: fileData})
+	}
+	// Helm expects the Chart.yaml of the chart to be the first file of the archive
+	isChartYaml := func(name string) bool {
+		return path.Base(name) == helmChartutil.ChartfileName && strings.Count(name, "/") == 1
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if isChartYaml(files[i].name) != isChartYaml(files[j].name) {
+			return isChartYaml(files[i].name)
+		}
+		return files[i].name < files[j].name
+	})
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	// Keep the gzip header that identifies a Helm chart archive, but not the time it was written
+	gzipWriter.Header.Name = gzipReader.Header.Name
+	gzipWriter.Header.Comment = gzipReader.Header.Comment
+	gzipWriter.Header.Extra = gzipReader.Header.Extra
+	tarWriter := tar.NewWriter(gzipWriter)
+	for _, f := range files {
+		h := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     f.name,
+			Mode:     archiveFileMode,
+			Size:     int64(len(f.data)),
+			ModTime:  modTime,
+		}
+		if err := tarWriter.WriteHeader(h); err != nil {
+			return err
+		}
+		if _, err := tarWriter.Write(f.data); err != nil {
+			return err
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(absTgzPath, buf.Bytes(), archiveFileMode)
+}
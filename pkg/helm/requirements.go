@@ -0,0 +1,62 @@
+package helm
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/sirupsen/logrus"
+	helmChart "helm.sh/helm/v3/pkg/chart"
+	helmLoader "helm.sh/helm/v3/pkg/chart/loader"
+	helmChartutil "helm.sh/helm/v3/pkg/chartutil"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// requirementsFile is the file that Helm v2 charts used to declare their dependencies
+	requirementsFile = "requirements.yaml"
+)
+
+var (
+	// EmitRequirementsYaml indicates that exported apiVersion v2 charts should also contain a requirements.yaml mirroring the dependencies in the Chart.yaml
+	// This allows legacy tooling that still parses requirements.yaml to discover the dependencies of the chart
+	EmitRequirementsYaml = false
+)
+
+// requirements represents the contents of a requirements.yaml
+type requirements struct {
+	Dependencies []*helmChart.Dependency `json:"dependencies"`
+}
+
+// addRequirementsYaml adds a requirements.yaml mirroring the dependencies in the Chart.yaml to the chart archive at absTgzPath
+func addRequirementsYaml(absTgzPath string) error {
+	chart, err := helmLoader.Load(absTgzPath)
+	if err != nil {
+		return fmt.Errorf("Could not load Helm chart archive %s: %s", absTgzPath, err)
+	}
+	if chart.Metadata.APIVersion == helmChart.APIVersionV1 || len(chart.Metadata.Dependencies) == 0 {
+		// apiVersion v1 charts already carry their own requirements.yaml
+		return nil
+	}
+	requirementsBytes, err := yaml.Marshal(requirements{Dependencies: chart.Metadata.Dependencies})
+	if err != nil {
+		return fmt.Errorf("Could not marshal %s: %s", requirementsFile, err)
+	}
+	chart.Files = append(chart.Files, &helmChart.File{Name: requirementsFile, Data: requirementsBytes})
+	if _, err := helmChartutil.Save(chart, filepath.Dir(absTgzPath)); err != nil {
+		return fmt.Errorf("Could not save Helm chart archive %s: %s", absTgzPath, err)
+	}
+	logrus.Infof("Added %s to %s", requirementsFile, filepath.Base(absTgzPath))
+	return nil
+}
+
+// IsAPIVersionV1Chart returns whether the chart at helmChartPath uses the Helm v2 style apiVersion v1
+// Charts that do not declare an apiVersion are treated as apiVersion v1, as Helm does
+func IsAPIVersionV1Chart(fs billy.Filesystem, helmChartPath string) (bool, error) {
+	chartMetadata, err := helmChartutil.LoadChartfile(filesystem.GetAbsPath(fs, filepath.Join(helmChartPath, helmChartutil.ChartfileName)))
+	if err != nil {
+		return false, fmt.Errorf("Could not load %s in %s: %s", helmChartutil.ChartfileName, helmChartPath, err)
+	}
+	return chartMetadata.APIVersion == helmChart.APIVersionV1 || len(chartMetadata.APIVersion) == 0, nil
+}
@@ -0,0 +1,146 @@
+package helm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/path"
+	"github.com/sirupsen/logrus"
+	helmLoader "helm.sh/helm/v3/pkg/chart/loader"
+	helmChartutil "helm.sh/helm/v3/pkg/chartutil"
+)
+
+const (
+	// valuesSchemaFile is the file that Helm validates the values of a chart against
+	valuesSchemaFile = "values.schema.json"
+	// valuesSchemaDraft is the version of JSON Schema that generated schemas conform to
+	valuesSchemaDraft = "http://json-schema.org/draft-07/schema#"
+)
+
+var (
+	// GenerateValuesSchema indicates that a values.schema.json should be added to exported charts that do not have one, derived from the types of their default values
+	// The schema of the chart, whether generated or provided by the chart, is merged with the schema overlay of the chart within the package, if one exists
+	GenerateValuesSchema = false
+)
+
+// getValuesSchemaOverlay returns the schema overlay of the chart at helmChartPath, which is found within the package at schemas/{helmChartPath}.json, or nil if it does not have one
+func getValuesSchemaOverlay(fs billy.Filesystem, helmChartPath string) (map[string]interface{}, error) {
+	overlayPath := filepath.Join(path.PackageValuesSchemaOverlayDir, fmt.Sprintf("%s.json", helmChartPath))
+	exists, err := filesystem.PathExists(fs, overlayPath)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, nil
+	}
+	overlayBytes, err := ioutil.ReadFile(filesystem.GetAbsPath(fs, overlayPath))
+	if err != nil {
+		return nil, err
+	}
+	var overlay map[string]interface{}
+	if err := json.Unmarshal(overlayBytes, &overlay); err != nil {
+		return nil, fmt.Errorf("Could not parse schema overlay %s: %s", overlayPath, err)
+	}
+	return overlay, nil
+}
+
+// addValuesSchema adds a values.schema.json to the chart archive at absTgzPath, generating one from its default values if it does not have one,
+// and merges the overlay into it. The default values of the chart must be valid against the resulting schema
+func addValuesSchema(absTgzPath string, overlay map[string]interface{}) error {
+	chart, err := helmLoader.Load(absTgzPath)
+	if err != nil {
+		return fmt.Errorf("Could not load Helm chart archive %s: %s", absTgzPath, err)
+	}
+	var schema map[string]interface{}
+	if len(chart.Schema) > 0 {
+		if err := json.Unmarshal(chart.Schema, &schema); err != nil {
+			return fmt.Errorf("Could not parse existing %s of %s: %s", valuesSchemaFile, filepath.Base(absTgzPath), err)
+		}
+	} else {
+		schema = getValueSchema(chart.Values)
+		schema["$schema"] = valuesSchemaDraft
+	}
+	if overlay != nil {
+		schema = mergeSchemas(schema, overlay)
+	}
+	schemaBytes, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Could not marshal %s: %s", valuesSchemaFile, err)
+	}
+	if err := helmChartutil.ValidateAgainstSingleSchema(chart.Values, schemaBytes); err != nil {
+		return fmt.Errorf("Default values of %s are not valid against its %s: %s", filepath.Base(absTgzPath), valuesSchemaFile, err)
+	}
+	chart.Schema = append(schemaBytes, '\n')
+	if _, err := helmChartutil.Save(chart, filepath.Dir(absTgzPath)); err != nil {
+		return fmt.Errorf("Could not save Helm chart archive %s: %s", absTgzPath, err)
+	}
+	logrus.Infof("Added %s to %s", valuesSchemaFile, filepath.Base(absTgzPath))
+	return nil
+}
+
+// getValueSchema returns a JSON schema that describes the type of the value and every value nested within it
+// Every value may also be null since users commonly unset values by setting them to null
+// Null and empty values are left unconstrained since they are commonly used as placeholders for values of any type
+func getValueSchema(val interface{}) map[string]interface{} {
+	valueType := getValueType(val)
+	switch v := val.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			return map[string]interface{}{}
+		}
+		properties := make(map[string]interface{}, len(v))
+		for key, inner := range v {
+			properties[key] = getValueSchema(inner)
+		}
+		return map[string]interface{}{"type": nullable(valueType), "properties": properties}
+	case []interface{}:
+		if len(v) == 0 {
+			return map[string]interface{}{}
+		}
+		schema := map[string]interface{}{"type": nullable(valueType)}
+		for _, inner := range v[1:] {
+			if getValueType(inner) != getValueType(v[0]) {
+				// The items are left unconstrained if they do not share a type
+				return schema
+			}
+		}
+		schema["items"] = getValueSchema(v[0])
+		return schema
+	case string:
+		if len(v) == 0 {
+			return map[string]interface{}{}
+		}
+		return map[string]interface{}{"type": nullable(valueType)}
+	case bool, int, int64, float64:
+		return map[string]interface{}{"type": nullable(valueType)}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// nullable returns the JSON schema types that allow a value of the provided type or null
+func nullable(valueType string) []interface{} {
+	return []interface{}{valueType, "null"}
+}
+
+// mergeSchemas merges overlay into schema, recursing into objects that exist in both and replacing any other value with that of the overlay
+func mergeSchemas(schema, overlay map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(schema))
+	for key, val := range schema {
+		merged[key] = val
+	}
+	for key, overlayVal := range overlay {
+		schemaMap, schemaIsMap := merged[key].(map[string]interface{})
+		overlayMap, overlayIsMap := overlayVal.(map[string]interface{})
+		if schemaIsMap && overlayIsMap {
+			merged[key] = mergeSchemas(schemaMap, overlayMap)
+			continue
+		}
+		merged[key] = overlayVal
+	}
+	return merged
+}
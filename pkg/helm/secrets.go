@@ -0,0 +1,283 @@
+package helm
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	helmChartutil "helm.sh/helm/v3/pkg/chartutil"
+	helmReleaseutil "helm.sh/helm/v3/pkg/releaseutil"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// SecretScanPolicyFail fails validation if any hardcoded credentials are found in the rendered manifests of a chart
+	SecretScanPolicyFail = "fail"
+	// SecretScanPolicyWarn only logs the hardcoded credentials found in the rendered manifests of a chart
+	SecretScanPolicyWarn = "warn"
+)
+
+var (
+	// credentialKeyRegex matches keys and environment variable names whose values are credentials, e.g. adminPassword or DB_PASSWORD
+	credentialKeyRegex = regexp.MustCompile(`(?i)(password|passwd|pwd|secret|token|api_?key|access_?key|secret_?key|private_?key|credentials?)$`)
+	// credentialReferenceKeyRegex matches keys that refer to credentials stored elsewhere rather than containing them, e.g. existingSecret
+	credentialReferenceKeyRegex = regexp.MustCompile(`(?i)^existing`)
+	// tokenRegexes match strings that have the format of well-known credentials, keyed by a description of the credential
+	tokenRegexes = map[string]*regexp.Regexp{
+		"a private key":         regexp.MustCompile(`-----BEGIN ([A-Z]+ )?PRIVATE KEY-----`),
+		"an AWS access key":     regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`),
+		"a Github token":        regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`),
+		"a Slack token":         regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}\b`),
+		"a JSON Web Token":      regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`),
+		"a Google API key":      regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`),
+		"a Stripe secret key":   regexp.MustCompile(`\b[rs]k_live_[0-9A-Za-z]{16,}\b`),
+		"a basic auth password": regexp.MustCompile(`://[^/\s:@]+:[^/\s@]+@`),
+	}
+	// defaultPasswords are well-known default passwords that are reported as such
+	defaultPasswords = map[string]bool{
+		"admin":         true,
+		"changeme":      true,
+		"password":      true,
+		"prom-operator": true,
+		"root":          true,
+		"secret":        true,
+		"123456":        true,
+	}
+)
+
+// HardcodedSecret represents a field within a rendered manifest that contains a hardcoded credential
+type HardcodedSecret struct {
+	// Template is the path to the template that rendered the manifest relative to the root of the chart
+	Template string
+	// Resource identifies the resource within the manifest, i.e. {kind}/{name}
+	Resource string
+	// Path is the dot-separated path to the field within the manifest
+	Path string
+	// Message describes the credential without revealing it
+	Message string
+
+	// value is the credential, used to tell hardcoded credentials apart from ones generated on every render
+	value string
+}
+
+func (s HardcodedSecret) String() string {
+	return fmt.Sprintf("%s: %s %s %s", s.Template, s.Resource, s.Path, s.Message)
+}
+
+// ValidateSecretScanPolicy returns an error if the policy is not a valid secret scan policy
+func ValidateSecretScanPolicy(policy string) error {
+	switch policy {
+	case SecretScanPolicyFail, SecretScanPolicyWarn:
+		return nil
+	default:
+		return fmt.Errorf("Secret scan policy %s is invalid: must be %s or %s", policy, SecretScanPolicyFail, SecretScanPolicyWarn)
+	}
+}
+
+// FindHardcodedSecrets renders the templates of the chart at helmChartPath with its default values and returns the hardcoded credentials found in the manifests
+// The chart is rendered twice so that credentials generated on every render, e.g. with randAlphaNum, are not reported
+// Any credential that matches one of the suppressions is not returned
+func FindHardcodedSecrets(fs billy.Filesystem, helmChartPath string, suppressions []options.SecretScanSuppression) ([]HardcodedSecret, error) {
+	var renders [2][]HardcodedSecret
+	for i := range renders {
+		rendered, err := renderChart(fs, helmChartPath, map[string]interface{}{}, renderReleaseName, renderReleaseNamespace, helmChartutil.DefaultCapabilities)
+		if err != nil {
+			return nil, fmt.Errorf("Could not render Helm chart: %s", err)
+		}
+		renders[i] = findHardcodedSecrets(rendered)
+	}
+	rerendered := make(map[HardcodedSecret]bool, len(renders[1]))
+	for _, secret := range renders[1] {
+		rerendered[secret] = true
+	}
+	var secrets []HardcodedSecret
+	for _, secret := range renders[0] {
+		if !rerendered[secret] || isSecretSuppressed(secret, suppressions) {
+			continue
+		}
+		secrets = append(secrets, secret)
+	}
+	return secrets, nil
+}
+
+// findHardcodedSecrets returns the credentials found in the rendered templates, ordered by template
+func findHardcodedSecrets(rendered map[string]string) []HardcodedSecret {
+	templates := make([]string, 0, len(rendered))
+	for template := range rendered {
+		templates = append(templates, template)
+	}
+	sort.Strings(templates)
+	var secrets []HardcodedSecret
+	for _, template := range templates {
+		if strings.HasSuffix(template, "NOTES.txt") {
+			continue
+		}
+		// Rendered templates are keyed by {chart}/templates/..., so the name of the chart is dropped
+		templatePath := template
+		if i := strings.Index(template, "/"); i >= 0 {
+			templatePath = template[i+1:]
+		}
+		manifests := helmReleaseutil.SplitManifests(rendered[template])
+		keys := make([]string, 0, len(manifests))
+		for key := range manifests {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			var obj map[string]interface{}
+			if err := yaml.Unmarshal([]byte(manifests[key]), &obj); err != nil || obj == nil {
+				// Invalid manifests are reported when rendering against render profiles
+				continue
+			}
+			kind, _ := obj["kind"].(string)
+			var name string
+			if metadata, ok := obj["metadata"].(map[string]interface{}); ok {
+				name, _ = metadata["name"].(string)
+			}
+			addSecret := func(fieldPath, value, message string) {
+				secrets = append(secrets, HardcodedSecret{
+					Template: templatePath,
+					Resource: fmt.Sprintf("%s/%s", kind, name),
+					Path:     fieldPath,
+					Message:  message,
+					value:    value,
+				})
+			}
+			if kind == "Secret" {
+				findSecretData(obj, addSecret)
+				delete(obj, "data")
+				delete(obj, "stringData")
+			}
+			findCredentials(obj, "", "", addSecret)
+		}
+	}
+	return secrets
+}
+
+// findSecretData reports every value set in the data or stringData of a Secret, since any value rendered from the default values is hardcoded
+func findSecretData(obj map[string]interface{}, addSecret func(fieldPath, value, message string)) {
+	for _, field := range []string{"data", "stringData"} {
+		data, ok := obj[field].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		keys := make([]string, 0, len(data))
+		for key := range data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value, ok := data[key].(string)
+			if !ok || len(value) == 0 {
+				continue
+			}
+			if field == "data" {
+				decoded, err := base64.StdEncoding.DecodeString(value)
+				if err != nil {
+					continue
+				}
+				value = string(decoded)
+			}
+			if len(strings.TrimSpace(value)) == 0 {
+				continue
+			}
+			addSecret(fmt.Sprintf("%s.%s", field, key), value, describeCredential(value, "contains a hardcoded value"))
+		}
+	}
+}
+
+// findCredentials reports string values under keys that hold credentials, environment variables that hold credentials, and strings that look like well-known credentials
+func findCredentials(val interface{}, key, valPath string, addSecret func(fieldPath, value, message string)) {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		// Environment variables are lists of maps with a name and a value
+		if name, ok := v["name"].(string); ok && credentialKeyRegex.MatchString(name) {
+			if value, ok := v["value"].(string); ok && len(value) > 0 {
+				addSecret(joinPath(valPath, "value"), value, describeCredential(value, fmt.Sprintf("sets %s to a hardcoded value", name)))
+				delete(v, "value")
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			childKey := k
+			if isFreeFormMap(key) {
+				// Keys of labels and annotations do not describe their values, so only the format of the values is checked
+				childKey = ""
+			}
+			findCredentials(v[k], childKey, joinPath(valPath, k), addSecret)
+		}
+	case []interface{}:
+		for i, inner := range v {
+			findCredentials(inner, key, fmt.Sprintf("%s[%d]", valPath, i), addSecret)
+		}
+	case string:
+		if len(v) == 0 {
+			return
+		}
+		if credentialKeyRegex.MatchString(key) && !credentialReferenceKeyRegex.MatchString(key) {
+			addSecret(valPath, v, describeCredential(v, "contains a hardcoded value"))
+			return
+		}
+		if description, ok := matchToken(v); ok {
+			addSecret(valPath, v, fmt.Sprintf("contains what looks like %s", description))
+		}
+	}
+}
+
+// describeCredential returns a message describing the credential, calling out well-known default passwords and credentials
+func describeCredential(value, message string) string {
+	if defaultPasswords[strings.ToLower(strings.TrimSpace(value))] {
+		return fmt.Sprintf("%s that is a well-known default password", message)
+	}
+	if description, ok := matchToken(value); ok {
+		return fmt.Sprintf("%s that looks like %s", message, description)
+	}
+	return message
+}
+
+// matchToken returns a description of the well-known credential that the value looks like, if any
+func matchToken(value string) (string, bool) {
+	descriptions := make([]string, 0, len(tokenRegexes))
+	for description := range tokenRegexes {
+		descriptions = append(descriptions, description)
+	}
+	sort.Strings(descriptions)
+	for _, description := range descriptions {
+		if tokenRegexes[description].MatchString(value) {
+			return description, true
+		}
+	}
+	return "", false
+}
+
+// joinPath appends the key to the dot-separated path
+func joinPath(valPath, key string) string {
+	if len(valPath) == 0 {
+		return key
+	}
+	return fmt.Sprintf("%s.%s", valPath, key)
+}
+
+// isSecretSuppressed returns whether the credential matches any of the suppressions
+func isSecretSuppressed(secret HardcodedSecret, suppressions []options.SecretScanSuppression) bool {
+	for _, suppression := range suppressions {
+		if suppression.Template != secret.Template {
+			continue
+		}
+		if len(suppression.Path) == 0 || secret.Path == suppression.Path {
+			return true
+		}
+		if strings.HasPrefix(secret.Path, suppression.Path+".") || strings.HasPrefix(secret.Path, suppression.Path+"[") {
+			return true
+		}
+	}
+	return false
+}
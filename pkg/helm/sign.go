@@ -0,0 +1,113 @@
+package helm
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/ssh/terminal"
+	helmProvenance "helm.sh/helm/v3/pkg/provenance"
+)
+
+const (
+	// ProvenanceFileExtension is the extension that is appended to the path of a chart archive to get the path to its provenance file
+	ProvenanceFileExtension = ".prov"
+)
+
+var (
+	// ChartSigningKey is the name of the GPG key that exported chart archives are signed with, which places a provenance file alongside each archive
+	// If empty, chart archives are not signed
+	ChartSigningKey string
+	// ChartSigningKeyring is the path to the GPG keyring that contains ChartSigningKey. If empty, the default keyring of GnuPG is used
+	ChartSigningKeyring string
+	// ChartSigningPassphraseFile is the path to a file that contains the passphrase of ChartSigningKey, or - to read it from stdin
+	// If empty and ChartSigningKey is encrypted, the passphrase is prompted for
+	ChartSigningPassphraseFile string
+
+	// chartSigner is the signer of chart archives returned by getChartSigner
+	chartSigner *helmProvenance.Signatory
+	// chartSignerErr is the error encountered by getChartSigner while loading chartSigner
+	chartSignerErr error
+	// chartSignerOnce ensures that chartSigner is only loaded once
+	chartSignerOnce sync.Once
+)
+
+// signChartArchive signs the chart archive at absTgzPath with ChartSigningKey and writes its provenance file alongside it
+// This must be the last modification made to the archive, since the provenance file records its digest
+func signChartArchive(absTgzPath string) error {
+	signer, err := getChartSigner()
+	if err != nil {
+		return fmt.Errorf("Could not load key %s to sign Helm chart archive %s with: %s", ChartSigningKey, filepath.Base(absTgzPath), err)
+	}
+	signature, err := signer.ClearSign(absTgzPath)
+	if err != nil {
+		return fmt.Errorf("Could not sign Helm chart archive %s with key %s: %s", filepath.Base(absTgzPath), ChartSigningKey, err)
+	}
+	return ioutil.WriteFile(absTgzPath+ProvenanceFileExtension, []byte(signature), 0644)
+}
+
+// getChartSigner returns the signer of chart archives, which is loaded and decrypted with the passphrase of ChartSigningKey the first time it is needed
+// The signer is kept for every later chart archive, since the passphrase can only be read from stdin or prompted for once
+func getChartSigner() (*helmProvenance.Signatory, error) {
+	chartSignerOnce.Do(func() {
+		signer, err := helmProvenance.NewFromKeyring(getChartSigningKeyring(), ChartSigningKey)
+		if err != nil {
+			chartSignerErr = err
+			return
+		}
+		passphraseFetcher := promptForPassphrase
+		if len(ChartSigningPassphraseFile) > 0 {
+			passphraseFetcher = readPassphraseFile
+		}
+		if err := signer.DecryptKey(passphraseFetcher); err != nil {
+			chartSignerErr = err
+			return
+		}
+		chartSigner = signer
+	})
+	return chartSigner, chartSignerErr
+}
+
+// readPassphraseFile returns the first line of ChartSigningPassphraseFile, or of stdin if it is -
+func readPassphraseFile(name string) ([]byte, error) {
+	passphraseFile := os.Stdin
+	if ChartSigningPassphraseFile != "-" {
+		f, err := os.Open(ChartSigningPassphraseFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		passphraseFile = f
+	}
+	passphrase, _, err := bufio.NewReader(passphraseFile).ReadLine()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the passphrase of key %q from %s: %s", name, ChartSigningPassphraseFile, err)
+	}
+	return passphrase, nil
+}
+
+// promptForPassphrase prompts for the passphrase of the key on the terminal
+func promptForPassphrase(name string) ([]byte, error) {
+	fmt.Printf("Password for key %q >  ", name)
+	passphrase, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	return passphrase, err
+}
+
+// getChartSigningKeyring returns the path to the keyring that contains ChartSigningKey, which defaults to the keyring that Helm uses to sign charts
+func getChartSigningKeyring() string {
+	if len(ChartSigningKeyring) > 0 {
+		return ChartSigningKeyring
+	}
+	if gnupgHome := os.Getenv("GNUPGHOME"); len(gnupgHome) > 0 {
+		return filepath.Join(gnupgHome, "pubring.gpg")
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".gnupg", "pubring.gpg")
+	}
+	return filepath.Join(homeDir, ".gnupg", "pubring.gpg")
+}
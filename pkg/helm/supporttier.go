@@ -0,0 +1,108 @@
+package helm
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/sirupsen/logrus"
+	helmChart "helm.sh/helm/v3/pkg/chart"
+	helmLoader "helm.sh/helm/v3/pkg/chart/loader"
+	helmChartutil "helm.sh/helm/v3/pkg/chartutil"
+)
+
+const (
+	// SupportedTier indicates that the charts in a package are maintained and supported by Rancher
+	SupportedTier = "supported"
+	// CommunityTier indicates that the charts in a package are maintained by the community
+	CommunityTier = "community"
+	// ExperimentalTier indicates that the charts in a package may change or be removed without notice
+	ExperimentalTier = "experimental"
+
+	// SupportTierAnnotation is the annotation added to exported charts to indicate their support tier
+	SupportTierAnnotation = "catalog.cattle.io/support-tier"
+	// HiddenAnnotation is the annotation that hides a chart from the Rancher catalog by default
+	HiddenAnnotation = "catalog.cattle.io/hidden"
+
+	// readmeFile is the file that the support tier banner is added to
+	readmeFile = "README.md"
+	// supportTierBannerMarker marks the start of a support tier banner so that it is only added once
+	supportTierBannerMarker = "<!-- support-tier -->"
+)
+
+var (
+	// supportTierBanners are the banners added to the top of the README.md of exported charts for each support tier
+	supportTierBanners = map[string]string{
+		SupportedTier:    "> **Support tier: supported.** This chart is maintained and supported by Rancher.",
+		CommunityTier:    "> **Support tier: community.** This chart is maintained by the community and is not covered by Rancher support.",
+		ExperimentalTier: "> **Support tier: experimental.** This chart may change or be removed without notice and should not be used in production.",
+	}
+)
+
+// ValidateSupportTier returns an error if the support tier is not supported, community, experimental, or empty
+func ValidateSupportTier(supportTier string) error {
+	if _, ok := supportTierBanners[supportTier]; len(supportTier) > 0 && !ok {
+		return fmt.Errorf("Support tier %s is invalid: must be %s, %s, or %s", supportTier, SupportedTier, CommunityTier, ExperimentalTier)
+	}
+	return nil
+}
+
+// CheckSupportTier returns the rules of the support tier that the chart at helmChartPath violates
+// Experimental charts must be hidden from the catalog by default and supported charts must belong to a package with an owner
+// The annotations are those that are added to the Chart.yaml of the chart when it is exported
+func CheckSupportTier(fs billy.Filesystem, helmChartPath, supportTier, owner string, annotations map[string]string) ([]string, error) {
+	chartMetadata, err := helmChartutil.LoadChartfile(filesystem.GetAbsPath(fs, filepath.Join(helmChartPath, helmChartutil.ChartfileName)))
+	if err != nil {
+		return nil, fmt.Errorf("Could not load %s in %s: %s", helmChartutil.ChartfileName, helmChartPath, err)
+	}
+	hidden := chartMetadata.Annotations[HiddenAnnotation]
+	if val, ok := annotations[HiddenAnnotation]; ok {
+		hidden = val
+	}
+	var violations []string
+	switch supportTier {
+	case ExperimentalTier:
+		if hidden != "true" {
+			violations = append(violations, fmt.Sprintf("%s charts must set the annotation %s: \"true\" so that they are not visible by default", ExperimentalTier, HiddenAnnotation))
+		}
+	case SupportedTier:
+		if len(owner) == 0 {
+			violations = append(violations, fmt.Sprintf("%s charts must belong to a package that declares an owner in its package.yaml", SupportedTier))
+		}
+	}
+	return violations, nil
+}
+
+// addSupportTier adds the support tier annotation and a banner at the top of the README.md to the chart archive at absTgzPath
+func addSupportTier(absTgzPath, supportTier string) error {
+	chart, err := helmLoader.Load(absTgzPath)
+	if err != nil {
+		return fmt.Errorf("Could not load Helm chart archive %s: %s", absTgzPath, err)
+	}
+	if chart.Metadata.Annotations == nil {
+		chart.Metadata.Annotations = make(map[string]string)
+	}
+	chart.Metadata.Annotations[SupportTierAnnotation] = supportTier
+	banner := fmt.Sprintf("%s\n%s\n\n", supportTierBannerMarker, supportTierBanners[supportTier])
+	var readme *helmChart.File
+	for _, f := range chart.Files {
+		if f.Name == readmeFile {
+			readme = f
+			break
+		}
+	}
+	if readme == nil {
+		readme = &helmChart.File{Name: readmeFile}
+		chart.Files = append(chart.Files, readme)
+	}
+	if !strings.HasPrefix(string(readme.Data), supportTierBannerMarker) {
+		readme.Data = append([]byte(banner), readme.Data...)
+	}
+	if _, err := helmChartutil.Save(chart, filepath.Dir(absTgzPath)); err != nil {
+		return fmt.Errorf("Could not save Helm chart archive %s: %s", absTgzPath, err)
+	}
+	logrus.Infof("Marked %s as %s", filepath.Base(absTgzPath), supportTier)
+	return nil
+}
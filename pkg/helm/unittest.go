@@ -0,0 +1,380 @@
+package helm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	helmReleaseutil "helm.sh/helm/v3/pkg/releaseutil"
+	"sigs.k8s.io/yaml"
+)
+
+// TestSuite represents a set of unit tests on the rendered templates of a chart
+type TestSuite struct {
+	// Suite is the name of the suite
+	Suite string `json:"suite"`
+	// WorkingDir is the working directory of the chart within the package that is tested. Defaults to the main chart
+	WorkingDir string `json:"workingDir,omitempty"`
+	// Templates are the templates whose rendered output is asserted on, e.g. templates/deployment.yaml. If empty, all templates are used
+	Templates []string `json:"templates,omitempty"`
+	// Tests are the unit tests within the suite
+	Tests []TestCase `json:"tests"`
+}
+
+// TestCase represents a single unit test that renders a chart with a set of values and asserts on the output
+type TestCase struct {
+	// It describes what the test verifies
+	It string `json:"it"`
+	// Set are values that are merged over the default values of the chart
+	Set map[string]interface{} `json:"set,omitempty"`
+	// Release is the release that the chart is rendered for
+	Release TestRelease `json:"release,omitempty"`
+	// KubeVersion is the Kubernetes version that the chart is rendered against
+	KubeVersion string `json:"kubeVersion,omitempty"`
+	// Asserts are the assertions on the rendered output
+	Asserts []Assertion `json:"asserts"`
+}
+
+// TestRelease represents the release that a chart is rendered for in a unit test
+type TestRelease struct {
+	// Name is the name of the release
+	Name string `json:"name,omitempty"`
+	// Namespace is the namespace of the release
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// Assertion represents a single check on the rendered output of a chart. Exactly one type of check must be provided
+type Assertion struct {
+	// Template restricts the assertion to a single template of the suite
+	Template string `json:"template,omitempty"`
+	// DocumentIndex restricts the assertion to a single document among those rendered by the templates
+	DocumentIndex *int `json:"documentIndex,omitempty"`
+
+	// Equal checks that the value at the path equals the value provided
+	Equal *PathValue `json:"equal,omitempty"`
+	// NotEqual checks that the value at the path does not equal the value provided
+	NotEqual *PathValue `json:"notEqual,omitempty"`
+	// Exists checks that the path exists
+	Exists *PathValue `json:"exists,omitempty"`
+	// NotExists checks that the path does not exist
+	NotExists *PathValue `json:"notExists,omitempty"`
+	// Contains checks that the list at the path contains the value provided
+	Contains *PathValue `json:"contains,omitempty"`
+	// MatchRegex checks that the string at the path matches the pattern provided
+	MatchRegex *PathValue `json:"matchRegex,omitempty"`
+	// IsKind checks that each document is of the kind provided
+	IsKind *string `json:"isKind,omitempty"`
+	// HasDocuments checks that the number of documents equals the count provided
+	HasDocuments *int `json:"hasDocuments,omitempty"`
+	// FailedTemplate checks that rendering fails with an error containing the message provided
+	FailedTemplate *string `json:"failedTemplate,omitempty"`
+}
+
+// PathValue represents the arguments of an assertion on the value at a path within a rendered document
+type PathValue struct {
+	// Path is the dot-separated path to the value within the document, e.g. spec.template.spec.containers[0].image
+	Path string `json:"path"`
+	// Value is the expected value
+	Value interface{} `json:"value,omitempty"`
+	// Pattern is the expected regular expression
+	Pattern string `json:"pattern,omitempty"`
+}
+
+// TestFailure represents an assertion that did not hold in a unit test
+type TestFailure struct {
+	// Suite is the name of the suite
+	Suite string
+	// Test describes the test
+	Test string
+	// Message describes why the test failed
+	Message string
+}
+
+func (f TestFailure) String() string {
+	return fmt.Sprintf("%s: %s: %s", f.Suite, f.Test, f.Message)
+}
+
+// LoadTestSuite loads the TestSuite found at the path within the filesystem
+func LoadTestSuite(fs billy.Filesystem, suitePath string) (TestSuite, error) {
+	var suite TestSuite
+	suiteBytes, err := ioutil.ReadFile(filesystem.GetAbsPath(fs, suitePath))
+	if err != nil {
+		return suite, err
+	}
+	if err := yaml.UnmarshalStrict(suiteBytes, &suite); err != nil {
+		return suite, fmt.Errorf("Unable to parse test suite %s: %s", suitePath, err)
+	}
+	if len(suite.Suite) == 0 {
+		suite.Suite = suitePath
+	}
+	return suite, nil
+}
+
+// RunTestSuite runs every test within the suite against the chart at helmChartPath and returns the failures
+func RunTestSuite(fs billy.Filesystem, helmChartPath string, suite TestSuite) ([]TestFailure, error) {
+	var failures []TestFailure
+	for _, test := range suite.Tests {
+		messages, err := runTestCase(fs, helmChartPath, suite.Templates, test)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to run test %s in suite %s: %s", test.It, suite.Suite, err)
+		}
+		for _, message := range messages {
+			failures = append(failures, TestFailure{
+				Suite:   suite.Suite,
+				Test:    test.It,
+				Message: message,
+			})
+		}
+	}
+	return failures, nil
+}
+
+// runTestCase renders the chart for the test and returns a message for each assertion that did not hold
+func runTestCase(fs billy.Filesystem, helmChartPath string, templates []string, test TestCase) ([]string, error) {
+	caps, err := getCapabilities(options.RenderProfile{KubeVersion: test.KubeVersion})
+	if err != nil {
+		return nil, err
+	}
+	releaseName, releaseNamespace := renderReleaseName, renderReleaseNamespace
+	if len(test.Release.Name) > 0 {
+		releaseName = test.Release.Name
+	}
+	if len(test.Release.Namespace) > 0 {
+		releaseNamespace = test.Release.Namespace
+	}
+	vals := test.Set
+	if vals == nil {
+		vals = map[string]interface{}{}
+	}
+	rendered, renderErr := renderChart(fs, helmChartPath, vals, releaseName, releaseNamespace, caps)
+	var messages []string
+	for i, assertion := range test.Asserts {
+		if assertion.FailedTemplate != nil {
+			if renderErr == nil {
+				messages = append(messages, fmt.Sprintf("assertion %d: expected rendering to fail with %q, but it succeeded", i, *assertion.FailedTemplate))
+			} else if !strings.Contains(renderErr.Error(), *assertion.FailedTemplate) {
+				messages = append(messages, fmt.Sprintf("assertion %d: expected rendering to fail with %q, found %s", i, *assertion.FailedTemplate, renderErr))
+			}
+			continue
+		}
+		if renderErr != nil {
+			messages = append(messages, fmt.Sprintf("assertion %d: rendering failed: %s", i, renderErr))
+			continue
+		}
+		assertionTemplates := templates
+		if len(assertion.Template) > 0 {
+			assertionTemplates = []string{assertion.Template}
+		}
+		documents, err := getDocuments(rendered, assertionTemplates)
+		if err != nil {
+			return nil, err
+		}
+		if assertion.DocumentIndex != nil {
+			if *assertion.DocumentIndex < 0 || *assertion.DocumentIndex >= len(documents) {
+				messages = append(messages, fmt.Sprintf("assertion %d: document %d does not exist, found %d documents", i, *assertion.DocumentIndex, len(documents)))
+				continue
+			}
+			documents = documents[*assertion.DocumentIndex : *assertion.DocumentIndex+1]
+		}
+		if err := checkAssertion(assertion, documents); err != nil {
+			messages = append(messages, fmt.Sprintf("assertion %d: %s", i, err))
+		}
+	}
+	return messages, nil
+}
+
+// getDocuments returns every non-empty document rendered by the templates, or by all templates if none are provided
+func getDocuments(rendered map[string]string, templates []string) ([]map[string]interface{}, error) {
+	names := make([]string, 0, len(rendered))
+	for name := range rendered {
+		// Rendered templates are keyed by the chart name followed by the path of the template within the chart
+		template := name[strings.Index(name, "/")+1:]
+		if len(templates) > 0 && !containsString(templates, template) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var documents []map[string]interface{}
+	for _, name := range names {
+		if strings.HasSuffix(name, "NOTES.txt") {
+			continue
+		}
+		manifests := helmReleaseutil.SplitManifests(rendered[name])
+		keys := make([]string, 0, len(manifests))
+		for key := range manifests {
+			keys = append(keys, key)
+		}
+		// SplitManifests keys documents as manifest-<index>, so sort them by index to retain their order
+		sort.Slice(keys, func(i, j int) bool {
+			return manifestIndex(keys[i]) < manifestIndex(keys[j])
+		})
+		for _, key := range keys {
+			var document map[string]interface{}
+			if err := yaml.Unmarshal([]byte(manifests[key]), &document); err != nil {
+				return nil, fmt.Errorf("Template %s rendered an invalid manifest: %s", name, err)
+			}
+			if document == nil {
+				continue
+			}
+			documents = append(documents, document)
+		}
+	}
+	return documents, nil
+}
+
+// checkAssertion returns an error if the assertion does not hold for any of the documents
+func checkAssertion(assertion Assertion, documents []map[string]interface{}) error {
+	if assertion.HasDocuments != nil {
+		if len(documents) != *assertion.HasDocuments {
+			return fmt.Errorf("expected %d documents, found %d", *assertion.HasDocuments, len(documents))
+		}
+		return nil
+	}
+	if len(documents) == 0 {
+		return fmt.Errorf("no documents were rendered")
+	}
+	for i, document := range documents {
+		if err := checkDocument(assertion, document); err != nil {
+			return fmt.Errorf("document %d: %s", i, err)
+		}
+	}
+	return nil
+}
+
+// checkDocument returns an error if the assertion does not hold for the document
+func checkDocument(assertion Assertion, document map[string]interface{}) error {
+	switch {
+	case assertion.IsKind != nil:
+		if document["kind"] != *assertion.IsKind {
+			return fmt.Errorf("expected kind %s, found %v", *assertion.IsKind, document["kind"])
+		}
+	case assertion.Equal != nil:
+		actual, found := getPath(document, assertion.Equal.Path)
+		if !found || !valuesEqual(actual, assertion.Equal.Value) {
+			return fmt.Errorf("expected %s to equal %v, found %v", assertion.Equal.Path, assertion.Equal.Value, actual)
+		}
+	case assertion.NotEqual != nil:
+		actual, found := getPath(document, assertion.NotEqual.Path)
+		if found && valuesEqual(actual, assertion.NotEqual.Value) {
+			return fmt.Errorf("expected %s to not equal %v", assertion.NotEqual.Path, assertion.NotEqual.Value)
+		}
+	case assertion.Exists != nil:
+		if _, found := getPath(document, assertion.Exists.Path); !found {
+			return fmt.Errorf("expected %s to exist", assertion.Exists.Path)
+		}
+	case assertion.NotExists != nil:
+		if actual, found := getPath(document, assertion.NotExists.Path); found {
+			return fmt.Errorf("expected %s to not exist, found %v", assertion.NotExists.Path, actual)
+		}
+	case assertion.Contains != nil:
+		actual, _ := getPath(document, assertion.Contains.Path)
+		list, ok := actual.([]interface{})
+		if !ok {
+			return fmt.Errorf("expected %s to be a list, found %v", assertion.Contains.Path, actual)
+		}
+		for _, item := range list {
+			if valuesEqual(item, assertion.Contains.Value) {
+				return nil
+			}
+		}
+		return fmt.Errorf("expected %s to contain %v", assertion.Contains.Path, assertion.Contains.Value)
+	case assertion.MatchRegex != nil:
+		actual, _ := getPath(document, assertion.MatchRegex.Path)
+		str, ok := actual.(string)
+		if !ok {
+			return fmt.Errorf("expected %s to be a string, found %v", assertion.MatchRegex.Path, actual)
+		}
+		matched, err := regexp.MatchString(assertion.MatchRegex.Pattern, str)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %s: %s", assertion.MatchRegex.Pattern, err)
+		}
+		if !matched {
+			return fmt.Errorf("expected %s to match %s, found %s", assertion.MatchRegex.Path, assertion.MatchRegex.Pattern, str)
+		}
+	default:
+		return fmt.Errorf("no check was provided")
+	}
+	return nil
+}
+
+// getPath returns the value at the dot-separated path within the document and whether it was found
+// Elements of lists can be referenced by index, e.g. spec.containers[0].image
+func getPath(document map[string]interface{}, docPath string) (interface{}, bool) {
+	var current interface{} = document
+	for _, part := range strings.Split(docPath, ".") {
+		key := part
+		var indices []int
+		if i := strings.Index(part, "["); i >= 0 {
+			key = part[:i]
+			for _, indexStr := range strings.Split(strings.TrimSuffix(part[i+1:], "]"), "][") {
+				index, err := strconv.Atoi(indexStr)
+				if err != nil {
+					return nil, false
+				}
+				indices = append(indices, index)
+			}
+		}
+		if len(key) > 0 {
+			m, ok := current.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if current, ok = m[key]; !ok {
+				return nil, false
+			}
+		}
+		for _, index := range indices {
+			list, ok := current.([]interface{})
+			if !ok || index < 0 || index >= len(list) {
+				return nil, false
+			}
+			current = list[index]
+		}
+	}
+	return current, true
+}
+
+// valuesEqual returns whether the rendered value equals the expected value after normalizing both through JSON
+// This ensures that numbers compare equal regardless of whether they were parsed as integers or floats
+func valuesEqual(actual, expected interface{}) bool {
+	actualBytes, err := yaml.Marshal(actual)
+	if err != nil {
+		return false
+	}
+	expectedBytes, err := yaml.Marshal(expected)
+	if err != nil {
+		return false
+	}
+	var normalizedActual, normalizedExpected interface{}
+	if err := yaml.Unmarshal(actualBytes, &normalizedActual); err != nil {
+		return false
+	}
+	if err := yaml.Unmarshal(expectedBytes, &normalizedExpected); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(normalizedActual, normalizedExpected)
+}
+
+// manifestIndex returns the index of a manifest keyed by SplitManifests
+func manifestIndex(key string) int {
+	index, _ := strconv.Atoi(strings.TrimPrefix(key, "manifest-"))
+	return index
+}
+
+// containsString returns whether the list contains the string
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
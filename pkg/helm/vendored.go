@@ -0,0 +1,48 @@
+package helm
+
+import (
+	"fmt"
+	"path/filepath"
+
+	helmChart "helm.sh/helm/v3/pkg/chart"
+)
+
+var (
+	// RequireVendoredDependencies indicates that every dependency of a chart must be vendored within its charts/ directory for the chart to be exported
+	// Charts whose dependencies are not vendored cannot be installed in air-gapped environments
+	RequireVendoredDependencies = false
+)
+
+// GetUnvendoredDependencies returns a message for every dependency declared by the chart or any of its subcharts that is not vendored,
+// either as a directory or as an archive, within the charts/ directory of the chart that declares it
+func GetUnvendoredDependencies(chart *helmChart.Chart) []string {
+	return getUnvendoredDependencies(chart, "")
+}
+
+// getUnvendoredDependencies returns a message for every dependency that is not vendored within the chart at chartPath, relative to the root chart, or any of its subcharts
+func getUnvendoredDependencies(chart *helmChart.Chart, chartPath string) []string {
+	var messages []string
+	subcharts := make(map[string]*helmChart.Chart)
+	for _, subchart := range chart.Dependencies() {
+		subcharts[subchart.Name()] = subchart
+	}
+	// apiVersion v1 charts declare their dependencies in a requirements.yaml
+	dependenciesFile := "Chart.yaml"
+	if chart.Metadata.APIVersion == helmChart.APIVersionV1 {
+		dependenciesFile = requirementsFile
+	}
+	for _, dependency := range chart.Metadata.Dependencies {
+		if _, ok := subcharts[dependency.Name]; ok {
+			continue
+		}
+		source := dependency.Repository
+		if len(source) == 0 {
+			source = "an unspecified repository"
+		}
+		messages = append(messages, fmt.Sprintf("%s: dependency %s (%s) from %s is not vendored in charts/", filepath.Join(chartPath, dependenciesFile), dependency.Name, dependency.Version, source))
+	}
+	for _, subchart := range chart.Dependencies() {
+		messages = append(messages, getUnvendoredDependencies(subchart, filepath.Join(chartPath, "charts", subchart.Name()))...)
+	}
+	return messages
+}
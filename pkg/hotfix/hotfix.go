@@ -0,0 +1,238 @@
+package hotfix
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/change"
+	"github.com/rancher/charts-build-scripts/pkg/charts"
+	"github.com/rancher/charts-build-scripts/pkg/diff"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/helm"
+	"github.com/rancher/charts-build-scripts/pkg/path"
+	"github.com/rancher/charts-build-scripts/pkg/transient"
+	"github.com/sirupsen/logrus"
+	helmChartutil "helm.sh/helm/v3/pkg/chartutil"
+	helmRepo "helm.sh/helm/v3/pkg/repo"
+)
+
+const (
+	// hotfixStagingDirPrefix is the prefix of the directory within the repository that a released chart is copied into while it is hotfixed
+	hotfixStagingDirPrefix = ".hotfix-"
+	// hotfixStagingChartDir is the directory within the staging directory that holds the chart being hotfixed
+	hotfixStagingChartDir = "chart"
+	// hotfixStagingPatchFile is the file within the staging directory that holds the patch applied to the chart
+	hotfixStagingPatchFile = "hotfix.patch"
+	// hotfixStagingValuesFile is the file within the staging directory that holds the values merged into the chart
+	hotfixStagingValuesFile = "hotfix-values.yaml"
+)
+
+var (
+	// patchVersionRegex splits a chart version into the part that precedes its patch version, its patch version, and the part that follows it,
+	// e.g. 100.0.1+up1.2.3 or 1.2.301, where the packageVersion is appended to the patch version of the upstream chart
+	patchVersionRegex = regexp.MustCompile(`^(\d+\.\d+\.)(\d+)(.*)$`)
+)
+
+// Request represents a minimal change to a released chart version that should be released as a new patch-level chart version
+type Request struct {
+	// Package is the name of the package that released the chart
+	Package string
+	// Chart is the name of the chart, which defaults to the only chart released by the package
+	Chart string
+	// Version is the released chart version that is hotfixed
+	Version string
+	// PatchFile is a path to a unified diff that is applied to the released chart, in which paths are rooted at the root of the chart after stripping their first component
+	PatchFile string
+	// ValuesFile is a path to a YAML file that is merged into the values.yaml of the released chart, in which a null value removes the key
+	ValuesFile string
+}
+
+// Hotfix applies the patch and values of the request to the released chart version found in the charts directory, without pulling its upstream or preparing its package,
+// and exports the result as the next patch-level chart version into the assets and charts directories before updating the Helm index
+// The packageVersion of the package is bumped if the next chart version it exports would otherwise reuse the hotfix version
+// It returns the chart version that was produced
+func Hotfix(repoRoot string, r Request) (string, error) {
+	if len(r.PatchFile) == 0 && len(r.ValuesFile) == 0 {
+		return "", fmt.Errorf("A patch or values file must be provided to hotfix %s/%s", r.Chart, r.Version)
+	}
+	rootFs := filesystem.GetFilesystem(repoRoot)
+	packageChartsDirpath := filepath.Join(path.RepositoryChartsDir, r.Package)
+	if len(r.Chart) == 0 {
+		chartName, err := getOnlyChart(rootFs, packageChartsDirpath)
+		if err != nil {
+			return "", err
+		}
+		r.Chart = chartName
+	}
+	releasedChartPath := helm.GetChartPath(packageChartsDirpath, r.Chart, r.Version)
+	exists, err := filesystem.PathExists(rootFs, releasedChartPath)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", fmt.Errorf("Chart version %s was not released by package %s: %s does not exist", r.Version, r.Package, releasedChartPath)
+	}
+	hotfixVersion, err := getHotfixVersion(r.Version)
+	if err != nil {
+		return "", err
+	}
+	if err := checkVersionIsNotReleased(rootFs, packageChartsDirpath, r.Chart, hotfixVersion); err != nil {
+		return "", err
+	}
+	absStagingDir, err := ioutil.TempDir(repoRoot, hotfixStagingDirPrefix)
+	if err != nil {
+		return "", fmt.Errorf("Failed to create staging directory for hotfix: %s", err)
+	}
+	if err := transient.Track(rootFs, filepath.Base(absStagingDir)); err != nil {
+		return "", err
+	}
+	defer transient.Remove(rootFs, filepath.Base(absStagingDir))
+	stagingFs := filesystem.GetFilesystem(absStagingDir)
+	if err := filesystem.CopyFromLocalPath(filesystem.GetAbsPath(rootFs, releasedChartPath), stagingFs, hotfixStagingChartDir); err != nil {
+		return "", fmt.Errorf("Encountered error while copying %s: %s", releasedChartPath, err)
+	}
+	if len(r.PatchFile) > 0 {
+		if err := copyIntoStaging(r.PatchFile, stagingFs, hotfixStagingPatchFile); err != nil {
+			return "", err
+		}
+		logrus.Infof("Applying %s to %s/%s", r.PatchFile, r.Chart, r.Version)
+		if err := diff.ApplyPatch(stagingFs, hotfixStagingPatchFile, hotfixStagingChartDir); err != nil {
+			return "", fmt.Errorf("Encountered error while applying %s: %s", r.PatchFile, err)
+		}
+	}
+	if len(r.ValuesFile) > 0 {
+		if err := copyIntoStaging(r.ValuesFile, stagingFs, hotfixStagingValuesFile); err != nil {
+			return "", err
+		}
+		logrus.Infof("Merging %s into the values of %s/%s", r.ValuesFile, r.Chart, r.Version)
+		if err := change.ApplyStructuredPatch(stagingFs, hotfixStagingValuesFile, filepath.Join(hotfixStagingChartDir, path.ChartValuesFile)); err != nil {
+			return "", fmt.Errorf("Encountered error while merging %s: %s", r.ValuesFile, err)
+		}
+	}
+	if err := setChartVersion(stagingFs, hotfixStagingChartDir, hotfixVersion); err != nil {
+		return "", err
+	}
+	// The released chart already carries the annotations and support tier of its package
+	packageAssetsDirpath := filepath.Join(path.RepositoryAssetsDir, r.Package)
+	if err := helm.ExportHelmChart(rootFs, stagingFs, hotfixStagingChartDir, "", "", nil, nil, packageAssetsDirpath, packageChartsDirpath); err != nil {
+		return "", fmt.Errorf("Encountered error while exporting hotfix %s/%s: %s", r.Chart, hotfixVersion, err)
+	}
+	if err := helm.CreateOrUpdateHelmIndex(rootFs); err != nil {
+		return "", err
+	}
+	if err := bumpPackageVersionPastHotfix(rootFs, r.Package, hotfixVersion); err != nil {
+		return "", fmt.Errorf("Encountered error while bumping the packageVersion of package %s past hotfix %s/%s: %s", r.Package, r.Chart, hotfixVersion, err)
+	}
+	return hotfixVersion, nil
+}
+
+// bumpPackageVersionPastHotfix bumps the packageVersion of the package past the one that the hotfix version ends with, if any,
+// so that the next chart version exported by the package does not reuse the hotfix version with different contents
+// e.g. hotfix 1.2.302 of 1.2.301 takes packageVersion 02, so a packageVersion of 02 or lower is bumped to 03
+func bumpPackageVersionPastHotfix(rootFs billy.Filesystem, name, hotfixVersion string) error {
+	match := patchVersionRegex.FindStringSubmatch(hotfixVersion)
+	// The packageVersion is appended to the version of the upstream chart as two or more digits, so it is only part of a patch version that ends the chart version
+	if match == nil || len(match[3]) > 0 || len(match[2]) < 3 {
+		return nil
+	}
+	hotfixPackageVersion, err := strconv.Atoi(match[2][len(match[2])-2:])
+	if err != nil {
+		return err
+	}
+	packageOpts, err := charts.LoadPackageOptions(rootFs, name)
+	if err != nil {
+		return err
+	}
+	if packageOpts.PackageVersion > hotfixPackageVersion {
+		return nil
+	}
+	logrus.Infof("Bumping packageVersion of package %s from %d to %d since hotfix %s takes packageVersion %d", name, packageOpts.PackageVersion, hotfixPackageVersion+1, hotfixVersion, hotfixPackageVersion)
+	return charts.UpdatePackageVersion(rootFs, name, func(int) int {
+		return hotfixPackageVersion + 1
+	})
+}
+
+// getOnlyChart returns the name of the only chart found in the charts directory of the package, as laid out by the chart template
+func getOnlyChart(rootFs billy.Filesystem, packageChartsDirpath string) (string, error) {
+	exportedCharts, err := helm.GetExportedCharts(rootFs, packageChartsDirpath)
+	if err != nil {
+		return "", fmt.Errorf("Unable to read released charts in %s: %s", packageChartsDirpath, err)
+	}
+	chartNameSet := make(map[string]bool)
+	var chartNames []string
+	for _, exportedChart := range exportedCharts {
+		if !chartNameSet[exportedChart.Name] {
+			chartNameSet[exportedChart.Name] = true
+			chartNames = append(chartNames, exportedChart.Name)
+		}
+	}
+	if len(chartNames) != 1 {
+		sort.Strings(chartNames)
+		return "", fmt.Errorf("Found %d charts in %s, so a chart must be provided: %v", len(chartNames), packageChartsDirpath, chartNames)
+	}
+	return chartNames[0], nil
+}
+
+// getHotfixVersion returns the patch-level chart version that follows the released version, keeping any leading zeros of its patch version
+// e.g. 100.0.1+up1.2.3 becomes 100.0.2+up1.2.3 and 1.2.301 becomes 1.2.302
+func getHotfixVersion(version string) (string, error) {
+	match := patchVersionRegex.FindStringSubmatch(version)
+	if match == nil {
+		return "", fmt.Errorf("Chart version %s does not have a patch version", version)
+	}
+	patch, err := strconv.Atoi(match[2])
+	if err != nil {
+		return "", fmt.Errorf("Chart version %s has an invalid patch version: %s", version, err)
+	}
+	return fmt.Sprintf("%s%0*d%s", match[1], len(match[2]), patch+1, match[3]), nil
+}
+
+// checkVersionIsNotReleased returns an error if the chart version already exists in the charts directory of the package or in the Helm index
+func checkVersionIsNotReleased(rootFs billy.Filesystem, packageChartsDirpath, chartName, version string) error {
+	chartPath := helm.GetChartPath(packageChartsDirpath, chartName, version)
+	exists, err := filesystem.PathExists(rootFs, chartPath)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("Chart version %s already exists at %s, so the hotfix must be based on a later chart version", version, chartPath)
+	}
+	exists, err = filesystem.PathExists(rootFs, path.RepositoryHelmIndexFile)
+	if err != nil || !exists {
+		return err
+	}
+	helmIndexFile, err := helmRepo.LoadIndexFile(filesystem.GetAbsPath(rootFs, path.RepositoryHelmIndexFile))
+	if err != nil {
+		return fmt.Errorf("Encountered error while trying to load existing index file: %s", err)
+	}
+	if helmIndexFile.Has(chartName, version) {
+		return fmt.Errorf("Chart version %s already exists in %s, so the hotfix must be based on a later chart version", version, path.RepositoryHelmIndexFile)
+	}
+	return nil
+}
+
+// copyIntoStaging copies the file at srcPath, which is either absolute or relative to the current working directory, into dstPath within the staging filesystem
+func copyIntoStaging(srcPath string, stagingFs billy.Filesystem, dstPath string) error {
+	data, err := ioutil.ReadFile(srcPath)
+	if err != nil {
+		return fmt.Errorf("Unable to read %s: %s", srcPath, err)
+	}
+	return ioutil.WriteFile(filesystem.GetAbsPath(stagingFs, dstPath), data, 0644)
+}
+
+// setChartVersion sets the version in the Chart.yaml of the chart at helmChartPath
+func setChartVersion(fs billy.Filesystem, helmChartPath, version string) error {
+	chartYamlPath := filepath.Join(helmChartPath, "Chart.yaml")
+	absChartYamlPath := filesystem.GetAbsPath(fs, chartYamlPath)
+	metadata, err := helmChartutil.LoadChartfile(absChartYamlPath)
+	if err != nil {
+		return fmt.Errorf("Unable to load %s: %s", chartYamlPath, err)
+	}
+	metadata.Version = version
+	return helmChartutil.SaveChartfile(absChartYamlPath, metadata)
+}
@@ -0,0 +1,128 @@
+package journal
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/rancher/charts-build-scripts/pkg/path"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// bundleJournalDir is the directory within a support bundle that holds the journal files and logs of each run
+	bundleJournalDir = "journal"
+)
+
+// CreateSupportBundle writes a gzipped tarball to output that contains the journal files and logs of the most recent runs in journalDir,
+// along with sanitized copies of the configuration of the repository at repoRoot, its aggregated package options, and the package.yaml of each of its packages
+// The run that is creating the bundle is not included
+func CreateSupportBundle(repoRoot, journalDir, configurationFile, output string, runs int) error {
+	ids, err := listRuns(journalDir)
+	if err != nil {
+		return err
+	}
+	currentID := CurrentID()
+	var includedIDs []string
+	for i := len(ids) - 1; i >= 0 && len(includedIDs) < runs; i-- {
+		if ids[i] != currentID {
+			includedIDs = append(includedIDs, ids[i])
+		}
+	}
+	files := make(map[string][]byte)
+	for _, id := range includedIDs {
+		for _, ext := range []string{journalExt, logExt} {
+			data, err := ioutil.ReadFile(filepath.Join(journalDir, id+ext))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			files[filepath.Join(bundleJournalDir, id+ext)] = data
+		}
+	}
+	configPaths := []string{configurationFile, filepath.Join(path.RepositoryPackagesDir, path.AggregatedPackageOptionsFile)}
+	packageOptionsPaths, err := filepath.Glob(filepath.Join(repoRoot, path.RepositoryPackagesDir, "*", path.PackageOptionsFile))
+	if err != nil {
+		return err
+	}
+	for _, packageOptionsPath := range packageOptionsPaths {
+		relPath, err := filepath.Rel(repoRoot, packageOptionsPath)
+		if err != nil {
+			return err
+		}
+		configPaths = append(configPaths, relPath)
+	}
+	var configCount int
+	for _, configPath := range configPaths {
+		absConfigPath := configPath
+		if !filepath.IsAbs(configPath) {
+			absConfigPath = filepath.Join(repoRoot, configPath)
+		} else {
+			configPath = filepath.Base(configPath)
+		}
+		data, err := ioutil.ReadFile(absConfigPath)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		sanitized, err := SanitizeYAML(data)
+		if err != nil {
+			logrus.Warnf("Leaving %s out of the support bundle since it could not be sanitized: %s", configPath, err)
+			continue
+		}
+		files[configPath] = sanitized
+		configCount++
+	}
+	if err := writeTarball(output, files); err != nil {
+		return fmt.Errorf("Unable to write support bundle %s: %s", output, err)
+	}
+	logrus.Infof("Wrote support bundle with %d runs and %d configuration files to %s", len(includedIDs), configCount, output)
+	return nil
+}
+
+// writeTarball writes the files to a gzipped tarball at output, in order of their names
+func writeTarball(output string, files map[string][]byte) error {
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gzipWriter := gzip.NewWriter(f)
+	tarWriter := tar.NewWriter(gzipWriter)
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	now := time.Now()
+	for _, name := range names {
+		header := &tar.Header{
+			Name:    filepath.ToSlash(name),
+			Mode:    0644,
+			Size:    int64(len(files[name])),
+			ModTime: now,
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tarWriter.Write(files[name]); err != nil {
+			return err
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
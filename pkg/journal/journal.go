@@ -0,0 +1,240 @@
+package journal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// DefaultDirName is the name of the directory within the user's cache directory that holds the journal by default
+	DefaultDirName = "charts-build-scripts-journal"
+	// MaxRuns is the number of runs kept in the journal. The oldest runs are removed when a new run starts
+	MaxRuns = 20
+
+	// runIDTimeFormat is the format of the timestamp that identifies each run, which sorts in the order the runs started
+	runIDTimeFormat = "20060102-150405.000"
+	// journalExt is the extension of the file that records the inputs and outcomes of a run
+	journalExt = ".yaml"
+	// logExt is the extension of the file that records the logs of a run
+	logExt = ".log"
+)
+
+var (
+	current    *Run
+	currentDir string
+	currentID  string
+	logFile    *os.File
+	fatalError string
+	lock       sync.Mutex
+)
+
+// Run represents the inputs and outcomes of a single run of the charts scripts
+type Run struct {
+	// Command is the command that was run
+	Command string `yaml:"command"`
+	// Args are the sanitized arguments the scripts were run with
+	Args []string `yaml:"args"`
+	// Version is the version of the charts scripts
+	Version string `yaml:"version"`
+	// Repository is the directory the scripts were run in
+	Repository string `yaml:"repository"`
+	// Commit is the commit checked out in the repository, if it is a Git repository
+	Commit string `yaml:"commit,omitempty"`
+	// Environment is the sanitized subset of environment variables that configure the scripts
+	Environment map[string]string `yaml:"environment,omitempty"`
+	// Packages are the packages that the command ran on
+	Packages []string `yaml:"packages,omitempty"`
+	// Steps are the outcomes of each step of the run
+	Steps []Step `yaml:"steps,omitempty"`
+	// Started is when the run started
+	Started time.Time `yaml:"started"`
+	// Duration is how long the run took, if it has finished
+	Duration string `yaml:"duration,omitempty"`
+	// Error is the error that the run failed with, if any
+	Error string `yaml:"error,omitempty"`
+}
+
+// Step represents the outcome of a single step of a run
+type Step struct {
+	// Name describes the step
+	Name string `yaml:"name"`
+	// Started is when the step started
+	Started time.Time `yaml:"started"`
+	// Duration is how long the step took
+	Duration string `yaml:"duration"`
+	// Error is the error that the step failed with, if any
+	Error string `yaml:"error,omitempty"`
+}
+
+// DefaultDir returns the directory within the user's cache directory that holds the journal by default
+func DefaultDir() (string, error) {
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("Unable to find user cache directory: %s", err)
+	}
+	return filepath.Join(userCacheDir, DefaultDirName), nil
+}
+
+// Start records the start of the run in the journal within dir and records every log of the run along with it
+// The oldest runs are removed so that the journal never holds more than MaxRuns runs
+func Start(dir string, run Run) error {
+	lock.Lock()
+	defer lock.Unlock()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("Unable to create journal directory %s: %s", dir, err)
+	}
+	if err := pruneRuns(dir, MaxRuns-1); err != nil {
+		return err
+	}
+	run.Started = time.Now().UTC()
+	id := fmt.Sprintf("%s-%s", run.Started.Format(runIDTimeFormat), run.Command)
+	f, err := os.OpenFile(filepath.Join(dir, id+logExt), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("Unable to create journal log: %s", err)
+	}
+	current, currentDir, currentID, logFile = &run, dir, id, f
+	logrus.AddHook(logHook{})
+	return write()
+}
+
+// CurrentID returns the identifier of the run being recorded or an empty string if the journal is disabled
+func CurrentID() string {
+	lock.Lock()
+	defer lock.Unlock()
+	return currentID
+}
+
+// AddPackage records that the command ran on the package
+func AddPackage(name string) {
+	lock.Lock()
+	defer lock.Unlock()
+	if current == nil {
+		return
+	}
+	for _, p := range current.Packages {
+		if p == name {
+			return
+		}
+	}
+	current.Packages = append(current.Packages, name)
+}
+
+// RecordStep records the outcome of a step that started at the provided time
+func RecordStep(name string, started time.Time, err error) {
+	lock.Lock()
+	defer lock.Unlock()
+	if current == nil {
+		return
+	}
+	step := Step{
+		Name:     name,
+		Started:  started.UTC(),
+		Duration: time.Since(started).Round(time.Millisecond).String(),
+	}
+	if err != nil {
+		step.Error = err.Error()
+	}
+	current.Steps = append(current.Steps, step)
+	if err := write(); err != nil {
+		// Logging would wait on the lock held by this step, since every log is also written to the journal
+		fmt.Fprintf(os.Stderr, "Unable to update journal: %s\n", err)
+	}
+}
+
+// Finish records the outcome of the run. If err is nil, the run is recorded as failed if a fatal error was logged
+func Finish(err error) {
+	lock.Lock()
+	defer lock.Unlock()
+	if current == nil {
+		return
+	}
+	current.Duration = time.Since(current.Started).Round(time.Millisecond).String()
+	if err != nil {
+		current.Error = err.Error()
+	} else if len(fatalError) > 0 {
+		current.Error = fatalError
+	}
+	if err := write(); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to update journal: %s\n", err)
+	}
+	logFile.Close()
+	current, logFile = nil, nil
+}
+
+// write writes the run being recorded to its journal file
+func write() error {
+	runBytes, err := yaml.Marshal(current)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(currentDir, currentID+journalExt), runBytes, 0644)
+}
+
+// listRuns returns the identifiers of the runs recorded in the journal within dir, from the oldest to the newest
+func listRuns(dir string) ([]string, error) {
+	fileInfos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read journal directory %s: %s", dir, err)
+	}
+	var ids []string
+	for _, fileInfo := range fileInfos {
+		if !fileInfo.IsDir() && filepath.Ext(fileInfo.Name()) == journalExt {
+			ids = append(ids, strings.TrimSuffix(fileInfo.Name(), journalExt))
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// pruneRuns removes the oldest runs from the journal within dir until at most keep runs remain
+func pruneRuns(dir string, keep int) error {
+	ids, err := listRuns(dir)
+	if err != nil {
+		return err
+	}
+	for len(ids) > keep {
+		for _, ext := range []string{journalExt, logExt} {
+			if err := os.Remove(filepath.Join(dir, ids[0]+ext)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("Unable to remove old run %s from journal: %s", ids[0], err)
+			}
+		}
+		ids = ids[1:]
+	}
+	return nil
+}
+
+// logHook writes every log of the run to its journal log and keeps track of the fatal error that ends the run, if any
+type logHook struct{}
+
+func (logHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (logHook) Fire(entry *logrus.Entry) error {
+	lock.Lock()
+	defer lock.Unlock()
+	if logFile == nil {
+		return nil
+	}
+	if entry.Level == logrus.FatalLevel {
+		fatalError = entry.Message
+	}
+	line, err := entry.String()
+	if err != nil {
+		return err
+	}
+	_, err = logFile.WriteString(SanitizeText(line))
+	return err
+}
@@ -0,0 +1,153 @@
+package lifecycle
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/charts"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/helm"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	"github.com/rancher/charts-build-scripts/pkg/path"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// archivedAtFormat is the format of the date on which a package was archived
+	archivedAtFormat = "2006-01-02"
+)
+
+// ArchiveRecord represents the decision to archive a package, which is recorded within the archived package
+type ArchiveRecord struct {
+	// Package is the name of the archived package
+	Package string `yaml:"package"`
+	// ArchivedAt is the date on which the package was archived, e.g. 2021-06-30
+	ArchivedAt string `yaml:"archivedAt"`
+	// Reason explains why the package was archived
+	Reason string `yaml:"reason"`
+	// PackageVersion is the packageVersion of the package at the time it was archived
+	PackageVersion int `yaml:"packageVersion"`
+	// DeprecatedVersions are the chart versions that were marked as deprecated in the Helm index when the package was archived, keyed by the name of the chart
+	DeprecatedVersions map[string][]string `yaml:"deprecatedVersions,omitempty"`
+}
+
+// ArchivePackage stops building the package by moving it from the packages directory to the archived packages directory,
+// marks every version of its charts in the Helm index as deprecated, and records the reason it was archived within the archived package
+func ArchivePackage(repoRoot, name, reason string) (*ArchiveRecord, error) {
+	if len(reason) == 0 {
+		return nil, fmt.Errorf("A reason must be provided to archive package %s", name)
+	}
+	rootFs := filesystem.GetFilesystem(repoRoot)
+	archivedPackageRoot := filepath.Join(path.RepositoryArchivedPackagesDir, name)
+	exists, err := filesystem.PathExists(rootFs, archivedPackageRoot)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, fmt.Errorf("Package %s has already been archived at %s", name, archivedPackageRoot)
+	}
+	pkg, err := charts.GetPackage(rootFs, name)
+	if err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to get package %s: %s", name, err)
+	}
+	if pkg == nil {
+		return nil, fmt.Errorf("Package %s does not exist", name)
+	}
+	if err := pkg.Clean(); err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to clean package %s: %s", name, err)
+	}
+	record := ArchiveRecord{
+		Package:        name,
+		ArchivedAt:     time.Now().UTC().Format(archivedAtFormat),
+		Reason:         reason,
+		PackageVersion: pkg.PackageVersion,
+	}
+	record.DeprecatedVersions, err = helm.DeprecateHelmIndexEntries(rootFs, filepath.Join(path.RepositoryAssetsDir, name))
+	if err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to deprecate the charts of package %s in the Helm index: %s", name, err)
+	}
+	if err := movePackage(rootFs, name, archivedPackageRoot); err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to move package %s to %s: %s", name, archivedPackageRoot, err)
+	}
+	recordBytes, err := yaml.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	recordPath := filepath.Join(archivedPackageRoot, path.PackageLifecycleFile)
+	if err := ioutil.WriteFile(filesystem.GetAbsPath(rootFs, recordPath), recordBytes, 0644); err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to write %s: %s", recordPath, err)
+	}
+	logrus.Infof("Archived package %s to %s", name, archivedPackageRoot)
+	return &record, nil
+}
+
+// movePackage moves the package into archivedPackageRoot
+// A package defined in the aggregated package options is moved out of it and into a package.yaml of its own within archivedPackageRoot
+func movePackage(rootFs billy.Filesystem, name, archivedPackageRoot string) error {
+	packageRoot := filepath.Join(path.RepositoryPackagesDir, name)
+	if err := rootFs.MkdirAll(path.RepositoryArchivedPackagesDir, 0755); err != nil {
+		return err
+	}
+	exists, err := filesystem.PathExists(rootFs, packageRoot)
+	if err != nil {
+		return err
+	}
+	if exists {
+		if err := os.Rename(filesystem.GetAbsPath(rootFs, packageRoot), filesystem.GetAbsPath(rootFs, archivedPackageRoot)); err != nil {
+			return err
+		}
+	} else if err := rootFs.MkdirAll(archivedPackageRoot, 0755); err != nil {
+		return err
+	}
+	return moveAggregatedPackageOptions(rootFs, name, archivedPackageRoot)
+}
+
+// moveAggregatedPackageOptions moves the entry of the package in the aggregated package options, if any, into a package.yaml within archivedPackageRoot
+// The remaining entries are written back in the same order, but any comments within the aggregated package options are not preserved
+func moveAggregatedPackageOptions(rootFs billy.Filesystem, name, archivedPackageRoot string) error {
+	aggregatedPackageOptionsPath := filepath.Join(path.RepositoryPackagesDir, path.AggregatedPackageOptionsFile)
+	aggregatedPackageOptions, err := options.LoadAggregatedPackageOptionsFromFile(rootFs, aggregatedPackageOptionsPath)
+	if err != nil {
+		return err
+	}
+	packageOpt, ok := aggregatedPackageOptions[name]
+	if !ok {
+		return nil
+	}
+	archivedPackageFs, err := rootFs.Chroot(archivedPackageRoot)
+	if err != nil {
+		return err
+	}
+	if err := packageOpt.WriteToFile(archivedPackageFs, path.PackageOptionsFile); err != nil {
+		return err
+	}
+	absAggregatedPackageOptionsPath := filesystem.GetAbsPath(rootFs, aggregatedPackageOptionsPath)
+	aggregatedPackageOptionsBytes, err := ioutil.ReadFile(absAggregatedPackageOptionsPath)
+	if err != nil {
+		return err
+	}
+	var entries yaml.MapSlice
+	if err := yaml.Unmarshal(aggregatedPackageOptionsBytes, &entries); err != nil {
+		return err
+	}
+	remainingEntries := make(yaml.MapSlice, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Key != name {
+			remainingEntries = append(remainingEntries, entry)
+		}
+	}
+	if len(remainingEntries) == 0 {
+		return rootFs.Remove(aggregatedPackageOptionsPath)
+	}
+	remainingEntriesBytes, err := yaml.Marshal(remainingEntries)
+	if err != nil {
+		return err
+	}
+	logrus.Warnf("Rewrote %s without package %s; any comments within it were not preserved", aggregatedPackageOptionsPath, name)
+	return ioutil.WriteFile(absAggregatedPackageOptionsPath, remainingEntriesBytes, 0644)
+}
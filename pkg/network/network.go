@@ -0,0 +1,41 @@
+package network
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ConfigureDefaultTransport configures the default HTTP transport, which is shared by archive downloads, Git operations over HTTPS, and container image pulls
+// Requests are sent through the proxy configured by HTTPS_PROXY, HTTP_PROXY, and NO_PROXY, and servers are also trusted if their certificate is signed by a CA within the PEM-encoded caBundle, if provided
+func ConfigureDefaultTransport(caBundle string) error {
+	defaultTransport, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return fmt.Errorf("Cannot configure default HTTP transport of type %T", http.DefaultTransport)
+	}
+	defaultTransport.Proxy = http.ProxyFromEnvironment
+	if len(caBundle) == 0 {
+		return nil
+	}
+	caBundleBytes, err := ioutil.ReadFile(caBundle)
+	if err != nil {
+		return fmt.Errorf("Unable to read CA bundle %s: %s", caBundle, err)
+	}
+	rootCAs, err := x509.SystemCertPool()
+	if err != nil {
+		logrus.Warnf("Unable to load system CA certificates, only trusting CA bundle %s: %s", caBundle, err)
+		rootCAs = x509.NewCertPool()
+	}
+	if !rootCAs.AppendCertsFromPEM(caBundleBytes) {
+		return fmt.Errorf("Could not find any PEM-encoded certificates in CA bundle %s", caBundle)
+	}
+	if defaultTransport.TLSClientConfig == nil {
+		defaultTransport.TLSClientConfig = &tls.Config{}
+	}
+	defaultTransport.TLSClientConfig.RootCAs = rootCAs
+	return nil
+}
@@ -8,6 +8,21 @@ type AdditionalChartOptions struct {
 	UpstreamOptions *UpstreamOptions `yaml:"upstreamOptions,omitempty"`
 	// CRDChartOptions is any options provided on how to generate a CRD chart. It is mutually exclusive with UpstreamOptions
 	CRDChartOptions *CRDChartOptions `yaml:"crdOptions,omitempty"`
+	// MainChartDependencyOptions is any options provided on how operators can toggle this chart from the main chart
+	MainChartDependencyOptions *MainChartDependencyOptions `yaml:"mainChartDependency,omitempty"`
+}
+
+// MainChartDependencyOptions represent how an additional chart is wired into the main chart as a dependency
+type MainChartDependencyOptions struct {
+	// The path within the values of the main chart that toggles the dependency. Defaults to {chart}.enabled
+	Condition string `yaml:"condition,omitempty"`
+	// The tags that toggle the dependency along with any other dependency of the main chart sharing them
+	Tags []string `yaml:"tags,omitempty"`
+	// Whether the dependency is enabled by default, i.e. the value of the condition added to the values of the main chart
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Whether to vendor a copy of the chart into the charts directory of the main chart and declare it as a dependency toggled by the condition and tags
+	// Otherwise only the condition is added to the values of the main chart. The vendored copy is not versioned like the exported chart and is installed as part of the main chart's release
+	Vendor bool `yaml:"vendor,omitempty"`
 }
 
 // CRDChartOptions represent any options that are configurable for CRD charts
@@ -16,6 +31,43 @@ type CRDChartOptions struct {
 	TemplateDirectory string `yaml:"templateDirectory"`
 	// The directory within your templateDirectory in which CRD files should be placed
 	CRDDirectory string `yaml:"crdDirectory" default:"templates"`
+	// Whether to render every file of your templateDirectory as a Go template with Sprig functions against the metadata of the package, e.g. [[ .Version ]]
+	// Actions are delimited by [[ and ]] so that any Helm templates within your templateDirectory are kept as is
+	RenderTemplate bool `yaml:"renderTemplate,omitempty"`
 	// Whether to add a validation file to your main chart to check that CRDs exist
 	AddCRDValidationToMainChart bool `yaml:"addCRDValidationToMainChart"`
+	// Whether to keep the CRDs in the crds directory of the main chart instead of moving them into the CRD chart, for consumers that only install the main chart
+	KeepCRDsInMainChart bool `yaml:"keepCRDsInMainChart,omitempty"`
+	// Whether to add the app.kubernetes.io/managed-by label and Helm release annotations to each CRD in the CRD chart on export
+	AddManagedByMetadataToCRDs bool `yaml:"addManagedByMetadataToCRDs"`
+	// The file within packages/<package-name>/templates/ containing a Go template that is rendered against each CRD in the CRD chart on export, whose output is merged into the CRD
+	CRDTransformTemplate string `yaml:"crdTransformTemplate,omitempty"`
+	// The suffix appended to the name of the main chart to name the CRD chart, e.g. -crd or -crds. If not provided, the name in the template is used
+	NameSuffix string `yaml:"nameSuffix,omitempty"`
+	// The description of the CRD chart. If not provided, the description in the template is used
+	Description string `yaml:"description,omitempty"`
+	// Annotations to add to the Chart.yaml of the CRD chart, overriding those in the template
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+	// How the CRD chart is versioned: template to use the version in the template or mainChart to use the version and appVersion of the main chart. Defaults to template
+	VersionScheme string `yaml:"versionScheme,omitempty"`
+	// Selects the CRDs of the main chart that are moved into this CRD chart, so that CRDs can be split across several CRD charts. If not provided, all CRDs are selected
+	Selector *CRDSelectorOptions `yaml:"selector,omitempty"`
+	// Adds a Job to the CRD chart that applies its CRDs with server-side apply on install and upgrade, since Helm never upgrades CRDs
+	UpgradeJob *CRDUpgradeJobOptions `yaml:"upgradeJob,omitempty"`
+}
+
+// CRDUpgradeJobOptions represent how the Job that applies the CRDs of a CRD chart is generated
+// Its image, pull policy, node selector, tolerations, security contexts, and resources can be overridden under crdUpgradeJob in the values of the CRD chart
+type CRDUpgradeJobOptions struct {
+	// The image containing kubectl that applies the CRDs, e.g. rancher/kubectl:v1.30.2
+	Image string `yaml:"image"`
+}
+
+// CRDSelectorOptions represent which CRDs of the main chart are placed in a CRD chart
+// A file within the crds directory of the main chart is selected if it matches any of the file patterns or defines a CRD in any of the groups
+type CRDSelectorOptions struct {
+	// The API groups of the CRDs to select, e.g. monitoring.coreos.com
+	Groups []string `yaml:"groups,omitempty"`
+	// Glob patterns matched against the path of each file relative to the crds directory of the main chart, e.g. monitoring.coreos.com_*.yaml
+	Files []string `yaml:"files,omitempty"`
 }
@@ -20,12 +20,60 @@ type ChartOptions struct {
 
 // UpstreamOptions represents the options presented to users to define where the upstream Helm chart is located
 type UpstreamOptions struct {
-	// URL represents a source for your upstream (e.g. a Github repository URL or a download link for an archive)
+	// URL represents a source for your upstream (e.g. a Github repository URL, the HTTPS or SSH URL of a Git repository hosted elsewhere, a path or URL to a Git bundle, a container image prefixed by image://, a directory relative to the repository root prefixed by path://, a Helm repository URL, or a download link for a .tgz or .zip archive)
 	URL string `yaml:"url,omitempty"`
-	// Subdirectory represents a specific directory within the upstream pointed to by the URL to treat as the root
+	// Subdirectory represents a specific directory within the upstream pointed to by the URL to treat as the root. It is required for container images
 	Subdirectory *string `yaml:"subdirectory,omitempty"`
-	// Commit represents a specific commit hash to treat as the head, if the URL points to a Github repository
+	// Commit represents a specific commit hash to treat as the head, if the URL points to a Git repository or a Git bundle
 	Commit *string `yaml:"commit,omitempty"`
+	// Tag represents a specific tag to treat as the head, if the URL points to a Git repository. It cannot be provided alongside a commit
+	Tag *string `yaml:"tag,omitempty"`
+	// Protocol represents the protocol to use to clone a Git repository, either https or ssh. Defaults to https for Github repositories and to the protocol of the URL otherwise
+	Protocol *string `yaml:"protocol,omitempty"`
+	// ChartName represents the name of the chart to pull, if the URL points to a Helm repository
+	ChartName *string `yaml:"chartName,omitempty"`
+	// Version represents the version of the chart to pull, if the URL points to a Helm repository
+	Version *string `yaml:"version,omitempty"`
+	// Checksum represents the expected digest of the archive in the form sha256:<hex>, if the URL points to an archive
+	Checksum *string `yaml:"checksum,omitempty"`
+	// Include represents the top-level directories or files of the chart to keep from the upstream (e.g. crds). If empty, the entire chart is kept
+	Include []string `yaml:"include,omitempty"`
+	// Exclude represents glob patterns of directories or files to remove from the chart pulled from the upstream before any changes are applied (e.g. tests or ci). Patterns without a slash match the name of any directory or file, while others match its path relative to the chart
+	Exclude []string `yaml:"exclude,omitempty"`
+	// Overlays represents upstreams whose contents are layered on top of this upstream in order before any changes are applied, e.g. to vendor templates or dashboards from another repository
+	Overlays []OverlayOptions `yaml:"overlays,omitempty"`
+	// DependencyOverrides represents changes to the version or repository of dependencies declared in the Chart.yaml or requirements.yaml of this upstream, e.g. to pin a subchart to a patched version
+	DependencyOverrides []DependencyOverride `yaml:"dependencyOverrides,omitempty"`
+	// LocalDependencies represents subcharts of this chart that are built from charts within the repository, e.g. to assemble an umbrella chart from in-repo components
+	LocalDependencies []LocalDependency `yaml:"localDependencies,omitempty"`
+}
+
+// LocalDependency represents a subchart that is built from a chart within the repository instead of being pulled from a Helm repository
+type LocalDependency struct {
+	// Name is the name of the subchart. It is added to the dependencies of the chart with the condition {name}.enabled if the chart does not declare it already
+	Name string `yaml:"name"`
+	// Path is the directory containing the chart relative to the root of the package, e.g. subcharts/agent. It cannot be provided alongside a package
+	Path string `yaml:"path,omitempty"`
+	// Package is the package whose main chart, once prepared, is used as the subchart. It cannot be provided alongside a path
+	Package string `yaml:"package,omitempty"`
+}
+
+// DependencyOverride represents a change to the version or repository that a dependency declared by an upstream chart is pulled from
+type DependencyOverride struct {
+	// Name is the name of the dependency, as declared by the upstream chart
+	Name string `yaml:"name"`
+	// Version is the version or version constraint of the dependency to pull instead of the one declared by the upstream chart
+	Version string `yaml:"version,omitempty"`
+	// Repository is the Helm repository to pull the dependency from instead of the one declared by the upstream chart. It is required to override a dependency vendored within the upstream via file://
+	Repository string `yaml:"repository,omitempty"`
+}
+
+// OverlayOptions represents the options presented to users to define an upstream whose contents are layered on top of another upstream
+type OverlayOptions struct {
+	// UpstreamOptions is any options provided on how to get the overlay from upstream
+	UpstreamOptions `yaml:",inline"`
+	// Path represents the directory within the chart where the contents of the overlay are placed, e.g. files/dashboards. Defaults to the root of the chart
+	Path string `yaml:"path,omitempty"`
 }
 
 // LoadChartOptionsFromFile unmarshalls the struct found at the file to YAML and reads it into memory
@@ -42,7 +90,7 @@ func LoadChartOptionsFromFile(fs billy.Filesystem, path string) (ChartOptions, e
 	if err != nil {
 		return chartOptions, err
 	}
-	return chartOptions, yaml.Unmarshal(chartOptionsBytes, &chartOptions)
+	return chartOptions, unmarshal(filesystem.GetAbsPath(fs, path), chartOptionsBytes, &chartOptions)
 }
 
 // WriteToFile marshals the struct to yaml and writes it into the path specified
@@ -59,7 +107,7 @@ func (c ChartOptions) WriteToFile(fs billy.Filesystem, path string) error {
 	if !exists {
 		file, err = filesystem.CreateFileAndDirs(fs, path)
 	} else {
-		file, err = fs.OpenFile(path, os.O_RDWR, os.ModePerm)
+		file, err = fs.OpenFile(path, os.O_RDWR|os.O_TRUNC, 0644)
 	}
 	if err != nil {
 		return err
@@ -21,6 +21,72 @@ type PackageOptions struct {
 	MainChartOptions ChartOptions `yaml:",inline"`
 	// AdditionalChartOptions represent options presented to the user to configure any additional charts
 	AdditionalChartOptions []AdditionalChartOptions `yaml:"additionalCharts,omitempty"`
+	// ValuesLintSuppressions represent violations of the values lint rules that should be ignored for this package
+	ValuesLintSuppressions []ValuesLintSuppression `yaml:"valuesLintSuppressions,omitempty"`
+	// HelmLintSuppressions represent messages reported by helm lint against the charts of this package that should be ignored, e.g. known upstream warnings
+	HelmLintSuppressions []HelmLintSuppression `yaml:"helmLintSuppressions,omitempty"`
+	// SecretScanSuppressions represent hardcoded credentials found in the rendered manifests of this package that should be ignored
+	SecretScanSuppressions []SecretScanSuppression `yaml:"secretScanSuppressions,omitempty"`
+	// Owner represents the team or person responsible for maintaining this package
+	Owner string `yaml:"owner,omitempty"`
+	// SupportTier represents the level of support offered for the charts in this package: supported, community, or experimental
+	SupportTier string `yaml:"supportTier,omitempty"`
+	// Labels represent arbitrary key-value pairs that packages can be selected by on the command line, e.g. team: monitoring
+	Labels map[string]string `yaml:"labels,omitempty"`
+	// Annotations represent annotations that are added to the Chart.yaml of the main chart and every edition of it exported from this package, e.g. catalog.cattle.io/kube-version
+	// They override any annotations of the same name set by the chart
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+	// AcknowledgedAnnotationChanges represent intended changes to critical annotations of the charts in this package from the versions that precede them,
+	// keyed by the name of the annotation with the new value of the annotation, e.g. catalog.cattle.io/kube-version: '>= 1.21.0-0'. An empty value acknowledges removing the annotation
+	AcknowledgedAnnotationChanges map[string]string `yaml:"acknowledgedAnnotationChanges,omitempty"`
+	// ImageMirror represents how the images referenced by the charts in this package are rewritten to point at a mirror registry on export, e.g. for air-gapped environments
+	ImageMirror *ImageMirrorOptions `yaml:"imageMirror,omitempty"`
+	// StructuredPatches indicates that modifications to YAML files in the charts of this package should be stored as merge patches that are applied semantically
+	// instead of unified diffs, so they still apply if lines shift upstream. Comments within YAML files that are patched this way are not preserved
+	StructuredPatches bool `yaml:"structuredPatches,omitempty"`
+	// Editions represent variants of the main chart of this package, e.g. a hardened edition, that are exported as charts of their own from the same prepared chart
+	Editions []EditionOptions `yaml:"editions,omitempty"`
+}
+
+// EditionOptions represent a variant of the main chart of a package that is exported as a chart of its own, named {chart}-{edition}
+// The values of the edition are merged from editions/{edition}.yaml within the package, if it exists, in which a null value removes the key
+type EditionOptions struct {
+	// Name is the name of the edition, e.g. hardened
+	Name string `yaml:"name"`
+	// Annotations are added to the Chart.yaml of the edition on top of the annotations of the package
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// ValuesLintSuppression represents a violation of a values lint rule that should be ignored
+type ValuesLintSuppression struct {
+	// Rule is the name of the rule to suppress
+	Rule string `yaml:"rule"`
+	// Path is the dot-separated path to the key within the values.yaml to suppress the rule on, including any keys nested under it. If empty, the rule is suppressed everywhere
+	Path string `yaml:"path,omitempty"`
+}
+
+// ImageMirrorOptions represent how the images referenced by the charts in a package are rewritten to point at a mirror registry on export
+type ImageMirrorOptions struct {
+	// Registry is the prefix that replaces the registry of every image, e.g. registry.example.com/mirror
+	Registry string `yaml:"registry"`
+	// Templates indicates that images hardcoded within templates are rewritten as well as those referenced by the values.yaml
+	Templates bool `yaml:"templates,omitempty"`
+}
+
+// HelmLintSuppression represents a message reported by helm lint that should be ignored
+type HelmLintSuppression struct {
+	// File is the file within the chart that the message is about, e.g. Chart.yaml or templates/. If empty, the message is ignored for any file
+	File string `yaml:"file,omitempty"`
+	// Message is a substring of the message to ignore, e.g. icon is recommended. It is required
+	Message string `yaml:"message"`
+}
+
+// SecretScanSuppression represents a hardcoded credential found in a rendered manifest that should be ignored
+type SecretScanSuppression struct {
+	// Template is the path to the template that renders the manifest relative to the root of the chart, e.g. templates/secret.yaml
+	Template string `yaml:"template"`
+	// Path is the dot-separated path to the field within the manifest to ignore, including any fields nested under it, e.g. stringData.password. If empty, the entire template is ignored
+	Path string `yaml:"path,omitempty"`
 }
 
 // LoadPackageOptionsFromFile unmarshalls the struct found at the file to YAML and reads it into memory
@@ -37,7 +103,28 @@ func LoadPackageOptionsFromFile(fs billy.Filesystem, path string) (PackageOption
 	if err != nil {
 		return packageOptions, err
 	}
-	return packageOptions, yaml.Unmarshal(chartOptionsBytes, &packageOptions)
+	return packageOptions, unmarshal(filesystem.GetAbsPath(fs, path), chartOptionsBytes, &packageOptions)
+}
+
+// LoadAggregatedPackageOptionsFromFile unmarshalls the packages defined in the aggregated packages file, keyed by the name of each package
+// If the file does not exist, no packages are returned
+func LoadAggregatedPackageOptionsFromFile(fs billy.Filesystem, path string) (map[string]PackageOptions, error) {
+	var aggregatedPackageOptions map[string]PackageOptions
+	exists, err := filesystem.PathExists(fs, path)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return aggregatedPackageOptions, nil
+	}
+	aggregatedPackageOptionsBytes, err := ioutil.ReadFile(filesystem.GetAbsPath(fs, path))
+	if err != nil {
+		return nil, err
+	}
+	if err := unmarshal(filesystem.GetAbsPath(fs, path), aggregatedPackageOptionsBytes, &aggregatedPackageOptions); err != nil {
+		return nil, err
+	}
+	return aggregatedPackageOptions, nil
 }
 
 // WriteToFile marshals the struct to yaml and writes it into the path specified
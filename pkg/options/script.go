@@ -10,6 +10,167 @@ type ChartsScriptOptions struct {
 	HelmRepoConfiguration `yaml:"helmRepo"`
 	// Template can be 'source', 'staging', or 'live'
 	Template string `yaml:"template"`
+	// ValuesLintOptions represent the rules that the values.yaml of each prepared chart must follow
+	ValuesLintOptions ValuesLintOptions `yaml:"valuesLint,omitempty"`
+	// TemplateFunctionOptions represent the template functions that the templates of each prepared chart are permitted to call
+	TemplateFunctionOptions TemplateFunctionOptions `yaml:"templateFunctions,omitempty"`
+	// RenderProfiles represent the clusters that each chart is rendered against on validation
+	RenderProfiles []RenderProfile `yaml:"renderProfiles,omitempty"`
+	// ContentPolicyOptions represent the rules that the files of each chart must follow before it is exported
+	ContentPolicyOptions ContentPolicyOptions `yaml:"contentPolicy,omitempty"`
+	// HelmLintOptions represent how helm lint is run against each chart on validation
+	HelmLintOptions HelmLintOptions `yaml:"helmLint,omitempty"`
+	// SecretScanOptions represent how the manifests rendered from the default values of each chart are checked for hardcoded credentials on validation
+	SecretScanOptions SecretScanOptions `yaml:"secretScan,omitempty"`
+	// ManifestValidationOptions represent how the manifests rendered from the default values of each chart are validated against Kubernetes schemas on validation
+	ManifestValidationOptions ManifestValidationOptions `yaml:"manifestValidation,omitempty"`
+	// ImageListOptions represent how the images referenced by each chart are listed
+	ImageListOptions ImageListOptions `yaml:"imageList,omitempty"`
+	// HandoffOptions represent the branches compared in the handoff report, ordered from the oldest to the newest release line
+	HandoffOptions HandoffOptions `yaml:"handoff,omitempty"`
+	// BackportOptions represent the release branches that fixes to packages on this branch are back-ported to
+	BackportOptions BackportOptions `yaml:"backport,omitempty"`
+	// AttestationOptions represent the keys that the attestation of each release must be signed with
+	AttestationOptions AttestationOptions `yaml:"attestation,omitempty"`
+	// ChartAliases represent the previous names of renamed charts that should still resolve in the Helm index
+	ChartAliases []ChartAlias `yaml:"chartAliases,omitempty"`
+	// LayoutOptions represent where exported charts are placed within the assets and charts directories of each package
+	LayoutOptions LayoutOptions `yaml:"layout,omitempty"`
+	// VersionMonotonicityOptions represent the release branches whose chart versions the charts on this branch are checked against on validation
+	VersionMonotonicityOptions VersionMonotonicityOptions `yaml:"versionMonotonicity,omitempty"`
+	// GeneratedChangesLayoutOptions represent the names and nesting of the directories within each package that contain the changes made to upstream charts
+	GeneratedChangesLayoutOptions GeneratedChangesLayoutOptions `yaml:"generatedChangesLayout,omitempty"`
+}
+
+// GeneratedChangesLayoutOptions represent the names and nesting of the directories within each package that contain the changes made to upstream charts
+type GeneratedChangesLayoutOptions struct {
+	// RootDir is the name of the directory that contains the changes made to a chart. Defaults to generated-changes
+	RootDir string `yaml:"rootDir,omitempty"`
+	// AdditionalChartsDir is the name of the directory within the RootDir of the main chart that contains the changes made to each additional chart. Defaults to additional-charts
+	AdditionalChartsDir string `yaml:"additionalChartsDir,omitempty"`
+	// DependenciesDir is the name of the directory within a RootDir that contains each dependency of the chart. Defaults to dependencies
+	DependenciesDir string `yaml:"dependenciesDir,omitempty"`
+	// FlatAdditionalCharts places the changes made to each additional chart directly within {RootDir}/{AdditionalChartsDir}/{workingDir} instead of within another RootDir nested in it
+	FlatAdditionalCharts bool `yaml:"flatAdditionalCharts,omitempty"`
+}
+
+// VersionMonotonicityOptions represent the release branches that precede and succeed this branch, whose latest chart versions must not be ahead of or behind the latest chart versions on this branch respectively
+type VersionMonotonicityOptions struct {
+	// OlderBranches are the release branches for older release lines, whose latest version of each chart must not be greater than the latest version on this branch
+	OlderBranches []CompareGeneratedAssetsOptions `yaml:"olderBranches,omitempty"`
+	// NewerBranches are the release branches for newer release lines, whose latest version of each chart must not be less than the latest version on this branch
+	NewerBranches []CompareGeneratedAssetsOptions `yaml:"newerBranches,omitempty"`
+	// Policy is either greaterOrEqual, which allows a newer release line to have the same latest version of a chart as an older one, or greater, which requires it to be strictly greater. Defaults to greaterOrEqual
+	Policy string `yaml:"policy,omitempty"`
+}
+
+// LayoutOptions represent the paths of exported charts, in which {name} and {version} are replaced by the name and version of the chart
+type LayoutOptions struct {
+	// AssetTemplate is the path of each chart archive relative to assets/{package}, e.g. {name}/{name}-{version}.tgz. Defaults to {name}-{version}.tgz
+	AssetTemplate string `yaml:"assetTemplate,omitempty"`
+	// ChartTemplate is the path of each unarchived chart relative to charts/{package}, e.g. {name}-{version}. Defaults to {name}/{version}
+	ChartTemplate string `yaml:"chartTemplate,omitempty"`
+}
+
+// ChartAlias represents a previous name of a chart that should still resolve in the Helm index after the chart was renamed
+type ChartAlias struct {
+	// Name is the previous name of the chart
+	Name string `yaml:"name"`
+	// Chart is the current name of the chart
+	Chart string `yaml:"chart"`
+	// Policy is how the previous name resolves: duplicate, which adds every version of the chart to the index under the previous name as well,
+	// or redirect, which annotates the versions released under the previous name with the current name. Defaults to duplicate
+	Policy string `yaml:"policy,omitempty"`
+}
+
+// RenderProfile represents the capabilities of a cluster that a chart is rendered against
+type RenderProfile struct {
+	// KubeVersion is the Kubernetes version of the cluster, e.g. v1.20.0
+	KubeVersion string `yaml:"kubeVersion"`
+	// APIVersions are the API versions served by the cluster, e.g. networking.k8s.io/v1/Ingress. If empty, all API versions known to Helm are served
+	APIVersions []string `yaml:"apiVersions,omitempty"`
+}
+
+// ContentPolicyOptions represent the rules that the files of each chart must follow before it is exported
+// Patterns are matched against the path of each file relative to the root of the chart, e.g. crds/*.sh
+type ContentPolicyOptions struct {
+	// Allowed are patterns of the only files that may be exported. If empty, any file that is not forbidden may be exported
+	Allowed []string `yaml:"allowed,omitempty"`
+	// Forbidden are patterns of files that may never be exported
+	Forbidden []string `yaml:"forbidden,omitempty"`
+	// ForbidSymlinks forbids exporting symbolic links
+	ForbidSymlinks bool `yaml:"forbidSymlinks,omitempty"`
+	// MaxFileSizeKB forbids exporting files larger than this many kilobytes. If 0, files of any size may be exported
+	MaxFileSizeKB int64 `yaml:"maxFileSizeKB,omitempty"`
+	// Required are paths that must exist in every exported chart, e.g. LICENSE
+	Required []string `yaml:"required,omitempty"`
+}
+
+// BackportOptions represent the release branches that fixes to packages on this branch are back-ported to
+type BackportOptions struct {
+	// Branches are the local branches that a commit is replayed onto, e.g. release-v2.5
+	Branches []string `yaml:"branches,omitempty"`
+}
+
+// AttestationOptions represent the keys that the attestation of each release must be signed with
+type AttestationOptions struct {
+	// PublicKeys are the base64-encoded ed25519 public keys trusted to sign attestations. Must be provided to validate attestations
+	PublicKeys []string `yaml:"publicKeys,omitempty"`
+}
+
+// TemplateFunctionOptions represent the template functions that the templates of each prepared chart are permitted to call
+type TemplateFunctionOptions struct {
+	// Allowed are the only template functions that may be called. If empty, any function that is not forbidden may be called
+	Allowed []string `yaml:"allowed,omitempty"`
+	// Forbidden are template functions that may never be called, e.g. env or lookup
+	Forbidden []string `yaml:"forbidden,omitempty"`
+}
+
+// HelmLintOptions represent how helm lint is run against each prepared chart and every exported version of each chart
+type HelmLintOptions struct {
+	// Enabled runs helm lint on validation
+	Enabled bool `yaml:"enabled"`
+	// Strict fails the lint on warnings as well as errors
+	Strict bool `yaml:"strict,omitempty"`
+}
+
+// SecretScanOptions represent how the manifests rendered from the default values of each prepared chart are checked for hardcoded credentials
+type SecretScanOptions struct {
+	// Policy is either fail, which fails validation if any hardcoded credentials are found, or warn, which only logs them. If empty, manifests are not scanned
+	Policy string `yaml:"policy,omitempty"`
+}
+
+// ManifestValidationOptions represent how the manifests rendered from the default values of each prepared chart are validated against Kubernetes schemas
+type ManifestValidationOptions struct {
+	// KubeVersions are the Kubernetes versions whose schemas the manifests are validated against, e.g. v1.22.0. If empty, manifests are not validated
+	KubeVersions []string `yaml:"kubeVersions,omitempty"`
+	// SchemaLocation is the URL or local path of the JSON schema of each kind, where {kubeVersion}, {kind}, {group}, {version}, and {kindSuffix} are replaced
+	// for each manifest. If empty, the schemas published at github.com/yannh/kubernetes-json-schema are used
+	SchemaLocation string `yaml:"schemaLocation,omitempty"`
+}
+
+// ImageListOptions represent how the images referenced by each chart are listed
+type ImageListOptions struct {
+	// ExtraValues are values files that charts are rendered with in addition to their default values, e.g. to list the images of optional components
+	ExtraValues []ImageListValues `yaml:"extraValues,omitempty"`
+}
+
+// ImageListValues represents a values file that charts are rendered with when listing their images
+type ImageListValues struct {
+	// Chart is the name of the chart that the values file applies to. If empty, it applies to every chart
+	Chart string `yaml:"chart,omitempty"`
+	// ValuesFile is the path to the values file relative to the root of the repository
+	ValuesFile string `yaml:"valuesFile"`
+}
+
+// ValuesLintOptions represent the rules that the values.yaml of each prepared chart must follow
+type ValuesLintOptions struct {
+	// CamelCaseKeys requires every key to be camelCase, except for keys within free-form maps like labels and annotations
+	CamelCaseKeys bool `yaml:"camelCaseKeys"`
+	// SplitImages requires every image to be a map with a separate repository and tag
+	SplitImages bool `yaml:"splitImages"`
+	// DefaultResources requires every resources block to set default requests or limits
+	DefaultResources bool `yaml:"defaultResources"`
 }
 
 // SyncOptions represent any options that are configurable when exporting a chart
@@ -18,6 +179,9 @@ type SyncOptions []CompareGeneratedAssetsOptions
 // ValidateOptions represent any options that are configurable when validating a chart
 type ValidateOptions []CompareGeneratedAssetsOptions
 
+// HandoffOptions represent the branches compared in the handoff report, ordered from the oldest to the newest release line
+type HandoffOptions []CompareGeneratedAssetsOptions
+
 // CompareGeneratedAssetsOptions represent any options that are configurable when comparing the generated assets of the current branch against another branch
 type CompareGeneratedAssetsOptions struct {
 	// UpstreamOptions points to the configuration that contains the branch you want to compare against
@@ -31,4 +195,14 @@ type CompareGeneratedAssetsOptions struct {
 // HelmRepoConfiguration represents the configuration of the Helm Repository that exposes your charts
 type HelmRepoConfiguration struct {
 	CNAME string `yaml:"cname"`
+	// PreviousURLs are the URLs that the Helm repository was previously served from, which are redirected to https://{cname}
+	PreviousURLs []HelmRepoPreviousURL `yaml:"previousURLs,omitempty"`
+}
+
+// HelmRepoPreviousURL represents a URL that the Helm repository was previously served from
+type HelmRepoPreviousURL struct {
+	// URL is the previous URL of the Helm repository, e.g. https://releases.example.com/charts
+	URL string `yaml:"url"`
+	// Directory is the directory within the repository, to be served at URL, where the Helm index and page that redirect to the current URL are written
+	Directory string `yaml:"directory"`
 }
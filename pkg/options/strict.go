@@ -0,0 +1,142 @@
+package options
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	// StrictDecoding indicates that the package.yaml and dependency.yaml files must not contain unknown fields or duplicate keys
+	StrictDecoding bool
+
+	// unknownFieldRegex matches the error returned by a strict decode for a field that does not exist in the type
+	unknownFieldRegex = regexp.MustCompile(`field (\S+) not found in type (\S+)`)
+)
+
+// unmarshal decodes the YAML found in the file at path into out, strictly if StrictDecoding is set
+func unmarshal(path string, data []byte, out interface{}) error {
+	if !StrictDecoding {
+		return yaml.Unmarshal(data, out)
+	}
+	return UnmarshalStrict(path, data, out)
+}
+
+// UnmarshalStrict decodes the YAML found in the file at path into out and returns an error if it contains any unknown fields or duplicate keys
+// The error lists every problem found along with its line and, for unknown fields, the most similar valid field name
+func UnmarshalStrict(path string, data []byte, out interface{}) error {
+	err := yaml.UnmarshalStrict(data, out)
+	if err == nil {
+		return nil
+	}
+	typeErr, ok := err.(*yaml.TypeError)
+	if !ok {
+		return fmt.Errorf("Unable to parse %s: %s", path, err)
+	}
+	fieldNames := make(map[string][]string)
+	getFieldNames(reflect.TypeOf(out), fieldNames)
+	problems := make([]string, len(typeErr.Errors))
+	for i, problem := range typeErr.Errors {
+		problems[i] = problem
+		match := unknownFieldRegex.FindStringSubmatch(problem)
+		if match == nil {
+			continue
+		}
+		if suggestion := getClosestName(match[1], fieldNames[match[2]]); len(suggestion) > 0 {
+			problems[i] = fmt.Sprintf("%s (did you mean %s?)", problem, suggestion)
+		}
+	}
+	return fmt.Errorf("Invalid configuration in %s:\n  %s", path, strings.Join(problems, "\n  "))
+}
+
+// getFieldNames adds the YAML field names of t and of every struct nested within it to fieldNames, keyed by the name of each struct type
+func getFieldNames(t reflect.Type, fieldNames map[string][]string) {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return
+	}
+	if _, ok := fieldNames[t.String()]; ok {
+		return
+	}
+	fieldNames[t.String()] = nil
+	fieldNames[t.String()] = getStructFieldNames(t, fieldNames)
+}
+
+// getStructFieldNames returns the YAML field names of the struct t, including those of any inlined structs
+func getStructFieldNames(t reflect.Type, fieldNames map[string][]string) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if len(field.PkgPath) > 0 {
+			// Unexported fields are never decoded
+			continue
+		}
+		tag := strings.Split(field.Tag.Get("yaml"), ",")
+		if tag[0] == "-" {
+			continue
+		}
+		getFieldNames(field.Type, fieldNames)
+		inline := false
+		for _, flag := range tag[1:] {
+			inline = inline || flag == "inline"
+		}
+		if inline && field.Type.Kind() == reflect.Struct {
+			names = append(names, getStructFieldNames(field.Type, fieldNames)...)
+			continue
+		}
+		name := tag[0]
+		if len(name) == 0 {
+			name = strings.ToLower(field.Name)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// getClosestName returns the name that is most similar to s, if any is similar enough to be a likely typo
+func getClosestName(s string, names []string) string {
+	closest, closestDistance := "", len(s)/2+1
+	for _, name := range names {
+		if distance := getEditDistance(strings.ToLower(s), strings.ToLower(name)); distance < closestDistance {
+			closest, closestDistance = name, distance
+		}
+	}
+	return closest
+}
+
+// getEditDistance returns the Levenshtein distance between a and b
+func getEditDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+// minInt returns the smallest of the values
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
@@ -7,30 +7,61 @@ const (
 	ChartsRepositoryCurrentBranchDir = "original-assets"
 	// ChartsRepositoryUpstreamBranchDir is a directory that will be used to store the latest copy of a branch you want to sync with
 	ChartsRepositoryUpstreamBranchDir = "new-assets"
+	// ChartsRepositoryHandoffDir is a directory that will be used to store the latest copy of each branch compared in a handoff report
+	ChartsRepositoryHandoffDir = "handoff-assets"
+	// ChartsRepositoryMonotonicityDir is a directory that will be used to store the latest copy of each branch whose chart versions are checked for monotonicity
+	ChartsRepositoryMonotonicityDir = "monotonicity-assets"
 
 	// RepositoryHelmIndexFile is the file on your Staging/Live branch that contains your Helm repository index
 	RepositoryHelmIndexFile = "index.yaml"
+	// RepositoryVersionedHelmIndexFileFormat is the format of the name of a versioned copy of your Helm repository index, given a timestamp
+	RepositoryVersionedHelmIndexFileFormat = "index-%s.yaml"
+	// RepositoryLatestHelmIndexPointerFile is a file that contains the name of the latest versioned copy of your Helm repository index
+	RepositoryLatestHelmIndexPointerFile = "index-latest"
 	// RepositoryPackagesDir is a directory on your Source branch that contains the files necessary to generate your package
 	RepositoryPackagesDir = "packages"
+	// RepositoryArchivedPackagesDir is a directory on your Source branch that contains packages that are no longer built, along with a record of why each was archived
+	RepositoryArchivedPackagesDir = "archived-packages"
 	// RepositoryAssetsDir is a directory on your Staging/Live branch that contains chart archives for each version of your package
 	RepositoryAssetsDir = "assets"
+	// RepositoryAttestationsDir is a directory on your Staging/Live branch that contains a signed manifest of the assets added in each release
+	RepositoryAttestationsDir = "attestations"
 	// RepositoryChartsDir is a directory on your Staging/Live branch that contains unarchived charts for each version of your package
 	RepositoryChartsDir = "charts"
+	// RepositoryTransientArtifactsFile is a file that lists the artifacts created within the repository by a run that the run has not removed yet
+	RepositoryTransientArtifactsFile = ".transient-artifacts.yaml"
 
 	// PackageOptionsFile is the name of a file that contains information about how to prepare your package
 	// The expected structure of this file is one that can be marshalled into a PackageOptions struct
 	PackageOptionsFile = "package.yaml"
+	// AggregatedPackageOptionsFile is the name of a file within RepositoryPackagesDir that defines many packages at once, keyed by the name of each package
+	// The expected structure of each entry is one that can be marshalled into a PackageOptions struct. A package defined here may not have its own PackageOptionsFile
+	AggregatedPackageOptionsFile = "packages.yaml"
 	// PackageTemplatesDir is a directory containing templates used as additional chart options
 	PackageTemplatesDir = "templates"
+	// PackageOverlayDir is a directory containing files that are copied over the upstream of the main chart in your package before any changes are applied
+	PackageOverlayDir = "overlay"
+	// PackageValuesSchemaOverlayDir is a directory containing a JSON schema for each chart in your package, named after its working directory, that is merged into the values.schema.json of the exported chart
+	PackageValuesSchemaOverlayDir = "schemas"
+	// PackageEditionsDir is a directory containing the values of each edition of the main chart in your package, named {edition}.yaml, that are merged into the values.yaml of the edition
+	PackageEditionsDir = "editions"
+	// PackageTestsDir is a directory containing unit tests on the rendered templates of the charts in your package
+	PackageTestsDir = "tests"
+	// PackagePrepareStateFile is the name of a file that records the state of the last prepare of your package, which allows a later prepare to skip work that is already done
+	PackagePrepareStateFile = ".prepare-state.yaml"
+	// PackageBuildStateFile is the name of a file that records the state of the last build of the charts of your package, which allows a later build to skip packages that have not changed
+	PackageBuildStateFile = ".build-state.yaml"
+	// PackageLifecycleFile is the name of a file within an archived package that records when and why the package was archived
+	PackageLifecycleFile = "lifecycle.yaml"
 	// RebasePackageOptionsFile is the name of a file that contains information about how to prepare your new upstream
 	RebasePackageOptionsFile = "rebase.yaml"
 
-	// GeneratedChangesDir is a directory that contains GeneratedChanges
-	GeneratedChangesDir = "generated-changes"
-	// GeneratedChangesAdditionalChartDir is a directory that contains additionalCharts
-	GeneratedChangesAdditionalChartDir = "additional-charts"
-	// GeneratedChangesDependenciesDir is a directory that contains dependencies within GeneratedChangesDir
-	GeneratedChangesDependenciesDir = "dependencies"
+	// DefaultGeneratedChangesDir is the default name of GeneratedChangesDir
+	DefaultGeneratedChangesDir = "generated-changes"
+	// DefaultGeneratedChangesAdditionalChartDir is the default name of GeneratedChangesAdditionalChartDir
+	DefaultGeneratedChangesAdditionalChartDir = "additional-charts"
+	// DefaultGeneratedChangesDependenciesDir is the default name of GeneratedChangesDependenciesDir
+	DefaultGeneratedChangesDependenciesDir = "dependencies"
 	// GeneratedChangesExcludeDir is a directory that contains excludes within GeneratedChangesDir
 	GeneratedChangesExcludeDir = "exclude"
 	// GeneratedChangesOverlayDir is a directory that contains overlays within GeneratedChangesDir
@@ -43,6 +74,25 @@ const (
 
 	// ChartCRDDir represents the directory that we expect to contain CRDs within the chart
 	ChartCRDDir = "crds"
+	// ChartTemplatesDir represents the directory that we expect to contain templates within the chart
+	ChartTemplatesDir = "templates"
+	// ChartValuesFile represents the file that we expect to contain the default values within the chart
+	ChartValuesFile = "values.yaml"
 	// ChartValidateInstallCRDFile is the path to the file pushed to upstream that validates the existence of CRDs in the chart
 	ChartValidateInstallCRDFile = "templates/validate-install-crd.yaml"
+	// ChartCRDUpgradeJobFile is the path to the file added to CRD charts that applies their CRDs with a Job on install and upgrade
+	ChartCRDUpgradeJobFile = "templates/crd-upgrade-job.yaml"
+)
+
+// The names of the directories that contain GeneratedChanges can be configured by the generatedChangesLayout of the configuration.yaml
+var (
+	// GeneratedChangesDir is a directory that contains GeneratedChanges
+	GeneratedChangesDir = DefaultGeneratedChangesDir
+	// GeneratedChangesAdditionalChartDir is a directory that contains additionalCharts
+	GeneratedChangesAdditionalChartDir = DefaultGeneratedChangesAdditionalChartDir
+	// GeneratedChangesDependenciesDir is a directory that contains dependencies within GeneratedChangesDir
+	GeneratedChangesDependenciesDir = DefaultGeneratedChangesDependenciesDir
+	// FlatAdditionalChartGeneratedChanges indicates that the GeneratedChanges of an additional chart are placed directly within its directory in GeneratedChangesAdditionalChartDir
+	// instead of within a GeneratedChangesDir nested in it
+	FlatAdditionalChartGeneratedChanges = false
 )
@@ -0,0 +1,158 @@
+package preview
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/path"
+	"github.com/sirupsen/logrus"
+	helmLoader "helm.sh/helm/v3/pkg/chart/loader"
+	helmRepo "helm.sh/helm/v3/pkg/repo"
+)
+
+// ChartVersion represents a single version of a chart within the catalog that is previewed
+type ChartVersion struct {
+	// Name is the name of the chart
+	Name string
+	// Version is the version of the chart
+	Version string
+	// AppVersion is the version of the app deployed by the chart
+	AppVersion string
+	// Annotations are the annotations on the chart's Chart.yaml
+	Annotations map[string]string
+	// URLs are the locations of the chart archive relative to the repository
+	URLs []string
+	// ValidationError is any error encountered while validating the chart archive
+	ValidationError string
+}
+
+// Serve serves the Helm index and chart assets in the repository along with a web UI that lists the charts in the catalog
+func Serve(rootFs billy.Filesystem, address string) error {
+	exists, err := filesystem.PathExists(rootFs, path.RepositoryHelmIndexFile)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("Could not find %s in the repository; you must generate charts before previewing them", path.RepositoryHelmIndexFile)
+	}
+	t := template.Must(template.New("preview").Parse(previewTemplate))
+	mux := http.NewServeMux()
+	fileServer := http.FileServer(http.Dir(rootFs.Root()))
+	mux.Handle("/"+path.RepositoryHelmIndexFile, fileServer)
+	mux.Handle("/"+path.RepositoryAssetsDir+"/", fileServer)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		chartVersions, err := GetChartVersions(rootFs)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := t.Execute(w, chartVersions); err != nil {
+			logrus.Errorf("Encountered error while rendering preview: %s", err)
+		}
+	})
+	logrus.Infof("Serving catalog preview at http://%s", address)
+	return http.ListenAndServe(address, mux)
+}
+
+// GetChartVersions returns every chart version tracked in the Helm index of the repository along with its validation status
+func GetChartVersions(rootFs billy.Filesystem) ([]ChartVersion, error) {
+	helmIndexFile, err := helmRepo.LoadIndexFile(filesystem.GetAbsPath(rootFs, path.RepositoryHelmIndexFile))
+	if err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to load index file: %s", err)
+	}
+	var chartVersions []ChartVersion
+	for _, entries := range helmIndexFile.Entries {
+		for _, entry := range entries {
+			chartVersion := ChartVersion{
+				Name:        entry.Name,
+				Version:     entry.Version,
+				AppVersion:  entry.AppVersion,
+				Annotations: entry.Annotations,
+				URLs:        entry.URLs,
+			}
+			if err := validateChartArchive(rootFs, entry); err != nil {
+				chartVersion.ValidationError = err.Error()
+			}
+			chartVersions = append(chartVersions, chartVersion)
+		}
+	}
+	sort.SliceStable(chartVersions, func(i, j int) bool {
+		return chartVersions[i].Name < chartVersions[j].Name
+	})
+	return chartVersions, nil
+}
+
+// validateChartArchive ensures that the archive tracked by an index entry exists and contains a valid chart
+func validateChartArchive(rootFs billy.Filesystem, entry *helmRepo.ChartVersion) error {
+	if len(entry.URLs) == 0 {
+		return fmt.Errorf("No URLs found for chart")
+	}
+	// Only archives within the assets directory are served, so the index can never point the preview at any other file
+	archivePath, err := filesystem.SecureJoin(".", entry.URLs[0])
+	if err != nil {
+		return fmt.Errorf("Chart archive %s is invalid: %s", entry.URLs[0], err)
+	}
+	if !strings.HasPrefix(filepath.ToSlash(archivePath), path.RepositoryAssetsDir+"/") {
+		return fmt.Errorf("Chart archive %s is invalid: must be within %s", entry.URLs[0], path.RepositoryAssetsDir)
+	}
+	exists, err := filesystem.PathExists(rootFs, archivePath)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("Chart archive %s does not exist", archivePath)
+	}
+	chart, err := helmLoader.Load(filesystem.GetAbsPath(rootFs, archivePath))
+	if err != nil {
+		return fmt.Errorf("Could not load Helm chart: %s", err)
+	}
+	if err := chart.Validate(); err != nil {
+		return fmt.Errorf("Failed while trying to validate Helm chart: %s", err)
+	}
+	if chart.Metadata.Version != entry.Version {
+		return fmt.Errorf("Chart archive has version %s but index has version %s", chart.Metadata.Version, entry.Version)
+	}
+	return nil
+}
+
+const previewTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Catalog Preview</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border: 1px solid #ddd; padding: 0.5em; text-align: left; vertical-align: top; }
+.valid { color: #22863a; }
+.invalid { color: #cb2431; }
+</style>
+</head>
+<body>
+<h1>Catalog Preview</h1>
+<p>Add this repository to Helm with <code>helm repo add preview &lt;this URL&gt;</code>.</p>
+<table>
+<tr><th>Chart</th><th>Version</th><th>App Version</th><th>Annotations</th><th>Validation</th></tr>
+{{- range . }}
+<tr>
+<td>{{ .Name }}</td>
+<td>{{ if .URLs }}<a href="/{{ index .URLs 0 }}">{{ .Version }}</a>{{ else }}{{ .Version }}{{ end }}</td>
+<td>{{ .AppVersion }}</td>
+<td>{{ range $key, $value := .Annotations }}<code>{{ $key }}: {{ $value }}</code><br>{{ end }}</td>
+<td>{{ if .ValidationError }}<span class="invalid">{{ .ValidationError }}</span>{{ else }}<span class="valid">valid</span>{{ end }}</td>
+</tr>
+{{- end }}
+</table>
+</body>
+</html>
+`
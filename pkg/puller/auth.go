@@ -0,0 +1,87 @@
+package puller
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/rancher/charts-build-scripts/pkg/credentials"
+)
+
+const (
+	// DefaultTokenUsername is the username used to authenticate over HTTPS when credentials only provide a token
+	DefaultTokenUsername = "x-access-token"
+	// sshUser is the user used when cloning a Git repository over SSH
+	sshUser = "git"
+	// sshAuthSockEnvVar is the environment variable that points to a running SSH agent
+	sshAuthSockEnvVar = "SSH_AUTH_SOCK"
+)
+
+var (
+	// defaultSSHKeyFiles are the private keys within ~/.ssh that are used if no SSH agent is running
+	defaultSSHKeyFiles = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+)
+
+// getHTTPAuth returns the authentication method to use when cloning a Git repository over HTTPS from the host, if credentials are available
+func getHTTPAuth(host string) (transport.AuthMethod, error) {
+	creds, err := credentials.GetCredentials(host)
+	if err != nil {
+		return nil, err
+	}
+	if creds == nil || (len(creds.Username) == 0 && len(creds.Password) == 0) {
+		return nil, nil
+	}
+	username := creds.Username
+	if len(username) == 0 {
+		username = DefaultTokenUsername
+	}
+	return &githttp.BasicAuth{
+		Username: username,
+		Password: creds.Password,
+	}, nil
+}
+
+// getSSHAuth returns the authentication method to use when cloning a Git repository over SSH from the host
+// It uses the SSH key from the credentials for the host if one is provided, then the SSH agent if one is running, and otherwise falls back to the default private keys in ~/.ssh
+func getSSHAuth(host string) (transport.AuthMethod, error) {
+	creds, err := credentials.GetCredentials(host)
+	if err != nil {
+		return nil, err
+	}
+	if creds != nil && len(creds.SSHKeyPath) > 0 {
+		auth, err := gitssh.NewPublicKeysFromFile(sshUser, creds.SSHKeyPath, creds.SSHKeyPassphrase)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load SSH key %s for %s: %s", creds.SSHKeyPath, host, err)
+		}
+		return auth, nil
+	}
+	if len(os.Getenv(sshAuthSockEnvVar)) > 0 {
+		return gitssh.NewSSHAgentAuth(sshUser)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	for _, keyFile := range defaultSSHKeyFiles {
+		keyPath := filepath.Join(home, ".ssh", keyFile)
+		if _, err := os.Stat(keyPath); err != nil {
+			continue
+		}
+		return gitssh.NewPublicKeysFromFile(sshUser, keyPath, "")
+	}
+	return nil, fmt.Errorf("No SSH agent is running and no SSH key could be found in %s", filepath.Join(home, ".ssh"))
+}
+
+// isAuthError returns whether the error was caused by a failure to authenticate against a Git server
+func isAuthError(err error) bool {
+	return errors.Is(err, transport.ErrAuthenticationRequired) || errors.Is(err, transport.ErrAuthorizationFailed)
+}
+
+// getAuthError returns an error explaining how to provide credentials for the host after failing to authenticate against it
+func getAuthError(host string, err error) error {
+	return fmt.Errorf("Unable to authenticate against %s (%s): provide credentials for %s using --credentials-provider or an SSH key", host, err, host)
+}
@@ -0,0 +1,116 @@
+package puller
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	"github.com/rancher/charts-build-scripts/pkg/transient"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	bundleFilepath = "upstream.bundle"
+)
+
+// GitBundle represents a path or URL pointing to a Git bundle file
+type GitBundle struct {
+	// URL represents a download link or a path relative to the repository root for a Git bundle
+	URL string `yaml:"url"`
+	// Subdirectory represents a specific directory within the upstream pointed to by the URL to treat as the root
+	Subdirectory *string `yaml:"subdirectory"`
+	// Commit represents a specific commit hash to treat as the head
+	Commit *string `yaml:"commit"`
+}
+
+// Pull clones the repository contained within the bundle
+func (u GitBundle) Pull(rootFs, fs billy.Filesystem, path string) error {
+	logrus.Infof("Pulling %s from upstream into %s", u, path)
+	if u.Commit == nil {
+		return fmt.Errorf("If you are pulling from a Git bundle, a commit is required in the package.yaml")
+	}
+	pathToGitCmd, err := exec.LookPath("git")
+	if err != nil {
+		return fmt.Errorf("Cannot pull from a Git bundle if git is not available")
+	}
+	var absBundlePath string
+	if u.isRemote() {
+		if err := transient.Track(fs, bundleFilepath); err != nil {
+			return err
+		}
+		defer transient.Remove(fs, bundleFilepath)
+		if err := filesystem.GetChartArchive(fs, u.URL, bundleFilepath); err != nil {
+			return err
+		}
+		absBundlePath = filesystem.GetAbsPath(fs, bundleFilepath)
+	} else if filepath.IsAbs(u.URL) {
+		absBundlePath = u.URL
+	} else {
+		absBundlePath = filesystem.GetAbsPath(rootFs, u.URL)
+	}
+	absPath := filesystem.GetAbsPath(fs, path)
+	if err := runGit(pathToGitCmd, "", "clone", "--quiet", "--no-checkout", absBundlePath, absPath); err != nil {
+		return fmt.Errorf("Unable to clone Git bundle %s: %s", u.URL, err)
+	}
+	if err := runGit(pathToGitCmd, absPath, "checkout", "--quiet", *u.Commit); err != nil {
+		return fmt.Errorf("Unable to checkout commit %s from Git bundle %s: %s", *u.Commit, u.URL, err)
+	}
+	if err := filesystem.RemoveAll(fs, filepath.Join(path, ".git")); err != nil {
+		return err
+	}
+	if u.Subdirectory != nil && len(*u.Subdirectory) > 0 {
+		if err := filesystem.MakeSubdirectoryRoot(fs, path, *u.Subdirectory); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isRemote returns whether the bundle needs to be downloaded
+func (u GitBundle) isRemote() bool {
+	return strings.HasPrefix(u.URL, "http://") || strings.HasPrefix(u.URL, "https://")
+}
+
+// GetOptions returns the path used to construct this upstream
+func (u GitBundle) GetOptions() options.UpstreamOptions {
+	return options.UpstreamOptions{
+		URL:          u.URL,
+		Subdirectory: u.Subdirectory,
+		Commit:       u.Commit,
+	}
+}
+
+// IsWithinPackage returns whether this upstream already exists within the package
+func (u GitBundle) IsWithinPackage() bool {
+	return false
+}
+
+func (u GitBundle) String() string {
+	repoStr := u.URL
+	if u.Commit != nil {
+		repoStr = fmt.Sprintf("%s@%s", repoStr, *u.Commit)
+	}
+	if u.Subdirectory != nil {
+		repoStr = fmt.Sprintf("%s[path=%s]", repoStr, *u.Subdirectory)
+	}
+	return repoStr
+}
+
+// runGit runs the git command with the provided args within dir
+func runGit(pathToGitCmd, dir string, args ...string) error {
+	var buf bytes.Buffer
+	cmd := exec.Command(pathToGitCmd, args...)
+	cmd.Dir = dir
+	cmd.Stdout = &buf
+	cmd.Stderr = &buf
+	if err := cmd.Run(); err != nil {
+		logrus.Errorf("\n%s", &buf)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package puller
+
+import (
+	"path/filepath"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/cache"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/sirupsen/logrus"
+)
+
+// pullWithCache places the contents of the upstream identified by key into the path
+// If the upstream cache is enabled, the contents are taken from the cache or pulled into the cache by calling pull before being copied into the path
+func pullWithCache(fs billy.Filesystem, path, key string, pull func(fs billy.Filesystem, path string) error) error {
+	upstreamCache := cache.GetDefault()
+	if upstreamCache == nil {
+		return pull(fs, path)
+	}
+	contentPath, ok, err := upstreamCache.Get(key)
+	if err != nil {
+		logrus.Warnf("Not using the upstream cache for %s: %s", key, err)
+		return pull(fs, path)
+	}
+	if ok {
+		logrus.Infof("Using cached copy of %s", key)
+	} else {
+		contentPath, err = upstreamCache.Put(key, func(contentPath string) error {
+			return pull(filesystem.GetFilesystem(filepath.Dir(contentPath)), filepath.Base(contentPath))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return filesystem.CopyFromLocalPath(contentPath, fs, path)
+}
@@ -0,0 +1,433 @@
+package puller
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/rancher/charts-build-scripts/pkg/cache"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	"github.com/rancher/charts-build-scripts/pkg/ratelimit"
+	"github.com/rancher/charts-build-scripts/pkg/repository"
+	"github.com/rancher/charts-build-scripts/pkg/retry"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// fetchedCommitRefName is the reference that points to a commit fetched without its history
+	fetchedCommitRefName = "refs/heads/charts-build-scripts"
+)
+
+// IsGithubURL returns whether the URL points to a repository hosted on Github
+func IsGithubURL(url string) bool {
+	endpoint, err := transport.NewEndpoint(url)
+	if err != nil {
+		return false
+	}
+	return endpoint.Host == githubHost
+}
+
+// GetGitRepository gets a Git repository hosted on any Git server from options
+func GetGitRepository(upstreamOptions options.UpstreamOptions, branch *string) (GitRepository, error) {
+	var gitRepo GitRepository
+	if !strings.HasSuffix(upstreamOptions.URL, ".git") {
+		return gitRepo, fmt.Errorf("URL does not seem to point to a Git repository: %s", upstreamOptions.URL)
+	}
+	endpoint, err := transport.NewEndpoint(upstreamOptions.URL)
+	if err != nil {
+		return gitRepo, fmt.Errorf("URL does not seem to be valid for a Git repository: %s", upstreamOptions.URL)
+	}
+	switch endpoint.Protocol {
+	case "http", "https", "ssh":
+	default:
+		return gitRepo, fmt.Errorf("URL must be an HTTP(S) or SSH URL for a Git repository, found %s", upstreamOptions.URL)
+	}
+	if upstreamOptions.Commit != nil && upstreamOptions.Tag != nil {
+		return gitRepo, fmt.Errorf("Only one of commit or tag can be provided for Git repository: %s", upstreamOptions.URL)
+	}
+	if upstreamOptions.Protocol != nil && *upstreamOptions.Protocol != HTTPSProtocol && *upstreamOptions.Protocol != SSHProtocol {
+		return gitRepo, fmt.Errorf("Protocol %s is invalid: must be %s or %s", *upstreamOptions.Protocol, HTTPSProtocol, SSHProtocol)
+	}
+	return GitRepository{
+		URL:          upstreamOptions.URL,
+		Subdirectory: upstreamOptions.Subdirectory,
+		Commit:       upstreamOptions.Commit,
+		Tag:          upstreamOptions.Tag,
+		Protocol:     upstreamOptions.Protocol,
+		endpoint:     endpoint,
+		branch:       branch,
+	}, nil
+}
+
+// GitRepository represents a repository hosted on any Git server, e.g. GitLab, Bitbucket, Gitea, or a self-hosted server
+type GitRepository struct {
+	// URL represents the HTTPS or SSH URL of the repository
+	URL string `yaml:"url"`
+	// Subdirectory represents a specific directory within the upstream pointed to by the URL to treat as the root
+	Subdirectory *string `yaml:"subdirectory"`
+	// Commit represents a specific commit hash to treat as the head
+	Commit *string `yaml:"commit"`
+	// Tag represents a specific tag to treat as the head
+	Tag *string `yaml:"tag"`
+	// Protocol represents the protocol to clone the repository with, either https or ssh. Defaults to the protocol of the URL
+	Protocol *string `yaml:"protocol"`
+
+	// endpoint is the parsed URL of the repository
+	endpoint *transport.Endpoint
+	// branch represents a specific branch to pull from
+	branch *string
+}
+
+// GetHTTPSURL returns the HTTPS URL of the repository
+func (r GitRepository) GetHTTPSURL() string {
+	if r.endpoint.Protocol == "http" || r.endpoint.Protocol == "https" {
+		return r.URL
+	}
+	return fmt.Sprintf("https://%s/%s", r.endpoint.Host, strings.TrimPrefix(r.endpoint.Path, "/"))
+}
+
+// GetSSHURL returns the SSH URL of the repository
+func (r GitRepository) GetSSHURL() string {
+	if r.endpoint.Protocol == "ssh" {
+		return r.URL
+	}
+	return fmt.Sprintf("%s@%s:%s", sshUser, r.endpoint.Host, strings.TrimPrefix(r.endpoint.Path, "/"))
+}
+
+// Pull grabs the repository
+func (r GitRepository) Pull(rootFs, fs billy.Filesystem, path string) error {
+	logrus.Infof("Pulling %s from upstream into %s", r, path)
+	return pullTree(fs, path, r.GetHTTPSURL(), r.Commit, r.Subdirectory, r.Clone, r.fetchCommit)
+}
+
+// Clone clones the repository into the path and checks out the commit while retaining its Git history
+func (r GitRepository) Clone(fs billy.Filesystem, path string) (*git.Repository, error) {
+	if r.Commit == nil && r.Tag == nil && r.branch == nil {
+		return nil, fmt.Errorf("If you are pulling from a Git repository, a commit or tag is required in the package.yaml")
+	}
+	repo, err := cloneWithSSHFallback(fs, path, r.endpoint.Host, r.GetHTTPSURL(), r.useSSH(), r.Protocol == nil, r.clone)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkoutCommit(repo, r.Commit); err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
+
+// fetchCommit fetches only the commit without its history into the path and checks out only the subdirectory, if provided
+func (r GitRepository) fetchCommit(fs billy.Filesystem, path string) error {
+	_, err := cloneWithSSHFallback(fs, path, r.endpoint.Host, r.GetHTTPSURL(), r.useSSH(), r.Protocol == nil, func(fs billy.Filesystem, path string, useSSH bool) (*git.Repository, error) {
+		cloneOptions, err := r.getCloneOptions(useSSH)
+		if err != nil {
+			return nil, err
+		}
+		return fetchCommit(fs, path, cloneOptions.URL, cloneOptions.Auth, *r.Commit, r.Subdirectory)
+	})
+	return err
+}
+
+// clone clones the repository into the path over either HTTPS or SSH
+func (r GitRepository) clone(fs billy.Filesystem, path string, useSSH bool) (*git.Repository, error) {
+	cloneOptions, err := r.getCloneOptions(useSSH)
+	if err != nil {
+		return nil, err
+	}
+	return git.PlainClone(filesystem.GetAbsPath(fs, path), false, cloneOptions)
+}
+
+// getCloneOptions returns the options to clone the repository over either HTTPS or SSH
+func (r GitRepository) getCloneOptions(useSSH bool) (*git.CloneOptions, error) {
+	var cloneOptions git.CloneOptions
+	var err error
+	if useSSH {
+		cloneOptions.URL = r.GetSSHURL()
+		cloneOptions.Auth, err = getSSHAuth(r.endpoint.Host)
+	} else {
+		cloneOptions.URL = r.GetHTTPSURL()
+		cloneOptions.Auth, err = getHTTPAuth(r.endpoint.Host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	setReferenceName(&cloneOptions, r.Tag, r.branch)
+	return &cloneOptions, nil
+}
+
+// useSSH returns whether the repository should be cloned over SSH, which defaults to the protocol of the URL
+func (r GitRepository) useSSH() bool {
+	if r.Protocol != nil {
+		return *r.Protocol == SSHProtocol
+	}
+	return r.endpoint.Protocol == "ssh"
+}
+
+// GetOptions returns the path used to construct this upstream
+func (r GitRepository) GetOptions() options.UpstreamOptions {
+	return options.UpstreamOptions{
+		URL:          r.URL,
+		Subdirectory: r.Subdirectory,
+		Commit:       r.Commit,
+		Tag:          r.Tag,
+		Protocol:     r.Protocol,
+	}
+}
+
+// IsWithinPackage returns whether this upstream already exists within the package
+func (r GitRepository) IsWithinPackage() bool {
+	return false
+}
+
+func (r GitRepository) String() string {
+	repoStr := fmt.Sprintf("%s/%s", r.endpoint.Host, strings.TrimSuffix(strings.TrimPrefix(r.endpoint.Path, "/"), ".git"))
+	if r.Commit != nil {
+		repoStr = fmt.Sprintf("%s@%s", repoStr, *r.Commit)
+	} else if r.Tag != nil {
+		repoStr = fmt.Sprintf("%s@%s", repoStr, *r.Tag)
+	}
+	if r.Subdirectory != nil {
+		repoStr = fmt.Sprintf("%s[path=%s]", repoStr, *r.Subdirectory)
+	}
+	return repoStr
+}
+
+// pullTree places the tree of a Git repository without its Git history into the path and makes the subdirectory its root, if provided
+// Trees pinned to a full commit hash are fetched with fetchCommit, which avoids downloading the history of the repository,
+// and fall back to a full clone if the server does not allow fetching a single commit
+// Trees pinned to a commit are immutable, so they are stored in and reused from the upstream cache keyed by the HTTPS URL, the commit, and the subdirectory
+func pullTree(fs billy.Filesystem, path, httpsURL string, commit, subdirectory *string, clone func(fs billy.Filesystem, path string) (*git.Repository, error), fetchCommit func(fs billy.Filesystem, path string) error) error {
+	pull := func(fs billy.Filesystem, path string) error {
+		if commit != nil && plumbing.IsHash(*commit) {
+			err := fetchCommit(fs, path)
+			if err == nil {
+				return filesystem.RemoveAll(fs, filepath.Join(path, ".git"))
+			}
+			if !errors.Is(err, git.ErrExactSHA1NotSupported) {
+				return err
+			}
+			logrus.Warnf("%s does not allow fetching a single commit; falling back to a full clone", httpsURL)
+			if err := filesystem.RemoveAll(fs, path); err != nil {
+				return err
+			}
+		}
+		if _, err := clone(fs, path); err != nil {
+			return err
+		}
+		return filesystem.RemoveAll(fs, filepath.Join(path, ".git"))
+	}
+	var err error
+	if commit != nil {
+		key := cache.Key(httpsURL, *commit)
+		if subdirectory != nil && len(*subdirectory) > 0 {
+			key = fmt.Sprintf("%s[path=%s]", key, *subdirectory)
+		}
+		err = pullWithCache(fs, path, key, pull)
+	} else {
+		err = pull(fs, path)
+	}
+	if err != nil {
+		return err
+	}
+	if subdirectory != nil && len(*subdirectory) > 0 {
+		if err := filesystem.MakeSubdirectoryRoot(fs, path, *subdirectory); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchCommit creates a repository at the path that only contains the commit, fetched without any of its history
+// Only the files within the subdirectory of the commit are checked out, if provided
+func fetchCommit(fs billy.Filesystem, path, url string, auth transport.AuthMethod, commit string, subdirectory *string) (*git.Repository, error) {
+	repo, err := git.PlainInit(filesystem.GetAbsPath(fs, path), false)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := repo.CreateRemote(&config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{url},
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = remote.Fetch(&git.FetchOptions{
+		RefSpecs: []config.RefSpec{config.RefSpec(fmt.Sprintf("%s:%s", commit, fetchedCommitRefName))},
+		Depth:    1,
+		Auth:     auth,
+	})
+	if err != nil {
+		return nil, err
+	}
+	commitObject, err := repo.CommitObject(plumbing.NewHash(commit))
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commitObject.Tree()
+	if err != nil {
+		return nil, err
+	}
+	var treePath string
+	if subdirectory != nil && len(*subdirectory) > 0 {
+		treePath = strings.Trim(*subdirectory, "/")
+		if tree, err = tree.Tree(treePath); err != nil {
+			return nil, fmt.Errorf("Subdirectory %s does not exist in commit %s: %s", treePath, commit, err)
+		}
+	}
+	err = tree.Files().ForEach(func(f *object.File) error {
+		return writeTreeFile(fs, filepath.Join(path, treePath, f.Name), f)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
+
+// writeTreeFile writes a file from a Git tree to the path, retaining whether it is executable or a symbolic link
+func writeTreeFile(fs billy.Filesystem, path string, f *object.File) error {
+	contents, err := f.Contents()
+	if err != nil {
+		return err
+	}
+	if err := fs.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if f.Mode == filemode.Symlink {
+		return fs.Symlink(contents, path)
+	}
+	mode, err := f.Mode.ToOSFileMode()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filesystem.GetAbsPath(fs, path), []byte(contents), mode)
+}
+
+// cloneWithSSHFallback clones a Git repository from the host into the path using clone over SSH if useSSH is set and otherwise over HTTPS
+// If cloning over HTTPS fails with an authentication error, it retries over SSH if allowFallback and EnableSSHFallback are set
+func cloneWithSSHFallback(fs billy.Filesystem, path, host, httpsURL string, useSSH, allowFallback bool, clone func(fs billy.Filesystem, path string, useSSH bool) (*git.Repository, error)) (*git.Repository, error) {
+	repo, err := cloneWithRetries(fs, path, host, httpsURL, useSSH, clone)
+	if err == nil {
+		return repo, nil
+	}
+	if !isAuthError(err) {
+		return nil, err
+	}
+	if useSSH || !allowFallback || !EnableSSHFallback {
+		return nil, getAuthError(host, err)
+	}
+	if _, sshErr := getSSHAuth(host); sshErr != nil {
+		logrus.Debugf("Not falling back to SSH since no SSH key is available: %s", sshErr)
+		return nil, getAuthError(host, err)
+	}
+	logrus.Warnf("Failed to clone %s over HTTPS (%s); retrying over SSH", httpsURL, err)
+	if err := filesystem.RemoveAll(fs, path); err != nil {
+		return nil, err
+	}
+	repo, err = cloneWithRetries(fs, path, host, httpsURL, true, clone)
+	if err != nil && isAuthError(err) {
+		return nil, getAuthError(host, err)
+	}
+	return repo, err
+}
+
+// cloneWithRetries clones the repository into the path, retrying with backoff if the clone fails with an error that may be transient
+// Each attempt is subject to the limits on fetches from the host
+func cloneWithRetries(fs billy.Filesystem, path, host, httpsURL string, useSSH bool, clone func(fs billy.Filesystem, path string, useSSH bool) (*git.Repository, error)) (*git.Repository, error) {
+	var repo *git.Repository
+	var attempted bool
+	err := retry.Do(fmt.Sprintf("clone %s", httpsURL), func() error {
+		if attempted {
+			// Clean up anything left behind by the failed attempt
+			if err := filesystem.RemoveAll(fs, path); err != nil {
+				return retry.Permanent(err)
+			}
+		}
+		attempted = true
+		var err error
+		release := ratelimit.Acquire(host)
+		repo, err = clone(fs, path, useSSH)
+		release()
+		if err != nil && !isRetryableGitError(err) {
+			return retry.Permanent(err)
+		}
+		return err
+	})
+	return repo, err
+}
+
+// isRetryableGitError returns whether a clone or fetch that failed with the error may succeed on a retry
+// Only failures that are known to be transient, such as network errors and server errors, are retried
+func isRetryableGitError(err error) bool {
+	var unexpectedErr *plumbing.UnexpectedError
+	if errors.As(err, &unexpectedErr) {
+		// go-git does not allow unwrapping the errors it encountered while making requests
+		err = unexpectedErr.Err
+	}
+	var httpErr *githttp.Err
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode() == http.StatusTooManyRequests || httpErr.StatusCode() >= http.StatusInternalServerError
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+	for _, transientErr := range []error{
+		io.ErrUnexpectedEOF,
+		syscall.ECONNREFUSED,
+		syscall.ECONNRESET,
+		syscall.EPIPE,
+		syscall.ETIMEDOUT,
+	} {
+		if errors.Is(err, transientErr) {
+			return true
+		}
+	}
+	return false
+}
+
+// setReferenceName configures the cloneOptions to only clone the tag or branch provided, if any
+func setReferenceName(cloneOptions *git.CloneOptions, tag, branch *string) {
+	if tag != nil {
+		cloneOptions.ReferenceName = plumbing.NewTagReferenceName(*tag)
+		cloneOptions.SingleBranch = true
+	} else if branch != nil {
+		cloneOptions.ReferenceName = repository.GetLocalBranchRefName(*branch)
+		cloneOptions.SingleBranch = true
+	}
+}
+
+// checkoutCommit checks out the commit within the repository, if provided
+func checkoutCommit(repo *git.Repository, commit *string) error {
+	if commit == nil {
+		return nil
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	return wt.Checkout(&git.CheckoutOptions{
+		Hash: plumbing.NewHash(*commit),
+	})
+}
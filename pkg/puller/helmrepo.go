@@ -0,0 +1,107 @@
+package puller
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	"github.com/rancher/charts-build-scripts/pkg/transient"
+	"github.com/sirupsen/logrus"
+	helmRepo "helm.sh/helm/v3/pkg/repo"
+)
+
+const (
+	helmRepoIndexFilepath = "index.yaml"
+)
+
+// HelmRepository represents a chart published in a classic Helm repository that exposes an index.yaml
+type HelmRepository struct {
+	// URL represents the URL of the Helm repository, e.g. https://charts.jetstack.io
+	URL string `yaml:"url"`
+	// ChartName represents the name of the chart within the Helm repository
+	ChartName string `yaml:"chartName"`
+	// Version represents the version of the chart within the Helm repository
+	Version string `yaml:"version"`
+	// Subdirectory represents a specific directory within the chart archive to treat as the root
+	Subdirectory *string `yaml:"subdirectory"`
+}
+
+// Pull resolves the chart version through the index.yaml of the Helm repository and grabs its archive
+func (u HelmRepository) Pull(rootFs, fs billy.Filesystem, path string) error {
+	logrus.Infof("Pulling %s from upstream into %s", u, path)
+	if len(u.Version) == 0 {
+		return fmt.Errorf("If you are pulling from a Helm repository, a version is required in the package.yaml")
+	}
+	chartURL, err := u.getChartURL(fs)
+	if err != nil {
+		return err
+	}
+	if err := transient.Track(fs, chartArchiveFilepath); err != nil {
+		return err
+	}
+	defer transient.Remove(fs, chartArchiveFilepath)
+	if err := filesystem.GetChartArchive(fs, chartURL, chartArchiveFilepath); err != nil {
+		return err
+	}
+	if err := fs.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	defer filesystem.PruneEmptyDirsInPath(fs, path)
+	var subdirectory string
+	if u.Subdirectory != nil {
+		subdirectory = *u.Subdirectory
+	}
+	if err := filesystem.UnarchiveTgz(fs, chartArchiveFilepath, subdirectory, path, true); err != nil {
+		return err
+	}
+	return nil
+}
+
+// getChartURL returns the URL of the chart archive listed for this chart version in the index.yaml of the Helm repository
+func (u HelmRepository) getChartURL(fs billy.Filesystem) (string, error) {
+	indexURL := fmt.Sprintf("%s/%s", strings.TrimSuffix(u.URL, "/"), helmRepoIndexFilepath)
+	if err := filesystem.GetChartArchive(fs, indexURL, helmRepoIndexFilepath); err != nil {
+		return "", fmt.Errorf("Unable to get index.yaml from Helm repository %s: %s", u.URL, err)
+	}
+	defer fs.Remove(helmRepoIndexFilepath)
+	indexFile, err := helmRepo.LoadIndexFile(filesystem.GetAbsPath(fs, helmRepoIndexFilepath))
+	if err != nil {
+		return "", fmt.Errorf("Unable to load index.yaml from Helm repository %s: %s", u.URL, err)
+	}
+	chartVersion, err := indexFile.Get(u.ChartName, u.Version)
+	if err != nil {
+		return "", fmt.Errorf("Unable to find version %s of chart %s in Helm repository %s: %s", u.Version, u.ChartName, u.URL, err)
+	}
+	if len(chartVersion.URLs) == 0 {
+		return "", fmt.Errorf("Version %s of chart %s in Helm repository %s does not list any URLs", u.Version, u.ChartName, u.URL)
+	}
+	return helmRepo.ResolveReferenceURL(u.URL, chartVersion.URLs[0])
+}
+
+// GetOptions returns the path used to construct this upstream
+func (u HelmRepository) GetOptions() options.UpstreamOptions {
+	return options.UpstreamOptions{
+		URL:          u.URL,
+		ChartName:    &u.ChartName,
+		Version:      &u.Version,
+		Subdirectory: u.Subdirectory,
+	}
+}
+
+// IsWithinPackage returns whether this upstream already exists within the package
+func (u HelmRepository) IsWithinPackage() bool {
+	return false
+}
+
+func (u HelmRepository) String() string {
+	repoStr := fmt.Sprintf("%s[chart=%s]", u.URL, u.ChartName)
+	if len(u.Version) > 0 {
+		repoStr = fmt.Sprintf("%s@%s", repoStr, u.Version)
+	}
+	if u.Subdirectory != nil {
+		repoStr = fmt.Sprintf("%s[path=%s]", repoStr, *u.Subdirectory)
+	}
+	return repoStr
+}
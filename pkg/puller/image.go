@@ -0,0 +1,198 @@
+package puller
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/rancher/charts-build-scripts/pkg/credentials"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	"github.com/rancher/charts-build-scripts/pkg/ratelimit"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ContainerImageURLPrefix is the prefix of a URL that points to a container image
+	ContainerImageURLPrefix = "image://"
+)
+
+// ContainerImage represents a chart embedded within the filesystem of a container image
+type ContainerImage struct {
+	// URL represents the image reference prefixed by ContainerImageURLPrefix, e.g. image://docker.io/rancher/operator:v1.0.0
+	URL string `yaml:"url"`
+	// Subdirectory represents a specific directory within the image's filesystem to treat as the root
+	Subdirectory *string `yaml:"subdirectory"`
+}
+
+// Pull extracts the chart from the image's filesystem
+func (u ContainerImage) Pull(rootFs, fs billy.Filesystem, path string) error {
+	logrus.Infof("Pulling %s from upstream into %s", u, path)
+	if u.Subdirectory == nil || len(*u.Subdirectory) == 0 {
+		return fmt.Errorf("If you are pulling from a container image, a subdirectory within the image is required in the package.yaml")
+	}
+	ref, err := name.ParseReference(strings.TrimPrefix(u.URL, ContainerImageURLPrefix))
+	if err != nil {
+		return fmt.Errorf("Unable to parse image reference %s: %s", u.URL, err)
+	}
+	auth, err := getRegistryAuth(ref.Context().RegistryStr())
+	if err != nil {
+		return err
+	}
+	// Layers are fetched while the filesystem of the image is extracted
+	defer ratelimit.Acquire(ref.Context().RegistryStr())()
+	img, err := remote.Image(ref, auth)
+	if err != nil {
+		return fmt.Errorf("Unable to pull image %s: %s", ref, err)
+	}
+	imageFs := mutate.Extract(img)
+	defer imageFs.Close()
+	if err := fs.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	subdirectory := strings.Trim(filepath.Clean(*u.Subdirectory), "/")
+	subdirectoryFound := false
+	tarReader := tar.NewReader(imageFs)
+	for {
+		h, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to read filesystem of image %s: %s", ref, err)
+		}
+		imagePath := strings.TrimPrefix(filepath.Clean("/"+h.Name), "/")
+		if imagePath != subdirectory && !strings.HasPrefix(imagePath, subdirectory+"/") {
+			continue
+		}
+		subdirectoryFound = true
+		dstPath, err := filesystem.MovePath(imagePath, subdirectory, path)
+		if err != nil {
+			return err
+		}
+		switch h.Typeflag {
+		case tar.TypeDir:
+			if err := fs.MkdirAll(dstPath, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := copyTarEntry(fs, tarReader, dstPath, h.Mode); err != nil {
+				return err
+			}
+		default:
+			logrus.Warnf("Skipping %s in image %s since it is not a regular file or directory", h.Name, ref)
+		}
+	}
+	if !subdirectoryFound {
+		return fmt.Errorf("Subdirectory %s was not found within the filesystem of image %s", subdirectory, ref)
+	}
+	return nil
+}
+
+// copyTarEntry copies the current entry of the tarReader into the path in the filesystem
+func copyTarEntry(fs billy.Filesystem, tarReader *tar.Reader, path string, mode int64) error {
+	f, err := filesystem.CreateFileAndDirs(fs, path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, tarReader); err != nil {
+		return err
+	}
+	return filesystem.UpdatePermissions(fs, path, mode)
+}
+
+// getRegistryAuth returns the option to authenticate against the registry using credentials from the credentials provider or the default Docker keychain
+func getRegistryAuth(registry string) (remote.Option, error) {
+	creds, err := credentials.GetCredentials(registry)
+	if err != nil {
+		return nil, err
+	}
+	if creds == nil {
+		return remote.WithAuthFromKeychain(authn.DefaultKeychain), nil
+	}
+	return remote.WithAuth(authn.FromConfig(authn.AuthConfig{
+		Username: creds.Username,
+		Password: creds.Password,
+	})), nil
+}
+
+// GetImagePlatforms returns the platforms that the image is published for as os/arch, e.g. linux/amd64
+// Platforms that are not runnable, such as the attestation manifests within an index, are ignored
+func GetImagePlatforms(image string) ([]string, error) {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse image reference %s: %s", image, err)
+	}
+	auth, err := getRegistryAuth(ref.Context().RegistryStr())
+	if err != nil {
+		return nil, err
+	}
+	defer ratelimit.Acquire(ref.Context().RegistryStr())()
+	desc, err := remote.Get(ref, auth)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get manifest of image %s: %s", ref, err)
+	}
+	platformSet := make(map[string]bool)
+	if desc.MediaType.IsIndex() {
+		index, err := desc.ImageIndex()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to get index of image %s: %s", ref, err)
+		}
+		indexManifest, err := index.IndexManifest()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to get index manifest of image %s: %s", ref, err)
+		}
+		for _, manifest := range indexManifest.Manifests {
+			if manifest.Platform == nil || manifest.Platform.OS == "unknown" || len(manifest.Platform.OS) == 0 {
+				continue
+			}
+			platformSet[fmt.Sprintf("%s/%s", manifest.Platform.OS, manifest.Platform.Architecture)] = true
+		}
+	} else {
+		img, err := desc.Image()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to get image %s: %s", ref, err)
+		}
+		config, err := img.ConfigFile()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to get config of image %s: %s", ref, err)
+		}
+		platformSet[fmt.Sprintf("%s/%s", config.OS, config.Architecture)] = true
+	}
+	platforms := make([]string, 0, len(platformSet))
+	for platform := range platformSet {
+		platforms = append(platforms, platform)
+	}
+	sort.Strings(platforms)
+	return platforms, nil
+}
+
+// GetOptions returns the path used to construct this upstream
+func (u ContainerImage) GetOptions() options.UpstreamOptions {
+	return options.UpstreamOptions{
+		URL:          u.URL,
+		Subdirectory: u.Subdirectory,
+	}
+}
+
+// IsWithinPackage returns whether this upstream already exists within the package
+func (u ContainerImage) IsWithinPackage() bool {
+	return false
+}
+
+func (u ContainerImage) String() string {
+	repoStr := u.URL
+	if u.Subdirectory != nil {
+		repoStr = fmt.Sprintf("%s[path=%s]", repoStr, *u.Subdirectory)
+	}
+	return repoStr
+}
@@ -0,0 +1,139 @@
+package puller
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	"github.com/sirupsen/logrus"
+)
+
+// Layered represents an upstream whose pulled tree has the trees of other upstreams layered on top of it in order
+type Layered struct {
+	// Puller is the upstream that the base chart is pulled from
+	Puller
+	// Overlays are the upstreams whose contents are copied over the base chart in order, so later overlays take precedence
+	Overlays []Overlay
+}
+
+// Overlay represents an upstream whose contents are copied into a directory of another upstream
+type Overlay struct {
+	// Puller is the upstream that the contents of the overlay are pulled from
+	Puller
+	// Path is the directory within the chart where the contents of the overlay are placed. If empty, they are placed at the root of the chart
+	Path string
+}
+
+func (o Overlay) String() string {
+	if len(o.Path) == 0 {
+		return fmt.Sprintf("%s", o.Puller)
+	}
+	return fmt.Sprintf("%s[dest=%s]", o.Puller, o.Path)
+}
+
+// GetLayered wraps the upstream so that the overlays are layered on top of it on a pull
+func GetLayered(upstream Puller, overlays []Overlay) (Layered, error) {
+	if upstream.IsWithinPackage() {
+		return Layered{}, fmt.Errorf("Cannot layer overlays on top of an upstream that already exists within the package")
+	}
+	for _, overlay := range overlays {
+		if overlay.IsWithinPackage() {
+			return Layered{}, fmt.Errorf("Overlay %s cannot be an upstream that already exists within the package", overlay)
+		}
+		if cleanPath := filepath.Clean(overlay.Path); len(overlay.Path) > 0 && (filepath.IsAbs(cleanPath) || cleanPath == ".." || strings.HasPrefix(cleanPath, "../")) {
+			return Layered{}, fmt.Errorf("Path %s of overlay %s must be a directory within the chart", overlay.Path, overlay.Puller)
+		}
+	}
+	return Layered{
+		Puller:   upstream,
+		Overlays: overlays,
+	}, nil
+}
+
+// Pull grabs the base chart and copies the contents of each overlay over it
+// If an overlay only includes part of its chart, only the included directories or files are copied
+func (u Layered) Pull(rootFs, fs billy.Filesystem, path string) error {
+	if err := u.Puller.Pull(rootFs, fs, path); err != nil {
+		return err
+	}
+	overlayPath := fmt.Sprintf("%s-overlay", path)
+	defer filesystem.RemoveAll(fs, overlayPath)
+	for _, overlay := range u.Overlays {
+		if err := filesystem.RemoveAll(fs, overlayPath); err != nil {
+			return err
+		}
+		if err := overlay.Pull(rootFs, fs, overlayPath); err != nil {
+			return fmt.Errorf("Encountered error while pulling overlay %s: %s", overlay, err)
+		}
+		var names []string
+		if partial, ok := overlay.Puller.(Partial); ok && len(partial.Include) > 0 {
+			for _, name := range partial.Include {
+				names = append(names, strings.Trim(name, "/"))
+			}
+		} else {
+			fileInfos, err := fs.ReadDir(overlayPath)
+			if err != nil {
+				return err
+			}
+			for _, fileInfo := range fileInfos {
+				names = append(names, fileInfo.Name())
+			}
+		}
+		dstDir := filepath.Join(path, overlay.Path)
+		if err := fs.MkdirAll(dstDir, 0755); err != nil {
+			return err
+		}
+		for _, name := range names {
+			srcPath, dstPath := filepath.Join(overlayPath, name), filepath.Join(dstDir, name)
+			exists, err := filesystem.PathExists(fs, srcPath)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				continue
+			}
+			logrus.Infof("Layering %s from %s on top of %s", name, overlay.Puller, dstDir)
+			if err := filesystem.CopyDir(fs, srcPath, dstPath); err != nil {
+				return fmt.Errorf("Encountered error while layering %s from overlay %s: %s", name, overlay, err)
+			}
+		}
+	}
+	return nil
+}
+
+// GetOptions returns the path used to construct this upstream
+func (u Layered) GetOptions() options.UpstreamOptions {
+	upstreamOptions := u.Puller.GetOptions()
+	for _, overlay := range u.Overlays {
+		upstreamOptions.Overlays = append(upstreamOptions.Overlays, options.OverlayOptions{
+			UpstreamOptions: overlay.GetOptions(),
+			Path:            overlay.Path,
+		})
+	}
+	return upstreamOptions
+}
+
+func (u Layered) String() string {
+	overlays := make([]string, len(u.Overlays))
+	for i, overlay := range u.Overlays {
+		overlays[i] = overlay.String()
+	}
+	return fmt.Sprintf("%s[overlays=%s]", u.Puller, strings.Join(overlays, ","))
+}
+
+// GetOverlays returns the overlays that are layered on top of the upstream, if any
+func GetOverlays(upstream Puller) []Overlay {
+	for {
+		switch wrapped := upstream.(type) {
+		case Partial:
+			upstream = wrapped.Puller
+		case Layered:
+			return wrapped.Overlays
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package puller
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/go-git/go-billy/v5"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	"github.com/rancher/charts-build-scripts/pkg/ratelimit"
+	"github.com/rancher/charts-build-scripts/pkg/transient"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// OCIChartURLPrefix is the prefix of a URL that points to a chart published as an artifact in an OCI registry
+	OCIChartURLPrefix = "oci://"
+	// helmChartContentLayerMediaType is the media type of the layer that holds the chart archive within a Helm chart artifact
+	helmChartContentLayerMediaType = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+)
+
+// OCIChart represents a chart published as an artifact in an OCI registry
+type OCIChart struct {
+	// URL represents the reference of the chart artifact prefixed by OCIChartURLPrefix, e.g. oci://registry-1.docker.io/bitnamicharts/redis:18.0.0
+	URL string `yaml:"url"`
+	// Subdirectory represents a specific directory within the chart archive to treat as the root
+	Subdirectory *string `yaml:"subdirectory"`
+}
+
+// Pull grabs the chart archive held by the artifact and unarchives it
+func (u OCIChart) Pull(rootFs, fs billy.Filesystem, path string) error {
+	logrus.Infof("Pulling %s from upstream into %s", u, path)
+	ref, err := name.ParseReference(strings.TrimPrefix(u.URL, OCIChartURLPrefix))
+	if err != nil {
+		return fmt.Errorf("Unable to parse chart reference %s: %s", u.URL, err)
+	}
+	if err := transient.Track(fs, chartArchiveFilepath); err != nil {
+		return err
+	}
+	defer transient.Remove(fs, chartArchiveFilepath)
+	if err := getOCIChartArchive(fs, ref, chartArchiveFilepath); err != nil {
+		return err
+	}
+	if err := fs.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	defer filesystem.PruneEmptyDirsInPath(fs, path)
+	var subdirectory string
+	if u.Subdirectory != nil {
+		subdirectory = *u.Subdirectory
+	}
+	return filesystem.UnarchiveTgz(fs, chartArchiveFilepath, subdirectory, path, true)
+}
+
+// getOCIChartArchive writes the chart archive held by the artifact at ref into the path in the filesystem
+func getOCIChartArchive(fs billy.Filesystem, ref name.Reference, path string) error {
+	auth, err := getRegistryAuth(ref.Context().RegistryStr())
+	if err != nil {
+		return err
+	}
+	defer ratelimit.Acquire(ref.Context().RegistryStr())()
+	artifact, err := remote.Image(ref, auth)
+	if err != nil {
+		return fmt.Errorf("Unable to pull chart %s: %s", ref, err)
+	}
+	layers, err := artifact.Layers()
+	if err != nil {
+		return fmt.Errorf("Unable to get layers of chart %s: %s", ref, err)
+	}
+	for _, layer := range layers {
+		mediaType, err := layer.MediaType()
+		if err != nil {
+			return fmt.Errorf("Unable to get media type of layer in chart %s: %s", ref, err)
+		}
+		if string(mediaType) != helmChartContentLayerMediaType {
+			continue
+		}
+		content, err := layer.Compressed()
+		if err != nil {
+			return fmt.Errorf("Unable to get content of chart %s: %s", ref, err)
+		}
+		defer content.Close()
+		tgz, err := filesystem.CreateFileAndDirs(fs, path)
+		if err != nil {
+			return fmt.Errorf("Unable to create tgz file: %s", err)
+		}
+		defer tgz.Close()
+		if _, err := io.Copy(tgz, content); err != nil {
+			return fmt.Errorf("Unable to get content of chart %s: %s", ref, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("Artifact %s is not a Helm chart: no layer has media type %s", ref, helmChartContentLayerMediaType)
+}
+
+// GetOCIChartURL returns the URL of the artifact of the chart within the OCI registry repository, e.g. oci://registry-1.docker.io/bitnamicharts
+// The version may be a constraint, in which case the latest tag that satisfies it is used
+func GetOCIChartURL(repository, chartName, version string) (string, error) {
+	repo, err := name.NewRepository(fmt.Sprintf("%s/%s", strings.TrimSuffix(strings.TrimPrefix(repository, OCIChartURLPrefix), "/"), chartName))
+	if err != nil {
+		return "", fmt.Errorf("Unable to parse OCI repository %s: %s", repository, err)
+	}
+	tag, err := getOCIChartTag(repo, version)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%s:%s", OCIChartURLPrefix, repo, tag), nil
+}
+
+// getOCIChartTag returns the tag of the chart version within the OCI registry repository
+// Helm publishes chart versions with build metadata under tags in which the + is replaced by a _, since tags cannot contain a +
+func getOCIChartTag(repo name.Repository, version string) (string, error) {
+	if _, err := semver.StrictNewVersion(version); err == nil {
+		return strings.ReplaceAll(version, "+", "_"), nil
+	}
+	if len(version) == 0 {
+		version = "*"
+	}
+	constraint, err := semver.NewConstraint(version)
+	if err != nil {
+		return "", fmt.Errorf("Version %s of chart %s is invalid: %s", version, repo, err)
+	}
+	auth, err := getRegistryAuth(repo.RegistryStr())
+	if err != nil {
+		return "", err
+	}
+	release := ratelimit.Acquire(repo.RegistryStr())
+	tags, err := remote.List(repo, auth)
+	release()
+	if err != nil {
+		return "", fmt.Errorf("Unable to list tags of chart %s: %s", repo, err)
+	}
+	var latestTag string
+	var latestVersion *semver.Version
+	for _, tag := range tags {
+		v, err := semver.NewVersion(strings.ReplaceAll(tag, "_", "+"))
+		if err != nil || !constraint.Check(v) {
+			continue
+		}
+		if latestVersion == nil || v.GreaterThan(latestVersion) {
+			latestTag, latestVersion = tag, v
+		}
+	}
+	if latestVersion == nil {
+		return "", fmt.Errorf("Unable to find a version of chart %s that satisfies %s", repo, version)
+	}
+	return latestTag, nil
+}
+
+// GetOptions returns the path used to construct this upstream
+func (u OCIChart) GetOptions() options.UpstreamOptions {
+	return options.UpstreamOptions{
+		URL:          u.URL,
+		Subdirectory: u.Subdirectory,
+	}
+}
+
+// IsWithinPackage returns whether this upstream already exists within the package
+func (u OCIChart) IsWithinPackage() bool {
+	return false
+}
+
+func (u OCIChart) String() string {
+	repoStr := u.URL
+	if u.Subdirectory != nil {
+		repoStr = fmt.Sprintf("%s[path=%s]", repoStr, *u.Subdirectory)
+	}
+	return repoStr
+}
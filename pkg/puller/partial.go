@@ -0,0 +1,177 @@
+package puller
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// chartMetadataFile is always kept when pulling part of a chart so that the result is still a valid chart
+	chartMetadataFile = "Chart.yaml"
+)
+
+// Partial represents an upstream whose pulled tree is restricted to specific top-level directories of the chart, e.g. only crds/,
+// or has specific files removed, e.g. tests/
+type Partial struct {
+	// Puller is the upstream that the chart is pulled from
+	Puller
+	// Include represents the top-level directories or files of the chart to keep. The Chart.yaml is always kept
+	Include []string
+	// Exclude represents glob patterns of directories or files of the chart to remove
+	Exclude []string
+}
+
+// GetPartial wraps the upstream so that only the top-level directories or files listed in include are kept on a pull
+// and any directories or files that match a pattern listed in exclude are removed
+func GetPartial(upstream Puller, include, exclude []string) (Partial, error) {
+	if upstream.IsWithinPackage() {
+		return Partial{}, fmt.Errorf("Cannot restrict an upstream that already exists within the package")
+	}
+	for _, name := range include {
+		if len(name) == 0 || strings.Contains(strings.Trim(name, "/"), "/") {
+			return Partial{}, fmt.Errorf("Include must only contain top-level directories or files of the chart, found %s", name)
+		}
+	}
+	for _, pattern := range exclude {
+		if _, err := filepath.Match(pattern, ""); err != nil || len(strings.Trim(pattern, "/")) == 0 {
+			return Partial{}, fmt.Errorf("Exclude pattern %s is invalid", pattern)
+		}
+	}
+	return Partial{
+		Puller:  upstream,
+		Include: include,
+		Exclude: exclude,
+	}, nil
+}
+
+// Unwrap returns the upstream that the chart is pulled from before any parts of it are removed or any overlays are layered on top of it
+func Unwrap(upstream Puller) Puller {
+	for {
+		switch wrapped := upstream.(type) {
+		case Partial:
+			upstream = wrapped.Puller
+		case Layered:
+			upstream = wrapped.Puller
+		default:
+			return upstream
+		}
+	}
+}
+
+// Pull grabs the upstream and removes all top-level directories and files that are not included, as well as any that are excluded
+func (u Partial) Pull(rootFs, fs billy.Filesystem, path string) error {
+	if err := u.Puller.Pull(rootFs, fs, path); err != nil {
+		return err
+	}
+	if len(u.Include) > 0 {
+		if err := u.removeNotIncluded(fs, path); err != nil {
+			return err
+		}
+	}
+	if len(u.Exclude) > 0 {
+		return u.removeExcluded(fs, path)
+	}
+	return nil
+}
+
+// removeNotIncluded removes all top-level directories and files of the chart at path that are not included
+func (u Partial) removeNotIncluded(fs billy.Filesystem, path string) error {
+	keep := map[string]bool{chartMetadataFile: true}
+	for _, name := range u.Include {
+		keep[strings.Trim(name, "/")] = true
+	}
+	fileInfos, err := fs.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, fileInfo := range fileInfos {
+		if keep[fileInfo.Name()] {
+			continue
+		}
+		if err := filesystem.RemoveAll(fs, filepath.Join(path, fileInfo.Name())); err != nil {
+			return err
+		}
+	}
+	for name := range keep {
+		exists, err := filesystem.PathExists(fs, filepath.Join(path, name))
+		if err != nil {
+			return err
+		}
+		if !exists {
+			logrus.Warnf("%s was included but does not exist in %s", name, u.Puller)
+		}
+	}
+	return nil
+}
+
+// removeExcluded removes all directories and files of the chart at path that match an excluded pattern
+// Patterns that do not contain a slash are matched against the name of each directory or file, e.g. tests or *.md, while other patterns are matched against its path relative to the chart, e.g. templates/tests
+func (u Partial) removeExcluded(fs billy.Filesystem, path string) error {
+	var excluded []string
+	err := filesystem.WalkDir(fs, path, func(fs billy.Filesystem, filePath string, isDir bool) error {
+		relPath, err := filepath.Rel(path, filePath)
+		if err != nil || relPath == "." {
+			return err
+		}
+		for _, e := range excluded {
+			if strings.HasPrefix(filePath, e+"/") {
+				// Already removed along with its parent directory
+				return nil
+			}
+		}
+		relPath = filepath.ToSlash(relPath)
+		for _, pattern := range u.Exclude {
+			pattern = strings.Trim(pattern, "/")
+			name := relPath
+			if !strings.Contains(pattern, "/") {
+				name = filepath.Base(relPath)
+			}
+			if matched, _ := filepath.Match(pattern, name); matched {
+				excluded = append(excluded, filePath)
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, filePath := range excluded {
+		logrus.Debugf("Excluding %s from %s", filePath, u.Puller)
+		if err := filesystem.RemoveAll(fs, filePath); err != nil {
+			return err
+		}
+		if err := filesystem.PruneEmptyDirsInPath(fs, filePath); err != nil {
+			return err
+		}
+	}
+	if len(excluded) > 0 {
+		logrus.Infof("Excluded %d directories or files from %s", len(excluded), u.Puller)
+	}
+	return nil
+}
+
+// GetOptions returns the path used to construct this upstream
+func (u Partial) GetOptions() options.UpstreamOptions {
+	upstreamOptions := u.Puller.GetOptions()
+	upstreamOptions.Include = u.Include
+	upstreamOptions.Exclude = u.Exclude
+	return upstreamOptions
+}
+
+func (u Partial) String() string {
+	var restrictions []string
+	if len(u.Include) > 0 {
+		restrictions = append(restrictions, fmt.Sprintf("include=%s", strings.Join(u.Include, ",")))
+	}
+	if len(u.Exclude) > 0 {
+		restrictions = append(restrictions, fmt.Sprintf("exclude=%s", strings.Join(u.Exclude, ",")))
+	}
+	return fmt.Sprintf("%s[%s]", u.Puller, strings.Join(restrictions, ","))
+}
@@ -0,0 +1,86 @@
+package puller
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// LocalPathURLPrefix is the prefix of URLs that point to a directory within the repository
+	LocalPathURLPrefix = "path://"
+)
+
+// LocalPath represents a directory within the repository outside of the package, e.g. a sibling directory in a monorepo
+type LocalPath struct {
+	// Path represents the path to the directory relative to the repository root
+	Path string `yaml:"path"`
+	// Subdirectory represents a specific directory within the path to treat as the root
+	Subdirectory *string `yaml:"subdirectory"`
+}
+
+// GetLocalPath returns a LocalPath from a URL prefixed by path://
+func GetLocalPath(url string, subdirectory *string) (LocalPath, error) {
+	path := filepath.Clean(strings.TrimPrefix(url, LocalPathURLPrefix))
+	if filepath.IsAbs(path) || path == "." || path == ".." || strings.HasPrefix(path, "../") {
+		return LocalPath{}, fmt.Errorf("URL %s is invalid: path must be a directory relative to the repository root", url)
+	}
+	return LocalPath{
+		Path:         path,
+		Subdirectory: subdirectory,
+	}, nil
+}
+
+// Pull copies the directory into the path
+func (u LocalPath) Pull(rootFs, fs billy.Filesystem, path string) error {
+	logrus.Infof("Pulling %s from upstream into %s", u, path)
+	exists, err := filesystem.PathExists(rootFs, u.Path)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("Could not find directory %s in the repository", u.Path)
+	}
+	repositoryPath, err := filesystem.GetRelativePath(rootFs, filesystem.GetAbsPath(fs, path))
+	if err != nil {
+		return err
+	}
+	if repositoryPath == u.Path || strings.HasPrefix(repositoryPath, u.Path+"/") {
+		return fmt.Errorf("Cannot pull %s into %s since it is within the directory being pulled", u, repositoryPath)
+	}
+	if err := filesystem.CopyDir(rootFs, u.Path, repositoryPath); err != nil {
+		return fmt.Errorf("Encountered error while copying %s into path: %s", u.Path, err)
+	}
+	if u.Subdirectory != nil && len(*u.Subdirectory) > 0 {
+		if err := filesystem.MakeSubdirectoryRoot(fs, path, *u.Subdirectory); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetOptions returns the path used to construct this upstream
+func (u LocalPath) GetOptions() options.UpstreamOptions {
+	return options.UpstreamOptions{
+		URL:          LocalPathURLPrefix + u.Path,
+		Subdirectory: u.Subdirectory,
+	}
+}
+
+// IsWithinPackage returns whether this upstream already exists within the package
+func (u LocalPath) IsWithinPackage() bool {
+	return false
+}
+
+func (u LocalPath) String() string {
+	repoStr := LocalPathURLPrefix + u.Path
+	if u.Subdirectory != nil {
+		repoStr = fmt.Sprintf("%s[path=%s]", repoStr, *u.Subdirectory)
+	}
+	return repoStr
+}
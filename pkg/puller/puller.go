@@ -1,27 +1,46 @@
 package puller
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
-	"path/filepath"
 	"strings"
 
 	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-git/v5"
-	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/rancher/charts-build-scripts/pkg/cache"
 	"github.com/rancher/charts-build-scripts/pkg/filesystem"
 	"github.com/rancher/charts-build-scripts/pkg/options"
-	"github.com/rancher/charts-build-scripts/pkg/repository"
+	"github.com/rancher/charts-build-scripts/pkg/transient"
 	"github.com/sirupsen/logrus"
 )
 
 const (
-	chartArchiveFilepath = "chart.tgz"
+	chartArchiveFilepath    = "chart.tgz"
+	chartZipArchiveFilepath = "chart.zip"
+	sha256ChecksumPrefix    = "sha256:"
 
+	githubHost  = "github.com"
 	httpsURLFmt = "https://github.com/%s/%s.git"
 	sshURLFmt   = "git@github.com:%s/%s.git"
 )
 
+const (
+	// HTTPSProtocol indicates that a Git repository should be cloned over HTTPS
+	HTTPSProtocol = "https"
+	// SSHProtocol indicates that a Git repository should be cloned over SSH
+	SSHProtocol = "ssh"
+)
+
+var (
+	// EnableSSHFallback indicates that a Git repository should be cloned over SSH if cloning over HTTPS fails with an authentication error
+	// This only applies to repositories that do not explicitly set a protocol
+	EnableSSHFallback = false
+)
+
 // Puller represents an interface that is able to pull a directory from a remote source
 type Puller interface {
 	// Pull grabs the Helm chart and places it on a path in the filesystem
@@ -42,9 +61,17 @@ func GetGithubRepository(upstreamOptions options.UpstreamOptions, branch *string
 	if len(splitURL) < 2 {
 		return githubRepo, fmt.Errorf("URL does not seem to be valid for a Git repository: %s", upstreamOptions.URL)
 	}
+	if upstreamOptions.Commit != nil && upstreamOptions.Tag != nil {
+		return githubRepo, fmt.Errorf("Only one of commit or tag can be provided for Git repository: %s", upstreamOptions.URL)
+	}
+	if upstreamOptions.Protocol != nil && *upstreamOptions.Protocol != HTTPSProtocol && *upstreamOptions.Protocol != SSHProtocol {
+		return githubRepo, fmt.Errorf("Protocol %s is invalid: must be %s or %s", *upstreamOptions.Protocol, HTTPSProtocol, SSHProtocol)
+	}
 	return GithubRepository{
 		Subdirectory: upstreamOptions.Subdirectory,
 		Commit:       upstreamOptions.Commit,
+		Tag:          upstreamOptions.Tag,
+		Protocol:     upstreamOptions.Protocol,
 		owner:        splitURL[len(splitURL)-2],
 		name:         splitURL[len(splitURL)-1],
 		branch:       branch,
@@ -57,6 +84,10 @@ type GithubRepository struct {
 	Subdirectory *string `yaml:"subdirectory"`
 	// Commit represents a specific commit hash to treat as the head
 	Commit *string `yaml:"commit"`
+	// Tag represents a specific tag to treat as the head
+	Tag *string `yaml:"tag"`
+	// Protocol represents the protocol to clone the repository with, either https or ssh
+	Protocol *string `yaml:"protocol"`
 
 	// owner represents the account that owns the repo, e.g. rancher
 	owner string `yaml:"owner"`
@@ -71,6 +102,16 @@ func (r GithubRepository) GetHTTPSURL() string {
 	return fmt.Sprintf(httpsURLFmt, r.owner, r.name)
 }
 
+// GetOwner returns the account that owns the repository
+func (r GithubRepository) GetOwner() string {
+	return r.owner
+}
+
+// GetName returns the name of the repository
+func (r GithubRepository) GetName() string {
+	return r.name
+}
+
 // GetSSHURL returns the SSH URL of the repository
 func (r GithubRepository) GetSSHURL() string {
 	return fmt.Sprintf(sshURLFmt, r.owner, r.name)
@@ -79,41 +120,66 @@ func (r GithubRepository) GetSSHURL() string {
 // Pull grabs the repository
 func (r GithubRepository) Pull(rootFs, fs billy.Filesystem, path string) error {
 	logrus.Infof("Pulling %s from upstream into %s", r, path)
-	if r.Commit == nil && r.branch == nil {
-		return fmt.Errorf("If you are pulling from a Git repository, a commit is required in the package.yaml")
-	}
-	cloneOptions := git.CloneOptions{
-		URL: r.GetHTTPSURL(),
-	}
-	if r.branch != nil {
-		cloneOptions.ReferenceName = repository.GetLocalBranchRefName(*r.branch)
-		cloneOptions.SingleBranch = true
+	return pullTree(fs, path, r.GetHTTPSURL(), r.Commit, r.Subdirectory, r.Clone, r.fetchCommit)
+}
+
+// Clone clones the repository into the path and checks out the commit while retaining its Git history
+func (r GithubRepository) Clone(fs billy.Filesystem, path string) (*git.Repository, error) {
+	if r.Commit == nil && r.Tag == nil && r.branch == nil {
+		return nil, fmt.Errorf("If you are pulling from a Git repository, a commit or tag is required in the package.yaml")
 	}
-	repo, err := git.PlainClone(filesystem.GetAbsPath(fs, path), false, &cloneOptions)
+	repo, err := cloneWithSSHFallback(fs, path, githubHost, r.GetHTTPSURL(), r.useSSH(), r.Protocol == nil, r.clone)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if r.Commit != nil {
-		wt, err := repo.Worktree()
+	if err := checkoutCommit(repo, r.Commit); err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
+
+// fetchCommit fetches only the commit without its history into the path and checks out only the subdirectory, if provided
+func (r GithubRepository) fetchCommit(fs billy.Filesystem, path string) error {
+	_, err := cloneWithSSHFallback(fs, path, githubHost, r.GetHTTPSURL(), r.useSSH(), r.Protocol == nil, func(fs billy.Filesystem, path string, useSSH bool) (*git.Repository, error) {
+		cloneOptions, err := r.getCloneOptions(useSSH)
 		if err != nil {
-			return err
-		}
-		err = wt.Checkout(&git.CheckoutOptions{
-			Hash: plumbing.NewHash(*r.Commit),
-		})
-		if err != nil {
-			return err
+			return nil, err
 		}
+		return fetchCommit(fs, path, cloneOptions.URL, cloneOptions.Auth, *r.Commit, r.Subdirectory)
+	})
+	return err
+}
+
+// clone clones the repository into the path over either HTTPS or SSH
+func (r GithubRepository) clone(fs billy.Filesystem, path string, useSSH bool) (*git.Repository, error) {
+	cloneOptions, err := r.getCloneOptions(useSSH)
+	if err != nil {
+		return nil, err
 	}
-	if err := filesystem.RemoveAll(fs, filepath.Join(path, ".git")); err != nil {
-		return err
+	return git.PlainClone(filesystem.GetAbsPath(fs, path), false, cloneOptions)
+}
+
+// getCloneOptions returns the options to clone the repository over either HTTPS or SSH
+func (r GithubRepository) getCloneOptions(useSSH bool) (*git.CloneOptions, error) {
+	var cloneOptions git.CloneOptions
+	var err error
+	if useSSH {
+		cloneOptions.URL = r.GetSSHURL()
+		cloneOptions.Auth, err = getSSHAuth(githubHost)
+	} else {
+		cloneOptions.URL = r.GetHTTPSURL()
+		cloneOptions.Auth, err = getHTTPAuth(githubHost)
 	}
-	if r.Subdirectory != nil && len(*r.Subdirectory) > 0 {
-		if err := filesystem.MakeSubdirectoryRoot(fs, path, *r.Subdirectory); err != nil {
-			return err
-		}
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	setReferenceName(&cloneOptions, r.Tag, r.branch)
+	return &cloneOptions, nil
+}
+
+// useSSH returns whether the repository should be cloned over SSH
+func (r GithubRepository) useSSH() bool {
+	return r.Protocol != nil && *r.Protocol == SSHProtocol
 }
 
 // GetOptions returns the path used to construct this upstream
@@ -122,6 +188,8 @@ func (r GithubRepository) GetOptions() options.UpstreamOptions {
 		URL:          r.GetHTTPSURL(),
 		Subdirectory: r.Subdirectory,
 		Commit:       r.Commit,
+		Tag:          r.Tag,
+		Protocol:     r.Protocol,
 	}
 }
 
@@ -134,6 +202,8 @@ func (r GithubRepository) String() string {
 	repoStr := fmt.Sprintf("%s/%s", r.owner, r.name)
 	if r.Commit != nil {
 		repoStr = fmt.Sprintf("%s@%s", repoStr, *r.Commit)
+	} else if r.Tag != nil {
+		repoStr = fmt.Sprintf("%s@%s", repoStr, *r.Tag)
 	}
 	if r.Subdirectory != nil {
 		repoStr = fmt.Sprintf("%s[path=%s]", repoStr, *r.Subdirectory)
@@ -141,21 +211,48 @@ func (r GithubRepository) String() string {
 	return repoStr
 }
 
-// Archive represents a URL pointing to a .tgz file
+// Archive represents a URL pointing to a .tgz or .zip file
 type Archive struct {
 	// URL represents a download link for an archive
 	URL string `yaml:"url"`
 	// Subdirectory represents a specific directory within the upstream pointed to by the URL to treat as the root
 	Subdirectory *string `yaml:"subdirectory"`
+	// Checksum represents the expected digest of the archive in the form sha256:<hex>
+	Checksum *string `yaml:"checksum"`
 }
 
 // Pull grabs the archive
 func (u Archive) Pull(rootFs, fs billy.Filesystem, path string) error {
 	logrus.Infof("Pulling %s from upstream into %s", u, path)
-	if err := filesystem.GetChartArchive(fs, u.URL, chartArchiveFilepath); err != nil {
+	download := func(fs billy.Filesystem, path string) error {
+		if err := filesystem.GetChartArchive(fs, u.URL, path); err != nil {
+			return err
+		}
+		if u.Checksum != nil {
+			if err := verifyChecksum(fs, path, *u.Checksum); err != nil {
+				return fmt.Errorf("Unable to verify archive downloaded from %s: %s", u.URL, err)
+			}
+		}
+		return nil
+	}
+	archivePath, unarchive := chartArchiveFilepath, filesystem.UnarchiveTgz
+	if u.IsZip() {
+		archivePath, unarchive = chartZipArchiveFilepath, filesystem.UnarchiveZip
+	}
+	if err := transient.Track(fs, archivePath); err != nil {
+		return err
+	}
+	defer transient.Remove(fs, archivePath)
+	// Archives are only cached if they are pinned to a checksum, since the contents of a URL may otherwise change
+	var err error
+	if u.Checksum != nil {
+		err = pullWithCache(fs, archivePath, cache.Key(u.URL, *u.Checksum), download)
+	} else {
+		err = download(fs, archivePath)
+	}
+	if err != nil {
 		return err
 	}
-	defer fs.Remove(chartArchiveFilepath)
 	if err := fs.MkdirAll(path, os.ModePerm); err != nil {
 		return err
 	}
@@ -164,16 +261,30 @@ func (u Archive) Pull(rootFs, fs billy.Filesystem, path string) error {
 	if u.Subdirectory != nil {
 		subdirectory = *u.Subdirectory
 	}
-	if err := filesystem.UnarchiveTgz(fs, chartArchiveFilepath, subdirectory, path, true); err != nil {
+	if err := unarchive(fs, archivePath, subdirectory, path, true); err != nil {
 		return err
 	}
 	return nil
 }
 
+// IsZip returns whether the URL points to a .zip file rather than a .tgz file
+func (u Archive) IsZip() bool {
+	return IsZipURL(u.URL)
+}
+
+// IsZipURL returns whether the URL points to a .zip file, ignoring any query or fragment
+func IsZipURL(rawURL string) bool {
+	if parsedURL, err := url.Parse(rawURL); err == nil {
+		rawURL = parsedURL.Path
+	}
+	return strings.HasSuffix(strings.ToLower(rawURL), ".zip")
+}
+
 // GetOptions returns the path used to construct this upstream
 func (u Archive) GetOptions() options.UpstreamOptions {
 	return options.UpstreamOptions{
-		URL: u.URL,
+		URL:      u.URL,
+		Checksum: u.Checksum,
 	}
 }
 
@@ -189,3 +300,25 @@ func (u Archive) String() string {
 	}
 	return repoStr
 }
+
+// verifyChecksum returns an error if the digest of the file at path does not match the checksum, which must be in the form sha256:<hex>
+func verifyChecksum(fs billy.Filesystem, path, checksum string) error {
+	expected := strings.TrimPrefix(checksum, sha256ChecksumPrefix)
+	if expected == checksum {
+		return fmt.Errorf("Checksum %s is invalid: must be in the form %s<hex>", checksum, sha256ChecksumPrefix)
+	}
+	f, err := fs.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return err
+	}
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("Checksum mismatch: expected %s%s, found %s%s", sha256ChecksumPrefix, expected, sha256ChecksumPrefix, actual)
+	}
+	return nil
+}
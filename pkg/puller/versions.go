@@ -0,0 +1,86 @@
+package puller
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/ratelimit"
+	helmRepo "helm.sh/helm/v3/pkg/repo"
+)
+
+// GetTags returns the commit that each tag of the repository points to
+func (r GithubRepository) GetTags() (map[string]string, error) {
+	cloneOptions, err := r.getCloneOptions(r.useSSH())
+	if err != nil {
+		return nil, err
+	}
+	return getRemoteTags(githubHost, cloneOptions.URL, cloneOptions.Auth)
+}
+
+// GetTags returns the commit that each tag of the repository points to
+func (r GitRepository) GetTags() (map[string]string, error) {
+	cloneOptions, err := r.getCloneOptions(r.useSSH())
+	if err != nil {
+		return nil, err
+	}
+	return getRemoteTags(r.endpoint.Host, cloneOptions.URL, cloneOptions.Auth)
+}
+
+// getRemoteTags lists the tags advertised by the Git repository at url without cloning it
+// Annotated tags are peeled so that every tag maps to the commit it points to
+func getRemoteTags(host, url string, auth transport.AuthMethod) (map[string]string, error) {
+	endpoint, err := transport.NewEndpoint(url)
+	if err != nil {
+		return nil, err
+	}
+	c, err := client.NewClient(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer ratelimit.Acquire(host)()
+	session, err := c.NewUploadPackSession(endpoint, auth)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to connect to %s: %s", url, err)
+	}
+	defer session.Close()
+	advertisedRefs, err := session.AdvertisedReferences()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list references of %s: %s", url, err)
+	}
+	tags := make(map[string]string)
+	for name, hash := range advertisedRefs.References {
+		refName := plumbing.ReferenceName(name)
+		if !refName.IsTag() {
+			continue
+		}
+		commit := hash
+		if peeled, ok := advertisedRefs.Peeled[name]; ok {
+			commit = peeled
+		}
+		tags[strings.TrimPrefix(name, "refs/tags/")] = commit.String()
+	}
+	return tags, nil
+}
+
+// GetVersions returns every version of the chart listed in the index.yaml of the Helm repository
+func (u HelmRepository) GetVersions(fs billy.Filesystem) ([]string, error) {
+	indexURL := fmt.Sprintf("%s/%s", strings.TrimSuffix(u.URL, "/"), helmRepoIndexFilepath)
+	if err := filesystem.GetChartArchive(fs, indexURL, helmRepoIndexFilepath); err != nil {
+		return nil, fmt.Errorf("Unable to get index.yaml from Helm repository %s: %s", u.URL, err)
+	}
+	defer fs.Remove(helmRepoIndexFilepath)
+	indexFile, err := helmRepo.LoadIndexFile(filesystem.GetAbsPath(fs, helmRepoIndexFilepath))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load index.yaml from Helm repository %s: %s", u.URL, err)
+	}
+	var versions []string
+	for _, chartVersion := range indexFile.Entries[u.ChartName] {
+		versions = append(versions, chartVersion.Version)
+	}
+	return versions, nil
+}
@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// MaxConcurrentPerHost represents the maximum number of fetches from a single host that may be in progress at once. If 0, fetches are not limited
+	MaxConcurrentPerHost = 4
+	// MinIntervalPerHost represents the minimum duration between starting two fetches from a single host. If 0, fetches are started immediately
+	MinIntervalPerHost time.Duration
+
+	hostLimiters     = make(map[string]*hostLimiter)
+	hostLimitersLock sync.Mutex
+)
+
+// hostLimiter tracks the fetches from a single host
+type hostLimiter struct {
+	// slots holds a value for each fetch in progress
+	slots chan struct{}
+	// next is the earliest time at which the next fetch may start
+	next time.Time
+	// lock guards next
+	lock sync.Mutex
+}
+
+// Acquire blocks until a fetch from the host is permitted by MaxConcurrentPerHost and MinIntervalPerHost
+// The caller must call the returned function once the fetch is complete
+func Acquire(host string) (release func()) {
+	limiter := getHostLimiter(host)
+	if limiter.slots != nil {
+		select {
+		case limiter.slots <- struct{}{}:
+		default:
+			logrus.Debugf("Waiting for one of %d fetches from %s to complete", cap(limiter.slots), host)
+			limiter.slots <- struct{}{}
+		}
+	}
+	if MinIntervalPerHost > 0 {
+		limiter.lock.Lock()
+		now := time.Now()
+		start := limiter.next
+		if start.Before(now) {
+			start = now
+		}
+		limiter.next = start.Add(MinIntervalPerHost)
+		limiter.lock.Unlock()
+		time.Sleep(time.Until(start))
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if limiter.slots != nil {
+				<-limiter.slots
+			}
+		})
+	}
+}
+
+// getHostLimiter returns the limiter for the host, creating it if this is the first fetch from the host
+func getHostLimiter(host string) *hostLimiter {
+	hostLimitersLock.Lock()
+	defer hostLimitersLock.Unlock()
+	limiter, ok := hostLimiters[host]
+	if !ok {
+		limiter = &hostLimiter{}
+		if MaxConcurrentPerHost > 0 {
+			limiter.slots = make(chan struct{}, MaxConcurrentPerHost)
+		}
+		hostLimiters[host] = limiter
+	}
+	return limiter
+}
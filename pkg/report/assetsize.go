@@ -0,0 +1,192 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/cache"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/helm"
+	"github.com/rancher/charts-build-scripts/pkg/path"
+	helmChartutil "helm.sh/helm/v3/pkg/chartutil"
+)
+
+const (
+	// AssetGrowthOverrideAnnotation is an annotation on a chart version that explains why its asset is allowed to grow beyond the configured threshold
+	AssetGrowthOverrideAnnotation = "catalog.cattle.io/allow-asset-growth"
+)
+
+// AssetSize represents the size of the asset of a chart version compared to that of the previous version of the chart
+type AssetSize struct {
+	// Chart is the path to the chart within the charts directory, i.e. {package}/{chart}
+	Chart string
+	// Version is the version of the chart
+	Version string
+	// Size is the size of the asset of Version in bytes
+	Size int64
+	// PreviousVersion is the latest version of the chart that precedes Version, if any
+	PreviousVersion string
+	// PreviousSize is the size of the asset of PreviousVersion in bytes
+	PreviousSize int64
+	// Override is the value of the AssetGrowthOverrideAnnotation on Version, if set
+	Override string
+}
+
+// Delta returns the difference in bytes between the size of the asset and that of the previous version
+func (a AssetSize) Delta() int64 {
+	if len(a.PreviousVersion) == 0 {
+		return 0
+	}
+	return a.Size - a.PreviousSize
+}
+
+// Growth returns the growth of the asset relative to the previous version as a percentage
+func (a AssetSize) Growth() float64 {
+	if len(a.PreviousVersion) == 0 || a.PreviousSize == 0 {
+		return 0
+	}
+	return float64(a.Delta()) * 100 / float64(a.PreviousSize)
+}
+
+// GetAssetSizes returns the AssetSize of every chart version in the repository that is not part of previousChartVersions
+// If previousChartVersions is nil, the AssetSize of the latest version of every chart is returned instead
+func GetAssetSizes(rootFs billy.Filesystem, previousChartVersions ChartVersions) ([]AssetSize, error) {
+	currentChartVersions, err := GetChartVersions(rootFs)
+	if err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to get current chart versions: %s", err)
+	}
+	var assetSizes []AssetSize
+	for chart, versions := range currentChartVersions {
+		versions = sortVersions(versions)
+		for i, version := range versions {
+			if previousChartVersions == nil && i != len(versions)-1 {
+				continue
+			}
+			if previousChartVersions != nil && contains(previousChartVersions[chart], version) {
+				continue
+			}
+			assetSize := AssetSize{
+				Chart:   chart,
+				Version: version,
+			}
+			assetSize.Size, err = getAssetSize(rootFs, chart, version)
+			if err != nil {
+				return nil, err
+			}
+			if i > 0 {
+				assetSize.PreviousVersion = versions[i-1]
+				assetSize.PreviousSize, err = getAssetSize(rootFs, chart, assetSize.PreviousVersion)
+				if err != nil {
+					return nil, err
+				}
+			}
+			assetSize.Override, err = getAssetGrowthOverride(rootFs, chart, version)
+			if err != nil {
+				return nil, err
+			}
+			assetSizes = append(assetSizes, assetSize)
+		}
+	}
+	sort.Slice(assetSizes, func(i, j int) bool {
+		if assetSizes[i].Chart != assetSizes[j].Chart {
+			return assetSizes[i].Chart < assetSizes[j].Chart
+		}
+		return assetSizes[i].Version < assetSizes[j].Version
+	})
+	return assetSizes, nil
+}
+
+// sortVersions returns the chart versions sorted from the oldest to the newest, ignoring any that are not valid semantic versions
+func sortVersions(versions []string) []string {
+	parsed := make(map[string]*semver.Version, len(versions))
+	var sorted []string
+	for _, version := range versions {
+		v, err := semver.NewVersion(version)
+		if err != nil {
+			continue
+		}
+		parsed[version] = v
+		sorted = append(sorted, version)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return parsed[sorted[i]].LessThan(parsed[sorted[j]])
+	})
+	return sorted
+}
+
+// contains returns whether the version is one of the versions
+func contains(versions []string, version string) bool {
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// getAssetSize returns the size in bytes of the archive of the chart version within the assets directory
+func getAssetSize(rootFs billy.Filesystem, chart, version string) (int64, error) {
+	packageName, chartName := filepath.Split(chart)
+	assetPath := helm.GetAssetPath(filepath.Join(path.RepositoryAssetsDir, packageName), chartName, version)
+	info, err := rootFs.Stat(assetPath)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to get size of asset %s: %s", assetPath, err)
+	}
+	return info.Size(), nil
+}
+
+// getAssetGrowthOverride returns the value of the AssetGrowthOverrideAnnotation on the chart version, if any
+func getAssetGrowthOverride(rootFs billy.Filesystem, chart, version string) (string, error) {
+	chartYamlPath := filepath.Join(getChartPath(chart, version), "Chart.yaml")
+	metadata, err := helmChartutil.LoadChartfile(filesystem.GetAbsPath(rootFs, chartYamlPath))
+	if err != nil {
+		return "", fmt.Errorf("Unable to load %s: %s", chartYamlPath, err)
+	}
+	return metadata.Annotations[AssetGrowthOverrideAnnotation], nil
+}
+
+// CheckAssetGrowth returns a message for every asset that grew by more than maxGrowthPercent relative to the previous version of its chart
+// unless the chart version explains why with the AssetGrowthOverrideAnnotation
+func CheckAssetGrowth(assetSizes []AssetSize, maxGrowthPercent float64) []string {
+	var messages []string
+	for _, a := range assetSizes {
+		if len(a.Override) > 0 || a.Growth() <= maxGrowthPercent {
+			continue
+		}
+		messages = append(messages, fmt.Sprintf("%s/%s: asset grew by %.1f%% from %s in %s to %s, which exceeds the maximum growth of %.1f%%. Add the %s annotation to the chart to allow it",
+			a.Chart, a.Version, a.Growth(), cache.FormatSize(a.PreviousSize), a.PreviousVersion, cache.FormatSize(a.Size), maxGrowthPercent, AssetGrowthOverrideAnnotation))
+	}
+	return messages
+}
+
+// WriteAssetSizes writes the asset sizes as a table
+func WriteAssetSizes(w io.Writer, assetSizes []AssetSize) error {
+	if len(assetSizes) == 0 {
+		_, err := fmt.Fprintln(w, "No chart assets found")
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHART\tVERSION\tSIZE\tPREVIOUS VERSION\tPREVIOUS SIZE\tDELTA\tGROWTH\tOVERRIDE")
+	for _, a := range assetSizes {
+		previousSize, delta, growth := "-", "-", "-"
+		if len(a.PreviousVersion) > 0 {
+			previousSize = cache.FormatSize(a.PreviousSize)
+			delta = cache.FormatSize(a.Delta())
+			if a.Delta() < 0 {
+				delta = fmt.Sprintf("-%s", cache.FormatSize(-a.Delta()))
+			} else {
+				delta = fmt.Sprintf("+%s", delta)
+			}
+			growth = fmt.Sprintf("%+.1f%%", a.Growth())
+		}
+		row := []string{a.Chart, a.Version, cache.FormatSize(a.Size), orDash(a.PreviousVersion), previousSize, delta, growth, orDash(a.Override)}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,347 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/charts"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/helm"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	"github.com/rancher/charts-build-scripts/pkg/path"
+	"github.com/rancher/charts-build-scripts/pkg/transient"
+	"github.com/sirupsen/logrus"
+	helmChartutil "helm.sh/helm/v3/pkg/chartutil"
+	helmProvenance "helm.sh/helm/v3/pkg/provenance"
+	helmRepo "helm.sh/helm/v3/pkg/repo"
+)
+
+const (
+	// auditStagingDirPrefix is the prefix of the directory within the repository that assets are unarchived into while they are audited
+	auditStagingDirPrefix = ".audit-"
+
+	// AuditCheckStamping identifies findings about how an asset was named, indexed, and unarchived into the charts directory
+	AuditCheckStamping = "stamping"
+	// AuditCheckAPIVersion identifies findings about charts that still use apiVersion v1
+	AuditCheckAPIVersion = "api-version"
+	// AuditCheckContentPolicy identifies violations of the content policy
+	AuditCheckContentPolicy = "content-policy"
+	// AuditCheckSupportTier identifies violations of the rules of the support tier that a chart was released with
+	AuditCheckSupportTier = "support-tier"
+	// AuditCheckValuesLint identifies violations of the values lint rules
+	AuditCheckValuesLint = "values-lint"
+	// AuditCheckTemplateFunctions identifies calls to template functions that are not allowed
+	AuditCheckTemplateFunctions = "template-functions"
+	// AuditCheckHelmLint identifies failures reported by helm lint
+	AuditCheckHelmLint = "helm-lint"
+	// AuditCheckSecrets identifies hardcoded credentials in rendered manifests
+	AuditCheckSecrets = "secrets"
+	// AuditCheckManifests identifies rendered manifests that do not match the Kubernetes schemas
+	AuditCheckManifests = "manifests"
+	// AuditCheckRender identifies failures to render a chart against a render profile
+	AuditCheckRender = "render"
+)
+
+// Audit summarizes the released chart versions that do not comply with the current validation and policy checks
+type Audit struct {
+	// Assets is the number of assets that were audited
+	Assets int `json:"assets"`
+	// Findings are the violations found within the assets, ordered by owner and asset
+	Findings []AuditFinding `json:"findings"`
+}
+
+// AuditFinding represents a violation of a validation or policy check by a released chart version
+type AuditFinding struct {
+	// Asset is the path to the chart archive within the repository
+	Asset string `json:"asset"`
+	// Package is the name of the package that released the chart
+	Package string `json:"package"`
+	// Owner is the owner of the package, as declared in its package.yaml on the current branch
+	Owner string `json:"owner"`
+	// Chart is the name of the chart, as found in its Chart.yaml
+	Chart string `json:"chart"`
+	// Version is the version of the chart, as found in its Chart.yaml
+	Version string `json:"version"`
+	// Check identifies the check that was violated
+	Check string `json:"check"`
+	// Message describes the violation
+	Message string `json:"message"`
+}
+
+// NonCompliantVersions returns the number of chart versions with at least one finding
+func (a Audit) NonCompliantVersions() int {
+	assets := make(map[string]bool)
+	for _, f := range a.Findings {
+		assets[f.Asset] = true
+	}
+	return len(assets)
+}
+
+// GetAudit unarchives every asset found in the assets directory of the repository without rebuilding it and runs the checks configured in
+// chartsScriptOptions against it, along with checks that the asset was stamped consistently into the charts directory and the Helm index
+// Owners and suppressions are looked up from the packages of the current branch, so assets of removed packages are audited without suppressions
+func GetAudit(repoRoot string, chartsScriptOptions *options.ChartsScriptOptions, packages []*charts.Package) (Audit, error) {
+	audit := Audit{}
+	rootFs := filesystem.GetFilesystem(repoRoot)
+	packagesByName := make(map[string]*charts.Package, len(packages))
+	for _, p := range packages {
+		packagesByName[p.Name] = p
+	}
+	var helmIndexFile *helmRepo.IndexFile
+	exists, err := filesystem.PathExists(rootFs, path.RepositoryHelmIndexFile)
+	if err != nil {
+		return audit, err
+	}
+	if exists {
+		helmIndexFile, err = helmRepo.LoadIndexFile(filesystem.GetAbsPath(rootFs, path.RepositoryHelmIndexFile))
+		if err != nil {
+			return audit, fmt.Errorf("Encountered error while trying to load existing index file: %s", err)
+		}
+	}
+	assets, err := getAssets(rootFs)
+	if err != nil {
+		return audit, fmt.Errorf("Encountered error while trying to list assets: %s", err)
+	}
+	absStagingDir, err := ioutil.TempDir(repoRoot, auditStagingDirPrefix)
+	if err != nil {
+		return audit, fmt.Errorf("Failed to create staging directory for audit: %s", err)
+	}
+	stagingDir := filepath.Base(absStagingDir)
+	if err := transient.Track(rootFs, stagingDir); err != nil {
+		return audit, err
+	}
+	defer transient.Remove(rootFs, stagingDir)
+	for _, asset := range assets {
+		logrus.Infof("Auditing %s", asset)
+		packageName := strings.Split(filepath.ToSlash(asset), "/")[1]
+		findings, err := auditAsset(rootFs, asset, filepath.Join(stagingDir, "chart"), helmIndexFile, chartsScriptOptions, packageName, packagesByName[packageName])
+		if err != nil {
+			return audit, fmt.Errorf("Encountered error while auditing %s: %s", asset, err)
+		}
+		audit.Findings = append(audit.Findings, findings...)
+		audit.Assets++
+	}
+	sort.SliceStable(audit.Findings, func(i, j int) bool {
+		if audit.Findings[i].Owner != audit.Findings[j].Owner {
+			return audit.Findings[i].Owner < audit.Findings[j].Owner
+		}
+		return audit.Findings[i].Asset < audit.Findings[j].Asset
+	})
+	return audit, nil
+}
+
+// getAssets returns the path to every chart archive within the assets directory of each package in the repository, at any depth
+func getAssets(rootFs billy.Filesystem) ([]string, error) {
+	var assets []string
+	exists, err := filesystem.PathExists(rootFs, path.RepositoryAssetsDir)
+	if err != nil || !exists {
+		return nil, err
+	}
+	packageInfos, err := rootFs.ReadDir(path.RepositoryAssetsDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, packageInfo := range packageInfos {
+		if !packageInfo.IsDir() {
+			continue
+		}
+		err := filesystem.WalkDir(rootFs, filepath.Join(path.RepositoryAssetsDir, packageInfo.Name()), func(fs billy.Filesystem, assetPath string, isDir bool) error {
+			if !isDir && filepath.Ext(assetPath) == ".tgz" {
+				assets = append(assets, assetPath)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return assets, nil
+}
+
+// auditAsset unarchives the asset of the package into helmChartPath and returns the findings of every check run against it
+// p is the package on the current branch, or nil if the package no longer exists
+func auditAsset(rootFs billy.Filesystem, asset, helmChartPath string, helmIndexFile *helmRepo.IndexFile, chartsScriptOptions *options.ChartsScriptOptions, packageName string, p *charts.Package) ([]AuditFinding, error) {
+	if err := filesystem.UnarchiveTgz(rootFs, asset, "", helmChartPath, true); err != nil {
+		return nil, fmt.Errorf("Unable to unarchive asset: %s", err)
+	}
+	defer filesystem.RemoveAll(rootFs, helmChartPath)
+	var owner string
+	var valuesLintSuppressions []options.ValuesLintSuppression
+	var helmLintSuppressions []options.HelmLintSuppression
+	var secretScanSuppressions []options.SecretScanSuppression
+	if p != nil {
+		owner = p.Owner
+		valuesLintSuppressions = p.ValuesLintSuppressions
+		helmLintSuppressions = p.HelmLintSuppressions
+		secretScanSuppressions = p.SecretScanSuppressions
+	}
+	reportedOwner := owner
+	if len(reportedOwner) == 0 {
+		reportedOwner = unownedPackage
+	}
+	chartMetadata, err := helmChartutil.LoadChartfile(filesystem.GetAbsPath(rootFs, filepath.Join(helmChartPath, helmChartutil.ChartfileName)))
+	if err != nil {
+		return nil, fmt.Errorf("Could not load %s: %s", helmChartutil.ChartfileName, err)
+	}
+	// The support tier is stamped onto the chart on export, so the chart is held to the tier it was released with
+	supportTier := chartMetadata.Annotations[helm.SupportTierAnnotation]
+	var findings []AuditFinding
+	addFinding := func(check, message string) {
+		findings = append(findings, AuditFinding{
+			Asset:   asset,
+			Package: packageName,
+			Owner:   reportedOwner,
+			Chart:   chartMetadata.Name,
+			Version: chartMetadata.Version,
+			Check:   check,
+			Message: message,
+		})
+	}
+	stampingMessages, err := checkStamping(rootFs, asset, packageName, chartMetadata.Name, chartMetadata.Version, helmIndexFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, message := range stampingMessages {
+		addFinding(AuditCheckStamping, message)
+	}
+	isV1, err := helm.IsAPIVersionV1Chart(rootFs, helmChartPath)
+	if err != nil {
+		return nil, err
+	}
+	if isV1 {
+		addFinding(AuditCheckAPIVersion, "chart uses apiVersion v1 and must be migrated to apiVersion v2")
+	}
+	contentPolicyViolations, err := helm.CheckContentPolicy(rootFs, helmChartPath, chartsScriptOptions.ContentPolicyOptions)
+	if err != nil {
+		return nil, err
+	}
+	for _, violation := range contentPolicyViolations {
+		addFinding(AuditCheckContentPolicy, violation.String())
+	}
+	supportTierViolations, err := helm.CheckSupportTier(rootFs, helmChartPath, supportTier, owner, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, violation := range supportTierViolations {
+		addFinding(AuditCheckSupportTier, violation)
+	}
+	if chartsScriptOptions.ValuesLintOptions != (options.ValuesLintOptions{}) {
+		violations, err := helm.LintValuesFile(rootFs, helmChartPath, chartsScriptOptions.ValuesLintOptions, valuesLintSuppressions)
+		if err != nil {
+			return nil, err
+		}
+		for _, violation := range violations {
+			addFinding(AuditCheckValuesLint, violation.String())
+		}
+	}
+	templateFunctionOptions := chartsScriptOptions.TemplateFunctionOptions
+	if len(templateFunctionOptions.Allowed) > 0 || len(templateFunctionOptions.Forbidden) > 0 {
+		violations, err := helm.CheckTemplateFunctions(rootFs, helmChartPath, templateFunctionOptions)
+		if err != nil {
+			return nil, err
+		}
+		for _, violation := range violations {
+			addFinding(AuditCheckTemplateFunctions, violation.String())
+		}
+	}
+	if chartsScriptOptions.HelmLintOptions.Enabled {
+		for _, message := range helm.LintChart(rootFs, helmChartPath, chartsScriptOptions.HelmLintOptions.Strict, helmLintSuppressions) {
+			if message.Failure {
+				addFinding(AuditCheckHelmLint, fmt.Sprintf("%s: %s", strings.TrimSuffix(message.File, "/"), message.Message))
+			}
+		}
+	}
+	if len(chartsScriptOptions.SecretScanOptions.Policy) > 0 {
+		secrets, err := helm.FindHardcodedSecrets(rootFs, helmChartPath, secretScanSuppressions)
+		if err != nil {
+			return nil, err
+		}
+		for _, secret := range secrets {
+			addFinding(AuditCheckSecrets, secret.String())
+		}
+	}
+	if len(chartsScriptOptions.ManifestValidationOptions.KubeVersions) > 0 {
+		violations, err := helm.ValidateManifests(rootFs, helmChartPath, chartsScriptOptions.ManifestValidationOptions)
+		if err != nil {
+			return nil, err
+		}
+		for _, violation := range violations {
+			addFinding(AuditCheckManifests, fmt.Sprintf("%s: %s against Kubernetes %s: %s", violation.Template, violation.Resource, violation.KubeVersion, violation.Message))
+		}
+	}
+	for _, profile := range chartsScriptOptions.RenderProfiles {
+		if err := helm.RenderChart(rootFs, helmChartPath, profile); err != nil {
+			addFinding(AuditCheckRender, fmt.Sprintf("against Kubernetes %s: %s", profile.KubeVersion, err))
+		}
+	}
+	return findings, nil
+}
+
+// checkStamping returns messages describing how the asset of the chart version deviates from the name, Helm index entry, and charts directory that exporting it produces
+func checkStamping(rootFs billy.Filesystem, asset, packageName, chartName, version string, helmIndexFile *helmRepo.IndexFile) ([]string, error) {
+	var messages []string
+	if expected := helm.GetAssetPath(filepath.Join(path.RepositoryAssetsDir, packageName), chartName, version); asset != expected {
+		messages = append(messages, fmt.Sprintf("asset should be placed at %s after the name and version in its %s", expected, helmChartutil.ChartfileName))
+	}
+	if _, err := semver.NewVersion(version); err != nil {
+		messages = append(messages, fmt.Sprintf("version %s is not a valid semantic version: %s", version, err))
+	}
+	chartPath := helm.GetChartPath(filepath.Join(path.RepositoryChartsDir, packageName), chartName, version)
+	exists, err := filesystem.PathExists(rootFs, chartPath)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		messages = append(messages, fmt.Sprintf("asset was not unarchived into %s", chartPath))
+	}
+	if helmIndexFile == nil {
+		return append(messages, fmt.Sprintf("asset is not listed in %s", path.RepositoryHelmIndexFile)), nil
+	}
+	entry, err := helmIndexFile.Get(chartName, version)
+	if err != nil {
+		return append(messages, fmt.Sprintf("asset is not listed in %s", path.RepositoryHelmIndexFile)), nil
+	}
+	digest, err := helmProvenance.DigestFile(filesystem.GetAbsPath(rootFs, asset))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to compute digest of %s: %s", asset, err)
+	}
+	if entry.Digest != digest {
+		messages = append(messages, fmt.Sprintf("digest of asset does not match the digest listed in %s", path.RepositoryHelmIndexFile))
+	}
+	return messages, nil
+}
+
+// WriteAudit writes the Audit as a table for each owner, or as JSON if asJSON is set
+func WriteAudit(w io.Writer, audit Audit, asJSON bool) error {
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(audit)
+	}
+	if len(audit.Findings) == 0 {
+		_, err := fmt.Fprintf(w, "All %d assets comply with the current checks\n", audit.Assets)
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	var owner string
+	for i, f := range audit.Findings {
+		if i == 0 || f.Owner != owner {
+			owner = f.Owner
+			if i > 0 {
+				fmt.Fprintln(tw)
+			}
+			fmt.Fprintf(tw, "Owner: %s\n", owner)
+			fmt.Fprintln(tw, "ASSET\tCHECK\tMESSAGE")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", f.Asset, f.Check, f.Message)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,197 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/charts"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	"github.com/rancher/charts-build-scripts/pkg/puller"
+	helmChartutil "helm.sh/helm/v3/pkg/chartutil"
+)
+
+const (
+	// currentUpstreamDir is the directory within the temporary directory that the current upstream is pulled into
+	currentUpstreamDir = "current"
+	// proposedUpstreamDir is the directory within the temporary directory that the upstream at the proposed commit is pulled into
+	proposedUpstreamDir = "proposed"
+)
+
+// BlastRadius represents everything that will be affected by a change to a package
+type BlastRadius struct {
+	// Package is the name of the package being changed
+	Package string
+	// CurrentUpstream is the upstream that the main chart of the package currently points to
+	CurrentUpstream string
+	// ProposedCommit is the new upstream commit being proposed, if any
+	ProposedCommit string
+	// UpstreamVersions are the versions of the upstream chart at the current and proposed commit, if a commit is proposed and the version changes
+	UpstreamVersions []string
+	// ChangedFiles are the files of the upstream chart that differ between the current and proposed commit, if a commit is proposed
+	ChangedFiles []string
+	// WorkingDirs are the working directories of all charts within the package that will be regenerated
+	WorkingDirs []string
+	// ExportedCharts are the charts currently exported by this package, along with their latest version
+	ExportedCharts []string
+	// Branches are the branches that this branch syncs with or validates against, which will observe the change
+	Branches []string
+	// DependentPackages are the packages that use this package as an upstream or a dependency and will change as a result
+	DependentPackages []string
+}
+
+// GetBlastRadius computes the BlastRadius of changing the package p to point to proposedCommit
+// If a commit is proposed, the upstream of the main chart is compared against it and nothing is affected if the upstream chart does not change
+func GetBlastRadius(rootFs billy.Filesystem, p *charts.Package, packages []*charts.Package, chartsScriptOptions *options.ChartsScriptOptions, proposedCommit string) (BlastRadius, error) {
+	blastRadius := BlastRadius{
+		Package:         p.Name,
+		CurrentUpstream: fmt.Sprint(p.Chart.Upstream),
+		ProposedCommit:  proposedCommit,
+		WorkingDirs:     []string{p.Chart.WorkingDir},
+	}
+	if len(proposedCommit) > 0 {
+		if err := diffProposedCommit(rootFs, p, proposedCommit, &blastRadius); err != nil {
+			return blastRadius, fmt.Errorf("Encountered error while comparing the upstream of package %s against commit %s: %s", p.Name, proposedCommit, err)
+		}
+		if len(blastRadius.ChangedFiles) == 0 {
+			// Nothing is affected if the proposed commit does not change the upstream chart
+			blastRadius.WorkingDirs = nil
+			return blastRadius, nil
+		}
+	}
+	for _, additionalChart := range p.AdditionalCharts {
+		blastRadius.WorkingDirs = append(blastRadius.WorkingDirs, additionalChart.WorkingDir)
+	}
+	chartVersions, err := GetChartVersions(rootFs)
+	if err != nil {
+		return blastRadius, fmt.Errorf("Encountered error while trying to get exported chart versions: %s", err)
+	}
+	for chart, versions := range chartVersions {
+		if !strings.HasPrefix(chart, p.Name+"/") {
+			continue
+		}
+		blastRadius.ExportedCharts = append(blastRadius.ExportedCharts, fmt.Sprintf("%s (latest: %s)", filepath.Base(chart), getLatestVersion(versions)))
+	}
+	sort.Strings(blastRadius.ExportedCharts)
+	if chartsScriptOptions != nil {
+		branchSet := make(map[string]bool)
+		for _, compareGeneratedAssetsOptions := range append(chartsScriptOptions.SyncOptions, chartsScriptOptions.ValidateOptions...) {
+			branchSet[compareGeneratedAssetsOptions.Branch] = true
+		}
+		for branch := range branchSet {
+			blastRadius.Branches = append(blastRadius.Branches, branch)
+		}
+		sort.Strings(blastRadius.Branches)
+	}
+	blastRadius.DependentPackages, err = charts.GetDependentPackages(packages, p.Name)
+	if err != nil {
+		return blastRadius, err
+	}
+	return blastRadius, nil
+}
+
+// diffProposedCommit pulls the upstream of the main chart of the package at its current commit and at proposedCommit and records the files and version that differ in the BlastRadius
+func diffProposedCommit(rootFs billy.Filesystem, p *charts.Package, proposedCommit string, blastRadius *BlastRadius) error {
+	switch puller.Unwrap(p.Chart.Upstream).(type) {
+	case puller.GithubRepository, puller.GitRepository, puller.GitBundle:
+	default:
+		return fmt.Errorf("a commit can only be proposed for an upstream that is a Git repository, found %s", p.Chart.Upstream)
+	}
+	proposedOptions := p.Chart.Upstream.GetOptions()
+	proposedOptions.Commit = &proposedCommit
+	proposedOptions.Tag = nil
+	proposedUpstream, err := charts.GetUpstream(proposedOptions)
+	if err != nil {
+		return err
+	}
+	absTempDir, err := ioutil.TempDir("", "charts-build-scripts-blast-radius-")
+	if err != nil {
+		return fmt.Errorf("Encountered error while trying to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(absTempDir)
+	tempFs := filesystem.GetFilesystem(absTempDir)
+	if err := p.Chart.Upstream.Pull(rootFs, tempFs, currentUpstreamDir); err != nil {
+		return fmt.Errorf("Encountered error while pulling %s: %s", p.Chart.Upstream, err)
+	}
+	if err := proposedUpstream.Pull(rootFs, tempFs, proposedUpstreamDir); err != nil {
+		return fmt.Errorf("Encountered error while pulling %s: %s", proposedUpstream, err)
+	}
+	addChangedFile := func(upstreamDir string) filesystem.RelativePathFunc {
+		return func(fs billy.Filesystem, upstreamPath string, isDir bool) error {
+			if !isDir {
+				blastRadius.ChangedFiles = append(blastRadius.ChangedFiles, strings.TrimPrefix(upstreamPath, upstreamDir+"/"))
+			}
+			return nil
+		}
+	}
+	compare := func(fs billy.Filesystem, currentPath, proposedPath string, isDir bool) error {
+		if isDir {
+			return nil
+		}
+		currentBytes, err := ioutil.ReadFile(filesystem.GetAbsPath(fs, currentPath))
+		if err != nil {
+			return err
+		}
+		proposedBytes, err := ioutil.ReadFile(filesystem.GetAbsPath(fs, proposedPath))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(currentBytes, proposedBytes) {
+			blastRadius.ChangedFiles = append(blastRadius.ChangedFiles, strings.TrimPrefix(currentPath, currentUpstreamDir+"/"))
+		}
+		return nil
+	}
+	if err := filesystem.CompareDirs(tempFs, currentUpstreamDir, proposedUpstreamDir, addChangedFile(currentUpstreamDir), addChangedFile(proposedUpstreamDir), compare); err != nil {
+		return err
+	}
+	sort.Strings(blastRadius.ChangedFiles)
+	var versions []string
+	for _, upstreamDir := range []string{currentUpstreamDir, proposedUpstreamDir} {
+		metadata, err := helmChartutil.LoadChartfile(filesystem.GetAbsPath(tempFs, filepath.Join(upstreamDir, helmChartutil.ChartfileName)))
+		if err != nil {
+			return fmt.Errorf("Unable to read the Chart.yaml of the upstream chart: %s", err)
+		}
+		versions = append(versions, metadata.Version)
+	}
+	if versions[0] != versions[1] {
+		blastRadius.UpstreamVersions = versions
+	}
+	return nil
+}
+
+func (b BlastRadius) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Blast radius of changing package %s\n", b.Package)
+	fmt.Fprintf(&sb, "  Current upstream: %s\n", b.CurrentUpstream)
+	if len(b.ProposedCommit) > 0 {
+		fmt.Fprintf(&sb, "  Proposed commit: %s\n", b.ProposedCommit)
+		if len(b.UpstreamVersions) == 2 {
+			fmt.Fprintf(&sb, "  Upstream chart version: %s -> %s\n", b.UpstreamVersions[0], b.UpstreamVersions[1])
+		}
+		writeList(&sb, "Upstream files changed by the proposed commit", b.ChangedFiles)
+	}
+	writeList(&sb, "Charts that will be regenerated", b.WorkingDirs)
+	writeList(&sb, "Exported charts that will get new versions", b.ExportedCharts)
+	writeList(&sb, "Branches that will observe the change", b.Branches)
+	writeList(&sb, "Dependent packages that will change", b.DependentPackages)
+	return sb.String()
+}
+
+// writeList writes a titled list of items to the builder
+func writeList(sb *strings.Builder, title string, items []string) {
+	fmt.Fprintf(sb, "  %s:", title)
+	if len(items) == 0 {
+		fmt.Fprintf(sb, " none\n")
+		return
+	}
+	fmt.Fprintf(sb, "\n")
+	for _, item := range items {
+		fmt.Fprintf(sb, "    - %s\n", item)
+	}
+}
@@ -0,0 +1,285 @@
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/cache"
+	"github.com/rancher/charts-build-scripts/pkg/diff"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/helm"
+	"github.com/rancher/charts-build-scripts/pkg/path"
+	"github.com/sirupsen/logrus"
+	helmChartutil "helm.sh/helm/v3/pkg/chartutil"
+)
+
+// ChartVersions maps a chart's path within the charts directory (i.e. {package}/{chart}) to all versions of that chart
+type ChartVersions map[string][]string
+
+// ChartVersionReport summarizes the changes introduced by a newly produced chart version
+type ChartVersionReport struct {
+	// Chart is the path to the chart within the charts directory, i.e. {package}/{chart}
+	Chart string
+	// Version is the newly produced version of the chart
+	Version string
+	// PreviousVersion is the latest version of this chart that existed prior to producing Version, if any
+	PreviousVersion string
+	// TemplatesDiff is the unified diff of the templates directory against the previous version
+	TemplatesDiff string
+	// ValuesDiff is the unified diff of the values.yaml against the previous version
+	ValuesDiff string
+	// AddedImages are the images referenced in the values.yaml of this version but not in that of the previous version
+	AddedImages []string
+	// RemovedImages are the images referenced in the values.yaml of the previous version but not in that of this version
+	RemovedImages []string
+}
+
+// GetChartVersions returns all chart versions currently found in the charts directory of the repository
+func GetChartVersions(rootFs billy.Filesystem) (ChartVersions, error) {
+	chartVersions := make(ChartVersions)
+	exists, err := filesystem.PathExists(rootFs, path.RepositoryChartsDir)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return chartVersions, nil
+	}
+	packageInfos, err := rootFs.ReadDir(path.RepositoryChartsDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, packageInfo := range packageInfos {
+		if !packageInfo.IsDir() {
+			continue
+		}
+		exportedCharts, err := helm.GetExportedCharts(rootFs, filepath.Join(path.RepositoryChartsDir, packageInfo.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, exportedChart := range exportedCharts {
+			chart := filepath.Join(packageInfo.Name(), exportedChart.Name)
+			chartVersions[chart] = append(chartVersions[chart], exportedChart.Version)
+		}
+	}
+	return chartVersions, nil
+}
+
+// GenerateChartVersionReports returns a report for every chart version currently in the repository that is not part of previousChartVersions
+func GenerateChartVersionReports(rootFs billy.Filesystem, previousChartVersions ChartVersions) ([]ChartVersionReport, error) {
+	currentChartVersions, err := GetChartVersions(rootFs)
+	if err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to get current chart versions: %s", err)
+	}
+	var reports []ChartVersionReport
+	for chart, versions := range currentChartVersions {
+		previousVersionSet := make(map[string]bool)
+		for _, version := range previousChartVersions[chart] {
+			previousVersionSet[version] = true
+		}
+		previousVersion := getLatestVersion(previousChartVersions[chart])
+		for _, version := range versions {
+			if previousVersionSet[version] {
+				continue
+			}
+			report, err := generateChartVersionReport(rootFs, chart, version, previousVersion)
+			if err != nil {
+				return nil, fmt.Errorf("Encountered error while generating report for %s/%s: %s", chart, version, err)
+			}
+			reports = append(reports, report)
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].Chart != reports[j].Chart {
+			return reports[i].Chart < reports[j].Chart
+		}
+		return reports[i].Version < reports[j].Version
+	})
+	return reports, nil
+}
+
+// generateChartVersionReport generates a report on the changes between previousVersion and version of a chart
+func generateChartVersionReport(rootFs billy.Filesystem, chart, version, previousVersion string) (ChartVersionReport, error) {
+	report := ChartVersionReport{
+		Chart:           chart,
+		Version:         version,
+		PreviousVersion: previousVersion,
+	}
+	chartPath := getChartPath(chart, version)
+	images, err := getImages(rootFs, chartPath)
+	if err != nil {
+		return report, err
+	}
+	if len(previousVersion) == 0 {
+		report.AddedImages = images
+		return report, nil
+	}
+	previousChartPath := getChartPath(chart, previousVersion)
+	previousImages, err := getImages(rootFs, previousChartPath)
+	if err != nil {
+		return report, err
+	}
+	report.AddedImages = difference(images, previousImages)
+	report.RemovedImages = difference(previousImages, images)
+	report.TemplatesDiff, err = diff.GenerateDiff(rootFs, filepath.Join(previousChartPath, path.ChartTemplatesDir), filepath.Join(chartPath, path.ChartTemplatesDir))
+	if err != nil {
+		return report, err
+	}
+	report.ValuesDiff, err = diff.GenerateDiff(rootFs, filepath.Join(previousChartPath, path.ChartValuesFile), filepath.Join(chartPath, path.ChartValuesFile))
+	if err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// getChartPath returns the path within the repository of the chart version, where chart is given as {package}/{chart}
+func getChartPath(chart, version string) string {
+	packageName, chartName := filepath.Split(chart)
+	return helm.GetChartPath(filepath.Join(path.RepositoryChartsDir, packageName), chartName, version)
+}
+
+// getImages returns the images referenced in the values.yaml of the chart at helmChartPath
+func getImages(rootFs billy.Filesystem, helmChartPath string) ([]string, error) {
+	valuesPath := filepath.Join(helmChartPath, path.ChartValuesFile)
+	exists, err := filesystem.PathExists(rootFs, valuesPath)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, nil
+	}
+	values, err := helmChartutil.ReadValuesFile(filesystem.GetAbsPath(rootFs, valuesPath))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read values from %s: %s", valuesPath, err)
+	}
+	return helm.GetImagesFromValues(values), nil
+}
+
+// getLatestVersion returns the latest semantic version within versions or an empty string if there are no versions
+func getLatestVersion(versions []string) string {
+	var latest *semver.Version
+	var latestStr string
+	for _, version := range versions {
+		v, err := semver.NewVersion(version)
+		if err != nil {
+			logrus.Warnf("Unable to parse chart version %s: %s", version, err)
+			continue
+		}
+		if latest == nil || v.GreaterThan(latest) {
+			latest = v
+			latestStr = version
+		}
+	}
+	return latestStr
+}
+
+// difference returns all elements in a that are not in b
+func difference(a, b []string) []string {
+	bSet := make(map[string]bool, len(b))
+	for _, s := range b {
+		bSet[s] = true
+	}
+	var diff []string
+	for _, s := range a {
+		if !bSet[s] {
+			diff = append(diff, s)
+		}
+	}
+	return diff
+}
+
+// htmlReport represents the data that is rendered into the HTML report
+type htmlReport struct {
+	// Reports are the reports on each newly produced chart version
+	Reports []ChartVersionReport
+	// CacheStats are the statistics of the upstream cache at the end of the run, if a cache is in use
+	CacheStats *cache.Stats
+}
+
+// WriteHTMLReport writes an HTML report of the chart version reports and the cache statistics, if provided, to the reportPath
+func WriteHTMLReport(reports []ChartVersionReport, cacheStats *cache.Stats, reportPath string) error {
+	t := template.Must(template.New("report").Funcs(template.FuncMap{"formatSize": cache.FormatSize}).Parse(htmlReportTemplate))
+	if err := os.MkdirAll(filepath.Dir(reportPath), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(reportPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := t.Execute(f, htmlReport{Reports: reports, CacheStats: cacheStats}); err != nil {
+		return fmt.Errorf("Error while executing template for report: %s", err)
+	}
+	logrus.Infof("Generated report: %s", reportPath)
+	return nil
+}
+
+const htmlReportTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Chart Release Report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+pre { background: #f6f8fa; padding: 1em; overflow-x: auto; }
+.added { color: #22863a; }
+.removed { color: #cb2431; }
+</style>
+</head>
+<body>
+<h1>Chart Release Report</h1>
+{{- if not .Reports }}
+<p>No new chart versions were produced.</p>
+{{- end }}
+{{- range .Reports }}
+<h2>{{ .Chart }} {{ .Version }}</h2>
+{{- if .PreviousVersion }}
+<p>Compared against previous version {{ .PreviousVersion }}</p>
+{{- else }}
+<p>No previous version found; this is a new chart.</p>
+{{- end }}
+{{- if or .AddedImages .RemovedImages }}
+<h3>Image Changes</h3>
+<ul>
+{{- range .AddedImages }}
+<li class="added">+ {{ . }}</li>
+{{- end }}
+{{- range .RemovedImages }}
+<li class="removed">- {{ . }}</li>
+{{- end }}
+</ul>
+{{- end }}
+{{- if .ValuesDiff }}
+<details>
+<summary>values.yaml diff</summary>
+<pre>{{ .ValuesDiff }}</pre>
+</details>
+{{- end }}
+{{- if .TemplatesDiff }}
+<details>
+<summary>templates diff</summary>
+<pre>{{ .TemplatesDiff }}</pre>
+</details>
+{{- end }}
+{{- end }}
+{{- with .CacheStats }}
+<h2>Upstream Cache</h2>
+<ul>
+<li>Directory: {{ .Dir }}</li>
+<li>Entries: {{ .Entries }}</li>
+<li>Size: {{ formatSize .Size }}{{ if .MaxSize }} (max: {{ formatSize .MaxSize }}){{ end }}</li>
+{{- if .MaxAge }}
+<li>Entries expire after: {{ .MaxAge }}</li>
+{{- end }}
+<li>Hits this run: {{ .Hits }}</li>
+<li>Misses this run: {{ .Misses }}</li>
+<li>Evicted this run: {{ .Evicted }} ({{ formatSize .EvictedSize }})</li>
+</ul>
+{{- end }}
+</body>
+</html>
+`
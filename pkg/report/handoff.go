@@ -0,0 +1,207 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/charts"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	"github.com/rancher/charts-build-scripts/pkg/path"
+	"github.com/rancher/charts-build-scripts/pkg/puller"
+	"github.com/rancher/charts-build-scripts/pkg/transient"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// unownedPackage is the owner reported for packages that do not declare an owner in their package.yaml
+	unownedPackage = "unowned"
+)
+
+// Handoff summarizes the charts whose latest versions differ across a set of branches
+type Handoff struct {
+	// Branches are the branches that were compared, ordered from the oldest to the newest release line
+	Branches []string
+	// Charts are the charts whose latest versions differ across the branches
+	Charts []HandoffChart
+}
+
+// HandoffChart represents a chart whose latest version differs across branches
+type HandoffChart struct {
+	// Chart is the path to the chart within the charts directory, i.e. {package}/{chart}
+	Chart string
+	// Package is the name of the package that exports the chart
+	Package string
+	// Owner is the owner of the package, as declared in its package.yaml on the current branch
+	Owner string
+	// Versions maps each branch to the latest version of the chart in that branch, if the chart exists in it
+	Versions map[string]string
+	// ForwardPorts are the newer branches whose latest version is behind the latest version of an older branch
+	ForwardPorts []string
+	// BackPorts are the older branches whose latest version is behind the latest version of a newer branch
+	BackPorts []string
+}
+
+// GetHandoff pulls each branch in handoffOptions, generates the charts of any packages within it, and compares the latest version of every chart across them
+// Owners are looked up from the packages of the current branch
+func GetHandoff(rootFs billy.Filesystem, handoffOptions options.HandoffOptions, packages []*charts.Package) (Handoff, error) {
+	handoff := Handoff{}
+	owners := make(map[string]string, len(packages))
+	for _, p := range packages {
+		owners[p.Name] = p.Owner
+	}
+	if err := transient.Track(rootFs, path.ChartsRepositoryHandoffDir); err != nil {
+		return handoff, err
+	}
+	defer transient.Remove(rootFs, path.ChartsRepositoryHandoffDir)
+	latestVersions := make(map[string]map[string]string)
+	for _, compareGeneratedAssetsOptions := range handoffOptions {
+		branch := compareGeneratedAssetsOptions.Branch
+		handoff.Branches = append(handoff.Branches, branch)
+		branchDir := filepath.Join(path.ChartsRepositoryHandoffDir, branch)
+		branchUpstream, err := puller.GetGithubRepository(compareGeneratedAssetsOptions.UpstreamOptions, &branch)
+		if err != nil {
+			return handoff, fmt.Errorf("Failed to get Github repository pointing to %s: %s", branch, err)
+		}
+		if err := branchUpstream.Pull(rootFs, rootFs, branchDir); err != nil {
+			return handoff, fmt.Errorf("Failed to pull %s: %s", branch, err)
+		}
+		branchPackages, err := charts.GetPackages(filesystem.GetAbsPath(rootFs, branchDir), "")
+		if err != nil {
+			return handoff, fmt.Errorf("Failed to get packages in %s: %s", branch, err)
+		}
+		for _, p := range branchPackages {
+			if err := p.GenerateCharts(); err != nil {
+				return handoff, err
+			}
+		}
+		branchFs, err := rootFs.Chroot(branchDir)
+		if err != nil {
+			return handoff, err
+		}
+		chartVersions, err := GetChartVersions(branchFs)
+		if err != nil {
+			return handoff, fmt.Errorf("Encountered error while trying to get chart versions in %s: %s", branch, err)
+		}
+		for chart, versions := range chartVersions {
+			if _, ok := latestVersions[chart]; !ok {
+				latestVersions[chart] = make(map[string]string)
+			}
+			latestVersions[chart][branch] = getLatestVersion(versions)
+		}
+	}
+	for chart, versions := range latestVersions {
+		handoffChart := HandoffChart{
+			Chart:    chart,
+			Package:  strings.Split(chart, "/")[0],
+			Versions: versions,
+		}
+		handoffChart.Owner = owners[handoffChart.Package]
+		if len(handoffChart.Owner) == 0 {
+			handoffChart.Owner = unownedPackage
+		}
+		if !hasDifferentVersions(handoff.Branches, versions) {
+			continue
+		}
+		handoffChart.ForwardPorts, handoffChart.BackPorts = getPorts(handoff.Branches, versions)
+		handoff.Charts = append(handoff.Charts, handoffChart)
+	}
+	sort.Slice(handoff.Charts, func(i, j int) bool {
+		if handoff.Charts[i].Owner != handoff.Charts[j].Owner {
+			return handoff.Charts[i].Owner < handoff.Charts[j].Owner
+		}
+		return handoff.Charts[i].Chart < handoff.Charts[j].Chart
+	})
+	return handoff, nil
+}
+
+// hasDifferentVersions returns whether the chart is missing from some branch or its latest version differs between branches
+func hasDifferentVersions(branches []string, versions map[string]string) bool {
+	for _, branch := range branches {
+		if versions[branch] != versions[branches[0]] {
+			return true
+		}
+	}
+	return false
+}
+
+// getPorts returns the branches that need a forward-port or a back-port of the chart
+// A branch needs a forward-port if an older branch has a newer version and a back-port if a newer branch has a newer version
+// Branches that do not contain the chart are not considered, since the chart may have been intentionally added or removed
+func getPorts(branches []string, versions map[string]string) (forwardPorts, backPorts []string) {
+	parsed := make(map[string]*semver.Version, len(versions))
+	for branch, version := range versions {
+		v, err := semver.NewVersion(version)
+		if err != nil {
+			logrus.Warnf("Unable to parse chart version %s in %s: %s", version, branch, err)
+			continue
+		}
+		parsed[branch] = v
+	}
+	for i, branch := range branches {
+		v, ok := parsed[branch]
+		if !ok {
+			continue
+		}
+		var needsForwardPort, needsBackPort bool
+		for j, other := range branches {
+			otherV, ok := parsed[other]
+			if !ok || !otherV.GreaterThan(v) {
+				continue
+			}
+			if j < i {
+				needsForwardPort = true
+			} else if j > i {
+				needsBackPort = true
+			}
+		}
+		if needsForwardPort {
+			forwardPorts = append(forwardPorts, branch)
+		}
+		if needsBackPort {
+			backPorts = append(backPorts, branch)
+		}
+	}
+	return forwardPorts, backPorts
+}
+
+// WriteHandoff writes the Handoff as a table for each owner
+func WriteHandoff(w io.Writer, handoff Handoff) error {
+	if len(handoff.Charts) == 0 {
+		_, err := fmt.Fprintf(w, "All charts have the same latest version across %s\n", strings.Join(handoff.Branches, ", "))
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	var owner string
+	for i, c := range handoff.Charts {
+		if i == 0 || c.Owner != owner {
+			owner = c.Owner
+			if i > 0 {
+				fmt.Fprintln(tw)
+			}
+			fmt.Fprintf(tw, "Owner: %s\n", owner)
+			fmt.Fprintf(tw, "CHART\t%s\tFORWARD-PORT TO\tBACK-PORT TO\n", strings.ToUpper(strings.Join(handoff.Branches, "\t")))
+		}
+		row := []string{c.Chart}
+		for _, branch := range handoff.Branches {
+			row = append(row, orDash(c.Versions[branch]))
+		}
+		row = append(row, orDash(strings.Join(c.ForwardPorts, ", ")), orDash(strings.Join(c.BackPorts, ", ")))
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// orDash returns s or a dash if s is empty
+func orDash(s string) string {
+	if len(s) == 0 {
+		return "-"
+	}
+	return s
+}
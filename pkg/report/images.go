@@ -0,0 +1,92 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/helm"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+)
+
+// ImageList represents the images referenced by every version of each chart in the repository
+type ImageList struct {
+	// Images are all images referenced by any chart, sorted and without duplicates
+	Images []string `json:"images"`
+	// Charts are the images referenced by each chart
+	Charts []ChartImages `json:"charts"`
+}
+
+// ChartImages represents the images referenced by a chart version
+type ChartImages struct {
+	// Chart is the path to the chart within the charts directory, i.e. {package}/{chart}
+	Chart string `json:"chart"`
+	// Version is the version of the chart
+	Version string `json:"version"`
+	// Images are the images referenced by the chart version
+	Images []string `json:"images"`
+}
+
+// GetImageList returns the images referenced by every version of each chart in the charts directory of the repository, since every released version can still be installed
+// Each chart version is rendered with its default values and with every extra values file in the options that applies to it
+func GetImageList(rootFs billy.Filesystem, imageListOptions options.ImageListOptions) (ImageList, error) {
+	var imageList ImageList
+	chartVersions, err := GetChartVersions(rootFs)
+	if err != nil {
+		return imageList, fmt.Errorf("Encountered error while trying to get chart versions: %s", err)
+	}
+	charts := make([]string, 0, len(chartVersions))
+	for chart := range chartVersions {
+		charts = append(charts, chart)
+	}
+	sort.Strings(charts)
+	imageSet := make(map[string]bool)
+	for _, chart := range charts {
+		var valuesFiles []string
+		for _, extraValues := range imageListOptions.ExtraValues {
+			if len(extraValues.Chart) > 0 && extraValues.Chart != filepath.Base(chart) {
+				continue
+			}
+			valuesFiles = append(valuesFiles, filesystem.GetAbsPath(rootFs, extraValues.ValuesFile))
+		}
+		for _, version := range sortVersions(chartVersions[chart]) {
+			images, err := helm.GetImagesFromRenderedChart(rootFs, getChartPath(chart, version), valuesFiles)
+			if err != nil {
+				return imageList, fmt.Errorf("Encountered error while trying to get images of %s/%s: %s", chart, version, err)
+			}
+			for _, image := range images {
+				imageSet[image] = true
+			}
+			imageList.Charts = append(imageList.Charts, ChartImages{
+				Chart:   chart,
+				Version: version,
+				Images:  images,
+			})
+		}
+	}
+	imageList.Images = make([]string, 0, len(imageSet))
+	for image := range imageSet {
+		imageList.Images = append(imageList.Images, image)
+	}
+	sort.Strings(imageList.Images)
+	return imageList, nil
+}
+
+// WriteImageList writes the image list as JSON if asJSON is set or otherwise as one image per line
+func WriteImageList(w io.Writer, imageList ImageList, asJSON bool) error {
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(imageList)
+	}
+	for _, image := range imageList.Images {
+		if _, err := fmt.Fprintln(w, image); err != nil {
+			return err
+		}
+	}
+	return nil
+}
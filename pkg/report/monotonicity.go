@@ -0,0 +1,140 @@
+package report
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	"github.com/rancher/charts-build-scripts/pkg/path"
+	"github.com/rancher/charts-build-scripts/pkg/puller"
+	"github.com/rancher/charts-build-scripts/pkg/transient"
+)
+
+const (
+	// MonotonicityPolicyGreaterOrEqual allows a newer release line to have the same latest version of a chart as an older one
+	MonotonicityPolicyGreaterOrEqual = "greaterOrEqual"
+	// MonotonicityPolicyGreater requires a newer release line to have a strictly greater latest version of a chart than an older one
+	MonotonicityPolicyGreater = "greater"
+
+	// currentBranch is how the branch that the scripts are run on is referred to in version regressions
+	currentBranch = "the current branch"
+)
+
+// VersionRegression represents a chart whose latest version on a newer release line is not ahead of its latest version on an older release line
+// Rancher would see such a chart as downgraded when upgrading from the older release line to the newer one
+type VersionRegression struct {
+	// Chart is the path to the chart within the charts directory, i.e. {package}/{chart}
+	Chart string
+	// OlderBranch is the branch of the older release line
+	OlderBranch string
+	// OlderVersion is the latest version of the chart on OlderBranch
+	OlderVersion string
+	// NewerBranch is the branch of the newer release line
+	NewerBranch string
+	// NewerVersion is the latest version of the chart on NewerBranch
+	NewerVersion string
+}
+
+func (r VersionRegression) String() string {
+	return fmt.Sprintf("%s: latest version %s on %s is not ahead of latest version %s on %s", r.Chart, r.NewerVersion, r.NewerBranch, r.OlderVersion, r.OlderBranch)
+}
+
+// ValidateMonotonicityPolicy returns an error if the policy is not greaterOrEqual, greater, or empty
+func ValidateMonotonicityPolicy(policy string) error {
+	switch policy {
+	case "", MonotonicityPolicyGreaterOrEqual, MonotonicityPolicyGreater:
+		return nil
+	}
+	return fmt.Errorf("Version monotonicity policy %s is invalid: must be %s or %s", policy, MonotonicityPolicyGreaterOrEqual, MonotonicityPolicyGreater)
+}
+
+// GetVersionRegressions pulls each branch in monotonicityOptions and compares the latest version of every chart on it against the latest version on the current branch
+// Charts that only exist on one of the branches being compared are not considered, since they may have been intentionally added or removed
+func GetVersionRegressions(rootFs billy.Filesystem, monotonicityOptions options.VersionMonotonicityOptions) ([]VersionRegression, error) {
+	if err := ValidateMonotonicityPolicy(monotonicityOptions.Policy); err != nil {
+		return nil, err
+	}
+	currentChartVersions, err := GetChartVersions(rootFs)
+	if err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to get chart versions in the current branch: %s", err)
+	}
+	if err := transient.Track(rootFs, path.ChartsRepositoryMonotonicityDir); err != nil {
+		return nil, err
+	}
+	defer transient.Remove(rootFs, path.ChartsRepositoryMonotonicityDir)
+	var regressions []VersionRegression
+	for _, compareGeneratedAssetsOptions := range monotonicityOptions.OlderBranches {
+		branchChartVersions, err := getBranchChartVersions(rootFs, compareGeneratedAssetsOptions)
+		if err != nil {
+			return nil, err
+		}
+		regressions = append(regressions, getVersionRegressions(compareGeneratedAssetsOptions.Branch, branchChartVersions, currentBranch, currentChartVersions, monotonicityOptions.Policy)...)
+	}
+	for _, compareGeneratedAssetsOptions := range monotonicityOptions.NewerBranches {
+		branchChartVersions, err := getBranchChartVersions(rootFs, compareGeneratedAssetsOptions)
+		if err != nil {
+			return nil, err
+		}
+		regressions = append(regressions, getVersionRegressions(currentBranch, currentChartVersions, compareGeneratedAssetsOptions.Branch, branchChartVersions, monotonicityOptions.Policy)...)
+	}
+	sort.SliceStable(regressions, func(i, j int) bool {
+		return regressions[i].Chart < regressions[j].Chart
+	})
+	return regressions, nil
+}
+
+// getBranchChartVersions pulls the branch and returns all chart versions found in its charts directory
+func getBranchChartVersions(rootFs billy.Filesystem, compareGeneratedAssetsOptions options.CompareGeneratedAssetsOptions) (ChartVersions, error) {
+	branch := compareGeneratedAssetsOptions.Branch
+	branchDir := filepath.Join(path.ChartsRepositoryMonotonicityDir, branch)
+	branchUpstream, err := puller.GetGithubRepository(compareGeneratedAssetsOptions.UpstreamOptions, &branch)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get Github repository pointing to %s: %s", branch, err)
+	}
+	if err := branchUpstream.Pull(rootFs, rootFs, branchDir); err != nil {
+		return nil, fmt.Errorf("Failed to pull %s: %s", branch, err)
+	}
+	branchFs, err := rootFs.Chroot(branchDir)
+	if err != nil {
+		return nil, err
+	}
+	chartVersions, err := GetChartVersions(branchFs)
+	if err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to get chart versions in %s: %s", branch, err)
+	}
+	return chartVersions, nil
+}
+
+// getVersionRegressions returns the charts whose latest version on the newer branch is not ahead of their latest version on the older branch according to the policy
+func getVersionRegressions(olderBranch string, olderChartVersions ChartVersions, newerBranch string, newerChartVersions ChartVersions, policy string) []VersionRegression {
+	var regressions []VersionRegression
+	for chart, olderVersions := range olderChartVersions {
+		newerVersions, ok := newerChartVersions[chart]
+		if !ok {
+			continue
+		}
+		olderVersion, newerVersion := getLatestVersion(olderVersions), getLatestVersion(newerVersions)
+		olderV, err := semver.NewVersion(olderVersion)
+		if err != nil {
+			continue
+		}
+		newerV, err := semver.NewVersion(newerVersion)
+		if err != nil {
+			continue
+		}
+		if newerV.GreaterThan(olderV) || (policy != MonotonicityPolicyGreater && newerV.Equal(olderV)) {
+			continue
+		}
+		regressions = append(regressions, VersionRegression{
+			Chart:        chart,
+			OlderBranch:  olderBranch,
+			OlderVersion: olderVersion,
+			NewerBranch:  newerBranch,
+			NewerVersion: newerVersion,
+		})
+	}
+	return regressions
+}
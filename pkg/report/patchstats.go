@@ -0,0 +1,330 @@
+package report
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
+	"github.com/rancher/charts-build-scripts/pkg/change"
+	"github.com/rancher/charts-build-scripts/pkg/charts"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	"github.com/rancher/charts-build-scripts/pkg/path"
+	"gopkg.in/yaml.v2"
+)
+
+// PatchStats summarizes the patch burden carried by a package
+type PatchStats struct {
+	// Package is the name of the package
+	Package string
+	// Patches is the number of patch files currently found within the package
+	Patches int
+	// PatchedLines is the total number of lines added or removed across all patch files
+	PatchedLines int
+	// OldestPatch is the time at which the oldest patch that is still present was first committed
+	OldestPatch time.Time
+	// UpstreamBumps is the number of commits that changed the upstream of the main chart since OldestPatch
+	UpstreamBumps int
+	// Conflicts are the upstream bumps since OldestPatch that required existing patches to be rewritten
+	Conflicts []PatchConflict
+}
+
+// PatchConflict represents an upstream bump that required existing patches to be rewritten
+type PatchConflict struct {
+	// Commit is the hash of the commit that bumped the upstream
+	Commit string
+	// When is the time at which the commit was made
+	When time.Time
+}
+
+// GetPatchStats computes the PatchStats for each of the packages provided using the git history of the repository at repoRoot
+func GetPatchStats(repoRoot string, packages []*charts.Package) ([]PatchStats, error) {
+	repo, err := git.PlainOpen(repoRoot)
+	if err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to open repository at %s: %s", repoRoot, err)
+	}
+	rootFs := filesystem.GetFilesystem(repoRoot)
+	var stats []PatchStats
+	for _, p := range packages {
+		packageStats, err := getPackagePatchStats(rootFs, repo, p.Name)
+		if err != nil {
+			return nil, fmt.Errorf("Encountered error while computing patch statistics for package %s: %s", p.Name, err)
+		}
+		stats = append(stats, packageStats)
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		return stats[i].PatchedLines > stats[j].PatchedLines
+	})
+	return stats, nil
+}
+
+// getPackagePatchStats computes the PatchStats of a single package
+func getPackagePatchStats(rootFs billy.Filesystem, repo *git.Repository, packageName string) (PatchStats, error) {
+	stats := PatchStats{Package: packageName}
+	packageDir := filepath.Join(path.RepositoryPackagesDir, packageName)
+	patchIntroduced := make(map[string]time.Time)
+	err := filesystem.WalkDir(rootFs, filepath.Join(packageDir, path.GeneratedChangesDir), func(fs billy.Filesystem, patchPath string, isDir bool) error {
+		if isDir || !isPatchFile(patchPath) {
+			return nil
+		}
+		lines, err := countPatchedLines(fs, patchPath)
+		if err != nil {
+			return err
+		}
+		stats.Patches++
+		stats.PatchedLines += lines
+		patchIntroduced[filepath.ToSlash(patchPath)] = time.Time{}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	if stats.Patches == 0 {
+		return stats, nil
+	}
+	packageOptionsPaths := getPackageOptionsPaths(packageName)
+	packagePrefix := filepath.ToSlash(packageDir) + "/"
+	commits, err := repo.Log(&git.LogOptions{
+		Order: git.LogOrderCommitterTime,
+		PathFilter: func(p string) bool {
+			// Packages defined in the aggregated package options are changed outside of their directory
+			return strings.HasPrefix(p, packagePrefix) || packageOptionsPaths[p]
+		},
+	})
+	if err != nil {
+		return stats, err
+	}
+	// Commits are walked from newest to oldest, so bumps are only attributed once the oldest patch is known
+	type bump struct {
+		commit         *object.Commit
+		patchesChanged bool
+	}
+	var bumps []bump
+	err = commits.ForEach(func(c *object.Commit) error {
+		changes, err := getCommitChanges(c)
+		if err != nil {
+			return err
+		}
+		var touchesPackageOptions, patchesChanged bool
+		for _, change := range changes {
+			action, err := change.Action()
+			if err != nil {
+				return err
+			}
+			switch {
+			case packageOptionsPaths[change.To.Name]:
+				touchesPackageOptions = true
+			case action == merkletrie.Insert:
+				if _, ok := patchIntroduced[change.To.Name]; ok {
+					// Keep overwriting since older commits are visited later
+					patchIntroduced[change.To.Name] = c.Committer.When
+				}
+			case action == merkletrie.Modify && isPatchFile(change.To.Name):
+				patchesChanged = true
+			}
+		}
+		if !touchesPackageOptions {
+			return nil
+		}
+		upstreamChanged, err := isUpstreamChanged(c, packageName)
+		if err != nil {
+			return err
+		}
+		if upstreamChanged {
+			bumps = append(bumps, bump{commit: c, patchesChanged: patchesChanged})
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	for _, introduced := range patchIntroduced {
+		if introduced.IsZero() {
+			// Patch has not been committed yet
+			continue
+		}
+		if stats.OldestPatch.IsZero() || introduced.Before(stats.OldestPatch) {
+			stats.OldestPatch = introduced
+		}
+	}
+	if stats.OldestPatch.IsZero() {
+		return stats, nil
+	}
+	for i := len(bumps) - 1; i >= 0; i-- {
+		c := bumps[i].commit
+		if c.Committer.When.Before(stats.OldestPatch) {
+			continue
+		}
+		stats.UpstreamBumps++
+		if bumps[i].patchesChanged {
+			stats.Conflicts = append(stats.Conflicts, PatchConflict{
+				Commit: c.Hash.String()[:7],
+				When:   c.Committer.When,
+			})
+		}
+	}
+	return stats, nil
+}
+
+// getCommitChanges returns the changes introduced by a commit against its first parent
+func getCommitChanges(c *object.Commit) (object.Changes, error) {
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, err
+	}
+	var parentTree *object.Tree
+	if c.NumParents() > 0 {
+		parent, err := c.Parent(0)
+		if err != nil {
+			return nil, err
+		}
+		parentTree, err = parent.Tree()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return object.DiffTree(parentTree, tree)
+}
+
+// isUpstreamChanged returns whether the commit changed the upstream of the main chart of the package
+func isUpstreamChanged(c *object.Commit, packageName string) (bool, error) {
+	upstreamOptions, err := getUpstreamOptionsAtCommit(c, packageName)
+	if err != nil {
+		return false, err
+	}
+	if c.NumParents() == 0 {
+		return upstreamOptions != nil, nil
+	}
+	parent, err := c.Parent(0)
+	if err != nil {
+		return false, err
+	}
+	parentUpstreamOptions, err := getUpstreamOptionsAtCommit(parent, packageName)
+	if err != nil {
+		return false, err
+	}
+	return !reflect.DeepEqual(upstreamOptions, parentUpstreamOptions), nil
+}
+
+// getPackageOptionsPaths returns the paths within the repository of the files that the package may be defined in
+func getPackageOptionsPaths(packageName string) map[string]bool {
+	return map[string]bool{
+		filepath.ToSlash(filepath.Join(path.RepositoryPackagesDir, packageName, path.PackageOptionsFile)): true,
+		filepath.ToSlash(filepath.Join(path.RepositoryPackagesDir, path.AggregatedPackageOptionsFile)):    true,
+	}
+}
+
+// getUpstreamOptionsAtCommit returns the upstream options of the main chart of the package as of the commit or nil if the package does not exist
+// The package is defined by its package.yaml or, if it does not have one, by its entry in the aggregated package options
+func getUpstreamOptionsAtCommit(c *object.Commit, packageName string) (*options.UpstreamOptions, error) {
+	contents, err := getFileContentsAtCommit(c, filepath.Join(path.RepositoryPackagesDir, packageName, path.PackageOptionsFile))
+	if err != nil {
+		return nil, err
+	}
+	if contents != nil {
+		var packageOptions options.PackageOptions
+		if err := yaml.Unmarshal(contents, &packageOptions); err != nil {
+			// An unparseable package.yaml cannot be compared, so treat it as missing
+			return nil, nil
+		}
+		return &packageOptions.MainChartOptions.UpstreamOptions, nil
+	}
+	contents, err = getFileContentsAtCommit(c, filepath.Join(path.RepositoryPackagesDir, path.AggregatedPackageOptionsFile))
+	if err != nil || contents == nil {
+		return nil, err
+	}
+	var aggregatedPackageOptions map[string]options.PackageOptions
+	if err := yaml.Unmarshal(contents, &aggregatedPackageOptions); err != nil {
+		return nil, nil
+	}
+	packageOptions, ok := aggregatedPackageOptions[packageName]
+	if !ok {
+		return nil, nil
+	}
+	return &packageOptions.MainChartOptions.UpstreamOptions, nil
+}
+
+// getFileContentsAtCommit returns the contents of the file at filePath as of the commit or nil if it does not exist
+func getFileContentsAtCommit(c *object.Commit, filePath string) ([]byte, error) {
+	file, err := c.File(filepath.ToSlash(filePath))
+	if err == object.ErrFileNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	contents, err := file.Contents()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(contents), nil
+}
+
+// isPatchFile returns whether the path points to a patch or a structured patch within a generated changes directory
+func isPatchFile(patchPath string) bool {
+	if !strings.HasSuffix(patchPath, ".patch") && !strings.HasSuffix(patchPath, change.StructuredPatchExt) {
+		return false
+	}
+	return strings.Contains(filepath.ToSlash(patchPath), "/"+path.GeneratedChangesPatchDir+"/")
+}
+
+// countPatchedLines returns the number of lines added or removed by the patch at patchPath
+// Every line of a structured patch sets or removes a value, so each one is counted
+func countPatchedLines(fs billy.Filesystem, patchPath string) (int, error) {
+	f, err := fs.Open(patchPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	structured := strings.HasSuffix(patchPath, change.StructuredPatchExt)
+	var lines int
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if structured {
+			if len(strings.TrimSpace(line)) > 0 {
+				lines++
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "+++ ") || strings.HasPrefix(line, "--- ") {
+			continue
+		}
+		if strings.HasPrefix(line, "+") || strings.HasPrefix(line, "-") {
+			lines++
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// WritePatchStats writes a table of the patch statistics to w, ordered by the packages with the largest patch burden
+func WritePatchStats(w io.Writer, stats []PatchStats, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PACKAGE\tPATCHES\tPATCHED LINES\tOLDEST PATCH AGE (DAYS)\tUPSTREAM BUMPS\tCONFLICTS\tCONFLICT HISTORY")
+	for _, s := range stats {
+		age := "-"
+		if !s.OldestPatch.IsZero() {
+			age = fmt.Sprint(int(now.Sub(s.OldestPatch).Hours() / 24))
+		}
+		var history []string
+		for _, conflict := range s.Conflicts {
+			history = append(history, fmt.Sprintf("%s (%s)", conflict.Commit, conflict.When.Format("2006-01-02")))
+		}
+		if len(history) == 0 {
+			history = append(history, "-")
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%d\t%d\t%s\n", s.Package, s.Patches, s.PatchedLines, age, s.UpstreamBumps, len(s.Conflicts), strings.Join(history, ", "))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,71 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/helm"
+)
+
+// ChartValuesDefaults represents the default values of a chart version
+type ChartValuesDefaults struct {
+	// Chart is the path to the chart within the charts directory, i.e. {package}/{chart}
+	Chart string `json:"chart"`
+	// Version is the version of the chart
+	Version string `json:"version"`
+	// Values are the default value of every key within the values of the chart version and its subcharts
+	Values []helm.ValueDefault `json:"values"`
+}
+
+// GetValuesDefaults returns the default values of every chart version in the charts directory of the repository
+func GetValuesDefaults(rootFs billy.Filesystem) ([]ChartValuesDefaults, error) {
+	chartVersions, err := GetChartVersions(rootFs)
+	if err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to get chart versions: %s", err)
+	}
+	charts := make([]string, 0, len(chartVersions))
+	for chart := range chartVersions {
+		charts = append(charts, chart)
+	}
+	sort.Strings(charts)
+	var valuesDefaults []ChartValuesDefaults
+	for _, chart := range charts {
+		versions := append([]string{}, chartVersions[chart]...)
+		sort.Strings(versions)
+		for _, version := range versions {
+			values, err := helm.GetValuesDefaults(rootFs, getChartPath(chart, version))
+			if err != nil {
+				return nil, fmt.Errorf("Encountered error while trying to get default values of %s/%s: %s", chart, version, err)
+			}
+			valuesDefaults = append(valuesDefaults, ChartValuesDefaults{
+				Chart:   chart,
+				Version: version,
+				Values:  values,
+			})
+		}
+	}
+	return valuesDefaults, nil
+}
+
+// WriteValuesDefaults writes the default values of each chart version as JSON into {dir}/{package}/{chart}/{version}.json
+func WriteValuesDefaults(dir string, valuesDefaults []ChartValuesDefaults) error {
+	for _, chartValuesDefaults := range valuesDefaults {
+		valuesDefaultsPath := filepath.Join(dir, chartValuesDefaults.Chart, fmt.Sprintf("%s.json", chartValuesDefaults.Version))
+		if err := os.MkdirAll(filepath.Dir(valuesDefaultsPath), 0755); err != nil {
+			return err
+		}
+		valuesDefaultsBytes, err := json.MarshalIndent(chartValuesDefaults, "", "  ")
+		if err != nil {
+			return fmt.Errorf("Could not marshal default values of %s/%s: %s", chartValuesDefaults.Chart, chartValuesDefaults.Version, err)
+		}
+		if err := ioutil.WriteFile(valuesDefaultsPath, append(valuesDefaultsBytes, '\n'), 0644); err != nil {
+			return fmt.Errorf("Unable to write %s: %s", valuesDefaultsPath, err)
+		}
+	}
+	return nil
+}
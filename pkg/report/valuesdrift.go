@@ -0,0 +1,147 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/rancher/charts-build-scripts/pkg/charts"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/helm"
+	"github.com/rancher/charts-build-scripts/pkg/options"
+	"github.com/rancher/charts-build-scripts/pkg/puller"
+)
+
+// ValuesDrift represents how the default values shipped by a package drift from those of its pinned upstream
+type ValuesDrift struct {
+	// Package is the name of the package
+	Package string
+	// Upstream is the upstream that the main chart of the package is pinned to
+	Upstream string
+	// PreviousUpstream is the upstream that the main chart was pinned to before it was last bumped, if any
+	PreviousUpstream string
+	// Drift is how the default values shipped by the package differ from those of the upstream
+	Drift helm.ValuesDrift
+}
+
+func (d ValuesDrift) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Values drift of package %s\n", d.Package)
+	fmt.Fprintf(&sb, "  Upstream: %s\n", d.Upstream)
+	if len(d.PreviousUpstream) > 0 {
+		fmt.Fprintf(&sb, "  Previous upstream: %s\n", d.PreviousUpstream)
+	}
+	writeList(&sb, "Keys we changed", stringifyDriftKeys(d.Drift.Changed))
+	writeList(&sb, "Keys we added", stringifyDriftKeys(d.Drift.Added))
+	writeList(&sb, "Keys we removed", stringifyDriftKeys(d.Drift.Removed))
+	if len(d.PreviousUpstream) > 0 {
+		writeList(&sb, "Keys upstream added that we have not reviewed", stringifyDriftKeys(d.Drift.Unreviewed))
+		writeList(&sb, "Keys upstream removed that we still set", stringifyDriftKeys(d.Drift.Stale))
+	}
+	return sb.String()
+}
+
+// stringifyDriftKeys returns the string representation of each key
+func stringifyDriftKeys(keys []helm.ValuesDriftKey) []string {
+	items := make([]string, len(keys))
+	for i, key := range keys {
+		items[i] = key.String()
+	}
+	return items
+}
+
+// GetValuesDrift computes the ValuesDrift of each of the packages provided, using the git history of the repository at repoRoot
+// to find the upstream that each package was pinned to before it was last bumped. Packages whose main chart is local are skipped
+func GetValuesDrift(repoRoot string, packages []*charts.Package) ([]ValuesDrift, error) {
+	repo, err := git.PlainOpen(repoRoot)
+	if err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to open repository at %s: %s", repoRoot, err)
+	}
+	rootFs := filesystem.GetFilesystem(repoRoot)
+	var drifts []ValuesDrift
+	for _, p := range packages {
+		if p.Chart.Upstream.IsWithinPackage() {
+			continue
+		}
+		valuesDrift := ValuesDrift{
+			Package:  p.Name,
+			Upstream: fmt.Sprint(p.Chart.Upstream),
+		}
+		previousUpstreamOptions, err := getPreviousUpstreamOptions(rootFs, repo, p.Name)
+		if err != nil {
+			return nil, fmt.Errorf("Encountered error while trying to find the previous upstream of package %s: %s", p.Name, err)
+		}
+		var previousUpstream puller.Puller
+		if previousUpstreamOptions != nil {
+			previousUpstream, err = charts.GetUpstream(*previousUpstreamOptions)
+			if err != nil {
+				return nil, fmt.Errorf("Encountered error while trying to get the previous upstream of package %s: %s", p.Name, err)
+			}
+		}
+		if previousUpstream != nil && previousUpstream.IsWithinPackage() {
+			// A local chart has no defaults of its own to compare against
+			previousUpstream = nil
+		}
+		if previousUpstream != nil {
+			valuesDrift.PreviousUpstream = fmt.Sprint(previousUpstream)
+		}
+		drift, err := p.GetValuesDrift(previousUpstream)
+		if err != nil {
+			return nil, fmt.Errorf("Encountered error while computing values drift for package %s: %s", p.Name, err)
+		}
+		valuesDrift.Drift = *drift
+		drifts = append(drifts, valuesDrift)
+	}
+	return drifts, nil
+}
+
+// getPreviousUpstreamOptions returns the upstream options of the main chart in the most recent commit whose package options pointed to a different upstream
+// than the package options currently in the repository, or nil if the package has always pointed to the same upstream
+func getPreviousUpstreamOptions(rootFs billy.Filesystem, repo *git.Repository, packageName string) (*options.UpstreamOptions, error) {
+	packageOptionsPaths := getPackageOptionsPaths(packageName)
+	packageOptions, err := charts.LoadPackageOptions(rootFs, packageName)
+	if err != nil {
+		return nil, err
+	}
+	upstreamOptions := packageOptions.MainChartOptions.UpstreamOptions
+	commits, err := repo.Log(&git.LogOptions{
+		Order: git.LogOrderCommitterTime,
+		PathFilter: func(p string) bool {
+			return packageOptionsPaths[p]
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var previousUpstreamOptions *options.UpstreamOptions
+	err = commits.ForEach(func(c *object.Commit) error {
+		commitUpstreamOptions, err := getUpstreamOptionsAtCommit(c, packageName)
+		if err != nil {
+			return err
+		}
+		if commitUpstreamOptions != nil && !reflect.DeepEqual(*commitUpstreamOptions, upstreamOptions) {
+			previousUpstreamOptions = commitUpstreamOptions
+			return storer.ErrStop
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return previousUpstreamOptions, nil
+}
+
+// WriteValuesDrift writes the values drift of each package to w
+func WriteValuesDrift(w io.Writer, drifts []ValuesDrift) error {
+	for _, d := range drifts {
+		if _, err := fmt.Fprint(w, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
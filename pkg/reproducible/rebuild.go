@@ -0,0 +1,198 @@
+package reproducible
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/helm"
+	"github.com/rancher/charts-build-scripts/pkg/path"
+	"github.com/rancher/charts-build-scripts/pkg/repository"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// committedOutputsDir is the directory within the workspace of a rebuild that contains the outputs of the package committed at the ref
+	committedOutputsDir = "committed"
+	// rebuiltOutputsDir is the directory within the workspace of a rebuild that contains the outputs of the package rebuilt from the inputs at the ref
+	rebuiltOutputsDir = "rebuilt"
+	// inputsDir is the directory within the workspace of a rebuild that contains the packages checked out at the ref
+	inputsDir = "inputs"
+)
+
+// RebuildPackageAsOf rebuilds the charts of the package from the packages of the repository as of ref and compares the outputs byte-for-byte against those committed at ref
+// The packages and outputs are read from the git objects of the repository into a temporary workspace, so the checkout of the repository is never modified
+// If outputDir is provided, the rebuilt assets and charts of the package are copied into it
+func RebuildPackageAsOf(repoRoot, ref, name, outputDir string) (*Report, error) {
+	tree, hash, err := getTreeAsOf(repoRoot, ref)
+	if err != nil {
+		return nil, err
+	}
+	tempDir, err := ioutil.TempDir("", "charts-build-rebuild-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+	tempFs := filesystem.GetFilesystem(tempDir)
+	logrus.Infof("Checking out packages as of %s (%s)", ref, hash)
+	if err := checkoutTreeDir(tree, path.RepositoryPackagesDir, tempFs, filepath.Join(inputsDir, path.RepositoryPackagesDir)); err != nil {
+		return nil, fmt.Errorf("Encountered error while checking out %s as of %s: %s", path.RepositoryPackagesDir, ref, err)
+	}
+	var packageOutputDirs []string
+	for _, outputDir := range []string{path.RepositoryAssetsDir, path.RepositoryChartsDir} {
+		packageOutputDir := filepath.Join(outputDir, name)
+		if err := checkoutTreeDir(tree, packageOutputDir, tempFs, filepath.Join(committedOutputsDir, packageOutputDir)); err != nil {
+			return nil, fmt.Errorf("Encountered error while checking out %s as of %s: %s", packageOutputDir, ref, err)
+		}
+		packageOutputDirs = append(packageOutputDirs, packageOutputDir)
+	}
+	logrus.Infof("Rebuilding package %s from scratch as of %s", name, ref)
+	if err := buildPackage(filesystem.GetAbsPath(tempFs, inputsDir), filesystem.GetAbsPath(tempFs, rebuiltOutputsDir), name); err != nil {
+		return nil, fmt.Errorf("Encountered error while rebuilding package %s as of %s: %s", name, ref, err)
+	}
+	// Outputs of past versions of the package are committed alongside the current one, but a rebuild only produces the current one
+	if err := pruneUnbuiltOutputs(tempFs, name); err != nil {
+		return nil, fmt.Errorf("Encountered error while selecting the outputs rebuilt as of %s: %s", ref, err)
+	}
+	report := &Report{
+		Package:     name,
+		HelmVersion: getHelmVersion(),
+		Ref:         ref,
+	}
+	if err := compareBuilds(tempFs, report, committedOutputsDir, rebuiltOutputsDir); err != nil {
+		return nil, err
+	}
+	if len(outputDir) == 0 {
+		return report, nil
+	}
+	outputFs := filesystem.GetFilesystem(outputDir)
+	for _, packageOutputDir := range packageOutputDirs {
+		rebuiltDir := filepath.Join(rebuiltOutputsDir, packageOutputDir)
+		exists, err := filesystem.PathExists(tempFs, rebuiltDir)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+		if err := filesystem.RemoveAll(outputFs, packageOutputDir); err != nil {
+			return nil, err
+		}
+		if err := filesystem.CopyFromLocalPath(filesystem.GetAbsPath(tempFs, rebuiltDir), outputFs, packageOutputDir); err != nil {
+			return nil, fmt.Errorf("Encountered error while copying rebuilt %s into %s: %s", packageOutputDir, outputDir, err)
+		}
+	}
+	return report, nil
+}
+
+// GetFileAsOf returns the contents of the file at filePath within the repository as of ref, or nil if it did not exist as of ref
+func GetFileAsOf(repoRoot, ref, filePath string) ([]byte, error) {
+	tree, _, err := getTreeAsOf(repoRoot, ref)
+	if err != nil {
+		return nil, err
+	}
+	f, err := tree.File(filepath.ToSlash(filePath))
+	if err == object.ErrFileNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get %s as of %s: %s", filePath, ref, err)
+	}
+	contents, err := f.Contents()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read %s as of %s: %s", filePath, ref, err)
+	}
+	return []byte(contents), nil
+}
+
+// getTreeAsOf returns the git tree of the repository as of ref along with the hash of the commit ref resolves to
+func getTreeAsOf(repoRoot, ref string) (*object.Tree, *plumbing.Hash, error) {
+	repo, err := repository.GetRepo(repoRoot)
+	if err != nil {
+		return nil, nil, err
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to resolve %s: %s", ref, err)
+	}
+	commit, err := repo.CommitObject(*hash)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to get commit %s: %s", hash, err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to get tree of commit %s: %s", hash, err)
+	}
+	return tree, hash, nil
+}
+
+// pruneUnbuiltOutputs removes the committed outputs of the package for any chart version that the rebuild did not produce
+// Chart versions are found through the layout that the outputs were exported with, which is the one configured as of the ref
+func pruneUnbuiltOutputs(fs billy.Filesystem, name string) error {
+	committedAssetsDir := filepath.Join(committedOutputsDir, path.RepositoryAssetsDir, name)
+	committedChartsDir := filepath.Join(committedOutputsDir, path.RepositoryChartsDir, name)
+	rebuiltCharts, err := helm.GetExportedCharts(fs, filepath.Join(rebuiltOutputsDir, path.RepositoryChartsDir, name))
+	if err != nil {
+		return err
+	}
+	rebuilt := make(map[string]bool, len(rebuiltCharts))
+	for _, rebuiltChart := range rebuiltCharts {
+		rebuilt[filepath.Join(rebuiltChart.Name, rebuiltChart.Version)] = true
+	}
+	committedCharts, err := helm.GetExportedCharts(fs, committedChartsDir)
+	if err != nil {
+		return err
+	}
+	for _, committedChart := range committedCharts {
+		if rebuilt[filepath.Join(committedChart.Name, committedChart.Version)] {
+			continue
+		}
+		logrus.Debugf("Skipping version %s of chart %s since it was not produced by the rebuild", committedChart.Version, committedChart.Name)
+		assetPath := helm.GetAssetPath(committedAssetsDir, committedChart.Name, committedChart.Version)
+		for _, committedPath := range []string{committedChart.Path, assetPath, assetPath + helm.ProvenanceFileExtension} {
+			if err := filesystem.RemoveAll(fs, committedPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkoutTreeDir writes the files found at dir within the git tree into dstDir within the filesystem, writing nothing if dir does not exist in the tree
+func checkoutTreeDir(tree *object.Tree, dir string, fs billy.Filesystem, dstDir string) error {
+	subtree, err := tree.Tree(filepath.ToSlash(dir))
+	if err == object.ErrDirectoryNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return subtree.Files().ForEach(func(f *object.File) error {
+		dstPath, err := filesystem.SecureJoin(dstDir, f.Name)
+		if err != nil {
+			return err
+		}
+		contents, err := f.Contents()
+		if err != nil {
+			return fmt.Errorf("Unable to read %s: %s", f.Name, err)
+		}
+		if err := fs.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+			return err
+		}
+		absPath := filesystem.GetAbsPath(fs, dstPath)
+		if f.Mode == filemode.Symlink {
+			return os.Symlink(contents, absPath)
+		}
+		mode := os.FileMode(0644)
+		if f.Mode == filemode.Executable {
+			mode = 0755
+		}
+		return ioutil.WriteFile(absPath, []byte(contents), mode)
+	})
+}
@@ -0,0 +1,311 @@
+package reproducible
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/cache"
+	"github.com/rancher/charts-build-scripts/pkg/charts"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/path"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// SourceTimestamps indicates that the outputs differ in the modification times recorded within an archive
+	SourceTimestamps = "timestamps"
+	// SourceOrdering indicates that the outputs contain the same entries or lines in a different order, e.g. due to iterating over a map
+	SourceOrdering = "ordering"
+	// SourceMetadata indicates that the outputs differ in the modes or owners of files recorded within an archive
+	SourceMetadata = "file metadata"
+	// SourceContent indicates that the contents of the outputs differ
+	SourceContent = "content"
+	// SourceMissing indicates that only one of the builds produced the output
+	SourceMissing = "missing output"
+
+	// helmModulePath is the path of the module that builds chart archives
+	helmModulePath = "helm.sh/helm/v3"
+)
+
+// Difference represents an output that differed between two builds of a package from scratch
+type Difference struct {
+	// Path is the path to the output relative to the root of the repository
+	Path string
+	// Source is the suspected source of nondeterminism
+	Source string
+	// Details describes the difference, e.g. the file within an archive that differed
+	Details string
+}
+
+// Report represents the result of verifying that a package builds reproducibly
+type Report struct {
+	// Package is the name of the package
+	Package string
+	// HelmVersion is the version of Helm that built the package. Outputs are only expected to match those built with the same version
+	HelmVersion string
+	// Outputs is the number of outputs compared between the builds
+	Outputs int
+	// Ref is the git reference that the package was rebuilt as of, if any, in which case the outputs are compared against those committed at Ref instead of a second build
+	Ref string
+	// Differences are the outputs that differed between the builds
+	Differences []Difference
+}
+
+// VerifyPackage builds the charts of the package twice from scratch, each in a repository of its own with an empty cache, and compares the assets and charts produced byte-for-byte
+// The builds are at least a second apart so that any timestamps recorded in the outputs are found
+func VerifyPackage(repoRoot, name string) (*Report, error) {
+	tempDir, err := ioutil.TempDir("", "charts-build-reproducible-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+	tempFs := filesystem.GetFilesystem(tempDir)
+	builds := []string{"first", "second"}
+	for i, build := range builds {
+		if i > 0 {
+			// Timestamps are commonly recorded with a precision of a second
+			time.Sleep(time.Second)
+		}
+		logrus.Infof("Building package %s from scratch (%s build)", name, build)
+		if err := buildPackage(repoRoot, filesystem.GetAbsPath(tempFs, build), name); err != nil {
+			return nil, fmt.Errorf("Encountered error during %s build of package %s: %s", build, name, err)
+		}
+	}
+	report := &Report{
+		Package:     name,
+		HelmVersion: getHelmVersion(),
+	}
+	if err := compareBuilds(tempFs, report, builds[0], builds[1]); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// compareBuilds compares the assets and charts of the package produced by each build, which are rooted at firstBuild and secondBuild within fs, and adds any differences to the report
+func compareBuilds(fs billy.Filesystem, report *Report, firstBuild, secondBuild string) error {
+	for _, outputDir := range []string{path.RepositoryAssetsDir, path.RepositoryChartsDir} {
+		packageOutputDir := filepath.Join(outputDir, report.Package)
+		firstDir, secondDir := filepath.Join(firstBuild, packageOutputDir), filepath.Join(secondBuild, packageOutputDir)
+		onlyInBuild := func(build string) filesystem.RelativePathFunc {
+			return func(fs billy.Filesystem, outputPath string, isDir bool) error {
+				if isDir {
+					return nil
+				}
+				report.Outputs++
+				report.Differences = append(report.Differences, Difference{
+					Path:    strings.TrimPrefix(outputPath, build+"/"),
+					Source:  SourceMissing,
+					Details: fmt.Sprintf("only produced by the %s build", build),
+				})
+				return nil
+			}
+		}
+		compare := func(fs billy.Filesystem, firstPath, secondPath string, isDir bool) error {
+			if isDir {
+				return nil
+			}
+			report.Outputs++
+			differences, err := compareOutputs(fs, firstPath, secondPath)
+			if err != nil {
+				return err
+			}
+			for _, d := range differences {
+				d.Path = strings.TrimPrefix(firstPath, firstBuild+"/")
+				report.Differences = append(report.Differences, d)
+			}
+			return nil
+		}
+		if err := filesystem.CompareDirs(fs, firstDir, secondDir, onlyInBuild(firstBuild), onlyInBuild(secondBuild), compare); err != nil {
+			return fmt.Errorf("Encountered error while comparing %s across builds: %s", packageOutputDir, err)
+		}
+	}
+	return nil
+}
+
+// buildPackage copies the packages of the repository into buildRoot and generates the charts of the package there with an empty cache
+func buildPackage(repoRoot, buildRoot, name string) error {
+	buildFs := filesystem.GetFilesystem(buildRoot)
+	if err := filesystem.CopyFromLocalPath(filepath.Join(repoRoot, path.RepositoryPackagesDir), buildFs, path.RepositoryPackagesDir); err != nil {
+		return fmt.Errorf("Encountered error while copying packages: %s", err)
+	}
+	previousCache := cache.GetDefault()
+	defer cache.SetDefault(previousCache)
+	cache.SetDefault(&cache.Cache{Dir: filepath.Join(buildRoot, ".cache")})
+	pkg, err := charts.GetPackage(buildFs, name)
+	if err != nil {
+		return err
+	}
+	if pkg == nil {
+		return fmt.Errorf("Package %s does not exist", name)
+	}
+	return pkg.GenerateCharts()
+}
+
+// compareOutputs returns the differences between the same output of both builds, which is empty if they are identical
+func compareOutputs(fs billy.Filesystem, firstPath, secondPath string) ([]Difference, error) {
+	first, err := ioutil.ReadFile(filesystem.GetAbsPath(fs, firstPath))
+	if err != nil {
+		return nil, err
+	}
+	second, err := ioutil.ReadFile(filesystem.GetAbsPath(fs, secondPath))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(first, second) {
+		return nil, nil
+	}
+	if strings.HasSuffix(firstPath, ".tgz") {
+		return compareArchives(first, second)
+	}
+	return []Difference{compareContents("", first, second)}, nil
+}
+
+// compareContents returns the difference between the contents of a file that differs across builds
+func compareContents(name string, first, second []byte) Difference {
+	if sortedLines(first) == sortedLines(second) {
+		return Difference{Source: SourceOrdering, Details: strings.TrimSpace(fmt.Sprintf("%s has the same lines in a different order", name))}
+	}
+	return Difference{Source: SourceContent, Details: strings.TrimSpace(fmt.Sprintf("%s has different contents", name))}
+}
+
+// sortedLines returns the lines of the contents in lexical order
+func sortedLines(contents []byte) string {
+	lines := strings.Split(string(contents), "\n")
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// archiveEntry represents a file within a chart archive
+type archiveEntry struct {
+	header *tar.Header
+	data   []byte
+}
+
+// compareArchives returns the differences between two chart archives, down to the headers and contents of the files within them
+func compareArchives(first, second []byte) ([]Difference, error) {
+	var differences []Difference
+	firstGzip, err := gzip.NewReader(bytes.NewReader(first))
+	if err != nil {
+		return nil, err
+	}
+	secondGzip, err := gzip.NewReader(bytes.NewReader(second))
+	if err != nil {
+		return nil, err
+	}
+	if !firstGzip.ModTime.Equal(secondGzip.ModTime) {
+		differences = append(differences, Difference{Source: SourceTimestamps, Details: "gzip header records a different modification time"})
+	}
+	firstNames, firstEntries, err := readArchive(firstGzip)
+	if err != nil {
+		return nil, err
+	}
+	secondNames, secondEntries, err := readArchive(secondGzip)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Join(firstNames, "\n") != strings.Join(secondNames, "\n") {
+		differences = append(differences, Difference{Source: SourceOrdering, Details: "archive lists its files in a different order"})
+	}
+	var timestampDiffs, metadataDiffs []string
+	for _, name := range firstNames {
+		firstEntry := firstEntries[name]
+		secondEntry, ok := secondEntries[name]
+		if !ok {
+			differences = append(differences, Difference{Source: SourceMissing, Details: fmt.Sprintf("%s is only in the archive of the first build", name)})
+			continue
+		}
+		if !firstEntry.header.ModTime.Equal(secondEntry.header.ModTime) {
+			timestampDiffs = append(timestampDiffs, name)
+		}
+		if firstEntry.header.Mode != secondEntry.header.Mode || firstEntry.header.Uid != secondEntry.header.Uid || firstEntry.header.Gid != secondEntry.header.Gid ||
+			firstEntry.header.Uname != secondEntry.header.Uname || firstEntry.header.Gname != secondEntry.header.Gname {
+			metadataDiffs = append(metadataDiffs, name)
+		}
+		if !bytes.Equal(firstEntry.data, secondEntry.data) {
+			differences = append(differences, compareContents(name, firstEntry.data, secondEntry.data))
+		}
+	}
+	for _, name := range secondNames {
+		if _, ok := firstEntries[name]; !ok {
+			differences = append(differences, Difference{Source: SourceMissing, Details: fmt.Sprintf("%s is only in the archive of the second build", name)})
+		}
+	}
+	if len(timestampDiffs) > 0 {
+		differences = append(differences, Difference{Source: SourceTimestamps, Details: fmt.Sprintf("%d files have different modification times, e.g. %s", len(timestampDiffs), timestampDiffs[0])})
+	}
+	if len(metadataDiffs) > 0 {
+		differences = append(differences, Difference{Source: SourceMetadata, Details: fmt.Sprintf("%d files have different modes or owners, e.g. %s", len(metadataDiffs), metadataDiffs[0])})
+	}
+	return differences, nil
+}
+
+// readArchive returns the names of the files within the tar archive in the order they are found, along with each file keyed by its name
+func readArchive(r io.Reader) ([]string, map[string]archiveEntry, error) {
+	var names []string
+	entries := make(map[string]archiveEntry)
+	tarReader := tar.NewReader(r)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return names, entries, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		data, err := ioutil.ReadAll(tarReader)
+		if err != nil {
+			return nil, nil, err
+		}
+		names = append(names, header.Name)
+		entries[header.Name] = archiveEntry{header: header, data: data}
+	}
+}
+
+// getHelmVersion returns the version of Helm that this binary was built with
+func getHelmVersion() string {
+	buildInfo, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, dep := range buildInfo.Deps {
+		if dep.Path != helmModulePath {
+			continue
+		}
+		if dep.Replace != nil {
+			return dep.Replace.Version
+		}
+		return dep.Version
+	}
+	return "unknown"
+}
+
+// WriteReport writes the differences found as a table
+func WriteReport(w io.Writer, report *Report) error {
+	if len(report.Ref) > 0 {
+		fmt.Fprintf(w, "Package %s rebuilt as of %s with Helm %s: compared %d outputs against those committed at %s\n", report.Package, report.Ref, report.HelmVersion, report.Outputs, report.Ref)
+	} else {
+		fmt.Fprintf(w, "Package %s built with Helm %s: compared %d outputs across two builds\n", report.Package, report.HelmVersion, report.Outputs)
+	}
+	if len(report.Differences) == 0 {
+		_, err := fmt.Fprintln(w, "All outputs are identical")
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PATH\tSOURCE\tDETAILS")
+	for _, d := range report.Differences {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", d.Path, d.Source, d.Details)
+	}
+	return tw.Flush()
+}
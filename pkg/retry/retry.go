@@ -0,0 +1,63 @@
+package retry
+
+import (
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// Retries represents the number of times a network operation is retried after failing with a transient error
+	Retries = 3
+	// Backoff represents how long to wait before the first retry, which doubles on every subsequent retry
+	Backoff = time.Second
+	// MaxBackoff represents the longest to wait between retries
+	MaxBackoff = 30 * time.Second
+)
+
+// permanentError is an error that should not be retried
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks an error as one that will not go away on a retry, e.g. an authentication error
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// Do runs the operation and retries it with exponential backoff until it succeeds, fails with a permanent error, or runs out of retries
+// The description is used to log retries, e.g. "clone https://github.com/rancher/charts.git"
+func Do(description string, operation func() error) error {
+	backoff := Backoff
+	for attempt := 0; ; attempt++ {
+		err := operation()
+		if err == nil {
+			return nil
+		}
+		var permanent permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+		if attempt >= Retries {
+			return err
+		}
+		logrus.Warnf("Failed to %s (attempt %d of %d): %s; retrying in %s", description, attempt+1, Retries+1, err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > MaxBackoff {
+			backoff = MaxBackoff
+		}
+	}
+}
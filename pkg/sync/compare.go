@@ -60,50 +60,37 @@ func CompareGeneratedAssets(rootFs billy.Filesystem, newCharts, newAssets, origi
 			defer filesystem.PruneEmptyDirsInPath(rootFs, d)
 			defer filesystem.RemoveAll(rootFs, d)
 		}
+		newExportedCharts, err := getExportedCharts(rootFs, newCharts)
+		if err != nil {
+			return fmt.Errorf("Encountered error while trying to get the charts within %s: %s", newCharts, err)
+		}
 		// Only keep the biggest RC of any packageVersion
-		visitedChart := make(map[string]bool)
 		latestRC := make(map[string]string)
-		err := filesystem.WalkDir(rootFs, newCharts, func(rootFs billy.Filesystem, path string, isDir bool) error {
-			// new-assets/charts/{package}/{chart}
-			if strings.Count(path, "/") != 3 {
-				return nil
-			}
-			chart := filepath.Base(path)
-			if _, ok := visitedChart[chart]; ok {
-				// Already pruned this path
-				return nil
-			}
-			// No need to visit again
-			visitedChart[chart] = true
-			fileInfos, err := rootFs.ReadDir(path)
-			if err != nil {
-				return fmt.Errorf("Encountered an error while trying to read directories within %s: %s", path, err)
-			}
-			for _, f := range fileInfos {
-				chartVersion := f.Name()
-				splitChartVersion := strings.Split(chartVersion, "-rc")
-				chartVersionWithName := fmt.Sprintf("%s/%s", chart, chartVersion)
-				chartVersionWithNameWithoutRC := fmt.Sprintf("%s/%s", chart, splitChartVersion[0])
+		latestRCPaths := make(map[string]string)
+		for packageName, exportedCharts := range newExportedCharts {
+			for _, exportedChart := range exportedCharts {
+				splitChartVersion := strings.Split(exportedChart.Version, "-rc")
+				chartVersionWithName := fmt.Sprintf("%s/%s", exportedChart.Name, exportedChart.Version)
+				chartVersionWithNameWithoutRC := fmt.Sprintf("%s/%s/%s", packageName, exportedChart.Name, splitChartVersion[0])
 				latestRCSeenSoFar, ok := latestRC[chartVersionWithNameWithoutRC]
 				if !ok {
 					// First time seeing this RC
 					latestRC[chartVersionWithNameWithoutRC] = chartVersionWithName
+					latestRCPaths[chartVersionWithNameWithoutRC] = exportedChart.Path
 					continue
 				}
 				// Compare with existing value
-				if latestRCSeenSoFar >= chartVersionWithName {
-					continue
+				olderRCPath := exportedChart.Path
+				if latestRCSeenSoFar < chartVersionWithName {
+					olderRCPath = latestRCPaths[chartVersionWithNameWithoutRC]
+					latestRC[chartVersionWithNameWithoutRC] = chartVersionWithName
+					latestRCPaths[chartVersionWithNameWithoutRC] = exportedChart.Path
 				}
-				latestRC[chartVersionWithNameWithoutRC] = chartVersionWithName
-				if err := filesystem.RemoveAll(rootFs, filepath.Join(path, latestRCSeenSoFar)); err != nil {
-					return fmt.Errorf("Failed to remove older RC %s: %s", filepath.Join(path, latestRCSeenSoFar), err)
+				if err := filesystem.RemoveAll(rootFs, olderRCPath); err != nil {
+					return fmt.Errorf("Failed to remove older RC %s: %s", olderRCPath, err)
 				}
-				logrus.Infof("Purged old release candidate version: %s", latestRCSeenSoFar)
+				logrus.Infof("Purged old release candidate version: %s", olderRCPath)
 			}
-			return nil
-		})
-		if err != nil {
-			return err
 		}
 		// pretty print on the console
 		prettyLatestRC, err := json.MarshalIndent(latestRC, "", " ")
@@ -114,34 +101,40 @@ func CompareGeneratedAssets(rootFs billy.Filesystem, newCharts, newAssets, origi
 			logrus.Infof("Found the following latest release candidate versions: %s", prettyLatestRC)
 		}
 		// Export each helm chart to newChartsWithoutRC
-		err = filesystem.WalkDir(rootFs, newCharts, func(rootFs billy.Filesystem, path string, isDir bool) error {
-			// new-assets/charts/{package}/{chart}/{version}
-			if strings.Count(path, "/") != 4 {
-				return nil
-			}
-			if !isDir {
-				return fmt.Errorf("Expected chart version to be found at %s, but that path does not represent a directory", path)
-			}
-			packageName := filepath.Base(filepath.Dir(filepath.Dir(path)))
-			err := helm.TrimRCVersionFromHelmChart(rootFs, path)
-			if err != nil {
-				return fmt.Errorf("Encountered error when dropping rc from %s", path)
-			}
-			err = helm.ExportHelmChart(rootFs, rootFs, path, "", filepath.Join(newAssetsWithoutRC, packageName), filepath.Join(newChartsWithoutRC, packageName))
-			if err != nil {
-				return fmt.Errorf("Encountered error when re-exporting latest releaseCandidateVersion of package without the version: %s", err)
-			}
-			return nil
-		})
+		newExportedCharts, err = getExportedCharts(rootFs, newCharts)
 		if err != nil {
-			return err
+			return fmt.Errorf("Encountered error while trying to get the charts within %s: %s", newCharts, err)
+		}
+		for packageName, exportedCharts := range newExportedCharts {
+			for _, exportedChart := range exportedCharts {
+				err := helm.TrimRCVersionFromHelmChart(rootFs, exportedChart.Path)
+				if err != nil {
+					return fmt.Errorf("Encountered error when dropping rc from %s", exportedChart.Path)
+				}
+				err = helm.ExportHelmChart(rootFs, rootFs, exportedChart.Path, "", "", nil, nil, filepath.Join(newAssetsWithoutRC, packageName), filepath.Join(newChartsWithoutRC, packageName))
+				if err != nil {
+					return fmt.Errorf("Encountered error when re-exporting latest releaseCandidateVersion of package without the version: %s", err)
+				}
+			}
 		}
 		checkCharts = newChartsWithoutRC
 		checkAssets = newAssetsWithoutRC
 	}
-	// level is 4 since the structure is charts/{package}/{chart}/{version}
-	if err := change.DoesNotModifyContentsAtLevel(rootFs, originalCharts, checkCharts, 4); err != nil {
-		return err
+	// Only chart versions that already exist in originalCharts are compared, wherever the chart template places them
+	checkExportedCharts, err := getExportedCharts(rootFs, checkCharts)
+	if err != nil {
+		return fmt.Errorf("Encountered error while trying to get the charts within %s: %s", checkCharts, err)
+	}
+	for _, exportedCharts := range checkExportedCharts {
+		for _, exportedChart := range exportedCharts {
+			relChartPath, err := filepath.Rel(checkCharts, exportedChart.Path)
+			if err != nil {
+				return err
+			}
+			if err := change.DoesNotModifyContentsAtLevel(rootFs, filepath.Join(originalCharts, relChartPath), exportedChart.Path, 1); err != nil {
+				return err
+			}
+		}
 	}
 	if !keepNewAssets {
 		return nil
@@ -172,3 +165,27 @@ func CompareGeneratedAssets(rootFs billy.Filesystem, newCharts, newAssets, origi
 	}
 	return nil
 }
+
+// getExportedCharts returns every chart version found within the charts directory, keyed by the name of the package it belongs to
+func getExportedCharts(rootFs billy.Filesystem, chartsDir string) (map[string][]helm.ExportedChart, error) {
+	exportedCharts := make(map[string][]helm.ExportedChart)
+	exists, err := filesystem.PathExists(rootFs, chartsDir)
+	if err != nil || !exists {
+		return exportedCharts, err
+	}
+	packageInfos, err := rootFs.ReadDir(chartsDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, packageInfo := range packageInfos {
+		if !packageInfo.IsDir() {
+			continue
+		}
+		packageExportedCharts, err := helm.GetExportedCharts(rootFs, filepath.Join(chartsDir, packageInfo.Name()))
+		if err != nil {
+			return nil, err
+		}
+		exportedCharts[packageInfo.Name()] = packageExportedCharts
+	}
+	return exportedCharts, nil
+}
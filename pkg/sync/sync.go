@@ -11,6 +11,7 @@ import (
 	"github.com/rancher/charts-build-scripts/pkg/options"
 	"github.com/rancher/charts-build-scripts/pkg/path"
 	"github.com/rancher/charts-build-scripts/pkg/puller"
+	"github.com/rancher/charts-build-scripts/pkg/transient"
 	"github.com/sirupsen/logrus"
 )
 
@@ -31,8 +32,12 @@ func SynchronizeRepository(rootFs billy.Filesystem, compareGeneratedAssetsOption
 		}
 		defer filesystem.RemoveAll(rootFs, d)
 	}
-	defer filesystem.RemoveAll(rootFs, path.ChartsRepositoryCurrentBranchDir)
-	defer filesystem.RemoveAll(rootFs, path.ChartsRepositoryUpstreamBranchDir)
+	for _, d := range []string{path.ChartsRepositoryCurrentBranchDir, path.ChartsRepositoryUpstreamBranchDir} {
+		if err := transient.Track(rootFs, d); err != nil {
+			return err
+		}
+		defer transient.Remove(rootFs, d)
+	}
 	// Copy current assets to original assets
 	packages, err := charts.GetPackages(rootFs.Root(), "")
 	if err != nil {
@@ -4,17 +4,21 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/go-git/go-billy/v5"
 	"github.com/rancher/charts-build-scripts/pkg/charts"
 	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/helm"
 	"github.com/rancher/charts-build-scripts/pkg/options"
 	"github.com/rancher/charts-build-scripts/pkg/path"
 	"github.com/rancher/charts-build-scripts/pkg/puller"
+	"github.com/rancher/charts-build-scripts/pkg/transient"
+	"github.com/sirupsen/logrus"
 )
 
-// ValidateRepository validates that the generated assets of the current repository doesn't conflict with the generated assets of the repository in upstreamConfig
-func ValidateRepository(rootFs billy.Filesystem, compareGeneratedAssetsOptions options.CompareGeneratedAssetsOptions, currentChart string) error {
+// ValidateRepository validates that the generated assets of the packages of the current repository doesn't conflict with the generated assets of the repository in upstreamConfig
+func ValidateRepository(rootFs billy.Filesystem, compareGeneratedAssetsOptions options.CompareGeneratedAssetsOptions, packages []*charts.Package) error {
 	// Create directories
 	originalAssets := filepath.Join(path.ChartsRepositoryCurrentBranchDir, path.RepositoryAssetsDir)
 	originalCharts := filepath.Join(path.ChartsRepositoryCurrentBranchDir, path.RepositoryChartsDir)
@@ -41,15 +45,27 @@ func ValidateRepository(rootFs billy.Filesystem, compareGeneratedAssetsOptions o
 	if removeHelmIndex {
 		defer filesystem.RemoveAll(rootFs, path.RepositoryHelmIndexFile)
 	}
-	defer filesystem.RemoveAll(rootFs, path.ChartsRepositoryCurrentBranchDir)
-	defer filesystem.RemoveAll(rootFs, path.ChartsRepositoryUpstreamBranchDir)
-	// Copy current assets to new assets
-	packages, err := charts.GetPackages(rootFs.Root(), currentChart)
-	if err != nil {
-		return fmt.Errorf("Failed to get packages in %s: %s", path.ChartsRepositoryUpstreamBranchDir, err)
+	// Generating charts also writes the files that redirect from the previous URLs of the repository along with the Helm index
+	for _, redirectPath := range helm.GetRepositoryRedirectPaths() {
+		existed, err := filesystem.PathExists(rootFs, redirectPath)
+		if err != nil {
+			return fmt.Errorf("Failed to check if path exists %s: %s", redirectPath, err)
+		}
+		if existed {
+			continue
+		}
+		defer filesystem.PruneEmptyDirsInPath(rootFs, filepath.Dir(redirectPath))
+		defer filesystem.RemoveAll(rootFs, redirectPath)
+	}
+	for _, d := range []string{path.ChartsRepositoryCurrentBranchDir, path.ChartsRepositoryUpstreamBranchDir} {
+		if err := transient.Track(rootFs, d); err != nil {
+			return err
+		}
+		defer transient.Remove(rootFs, d)
 	}
+	// Copy current assets to new assets
 	for _, p := range packages {
-		if err = p.GenerateCharts(); err != nil {
+		if err := p.GenerateCharts(); err != nil {
 			return err
 		}
 	}
@@ -67,15 +83,18 @@ func ValidateRepository(rootFs billy.Filesystem, compareGeneratedAssetsOptions o
 	if err := originalChartsUpstream.Pull(rootFs, rootFs, path.ChartsRepositoryCurrentBranchDir); err != nil {
 		return fmt.Errorf("Failed to pull chart from upstream: %s", err)
 	}
-	packages, err = charts.GetPackages(filesystem.GetAbsPath(rootFs, path.ChartsRepositoryCurrentBranchDir), "")
+	originalPackages, err := charts.GetPackages(filesystem.GetAbsPath(rootFs, path.ChartsRepositoryCurrentBranchDir), "")
 	if err != nil {
 		return fmt.Errorf("Failed to get packages in %s: %s", path.ChartsRepositoryCurrentBranchDir, err)
 	}
-	for _, p := range packages {
+	for _, p := range originalPackages {
 		if err = p.GenerateCharts(); err != nil {
 			return err
 		}
 	}
+	if err := warnOnAPIVersionV1Charts(rootFs, newCharts, originalCharts); err != nil {
+		return err
+	}
 	// Compare the generated assets, but don't keep the new assets
 	err = CompareGeneratedAssets(rootFs, newCharts, newAssets, originalCharts, originalAssets, compareGeneratedAssetsOptions.DropReleaseCandidates, false)
 	if err != nil {
@@ -83,3 +102,36 @@ func ValidateRepository(rootFs billy.Filesystem, compareGeneratedAssetsOptions o
 	}
 	return nil
 }
+
+// warnOnAPIVersionV1Charts logs a warning for each chart in newCharts that uses apiVersion v1 and does not already exist in originalCharts
+func warnOnAPIVersionV1Charts(rootFs billy.Filesystem, newCharts, originalCharts string) error {
+	return filesystem.WalkDir(rootFs, newCharts, func(fs billy.Filesystem, chartYamlPath string, isDir bool) error {
+		if isDir || filepath.Base(chartYamlPath) != "Chart.yaml" {
+			return nil
+		}
+		// Only consider Chart.yaml files found at {package}/{chart}/{version}/Chart.yaml, which excludes subcharts
+		helmChartPath := filepath.Dir(chartYamlPath)
+		relativeChartPath, err := filepath.Rel(newCharts, helmChartPath)
+		if err != nil {
+			return err
+		}
+		if len(strings.Split(relativeChartPath, string(filepath.Separator))) != 3 {
+			return nil
+		}
+		exists, err := filesystem.PathExists(fs, filepath.Join(originalCharts, relativeChartPath))
+		if err != nil {
+			return err
+		}
+		if exists {
+			return nil
+		}
+		isV1, err := helm.IsAPIVersionV1Chart(fs, helmChartPath)
+		if err != nil {
+			return err
+		}
+		if isV1 {
+			logrus.Warnf("Chart %s uses apiVersion v1; charts should be migrated to apiVersion v2", relativeChartPath)
+		}
+		return nil
+	})
+}
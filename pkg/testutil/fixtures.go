@@ -0,0 +1,46 @@
+package testutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+)
+
+// NewFilesystem returns a filesystem rooted at a new temporary directory that is removed when the test completes
+func NewFilesystem(t testing.TB) billy.Filesystem {
+	t.Helper()
+	return filesystem.GetFilesystem(t.TempDir())
+}
+
+// LoadFixture returns a filesystem rooted at a temporary copy of the fixture directory at fixtureDir, e.g. testdata/repository
+// Tests can modify the filesystem freely since the fixture itself is never changed
+func LoadFixture(t testing.TB, fixtureDir string) billy.Filesystem {
+	t.Helper()
+	absFixtureDir, err := filepath.Abs(fixtureDir)
+	if err != nil {
+		t.Fatalf("Unable to get absolute path of fixture %s: %s", fixtureDir, err)
+	}
+	fs := NewFilesystem(t)
+	if err := filesystem.CopyFromLocalPath(absFixtureDir, fs, ""); err != nil {
+		t.Fatalf("Unable to load fixture %s: %s", fixtureDir, err)
+	}
+	return fs
+}
+
+// WriteFiles writes each file in files, keyed by its path within the filesystem, creating any parent directories
+func WriteFiles(t testing.TB, fs billy.Filesystem, files map[string]string) {
+	t.Helper()
+	for path, contents := range files {
+		absPath := filesystem.GetAbsPath(fs, path)
+		if err := os.MkdirAll(filepath.Dir(absPath), 0755); err != nil {
+			t.Fatalf("Unable to create parent directories of %s: %s", path, err)
+		}
+		if err := ioutil.WriteFile(absPath, []byte(contents), 0644); err != nil {
+			t.Fatalf("Unable to write %s: %s", path, err)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package testutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/diff"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+)
+
+const (
+	// UpdateGoldenEnvironmentVariable is the environment variable that, if set to true, overwrites golden directories with the actual contents instead of comparing them
+	UpdateGoldenEnvironmentVariable = "CHARTS_UPDATE_GOLDEN"
+)
+
+// AssertGoldenDir fails the test if the contents of the directory at path within the filesystem differ from those of the golden directory at goldenDir, e.g. testdata/golden/charts
+// The failure contains the unified diff from the golden directory to the actual contents. Set CHARTS_UPDATE_GOLDEN=true to overwrite the golden directory instead
+func AssertGoldenDir(t testing.TB, fs billy.Filesystem, path, goldenDir string) {
+	t.Helper()
+	absGoldenDir, err := filepath.Abs(goldenDir)
+	if err != nil {
+		t.Fatalf("Unable to get absolute path of golden directory %s: %s", goldenDir, err)
+	}
+	if os.Getenv(UpdateGoldenEnvironmentVariable) == "true" {
+		if err := os.RemoveAll(absGoldenDir); err != nil {
+			t.Fatalf("Unable to remove golden directory %s: %s", goldenDir, err)
+		}
+		if err := filesystem.CopyFromLocalPath(filesystem.GetAbsPath(fs, path), filesystem.GetFilesystem(absGoldenDir), ""); err != nil {
+			t.Fatalf("Unable to update golden directory %s: %s", goldenDir, err)
+		}
+		return
+	}
+	goldenPath := path + "-golden"
+	if err := filesystem.CopyFromLocalPath(absGoldenDir, fs, goldenPath); err != nil {
+		t.Fatalf("Unable to load golden directory %s: %s", goldenDir, err)
+	}
+	defer filesystem.RemoveAll(fs, goldenPath)
+	d, err := diff.GenerateDiff(fs, goldenPath, path)
+	if err != nil {
+		t.Fatalf("Unable to compare %s against golden directory %s: %s", path, goldenDir, err)
+	}
+	if len(d) > 0 {
+		t.Errorf("%s does not match golden directory %s (set %s=true to update it):\n%s", path, goldenDir, UpdateGoldenEnvironmentVariable, d)
+	}
+}
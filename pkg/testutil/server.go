@@ -0,0 +1,109 @@
+package testutil
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+)
+
+// UpstreamServer is a fake upstream that serves files over HTTP, e.g. chart archives or a Helm repository index
+type UpstreamServer struct {
+	*httptest.Server
+
+	lock     sync.Mutex
+	files    map[string][]byte
+	requests map[string]int
+}
+
+// NewUpstreamServer starts an UpstreamServer that serves each file in files, keyed by its URL path, until the test completes
+func NewUpstreamServer(t testing.TB, files map[string][]byte) *UpstreamServer {
+	t.Helper()
+	s := &UpstreamServer{
+		files:    make(map[string][]byte),
+		requests: make(map[string]int),
+	}
+	for urlPath, contents := range files {
+		s.SetFile(urlPath, contents)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// SetFile serves contents at the URL path, replacing any file already served there
+func (s *UpstreamServer) SetFile(urlPath string, contents []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.files[urlPath] = contents
+}
+
+// Requests returns the number of requests received for the URL path
+func (s *UpstreamServer) Requests(urlPath string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.requests[urlPath]
+}
+
+func (s *UpstreamServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	s.requests[r.URL.Path]++
+	contents, ok := s.files[r.URL.Path]
+	s.lock.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write(contents)
+}
+
+// ArchiveDir returns a gzipped tarball of the directory at path within the filesystem, with every file placed under rootDir within the tarball, e.g. chart/
+func ArchiveDir(t testing.TB, fs billy.Filesystem, path, rootDir string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+	absPath := filesystem.GetAbsPath(fs, path)
+	err := filepath.Walk(absPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(absPath, filePath)
+		if err != nil {
+			return err
+		}
+		contents, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		header := &tar.Header{
+			Name: filepath.ToSlash(filepath.Join(rootDir, relPath)),
+			Mode: 0644,
+			Size: int64(len(contents)),
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err = tarWriter.Write(contents)
+		return err
+	})
+	if err == nil {
+		err = tarWriter.Close()
+	}
+	if err == nil {
+		err = gzipWriter.Close()
+	}
+	if err != nil {
+		t.Fatalf("Unable to archive %s: %s", path, err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,91 @@
+package testutil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+)
+
+// recordingTB records whether a test helper failed the test instead of failing the test that runs it
+type recordingTB struct {
+	testing.TB
+	failed bool
+}
+
+func (r *recordingTB) Helper() {}
+
+func (r *recordingTB) Errorf(format string, args ...interface{}) {
+	r.failed = true
+}
+
+func (r *recordingTB) Fatalf(format string, args ...interface{}) {
+	r.failed = true
+}
+
+func TestLoadFixture(t *testing.T) {
+	fs := LoadFixture(t, "testdata/chart")
+	WriteFiles(t, fs, map[string]string{
+		"values.yaml":           "replicas: 2\n",
+		"templates/secret.yaml": "kind: Secret\n",
+	})
+	for path, expected := range map[string]string{
+		"Chart.yaml":            "apiVersion: v2\nname: chart\nversion: 1.0.0\n",
+		"values.yaml":           "replicas: 2\n",
+		"templates/secret.yaml": "kind: Secret\n",
+	} {
+		actual, err := ioutil.ReadFile(filesystem.GetAbsPath(fs, path))
+		if err != nil {
+			t.Fatalf("unable to read %s: %s", path, err)
+		}
+		if string(actual) != expected {
+			t.Fatalf("expected %s to contain %q, got %q", path, expected, actual)
+		}
+	}
+	fixtureValues, err := ioutil.ReadFile("testdata/chart/values.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(fixtureValues) != "replicas: 1\n" {
+		t.Fatalf("writing to a loaded fixture modified the fixture itself")
+	}
+}
+
+func TestAssertGoldenDir(t *testing.T) {
+	fs := NewFilesystem(t)
+	WriteFiles(t, fs, map[string]string{
+		"chart/Chart.yaml":        "apiVersion: v2\nname: chart\nversion: 1.0.0\n",
+		"chart/values.yaml":       "replicas: 1\n",
+		"chart/templates/cm.yaml": "kind: ConfigMap\napiVersion: v1\nmetadata:\n  name: chart\n",
+	})
+	AssertGoldenDir(t, fs, "chart", "testdata/chart")
+
+	WriteFiles(t, fs, map[string]string{"chart/values.yaml": "replicas: 2\n"})
+	recorder := &recordingTB{TB: t}
+	AssertGoldenDir(recorder, fs, "chart", "testdata/chart")
+	if !recorder.failed {
+		t.Fatalf("expected a modified directory not to match its golden directory")
+	}
+}
+
+func TestUpstreamServer(t *testing.T) {
+	fixtureFs := LoadFixture(t, "testdata/chart")
+	server := NewUpstreamServer(t, map[string][]byte{
+		"/chart-1.0.0.tgz": ArchiveDir(t, fixtureFs, "", "chart"),
+	})
+	fs := NewFilesystem(t)
+	if err := filesystem.GetChartArchive(fs, fmt.Sprintf("%s/chart-1.0.0.tgz", server.URL), "chart.tgz"); err != nil {
+		t.Fatalf("unable to get chart archive: %s", err)
+	}
+	if err := filesystem.UnarchiveTgz(fs, "chart.tgz", "", "chart", false); err != nil {
+		t.Fatalf("unable to unarchive chart archive: %s", err)
+	}
+	AssertGoldenDir(t, fs, "chart", "testdata/chart")
+	if requests := server.Requests("/chart-1.0.0.tgz"); requests != 1 {
+		t.Fatalf("expected 1 request for the chart archive, got %d", requests)
+	}
+	if err := filesystem.GetChartArchive(fs, fmt.Sprintf("%s/missing.tgz", server.URL), "missing.tgz"); err == nil {
+		t.Fatalf("expected an error for a file that is not served")
+	}
+}
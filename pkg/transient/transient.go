@@ -0,0 +1,218 @@
+package transient
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/path"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	// repoRoot is the repository whose transient artifacts are tracked, or empty if tracking has not been started
+	repoRoot string
+	// hostname is the name of the host that the current run runs on
+	hostname string
+	// manifestLock guards the manifest, since packages may be processed concurrently
+	manifestLock sync.Mutex
+)
+
+// manifest represents the transient artifacts that runs created within the repository and have not removed yet
+type manifest struct {
+	// Artifacts are the artifacts tracked within the repository
+	Artifacts []artifact `yaml:"artifacts"`
+}
+
+// artifact represents a transient artifact along with the run that owns it
+type artifact struct {
+	// Path is the path to the artifact relative to the root of the repository
+	Path string `yaml:"path"`
+	// PID is the process ID of the run that created the artifact
+	PID int `yaml:"pid"`
+	// Hostname is the name of the host that the run that created the artifact runs on
+	Hostname string `yaml:"hostname,omitempty"`
+}
+
+// isOwnedByLiveRun returns whether the run that created the artifact may still be running and is not the current run
+// Runs on other hosts are always assumed to be running, since whether they are cannot be checked
+func (a artifact) isOwnedByLiveRun() bool {
+	if a.Hostname != hostname {
+		return true
+	}
+	if a.PID == os.Getpid() || a.PID <= 0 {
+		return false
+	}
+	process, err := os.FindProcess(a.PID)
+	if err != nil {
+		return false
+	}
+	// Signal 0 only checks whether the process exists. A process owned by another user still exists, but cannot be signaled
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// Start tracks transient artifacts created within the repository in the current working directory from now on
+// Artifacts are removed if the run exits with a fatal error or is interrupted before it removes them itself
+func Start() error {
+	var err error
+	repoRoot, err = os.Getwd()
+	if err != nil {
+		return fmt.Errorf("Unable to get current working directory: %s", err)
+	}
+	hostname, err = os.Hostname()
+	if err != nil {
+		return fmt.Errorf("Unable to get hostname: %s", err)
+	}
+	// Runs that end with a fatal error exit without running their deferred removals
+	logrus.RegisterExitHandler(Cleanup)
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-interrupts
+		logrus.Warnf("Received %s, removing transient artifacts before exiting", sig)
+		// Exiting through logrus runs every exit handler, including Cleanup
+		logrus.Exit(1)
+	}()
+	return nil
+}
+
+// Track records that the path within fs is a transient artifact of the current run, which should be removed with Remove once it is no longer needed
+// Paths outside of the repository are not tracked
+func Track(fs billy.Filesystem, artifactPath string) error {
+	relPath, ok := getRepositoryPath(fs, artifactPath)
+	if !ok {
+		return nil
+	}
+	manifestLock.Lock()
+	defer manifestLock.Unlock()
+	m, err := loadManifest()
+	if err != nil {
+		return err
+	}
+	for _, a := range m.Artifacts {
+		if a.Path == relPath {
+			return nil
+		}
+	}
+	m.Artifacts = append(m.Artifacts, artifact{Path: relPath, PID: os.Getpid(), Hostname: hostname})
+	sort.Slice(m.Artifacts, func(i, j int) bool {
+		return m.Artifacts[i].Path < m.Artifacts[j].Path
+	})
+	return saveManifest(m)
+}
+
+// Remove removes the transient artifact at the path within fs and stops tracking it
+func Remove(fs billy.Filesystem, artifactPath string) error {
+	if err := filesystem.RemoveAll(fs, artifactPath); err != nil {
+		return err
+	}
+	relPath, ok := getRepositoryPath(fs, artifactPath)
+	if !ok {
+		return nil
+	}
+	manifestLock.Lock()
+	defer manifestLock.Unlock()
+	m, err := loadManifest()
+	if err != nil {
+		return err
+	}
+	artifacts := m.Artifacts[:0]
+	for _, a := range m.Artifacts {
+		if a.Path != relPath {
+			artifacts = append(artifacts, a)
+		}
+	}
+	m.Artifacts = artifacts
+	return saveManifest(m)
+}
+
+// Clean removes the transient artifacts tracked within the repository that were created by the current run or by a run that is no longer running, e.g. one that crashed,
+// and returns their paths relative to the root of the repository. Artifacts of runs that are still running in the same repository are left untouched
+func Clean() ([]string, error) {
+	if len(repoRoot) == 0 {
+		return nil, nil
+	}
+	manifestLock.Lock()
+	defer manifestLock.Unlock()
+	m, err := loadManifest()
+	if err != nil {
+		return nil, err
+	}
+	rootFs := filesystem.GetFilesystem(repoRoot)
+	var removed []string
+	var kept []artifact
+	for _, a := range m.Artifacts {
+		if a.isOwnedByLiveRun() {
+			logrus.Debugf("Keeping transient artifact %s of run %d on %s that is still running", a.Path, a.PID, a.Hostname)
+			kept = append(kept, a)
+			continue
+		}
+		if err := filesystem.RemoveAll(rootFs, a.Path); err != nil {
+			return removed, fmt.Errorf("Encountered error while trying to remove transient artifact %s: %s", a.Path, err)
+		}
+		removed = append(removed, a.Path)
+	}
+	return removed, saveManifest(manifest{Artifacts: kept})
+}
+
+// Cleanup removes every transient artifact tracked within the repository, logging any error instead of returning it
+func Cleanup() {
+	if _, err := Clean(); err != nil {
+		logrus.Error(err)
+	}
+}
+
+// getRepositoryPath returns the path within fs relative to the root of the repository, if tracking has been started and the path is within the repository
+func getRepositoryPath(fs billy.Filesystem, artifactPath string) (string, bool) {
+	if len(repoRoot) == 0 {
+		return "", false
+	}
+	relPath, err := filepath.Rel(repoRoot, filesystem.GetAbsPath(fs, artifactPath))
+	if err != nil || relPath == "." || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return relPath, true
+}
+
+// loadManifest returns the transient artifacts tracked within the repository
+func loadManifest() (manifest, error) {
+	var m manifest
+	manifestBytes, err := ioutil.ReadFile(filepath.Join(repoRoot, path.RepositoryTransientArtifactsFile))
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return m, err
+	}
+	if err := yaml.Unmarshal(manifestBytes, &m); err != nil {
+		return m, fmt.Errorf("Unable to parse %s: %s", path.RepositoryTransientArtifactsFile, err)
+	}
+	return m, nil
+}
+
+// saveManifest records the transient artifacts tracked within the repository, removing the manifest if there are none
+func saveManifest(m manifest) error {
+	manifestPath := filepath.Join(repoRoot, path.RepositoryTransientArtifactsFile)
+	if len(m.Artifacts) == 0 {
+		if err := os.Remove(manifestPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	manifestBytes, err := yaml.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(manifestPath, manifestBytes, 0644)
+}
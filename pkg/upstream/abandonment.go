@@ -0,0 +1,115 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/rancher/charts-build-scripts/pkg/charts"
+	"github.com/rancher/charts-build-scripts/pkg/puller"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+)
+
+// Status represents the health of the upstream of a chart within a package
+type Status struct {
+	// Package is the name of the package whose chart uses this upstream
+	Package string
+	// WorkingDir is the working directory of the chart that uses this upstream
+	WorkingDir string
+	// Upstream is a string representation of the upstream
+	Upstream string
+	// Archived indicates that the upstream repository has been archived
+	Archived bool
+	// LastActivity is the time of the latest commit or release observed on the upstream repository
+	LastActivity time.Time
+	// Stale indicates that there has been no activity on the upstream repository within the configured window
+	Stale bool
+}
+
+// IsAbandoned returns whether the upstream seems to be no longer maintained
+func (s Status) IsAbandoned() bool {
+	return s.Archived || s.Stale
+}
+
+func (s Status) String() string {
+	if s.Archived {
+		return fmt.Sprintf("%s (%s): upstream %s has been archived", s.Package, s.WorkingDir, s.Upstream)
+	}
+	if s.Stale {
+		return fmt.Sprintf("%s (%s): upstream %s has had no commits or releases since %s", s.Package, s.WorkingDir, s.Upstream, s.LastActivity.Format("2006-01-02"))
+	}
+	return fmt.Sprintf("%s (%s): upstream %s was last active on %s", s.Package, s.WorkingDir, s.Upstream, s.LastActivity.Format("2006-01-02"))
+}
+
+// NewGithubClient returns a client for the Github API that uses the token provided, if any
+func NewGithubClient(ctx context.Context, token string) *github.Client {
+	var httpClient *http.Client
+	if len(token) > 0 {
+		httpClient = oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}))
+	}
+	return github.NewClient(httpClient)
+}
+
+// CheckAbandonment returns the Status of every Github upstream used by the packages provided
+// An upstream is considered stale if it has had no commits or releases within the window provided
+func CheckAbandonment(ctx context.Context, client *github.Client, packages []*charts.Package, window time.Duration) ([]Status, error) {
+	var statuses []Status
+	for _, p := range packages {
+		upstreams := map[string]puller.Puller{
+			p.Chart.WorkingDir: p.Chart.Upstream,
+		}
+		for _, additionalChart := range p.AdditionalCharts {
+			if additionalChart.Upstream != nil {
+				upstreams[additionalChart.WorkingDir] = *additionalChart.Upstream
+			}
+		}
+		for workingDir, u := range upstreams {
+			githubRepo, ok := puller.Unwrap(u).(puller.GithubRepository)
+			if !ok {
+				logrus.Debugf("Skipping abandonment check for %s (%s) since upstream %s is not a Github repository", p.Name, workingDir, u)
+				continue
+			}
+			status, err := checkGithubRepository(ctx, client, githubRepo, window)
+			if err != nil {
+				return nil, fmt.Errorf("Encountered error while checking upstream %s of package %s: %s", githubRepo, p.Name, err)
+			}
+			status.Package = p.Name
+			status.WorkingDir = workingDir
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses, nil
+}
+
+// checkGithubRepository returns the Status of a Github repository
+func checkGithubRepository(ctx context.Context, client *github.Client, r puller.GithubRepository, window time.Duration) (Status, error) {
+	status := Status{
+		Upstream: fmt.Sprintf("%s/%s", r.GetOwner(), r.GetName()),
+	}
+	repo, _, err := client.Repositories.Get(ctx, r.GetOwner(), r.GetName())
+	if err != nil {
+		return status, err
+	}
+	status.Archived = repo.GetArchived()
+	commits, _, err := client.Repositories.ListCommits(ctx, r.GetOwner(), r.GetName(), &github.CommitsListOptions{
+		ListOptions: github.ListOptions{PerPage: 1},
+	})
+	if err != nil {
+		return status, err
+	}
+	if len(commits) > 0 {
+		status.LastActivity = commits[0].GetCommit().GetCommitter().GetDate()
+	}
+	release, resp, err := client.Repositories.GetLatestRelease(ctx, r.GetOwner(), r.GetName())
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+		return status, err
+	}
+	if release != nil && release.GetPublishedAt().After(status.LastActivity) {
+		status.LastActivity = release.GetPublishedAt().Time
+	}
+	status.Stale = time.Since(status.LastActivity) > window
+	return status, nil
+}
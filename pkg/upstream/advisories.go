@@ -0,0 +1,237 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/google/go-github/github"
+	"github.com/rancher/charts-build-scripts/pkg/puller"
+)
+
+var (
+	// securityIDRegex matches identifiers of vulnerabilities mentioned in release notes, e.g. CVE-2021-25741 or GHSA-f5f7-6478-qm6p
+	securityIDRegex = regexp.MustCompile(`\b(CVE-\d{4}-\d{4,}|GHSA(?:-[23456789cfghjmpqrvwx]{4}){3})\b`)
+	// securityNoteRegex matches release notes that describe a security fix without identifying the vulnerability
+	securityNoteRegex = regexp.MustCompile(`(?i)\b(security (fix|issue|patch|release|update)|vulnerabilit(y|ies))\b`)
+	// patchedVersionRegex matches the version within the patched versions of an advisory, e.g. >= 1.2.3
+	patchedVersionRegex = regexp.MustCompile(`v?\d+\.\d+(?:\.\d+)?(?:-[0-9A-Za-z.-]+)?`)
+)
+
+// SecurityFix represents a security fix released by an upstream in one of the versions that an update moves past
+type SecurityFix struct {
+	// ID identifies the vulnerability, e.g. a GHSA or CVE identifier, or is empty if the release notes describe a security fix without identifying it
+	ID string
+	// Version is the version of the upstream that fixes the vulnerability
+	Version string
+	// Severity is the severity of the vulnerability, if published in a security advisory
+	Severity string
+	// Summary describes the vulnerability
+	Summary string
+}
+
+func (f SecurityFix) String() string {
+	id := f.ID
+	if len(id) == 0 {
+		id = "unidentified"
+	}
+	if len(f.Severity) > 0 {
+		return fmt.Sprintf("%s (%s) fixed in %s: %s", id, f.Severity, f.Version, f.Summary)
+	}
+	return fmt.Sprintf("%s fixed in %s: %s", id, f.Version, f.Summary)
+}
+
+// securityAdvisory represents a security advisory published on a Github repository
+type securityAdvisory struct {
+	GHSAID          string                  `json:"ghsa_id"`
+	CVEID           string                  `json:"cve_id"`
+	Summary         string                  `json:"summary"`
+	Severity        string                  `json:"severity"`
+	PublishedAt     time.Time               `json:"published_at"`
+	Vulnerabilities []advisoryVulnerability `json:"vulnerabilities"`
+}
+
+// advisoryVulnerability represents the versions affected by a security advisory
+type advisoryVulnerability struct {
+	PatchedVersions string `json:"patched_versions"`
+}
+
+// bumpRange represents the versions of an upstream that an update moves past, i.e. those newer than the current version up to and including the latest version
+type bumpRange struct {
+	// prefix is the prefix of the tags of the upstream, e.g. kube-prometheus-stack-
+	prefix string
+	// current is the version that the upstream is pinned to, or nil if it is pinned to a commit that is not tagged
+	current *semver.Version
+	// since is the time of the commit that the upstream is pinned to, which bounds the versions considered if current is nil
+	since time.Time
+	// latest is the version that the upstream is updated to
+	latest *semver.Version
+}
+
+// newBumpRange returns the versions moved past by updating from current to latest, where current is empty if the upstream is pinned to the commit made at since
+func newBumpRange(current, latest string, since time.Time) (*bumpRange, bool) {
+	prefix, latestVersion, ok := parseVersion(latest)
+	if !ok {
+		return nil, false
+	}
+	r := &bumpRange{prefix: prefix, since: since, latest: latestVersion}
+	if len(current) > 0 {
+		if _, r.current, ok = parseVersion(current); !ok {
+			return nil, false
+		}
+	}
+	return r, true
+}
+
+// contains returns whether the version, released at the time provided, is moved past by the update
+func (r *bumpRange) contains(version *semver.Version, releasedAt time.Time) bool {
+	if version.GreaterThan(r.latest) {
+		return false
+	}
+	if r.current != nil {
+		return version.GreaterThan(r.current)
+	}
+	return releasedAt.After(r.since)
+}
+
+// findSecurityFixes returns the security fixes released by the Github repository in the versions that the update moves past,
+// based on the security advisories published on the repository and on the notes of its releases
+func findSecurityFixes(ctx context.Context, client *github.Client, r puller.GithubRepository, bump *bumpRange) ([]SecurityFix, error) {
+	advisoryFixes, err := findAdvisoryFixes(ctx, client, r, bump)
+	if err != nil {
+		return nil, err
+	}
+	releaseNoteFixes, err := findReleaseNoteFixes(ctx, client, r, bump)
+	if err != nil {
+		return nil, err
+	}
+	fixes := advisoryFixes
+	found := make(map[string]bool, len(advisoryFixes))
+	for _, fix := range advisoryFixes {
+		found[fix.ID] = true
+	}
+	for _, fix := range releaseNoteFixes {
+		if len(fix.ID) > 0 && found[fix.ID] {
+			// The release notes refer to a published advisory, which describes the fix better
+			continue
+		}
+		fixes = append(fixes, fix)
+	}
+	return fixes, nil
+}
+
+// findAdvisoryFixes returns the security fixes published as security advisories on the Github repository that are patched in versions that the update moves past
+func findAdvisoryFixes(ctx context.Context, client *github.Client, r puller.GithubRepository, bump *bumpRange) ([]SecurityFix, error) {
+	var fixes []SecurityFix
+	page := 1
+	for {
+		req, err := client.NewRequest("GET", fmt.Sprintf("repos/%s/%s/security-advisories?state=published&per_page=100&page=%d", r.GetOwner(), r.GetName(), page), nil)
+		if err != nil {
+			return nil, err
+		}
+		var advisories []securityAdvisory
+		resp, err := client.Do(ctx, req, &advisories)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to list security advisories of %s/%s: %s", r.GetOwner(), r.GetName(), err)
+		}
+		for _, advisory := range advisories {
+			patchedVersion, ok := getPatchedVersion(advisory, bump)
+			if !ok {
+				continue
+			}
+			id := advisory.GHSAID
+			if len(advisory.CVEID) > 0 {
+				id = advisory.CVEID
+			}
+			fixes = append(fixes, SecurityFix{
+				ID:       id,
+				Version:  patchedVersion,
+				Severity: advisory.Severity,
+				Summary:  advisory.Summary,
+			})
+		}
+		if resp.NextPage == 0 {
+			return fixes, nil
+		}
+		page = resp.NextPage
+	}
+}
+
+// getPatchedVersion returns the lowest version that patches the vulnerability described by the advisory among the versions that the update moves past, if any
+func getPatchedVersion(advisory securityAdvisory, bump *bumpRange) (string, bool) {
+	var patched *semver.Version
+	for _, vulnerability := range advisory.Vulnerabilities {
+		for _, v := range patchedVersionRegex.FindAllString(vulnerability.PatchedVersions, -1) {
+			version, err := semver.NewVersion(v)
+			if err != nil || !bump.contains(version, advisory.PublishedAt) {
+				continue
+			}
+			if patched == nil || version.LessThan(patched) {
+				patched = version
+			}
+		}
+	}
+	if patched == nil {
+		return "", false
+	}
+	return patched.Original(), true
+}
+
+// findReleaseNoteFixes returns the security fixes described by the notes of the releases of the Github repository that the update moves past
+func findReleaseNoteFixes(ctx context.Context, client *github.Client, r puller.GithubRepository, bump *bumpRange) ([]SecurityFix, error) {
+	var fixes []SecurityFix
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		releases, resp, err := client.Repositories.ListReleases(ctx, r.GetOwner(), r.GetName(), opts)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to list releases of %s/%s: %s", r.GetOwner(), r.GetName(), err)
+		}
+		for _, release := range releases {
+			if release.GetDraft() || release.GetPrerelease() {
+				continue
+			}
+			prefix, version, ok := parseVersion(release.GetTagName())
+			if !ok || prefix != bump.prefix || !bump.contains(version, release.GetPublishedAt().Time) {
+				continue
+			}
+			fixes = append(fixes, getReleaseNoteFixes(release)...)
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return fixes, nil
+}
+
+// getReleaseNoteFixes returns the security fixes described by the notes of the release
+// Each vulnerability identified within the notes is a fix of its own, while notes that only describe a security fix produce a single unidentified fix
+func getReleaseNoteFixes(release *github.RepositoryRelease) []SecurityFix {
+	var fixes []SecurityFix
+	found := make(map[string]bool)
+	for _, line := range strings.Split(release.GetBody(), "\n") {
+		for _, id := range securityIDRegex.FindAllString(line, -1) {
+			if found[id] {
+				continue
+			}
+			found[id] = true
+			fixes = append(fixes, SecurityFix{
+				ID:      id,
+				Version: release.GetTagName(),
+				Summary: strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "-*")),
+			})
+		}
+	}
+	if len(fixes) == 0 {
+		if note := securityNoteRegex.FindString(release.GetBody()); len(note) > 0 {
+			fixes = append(fixes, SecurityFix{
+				Version: release.GetTagName(),
+				Summary: fmt.Sprintf("release notes mention %q", note),
+			})
+		}
+	}
+	return fixes
+}
@@ -0,0 +1,337 @@
+package upstream
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/go-git/go-billy/v5"
+	"github.com/google/go-github/github"
+	"github.com/rancher/charts-build-scripts/pkg/charts"
+	"github.com/rancher/charts-build-scripts/pkg/filesystem"
+	"github.com/rancher/charts-build-scripts/pkg/puller"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// CommitField is the option in the package.yaml that pins a Git upstream to a commit
+	CommitField = "commit"
+	// TagField is the option in the package.yaml that pins a Git upstream to a tag
+	TagField = "tag"
+	// VersionField is the option in the package.yaml that pins a Helm repository upstream to a chart version
+	VersionField = "version"
+)
+
+var (
+	// tagVersionRegex splits a tag into an arbitrary prefix and a version, e.g. kube-prometheus-stack-45.1.0 or v1.2.3
+	tagVersionRegex = regexp.MustCompile(`^(.*?)(v?\d+\.\d+(?:\.\d+)?(?:-[0-9A-Za-z.-]+)?(?:\+[0-9A-Za-z.-]+)?)$`)
+)
+
+// Update represents a newer version that the upstream of a chart within a package can be pinned to
+type Update struct {
+	// Package is the name of the package whose chart uses this upstream
+	Package string
+	// WorkingDir is the working directory of the chart that uses this upstream
+	WorkingDir string
+	// Upstream is a string representation of the upstream
+	Upstream string
+	// Field is the option in the package.yaml that pins the upstream, i.e. commit, tag, or version
+	Field string
+	// Current is the value that Field is currently pinned to
+	Current string
+	// Latest is the newest version of the upstream, e.g. a tag or a chart version
+	Latest string
+	// Pin is the value of Field that pins the upstream to Latest
+	Pin string
+	// SecurityFixes are the security fixes released by the upstream in the versions that the update moves past
+	SecurityFixes []SecurityFix
+}
+
+func (u Update) String() string {
+	var securityFixes string
+	if len(u.SecurityFixes) > 0 {
+		securityFixes = fmt.Sprintf(", which contains %d security fixes", len(u.SecurityFixes))
+	}
+	if u.Pin == u.Latest {
+		return fmt.Sprintf("%s (%s): upstream %s can be updated from %s %s to %s%s", u.Package, u.WorkingDir, u.Upstream, u.Field, u.Current, u.Latest, securityFixes)
+	}
+	return fmt.Sprintf("%s (%s): upstream %s can be updated from %s %s to %s (%s)%s", u.Package, u.WorkingDir, u.Upstream, u.Field, u.Current, u.Pin, u.Latest, securityFixes)
+}
+
+// FindUpdates returns an Update for every upstream used by the packages provided that has a newer version available
+// Git upstreams are compared against the tags of the repository, preferring published releases for Github repositories, while Helm repository upstreams are compared against the versions in its index.yaml
+// Only versions that follow semantic versioning and are not pre-releases are considered
+// Updates of Github repositories list the security fixes found in the security advisories and release notes of the versions they move past, and are returned before other updates
+func FindUpdates(ctx context.Context, client *github.Client, packages []*charts.Package) ([]Update, error) {
+	absTempDir, err := ioutil.TempDir("", "charts-build-scripts-auto-update-")
+	if err != nil {
+		return nil, fmt.Errorf("Encountered error while trying to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(absTempDir)
+	tempFs := filesystem.GetFilesystem(absTempDir)
+	var updates []Update
+	for _, p := range packages {
+		upstreams := map[string]puller.Puller{
+			p.Chart.WorkingDir: p.Chart.Upstream,
+		}
+		for _, additionalChart := range p.AdditionalCharts {
+			if additionalChart.Upstream != nil {
+				upstreams[additionalChart.WorkingDir] = *additionalChart.Upstream
+			}
+		}
+		workingDirs := make([]string, 0, len(upstreams))
+		for workingDir := range upstreams {
+			workingDirs = append(workingDirs, workingDir)
+		}
+		sort.Strings(workingDirs)
+		for _, workingDir := range workingDirs {
+			u := puller.Unwrap(upstreams[workingDir])
+			update, err := findUpdate(ctx, client, tempFs, u)
+			if err != nil {
+				return nil, fmt.Errorf("Encountered error while checking upstream %s of package %s for updates: %s", u, p.Name, err)
+			}
+			if update == nil {
+				logrus.Debugf("Upstream %s of %s (%s) is up to date", u, p.Name, workingDir)
+				continue
+			}
+			update.Package = p.Name
+			update.WorkingDir = workingDir
+			update.Upstream = fmt.Sprintf("%s", u)
+			updates = append(updates, *update)
+		}
+	}
+	// Updates that contain security fixes should be prioritized above routine updates
+	sort.SliceStable(updates, func(i, j int) bool {
+		return len(updates[i].SecurityFixes) > 0 && len(updates[j].SecurityFixes) == 0
+	})
+	return updates, nil
+}
+
+// findUpdate returns an Update if there is a newer version of the upstream or nil if it is up to date or cannot be updated automatically
+func findUpdate(ctx context.Context, client *github.Client, tempFs billy.Filesystem, u puller.Puller) (*Update, error) {
+	switch u := u.(type) {
+	case puller.HelmRepository:
+		versions, err := u.GetVersions(tempFs)
+		if err != nil {
+			return nil, err
+		}
+		latest, ok := getLatestVersion(versions, u.Version)
+		if !ok {
+			return nil, nil
+		}
+		return &Update{Field: VersionField, Current: u.Version, Latest: latest, Pin: latest}, nil
+	case puller.GithubRepository:
+		tags, err := u.GetTags()
+		if err != nil {
+			return nil, err
+		}
+		releases, err := getReleaseTags(ctx, client, u, tags)
+		if err != nil {
+			return nil, err
+		}
+		if len(releases) > 0 {
+			// Only tags that were published as releases are considered if the repository publishes releases
+			tags = releases
+		}
+		update := findGitUpdate(tags, u.Commit, u.Tag)
+		if update != nil {
+			var current string
+			if u.Tag != nil {
+				current = *u.Tag
+			} else {
+				current, _ = getPinnedVersion(tags, *u.Commit)
+			}
+			addSecurityFixes(ctx, client, u, update, current, time.Time{})
+			return update, nil
+		}
+		if u.Commit == nil || isTagged(tags, *u.Commit) {
+			return nil, nil
+		}
+		// The pinned commit is not tagged, so the newest release is only an update if it comes after the pinned commit
+		latest, ok := getLatestVersion(getKeys(tags), "")
+		if !ok {
+			return nil, nil
+		}
+		comparison, _, err := client.Repositories.CompareCommits(ctx, u.GetOwner(), u.GetName(), *u.Commit, tags[latest])
+		if err != nil {
+			return nil, fmt.Errorf("Unable to compare commit %s with %s: %s", *u.Commit, latest, err)
+		}
+		if comparison.GetStatus() != "ahead" {
+			return nil, nil
+		}
+		update = &Update{Field: CommitField, Current: *u.Commit, Latest: latest, Pin: tags[latest]}
+		addSecurityFixes(ctx, client, u, update, "", comparison.GetBaseCommit().GetCommit().GetCommitter().GetDate())
+		return update, nil
+	case puller.GitRepository:
+		tags, err := u.GetTags()
+		if err != nil {
+			return nil, err
+		}
+		update := findGitUpdate(tags, u.Commit, u.Tag)
+		if update == nil && u.Commit != nil {
+			logrus.Debugf("Skipping update check for %s since the pinned commit is not tagged", u)
+		}
+		return update, nil
+	default:
+		logrus.Debugf("Skipping update check for %s since it is not a Git repository or a Helm repository", u)
+		return nil, nil
+	}
+}
+
+// findGitUpdate returns an Update if there is a tag newer than the pinned tag or than the newest tag that points to the pinned commit
+func findGitUpdate(tags map[string]string, commit, tag *string) *Update {
+	if tag != nil {
+		latest, ok := getLatestVersion(getKeys(tags), *tag)
+		if !ok {
+			return nil
+		}
+		return &Update{Field: TagField, Current: *tag, Latest: latest, Pin: latest}
+	}
+	if commit == nil {
+		return nil
+	}
+	current, ok := getPinnedVersion(tags, *commit)
+	if !ok {
+		return nil
+	}
+	latest, ok := getLatestVersion(getKeys(tags), current)
+	if !ok {
+		return nil
+	}
+	return &Update{Field: CommitField, Current: *commit, Latest: latest, Pin: tags[latest]}
+}
+
+// getPinnedVersion returns the newest of the tags that point to the commit
+func getPinnedVersion(tags map[string]string, commit string) (string, bool) {
+	var pinnedTags []string
+	for t, c := range tags {
+		if c == commit {
+			pinnedTags = append(pinnedTags, t)
+		}
+	}
+	return getLatestVersion(pinnedTags, "")
+}
+
+// addSecurityFixes adds the security fixes released by the Github repository in the versions that the update moves past from current to the update
+// If current is empty, the upstream is pinned to a commit that is not tagged and was made at since
+// Failing to look up security fixes does not prevent the update from being reported, so any errors are only logged
+func addSecurityFixes(ctx context.Context, client *github.Client, r puller.GithubRepository, update *Update, current string, since time.Time) {
+	bump, ok := newBumpRange(current, update.Latest, since)
+	if !ok {
+		return
+	}
+	securityFixes, err := findSecurityFixes(ctx, client, r, bump)
+	if err != nil {
+		logrus.Warnf("Unable to check whether updating %s to %s contains security fixes: %s", r, update.Latest, err)
+		return
+	}
+	update.SecurityFixes = securityFixes
+}
+
+// isTagged returns whether any of the tags point to the commit
+func isTagged(tags map[string]string, commit string) bool {
+	for _, c := range tags {
+		if c == commit {
+			return true
+		}
+	}
+	return false
+}
+
+// getReleaseTags returns the commit of each tag that was published as a release that is neither a draft nor a pre-release
+func getReleaseTags(ctx context.Context, client *github.Client, r puller.GithubRepository, tags map[string]string) (map[string]string, error) {
+	releaseTags := make(map[string]string)
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		releases, resp, err := client.Repositories.ListReleases(ctx, r.GetOwner(), r.GetName(), opts)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to list releases of %s/%s: %s", r.GetOwner(), r.GetName(), err)
+		}
+		for _, release := range releases {
+			if release.GetDraft() || release.GetPrerelease() {
+				continue
+			}
+			if commit, ok := tags[release.GetTagName()]; ok {
+				releaseTags[release.GetTagName()] = commit
+			}
+		}
+		if resp.NextPage == 0 {
+			return releaseTags, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// getLatestVersion returns the newest version within versions that is newer than current and shares its prefix, if any
+// If current is empty, the newest version is returned regardless of its prefix
+func getLatestVersion(versions []string, current string) (string, bool) {
+	var currentPrefix string
+	var currentVersion *semver.Version
+	if len(current) > 0 {
+		var ok bool
+		currentPrefix, currentVersion, ok = parseVersion(current)
+		if !ok {
+			return "", false
+		}
+	}
+	var latest string
+	var latestVersion *semver.Version
+	for _, v := range versions {
+		prefix, version, ok := parseVersion(v)
+		if !ok || len(version.Prerelease()) > 0 {
+			continue
+		}
+		if currentVersion != nil && (prefix != currentPrefix || !version.GreaterThan(currentVersion)) {
+			continue
+		}
+		if latestVersion == nil || version.GreaterThan(latestVersion) || (version.Equal(latestVersion) && v < latest) {
+			latest, latestVersion = v, version
+		}
+	}
+	return latest, latestVersion != nil
+}
+
+// parseVersion splits a tag or chart version into its prefix and its semantic version
+func parseVersion(v string) (string, *semver.Version, bool) {
+	match := tagVersionRegex.FindStringSubmatch(v)
+	if match == nil {
+		return "", nil, false
+	}
+	version, err := semver.NewVersion(match[2])
+	if err != nil {
+		return "", nil, false
+	}
+	return match[1], version, true
+}
+
+// getKeys returns the keys of the map
+func getKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// ApplyUpdate rewrites the options of the package in the file it is defined in to pin the upstream to the newer version without reformatting the rest of the file
+func ApplyUpdate(rootFs billy.Filesystem, u Update) error {
+	return charts.EditPackageOptions(rootFs, u.Package, func(packageOptionsBytes []byte, indent string) ([]byte, error) {
+		fieldRegex := regexp.MustCompile(fmt.Sprintf(`(?m)^([ \t]*(?:-[ \t]+)?%s:[ \t]*["']?)%s(["']?[ \t]*(?:#.*)?)$`, regexp.QuoteMeta(u.Field), regexp.QuoteMeta(u.Current)))
+		matches := fieldRegex.FindAllSubmatchIndex(packageOptionsBytes, -1)
+		if len(matches) != 1 {
+			return nil, fmt.Errorf("Expected to find %s: %s exactly once, found it %d times", u.Field, u.Current, len(matches))
+		}
+		// The value lies between the end of the first group and the start of the second group
+		var buf bytes.Buffer
+		buf.Write(packageOptionsBytes[:matches[0][3]])
+		buf.WriteString(u.Pin)
+		buf.Write(packageOptionsBytes[matches[0][4]:])
+		return buf.Bytes(), nil
+	})
+}